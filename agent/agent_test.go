@@ -1,6 +1,10 @@
 package agent
 
-import "testing"
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
 
 func TestRuntimeExecutesTypedSafePlan(t *testing.T) {
 	executed := false
@@ -608,12 +612,171 @@ func TestEnumStrings(t *testing.T) {
 }
 
 func TestMessageAgentHelpStringMatchesAgentCommands(t *testing.T) {
-	want := "Agent commands:\n  agent show files    - Show files managed by the agent\n  agent show history  - Show command history stored by the agent\n  agent show version  - Show OS version through the agent\n  agent show ticks    - Show PIT ticks through the agent\n  agent show memorymap - Show memory map through the agent\n  agent read <name>   - Read a file through the agent\n  agent stat <name>   - Show file metadata through the agent\n  agent delete <name> confirm - Delete a file through the agent\n  agent mode [mode]   - Show or switch agent mode\n  agent help          - Show agent commands"
+	want := "Agent commands:\n  agent show files    - Show files managed by the agent\n  agent show history  - Show command history stored by the agent\n  agent show version  - Show OS version through the agent\n  agent show ticks    - Show PIT ticks through the agent\n  agent show memorymap - Show memory map through the agent\n  agent read <name>   - Read a file through the agent\n  agent stat <name>   - Show file metadata through the agent\n  agent delete <name> confirm - Delete a file through the agent\n  agent mode [mode]   - Show or switch agent mode\n  agent ask <request> - Plan a request through the LLM bridge\n  agent help          - Show agent commands"
 	if got := MessageAgentHelp.String(); got != want {
 		t.Fatalf("MessageAgentHelp.String() = %q, expected %q", got, want)
 	}
 }
 
+func TestEncodeBridgeRequestFollowsProtocol(t *testing.T) {
+	var buf [BridgeBufferSize]byte
+	context := Context{LastIntent: IntentReadFile}
+	n, ok := EncodeBridgeRequest(buf[:], "read \"my\" notes\n\x01", &context, 3)
+	if !ok || buf[n-1] != '\n' {
+		t.Fatalf("EncodeBridgeRequest = %d, %v", n, ok)
+	}
+	var request struct {
+		Input   string
+		Context struct {
+			LastIntent   string
+			RequestCount int
+		}
+		AllowedActions []string
+	}
+	if err := json.Unmarshal(buf[:n], &request); err != nil {
+		t.Fatalf("request is not JSON: %v\n%s", err, buf[:n])
+	}
+	if request.Input != "read \"my\" notes\n\x01" || request.Context.LastIntent != "read_file" || request.Context.RequestCount != 3 {
+		t.Fatalf("unexpected request: %+v", request)
+	}
+	want := "list_files read_file stat_file delete_file show_history show_version show_ticks show_memory_map"
+	if got := strings.Join(request.AllowedActions, " "); got != want {
+		t.Fatalf("allowedActions = %q, want %q", got, want)
+	}
+
+	if _, ok := EncodeBridgeRequest(buf[:32], "show files", nil, 1); ok {
+		t.Fatalf("request should not fit in 32 bytes")
+	}
+}
+
+func TestParseBridgeResponseBuildsLLMPlan(t *testing.T) {
+	result := ParseBridgeResponse([]byte(`{"intent":"read_file","action":"read_file","args":["notes"],` +
+		`"risk":"safe","explanation":"Matched read request.","extra":{"n":[1,true,null]}}`))
+	if !result.OK {
+		t.Fatalf("expected a plan, got %q", result.Reason)
+	}
+	plan := result.Plan
+	action := plan.Actions[0]
+	if plan.Planner != PlannerModeLLM || plan.Intent != IntentReadFile || plan.ActionCount != 1 ||
+		action.Kind != ActionReadFile || action.Risk != RiskSafe || string(action.Target[:action.TargetLen]) != "notes" {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+
+	result = ParseBridgeResponse([]byte(`{"intent":"unknown","action":"unknown","args":[],"risk":"safe"}`))
+	if result.OK || result.Reason != MessageBridgeNoAction {
+		t.Fatalf("unknown action should be a planner failure, got %+v", result)
+	}
+}
+
+func TestParseBridgeResponseRejectsInvalidResponses(t *testing.T) {
+	cases := []struct {
+		name     string
+		response string
+	}{
+		{name: "not json", response: `show files`},
+		{name: "missing risk", response: `{"intent":"list_files","action":"list_files"}`},
+		{name: "wrong type", response: `{"intent":"list_files","action":["list_files"],"risk":"safe"}`},
+		{name: "unknown intent", response: `{"intent":"format_disk","action":"list_files","risk":"safe"}`},
+		{name: "not a bridge action", response: `{"intent":"write_file","action":"write_file","args":["x"],"risk":"risky"}`},
+		{name: "set mode", response: `{"intent":"set_mode","action":"set_mode","args":["llm"],"risk":"safe"}`},
+		{name: "bad risk", response: `{"intent":"list_files","action":"list_files","risk":"low"}`},
+		{name: "two args", response: `{"intent":"read_file","action":"read_file","args":["a","b"],"risk":"safe"}`},
+		{name: "arg for no-target action", response: `{"intent":"list_files","action":"list_files","args":["a"],"risk":"safe"}`},
+		{name: "long arg", response: `{"intent":"read_file","action":"read_file","args":["abcdefghijklmnopq"],"risk":"safe"}`},
+		{name: "command field", response: `{"intent":"list_files","action":"list_files","risk":"safe","command":"rm x"}`},
+		{name: "nested exec", response: `{"intent":"list_files","action":"list_files","risk":"safe","meta":{"exec":"ls"}}`},
+		{name: "trailing data", response: `{"intent":"list_files","action":"list_files","risk":"safe"} {}`},
+		{name: "unterminated", response: `{"intent":"list_files","action":"list_files","risk":"safe"`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := ParseBridgeResponse([]byte(tc.response))
+			if result.OK || result.Reason != MessageBridgeResponseInvalid {
+				t.Fatalf("expected rejection, got %+v", result)
+			}
+		})
+	}
+}
+
+// fakeStream is the host end of a StreamBridge: it answers each request
+// line with reply and advances a fake clock on every read
+type fakeStream struct {
+	sent    []byte
+	pending []byte
+	reply   string
+	now     uint64
+}
+
+func (s *fakeStream) bridge() *StreamBridge {
+	return &StreamBridge{
+		Send: func(c byte) {
+			s.sent = append(s.sent, c)
+			if c == '\n' && s.reply != "" {
+				s.pending = append(s.pending, s.reply...)
+			}
+		},
+		Receive: func() (byte, bool) {
+			if len(s.pending) == 0 {
+				return 0, false
+			}
+			c := s.pending[0]
+			s.pending = s.pending[1:]
+			return c, true
+		},
+		Now:       func() uint64 { s.now += 1000000; return s.now },
+		TimeoutNs: 50000000,
+	}
+}
+
+func TestStreamBridgeExchangesOneLine(t *testing.T) {
+	stream := &fakeStream{
+		pending: []byte("stale\n"),
+		reply:   "\r\n{\"intent\":\"list_files\",\"action\":\"list_files\",\"args\":[],\"risk\":\"safe\"}\r\n",
+	}
+	executed := false
+	runtime := NewDeterministicAgent(AllowedActionExecutor{
+		ListFiles: func(action Action, context *Context) ActionResult {
+			executed = true
+			return ActionResult{OK: true, Message: MessageFilesListed}
+		},
+	})
+	var context Context
+
+	response := runtime.RunLLM(LLMPlanner{Bridge: stream.bridge()}, "show me the files", &context)
+	if !response.Result.OK || response.Result.Message != MessageFilesListed || !executed {
+		t.Fatalf("unexpected response: %+v", response.Result)
+	}
+	if response.Trace[0].Detail != TraceDetailLLM || context.LastIntent != IntentListFiles {
+		t.Fatalf("plan was not run as an LLM plan: %+v", response.Trace[0])
+	}
+	if !strings.HasPrefix(string(stream.sent), `{"input":"show me the files"`) || strings.Count(string(stream.sent), "\n") != 1 {
+		t.Fatalf("unexpected request line: %q", stream.sent)
+	}
+}
+
+func TestStreamBridgeTimesOut(t *testing.T) {
+	stream := &fakeStream{}
+	idled := 0
+	bridge := stream.bridge()
+	bridge.Idle = func() { idled++ }
+
+	result := bridge.Plan("show files", nil)
+	if result.OK || result.Reason != MessageBridgeTimeout {
+		t.Fatalf("expected a timeout, got %+v", result)
+	}
+	if idled == 0 {
+		t.Fatalf("StreamBridge never idled while waiting")
+	}
+
+	stream.reply = strings.Repeat("x", BridgeBufferSize+1) + "\n"
+	if result := bridge.Plan("show files", nil); result.Reason != MessageBridgeResponseInvalid {
+		t.Fatalf("oversized response = %+v", result)
+	}
+	if result := (*StreamBridge)(nil).Plan("show files", nil); result.Reason != MessageLLMBridgeNotConfigured {
+		t.Fatalf("nil bridge = %+v", result)
+	}
+}
+
 type fakeBridge struct {
 	plan Plan
 }
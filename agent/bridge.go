@@ -0,0 +1,525 @@
+package agent
+
+// The host bridge protocol (docs/v0.5.0/llm_bridge_protocol.md) carried as
+// newline-delimited JSON: the kernel writes one request object on a line and
+// reads one response object back. Everything here works on fixed buffers so
+// it runs in the freestanding build.
+
+const (
+	// BridgeBufferSize bounds one encoded request and one response line
+	BridgeBufferSize = 1024
+	// DefaultBridgeTimeoutNs is how long StreamBridge waits for a response
+	// when TimeoutNs is zero
+	DefaultBridgeTimeoutNs = 10000000000
+
+	bridgeMaxDepth = 8
+	bridgeKeyLen   = 16
+)
+
+// planNames spells IntentKind and ActionKind values on the wire; the two
+// enums share their order
+var planNames = [...]string{
+	stringUnknown,
+	stringListFiles,
+	stringReadFile,
+	stringWriteFile,
+	stringDeleteFile,
+	stringStatFile,
+	stringShowHelp,
+	stringShowHistory,
+	stringShowVersion,
+	stringShowTicks,
+	stringShowMemoryMap,
+	stringSetMode,
+}
+
+// bridgeActions is the allowedActions list of every request: the protocol's
+// bridge actions that an LLM plan may carry
+var bridgeActions = [...]ActionKind{
+	ActionListFiles,
+	ActionReadFile,
+	ActionStatFile,
+	ActionDeleteFile,
+	ActionShowHistory,
+	ActionShowVersion,
+	ActionShowTicks,
+	ActionShowMemoryMap,
+}
+
+// rawExecutionFields may not appear anywhere in a response
+var rawExecutionFields = [...]string{"command", "shell", "argv", "script", "exec"}
+
+var (
+	bridgeRequest  [BridgeBufferSize]byte
+	bridgeResponse [BridgeBufferSize]byte
+)
+
+// StreamBridge is a BridgeClient over a byte stream such as a serial port.
+// Send transmits one byte, Receive returns a pending byte without blocking,
+// Now is a nanosecond clock and Idle, when set, waits for the next
+// interrupt while nothing has arrived.
+type StreamBridge struct {
+	Send      func(c byte)
+	Receive   func() (byte, bool)
+	Now       func() uint64
+	Idle      func()
+	TimeoutNs uint64
+
+	requests int
+}
+
+func (b *StreamBridge) Plan(input string, context *Context) PlanningResult {
+	if b == nil || b.Send == nil || b.Receive == nil || b.Now == nil {
+		return PlanningResult{OK: false, Reason: MessageLLMBridgeNotConfigured}
+	}
+	b.requests++
+	n, ok := EncodeBridgeRequest(bridgeRequest[:], input, context, b.requests)
+	if !ok {
+		return PlanningResult{OK: false, Reason: MessageActionDataInvalid}
+	}
+
+	// Whatever is pending belongs to an exchange that already timed out
+	for {
+		if _, ok := b.Receive(); !ok {
+			break
+		}
+	}
+	for i := 0; i < n; i++ {
+		b.Send(bridgeRequest[i])
+	}
+
+	timeout := b.TimeoutNs
+	if timeout == 0 {
+		timeout = DefaultBridgeTimeoutNs
+	}
+	deadline := b.Now() + timeout
+	got := 0
+	overflow := false
+	for {
+		c, ok := b.Receive()
+		if !ok {
+			if b.Now() >= deadline {
+				return PlanningResult{OK: false, Reason: MessageBridgeTimeout}
+			}
+			if b.Idle != nil {
+				b.Idle()
+			}
+			continue
+		}
+		if c == '\r' || c == '\n' && got == 0 {
+			continue
+		}
+		if c == '\n' {
+			break
+		}
+		if got < len(bridgeResponse) {
+			bridgeResponse[got] = c
+			got++
+		} else {
+			overflow = true
+		}
+	}
+	if overflow {
+		return PlanningResult{OK: false, Reason: MessageBridgeResponseInvalid}
+	}
+	return ParseBridgeResponse(bridgeResponse[:got])
+}
+
+// EncodeBridgeRequest writes the request for input, newline included, into
+// buf. requestCount is the session's running count of bridge requests.
+// It returns false when the request does not fit.
+func EncodeBridgeRequest(buf []byte, input string, context *Context, requestCount int) (int, bool) {
+	w := jsonWriter{buf: buf}
+	w.raw(`{"input":`)
+	w.str(input)
+	w.raw(`,"context":{`)
+	if context != nil && context.LastIntent != IntentUnknown && int(context.LastIntent) < len(planNames) {
+		w.raw(`"lastIntent":`)
+		w.str(planNames[context.LastIntent])
+		w.put(',')
+	}
+	w.raw(`"requestCount":`)
+	w.uint(requestCount)
+	w.raw(`},"allowedActions":[`)
+	for i := 0; i < len(bridgeActions); i++ {
+		if i > 0 {
+			w.put(',')
+		}
+		w.str(planNames[bridgeActions[i]])
+	}
+	w.raw("]}\n")
+	return w.n, !w.overflow
+}
+
+// ParseBridgeResponse checks one response object against the protocol and
+// turns it into an LLM plan. A response the protocol rejects, and one that
+// picks the unknown action, becomes a planner failure; nothing in it is
+// executed.
+func ParseBridgeResponse(data []byte) PlanningResult {
+	invalid := PlanningResult{OK: false, Reason: MessageBridgeResponseInvalid}
+	r := jsonReader{data: data}
+	var key [bridgeKeyLen]byte
+	var name [bridgeKeyLen]byte
+	var target [MaxNameLen]byte
+	intent, action, risk := -1, -1, -1
+	targetLen, argCount := 0, 0
+
+	if !r.next('{') {
+		return invalid
+	}
+	for first := true; !r.next('}'); first = false {
+		if !first && !r.next(',') {
+			return invalid
+		}
+		keyLen, ok := r.str(key[:])
+		if !ok || !r.next(':') {
+			return invalid
+		}
+		if keyLen > len(key) {
+			// Longer than any field this parser knows
+			if !r.skip(0) {
+				return invalid
+			}
+			continue
+		}
+		k := key[:keyLen]
+		switch {
+		case isRawExecutionField(k):
+			return invalid
+		case sameName(k, "intent"), sameName(k, "action"):
+			n, ok := r.str(name[:])
+			if !ok || n > len(name) {
+				return invalid
+			}
+			kind := lookupName(name[:n])
+			if kind < 0 {
+				return invalid
+			}
+			if sameName(k, "intent") {
+				intent = kind
+			} else {
+				action = kind
+			}
+		case sameName(k, "risk"):
+			n, ok := r.str(name[:])
+			if !ok || n > len(name) {
+				return invalid
+			}
+			if sameName(name[:n], stringSafe) {
+				risk = int(RiskSafe)
+			} else if sameName(name[:n], stringRisky) {
+				risk = int(RiskRisky)
+			} else {
+				return invalid
+			}
+		case sameName(k, "args"):
+			if !r.next('[') {
+				return invalid
+			}
+			argCount = 0
+			for !r.next(']') {
+				if argCount > 0 && !r.next(',') {
+					return invalid
+				}
+				n, ok := r.str(target[:])
+				if !ok || n > len(target) || argCount > 0 {
+					return invalid
+				}
+				targetLen = n
+				argCount++
+			}
+		case sameName(k, "explanation"):
+			if _, ok := r.str(nil); !ok {
+				return invalid
+			}
+		default:
+			if !r.skip(0) {
+				return invalid
+			}
+		}
+	}
+	if !r.end() || intent < 0 || action < 0 || risk < 0 {
+		return invalid
+	}
+
+	kind := ActionKind(action)
+	if kind == ActionUnknown {
+		return PlanningResult{OK: false, Reason: MessageBridgeNoAction}
+	}
+	if !isBridgeAction(kind) || argCount > 0 && !actionRequiresTarget(kind) {
+		return invalid
+	}
+	plan := singleActionPlan(PlannerModeLLM, IntentKind(intent), kind, RiskLevel(risk))
+	plan.Actions[0].TargetLen = targetLen
+	for i := 0; i < targetLen; i++ {
+		plan.Actions[0].Target[i] = target[i]
+	}
+	return PlanningResult{OK: true, Plan: plan}
+}
+
+func isBridgeAction(kind ActionKind) bool {
+	for i := 0; i < len(bridgeActions); i++ {
+		if bridgeActions[i] == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func isRawExecutionField(key []byte) bool {
+	for i := 0; i < len(rawExecutionFields); i++ {
+		if sameName(key, rawExecutionFields[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupName returns the IntentKind/ActionKind spelled name, or -1
+func lookupName(name []byte) int {
+	for i := 0; i < len(planNames); i++ {
+		if sameName(name, planNames[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func sameName(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if b[i] != s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type jsonWriter struct {
+	buf      []byte
+	n        int
+	overflow bool
+}
+
+func (w *jsonWriter) put(c byte) {
+	if w.n >= len(w.buf) {
+		w.overflow = true
+		return
+	}
+	w.buf[w.n] = c
+	w.n++
+}
+
+func (w *jsonWriter) raw(s string) {
+	for i := 0; i < len(s); i++ {
+		w.put(s[i])
+	}
+}
+
+// str writes s as a quoted JSON string, escaping quotes, backslashes and
+// control characters
+func (w *jsonWriter) str(s string) {
+	const hex = "0123456789abcdef"
+	w.put('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			w.put('\\')
+			w.put(c)
+		case c == '\n':
+			w.raw(`\n`)
+		case c == '\t':
+			w.raw(`\t`)
+		case c < 0x20 || c == 0x7F:
+			w.raw(`\u00`)
+			w.put(hex[c>>4])
+			w.put(hex[c&0xF])
+		default:
+			w.put(c)
+		}
+	}
+	w.put('"')
+}
+
+func (w *jsonWriter) uint(v int) {
+	var digits [20]byte
+	n := 0
+	for {
+		digits[n] = byte('0' + v%10)
+		n++
+		v /= 10
+		if v == 0 {
+			break
+		}
+	}
+	for n > 0 {
+		n--
+		w.put(digits[n])
+	}
+}
+
+type jsonReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *jsonReader) space() {
+	for r.pos < len(r.data) {
+		switch r.data[r.pos] {
+		case ' ', '\t', '\r', '\n':
+			r.pos++
+		default:
+			return
+		}
+	}
+}
+
+// next consumes c, after any whitespace, when it comes next
+func (r *jsonReader) next(c byte) bool {
+	r.space()
+	if r.pos < len(r.data) && r.data[r.pos] == c {
+		r.pos++
+		return true
+	}
+	return false
+}
+
+// end reports whether only whitespace is left
+func (r *jsonReader) end() bool {
+	r.space()
+	return r.pos == len(r.data)
+}
+
+// str decodes a JSON string into dst and returns its full length; bytes
+// past the end of dst are dropped, so callers compare the length against
+// len(dst). Escapes outside ASCII decode to '?'.
+func (r *jsonReader) str(dst []byte) (int, bool) {
+	if !r.next('"') {
+		return 0, false
+	}
+	n := 0
+	for r.pos < len(r.data) {
+		c := r.data[r.pos]
+		r.pos++
+		switch {
+		case c == '"':
+			return n, true
+		case c < 0x20:
+			return 0, false
+		case c == '\\':
+			if r.pos >= len(r.data) {
+				return 0, false
+			}
+			e := r.data[r.pos]
+			r.pos++
+			switch e {
+			case '"', '\\', '/':
+				c = e
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'u':
+				v, ok := r.hex4()
+				if !ok {
+					return 0, false
+				}
+				c = '?'
+				if v < 0x80 {
+					c = byte(v)
+				}
+			default:
+				return 0, false
+			}
+		}
+		if n < len(dst) {
+			dst[n] = c
+		}
+		n++
+	}
+	return 0, false
+}
+
+func (r *jsonReader) hex4() (int, bool) {
+	if r.pos+4 > len(r.data) {
+		return 0, false
+	}
+	v := 0
+	for i := 0; i < 4; i++ {
+		c := r.data[r.pos+i]
+		switch {
+		case c >= '0' && c <= '9':
+			v = v<<4 | int(c-'0')
+		case c >= 'a' && c <= 'f':
+			v = v<<4 | int(c-'a'+10)
+		case c >= 'A' && c <= 'F':
+			v = v<<4 | int(c-'A'+10)
+		default:
+			return 0, false
+		}
+	}
+	r.pos += 4
+	return v, true
+}
+
+// skip passes over any value, still refusing raw execution fields in
+// nested objects
+func (r *jsonReader) skip(depth int) bool {
+	if depth > bridgeMaxDepth {
+		return false
+	}
+	r.space()
+	if r.pos >= len(r.data) {
+		return false
+	}
+	switch r.data[r.pos] {
+	case '"':
+		_, ok := r.str(nil)
+		return ok
+	case '{':
+		r.pos++
+		var key [bridgeKeyLen]byte
+		for first := true; !r.next('}'); first = false {
+			if !first && !r.next(',') {
+				return false
+			}
+			n, ok := r.str(key[:])
+			if !ok || n <= len(key) && isRawExecutionField(key[:n]) {
+				return false
+			}
+			if !r.next(':') || !r.skip(depth+1) {
+				return false
+			}
+		}
+		return true
+	case '[':
+		r.pos++
+		for first := true; !r.next(']'); first = false {
+			if !first && !r.next(',') {
+				return false
+			}
+			if !r.skip(depth + 1) {
+				return false
+			}
+		}
+		return true
+	}
+	start := r.pos
+	for r.pos < len(r.data) {
+		c := r.data[r.pos]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c == '-' || c == '+' || c == '.' || c == 'E') {
+			break
+		}
+		r.pos++
+	}
+	return r.pos > start
+}
@@ -1,11 +1,13 @@
-//go:build !gccgo
-
 package agent
 
+// BridgeClient asks a host-side planner for a typed plan; StreamBridge is
+// the kernel's implementation
 type BridgeClient interface {
 	Plan(input string, context *Context) PlanningResult
 }
 
+// LLMPlanner plans through a bridge and marks what it returns as an LLM
+// plan, which the runtime validates more strictly
 type LLMPlanner struct {
 	Bridge BridgeClient
 }
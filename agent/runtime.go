@@ -70,6 +70,23 @@ func (r *Runtime) RunActionMessage(kind ActionKind, intent IntentKind, risk Risk
 	return result.Message
 }
 
+// RunLLM asks planner for a plan of input and runs it like any other plan:
+// validation, the safety gate, then the executor
+func (r Runtime) RunLLM(planner LLMPlanner, input string, context *Context) Response {
+	return r.runPlanning(planner.Plan(input, context), context)
+}
+
+func (r Runtime) runPlanning(planning PlanningResult, context *Context) Response {
+	if !planning.OK {
+		var response Response
+		setResponseResult(&response, false, planning.Reason)
+		setSafety(&response, SafetyRejected, MessagePlannerFailed)
+		response.AddTrace(TracePlanner, traceFromMessage(planning.Reason))
+		return response
+	}
+	return r.runPlan(planning.Plan, context)
+}
+
 func (r Runtime) runPlan(plan Plan, context *Context) Response {
 	var response Response
 	response.AddTrace(TracePlanner, plannerTrace(plan.Planner))
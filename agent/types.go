@@ -8,6 +8,28 @@ const (
 	MaxRecentItems  = 4
 )
 
+const (
+	stringUnknown              = "unknown"
+	stringDeterministic        = "deterministic"
+	stringLLM                  = "llm"
+	stringListFiles            = "list_files"
+	stringReadFile             = "read_file"
+	stringWriteFile            = "write_file"
+	stringDeleteFile           = "delete_file"
+	stringStatFile             = "stat_file"
+	stringShowHelp             = "show_help"
+	stringShowHistory          = "show_history"
+	stringShowVersion          = "show_version"
+	stringShowTicks            = "show_ticks"
+	stringShowMemoryMap        = "show_memory_map"
+	stringSetMode              = "set_mode"
+	stringAllowed              = "allowed"
+	stringConfirmationRequired = "confirmation_required"
+	stringRejected             = "rejected"
+	stringSafe                 = "safe"
+	stringRisky                = "risky"
+)
+
 type PlannerMode uint8
 
 const (
@@ -134,6 +156,9 @@ const (
 	MessageLLMBridgeNotConfigured
 	MessageLLMBridgeFailed
 	MessageBridgeTimeout
+	MessageLLMMode
+	MessageBridgeResponseInvalid
+	MessageBridgeNoAction
 )
 
 type TraceKind uint8
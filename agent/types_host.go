@@ -2,26 +2,6 @@
 
 package agent
 
-const (
-	stringUnknown              = "unknown"
-	stringDeterministic        = "deterministic"
-	stringLLM                  = "llm"
-	stringListFiles            = "list_files"
-	stringReadFile             = "read_file"
-	stringWriteFile            = "write_file"
-	stringDeleteFile           = "delete_file"
-	stringStatFile             = "stat_file"
-	stringShowHelp             = "show_help"
-	stringShowHistory          = "show_history"
-	stringShowVersion          = "show_version"
-	stringShowTicks            = "show_ticks"
-	stringShowMemoryMap        = "show_memory_map"
-	stringSetMode              = "set_mode"
-	stringAllowed              = "allowed"
-	stringConfirmationRequired = "confirmation_required"
-	stringRejected             = "rejected"
-)
-
 func (m PlannerMode) String() string {
 	switch m {
 	case PlannerModeDeterministic:
@@ -94,9 +74,9 @@ func (k ActionKind) String() string {
 func (r RiskLevel) String() string {
 	switch r {
 	case RiskRisky:
-		return "risky"
+		return stringRisky
 	default:
-		return "safe"
+		return stringSafe
 	}
 }
 
@@ -160,7 +140,7 @@ func (m MessageKind) String() string {
 	case MessageFileNotFound:
 		return "agent: file not found"
 	case MessageAgentHelp:
-		return "Agent commands:\n  agent show files    - Show files managed by the agent\n  agent show history  - Show command history stored by the agent\n  agent show version  - Show OS version through the agent\n  agent show ticks    - Show PIT ticks through the agent\n  agent show memorymap - Show memory map through the agent\n  agent read <name>   - Read a file through the agent\n  agent stat <name>   - Show file metadata through the agent\n  agent delete <name> confirm - Delete a file through the agent\n  agent mode [mode]   - Show or switch agent mode\n  agent help          - Show agent commands"
+		return "Agent commands:\n  agent show files    - Show files managed by the agent\n  agent show history  - Show command history stored by the agent\n  agent show version  - Show OS version through the agent\n  agent show ticks    - Show PIT ticks through the agent\n  agent show memorymap - Show memory map through the agent\n  agent read <name>   - Read a file through the agent\n  agent stat <name>   - Show file metadata through the agent\n  agent delete <name> confirm - Delete a file through the agent\n  agent mode [mode]   - Show or switch agent mode\n  agent ask <request> - Plan a request through the LLM bridge\n  agent help          - Show agent commands"
 	case MessageHistoryListed:
 		return "agent: history listed"
 	case MessageVersionShown:
@@ -189,6 +169,12 @@ func (m MessageKind) String() string {
 		return "agent: llm bridge failed"
 	case MessageBridgeTimeout:
 		return "bridge timeout"
+	case MessageLLMMode:
+		return "agent: llm mode"
+	case MessageBridgeResponseInvalid:
+		return "agent: bridge response rejected"
+	case MessageBridgeNoAction:
+		return "agent: no allowed action matched"
 	default:
 		return ""
 	}
@@ -0,0 +1,161 @@
+/* asm/ap_trampoline.s
+ * Application processor entry, copied to TRAMPOLINE_BASE by kernel/smp.
+ *
+ * Flow:
+ * - The BSP sends INIT-SIPI-SIPI with vector 0x08, so the AP starts here in
+ *   real mode at 0x0800:0000 (physical 0x8000).
+ * - Load a small GDT, enter protected mode, enable PAE, load the BSP's CR3,
+ *   set EFER.LME and turn on paging to reach long mode.
+ * - Switch to the per-AP stack from the parameter block and call
+ *   smp.apEntry(cpu). It never returns.
+ *
+ * The code is assembled into the kernel image but runs from the copy, so
+ * every absolute address is written as (label - ap_trampoline_start +
+ * TRAMPOLINE_BASE) to rebase it onto the copy.
+ */
+
+.set TRAMPOLINE_BASE, 0x8000
+
+.section .text
+.code16
+.global ap_trampoline_start
+ap_trampoline_start:
+	cli
+	cld
+	xorw %ax, %ax
+	movw %ax, %ds
+	movw %ax, %es
+	movw %ax, %ss
+
+	lgdtl (ap_gdt_desc - ap_trampoline_start + TRAMPOLINE_BASE)
+
+	movl %cr0, %eax
+	orl  $0x1, %eax            # PE
+	movl %eax, %cr0
+
+	ljmpl $0x18, $(ap_protected_mode - ap_trampoline_start + TRAMPOLINE_BASE)
+
+.code32
+ap_protected_mode:
+	movw $0x10, %ax
+	movw %ax, %ds
+	movw %ax, %es
+	movw %ax, %ss
+
+	movl %cr4, %eax
+	orl  $0x20, %eax           # PAE
+	movl %eax, %cr4
+
+	movl (ap_param_cr3 - ap_trampoline_start + TRAMPOLINE_BASE), %eax
+	movl %eax, %cr3
+
+	movl $0xC0000080, %ecx     # EFER
+	rdmsr
+	orl  $0x100, %eax          # LME
+	wrmsr
+
+	movl %cr0, %eax
+	orl  $0x80000000, %eax     # PG
+	movl %eax, %cr0
+
+	ljmp $0x08, $(ap_long_mode - ap_trampoline_start + TRAMPOLINE_BASE)
+
+.code64
+ap_long_mode:
+	movw $0x10, %ax
+	movw %ax, %ds
+	movw %ax, %es
+	movw %ax, %ss
+	movw %ax, %fs
+	movw %ax, %gs
+
+	movq (ap_param_stack - ap_trampoline_start + TRAMPOLINE_BASE), %rsp
+	movq (ap_param_cpu - ap_trampoline_start + TRAMPOLINE_BASE), %rdi
+	movq (ap_param_entry - ap_trampoline_start + TRAMPOLINE_BASE), %rax
+	subq $8, %rsp              # keep the ABI alignment at the call
+	call *%rax
+
+1:
+	cli
+	hlt
+	jmp 1b
+
+# Same selectors as the kernel GDT for 64-bit code (0x08) and data (0x10),
+# plus a 32-bit code segment (0x18) for the protected mode hop, so CS needs
+# no reload once the AP switches to its own GDT.
+.align 8
+ap_gdt:
+	.quad 0x0000000000000000
+	.quad 0x00AF9A000000FFFF   # 0x08: 64-bit kernel code
+	.quad 0x00CF92000000FFFF   # 0x10: kernel data
+	.quad 0x00CF9A000000FFFF   # 0x18: 32-bit code
+ap_gdt_end:
+
+ap_gdt_desc:
+	.word ap_gdt_end - ap_gdt - 1
+	.long (ap_gdt - ap_trampoline_start + TRAMPOLINE_BASE)
+
+# Parameter block, filled in by smp.startAP before each SIPI.
+# Field offsets must match the param* constants in kernel/smp/smp.go.
+.align 8
+.global ap_trampoline_params
+ap_trampoline_params:
+ap_param_cr3:
+	.quad 0
+ap_param_stack:
+	.quad 0
+ap_param_cpu:
+	.quad 0
+ap_param_entry:
+	.quad 0
+
+.global ap_trampoline_end
+ap_trampoline_end:
+
+# uintptr smp.trampolineStart()
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineStart
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineStart, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineStart:
+	leaq ap_trampoline_start(%rip), %rax
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineStart, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineStart
+
+# uintptr smp.trampolineEnd()
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineEnd
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineEnd, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineEnd:
+	leaq ap_trampoline_end(%rip), %rax
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineEnd, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineEnd
+
+# uintptr smp.trampolineParams()
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineParams
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineParams, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineParams:
+	leaq ap_trampoline_params(%rip), %rax
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineParams, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.trampolineParams
+
+# uintptr smp.apEntryAddr()
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.apEntryAddr
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.apEntryAddr, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.apEntryAddr:
+	leaq github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.apEntry(%rip), %rax
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.apEntryAddr, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.apEntryAddr
+
+# uint64 smp.readCR3()
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.readCR3
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.readCR3, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.readCR3:
+	movq %cr3, %rax
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.readCR3, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.readCR3
+
+# void smp.pause()
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.pause
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.pause, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.pause:
+	pause
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.pause, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1smp.pause
@@ -11,11 +11,20 @@ github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1scheduler.CpuSwitch:
 	pushq %rbx
 	pushq %rsi
 	pushq %rdi
+	# R12-R15 are callee-saved too; the task switched to may clobber them
+	pushq %r12
+	pushq %r13
+	pushq %r14
+	pushq %r15
 
 	# Args: old *uint64 in RDI, new uint64 in RSI.
 	movq %rsp, (%rdi)
 	movq %rsi, %rsp
 
+	popq %r15
+	popq %r14
+	popq %r13
+	popq %r12
 	popq %rdi
 	popq %rsi
 	popq %rbx
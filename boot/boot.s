@@ -19,6 +19,9 @@
 .set MULTIBOOT_MAGIC, 0xE85250D6
 .set MULTIBOOT_ARCH,  0
 .set USER_VA_BASE,    0x40000000
+# One user stack page per program that can run at once
+# (maxProcesses in kernel/process.go)
+.set USER_STACK_PAGES, 4
 
 .section .multiboot2
 .align 8
@@ -45,6 +48,16 @@ multiboot_header_start:
 	.long _start
 	.long 0
 
+# Framebuffer tag: prefer 1024x768x32 (a 128x48 console). Flagged
+# optional, so GRUB may leave the screen in VGA text mode.
+	.align 8
+	.word 5
+	.word 1
+	.long 20
+	.long 1024
+	.long 768
+	.long 32
+
 /* End tag */
 	.align 8
 	.word 0
@@ -90,8 +103,8 @@ pt_user:
 	.skip 4096
 
 .align 4096
-user_stack_page:
-	.skip 4096
+user_stack_pages:
+	.skip 4096 * USER_STACK_PAGES
 .global __bootstrap_end
 __bootstrap_end:
 
@@ -241,7 +254,7 @@ setup_long_mode:
 
 # User virtual window:
 #   0x40000000 -> user program page
-#   0x40001000 -> user stack page
+#   0x40001000 -> user stack pages, one per program
 # PDPT[1] already points to pd1 with U/S=1. Replace pd1[0] with a PT.
 	lea pd1, %edi
 	movl $pt_user, %eax
@@ -267,18 +280,26 @@ setup_long_mode:
 	cmpl $512, %ecx
 	jne .Lmap_4k_pt_user_identity
 
-	# Override first two pages as user mappings.
+	# Override the first pages as user mappings: the program page, then
+	# the stack pages.
 	movl $__user_program_page, %eax
 	andl $0xFFFFF000, %eax
 	orl $0x05, %eax            # present|user (read-only)
 	movl %eax, pt_user
 	movl $0, pt_user+4
 
-	movl $user_stack_page, %eax
-	andl $0xFFFFF000, %eax
+	lea pt_user+8, %edi
+	movl $user_stack_pages, %eax
 	orl $0x07, %eax            # present|rw|user
-	movl %eax, pt_user+8
-	movl $0, pt_user+12
+	xorl %ecx, %ecx
+.Lmap_user_stacks:
+	movl %eax, (%edi)
+	movl $0, 4(%edi)
+	addl $4096, %eax
+	addl $8, %edi
+	incl %ecx
+	cmpl $USER_STACK_PAGES, %ecx
+	jne .Lmap_user_stacks
 
 # Load PML4 and enable PAE.
 	movl $pml4, %eax
@@ -342,11 +342,12 @@ go_0kernel.Int80Stub:
 .type   go_0kernel.SyscallEntryStub, @function
 go_0kernel.SyscallEntryStub:
 	# SYSCALL does not switch stacks 
-	#Save the user return state into static scratch slots, then pivot onto the dedicated kernel syscall stack
+	#Save the user return state into static scratch slots, then pivot onto the kernel syscall stack
+	# of the running program, which the scheduler switches with the task
 	movq %rsp, __syscall_saved_user_rsp(%rip)
 	movq %rcx, __syscall_saved_user_rip(%rip)
 	movq %r11, __syscall_saved_user_rflags(%rip)
-	leaq __syscall_entry_stack_top(%rip), %rsp
+	movq __syscall_stack_top(%rip), %rsp
 
 	# Synthesize the same return frame shape used by the int 0x80 path so the
 	# dispatcher can share a single 64-bit trapframe layout
@@ -529,49 +530,98 @@ go_0kernel.Halt:
 	ret
 .size go_0kernel.Halt, . - go_0kernel.Halt
 
-.global go_0kernel.IRQ0Stub
-.type   go_0kernel.IRQ0Stub, @function
-go_0kernel.IRQ0Stub:
+# One stub per legacy IRQ line (vector 0x20 + line): each passes its line
+# number to go_0kernel.IRQHandler, which runs the handlers registered for it
+.macro IRQ_STUB line
+.global go_0kernel.IRQ\line\()Stub
+.type   go_0kernel.IRQ\line\()Stub, @function
+go_0kernel.IRQ\line\()Stub:
 	pushq $0            # dummy error code
 	PUSH_REGS
 	mov %rsp, %rbp
 	andq $-16, %rsp
 	subq $8, %rsp
-	call go_0kernel.IRQ0Handler
+	movl $\line, %edi
+	call go_0kernel.IRQHandler
 	mov %rbp, %rsp
 	POP_REGS
 	addq $8, %rsp      # pop dummy error code
 	iretq
-.size go_0kernel.IRQ0Stub, . - go_0kernel.IRQ0Stub
+.size go_0kernel.IRQ\line\()Stub, . - go_0kernel.IRQ\line\()Stub
+.endm
 
-.global go_0kernel.getIRQ0StubAddr
-.type   go_0kernel.getIRQ0StubAddr, @function
-go_0kernel.getIRQ0StubAddr:
-	leaq go_0kernel.IRQ0Stub(%rip), %rax
+IRQ_STUB 0
+IRQ_STUB 1
+IRQ_STUB 2
+IRQ_STUB 3
+IRQ_STUB 4
+IRQ_STUB 5
+IRQ_STUB 6
+IRQ_STUB 7
+IRQ_STUB 8
+IRQ_STUB 9
+IRQ_STUB 10
+IRQ_STUB 11
+IRQ_STUB 12
+IRQ_STUB 13
+IRQ_STUB 14
+IRQ_STUB 15
+
+# uint64 go_0kernel.getIRQStubAddr(line uint8)
+.global go_0kernel.getIRQStubAddr
+.type   go_0kernel.getIRQStubAddr, @function
+go_0kernel.getIRQStubAddr:
+	movzbl %dil, %edi
+	leaq irq_stub_table(%rip), %rax
+	movq (%rax,%rdi,8), %rax
 	ret
-.size go_0kernel.getIRQ0StubAddr, . - go_0kernel.getIRQ0StubAddr
+.size go_0kernel.getIRQStubAddr, . - go_0kernel.getIRQStubAddr
+
+.section .rodata
+.align 8
+irq_stub_table:
+	.quad go_0kernel.IRQ0Stub, go_0kernel.IRQ1Stub, go_0kernel.IRQ2Stub, go_0kernel.IRQ3Stub
+	.quad go_0kernel.IRQ4Stub, go_0kernel.IRQ5Stub, go_0kernel.IRQ6Stub, go_0kernel.IRQ7Stub
+	.quad go_0kernel.IRQ8Stub, go_0kernel.IRQ9Stub, go_0kernel.IRQ10Stub, go_0kernel.IRQ11Stub
+	.quad go_0kernel.IRQ12Stub, go_0kernel.IRQ13Stub, go_0kernel.IRQ14Stub, go_0kernel.IRQ15Stub
+.section .text
 
-.global go_0kernel.IRQ1Stub
-.type   go_0kernel.IRQ1Stub, @function
-go_0kernel.IRQ1Stub:
+# Local APIC timer (vector 0x40), the preemption tick of application processors
+.global go_0kernel.LAPICTimerStub
+.type   go_0kernel.LAPICTimerStub, @function
+go_0kernel.LAPICTimerStub:
 	pushq $0            # dummy error code
 	PUSH_REGS
 	mov %rsp, %rbp
 	andq $-16, %rsp
 	subq $8, %rsp
-	call go_0kernel.IRQ1Handler
+	call go_0kernel.LAPICTimerHandler
 	mov %rbp, %rsp
 	POP_REGS
 	addq $8, %rsp      # pop dummy error code
 	iretq
-.size go_0kernel.IRQ1Stub, . - go_0kernel.IRQ1Stub
+.size go_0kernel.LAPICTimerStub, . - go_0kernel.LAPICTimerStub
+
+.global go_0kernel.getLAPICTimerStubAddr
+.type   go_0kernel.getLAPICTimerStubAddr, @function
+go_0kernel.getLAPICTimerStubAddr:
+	leaq go_0kernel.LAPICTimerStub(%rip), %rax
+	ret
+.size go_0kernel.getLAPICTimerStubAddr, . - go_0kernel.getLAPICTimerStubAddr
+
+# Local APIC spurious interrupt (vector 0xFF): no EOI is due, just return
+.global go_0kernel.SpuriousStub
+.type   go_0kernel.SpuriousStub, @function
+go_0kernel.SpuriousStub:
+	iretq
+.size go_0kernel.SpuriousStub, . - go_0kernel.SpuriousStub
 
-.global go_0kernel.getIRQ1StubAddr
-.type   go_0kernel.getIRQ1StubAddr, @function
-go_0kernel.getIRQ1StubAddr:
-	leaq go_0kernel.IRQ1Stub(%rip), %rax
+.global go_0kernel.getSpuriousStubAddr
+.type   go_0kernel.getSpuriousStubAddr, @function
+go_0kernel.getSpuriousStubAddr:
+	leaq go_0kernel.SpuriousStub(%rip), %rax
 	ret
-.size go_0kernel.getIRQ1StubAddr, . - go_0kernel.getIRQ1StubAddr
+.size go_0kernel.getSpuriousStubAddr, . - go_0kernel.getSpuriousStubAddr
 
 # --- Data section: global variable runtime.writeBarrier (bool) ---
 .section .data
@@ -625,6 +675,303 @@ github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1ata.outsw:
 	ret
 .size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1ata.outsw, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1ata.outsw
 
+# github.com/dmarro89/go-dav-os/drivers/ata.outl(port uint16, value uint32)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1ata.outl
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1ata.outl, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1ata.outl:
+	movw %di, %dx
+	movl %esi, %eax
+	outl %eax, %dx
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1ata.outl, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1ata.outl
+
+# github.com/dmarro89/go-dav-os/drivers/rtc.inb(port uint16) byte
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1rtc.inb
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1rtc.inb, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1rtc.inb:
+	movw %di, %dx
+	xorl %eax, %eax
+	inb %dx, %al
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1rtc.inb, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1rtc.inb
+
+# github.com/dmarro89/go-dav-os/drivers/rtc.outb(port uint16, val byte)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1rtc.outb
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1rtc.outb, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1rtc.outb:
+	movw %di, %dx
+	movb %sil, %al
+	outb %al, %dx
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1rtc.outb, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1rtc.outb
+
+# github.com/dmarro89/go-dav-os/drivers/mouse.inb(port uint16) byte
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1mouse.inb
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1mouse.inb, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1mouse.inb:
+	movw %di, %dx
+	xorl %eax, %eax
+	inb %dx, %al
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1mouse.inb, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1mouse.inb
+
+# github.com/dmarro89/go-dav-os/drivers/mouse.outb(port uint16, val byte)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1mouse.outb
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1mouse.outb, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1mouse.outb:
+	movw %di, %dx
+	movb %sil, %al
+	outb %al, %dx
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1mouse.outb, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1mouse.outb
+
+# github.com/dmarro89/go-dav-os/kernel/time.rdtsc() uint64
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.rdtsc
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.rdtsc, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.rdtsc:
+	rdtsc
+	shlq $32, %rdx
+	orq %rdx, %rax
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.rdtsc, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.rdtsc
+
+# github.com/dmarro89/go-dav-os/kernel/time.inb(port uint16) byte
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.inb
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.inb, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.inb:
+	movw %di, %dx
+	xorl %eax, %eax
+	inb %dx, %al
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.inb, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.inb
+
+# github.com/dmarro89/go-dav-os/kernel/time.outb(port uint16, val byte)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.outb
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.outb, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.outb:
+	movw %di, %dx
+	movb %sil, %al
+	outb %al, %dx
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.outb, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.outb
+
+# github.com/dmarro89/go-dav-os/kernel/time.irqSave() uint64
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.irqSave
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.irqSave, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.irqSave:
+	pushfq
+	popq %rax
+	cli
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.irqSave, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.irqSave
+
+# github.com/dmarro89/go-dav-os/kernel/time.irqRestore(flags uint64)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.irqRestore
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.irqRestore, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.irqRestore:
+	pushq %rdi
+	popfq
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.irqRestore, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.irqRestore
+
+# github.com/dmarro89/go-dav-os/kernel/time.waitForInterrupt()
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.waitForInterrupt
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.waitForInterrupt, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.waitForInterrupt:
+	sti
+	hlt
+	cli
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.waitForInterrupt, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1time.waitForInterrupt
+
+# github.com/dmarro89/go-dav-os/kernel/spinlock.xchg32(addr *uint32, v uint32) uint32
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.xchg32
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.xchg32, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.xchg32:
+	movl %esi, %eax
+	xchgl %eax, (%rdi)     # implicitly locked
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.xchg32, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.xchg32
+
+# github.com/dmarro89/go-dav-os/kernel/spinlock.load32(addr *uint32) uint32
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.load32
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.load32, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.load32:
+	movl (%rdi), %eax
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.load32, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.load32
+
+# github.com/dmarro89/go-dav-os/kernel/spinlock.store32(addr *uint32, v uint32)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.store32
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.store32, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.store32:
+	movl %esi, (%rdi)
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.store32, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.store32
+
+# github.com/dmarro89/go-dav-os/kernel/spinlock.pause()
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.pause
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.pause, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.pause:
+	pause
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.pause, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.pause
+
+# github.com/dmarro89/go-dav-os/kernel/spinlock.irqSave() uint64
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.irqSave
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.irqSave, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.irqSave:
+	pushfq
+	popq %rax
+	cli
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.irqSave, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.irqSave
+
+# github.com/dmarro89/go-dav-os/kernel/spinlock.irqRestore(flags uint64)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.irqRestore
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.irqRestore, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.irqRestore:
+	pushq %rdi
+	popfq
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.irqRestore, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1spinlock.irqRestore
+
+# github.com/dmarro89/go-dav-os/kernel/percpu.readGS0() uintptr
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1percpu.readGS0
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1percpu.readGS0, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1percpu.readGS0:
+	movq %gs:0, %rax
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1percpu.readGS0, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1percpu.readGS0
+
+# github.com/dmarro89/go-dav-os/kernel/percpu.writeGSBase(base uint64)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1percpu.writeGSBase
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1percpu.writeGSBase, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1percpu.writeGSBase:
+	movl $0xC0000101, %ecx   # IA32_GS_BASE
+	movq %rdi, %rax
+	movq %rdi, %rdx
+	shrq $32, %rdx
+	wrmsr
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1percpu.writeGSBase, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1percpu.writeGSBase
+
+# github.com/dmarro89/go-dav-os/kernel/irq.inb(port uint16) byte
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1irq.inb
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1irq.inb, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1irq.inb:
+	movw %di, %dx
+	xorl %eax, %eax
+	inb %dx, %al
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1irq.inb, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1irq.inb
+
+# github.com/dmarro89/go-dav-os/kernel/irq.outb(port uint16, val byte)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1irq.outb
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1irq.outb, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1irq.outb:
+	movw %di, %dx
+	movb %sil, %al
+	outb %al, %dx
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1irq.outb, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1kernel_1irq.outb
+
+# github.com/dmarro89/go-dav-os/drivers/pci.inl(port uint16) uint32
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.inl
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.inl, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.inl:
+	movw %di, %dx
+	inl %dx, %eax
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.inl, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.inl
+
+# github.com/dmarro89/go-dav-os/drivers/pci.outl(port uint16, value uint32)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.outl
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.outl, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.outl:
+	movw %di, %dx
+	movl %esi, %eax
+	outl %eax, %dx
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.outl, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.outl
+
+# github.com/dmarro89/go-dav-os/drivers/pci.outw(port uint16, value uint16)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.outw
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.outw, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.outw:
+	movw %di, %dx
+	movw %si, %ax
+	outw %ax, %dx
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.outw, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1pci.outw
+
+# github.com/dmarro89/go-dav-os/drivers/virtio.inb(port uint16) uint8
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inb
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inb, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inb:
+	movw %di, %dx
+	xorl %eax, %eax
+	inb %dx, %al
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inb, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inb
+
+# github.com/dmarro89/go-dav-os/drivers/virtio.outb(port uint16, value uint8)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outb
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outb, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outb:
+	movw %di, %dx
+	movb %sil, %al
+	outb %al, %dx
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outb, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outb
+
+# github.com/dmarro89/go-dav-os/drivers/virtio.inw(port uint16) uint16
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inw
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inw, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inw:
+	movw %di, %dx
+	xorl %eax, %eax
+	inw %dx, %ax
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inw, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inw
+
+# github.com/dmarro89/go-dav-os/drivers/virtio.outw(port uint16, value uint16)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outw
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outw, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outw:
+	movw %di, %dx
+	movw %si, %ax
+	outw %ax, %dx
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outw, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outw
+
+# github.com/dmarro89/go-dav-os/drivers/virtio.inl(port uint16) uint32
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inl
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inl, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inl:
+	movw %di, %dx
+	inl %dx, %eax
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inl, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.inl
+
+# github.com/dmarro89/go-dav-os/drivers/virtio.outl(port uint16, value uint32)
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outl
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outl, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outl:
+	movw %di, %dx
+	movl %esi, %eax
+	outl %eax, %dx
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outl, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.outl
+
+# github.com/dmarro89/go-dav-os/drivers/virtio.cpuRelax()
+.global github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.cpuRelax
+.type   github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.cpuRelax, @function
+github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.cpuRelax:
+	pause
+	ret
+.size github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.cpuRelax, . - github_0com_1dmarro89_1go_x2ddav_x2dos_1drivers_1virtio.cpuRelax
+
 # void go_0kernel.ExecuteUserTask(funcPtr uint64, stackPtr uint64)
 .global go_0kernel.ExecuteUserTask
 .type   go_0kernel.ExecuteUserTask, @function
@@ -641,11 +988,12 @@ go_0kernel.ExecuteUserTask:
     popq __kernel_saved_rflags(%rip)
 
     # Setup iretq frame
+    # GS is left alone: reloading it would clear the GS base, which holds
+    # this CPU's percpu.CPU pointer
     mov $0x23, %ax      # user data selector Index 4 (0x20) | 3 = 0x23
     mov %ax, %ds
     mov %ax, %es
     mov %ax, %fs
-    mov %ax, %gs
 
     pushq $0x23         # SS (Data)
     pushq %rsi          # RSP
@@ -658,6 +1006,14 @@ go_0kernel.ExecuteUserTask:
     iretq
 .size go_0kernel.ExecuteUserTask, . - go_0kernel.ExecuteUserTask
 
+# void go_0kernel.setSyscallStack(uint64 top)
+.global go_0kernel.setSyscallStack
+.type   go_0kernel.setSyscallStack, @function
+go_0kernel.setSyscallStack:
+	movq %rdi, __syscall_stack_top(%rip)
+	ret
+.size go_0kernel.setSyscallStack, . - go_0kernel.setSyscallStack
+
 # void go_0kernel.ReturnToKernel()
 .global go_0kernel.ReturnToKernel
 .type   go_0kernel.ReturnToKernel, @function
@@ -667,7 +1023,6 @@ go_0kernel.ReturnToKernel:
     mov %ax, %ds
     mov %ax, %es
     mov %ax, %fs
-    mov %ax, %gs
 
     mov __kernel_saved_rsp(%rip), %rsp
     mov __kernel_saved_rbp(%rip), %rbp
@@ -727,6 +1082,17 @@ go_0kernel.GetUserProgramPrivilegedProbeAddr:
 	ret
 .size go_0kernel.GetUserProgramPrivilegedProbeAddr, . - go_0kernel.GetUserProgramPrivilegedProbeAddr
 
+# uint64 go_0kernel.GetUserProgramEchoAddr()
+.global go_0kernel.GetUserProgramEchoAddr
+.type   go_0kernel.GetUserProgramEchoAddr, @function
+go_0kernel.GetUserProgramEchoAddr:
+	leaq go_0kernel.userEchoStart(%rip), %rax
+	leaq __user_program_page(%rip), %rdx
+	subq %rdx, %rax
+	addq $USER_VA_BASE, %rax
+	ret
+.size go_0kernel.GetUserProgramEchoAddr, . - go_0kernel.GetUserProgramEchoAddr
+
 # uint64 go_0kernel.GetUserStackTopAddr()
 .global go_0kernel.GetUserStackTopAddr
 .type   go_0kernel.GetUserStackTopAddr, @function
@@ -748,6 +1114,7 @@ __kernel_saved_rflags: .quad 0
 __syscall_saved_user_rsp: .quad 0
 __syscall_saved_user_rip: .quad 0
 __syscall_saved_user_rflags: .quad 0
+__syscall_stack_top: .quad __syscall_entry_stack_top
 
 .section .bss
 .align 16
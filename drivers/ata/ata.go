@@ -14,80 +14,284 @@ const (
 	DriveHead uint16 = 0x1F6
 	StatusCmd uint16 = 0x1F7
 
-	CmdRead  = 0x20
-	CmdWrite = 0x30
-	CmdFlush = 0xE7
+	CmdRead     = 0x20
+	CmdReadExt  = 0x24
+	CmdWrite    = 0x30
+	CmdWriteExt = 0x34
+	CmdFlush    = 0xE7
+	CmdFlushExt = 0xEA
+	CmdIdentify = 0xEC
+)
+
+// Status register bits
+const (
+	statusERR = 0x01
+	statusDRQ = 0x08
+	statusDF  = 0x20
+	statusBSY = 0x80
+
+	// floatingBus is what an empty controller reads back
+	floatingBus = 0xFF
+)
+
+// Drive/head register values for the master: LBA28 ORs in LBA bits 24-27
+const (
+	selectMasterLBA28 = 0xE0
+	selectMasterLBA48 = 0x40
+	selectMaster      = 0xA0
+)
+
+const (
+	SectorSize = 512
+
+	// Largest transfer per command; a sector count register of 0 means 256
+	// (28-bit) or 65536 (48-bit)
+	maxSectors28 = 256
+	maxSectors48 = 65536
 )
 
 // Timeout constant for ATA operations (iterations)
 const ataTimeout = 100000
 
-func waitBusy() bool {
+var (
+	identBuf [256]uint16
+	lastErr  Error
+	mode     Mode
+)
+
+// CurrentMode returns how transfers currently wait for the drive
+func CurrentMode() Mode { return mode }
+
+// SetMode switches the transfer mode. ModeIRQ needs EnableIRQ and ModeDMA
+// needs InitDMA to have succeeded.
+func SetMode(m Mode) bool {
+	switch m {
+	case ModePolling:
+	case ModeIRQ:
+		if !irqReady {
+			return false
+		}
+	case ModeDMA:
+		if !DMAAvailable() {
+			return false
+		}
+	default:
+		return false
+	}
+	mode = m
+	return true
+}
+
+func ptr(p uintptr) *byte { return (*byte)(unsafe.Pointer(p)) }
+
+// LastError returns the outcome of the most recent transfer, so callers of
+// the bool ReadSector/WriteSector API can still report why it failed
+func LastError() Error { return lastErr }
+
+func waitBusy() Error {
 	for i := 0; i < ataTimeout; i++ {
 		status := inb(StatusCmd)
-		if (status & 0x80) == 0 {
-			return true
+		if (status & statusBSY) == 0 {
+			return OK
 		}
 	}
-	return false // Timeout
+	return ErrTimeout
 }
 
-func waitDRQ() bool {
+func waitDRQ() Error {
 	for i := 0; i < ataTimeout; i++ {
 		status := inb(StatusCmd)
-		if (status & 0x01) != 0 {
-			return false // ERR
+		if (status & statusBSY) != 0 {
+			continue
+		}
+		if (status & statusERR) != 0 {
+			return decodeError(inb(ErrFeat))
+		}
+		if (status & statusDF) != 0 {
+			return ErrDeviceFault
 		}
-		if (status & 0x08) != 0 {
-			return true // DRQ ready
+		if (status & statusDRQ) != 0 {
+			return OK
 		}
 	}
-	return false // Timeout
+	return ErrNoDRQ
+}
+
+// Identify issues IDENTIFY DEVICE to the primary master and caches the
+// result for Device. Packet (ATAPI) devices are reported as ErrNoDevice.
+func Identify() Error {
+	device.Present = false
+
+	outb(DriveHead, selectMaster)
+	outb(SecCount, 0)
+	outb(LBALo, 0)
+	outb(LBAMid, 0)
+	outb(LBAHi, 0)
+	outb(StatusCmd, CmdIdentify)
+
+	status := inb(StatusCmd)
+	if status == 0 || status == floatingBus {
+		return ErrNoDevice
+	}
+	if err := waitBusy(); err != OK {
+		return err
+	}
+	// ATAPI and SATA bridges leave a signature in the LBA registers
+	if inb(LBAMid) != 0 || inb(LBAHi) != 0 {
+		return ErrNoDevice
+	}
+	if err := waitDRQ(); err != OK {
+		return err
+	}
+
+	insw(Data, (*byte)(unsafe.Pointer(&identBuf[0])), 256)
+	parseIdentify(&identBuf, &device)
+	return OK
+}
+
+// ReadSectors reads count sectors starting at lba into buf, which must
+// hold count*SectorSize bytes
+func ReadSectors(lba uint64, count int, buf *byte) Error {
+	lastErr = transfer(lba, count, buf, false)
+	return lastErr
+}
+
+// WriteSectors writes count sectors from buf starting at lba and flushes
+// the drive's write cache
+func WriteSectors(lba uint64, count int, buf *byte) Error {
+	lastErr = transfer(lba, count, buf, true)
+	return lastErr
 }
 
 func ReadSector(lba uint32, buf *[512]byte) bool {
-	if !waitBusy() {
-		return false
+	return ReadSectors(uint64(lba), 1, &buf[0]) == OK
+}
+
+func WriteSector(lba uint32, data *[512]byte) bool {
+	return WriteSectors(uint64(lba), 1, &data[0]) == OK
+}
+
+func transfer(lba uint64, count int, buf *byte, write bool) Error {
+	if count <= 0 {
+		return ErrBadCount
+	}
+	if err := checkRange(lba, count); err != OK {
+		return err
 	}
 
-	outb(DriveHead, 0xE0|byte((lba>>24)&0x0F))
-	outb(SecCount, 1)
-	outb(LBALo, byte(lba))
-	outb(LBAMid, byte(lba>>8))
-	outb(LBAHi, byte(lba>>16))
-	outb(StatusCmd, CmdRead)
+	maxPerCmd := maxSectors28
+	if useLBA48() {
+		maxPerCmd = maxSectors48
+	}
+	if mode == ModeDMA && maxPerCmd > maxDMASectors {
+		maxPerCmd = maxDMASectors
+	}
 
-	if !waitDRQ() {
-		return false
+	p := uintptr(unsafe.Pointer(buf))
+	for count > 0 {
+		n := count
+		if n > maxPerCmd {
+			n = maxPerCmd
+		}
+		err := errDMAUnusable
+		if mode == ModeDMA {
+			err = dmaTransfer(lba, n, p, write)
+		}
+		if err == errDMAUnusable {
+			if mode == ModePolling {
+				err = pioPolling(lba, n, p, write)
+			} else {
+				err = pioIRQ(lba, n, p, write)
+			}
+		}
+		if err != OK {
+			return err
+		}
+		p += uintptr(n) * SectorSize
+		lba += uint64(n)
+		count -= n
 	}
+	return OK
+}
 
-	insw(Data, (*byte)(unsafe.Pointer(&buf[0])), 256)
-	return true
+// pioPolling is the ModePolling body of one command: spin on DRQ for
+// every sector
+func pioPolling(lba uint64, n int, p uintptr, write bool) Error {
+	var err Error
+	if write {
+		err = issue(lba, n, CmdWrite, CmdWriteExt)
+	} else {
+		err = issue(lba, n, CmdRead, CmdReadExt)
+	}
+	if err != OK {
+		return err
+	}
+
+	// PIO moves one sector per DRQ block
+	for i := 0; i < n; i++ {
+		if err := waitDRQ(); err != OK {
+			return err
+		}
+		if write {
+			outsw(Data, ptr(p), 256)
+		} else {
+			insw(Data, ptr(p), 256)
+		}
+		p += SectorSize
+	}
+
+	if write {
+		return flush()
+	}
+	return OK
 }
 
-func WriteSector(lba uint32, data *[512]byte) bool {
-	if !waitBusy() {
-		return false
+// issue programs the task file for n sectors at lba and sends cmd28, or
+// cmd48 when the drive is addressed with 48-bit LBA
+func issue(lba uint64, n int, cmd28, cmd48 byte) Error {
+	if err := waitBusy(); err != OK {
+		return err
+	}
+
+	if useLBA48() {
+		outb(DriveHead, selectMasterLBA48)
+		// High-order bytes first, then the low-order ones
+		outb(SecCount, byte(n>>8))
+		outb(LBALo, byte(lba>>24))
+		outb(LBAMid, byte(lba>>32))
+		outb(LBAHi, byte(lba>>40))
+		outb(SecCount, byte(n))
+		outb(LBALo, byte(lba))
+		outb(LBAMid, byte(lba>>8))
+		outb(LBAHi, byte(lba>>16))
+		outb(StatusCmd, cmd48)
+		return OK
 	}
 
-	outb(DriveHead, 0xE0|byte((lba>>24)&0x0F))
-	outb(SecCount, 1)
+	outb(DriveHead, selectMasterLBA28|byte((lba>>24)&0x0F))
+	outb(SecCount, byte(n))
 	outb(LBALo, byte(lba))
 	outb(LBAMid, byte(lba>>8))
 	outb(LBAHi, byte(lba>>16))
-	outb(StatusCmd, CmdWrite)
+	outb(StatusCmd, cmd28)
+	return OK
+}
 
-	if !waitDRQ() {
-		return false
+// flush waits for the drive to commit its write cache
+func flush() Error {
+	if err := waitBusy(); err != OK {
+		return err
 	}
-
-	outsw(Data, (*byte)(unsafe.Pointer(&data[0])), 256)
-
-	// Flush Cache
-	outb(StatusCmd, CmdFlush)
-	if !waitBusy() {
-		return false
+	if useLBA48() {
+		outb(StatusCmd, CmdFlushExt)
+	} else {
+		outb(StatusCmd, CmdFlush)
 	}
-
-	return true
+	if err := waitBusy(); err != OK {
+		return err
+	}
+	if inb(StatusCmd)&statusERR != 0 {
+		return decodeError(inb(ErrFeat))
+	}
+	return OK
 }
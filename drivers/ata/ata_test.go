@@ -0,0 +1,104 @@
+package ata
+
+import (
+	"testing"
+
+	"github.com/dmarro89/go-dav-os/drivers/pci"
+)
+
+func TestParseIdentify(t *testing.T) {
+	var words [256]uint16
+	putIdentString(&words, idModel, "QEMU HARDDISK")
+	// Serials are often right-justified with leading spaces
+	putIdentString(&words, idSerial, "   QM00001")
+	words[idCommandSets] = cmdSetLBA48
+	words[idLBA28Sectors] = 0xFFFF
+	words[idLBA28Sectors+1] = 0x0FFF
+	words[idLBA48Sectors] = 0x0000
+	words[idLBA48Sectors+1] = 0x0000
+	words[idLBA48Sectors+2] = 0x0001 // 2^32 sectors = 2 TiB
+
+	var d DeviceInfo
+	parseIdentify(&words, &d)
+
+	if !d.Present || !d.LBA48 {
+		t.Fatalf("Present=%v LBA48=%v, want both true", d.Present, d.LBA48)
+	}
+	if got := string(d.Model[:d.ModelLen]); got != "QEMU HARDDISK" {
+		t.Fatalf("model = %q", got)
+	}
+	if got := string(d.Serial[:d.SerialLen]); got != "QM00001" {
+		t.Fatalf("serial = %q", got)
+	}
+	if d.Sectors != 1<<32 {
+		t.Fatalf("sectors = %d, want %d from the 48-bit words", d.Sectors, uint64(1)<<32)
+	}
+
+	words[idCommandSets] = 0
+	parseIdentify(&words, &d)
+	if d.LBA48 || d.Sectors != 0x0FFFFFFF {
+		t.Fatalf("LBA28 drive: LBA48=%v sectors=%#x, want false and 0xfffffff", d.LBA48, d.Sectors)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	tests := []struct {
+		reg  byte
+		want Error
+	}{
+		{0, ErrUnknown},
+		{errABRT, ErrAborted},
+		{errIDNF, ErrIDNotFound},
+		{errUNC, ErrUncorrectable},
+		{errMCR, ErrMediaChanged},
+		{errAMNF, ErrAddressMarkNotFound},
+		{errTK0NF, ErrTrack0NotFound},
+		// Data loss beats the abort that usually accompanies it
+		{errUNC | errABRT, ErrUncorrectable},
+		{errBBK | errIDNF, ErrBadBlock},
+	}
+	for _, tt := range tests {
+		if got := decodeError(tt.reg); got != tt.want {
+			t.Errorf("decodeError(%#x) = %v, want %v", tt.reg, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRange(t *testing.T) {
+	device.Present = false
+	if err := checkRange(lba28Limit-1, 1); err != OK {
+		t.Fatalf("last 28-bit sector rejected: %v", err)
+	}
+	if err := checkRange(lba28Limit-1, 2); err != ErrOutOfRange {
+		t.Fatalf("crossing 2^28 without LBA48 = %v, want out of range", err)
+	}
+
+	Identify()
+	t.Cleanup(func() { device.Present = false })
+	if err := checkRange(40959, 1); err != OK {
+		t.Fatalf("last sector rejected: %v", err)
+	}
+	if err := checkRange(40960, 1); err != ErrOutOfRange {
+		t.Fatalf("past the end = %v, want out of range", err)
+	}
+	if err := ReadSectors(0, 0, nil); err != ErrBadCount {
+		t.Fatalf("zero-sector read = %v, want bad count", err)
+	}
+}
+
+func TestPCIDriverNeedsBusMasterIOBAR(t *testing.T) {
+	t.Cleanup(func() { controller = nil })
+
+	var d pci.Device
+	d.BARs[busMasterBAR].Kind = pci.BARMem32
+	d.BARs[busMasterBAR].Base = 0xFEBF0000
+	if probeController(&d) || controller != nil {
+		t.Fatalf("probe accepted a memory BAR4")
+	}
+
+	d.BARs[busMasterBAR].Kind = pci.BARIO
+	d.BARs[busMasterBAR].Base = 0xC040
+	if !probeController(&d) || controller != &d {
+		t.Fatalf("probe rejected an I/O BAR4 at 0xC040")
+	}
+}
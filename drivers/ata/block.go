@@ -0,0 +1,27 @@
+package ata
+
+import "github.com/dmarro89/go-dav-os/drivers/block"
+
+// BlockDevice is the primary master as block device "hda"
+var BlockDevice = block.Device{
+	Name:  "hda",
+	Read:  readBlocks,
+	Write: writeBlocks,
+}
+
+// RegisterBlock publishes the disk found by Identify to the block layer
+func RegisterBlock() bool {
+	if !device.Present {
+		return false
+	}
+	BlockDevice.Sectors = device.Sectors
+	return block.Register(&BlockDevice)
+}
+
+func readBlocks(unit int, lba uint64, count int, buf *byte) bool {
+	return ReadSectors(lba, count, buf) == OK
+}
+
+func writeBlocks(unit int, lba uint64, count int, buf *byte) bool {
+	return WriteSectors(lba, count, buf) == OK
+}
@@ -0,0 +1,117 @@
+//go:build !testing
+
+package ata
+
+import (
+	"unsafe"
+
+	"github.com/dmarro89/go-dav-os/drivers/pci"
+	"github.com/dmarro89/go-dav-os/mem"
+)
+
+const (
+	CmdReadDMA     = 0xC8
+	CmdReadDMAExt  = 0x25
+	CmdWriteDMA    = 0xCA
+	CmdWriteDMAExt = 0x35
+
+	// Bus master IDE registers of the primary channel, relative to BAR4
+	bmCommand = 0
+	bmStatus  = 2
+	bmPRDT    = 4
+
+	bmCommandStart = 0x01
+	// bmCommandRead makes the bus master write to memory (a disk read)
+	bmCommandRead = 0x08
+
+	bmStatusActive = 0x01
+	bmStatusError  = 0x02
+	bmStatusIRQ    = 0x04
+
+	// maxDMASectors keeps one command within what a page of PRD entries
+	// can describe for any buffer alignment
+	maxDMASectors = 2048
+)
+
+var (
+	bmBase    uint16
+	prdTable  *[prdTableEntries]prdEntry
+	prdPhys   uint64
+	dmaActive bool
+)
+
+// InitDMA enables bus mastering on the IDE controller claimed by
+// PCIDriver and allocates the PRD table. It needs EnableIRQ (completion is
+// signalled on IRQ 14) and the page frame allocator. On success transfers
+// switch to ModeDMA.
+func InitDMA() bool {
+	if !irqReady || controller == nil {
+		return false
+	}
+	if prdTable == nil {
+		page := mem.AllocPage()
+		if page == 0 || page >= 1<<32 {
+			return false
+		}
+		prdPhys = page
+		prdTable = (*[prdTableEntries]prdEntry)(unsafe.Pointer(uintptr(page)))
+	}
+	controller.Enable(pci.CommandBusMaster)
+	bmBase = uint16(controller.BARs[busMasterBAR].Base)
+	mode = ModeDMA
+	return true
+}
+
+// DMAAvailable reports whether InitDMA found a bus master
+func DMAAvailable() bool { return bmBase != 0 && prdTable != nil }
+
+// dmaTransfer runs one DMA command of n sectors at lba. Returns
+// errDMAUnusable when the buffer cannot be described by the PRD table, so
+// the caller falls back to PIO.
+func dmaTransfer(lba uint64, n int, p uintptr, write bool) Error {
+	if buildPRD(prdTable, uint64(p), n*SectorSize) == 0 {
+		return errDMAUnusable
+	}
+
+	outb(bmBase+bmCommand, 0)
+	outl(bmBase+bmPRDT, uint32(prdPhys))
+	var cmd byte
+	if !write {
+		cmd = bmCommandRead
+	}
+	outb(bmBase+bmCommand, cmd)
+	// Status error and IRQ bits are cleared by writing 1
+	outb(bmBase+bmStatus, inb(bmBase+bmStatus)|bmStatusError|bmStatusIRQ)
+
+	armIRQ()
+	dmaActive = true
+	var err Error
+	if write {
+		err = issue(lba, n, CmdWriteDMA, CmdWriteDMAExt)
+	} else {
+		err = issue(lba, n, CmdReadDMA, CmdReadDMAExt)
+	}
+	if err != OK {
+		dmaActive = false
+		return err
+	}
+	outb(bmBase+bmCommand, cmd|bmCommandStart)
+
+	_, err = waitIRQ()
+
+	outb(bmBase+bmCommand, cmd)
+	dmaActive = false
+	bm := inb(bmBase + bmStatus)
+	outb(bmBase+bmStatus, bm|bmStatusError|bmStatusIRQ)
+
+	if err != OK {
+		return err
+	}
+	if bm&bmStatusError != 0 {
+		return ErrDMA
+	}
+	if write {
+		return flushIRQ()
+	}
+	return OK
+}
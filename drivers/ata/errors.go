@@ -0,0 +1,110 @@
+package ata
+
+// Error describes why an ATA command failed. OK is the zero value.
+type Error uint8
+
+const (
+	OK Error = iota
+	// ErrNoDevice means nothing answered IDENTIFY on the primary master
+	ErrNoDevice
+	// ErrTimeout means BSY never cleared
+	ErrTimeout
+	// ErrNoDRQ means the drive never asked for (or offered) data
+	ErrNoDRQ
+	// ErrDeviceFault is the DF status bit
+	ErrDeviceFault
+	// ErrOutOfRange means the request runs past the disk or past 28-bit LBA
+	ErrOutOfRange
+	// ErrBadCount is a transfer of zero or fewer sectors
+	ErrBadCount
+
+	// Decoded from the error register when the ERR status bit is set
+	ErrBadBlock
+	ErrUncorrectable
+	ErrMediaChanged
+	ErrIDNotFound
+	ErrAborted
+	ErrTrack0NotFound
+	ErrAddressMarkNotFound
+	// ErrUnknown is ERR with an empty error register
+	ErrUnknown
+
+	// ErrDMA is the bus master's error bit (a PCI fault during DMA)
+	ErrDMA
+
+	// errDMAUnusable tells the transfer loop to fall back to PIO because
+	// the buffer cannot be described by the PRD table
+	errDMAUnusable
+)
+
+// Error register bits
+const (
+	errAMNF  = 0x01
+	errTK0NF = 0x02
+	errABRT  = 0x04
+	errMCR   = 0x08
+	errIDNF  = 0x10
+	errMC    = 0x20
+	errUNC   = 0x40
+	errBBK   = 0x80
+)
+
+// decodeError maps the error register to the most specific Error. Several
+// bits may be set; the data-loss ones win.
+func decodeError(reg byte) Error {
+	switch {
+	case reg&errBBK != 0:
+		return ErrBadBlock
+	case reg&errUNC != 0:
+		return ErrUncorrectable
+	case reg&errIDNF != 0:
+		return ErrIDNotFound
+	case reg&(errMC|errMCR) != 0:
+		return ErrMediaChanged
+	case reg&errABRT != 0:
+		return ErrAborted
+	case reg&errTK0NF != 0:
+		return ErrTrack0NotFound
+	case reg&errAMNF != 0:
+		return ErrAddressMarkNotFound
+	}
+	return ErrUnknown
+}
+
+func (e Error) String() string {
+	switch e {
+	case OK:
+		return "ok"
+	case ErrNoDevice:
+		return "no device"
+	case ErrTimeout:
+		return "timeout waiting for drive"
+	case ErrNoDRQ:
+		return "drive did not request data"
+	case ErrDeviceFault:
+		return "device fault"
+	case ErrOutOfRange:
+		return "lba out of range"
+	case ErrBadCount:
+		return "bad sector count"
+	case ErrBadBlock:
+		return "bad block"
+	case ErrUncorrectable:
+		return "uncorrectable data error"
+	case ErrMediaChanged:
+		return "media changed"
+	case ErrIDNotFound:
+		return "sector id not found"
+	case ErrAborted:
+		return "command aborted"
+	case ErrTrack0NotFound:
+		return "track 0 not found"
+	case ErrAddressMarkNotFound:
+		return "address mark not found"
+	case ErrDMA:
+		return "bus master DMA error"
+	case errDMAUnusable:
+		return "buffer not usable for DMA"
+	}
+	return "unknown error"
+}
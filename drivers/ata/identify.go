@@ -0,0 +1,101 @@
+package ata
+
+// DeviceInfo is what IDENTIFY DEVICE reported for the primary master
+type DeviceInfo struct {
+	Present bool
+	// Model and Serial are ASCII, space-trimmed; only the first ModelLen /
+	// SerialLen bytes are meaningful
+	Model     [40]byte
+	ModelLen  int
+	Serial    [20]byte
+	SerialLen int
+	// Sectors is the number of addressable 512-byte sectors
+	Sectors uint64
+	LBA48   bool
+}
+
+// IDENTIFY DEVICE word offsets
+const (
+	idSerial       = 10
+	idSerialWords  = 10
+	idModel        = 27
+	idModelWords   = 20
+	idLBA28Sectors = 60
+	idCommandSets  = 83
+	idLBA48Sectors = 100
+
+	cmdSetLBA48 = 1 << 10
+
+	// lba28Limit is the first sector 28-bit commands cannot reach
+	lba28Limit = 1 << 28
+)
+
+var device DeviceInfo
+
+// Device returns the drive found by the last Identify
+func Device() *DeviceInfo { return &device }
+
+// parseIdentify fills d from the 256 words IDENTIFY DEVICE returns
+func parseIdentify(words *[256]uint16, d *DeviceInfo) {
+	d.Present = true
+	d.ModelLen = identString(words, idModel, idModelWords, d.Model[:])
+	d.SerialLen = identString(words, idSerial, idSerialWords, d.Serial[:])
+
+	d.LBA48 = words[idCommandSets]&cmdSetLBA48 != 0
+	if d.LBA48 {
+		d.Sectors = uint64(words[idLBA48Sectors]) |
+			uint64(words[idLBA48Sectors+1])<<16 |
+			uint64(words[idLBA48Sectors+2])<<32 |
+			uint64(words[idLBA48Sectors+3])<<48
+	} else {
+		d.Sectors = uint64(words[idLBA28Sectors]) | uint64(words[idLBA28Sectors+1])<<16
+	}
+}
+
+// identString unpacks an IDENTIFY string (two characters per word, high
+// byte first) into out with surrounding spaces removed. Returns the length.
+func identString(words *[256]uint16, first, count int, out []byte) int {
+	n := 0
+	for i := 0; i < count; i++ {
+		w := words[first+i]
+		out[n] = byte(w >> 8)
+		out[n+1] = byte(w)
+		n += 2
+	}
+
+	start := 0
+	for start < n && (out[start] == ' ' || out[start] == 0) {
+		start++
+	}
+	end := n
+	for end > start && (out[end-1] == ' ' || out[end-1] == 0) {
+		end--
+	}
+	for i := start; i < end; i++ {
+		out[i-start] = out[i]
+	}
+	for i := end - start; i < n; i++ {
+		out[i] = 0
+	}
+	return end - start
+}
+
+// checkRange rejects transfers the identified drive cannot address. Before
+// Identify has found a drive only the 28-bit limit applies.
+func checkRange(lba uint64, count int) Error {
+	end := lba + uint64(count)
+	if end < lba {
+		return ErrOutOfRange
+	}
+	if device.Present && end > device.Sectors {
+		return ErrOutOfRange
+	}
+	if !useLBA48() && end > lba28Limit {
+		return ErrOutOfRange
+	}
+	return OK
+}
+
+func useLBA48() bool {
+	return device.Present && device.LBA48
+}
@@ -0,0 +1,150 @@
+//go:build !testing
+
+package ata
+
+import (
+	"github.com/dmarro89/go-dav-os/kernel/irq"
+	"github.com/dmarro89/go-dav-os/kernel/scheduler"
+	ktime "github.com/dmarro89/go-dav-os/kernel/time"
+)
+
+const (
+	// DevControl is the primary channel's device control register; bit 1
+	// (nIEN) masks the drive's interrupt line
+	DevControl uint16 = 0x3F6
+
+	// PrimaryIRQ is the legacy IRQ of the primary channel. The driver only
+	// handles the primary master, so the secondary channel's IRQ 15 is
+	// left to whoever drives that channel.
+	PrimaryIRQ = 14
+
+	irqTimeoutNs = 2 * ktime.NanosPerSecond
+)
+
+var (
+	irqReady  bool
+	irqFired  bool
+	irqWaiter = -1
+	// irqStatus is the status register read by the handler, which also
+	// acknowledges the drive's interrupt
+	irqStatus byte
+)
+
+// EnableIRQ claims IRQ 14 and lets the drive raise it, so transfers sleep
+// instead of spinning. The mode switches from polling to ModeIRQ.
+func EnableIRQ() bool {
+	if irqReady {
+		return true
+	}
+	if !irq.RegisterIRQ(PrimaryIRQ, irqHandler) {
+		return false
+	}
+	outb(DevControl, 0)
+	irqReady = true
+	if mode == ModePolling {
+		mode = ModeIRQ
+	}
+	return true
+}
+
+func irqHandler() {
+	if dmaActive {
+		// Only the bus master's IRQ bit says the transfer is ours and done
+		if inb(bmBase+bmStatus)&bmStatusIRQ == 0 {
+			return
+		}
+	}
+	irqStatus = inb(StatusCmd)
+	irqFired = true
+	if irqWaiter >= 0 {
+		scheduler.Wake(irqWaiter)
+	}
+}
+
+func irqArrived() bool { return irqFired }
+
+// armIRQ must run before the action that makes the drive interrupt
+func armIRQ() {
+	irqFired = false
+	irqWaiter = scheduler.CurrentTaskID()
+}
+
+// waitIRQ blocks the calling task until the interrupt armed by armIRQ
+// arrives and returns the drive status it reported
+func waitIRQ() (byte, Error) {
+	ok := ktime.WaitEvent(irqArrived, irqTimeoutNs)
+	irqWaiter = -1
+	if !ok {
+		return 0, ErrTimeout
+	}
+	status := irqStatus
+	if status&statusERR != 0 {
+		return status, decodeError(inb(ErrFeat))
+	}
+	if status&statusDF != 0 {
+		return status, ErrDeviceFault
+	}
+	return status, OK
+}
+
+// pioIRQ is the ModeIRQ body of one command: the drive interrupts once per
+// sector when data is ready (reads) or has been taken (writes)
+func pioIRQ(lba uint64, n int, p uintptr, write bool) Error {
+	if write {
+		if err := issue(lba, n, CmdWrite, CmdWriteExt); err != OK {
+			return err
+		}
+		// No interrupt announces the first block of a PIO write
+		if err := waitDRQ(); err != OK {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			armIRQ()
+			outsw(Data, ptr(p), 256)
+			p += SectorSize
+			status, err := waitIRQ()
+			if err != OK {
+				return err
+			}
+			if i+1 < n && status&statusDRQ == 0 {
+				return ErrNoDRQ
+			}
+		}
+		return flushIRQ()
+	}
+
+	armIRQ()
+	if err := issue(lba, n, CmdRead, CmdReadExt); err != OK {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		status, err := waitIRQ()
+		if err != OK {
+			return err
+		}
+		if status&statusDRQ == 0 {
+			return ErrNoDRQ
+		}
+		// Reading the block lets the drive fetch the next one and interrupt
+		if i+1 < n {
+			armIRQ()
+		}
+		insw(Data, ptr(p), 256)
+		p += SectorSize
+	}
+	return OK
+}
+
+func flushIRQ() Error {
+	if err := waitBusy(); err != OK {
+		return err
+	}
+	armIRQ()
+	if useLBA48() {
+		outb(StatusCmd, CmdFlushExt)
+	} else {
+		outb(StatusCmd, CmdFlush)
+	}
+	_, err := waitIRQ()
+	return err
+}
@@ -0,0 +1,26 @@
+package ata
+
+// Mode selects how transfers wait for the drive
+type Mode uint8
+
+const (
+	// ModePolling busy-waits on the status register (used at boot)
+	ModePolling Mode = iota
+	// ModeIRQ moves data by PIO but sleeps on IRQ 14 between sectors
+	ModeIRQ
+	// ModeDMA lets the PCI IDE bus master move the data and sleeps on
+	// IRQ 14 until the whole transfer is done
+	ModeDMA
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModePolling:
+		return "poll"
+	case ModeIRQ:
+		return "irq"
+	case ModeDMA:
+		return "dma"
+	}
+	return "unknown"
+}
@@ -0,0 +1,31 @@
+package ata
+
+import "github.com/dmarro89/go-dav-os/drivers/pci"
+
+// busMasterBAR is the IDE controller BAR holding the bus master registers
+const busMasterBAR = 4
+
+// PCIDriver claims the IDE controller so InitDMA can reach its bus master
+// registers. Register it with the pci package before pci.Probe.
+var PCIDriver = pci.Driver{
+	Name:     "ata",
+	VendorID: pci.AnyID,
+	DeviceID: pci.AnyID,
+	Class:    pci.ClassStorage,
+	Subclass: pci.SubclassIDE,
+	Probe:    probeController,
+}
+
+// controller is the IDE function claimed by PCIDriver, nil without one
+var controller *pci.Device
+
+// probeController accepts an IDE function whose bus master BAR is an
+// assigned I/O range
+func probeController(d *pci.Device) bool {
+	bar := &d.BARs[busMasterBAR]
+	if bar.Kind != pci.BARIO || bar.Base == 0 {
+		return false
+	}
+	controller = d
+	return true
+}
@@ -0,0 +1,46 @@
+package ata
+
+// Physical Region Descriptor: one contiguous piece of a DMA buffer. The
+// bus master walks the table until an entry with prdEndOfTable.
+type prdEntry struct {
+	addr  uint32
+	count uint16 // bytes; 0 means 64 KiB
+	flags uint16
+}
+
+const (
+	prdEndOfTable = 0x8000
+	prdBoundary   = 0x10000
+
+	// prdTableEntries fills the page the table lives in
+	prdTableEntries = 4096 / 8
+)
+
+// buildPRD describes the size bytes at physical addr in table, splitting at
+// every 64 KiB boundary since a region may not cross one. Returns the
+// number of entries used, or 0 if the buffer cannot be described: odd
+// address or size, above 4 GiB, or more entries than the table holds.
+func buildPRD(table *[prdTableEntries]prdEntry, addr uint64, size int) int {
+	if size <= 0 || addr&1 != 0 || size&1 != 0 || addr+uint64(size) > 1<<32 {
+		return 0
+	}
+	n := 0
+	for size > 0 {
+		if n >= prdTableEntries {
+			return 0
+		}
+		chunk := prdBoundary - int(addr%prdBoundary)
+		if chunk > size {
+			chunk = size
+		}
+		e := &table[n]
+		e.addr = uint32(addr)
+		e.count = uint16(chunk) // 64 KiB wraps to 0, as the hardware wants
+		e.flags = 0
+		addr += uint64(chunk)
+		size -= chunk
+		n++
+	}
+	table[n-1].flags = prdEndOfTable
+	return n
+}
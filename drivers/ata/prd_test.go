@@ -0,0 +1,43 @@
+package ata
+
+import "testing"
+
+func TestBuildPRDSplitsAt64KiBBoundaries(t *testing.T) {
+	var table [prdTableEntries]prdEntry
+
+	// 4 KiB below a boundary, then a full 64 KiB region, then 4 KiB more
+	n := buildPRD(&table, 0x1F000, 0x1000+0x10000+0x1000)
+	if n != 3 {
+		t.Fatalf("buildPRD used %d entries, want 3", n)
+	}
+	want := []prdEntry{
+		{0x1F000, 0x1000, 0},
+		{0x20000, 0, 0}, // 64 KiB is encoded as 0
+		{0x30000, 0x1000, prdEndOfTable},
+	}
+	for i, w := range want {
+		if table[i] != w {
+			t.Errorf("entry %d = %+v, want %+v", i, table[i], w)
+		}
+	}
+}
+
+func TestBuildPRDRejectsUnusableBuffers(t *testing.T) {
+	var table [prdTableEntries]prdEntry
+	tests := []struct {
+		name string
+		addr uint64
+		size int
+	}{
+		{"empty", 0x1000, 0},
+		{"odd address", 0x1001, 512},
+		{"odd size", 0x1000, 511},
+		{"above 4 GiB", 0xFFFFFF00, 512},
+		{"too many regions", 0, (prdTableEntries + 1) * prdBoundary},
+	}
+	for _, tt := range tests {
+		if n := buildPRD(&table, tt.addr, tt.size); n != 0 {
+			t.Errorf("%s: buildPRD = %d entries, want 0", tt.name, n)
+		}
+	}
+}
@@ -19,3 +19,56 @@ func ReadSector(lba uint32, buf *[512]byte) bool {
 func WriteSector(lba uint32, data *[512]byte) bool {
 	return true
 }
+
+func ReadSectors(lba uint64, count int, buf *byte) Error {
+	if count <= 0 {
+		return ErrBadCount
+	}
+	return checkRange(lba, count)
+}
+
+func WriteSectors(lba uint64, count int, buf *byte) Error {
+	if count <= 0 {
+		return ErrBadCount
+	}
+	return checkRange(lba, count)
+}
+
+func LastError() Error { return OK }
+
+// Identify reports a disk shaped like QEMU's default 20 MiB test image
+func Identify() Error {
+	var words [256]uint16
+	putIdentString(&words, idModel, "QEMU HARDDISK")
+	putIdentString(&words, idSerial, "QM00001")
+	words[idCommandSets] = cmdSetLBA48
+	words[idLBA48Sectors] = 40960
+	words[idLBA28Sectors] = 40960
+	parseIdentify(&words, &device)
+	return OK
+}
+
+func putIdentString(words *[256]uint16, first int, s string) {
+	for i := 0; i < len(s); i += 2 {
+		hi, lo := s[i], byte(' ')
+		if i+1 < len(s) {
+			lo = s[i+1]
+		}
+		words[first+i/2] = uint16(hi)<<8 | uint16(lo)
+	}
+}
+
+var mode Mode
+
+func CurrentMode() Mode { return mode }
+
+// SetMode accepts polling and IRQ; host builds have no bus master
+func SetMode(m Mode) bool {
+	if m > ModeIRQ {
+		return false
+	}
+	mode = m
+	return true
+}
+
+func DMAAvailable() bool { return false }
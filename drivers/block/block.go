@@ -0,0 +1,103 @@
+package block
+
+const (
+	SectorSize = 512
+	MaxDevices = 4
+)
+
+// Device is a disk as seen by filesystems. Drivers fill one in and
+// Register it; Read and Write move count sectors starting at lba through
+// buf, which must hold count*SectorSize bytes. Unit is passed back to them
+// so one driver can serve several disks.
+type Device struct {
+	Name     string
+	Unit     int
+	Sectors  uint64
+	ReadOnly bool
+
+	Read  func(unit int, lba uint64, count int, buf *byte) bool
+	Write func(unit int, lba uint64, count int, buf *byte) bool
+}
+
+var (
+	devices     [MaxDevices]*Device
+	deviceCount int
+	current     *Device
+)
+
+// Register adds d to the device list. The first device registered becomes
+// the default one used by ReadSector/WriteSector.
+func Register(d *Device) bool {
+	if d == nil || d.Read == nil || deviceCount >= MaxDevices {
+		return false
+	}
+	devices[deviceCount] = d
+	deviceCount++
+	if current == nil {
+		current = d
+	}
+	return true
+}
+
+// Count returns how many devices are registered
+func Count() int { return deviceCount }
+
+// At returns the i-th registered device, or nil
+func At(i int) *Device {
+	if i < 0 || i >= deviceCount {
+		return nil
+	}
+	return devices[i]
+}
+
+// Find returns the registered device called name, or nil
+func Find(name string) *Device {
+	for i := 0; i < deviceCount; i++ {
+		if sameName(devices[i].Name, name) {
+			return devices[i]
+		}
+	}
+	return nil
+}
+
+func sameName(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Default returns the device behind ReadSector/WriteSector, or nil
+func Default() *Device { return current }
+
+// SetDefault makes d, which must be registered, the default device
+func SetDefault(d *Device) bool {
+	for i := 0; i < deviceCount; i++ {
+		if devices[i] == d {
+			current = d
+			return true
+		}
+	}
+	return false
+}
+
+// ReadSector reads one sector of the default device
+func ReadSector(lba uint32, buf *[SectorSize]byte) bool {
+	if current == nil || uint64(lba) >= current.Sectors {
+		return false
+	}
+	return current.Read(current.Unit, uint64(lba), 1, &buf[0])
+}
+
+// WriteSector writes one sector of the default device
+func WriteSector(lba uint32, data *[SectorSize]byte) bool {
+	if current == nil || current.ReadOnly || current.Write == nil || uint64(lba) >= current.Sectors {
+		return false
+	}
+	return current.Write(current.Unit, uint64(lba), 1, &data[0])
+}
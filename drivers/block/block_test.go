@@ -0,0 +1,67 @@
+package block
+
+import (
+	"testing"
+	"unsafe"
+)
+
+var ramDisk [8 * SectorSize]byte
+
+func ramRead(unit int, lba uint64, count int, buf *byte) bool {
+	out := (*[8 * SectorSize]byte)(unsafe.Pointer(buf))
+	copy(out[:count*SectorSize], ramDisk[lba*SectorSize:])
+	return true
+}
+
+func ramWrite(unit int, lba uint64, count int, buf *byte) bool {
+	in := (*[8 * SectorSize]byte)(unsafe.Pointer(buf))
+	copy(ramDisk[lba*SectorSize:], in[:count*SectorSize])
+	return true
+}
+
+func TestDefaultDeviceIsFirstRegistered(t *testing.T) {
+	ResetForTesting()
+	defer ResetForTesting()
+
+	var sec [SectorSize]byte
+	if ReadSector(0, &sec) {
+		t.Fatalf("ReadSector succeeded without a device")
+	}
+
+	vda := Device{Name: "vda", Sectors: 8, Read: ramRead, Write: ramWrite}
+	hda := Device{Name: "hda", Sectors: 8, Read: ramRead, ReadOnly: true}
+	if !Register(&vda) || !Register(&hda) {
+		t.Fatalf("Register failed")
+	}
+	if Register(&Device{Name: "bad"}) {
+		t.Fatalf("Register should reject a device without Read")
+	}
+	if Default() != &vda {
+		t.Fatalf("default device is %q, want vda", Default().Name)
+	}
+	if Find("hda") != &hda || Find("sda") != nil {
+		t.Fatalf("Find returned the wrong device")
+	}
+
+	sec[0] = 0xAB
+	if !WriteSector(3, &sec) || ramDisk[3*SectorSize] != 0xAB {
+		t.Fatalf("WriteSector did not reach the default device")
+	}
+	if WriteSector(8, &sec) {
+		t.Fatalf("WriteSector past the end succeeded")
+	}
+
+	if !SetDefault(&hda) {
+		t.Fatalf("SetDefault rejected a registered device")
+	}
+	if WriteSector(0, &sec) {
+		t.Fatalf("WriteSector to a read-only device succeeded")
+	}
+	sec[0] = 0
+	if !ReadSector(3, &sec) || sec[0] != 0xAB {
+		t.Fatalf("ReadSector through hda = 0x%x, want 0xAB", sec[0])
+	}
+	if SetDefault(&Device{Name: "other"}) {
+		t.Fatalf("SetDefault accepted an unregistered device")
+	}
+}
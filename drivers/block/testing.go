@@ -0,0 +1,12 @@
+//go:build testing
+
+package block
+
+// ResetForTesting forgets every registered device
+func ResetForTesting() {
+	for i := 0; i < MaxDevices; i++ {
+		devices[i] = nil
+	}
+	deviceCount = 0
+	current = nil
+}
@@ -0,0 +1,267 @@
+package mouse
+
+// Driver for the PS/2 auxiliary device behind the i8042 controller. The
+// controller shares its data port with the keyboard; a status bit tells
+// which device a byte came from.
+
+const (
+	dataPort    = 0x60
+	statusPort  = 0x64
+	commandPort = 0x64
+
+	statusOutputFull = 0x01 // a byte is waiting in the data port
+	statusInputFull  = 0x02 // the controller has not taken the last write
+	statusAuxData    = 0x20 // the waiting byte came from the aux device
+
+	cmdReadConfig  = 0x20
+	cmdWriteConfig = 0x60
+	cmdEnableAux   = 0xA8
+	cmdWriteAux    = 0xD4 // route the next data byte to the aux device
+
+	configAuxIRQ      = 0x02
+	configAuxClockOff = 0x20
+
+	devGetID           = 0xF2
+	devSetSampleRate   = 0xF3
+	devEnableReporting = 0xF4
+	devSetDefaults     = 0xF6
+
+	replyAck = 0xFA
+
+	// idIntelliMouse is reported after the 200/100/80 sample rate knock by
+	// mice with a scroll wheel, which then send a fourth packet byte
+	idIntelliMouse = 3
+
+	packetSync     = 0x08 // always set in the first packet byte
+	packetSignX    = 0x10
+	packetSignY    = 0x20
+	packetOverflow = 0xC0
+	packetButtons  = 0x07
+
+	// Bounds the busy-waits so a missing controller or mouse cannot hang boot
+	spinLimit = 100000
+
+	eventBufSize = 64
+)
+
+// IRQ is the legacy interrupt line of the aux device, on the slave PIC
+const IRQ = 12
+
+// Button bits of Event.Buttons, as laid out in the packet
+const (
+	ButtonLeft   = 0x01
+	ButtonRight  = 0x02
+	ButtonMiddle = 0x04
+)
+
+// Event is one decoded packet. DY grows downwards like screen rows; DZ is
+// the wheel motion in notches, positive when rolled towards the user.
+type Event struct {
+	DX, DY, DZ int
+	Buttons    uint8
+}
+
+var (
+	present    bool
+	wheel      bool
+	packetSize int
+	packet     [4]byte
+	count      int
+
+	events  [eventBufSize]Event
+	head    uint32
+	tail    uint32
+	dropped uint64
+)
+
+// Init enables the aux port, turns on IRQ 12 in the controller, tries the
+// IntelliMouse handshake and starts streaming packets. It reports whether a
+// mouse answered. Call it with interrupts disabled: replies are polled.
+func Init() bool {
+	present, wheel = false, false
+	packetSize, count = 3, 0
+	head, tail = 0, 0
+
+	flush()
+	if !writeCommand(cmdEnableAux) || !writeCommand(cmdReadConfig) {
+		return false
+	}
+	config, ok := readByte(false)
+	if !ok {
+		return false
+	}
+	config = (config | configAuxIRQ) &^ configAuxClockOff
+	if !writeCommand(cmdWriteConfig) || !writeData(config) {
+		return false
+	}
+
+	if !send(devSetDefaults) {
+		return false
+	}
+	if setSampleRate(200) && setSampleRate(100) && setSampleRate(80) {
+		if id, ok := readID(); ok && id == idIntelliMouse {
+			wheel = true
+			packetSize = 4
+		}
+	}
+	if !send(devEnableReporting) {
+		return false
+	}
+	present = true
+	return true
+}
+
+// Present reports whether Init found a mouse
+func Present() bool {
+	return present
+}
+
+// HasWheel reports whether the mouse accepted the IntelliMouse handshake
+func HasWheel() bool {
+	return wheel
+}
+
+// Dropped counts the events lost because nobody drained the queue
+func Dropped() uint64 {
+	return dropped
+}
+
+// IRQHandler collects one packet byte; the event is queued once the packet
+// is complete. A byte from the keyboard is left for its own handler.
+func IRQHandler() {
+	status := inb(statusPort)
+	if status&statusOutputFull == 0 || status&statusAuxData == 0 {
+		return
+	}
+	feed(inb(dataPort))
+}
+
+// TryRead pops the oldest decoded event. Call it with interrupts disabled.
+func TryRead() (Event, bool) {
+	if tail == head {
+		return Event{}, false
+	}
+	ev := events[tail]
+	tail = (tail + 1) % eventBufSize
+	return ev, true
+}
+
+// feed adds a byte to the packet being assembled. A first byte without the
+// sync bit means the stream slipped, so it is skipped until one lines up.
+func feed(b byte) {
+	if count == 0 && b&packetSync == 0 {
+		return
+	}
+	packet[count] = b
+	count++
+	if count < packetSize {
+		return
+	}
+	count = 0
+	push(decode())
+}
+
+// decode turns the assembled packet into an event. The deltas are 9-bit
+// two's complement with the sign in the first byte; an overflowed axis is
+// dropped rather than trusted, the buttons still count.
+func decode() Event {
+	flags := packet[0]
+	ev := Event{Buttons: flags & packetButtons}
+	if flags&packetOverflow == 0 {
+		ev.DX = int(packet[1])
+		if flags&packetSignX != 0 {
+			ev.DX -= 256
+		}
+		dy := int(packet[2])
+		if flags&packetSignY != 0 {
+			dy -= 256
+		}
+		ev.DY = -dy
+	}
+	if packetSize == 4 {
+		z := int(packet[3] & 0x0F)
+		if z&0x08 != 0 {
+			z -= 16
+		}
+		ev.DZ = z
+	}
+	return ev
+}
+
+func push(ev Event) {
+	next := (head + 1) % eventBufSize
+	if next == tail {
+		dropped++
+		return
+	}
+	events[head] = ev
+	head = next
+}
+
+// flush discards whatever the controller still holds from before Init
+func flush() {
+	for i := 0; i < spinLimit && inb(statusPort)&statusOutputFull != 0; i++ {
+		inb(dataPort)
+	}
+}
+
+func waitWritable() bool {
+	for i := 0; i < spinLimit; i++ {
+		if inb(statusPort)&statusInputFull == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func writeCommand(c byte) bool {
+	if !waitWritable() {
+		return false
+	}
+	outb(commandPort, c)
+	return true
+}
+
+func writeData(b byte) bool {
+	if !waitWritable() {
+		return false
+	}
+	outb(dataPort, b)
+	return true
+}
+
+// readByte waits for a byte from the controller itself or, when aux is
+// set, from the mouse. Keystrokes arriving meanwhile are discarded.
+func readByte(aux bool) (byte, bool) {
+	for i := 0; i < spinLimit; i++ {
+		status := inb(statusPort)
+		if status&statusOutputFull == 0 {
+			continue
+		}
+		b := inb(dataPort)
+		if !aux || status&statusAuxData != 0 {
+			return b, true
+		}
+	}
+	return 0, false
+}
+
+// send writes one byte to the mouse and waits for its acknowledgement
+func send(b byte) bool {
+	if !writeCommand(cmdWriteAux) || !writeData(b) {
+		return false
+	}
+	reply, ok := readByte(true)
+	return ok && reply == replyAck
+}
+
+func setSampleRate(rate byte) bool {
+	return send(devSetSampleRate) && send(rate)
+}
+
+func readID() (byte, bool) {
+	if !send(devGetID) {
+		return 0, false
+	}
+	return readByte(true)
+}
@@ -0,0 +1,135 @@
+package mouse
+
+import "testing"
+
+func resetFake() {
+	fakeOut, fakeSent, fakeRates = nil, nil, nil
+	fakeConfig = 0x61 // keyboard IRQ on, aux clock off, translation on
+	fakeWheel, fakeAbsent, fakeRate = false, false, false
+	fakeNext = 0
+}
+
+func feedBytes(bs ...byte) {
+	for _, b := range bs {
+		fakeAux(b)
+		IRQHandler()
+	}
+}
+
+func TestInitEnablesAuxAndReporting(t *testing.T) {
+	resetFake()
+	if !Init() || !Present() {
+		t.Fatalf("Init() should find the mouse")
+	}
+	if fakeConfig&configAuxIRQ == 0 || fakeConfig&configAuxClockOff != 0 {
+		t.Fatalf("config = %#x, want aux IRQ on and aux clock enabled", fakeConfig)
+	}
+	if fakeConfig&0x41 != 0x41 {
+		t.Fatalf("config = %#x, keyboard bits must survive", fakeConfig)
+	}
+	if HasWheel() {
+		t.Fatalf("a plain mouse must not report a wheel")
+	}
+	if last := fakeSent[len(fakeSent)-1]; last != devEnableReporting {
+		t.Fatalf("last mouse command = %#x, want enable reporting", last)
+	}
+}
+
+func TestInitFailsWithoutMouse(t *testing.T) {
+	resetFake()
+	fakeAbsent = true
+	if Init() || Present() {
+		t.Fatalf("Init() should fail when the mouse never acknowledges")
+	}
+}
+
+func TestIntelliMouseHandshake(t *testing.T) {
+	resetFake()
+	fakeWheel = true
+	if !Init() || !HasWheel() {
+		t.Fatalf("Init() should detect the wheel")
+	}
+	want := []byte{devSetDefaults, devSetSampleRate, 200, devSetSampleRate, 100, devSetSampleRate, 80, devGetID, devEnableReporting}
+	if string(fakeSent) != string(want) {
+		t.Fatalf("mouse commands = % x, want % x", fakeSent, want)
+	}
+
+	// Wheel byte 0x0F is -1: one notch away from the user
+	feedBytes(packetSync|ButtonMiddle, 3, 2, 0x0F)
+	ev, ok := TryRead()
+	if !ok || ev.DX != 3 || ev.DY != -2 || ev.DZ != -1 || ev.Buttons != ButtonMiddle {
+		t.Fatalf("TryRead() = %+v, %v", ev, ok)
+	}
+}
+
+func TestDecodeSignedDeltasAndButtons(t *testing.T) {
+	resetFake()
+	Init()
+
+	// dx = -2, dy = -16 (down), left and right held
+	feedBytes(packetSync|packetSignX|packetSignY|ButtonLeft|ButtonRight, 0xFE, 0xF0)
+	ev, ok := TryRead()
+	if !ok || ev.DX != -2 || ev.DY != 16 || ev.DZ != 0 || ev.Buttons != ButtonLeft|ButtonRight {
+		t.Fatalf("TryRead() = %+v, %v", ev, ok)
+	}
+	if _, ok := TryRead(); ok {
+		t.Fatalf("queue should be empty")
+	}
+}
+
+func TestDecodeDropsOverflowedMotion(t *testing.T) {
+	resetFake()
+	Init()
+
+	feedBytes(packetSync|0x40|ButtonLeft, 0xFF, 0x10)
+	ev, ok := TryRead()
+	if !ok || ev.DX != 0 || ev.DY != 0 || ev.Buttons != ButtonLeft {
+		t.Fatalf("TryRead() = %+v, %v", ev, ok)
+	}
+}
+
+func TestFeedResynchronizes(t *testing.T) {
+	resetFake()
+	Init()
+
+	// A stray byte without the sync bit is skipped
+	feedBytes(0x05, packetSync, 1, 1)
+	ev, ok := TryRead()
+	if !ok || ev.DX != 1 || ev.DY != -1 {
+		t.Fatalf("TryRead() = %+v, %v", ev, ok)
+	}
+}
+
+func TestIRQHandlerLeavesKeyboardBytes(t *testing.T) {
+	resetFake()
+	Init()
+
+	fakeOut = append(fakeOut, fakeByte{value: 0x1E})
+	IRQHandler()
+	if len(fakeOut) != 1 {
+		t.Fatalf("a keyboard byte must stay for IRQ 1")
+	}
+}
+
+func TestQueueDropsWhenFull(t *testing.T) {
+	resetFake()
+	Init()
+	dropped = 0
+
+	for i := 0; i < eventBufSize; i++ {
+		feedBytes(packetSync, 1, 0)
+	}
+	if Dropped() != 1 {
+		t.Fatalf("Dropped() = %d, want 1", Dropped())
+	}
+	n := 0
+	for {
+		if _, ok := TryRead(); !ok {
+			break
+		}
+		n++
+	}
+	if n != eventBufSize-1 {
+		t.Fatalf("read %d events, want %d", n, eventBufSize-1)
+	}
+}
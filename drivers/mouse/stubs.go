@@ -0,0 +1,90 @@
+//go:build !gccgo
+
+package mouse
+
+// Host builds get a fake i8042 with a mouse on its aux port. Replies queue
+// in fakeOut, each tagged with the device it came from; fakeSent records
+// every byte routed to the mouse. fakeWheel makes the mouse answer the
+// IntelliMouse knock and fakeAbsent leaves aux writes unanswered.
+type fakeByte struct {
+	value byte
+	aux   bool
+}
+
+var (
+	fakeOut    []fakeByte
+	fakeSent   []byte
+	fakeConfig byte
+	fakeWheel  bool
+	fakeAbsent bool
+
+	fakeNext  byte // controller command waiting for its data byte
+	fakeRates []byte
+	fakeRate  bool // the mouse expects a sample rate next
+)
+
+func inb(port uint16) byte {
+	if port == statusPort {
+		if len(fakeOut) == 0 {
+			return 0
+		}
+		if fakeOut[0].aux {
+			return statusOutputFull | statusAuxData
+		}
+		return statusOutputFull
+	}
+	if len(fakeOut) == 0 {
+		return 0
+	}
+	b := fakeOut[0].value
+	fakeOut = fakeOut[1:]
+	return b
+}
+
+func outb(port uint16, value byte) {
+	if port == commandPort {
+		switch value {
+		case cmdReadConfig:
+			fakeOut = append(fakeOut, fakeByte{value: fakeConfig})
+		case cmdWriteConfig, cmdWriteAux:
+			fakeNext = value
+		}
+		return
+	}
+	next := fakeNext
+	fakeNext = 0
+	switch next {
+	case cmdWriteConfig:
+		fakeConfig = value
+	case cmdWriteAux:
+		fakeMouse(value)
+	}
+}
+
+func fakeMouse(b byte) {
+	fakeSent = append(fakeSent, b)
+	if fakeAbsent {
+		return
+	}
+	fakeAux(replyAck)
+	if fakeRate {
+		fakeRate = false
+		fakeRates = append(fakeRates, b)
+		return
+	}
+	switch b {
+	case devSetSampleRate:
+		fakeRate = true
+	case devGetID:
+		n := len(fakeRates)
+		if fakeWheel && n >= 3 && fakeRates[n-3] == 200 && fakeRates[n-2] == 100 && fakeRates[n-1] == 80 {
+			fakeAux(idIntelliMouse)
+		} else {
+			fakeAux(0)
+		}
+	}
+}
+
+func fakeAux(b byte) {
+	fakeOut = append(fakeOut, fakeByte{value: b, aux: true})
+}
@@ -0,0 +1,102 @@
+package netdev
+
+const (
+	MaxDevices = 4
+	AddrLen    = 6
+
+	// MaxFrame is the largest Ethernet frame without FCS; MinFrame is
+	// what drivers pad shorter frames to
+	MaxFrame  = 1514
+	MinFrame  = 60
+	HeaderLen = 14
+	MTU       = MaxFrame - HeaderLen
+)
+
+// Stats counts traffic through one device
+type Stats struct {
+	RxPackets uint64
+	RxBytes   uint64
+	RxDropped uint64
+	TxPackets uint64
+	TxBytes   uint64
+	TxDropped uint64
+}
+
+// Device is a network interface as seen by the protocol stack. Drivers
+// fill one in and Register it. Send queues one frame for transmission and
+// reports false when it cannot (ring full, link down); Poll reaps the
+// driver's rings and hands each received frame to Deliver. Unit is passed
+// back to both so one driver can serve several interfaces.
+type Device struct {
+	Name   string
+	Unit   int
+	MAC    [AddrLen]byte
+	MTU    int
+	LinkUp bool
+	Stats  Stats
+
+	Send func(unit int, frame []byte) bool
+	Poll func(unit int)
+}
+
+var (
+	devices     [MaxDevices]*Device
+	deviceCount int
+	receiver    func(d *Device, frame []byte)
+)
+
+// Register adds d to the interface list
+func Register(d *Device) bool {
+	if d == nil || d.Send == nil || deviceCount >= MaxDevices {
+		return false
+	}
+	devices[deviceCount] = d
+	deviceCount++
+	return true
+}
+
+// Count returns how many interfaces are registered
+func Count() int { return deviceCount }
+
+// At returns the i-th registered interface, or nil
+func At(i int) *Device {
+	if i < 0 || i >= deviceCount {
+		return nil
+	}
+	return devices[i]
+}
+
+// SetReceiver installs the function every received frame is passed to.
+// The frame is only valid until it returns.
+func SetReceiver(fn func(d *Device, frame []byte)) { receiver = fn }
+
+// Transmit sends frame through d and updates its counters
+func Transmit(d *Device, frame []byte) bool {
+	if len(frame) < HeaderLen || len(frame) > MaxFrame || !d.Send(d.Unit, frame) {
+		d.Stats.TxDropped++
+		return false
+	}
+	d.Stats.TxPackets++
+	d.Stats.TxBytes += uint64(len(frame))
+	return true
+}
+
+// Deliver is called by drivers for each frame received on d
+func Deliver(d *Device, frame []byte) {
+	if receiver == nil || len(frame) < HeaderLen {
+		d.Stats.RxDropped++
+		return
+	}
+	d.Stats.RxPackets++
+	d.Stats.RxBytes += uint64(len(frame))
+	receiver(d, frame)
+}
+
+// PollAll lets every driver reap its receive and transmit rings
+func PollAll() {
+	for i := 0; i < deviceCount; i++ {
+		if d := devices[i]; d.Poll != nil {
+			d.Poll(d.Unit)
+		}
+	}
+}
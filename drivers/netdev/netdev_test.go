@@ -0,0 +1,55 @@
+package netdev
+
+import "testing"
+
+var (
+	sent     int
+	received []byte
+	polled   int
+)
+
+func loopSend(unit int, frame []byte) bool { sent++; return unit == 0 }
+func loopPoll(unit int)                    { polled++ }
+func keep(d *Device, frame []byte)         { received = append(received[:0], frame...) }
+
+func TestTransmitAndDeliverCount(t *testing.T) {
+	ResetForTesting()
+	defer ResetForTesting()
+	sent, polled, received = 0, 0, nil
+
+	eth0 := Device{Name: "eth0", Send: loopSend, Poll: loopPoll}
+	eth1 := Device{Name: "eth1", Unit: 1, Send: loopSend}
+	if !Register(&eth0) || !Register(&eth1) {
+		t.Fatalf("Register failed")
+	}
+	if Register(&Device{Name: "bad"}) {
+		t.Fatalf("Register should reject a device without Send")
+	}
+
+	frame := make([]byte, 60)
+	if !Transmit(&eth0, frame) || eth0.Stats.TxPackets != 1 || eth0.Stats.TxBytes != 60 {
+		t.Fatalf("Transmit on eth0: stats %+v", eth0.Stats)
+	}
+	if Transmit(&eth1, frame) || eth1.Stats.TxDropped != 1 {
+		t.Fatalf("a refused send must count as dropped: %+v", eth1.Stats)
+	}
+	if Transmit(&eth0, make([]byte, MaxFrame+1)) || sent != 2 {
+		t.Fatalf("an oversized frame reached the driver (sent=%d)", sent)
+	}
+
+	Deliver(&eth0, frame[:20])
+	if eth0.Stats.RxDropped != 1 {
+		t.Fatalf("a frame with no receiver must count as dropped")
+	}
+	SetReceiver(keep)
+	frame[0] = 0xAA
+	Deliver(&eth0, frame[:20])
+	if len(received) != 20 || received[0] != 0xAA || eth0.Stats.RxPackets != 1 || eth0.Stats.RxBytes != 20 {
+		t.Fatalf("Deliver: got %d bytes, stats %+v", len(received), eth0.Stats)
+	}
+
+	PollAll()
+	if polled != 1 {
+		t.Fatalf("PollAll called %d pollers, want 1", polled)
+	}
+}
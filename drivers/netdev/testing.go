@@ -0,0 +1,12 @@
+//go:build testing
+
+package netdev
+
+// ResetForTesting forgets every interface and the receiver
+func ResetForTesting() {
+	for i := 0; i < MaxDevices; i++ {
+		devices[i] = nil
+	}
+	deviceCount = 0
+	receiver = nil
+}
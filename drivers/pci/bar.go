@@ -0,0 +1,92 @@
+package pci
+
+const (
+	MaxBARs    = 6
+	bridgeBARs = 2
+
+	barIOSpace      = 0x1
+	barTypeMask     = 0x6
+	barType64       = 0x4
+	barPrefetchable = 0x8
+	barIOMask       = 0x3
+	barMemMask      = 0xF
+)
+
+// BARKind is the address space a base address register decodes
+type BARKind uint8
+
+const (
+	BARNone BARKind = iota
+	BARIO
+	BARMem32
+	BARMem64
+)
+
+func (k BARKind) String() string {
+	switch k {
+	case BARIO:
+		return "io"
+	case BARMem32:
+		return "mem32"
+	case BARMem64:
+		return "mem64"
+	}
+	return "none"
+}
+
+// BAR is one decoded base address register. The upper half of a 64-bit
+// BAR is folded into the BAR before it, whose slot it shares.
+type BAR struct {
+	Kind         BARKind
+	Base         uint64
+	Size         uint64
+	Prefetchable bool
+}
+
+// decodeBARs reads the first count BARs of d and sizes them the usual way:
+// write all ones, read back which address bits stick, restore. Decoding is
+// switched off meanwhile so the device never answers at the probe address.
+func decodeBARs(d *Device, count int) {
+	cmd := d.Read16(regCommand)
+	d.Write16(regCommand, cmd&^(CommandIO|CommandMemory))
+
+	for i := 0; i < count; i++ {
+		off := regBAR0 + uint16(i)*4
+		orig, probe := sizeBAR(d, off)
+		if probe == 0 {
+			continue
+		}
+		bar := &d.BARs[i]
+
+		if probe&barIOSpace != 0 {
+			bar.Kind = BARIO
+			bar.Base = uint64(orig &^ barIOMask)
+			// The upper 16 bits of an I/O BAR may read back as zero
+			bar.Size = uint64(^(probe&^barIOMask)+1) & 0xFFFF
+			continue
+		}
+
+		bar.Prefetchable = probe&barPrefetchable != 0
+		if probe&barTypeMask == barType64 && i+1 < count {
+			origHi, probeHi := sizeBAR(d, off+4)
+			bar.Kind = BARMem64
+			bar.Base = uint64(origHi)<<32 | uint64(orig&^barMemMask)
+			bar.Size = ^(uint64(probeHi)<<32 | uint64(probe&^barMemMask)) + 1
+			i++
+			continue
+		}
+		bar.Kind = BARMem32
+		bar.Base = uint64(orig &^ barMemMask)
+		bar.Size = uint64(^(probe &^ barMemMask) + 1)
+	}
+
+	d.Write16(regCommand, cmd)
+}
+
+func sizeBAR(d *Device, off uint16) (orig, probe uint32) {
+	orig = d.Read32(off)
+	d.Write32(off, 0xFFFFFFFF)
+	probe = d.Read32(off)
+	d.Write32(off, orig)
+	return orig, probe
+}
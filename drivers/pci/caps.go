@@ -0,0 +1,124 @@
+package pci
+
+const (
+	MaxCaps = 8
+
+	CapPowerManagement = 0x01
+	CapMSI             = 0x05
+	CapVendorSpecific  = 0x09
+	CapPCIExpress      = 0x10
+	CapMSIX            = 0x11
+
+	statusCapList = 0x10
+
+	// The list lives after the 64-byte header; a walk longer than the
+	// space could hold means the list loops
+	capMinOffset = 0x40
+	maxCapWalk   = 48
+
+	msiControl   = 2
+	msiAddressLo = 4
+	msiAddressHi = 8
+	msiData32    = 8
+	msiData64    = 12
+
+	msiEnable           = 0x0001
+	msiMultipleCapShift = 1
+	msiMultipleCapMask  = 0x7
+	msiMultipleEnable   = 0x0070
+	msi64Bit            = 0x0080
+	msiPerVectorMask    = 0x0100
+
+	// msiAddressBase targets the local APIC of the destination CPU
+	msiAddressBase = 0xFEE00000
+	msiDestShift   = 12
+)
+
+// Capability is one entry of a device's capability list
+type Capability struct {
+	ID     uint8
+	Offset uint8
+}
+
+// MSI describes a device's message signalled interrupt capability.
+// Offset is 0 when the device has none.
+type MSI struct {
+	Offset        uint8
+	Is64Bit       bool
+	PerVectorMask bool
+	// Vectors is how many vectors the device can ask for (1-32)
+	Vectors uint8
+	Enabled bool
+}
+
+func readCapabilities(d *Device) {
+	d.CapCount = 0
+	d.MSI.Offset = 0
+	d.MSI.Is64Bit = false
+	d.MSI.PerVectorMask = false
+	d.MSI.Vectors = 0
+	d.MSI.Enabled = false
+
+	if d.HeaderType > headerBridge || d.Read16(regStatus)&statusCapList == 0 {
+		return
+	}
+	ptr := d.Read8(regCapPointer) &^ 3
+	for walk := 0; ptr >= capMinOffset && walk < maxCapWalk; walk++ {
+		id := d.Read8(uint16(ptr))
+		if d.CapCount < MaxCaps {
+			d.Caps[d.CapCount].ID = id
+			d.Caps[d.CapCount].Offset = ptr
+			d.CapCount++
+		}
+		if id == CapMSI && d.MSI.Offset == 0 {
+			decodeMSI(d, ptr)
+		}
+		ptr = d.Read8(uint16(ptr)+1) &^ 3
+	}
+}
+
+func decodeMSI(d *Device, off uint8) {
+	ctrl := d.Read16(uint16(off) + msiControl)
+	d.MSI.Offset = off
+	d.MSI.Is64Bit = ctrl&msi64Bit != 0
+	d.MSI.PerVectorMask = ctrl&msiPerVectorMask != 0
+	d.MSI.Vectors = 1 << ((ctrl >> msiMultipleCapShift) & msiMultipleCapMask)
+	if d.MSI.Vectors > 32 {
+		d.MSI.Vectors = 32
+	}
+	d.MSI.Enabled = ctrl&msiEnable != 0
+}
+
+// FindCapability returns the configuration space offset of the first
+// capability with the given ID, or 0
+func (d *Device) FindCapability(id uint8) uint8 {
+	for i := 0; i < d.CapCount; i++ {
+		if d.Caps[i].ID == id {
+			return d.Caps[i].Offset
+		}
+	}
+	return 0
+}
+
+// EnableMSI points the device's single MSI vector at the local APIC apicID
+// and turns MSI on, masking legacy INTx. Returns false without MSI.
+func (d *Device) EnableMSI(apicID, vector uint8) bool {
+	if d.MSI.Offset == 0 {
+		return false
+	}
+	base := uint16(d.MSI.Offset)
+	d.Write32(base+msiAddressLo, msiAddressBase|uint32(apicID)<<msiDestShift)
+	data := base + msiData32
+	if d.MSI.Is64Bit {
+		d.Write32(base+msiAddressHi, 0)
+		data = base + msiData64
+	}
+	d.Write16(data, uint16(vector))
+
+	ctrl := d.Read16(base + msiControl)
+	ctrl &^= msiMultipleEnable
+	d.Write16(base+msiControl, ctrl|msiEnable)
+	d.Enable(CommandINTxDisabled)
+	d.MSI.Enabled = true
+	return true
+}
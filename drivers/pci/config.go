@@ -0,0 +1,117 @@
+package pci
+
+import "unsafe"
+
+const (
+	// Configuration mechanism #1: write an address to 0xCF8, then move
+	// the selected dword through 0xCFC-0xCFF
+	configAddress = 0xCF8
+	configData    = 0xCFC
+	configEnable  = 0x80000000
+
+	// portConfigSize is how much of each function's configuration space
+	// mechanism #1 reaches; ECAM exposes the full 4 KiB
+	portConfigSize = 256
+
+	ecamBusShift      = 20
+	ecamSlotShift     = 15
+	ecamFunctionShift = 12
+)
+
+var (
+	ecamBase  uint64
+	ecamStart uint8
+	ecamEnd   uint8
+
+	// ecamLimit is the end of the identity map built at boot: a window
+	// above it cannot be dereferenced, so such buses stay on the ports
+	ecamLimit uint64 = 1 << 32
+)
+
+// setECAM selects memory-mapped configuration for buses startBus..endBus
+// at base (as reported by ACPI MCFG). A zero base, or a window the kernel
+// cannot reach, leaves every bus on mechanism #1.
+func setECAM(base uint64, startBus, endBus uint8) {
+	ecamBase = 0
+	if base == 0 || endBus < startBus {
+		return
+	}
+	if base+(uint64(endBus)+1)<<ecamBusShift > ecamLimit {
+		return
+	}
+	ecamBase = base
+	ecamStart = startBus
+	ecamEnd = endBus
+}
+
+// Mechanism names how configuration space is being accessed: "ecam" or
+// "ports"
+func Mechanism() string {
+	if ecamBase != 0 {
+		return "ecam"
+	}
+	return "ports"
+}
+
+// ecamAddr returns the address of a configuration register through ECAM,
+// or 0 when the bus is outside the window. The MCFG base corresponds to
+// bus 0 even when the window starts at a later bus.
+func ecamAddr(bus, slot, fn uint8, off uint16) uintptr {
+	if ecamBase == 0 || bus < ecamStart || bus > ecamEnd {
+		return 0
+	}
+	return uintptr(ecamBase + uint64(bus)<<ecamBusShift + uint64(slot)<<ecamSlotShift +
+		uint64(fn)<<ecamFunctionShift + uint64(off))
+}
+
+func portAddr(bus, slot, fn uint8, off uint16) uint32 {
+	return configEnable | uint32(bus)<<16 | uint32(slot&0x1F)<<11 | uint32(fn&7)<<8 | uint32(off&0xFC)
+}
+
+func read32(bus, slot, fn uint8, off uint16) uint32 {
+	off &^= 3
+	if p := ecamAddr(bus, slot, fn, off); p != 0 {
+		return *(*uint32)(unsafe.Pointer(p))
+	}
+	if off >= portConfigSize {
+		return 0xFFFFFFFF
+	}
+	outl(configAddress, portAddr(bus, slot, fn, off))
+	return inl(configData)
+}
+
+func read16(bus, slot, fn uint8, off uint16) uint16 {
+	return uint16(read32(bus, slot, fn, off) >> ((off & 2) * 8))
+}
+
+func read8(bus, slot, fn uint8, off uint16) uint8 {
+	return uint8(read32(bus, slot, fn, off) >> ((off & 3) * 8))
+}
+
+func write32(bus, slot, fn uint8, off uint16, v uint32) {
+	off &^= 3
+	if p := ecamAddr(bus, slot, fn, off); p != 0 {
+		*(*uint32)(unsafe.Pointer(p)) = v
+		return
+	}
+	if off >= portConfigSize {
+		return
+	}
+	outl(configAddress, portAddr(bus, slot, fn, off))
+	outl(configData, v)
+}
+
+// write16 stores a 16-bit register without a read-modify-write of its
+// dword, which would write back (and so clear) RW1C status bits
+func write16(bus, slot, fn uint8, off uint16, v uint16) {
+	off &^= 1
+	if p := ecamAddr(bus, slot, fn, off); p != 0 {
+		*(*uint16)(unsafe.Pointer(p)) = v
+		return
+	}
+	if off >= portConfigSize {
+		return
+	}
+	outl(configAddress, portAddr(bus, slot, fn, off))
+	outw(configData+(off&2), v)
+}
@@ -0,0 +1,81 @@
+package pci
+
+// ClassName returns a short description of a class/subclass pair, in the
+// wording lspci uses
+func ClassName(class, subclass uint8) string {
+	switch class {
+	case 0x00:
+		if subclass == 0x01 {
+			return "VGA compatible unclassified device"
+		}
+		return "Unclassified device"
+	case ClassStorage:
+		switch subclass {
+		case 0x00:
+			return "SCSI storage controller"
+		case SubclassIDE:
+			return "IDE interface"
+		case 0x05:
+			return "ATA controller"
+		case 0x06:
+			return "SATA controller"
+		case 0x08:
+			return "Non-Volatile memory controller"
+		}
+		return "Mass storage controller"
+	case ClassNetwork:
+		if subclass == SubclassEthernet {
+			return "Ethernet controller"
+		}
+		return "Network controller"
+	case ClassDisplay:
+		if subclass == 0x00 {
+			return "VGA compatible controller"
+		}
+		return "Display controller"
+	case 0x04:
+		return "Multimedia controller"
+	case 0x05:
+		return "Memory controller"
+	case ClassBridge:
+		switch subclass {
+		case 0x00:
+			return "Host bridge"
+		case 0x01:
+			return "ISA bridge"
+		case SubclassPCIBridge:
+			return "PCI bridge"
+		}
+		return "Bridge"
+	case 0x07:
+		return "Communication controller"
+	case 0x08:
+		return "System peripheral"
+	case 0x0C:
+		switch subclass {
+		case 0x03:
+			return "USB controller"
+		case 0x05:
+			return "SMBus"
+		}
+		return "Serial bus controller"
+	}
+	return "Unknown class"
+}
+
+// CapabilityName returns the short name of a capability ID
+func CapabilityName(id uint8) string {
+	switch id {
+	case CapPowerManagement:
+		return "PM"
+	case CapMSI:
+		return "MSI"
+	case CapVendorSpecific:
+		return "Vendor"
+	case CapPCIExpress:
+		return "PCIe"
+	case CapMSIX:
+		return "MSI-X"
+	}
+	return "?"
+}
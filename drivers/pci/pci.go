@@ -0,0 +1,256 @@
+package pci
+
+const (
+	MaxDevices = 64
+	MaxDrivers = 16
+
+	// AnyID and AnyClass are wildcards in a Driver's match fields
+	AnyID    = 0xFFFF
+	AnyClass = 0xFF
+
+	ClassStorage = 0x01
+	ClassNetwork = 0x02
+	ClassDisplay = 0x03
+	ClassBridge  = 0x06
+
+	SubclassIDE       = 0x01
+	SubclassEthernet  = 0x00
+	SubclassPCIBridge = 0x04
+
+	CommandIO           = 0x0001
+	CommandMemory       = 0x0002
+	CommandBusMaster    = 0x0004
+	CommandINTxDisabled = 0x0400
+
+	regVendor        = 0x00
+	regDevice        = 0x02
+	regCommand       = 0x04
+	regStatus        = 0x06
+	regRevision      = 0x08
+	regProgIF        = 0x09
+	regSubclass      = 0x0A
+	regClass         = 0x0B
+	regHeaderType    = 0x0E
+	regBAR0          = 0x10
+	regSecondaryBus  = 0x19
+	regCapPointer    = 0x34
+	regInterruptLine = 0x3C
+	regInterruptPin  = 0x3D
+
+	invalidVendor = 0xFFFF
+
+	headerTypeMask      = 0x7F
+	headerMultiFunction = 0x80
+	headerGeneral       = 0x00
+	headerBridge        = 0x01
+
+	slotsPerBus      = 32
+	functionsPerSlot = 8
+)
+
+// Device is one PCI function found by Init
+type Device struct {
+	Bus, Slot, Function uint8
+
+	VendorID, DeviceID uint16
+	Class, Subclass    uint8
+	ProgIF, Revision   uint8
+	HeaderType         uint8
+
+	// IRQLine is the legacy PIC line the firmware routed INTx to; IRQPin
+	// is 1-4 for INTA-INTD, 0 when the function uses no INTx pin
+	IRQLine, IRQPin uint8
+
+	BARs     [MaxBARs]BAR
+	Caps     [MaxCaps]Capability
+	CapCount int
+	MSI      MSI
+
+	driver *Driver
+}
+
+// Driver claims devices whose IDs and class match. Each match field can be
+// AnyID / AnyClass.
+type Driver struct {
+	Name string
+
+	VendorID, DeviceID uint16
+	Class, Subclass    uint8
+
+	// Probe is offered each matching unclaimed device; returning false
+	// leaves it for drivers registered later
+	Probe func(d *Device) bool
+}
+
+var (
+	devices     [MaxDevices]Device
+	deviceCount int
+
+	drivers     [MaxDrivers]*Driver
+	driverCount int
+)
+
+// Init selects the configuration access method (ECAM when ecamBase is
+// non-zero, as found in the ACPI MCFG table, else ports 0xCF8/0xCFC) and
+// enumerates every bus reachable from the host bridge, following PCI to
+// PCI bridges. Returns the number of functions found.
+func Init(ecamBase uint64, startBus, endBus uint8) int {
+	setECAM(ecamBase, startBus, endBus)
+	deviceCount = 0
+
+	// A multi-function host bridge means several host controllers, one
+	// root bus per function
+	if read16(0, 0, 0, regVendor) == invalidVendor ||
+		read8(0, 0, 0, regHeaderType)&headerMultiFunction == 0 {
+		scanBus(0, 0)
+		return deviceCount
+	}
+	for fn := uint8(0); fn < functionsPerSlot; fn++ {
+		if read16(0, 0, fn, regVendor) == invalidVendor {
+			continue
+		}
+		scanBus(fn, 0)
+	}
+	return deviceCount
+}
+
+// maxBridgeDepth bounds recursion on firmware that wires a bridge loop
+const maxBridgeDepth = 8
+
+func scanBus(bus uint8, depth int) {
+	for slot := uint8(0); slot < slotsPerBus; slot++ {
+		scanSlot(bus, slot, depth)
+	}
+}
+
+func scanSlot(bus, slot uint8, depth int) {
+	if read16(bus, slot, 0, regVendor) == invalidVendor {
+		return
+	}
+	functions := uint8(1)
+	if read8(bus, slot, 0, regHeaderType)&headerMultiFunction != 0 {
+		functions = functionsPerSlot
+	}
+	for fn := uint8(0); fn < functions; fn++ {
+		if read16(bus, slot, fn, regVendor) == invalidVendor {
+			continue
+		}
+		d := addDevice(bus, slot, fn)
+		if d == nil {
+			return
+		}
+		if d.HeaderType != headerBridge || depth >= maxBridgeDepth {
+			continue
+		}
+		if secondary := d.Read8(regSecondaryBus); secondary > bus {
+			scanBus(secondary, depth+1)
+		}
+	}
+}
+
+func addDevice(bus, slot, fn uint8) *Device {
+	if deviceCount >= MaxDevices {
+		return nil
+	}
+	d := &devices[deviceCount]
+	deviceCount++
+
+	d.Bus, d.Slot, d.Function = bus, slot, fn
+	d.VendorID = d.Read16(regVendor)
+	d.DeviceID = d.Read16(regDevice)
+	d.Class = d.Read8(regClass)
+	d.Subclass = d.Read8(regSubclass)
+	d.ProgIF = d.Read8(regProgIF)
+	d.Revision = d.Read8(regRevision)
+	d.HeaderType = d.Read8(regHeaderType) & headerTypeMask
+	d.IRQLine = d.Read8(regInterruptLine)
+	d.IRQPin = d.Read8(regInterruptPin)
+	d.driver = nil
+
+	for i := 0; i < MaxBARs; i++ {
+		d.BARs[i].Kind = BARNone
+		d.BARs[i].Base = 0
+		d.BARs[i].Size = 0
+		d.BARs[i].Prefetchable = false
+	}
+	switch d.HeaderType {
+	case headerGeneral:
+		decodeBARs(d, MaxBARs)
+	case headerBridge:
+		decodeBARs(d, bridgeBARs)
+	}
+	readCapabilities(d)
+	return d
+}
+
+// Count returns how many functions Init found
+func Count() int { return deviceCount }
+
+// At returns the i-th function found by Init, or nil
+func At(i int) *Device {
+	if i < 0 || i >= deviceCount {
+		return nil
+	}
+	return &devices[i]
+}
+
+// Register adds drv to the registry consulted by Probe. Returns false when
+// the registry is full or drv has no Probe function.
+func Register(drv *Driver) bool {
+	if drv == nil || drv.Probe == nil || driverCount >= MaxDrivers {
+		return false
+	}
+	drivers[driverCount] = drv
+	driverCount++
+	return true
+}
+
+// Probe offers every unclaimed device to the registered drivers in
+// registration order and returns how many devices were claimed
+func Probe() int {
+	claimed := 0
+	for i := 0; i < deviceCount; i++ {
+		d := &devices[i]
+		if d.driver != nil {
+			continue
+		}
+		for j := 0; j < driverCount; j++ {
+			drv := drivers[j]
+			if drv.matches(d) && drv.Probe(d) {
+				d.driver = drv
+				claimed++
+				break
+			}
+		}
+	}
+	return claimed
+}
+
+func (drv *Driver) matches(d *Device) bool {
+	return (drv.VendorID == AnyID || drv.VendorID == d.VendorID) &&
+		(drv.DeviceID == AnyID || drv.DeviceID == d.DeviceID) &&
+		(drv.Class == AnyClass || drv.Class == d.Class) &&
+		(drv.Subclass == AnyClass || drv.Subclass == d.Subclass)
+}
+
+// DriverName returns the name of the driver that claimed d, or "" when
+// none did
+func (d *Device) DriverName() string {
+	if d.driver == nil {
+		return ""
+	}
+	return d.driver.Name
+}
+
+func (d *Device) Read32(off uint16) uint32 { return read32(d.Bus, d.Slot, d.Function, off) }
+func (d *Device) Read16(off uint16) uint16 { return read16(d.Bus, d.Slot, d.Function, off) }
+func (d *Device) Read8(off uint16) uint8   { return read8(d.Bus, d.Slot, d.Function, off) }
+
+func (d *Device) Write32(off uint16, v uint32) { write32(d.Bus, d.Slot, d.Function, off, v) }
+func (d *Device) Write16(off uint16, v uint16) { write16(d.Bus, d.Slot, d.Function, off, v) }
+
+// Enable sets bits (Command*) in the command register, e.g. to turn on
+// bus mastering before a device can DMA
+func (d *Device) Enable(bits uint16) {
+	d.Write16(regCommand, d.Read16(regCommand)|bits)
+}
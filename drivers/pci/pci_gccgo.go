@@ -0,0 +1,8 @@
+//go:build gccgo
+
+package pci
+
+// Implemented in boot/stubs_amd64.s
+func inl(port uint16) uint32
+func outl(port uint16, value uint32)
+func outw(port uint16, value uint16)
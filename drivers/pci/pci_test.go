@@ -0,0 +1,237 @@
+package pci
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func find(t *testing.T, bus, slot, fn uint8) *Device {
+	t.Helper()
+	for i := 0; i < Count(); i++ {
+		if d := At(i); d.Bus == bus && d.Slot == slot && d.Function == fn {
+			return d
+		}
+	}
+	t.Fatalf("no device at %02x:%02x.%d", bus, slot, fn)
+	return nil
+}
+
+func resetRegistry() {
+	for i := range drivers {
+		drivers[i] = nil
+	}
+	driverCount = 0
+}
+
+func TestInitEnumeratesDefaultMachine(t *testing.T) {
+	resetFakeBus()
+	defaultFakeMachine()
+
+	if n := Init(0, 0, 0); n != 6 {
+		t.Fatalf("Init found %d functions, want 6", n)
+	}
+	if Mechanism() != "ports" {
+		t.Fatalf("Mechanism() = %q, want ports", Mechanism())
+	}
+
+	// 00:01 is multi-function: functions 1 and 3 must be found, 2 skipped
+	ide := find(t, 0, 1, 1)
+	if ide.VendorID != 0x8086 || ide.DeviceID != 0x7010 || ide.Class != ClassStorage ||
+		ide.Subclass != SubclassIDE || ide.ProgIF != 0x80 {
+		t.Fatalf("IDE function decoded as %+v", *ide)
+	}
+	find(t, 0, 1, 3)
+
+	nic := find(t, 0, 3, 0)
+	if nic.IRQLine != 11 || nic.IRQPin != 1 {
+		t.Fatalf("NIC IRQ line/pin = %d/%d, want 11/1", nic.IRQLine, nic.IRQPin)
+	}
+}
+
+func TestBARDecoding(t *testing.T) {
+	resetFakeBus()
+	f := addFake(0, 4, 0, 0x1AF4, 0x1001, ClassStorage, 0x00, 0)
+	f.bar(0, 0xC080|barIOSpace, 128)
+	f.bar(1, 0xFEBD0000, 4096)
+	f.bar64(4, 0x800000000|barPrefetchable, 16<<10)
+	f.cfg[regCommand/4] = CommandIO | CommandMemory
+
+	Init(0, 0, 0)
+	d := find(t, 0, 4, 0)
+
+	want := [MaxBARs]BAR{
+		{Kind: BARIO, Base: 0xC080, Size: 128},
+		{Kind: BARMem32, Base: 0xFEBD0000, Size: 4096},
+		{},
+		{},
+		{Kind: BARMem64, Base: 0x800000000, Size: 16 << 10, Prefetchable: true},
+		{},
+	}
+	for i := range want {
+		if d.BARs[i] != want[i] {
+			t.Errorf("BAR%d = %+v, want %+v", i, d.BARs[i], want[i])
+		}
+	}
+
+	// Sizing must put the original BARs and command register back
+	if f.cfg[regBAR0/4] != 0xC080|barIOSpace || f.cfg[regBAR0/4+1] != 0xFEBD0000 {
+		t.Fatalf("BARs not restored after sizing: 0x%x 0x%x", f.cfg[regBAR0/4], f.cfg[regBAR0/4+1])
+	}
+	if f.cfg[regCommand/4]&0xFFFF != CommandIO|CommandMemory {
+		t.Fatalf("command register not restored: 0x%x", f.cfg[regCommand/4])
+	}
+}
+
+func TestCapabilitiesAndMSI(t *testing.T) {
+	resetFakeBus()
+	f := addFake(0, 5, 0, 0x8086, 0x10D3, ClassNetwork, SubclassEthernet, 0)
+	f.capability(0xC8, CapPowerManagement, 0)
+	f.capability(0xD0, CapMSI, msi64Bit|2<<msiMultipleCapShift)
+	f.capability(0xE0, CapPCIExpress, 0)
+	// MSI address, upper address and data registers are writable
+	f.wmask[0xD0/4] = uint32(msiEnable|msiMultipleEnable) << 16
+	f.wmask[0xD4/4] = 0xFFFFFFFC
+	f.wmask[0xD8/4] = 0xFFFFFFFF
+	f.wmask[0xDC/4] = 0xFFFF
+
+	Init(0, 0, 0)
+	d := find(t, 0, 5, 0)
+
+	if d.CapCount != 3 {
+		t.Fatalf("found %d capabilities, want 3", d.CapCount)
+	}
+	if off := d.FindCapability(CapMSI); off != 0xD0 {
+		t.Fatalf("FindCapability(MSI) = 0x%x, want 0xD0", off)
+	}
+	if d.FindCapability(CapMSIX) != 0 {
+		t.Fatalf("FindCapability should not find an absent capability")
+	}
+	if !d.MSI.Is64Bit || d.MSI.Vectors != 4 || d.MSI.Enabled {
+		t.Fatalf("MSI decoded as %+v", d.MSI)
+	}
+
+	if !d.EnableMSI(1, 0x50) {
+		t.Fatalf("EnableMSI failed")
+	}
+	if got := f.cfg[0xD4/4]; got != 0xFEE01000 {
+		t.Fatalf("MSI address = 0x%x, want 0xFEE01000", got)
+	}
+	if got := f.cfg[0xDC/4] & 0xFFFF; got != 0x50 {
+		t.Fatalf("MSI data = 0x%x, want 0x50", got)
+	}
+	if f.cfg[0xD0/4]>>16&msiEnable == 0 {
+		t.Fatalf("MSI enable bit not set")
+	}
+	if f.cfg[regCommand/4]&CommandINTxDisabled == 0 {
+		t.Fatalf("EnableMSI should mask INTx")
+	}
+}
+
+func TestCapabilityLoopIsBounded(t *testing.T) {
+	resetFakeBus()
+	f := addFake(0, 6, 0, 0x1234, 0x5678, 0x08, 0x80, 0)
+	f.capability(0x40, CapVendorSpecific, 0)
+	f.cfg[0x40/4] |= 0x40 << 8 // points at itself
+
+	Init(0, 0, 0)
+	if d := find(t, 0, 6, 0); d.CapCount != MaxCaps {
+		t.Fatalf("CapCount = %d, want the %d-entry cap", d.CapCount, MaxCaps)
+	}
+}
+
+func TestBridgeSecondaryBusIsScanned(t *testing.T) {
+	resetFakeBus()
+	addFake(0, 0, 0, 0x8086, 0x29C0, ClassBridge, 0x00, 0)
+	bridge := addFake(0, 0x1E, 0, 0x8086, 0x244E, ClassBridge, SubclassPCIBridge, 0)
+	bridge.cfg[regHeaderType/4] |= headerBridge << 16
+	bridge.cfg[0x18/4] = 0x00020200 // primary 0, secondary 2, subordinate 2
+	addFake(2, 0, 0, 0x1AF4, 0x1000, ClassNetwork, SubclassEthernet, 0)
+
+	if n := Init(0, 0, 0); n != 3 {
+		t.Fatalf("Init found %d functions, want 3", n)
+	}
+	find(t, 2, 0, 0)
+}
+
+func TestECAMAccess(t *testing.T) {
+	resetFakeBus()
+	// One bus of ECAM space: 32 slots x 8 functions x 4 KiB
+	space := make([]uint32, 1<<ecamBusShift/4)
+	for i := range space {
+		space[i] = 0xFFFFFFFF
+	}
+	cfg := space[3<<ecamSlotShift/4:]
+	for i := 0; i < 64; i++ {
+		cfg[i] = 0
+	}
+	cfg[regVendor/4] = 0x10001B36
+	cfg[regRevision/4] = 0x01080200
+	cfg[0x100/4] = 0xCAFE0001 // extended configuration space
+
+	base := uint64(uintptr(unsafe.Pointer(&space[0])))
+	saved := ecamLimit
+	ecamLimit = ^uint64(0)
+	defer func() { ecamLimit = saved }()
+
+	if n := Init(base, 0, 0); n != 1 {
+		t.Fatalf("Init over ECAM found %d functions, want 1", n)
+	}
+	if Mechanism() != "ecam" {
+		t.Fatalf("Mechanism() = %q, want ecam", Mechanism())
+	}
+	d := find(t, 0, 3, 0)
+	if d.VendorID != 0x1B36 || d.DeviceID != 0x1000 || d.Class != 0x01 || d.Subclass != 0x08 {
+		t.Fatalf("ECAM device decoded as %+v", *d)
+	}
+	if got := d.Read32(0x100); got != 0xCAFE0001 {
+		t.Fatalf("extended config read = 0x%x, want 0xCAFE0001", got)
+	}
+
+	ecamLimit = saved
+	setECAM(1<<32, 0, 0)
+	if Mechanism() != "ports" {
+		t.Fatalf("an ECAM window above the identity map must not be used")
+	}
+}
+
+var probed []uint16
+
+func probeAccept(d *Device) bool { probed = append(probed, d.DeviceID); return true }
+func probeReject(d *Device) bool { return false }
+
+func TestRegistryMatchesAndProbes(t *testing.T) {
+	resetFakeBus()
+	defaultFakeMachine()
+	resetRegistry()
+	defer resetRegistry()
+	probed = nil
+	Init(0, 0, 0)
+
+	picky := Driver{Name: "picky", VendorID: 0x8086, DeviceID: 0x100E, Class: AnyClass, Subclass: AnyClass, Probe: probeReject}
+	nic := Driver{Name: "e1000", VendorID: 0x8086, DeviceID: 0x100E, Class: AnyClass, Subclass: AnyClass, Probe: probeAccept}
+	ide := Driver{Name: "ide", VendorID: AnyID, DeviceID: AnyID, Class: ClassStorage, Subclass: SubclassIDE, Probe: probeAccept}
+	if !Register(&picky) || !Register(&nic) || !Register(&ide) {
+		t.Fatalf("Register failed")
+	}
+	if Register(&Driver{Name: "broken"}) {
+		t.Fatalf("Register should reject a driver without Probe")
+	}
+
+	if n := Probe(); n != 2 {
+		t.Fatalf("Probe claimed %d devices, want 2", n)
+	}
+	if got := find(t, 0, 3, 0).DriverName(); got != "e1000" {
+		t.Fatalf("NIC bound to %q, want e1000 after picky declined", got)
+	}
+	if got := find(t, 0, 1, 1).DriverName(); got != "ide" {
+		t.Fatalf("IDE bound to %q, want ide", got)
+	}
+	if got := find(t, 0, 2, 0).DriverName(); got != "" {
+		t.Fatalf("VGA bound to %q, want no driver", got)
+	}
+
+	// Claimed devices are not offered again
+	if n := Probe(); n != 0 || len(probed) != 2 {
+		t.Fatalf("second Probe claimed %d, %d probe calls in total", n, len(probed))
+	}
+}
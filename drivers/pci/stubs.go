@@ -0,0 +1,150 @@
+//go:build !gccgo
+
+package pci
+
+// Host builds get a fake bus behind the mechanism #1 ports. It starts out
+// as QEMU's default i440fx machine; tests rebuild it with resetFakeBus and
+// addFake. Only bits set in a register's wmask are writable, which is
+// what BAR sizing relies on.
+type fakeFunction struct {
+	bus, slot, fn uint8
+	cfg           [portConfigSize / 4]uint32
+	wmask         [portConfigSize / 4]uint32
+}
+
+var (
+	fakeBus   []*fakeFunction
+	fakeLatch uint32
+)
+
+func init() {
+	resetFakeBus()
+	defaultFakeMachine()
+}
+
+func resetFakeBus() {
+	fakeBus = nil
+	fakeLatch = 0
+}
+
+func defaultFakeMachine() {
+	addFake(0, 0, 0, 0x8086, 0x1237, ClassBridge, 0x00, 0)
+	isa := addFake(0, 1, 0, 0x8086, 0x7000, ClassBridge, 0x01, 0)
+	isa.cfg[regHeaderType/4] |= headerMultiFunction << 16
+	ide := addFake(0, 1, 1, 0x8086, 0x7010, ClassStorage, SubclassIDE, 0x80)
+	ide.bar(4, 0xC040|barIOSpace, 16)
+	addFake(0, 1, 3, 0x8086, 0x7113, ClassBridge, 0x80, 0)
+	vga := addFake(0, 2, 0, 0x1234, 0x1111, ClassDisplay, 0x00, 0)
+	vga.bar(0, 0xFD000000|barPrefetchable, 16<<20)
+	vga.bar(2, 0xFEBF0000, 4096)
+	nic := addFake(0, 3, 0, 0x8086, 0x100E, ClassNetwork, SubclassEthernet, 0)
+	nic.bar(0, 0xFEBC0000, 128<<10)
+	nic.bar(1, 0xC000|barIOSpace, 64)
+	nic.irq(11, 1)
+}
+
+func addFake(bus, slot, fn uint8, vendor, device uint16, class, subclass, progIF uint8) *fakeFunction {
+	f := &fakeFunction{bus: bus, slot: slot, fn: fn}
+	f.cfg[regVendor/4] = uint32(device)<<16 | uint32(vendor)
+	f.cfg[regRevision/4] = uint32(class)<<24 | uint32(subclass)<<16 | uint32(progIF)<<8
+	f.wmask[regCommand/4] = CommandIO | CommandMemory | CommandBusMaster | CommandINTxDisabled
+	fakeBus = append(fakeBus, f)
+	return f
+}
+
+// bar installs BAR i with the given initial value (type bits included)
+// and a power-of-two size
+func (f *fakeFunction) bar(i int, value uint32, size uint32) {
+	reg := (regBAR0 + i*4) / 4
+	low := uint32(barMemMask)
+	if value&barIOSpace != 0 {
+		low = barIOMask
+	}
+	f.cfg[reg] = value
+	f.wmask[reg] = ^(size - 1) &^ low
+}
+
+// bar64 installs a 64-bit memory BAR in slots i and i+1
+func (f *fakeFunction) bar64(i int, base uint64, size uint64) {
+	reg := (regBAR0 + i*4) / 4
+	f.cfg[reg] = uint32(base) | barType64
+	f.cfg[reg+1] = uint32(base >> 32)
+	mask := ^(size - 1)
+	f.wmask[reg] = uint32(mask) &^ barMemMask
+	f.wmask[reg+1] = uint32(mask >> 32)
+}
+
+func (f *fakeFunction) irq(line, pin uint8) {
+	f.cfg[regInterruptLine/4] = uint32(pin)<<8 | uint32(line)
+}
+
+// capability appends a capability with the given ID and 16-bit control
+// word at off, linking it to the end of the list
+func (f *fakeFunction) capability(off, id uint8, control uint16) {
+	f.cfg[regStatus/4] |= statusCapList << 16
+	f.cfg[off/4] = uint32(control)<<16 | uint32(id)
+	if f.cfg[regCapPointer/4] == 0 {
+		f.cfg[regCapPointer/4] = uint32(off)
+		return
+	}
+	ptr := uint8(f.cfg[regCapPointer/4])
+	for {
+		next := uint8(f.cfg[ptr/4] >> 8)
+		if next == 0 {
+			f.cfg[ptr/4] |= uint32(off) << 8
+			return
+		}
+		ptr = next
+	}
+}
+
+func fakeSelected() (*fakeFunction, int) {
+	if fakeLatch&configEnable == 0 {
+		return nil, 0
+	}
+	bus := uint8(fakeLatch >> 16)
+	slot := uint8(fakeLatch>>11) & 0x1F
+	fn := uint8(fakeLatch>>8) & 7
+	for _, f := range fakeBus {
+		if f.bus == bus && f.slot == slot && f.fn == fn {
+			return f, int(fakeLatch&0xFC) / 4
+		}
+	}
+	return nil, 0
+}
+
+func (f *fakeFunction) write(reg int, v, lanes uint32) {
+	m := f.wmask[reg] & lanes
+	f.cfg[reg] = f.cfg[reg]&^m | v&m
+}
+
+func inl(port uint16) uint32 {
+	if port != configData {
+		return 0xFFFFFFFF
+	}
+	f, reg := fakeSelected()
+	if f == nil {
+		return 0xFFFFFFFF
+	}
+	return f.cfg[reg]
+}
+
+func outl(port uint16, value uint32) {
+	if port == configAddress {
+		fakeLatch = value
+		return
+	}
+	if f, reg := fakeSelected(); f != nil && port == configData {
+		f.write(reg, value, 0xFFFFFFFF)
+	}
+}
+
+func outw(port uint16, value uint16) {
+	if port < configData || port > configData+2 {
+		return
+	}
+	if f, reg := fakeSelected(); f != nil {
+		shift := uint32(port-configData) * 8
+		f.write(reg, uint32(value)<<shift, 0xFFFF<<shift)
+	}
+}
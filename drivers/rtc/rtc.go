@@ -0,0 +1,277 @@
+package rtc
+
+import ktime "github.com/dmarro89/go-dav-os/kernel/time"
+
+const (
+	cmosIndexPort = 0x70
+	cmosDataPort  = 0x71
+
+	regSeconds = 0x00
+	regMinutes = 0x02
+	regHours   = 0x04
+	regDay     = 0x07
+	regMonth   = 0x08
+	regYear    = 0x09
+	regStatusA = 0x0A
+	regStatusB = 0x0B
+
+	statusAUpdating = 0x80 // update in progress, registers are not stable
+	statusB24Hour   = 0x02
+	statusBBinary   = 0x04
+	hourPM          = 0x80
+
+	// Bounds the busy-waits so a missing or stuck RTC cannot hang boot
+	updateSpinLimit = 1000000
+	readAttempts    = 8
+
+	secondsPerDay = 86400
+)
+
+// Time is a calendar date and time as kept by the CMOS clock
+type Time struct {
+	Year   uint16
+	Month  uint8 // 1-12
+	Day    uint8 // 1-31
+	Hour   uint8 // 0-23
+	Minute uint8
+	Second uint8
+}
+
+// rawTime holds the registers exactly as read, before BCD/12h decoding
+type rawTime struct {
+	second, minute, hour, day, month, year, century byte
+}
+
+var (
+	centuryReg byte
+
+	ready     bool
+	bootUnix  uint64
+	bootNanos uint64
+)
+
+func readCMOS(reg byte) byte {
+	outb(cmosIndexPort, reg)
+	return inb(cmosDataPort)
+}
+
+func updating() bool {
+	return readCMOS(regStatusA)&statusAUpdating != 0
+}
+
+func readRaw() rawTime {
+	var r rawTime
+	r.second = readCMOS(regSeconds)
+	r.minute = readCMOS(regMinutes)
+	r.hour = readCMOS(regHours)
+	r.day = readCMOS(regDay)
+	r.month = readCMOS(regMonth)
+	r.year = readCMOS(regYear)
+	if centuryReg != 0 {
+		r.century = readCMOS(centuryReg)
+	}
+	return r
+}
+
+// Read returns the current CMOS time. An update can start between two
+// register reads, so the registers are read until two passes agree.
+func Read() (Time, bool) {
+	var last rawTime
+	haveLast := false
+
+	for attempt := 0; attempt < readAttempts; attempt++ {
+		spins := 0
+		for updating() {
+			spins++
+			if spins >= updateSpinLimit {
+				return Time{}, false
+			}
+		}
+
+		cur := readRaw()
+		if haveLast && sameRaw(cur, last) {
+			t := decode(cur, readCMOS(regStatusB))
+			return t, t.valid()
+		}
+		last = cur
+		haveLast = true
+	}
+	return Time{}, false
+}
+
+// sameRaw compares field by field: struct == lowers to runtime.memequal,
+// which the freestanding runtime stubs out
+func sameRaw(a, b rawTime) bool {
+	return a.second == b.second && a.minute == b.minute && a.hour == b.hour &&
+		a.day == b.day && a.month == b.month && a.year == b.year &&
+		a.century == b.century
+}
+
+func bcdToBinary(v byte) byte {
+	return (v>>4)*10 + v&0x0F
+}
+
+// decode converts raw registers to a Time according to status register B
+func decode(r rawTime, statusB byte) Time {
+	pm := r.hour&hourPM != 0
+	hour := r.hour &^ hourPM
+
+	if statusB&statusBBinary == 0 {
+		r.second = bcdToBinary(r.second)
+		r.minute = bcdToBinary(r.minute)
+		hour = bcdToBinary(hour)
+		r.day = bcdToBinary(r.day)
+		r.month = bcdToBinary(r.month)
+		r.year = bcdToBinary(r.year)
+		r.century = bcdToBinary(r.century)
+	}
+
+	if statusB&statusB24Hour == 0 {
+		// 12 AM is midnight and 12 PM is noon
+		if hour == 12 {
+			hour = 0
+		}
+		if pm {
+			hour += 12
+		}
+	}
+
+	year := uint16(r.year)
+	if r.century != 0 {
+		year += uint16(r.century) * 100
+	} else {
+		year += 2000
+	}
+
+	return Time{
+		Year:   year,
+		Month:  r.month,
+		Day:    r.day,
+		Hour:   hour,
+		Minute: r.minute,
+		Second: r.second,
+	}
+}
+
+func (t Time) valid() bool {
+	return t.Month >= 1 && t.Month <= 12 &&
+		t.Day >= 1 && t.Day <= daysIn(t.Year, t.Month) &&
+		t.Hour < 24 && t.Minute < 60 && t.Second < 60
+}
+
+func isLeap(year uint16) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+func daysIn(year uint16, month uint8) uint8 {
+	switch month {
+	case 2:
+		if isLeap(year) {
+			return 29
+		}
+		return 28
+	case 4, 6, 9, 11:
+		return 30
+	default:
+		return 31
+	}
+}
+
+// Init reads the CMOS clock once and anchors wall-clock time to the
+// monotonic clock, so Now advances with kernel/time rather than polling the
+// slow CMOS ports. century is the CMOS century register from the ACPI FADT,
+// or 0 when the firmware does not provide one (years are then 20xx).
+func Init(century byte) bool {
+	centuryReg = century
+	ready = false
+
+	t, ok := Read()
+	if !ok {
+		return false
+	}
+	anchor(t, ktime.Nanotime())
+	return true
+}
+
+func anchor(t Time, mono uint64) {
+	bootUnix = toUnix(t)
+	bootNanos = mono
+	ready = true
+}
+
+// Ready reports whether Init managed to read the CMOS clock
+func Ready() bool { return ready }
+
+// Now returns the current wall-clock time
+func Now() (Time, bool) {
+	if !ready {
+		return Time{}, false
+	}
+	return fromUnix(unixAt(ktime.Nanotime())), true
+}
+
+// Unix returns the current time as seconds since 1970-01-01 00:00:00
+func Unix() (uint64, bool) {
+	if !ready {
+		return 0, false
+	}
+	return unixAt(ktime.Nanotime()), true
+}
+
+func unixAt(mono uint64) uint64 {
+	if mono < bootNanos {
+		return bootUnix
+	}
+	return bootUnix + (mono-bootNanos)/ktime.NanosPerSecond
+}
+
+// daysFromCivil counts days since 1970-01-01 (Howard Hinnant's algorithm,
+// shifted so the year starts in March and leap days fall at its end)
+func daysFromCivil(year uint16, month, day uint8) uint64 {
+	y := uint64(year)
+	m := uint64(month)
+	if m <= 2 {
+		y--
+		m += 12
+	}
+	era := y / 400
+	yoe := y - era*400
+	doy := (153*(m-3)+2)/5 + uint64(day) - 1
+	doe := yoe*365 + yoe/4 - yoe/100 + doy
+	return era*146097 + doe - 719468
+}
+
+func toUnix(t Time) uint64 {
+	days := daysFromCivil(t.Year, t.Month, t.Day)
+	return days*secondsPerDay + uint64(t.Hour)*3600 + uint64(t.Minute)*60 + uint64(t.Second)
+}
+
+func fromUnix(secs uint64) Time {
+	days := secs / secondsPerDay
+	rem := secs % secondsPerDay
+
+	z := days + 719468
+	era := z / 146097
+	doe := z - era*146097
+	yoe := (doe - doe/1460 + doe/36524 - doe/146096) / 365
+	doy := doe - (365*yoe + yoe/4 - yoe/100)
+	mp := (5*doy + 2) / 153
+	day := doy - (153*mp+2)/5 + 1
+	month := mp + 3
+	if month > 12 {
+		month -= 12
+	}
+	year := yoe + era*400
+	if month <= 2 {
+		year++
+	}
+
+	return Time{
+		Year:   uint16(year),
+		Month:  uint8(month),
+		Day:    uint8(day),
+		Hour:   uint8(rem / 3600),
+		Minute: uint8(rem % 3600 / 60),
+		Second: uint8(rem % 60),
+	}
+}
@@ -0,0 +1,7 @@
+//go:build gccgo
+
+package rtc
+
+// Implemented in boot/stubs_amd64.s
+func inb(port uint16) byte
+func outb(port uint16, value byte)
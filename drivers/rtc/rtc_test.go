@@ -0,0 +1,95 @@
+package rtc
+
+import "testing"
+
+func setCMOS(sec, min, hour, day, month, year, statusB byte) {
+	fakeCMOS = [128]byte{}
+	fakeCMOS[regSeconds] = sec
+	fakeCMOS[regMinutes] = min
+	fakeCMOS[regHours] = hour
+	fakeCMOS[regDay] = day
+	fakeCMOS[regMonth] = month
+	fakeCMOS[regYear] = year
+	fakeCMOS[regStatusB] = statusB
+}
+
+func TestReadDecodesBCD24Hour(t *testing.T) {
+	centuryReg = 0
+	setCMOS(0x59, 0x07, 0x23, 0x18, 0x10, 0x26, statusB24Hour)
+
+	got, ok := Read()
+	want := Time{Year: 2026, Month: 10, Day: 18, Hour: 23, Minute: 7, Second: 59}
+	if !ok || got != want {
+		t.Fatalf("Read() = %+v, %v; want %+v", got, ok, want)
+	}
+}
+
+func TestReadDecodesBinary12HourAndCentury(t *testing.T) {
+	setCMOS(5, 30, 12|hourPM, 29, 2, 24, statusBBinary)
+	fakeCMOS[0x32] = 20
+	centuryReg = 0x32
+	defer func() { centuryReg = 0 }()
+
+	got, ok := Read()
+	want := Time{Year: 2024, Month: 2, Day: 29, Hour: 12, Minute: 30, Second: 5}
+	if !ok || got != want {
+		t.Fatalf("Read() = %+v, %v; want %+v", got, ok, want)
+	}
+
+	fakeCMOS[regHours] = 12 // 12 AM
+	got, _ = Read()
+	if got.Hour != 0 {
+		t.Fatalf("12 AM decoded as hour %d, want 0", got.Hour)
+	}
+	fakeCMOS[regHours] = 7 | hourPM
+	got, _ = Read()
+	if got.Hour != 19 {
+		t.Fatalf("7 PM decoded as hour %d, want 19", got.Hour)
+	}
+}
+
+func TestReadFailsWhileStuckUpdating(t *testing.T) {
+	setCMOS(0, 0, 0, 1, 1, 0x26, statusB24Hour)
+	fakeCMOS[regStatusA] = statusAUpdating
+	if _, ok := Read(); ok {
+		t.Fatalf("Read should give up while the update flag never clears")
+	}
+}
+
+func TestReadRejectsGarbage(t *testing.T) {
+	setCMOS(0, 0, 0, 0x31, 0x02, 0x26, statusB24Hour) // 31 February
+	if _, ok := Read(); ok {
+		t.Fatalf("Read should reject an impossible date")
+	}
+}
+
+func TestUnixRoundTrip(t *testing.T) {
+	tests := []struct {
+		t    Time
+		unix uint64
+	}{
+		{Time{Year: 1970, Month: 1, Day: 1}, 0},
+		{Time{Year: 2000, Month: 2, Day: 29, Hour: 12}, 951825600},
+		{Time{Year: 2026, Month: 10, Day: 18, Hour: 23, Minute: 7, Second: 59}, 1792364879},
+	}
+	for _, tt := range tests {
+		if got := toUnix(tt.t); got != tt.unix {
+			t.Errorf("toUnix(%+v) = %d, want %d", tt.t, got, tt.unix)
+		}
+		if got := fromUnix(tt.unix); got != tt.t {
+			t.Errorf("fromUnix(%d) = %+v, want %+v", tt.unix, got, tt.t)
+		}
+	}
+}
+
+func TestWallClockFollowsMonotonicTime(t *testing.T) {
+	anchor(Time{Year: 2026, Month: 12, Day: 31, Hour: 23, Minute: 59, Second: 58}, 1000)
+	defer func() { ready = false }()
+
+	if got := fromUnix(unixAt(1000 + 3*1000000000)); got != (Time{Year: 2027, Month: 1, Day: 1, Hour: 0, Minute: 0, Second: 1}) {
+		t.Fatalf("3s after anchor = %+v, want 2027-01-01 00:00:01", got)
+	}
+	if unixAt(0) != bootUnix {
+		t.Fatalf("wall clock should not go back before its anchor")
+	}
+}
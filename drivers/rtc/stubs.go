@@ -0,0 +1,23 @@
+//go:build !gccgo
+
+package rtc
+
+// Host builds get a fake CMOS: tests fill fakeCMOS and the index/data port
+// pair reads it back like the real chip
+var (
+	fakeCMOS  [128]byte
+	fakeIndex byte
+)
+
+func inb(port uint16) byte {
+	if port == cmosDataPort {
+		return fakeCMOS[fakeIndex&0x7F]
+	}
+	return 0
+}
+
+func outb(port uint16, value byte) {
+	if port == cmosIndexPort {
+		fakeIndex = value
+	}
+}
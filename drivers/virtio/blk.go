@@ -0,0 +1,222 @@
+package virtio
+
+import (
+	"unsafe"
+
+	"github.com/dmarro89/go-dav-os/drivers/block"
+	"github.com/dmarro89/go-dav-os/drivers/pci"
+)
+
+const (
+	MaxDisks = 2
+
+	blkRequestIn  = 0
+	blkRequestOut = 1
+	blkStatusOK   = 0
+
+	blkFeatureReadOnly = 5
+	blkConfigCapacity  = 0
+
+	// maxRequestSectors bounds the data descriptor of one request; larger
+	// transfers are split
+	maxRequestSectors = 128
+
+	// requestTimeout is how many polls of the used ring a request gets
+	requestTimeout = 10000000
+
+	// queueAreaSize fits a MaxQueueSize queue plus the slack needed to
+	// align it
+	queueAreaSize = 4 * queueAlign
+)
+
+// blkHeader is the device-readable first part of every request
+type blkHeader struct {
+	typ      uint32
+	reserved uint32
+	sector   uint64
+}
+
+type disk struct {
+	t transport
+	q queue
+
+	header blkHeader
+	status uint8
+	// broken is set when a request timed out: its descriptors may still
+	// be in the device's hands, so the queue is never used again
+	broken bool
+
+	dev block.Device
+}
+
+var (
+	disks     [MaxDisks]disk
+	diskCount int
+	queueArea [MaxDisks][queueAreaSize]byte
+	diskNames = [MaxDisks]string{"vda", "vdb"}
+)
+
+// BlkDriver claims virtio block devices, transitional or modern-only.
+// Each one found is registered as block device vda, vdb, ...
+var BlkDriver = pci.Driver{
+	Name:     "virtio-blk",
+	VendorID: VendorID,
+	DeviceID: pci.AnyID,
+	Class:    pci.AnyClass,
+	Subclass: pci.AnyClass,
+	Probe:    probeBlk,
+}
+
+// DiskCount returns how many virtio block devices were brought up
+func DiskCount() int { return diskCount }
+
+func probeBlk(d *pci.Device) bool {
+	if d.DeviceID != DeviceBlkLegacy && d.DeviceID != DeviceBlkModern {
+		return false
+	}
+	if diskCount >= MaxDisks {
+		return false
+	}
+	k := &disks[diskCount]
+
+	// Prefer the modern interface; transitional devices also offer the
+	// legacy one in I/O BAR0
+	if !k.t.setupModern(d) && (d.DeviceID != DeviceBlkLegacy || !k.t.setupLegacy(d)) {
+		return false
+	}
+	d.Enable(pci.CommandIO | pci.CommandMemory | pci.CommandBusMaster)
+	if !k.init(&queueArea[diskCount]) {
+		return false
+	}
+
+	k.dev.Name = diskNames[diskCount]
+	k.dev.Unit = diskCount
+	k.dev.Read = blkRead
+	k.dev.Write = blkWrite
+	diskCount++
+	block.Register(&k.dev)
+	return true
+}
+
+// init runs the virtio initialisation sequence: reset, acknowledge,
+// negotiate features, set up request queue 0, go live
+func (k *disk) init(area *[queueAreaSize]byte) bool {
+	t := &k.t
+	t.setStatus(0)
+	status := uint8(statusAcknowledge)
+	t.setStatus(status)
+	status |= statusDriver
+	t.setStatus(status)
+
+	offered := t.features()
+	var wanted uint64
+	if t.modern {
+		if offered&(1<<featureVersion1) == 0 {
+			t.setStatus(statusFailed)
+			return false
+		}
+		wanted |= 1 << featureVersion1
+	}
+	wanted |= offered & (1 << blkFeatureReadOnly)
+	t.setFeatures(wanted)
+	if t.modern {
+		status |= statusFeaturesOK
+		t.setStatus(status)
+		if t.status()&statusFeaturesOK == 0 {
+			t.setStatus(statusFailed)
+			return false
+		}
+	}
+
+	size := t.queueSize(0)
+	if size > MaxQueueSize && t.modern {
+		size = MaxQueueSize
+	}
+	if size == 0 || size > MaxQueueSize {
+		t.setStatus(statusFailed)
+		return false
+	}
+	base := alignUp(uintptr(unsafe.Pointer(&area[0])), queueAlign)
+	k.q.init(base, size, size)
+	if !t.setQueue(0, &k.q) {
+		t.setStatus(statusFailed)
+		return false
+	}
+
+	t.setStatus(status | statusDriverOK)
+	k.broken = false
+	k.dev.Sectors = t.config64(blkConfigCapacity)
+	k.dev.ReadOnly = wanted&(1<<blkFeatureReadOnly) != 0
+	return true
+}
+
+func blkRead(unit int, lba uint64, count int, buf *byte) bool {
+	return disks[unit].transfer(lba, count, buf, false)
+}
+
+func blkWrite(unit int, lba uint64, count int, buf *byte) bool {
+	return disks[unit].transfer(lba, count, buf, true)
+}
+
+func (k *disk) transfer(lba uint64, count int, buf *byte, write bool) bool {
+	if count <= 0 || k.broken || lba+uint64(count) > k.dev.Sectors {
+		return false
+	}
+	if write && k.dev.ReadOnly {
+		return false
+	}
+	p := uintptr(unsafe.Pointer(buf))
+	for count > 0 {
+		n := count
+		if n > maxRequestSectors {
+			n = maxRequestSectors
+		}
+		if !k.request(lba, n, p, write) {
+			return false
+		}
+		lba += uint64(n)
+		p += uintptr(n * block.SectorSize)
+		count -= n
+	}
+	return true
+}
+
+// request sends one header/data/status chain and polls for completion
+func (k *disk) request(lba uint64, n int, p uintptr, write bool) bool {
+	q := &k.q
+	head, ok := q.alloc(3)
+	if !ok {
+		return false
+	}
+	data := q.descAt(head).next
+	status := q.descAt(data).next
+
+	k.header.typ = blkRequestIn
+	dataFlags := uint16(descFlagNext | descFlagWrite)
+	if write {
+		k.header.typ = blkRequestOut
+		dataFlags = descFlagNext
+	}
+	k.header.reserved = 0
+	k.header.sector = lba
+	k.status = 0xFF
+
+	q.setDesc(head, physAddr(unsafe.Pointer(&k.header)), uint32(unsafe.Sizeof(k.header)), descFlagNext)
+	q.setDesc(data, uint64(p), uint32(n*block.SectorSize), dataFlags)
+	q.setDesc(status, physAddr(unsafe.Pointer(&k.status)), 1, descFlagWrite)
+	q.submit(head)
+	k.t.kick(0)
+
+	for i := 0; ; i++ {
+		if _, _, done := q.popUsed(); done {
+			break
+		}
+		if i >= requestTimeout {
+			k.broken = true
+			return false
+		}
+		cpuRelax()
+	}
+	q.free(head)
+	return k.status == blkStatusOK
+}
@@ -0,0 +1,249 @@
+package virtio
+
+import (
+	"unsafe"
+
+	"github.com/dmarro89/go-dav-os/drivers/netdev"
+	"github.com/dmarro89/go-dav-os/drivers/pci"
+	"github.com/dmarro89/go-dav-os/kernel/irq"
+)
+
+const (
+	DeviceNetLegacy = 0x1000
+	DeviceNetModern = 0x1041
+
+	MaxNICs = 1
+
+	netFeatureMAC    = 5
+	netFeatureStatus = 16
+
+	netConfigMAC    = 0
+	netConfigStatus = 6
+	netStatusLinkUp = 0x1
+
+	netQueueRX = 0
+	netQueueTX = 1
+
+	// Every frame is preceded by a virtio_net_hdr: 10 bytes on a legacy
+	// device without mergeable buffers, 12 (with num_buffers) on a modern one
+	netHeaderLegacy = 10
+	netHeaderModern = 12
+
+	netRxBuffers  = 32
+	netTxBuffers  = 16
+	netBufferSize = netHeaderModern + netdev.MaxFrame
+)
+
+// nic holds one virtio-net device. Buffers are tied to descriptor
+// indices: the queues only put that many descriptors on their free lists.
+type nic struct {
+	t      transport
+	rx     queue
+	tx     queue
+	hdrLen int
+	// linkStatus is set when the device reports link state in its config
+	linkStatus bool
+
+	rxBuf [netRxBuffers][netBufferSize]byte
+	txBuf [netTxBuffers][netBufferSize]byte
+
+	dev netdev.Device
+}
+
+var (
+	nics         [MaxNICs]nic
+	nicCount     int
+	netQueueArea [MaxNICs][2][queueAreaSize]byte
+	nicNames     = [MaxNICs]string{"eth0"}
+)
+
+// NetDriver claims virtio network devices, transitional or modern-only.
+// Each one found is registered as network interface eth0, ...
+var NetDriver = pci.Driver{
+	Name:     "virtio-net",
+	VendorID: VendorID,
+	DeviceID: pci.AnyID,
+	Class:    pci.AnyClass,
+	Subclass: pci.AnyClass,
+	Probe:    probeNet,
+}
+
+// NICCount returns how many virtio network devices were brought up
+func NICCount() int { return nicCount }
+
+func probeNet(d *pci.Device) bool {
+	if d.DeviceID != DeviceNetLegacy && d.DeviceID != DeviceNetModern {
+		return false
+	}
+	if nicCount >= MaxNICs {
+		return false
+	}
+	n := &nics[nicCount]
+	if !n.t.setupModern(d) && (d.DeviceID != DeviceNetLegacy || !n.t.setupLegacy(d)) {
+		return false
+	}
+	d.Enable(pci.CommandIO | pci.CommandMemory | pci.CommandBusMaster)
+	if !n.init(&netQueueArea[nicCount]) {
+		return false
+	}
+
+	// Frames are reaped by netdev.PollAll; the interrupt only has to be
+	// acknowledged so a level-triggered line drops, and wakes a halted CPU
+	if d.IRQPin != 0 && d.IRQLine < irq.Lines {
+		irq.RegisterIRQ(d.IRQLine, netIRQ)
+	}
+
+	n.dev.Name = nicNames[nicCount]
+	n.dev.Unit = nicCount
+	n.dev.MTU = netdev.MTU
+	n.dev.Send = netSend
+	n.dev.Poll = netPoll
+	nicCount++
+	netdev.Register(&n.dev)
+	return true
+}
+
+func (n *nic) init(areas *[2][queueAreaSize]byte) bool {
+	t := &n.t
+	t.setStatus(0)
+	status := uint8(statusAcknowledge)
+	t.setStatus(status)
+	status |= statusDriver
+	t.setStatus(status)
+
+	offered := t.features()
+	var wanted uint64
+	n.hdrLen = netHeaderLegacy
+	if t.modern {
+		if offered&(1<<featureVersion1) == 0 {
+			t.setStatus(statusFailed)
+			return false
+		}
+		wanted |= 1 << featureVersion1
+		n.hdrLen = netHeaderModern
+	}
+	wanted |= offered & (1<<netFeatureMAC | 1<<netFeatureStatus)
+	t.setFeatures(wanted)
+	if t.modern {
+		status |= statusFeaturesOK
+		t.setStatus(status)
+		if t.status()&statusFeaturesOK == 0 {
+			t.setStatus(statusFailed)
+			return false
+		}
+	}
+
+	if !n.setupQueue(netQueueRX, &n.rx, &areas[netQueueRX], netRxBuffers) ||
+		!n.setupQueue(netQueueTX, &n.tx, &areas[netQueueTX], netTxBuffers) {
+		t.setStatus(statusFailed)
+		return false
+	}
+
+	// Hand every receive buffer to the device before going live
+	n.rx.setInterrupts(true)
+	for i := 0; i < netRxBuffers; i++ {
+		head, _ := n.rx.alloc(1)
+		n.rx.setDesc(head, physAddr(unsafe.Pointer(&n.rxBuf[head][0])), netBufferSize, descFlagWrite)
+		n.rx.submit(head)
+	}
+
+	t.setStatus(status | statusDriverOK)
+	t.kick(netQueueRX)
+
+	if wanted&(1<<netFeatureMAC) != 0 {
+		for i := 0; i < netdev.AddrLen; i++ {
+			n.dev.MAC[i] = t.config8(netConfigMAC + uint16(i))
+		}
+	}
+	n.linkStatus = wanted&(1<<netFeatureStatus) != 0
+	n.dev.LinkUp = n.linkUp()
+	return true
+}
+
+func (n *nic) setupQueue(index uint16, q *queue, area *[queueAreaSize]byte, buffers uint16) bool {
+	size := n.t.queueSize(index)
+	if size > MaxQueueSize && n.t.modern {
+		size = MaxQueueSize
+	}
+	if size < buffers || size > MaxQueueSize {
+		return false
+	}
+	q.init(alignUp(uintptr(unsafe.Pointer(&area[0])), queueAlign), size, buffers)
+	return n.t.setQueue(index, q)
+}
+
+// linkUp reads the link state, assuming up when the device does not
+// report it
+func (n *nic) linkUp() bool {
+	if !n.linkStatus {
+		return true
+	}
+	return n.t.config16(netConfigStatus)&netStatusLinkUp != 0
+}
+
+// netSend copies frame behind a zeroed header into a free transmit
+// buffer, padding it to the Ethernet minimum
+func netSend(unit int, frame []byte) bool {
+	n := &nics[unit]
+	n.reapTX()
+	head, ok := n.tx.alloc(1)
+	if !ok {
+		return false
+	}
+	buf := &n.txBuf[head]
+	for i := 0; i < n.hdrLen; i++ {
+		buf[i] = 0
+	}
+	length := len(frame)
+	for i := 0; i < length; i++ {
+		buf[n.hdrLen+i] = frame[i]
+	}
+	for ; length < netdev.MinFrame; length++ {
+		buf[n.hdrLen+length] = 0
+	}
+	n.tx.setDesc(head, physAddr(unsafe.Pointer(&buf[0])), uint32(n.hdrLen+length), 0)
+	n.tx.submit(head)
+	n.t.kick(netQueueTX)
+	return true
+}
+
+// netPoll passes every filled receive buffer up the stack, gives it back
+// to the device and frees completed transmit buffers
+func netPoll(unit int) {
+	n := &nics[unit]
+	reposted := false
+	for {
+		head, length, ok := n.rx.popUsed()
+		if !ok {
+			break
+		}
+		if int(head) < netRxBuffers && int(length) > n.hdrLen && int(length) <= netBufferSize {
+			netdev.Deliver(&n.dev, n.rxBuf[head][n.hdrLen:length])
+		} else {
+			n.dev.Stats.RxDropped++
+		}
+		n.rx.submit(head)
+		reposted = true
+	}
+	if reposted {
+		n.t.kick(netQueueRX)
+	}
+	n.reapTX()
+	n.dev.LinkUp = n.linkUp()
+}
+
+func (n *nic) reapTX() {
+	for {
+		head, _, ok := n.tx.popUsed()
+		if !ok {
+			return
+		}
+		n.tx.free(head)
+	}
+}
+
+func netIRQ() {
+	for i := 0; i < nicCount; i++ {
+		nics[i].t.ackInterrupt()
+	}
+}
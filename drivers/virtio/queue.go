@@ -0,0 +1,150 @@
+package virtio
+
+import "unsafe"
+
+const (
+	MaxQueueSize = 256
+
+	descSize      = 16
+	descFlagNext  = 0x1
+	descFlagWrite = 0x2
+
+	// availNoInterrupt asks the device not to interrupt on completions;
+	// requests are polled
+	availNoInterrupt = 0x1
+
+	ringHeader   = 4 // flags and idx
+	usedElemSize = 8
+
+	// queueAlign is the legacy interface's alignment for the ring area and
+	// the used ring; modern devices accept the same layout
+	queueAlign = 4096
+)
+
+// descriptor is one entry of the descriptor table, as the device reads it
+type descriptor struct {
+	addr   uint64
+	length uint32
+	flags  uint16
+	next   uint16
+}
+
+// queue is a split virtqueue laid out in one physically contiguous area:
+// descriptor table and available ring, then the used ring on the next
+// queueAlign boundary
+type queue struct {
+	size  uint16
+	desc  uintptr
+	avail uintptr
+	used  uintptr
+
+	freeHead uint16
+	numFree  uint16
+	lastUsed uint16
+}
+
+// queueBytes is the size of the area init needs for a size-entry queue
+func queueBytes(size uint16) uintptr {
+	n := uintptr(size)
+	return alignUp(descSize*n+ringHeader+2*n+2, queueAlign) +
+		alignUp(ringHeader+usedElemSize*n+2, queueAlign)
+}
+
+// init zeroes the queueAlign-aligned area and lays a size-entry queue out
+// in it. Only the first usable descriptors go on the free list, so a
+// driver that ties one buffer to each descriptor index needs no more
+// buffers than that. Used-ring interrupts start suppressed.
+func (q *queue) init(area uintptr, size, usable uint16) {
+	total := queueBytes(size)
+	for i := uintptr(0); i < total; i++ {
+		write8(area+i, 0)
+	}
+
+	n := uintptr(size)
+	q.size = size
+	q.desc = area
+	q.avail = area + descSize*n
+	q.used = area + alignUp(descSize*n+ringHeader+2*n+2, queueAlign)
+
+	if usable > size {
+		usable = size
+	}
+	for i := uint16(0); i+1 < usable; i++ {
+		q.descAt(i).next = i + 1
+	}
+	q.freeHead = 0
+	q.numFree = usable
+	q.lastUsed = 0
+	write16(q.avail, availNoInterrupt)
+}
+
+// setInterrupts asks the device to interrupt, or not, when it adds to
+// the used ring
+func (q *queue) setInterrupts(on bool) {
+	if on {
+		write16(q.avail, 0)
+	} else {
+		write16(q.avail, availNoInterrupt)
+	}
+}
+
+func (q *queue) descAt(i uint16) *descriptor {
+	return (*descriptor)(unsafe.Pointer(q.desc + uintptr(i)*descSize))
+}
+
+// alloc takes a chain of n descriptors off the free list and returns its
+// head; the chain is already linked through next
+func (q *queue) alloc(n uint16) (uint16, bool) {
+	if n == 0 || n > q.numFree {
+		return 0, false
+	}
+	head := q.freeHead
+	last := head
+	for i := uint16(1); i < n; i++ {
+		last = q.descAt(last).next
+	}
+	q.freeHead = q.descAt(last).next
+	q.numFree -= n
+	return head, true
+}
+
+// setDesc fills descriptor i; flags carries descFlagNext for every link
+// but the last
+func (q *queue) setDesc(i uint16, addr uint64, length uint32, flags uint16) {
+	d := q.descAt(i)
+	d.addr = addr
+	d.length = length
+	d.flags = flags
+}
+
+// free returns the chain starting at head to the free list
+func (q *queue) free(head uint16) {
+	last := head
+	n := uint16(1)
+	for q.descAt(last).flags&descFlagNext != 0 {
+		last = q.descAt(last).next
+		n++
+	}
+	q.descAt(last).next = q.freeHead
+	q.freeHead = head
+	q.numFree += n
+}
+
+// submit publishes the chain at head on the available ring. The index is
+// bumped only after the ring slot is written, so the device never sees a
+// stale entry.
+func (q *queue) submit(head uint16) {
+	idx := read16(q.avail + 2)
+	write16(q.avail+ringHeader+uintptr(idx%q.size)*2, head)
+	write16(q.avail+2, idx+1)
+}
+
+// popUsed returns the next chain the device has finished with
+func (q *queue) popUsed() (head uint16, length uint32, ok bool) {
+	if read16(q.used+2) == q.lastUsed {
+		return 0, 0, false
+	}
+	elem := q.used + ringHeader + uintptr(q.lastUsed%q.size)*usedElemSize
+	q.lastUsed++
+	return uint16(read32(elem)), read32(elem + 4), true
+}
@@ -0,0 +1,279 @@
+//go:build !gccgo
+
+package virtio
+
+import "unsafe"
+
+// Host builds get one fake legacy virtio-blk device at fakeIOBase and one
+// fake legacy virtio-net device at fakeNetIOBase. The disk serves requests
+// from fakeDisk synchronously when its queue is notified, walking the
+// rings in host memory the way QEMU walks guest memory; the NIC records
+// transmitted frames and fills receive buffers on fakeNetReceive.
+const (
+	fakeIOBase      = 0xC080
+	fakeDiskSectors = 192
+	fakeQueueSize   = 128
+
+	fakeNetIOBase    = 0xC0C0
+	fakeNetQueueSize = 64
+	fakeNetPortSpan  = 0x20
+)
+
+var (
+	fakeDisk      [fakeDiskSectors * 512]byte
+	fakeFeatures  uint32
+	fakeQueueMax  uint16 = fakeQueueSize
+	fakeStatus    uint8
+	fakeGuestFeat uint32
+	fakeQueuePFN  uint32
+	fakeLastAvail uint16
+	fakeRequests  int
+	// fakeStall makes the device ignore notifications
+	fakeStall bool
+)
+
+var (
+	fakeNetMAC       = [6]byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+	fakeNetFeatures  uint32
+	fakeNetStatus    uint8
+	fakeNetGuestFeat uint32
+	fakeNetLink      uint16
+	fakeNetISR       uint8
+	fakeNetSelect    uint16
+	fakeNetPFN       [maxQueues]uint32
+	fakeNetLastAvail [maxQueues]uint16
+	// fakeNetSent holds every frame the driver transmitted, header stripped
+	fakeNetSent [][]byte
+)
+
+func resetFakeNIC() {
+	fakeNetFeatures = 1<<netFeatureMAC | 1<<netFeatureStatus
+	fakeNetStatus = 0
+	fakeNetGuestFeat = 0
+	fakeNetLink = netStatusLinkUp
+	fakeNetISR = 0
+	fakeNetSelect = 0
+	fakeNetPFN = [maxQueues]uint32{}
+	fakeNetLastAvail = [maxQueues]uint16{}
+	fakeNetSent = nil
+}
+
+func isNetPort(port uint16) bool {
+	return port >= fakeNetIOBase && port < fakeNetIOBase+fakeNetPortSpan
+}
+
+func resetFakeDevice() {
+	for i := range fakeDisk {
+		fakeDisk[i] = 0
+	}
+	fakeFeatures = 0
+	fakeQueueMax = fakeQueueSize
+	fakeStatus = 0
+	fakeGuestFeat = 0
+	fakeQueuePFN = 0
+	fakeLastAvail = 0
+	fakeRequests = 0
+	fakeStall = false
+}
+
+func inb(port uint16) uint8 {
+	if isNetPort(port) {
+		return netInb(port - fakeNetIOBase)
+	}
+	if port == fakeIOBase+legacyStatus {
+		return fakeStatus
+	}
+	return 0xFF
+}
+
+func outb(port uint16, value uint8) {
+	if isNetPort(port) {
+		if port-fakeNetIOBase == legacyStatus {
+			fakeNetStatus = value
+			if value == 0 {
+				fakeNetPFN = [maxQueues]uint32{}
+				fakeNetLastAvail = [maxQueues]uint16{}
+			}
+		}
+		return
+	}
+	if port == fakeIOBase+legacyStatus {
+		fakeStatus = value
+		if value == 0 {
+			fakeQueuePFN = 0
+			fakeLastAvail = 0
+		}
+	}
+}
+
+func inw(port uint16) uint16 {
+	if isNetPort(port) {
+		if port-fakeNetIOBase == legacyQueueSize && fakeNetSelect < maxQueues {
+			return fakeNetQueueSize
+		}
+		return 0
+	}
+	if port == fakeIOBase+legacyQueueSize {
+		return fakeQueueMax
+	}
+	return 0xFFFF
+}
+
+func outw(port uint16, value uint16) {
+	if isNetPort(port) {
+		switch port - fakeNetIOBase {
+		case legacyQueueSelect:
+			fakeNetSelect = value
+		case legacyQueueNotify:
+			if value == netQueueTX {
+				fakeNetTransmit()
+			}
+		}
+		return
+	}
+	if port == fakeIOBase+legacyQueueNotify && value == 0 && !fakeStall {
+		fakeServe()
+	}
+}
+
+func inl(port uint16) uint32 {
+	if isNetPort(port) {
+		if port-fakeNetIOBase == legacyDeviceFeatures {
+			return fakeNetFeatures
+		}
+		return 0xFFFFFFFF
+	}
+	switch port {
+	case fakeIOBase + legacyDeviceFeatures:
+		return fakeFeatures
+	case fakeIOBase + legacyConfig + blkConfigCapacity:
+		return fakeDiskSectors
+	case fakeIOBase + legacyConfig + blkConfigCapacity + 4:
+		return 0
+	}
+	return 0xFFFFFFFF
+}
+
+func outl(port uint16, value uint32) {
+	if isNetPort(port) {
+		switch port - fakeNetIOBase {
+		case legacyGuestFeatures:
+			fakeNetGuestFeat = value
+		case legacyQueueAddress:
+			if fakeNetSelect < maxQueues {
+				fakeNetPFN[fakeNetSelect] = value
+			}
+		}
+		return
+	}
+	switch port {
+	case fakeIOBase + legacyGuestFeatures:
+		fakeGuestFeat = value
+	case fakeIOBase + legacyQueueAddress:
+		fakeQueuePFN = value
+	}
+}
+
+func cpuRelax() {}
+
+// fakeRing views the legacy queue at page pfn the way the device does
+func fakeRing(pfn uint32, size uint16) queue {
+	var q queue
+	n := uintptr(size)
+	area := uintptr(pfn) << legacyPFNShift
+	q.size = size
+	q.desc = area
+	q.avail = area + descSize*n
+	q.used = area + alignUp(descSize*n+ringHeader+2*n+2, queueAlign)
+	return q
+}
+
+// fakePushUsed completes the chain at head with length bytes written
+func fakePushUsed(q *queue, head uint16, length uint32) {
+	idx := read16(q.used + 2)
+	elem := q.used + ringHeader + uintptr(idx%q.size)*usedElemSize
+	write32(elem, uint32(head))
+	write32(elem+4, length)
+	write16(q.used+2, idx+1)
+}
+
+func netInb(reg uint16) uint8 {
+	switch {
+	case reg == legacyStatus:
+		return fakeNetStatus
+	case reg == legacyISR:
+		isr := fakeNetISR
+		fakeNetISR = 0
+		return isr
+	case reg >= legacyConfig+netConfigMAC && reg < legacyConfig+netConfigMAC+6:
+		return fakeNetMAC[reg-legacyConfig-netConfigMAC]
+	case reg == legacyConfig+netConfigStatus:
+		return uint8(fakeNetLink)
+	case reg == legacyConfig+netConfigStatus+1:
+		return uint8(fakeNetLink >> 8)
+	}
+	return 0xFF
+}
+
+func fakeNetTransmit() {
+	q := fakeRing(fakeNetPFN[netQueueTX], fakeNetQueueSize)
+	for fakeNetLastAvail[netQueueTX] != read16(q.avail+2) {
+		head := read16(q.avail + ringHeader + uintptr(fakeNetLastAvail[netQueueTX]%q.size)*2)
+		fakeNetLastAvail[netQueueTX]++
+		d := q.descAt(head)
+		buf := unsafe.Slice((*byte)(unsafe.Pointer(uintptr(d.addr))), d.length)
+		fakeNetSent = append(fakeNetSent, append([]byte(nil), buf[netHeaderLegacy:]...))
+		fakePushUsed(&q, head, 0)
+	}
+	fakeNetISR |= 1
+}
+
+// fakeNetReceive fills the next posted receive buffer with frame, as if
+// it had arrived on the wire, and reports false when none is posted
+func fakeNetReceive(frame []byte) bool {
+	q := fakeRing(fakeNetPFN[netQueueRX], fakeNetQueueSize)
+	if fakeNetLastAvail[netQueueRX] == read16(q.avail+2) {
+		return false
+	}
+	head := read16(q.avail + ringHeader + uintptr(fakeNetLastAvail[netQueueRX]%q.size)*2)
+	fakeNetLastAvail[netQueueRX]++
+	d := q.descAt(head)
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(uintptr(d.addr))), d.length)
+	for i := 0; i < netHeaderLegacy; i++ {
+		buf[i] = 0
+	}
+	copy(buf[netHeaderLegacy:], frame)
+	fakePushUsed(&q, head, uint32(netHeaderLegacy+len(frame)))
+	fakeNetISR |= 1
+	return true
+}
+
+func fakeServe() {
+	q := fakeRing(fakeQueuePFN, fakeQueueMax)
+
+	for fakeLastAvail != read16(q.avail+2) {
+		head := read16(q.avail + ringHeader + uintptr(fakeLastAvail%q.size)*2)
+		fakeLastAvail++
+		fakeRequests++
+
+		hdr := q.descAt(head)
+		data := q.descAt(hdr.next)
+		status := q.descAt(data.next)
+		typ := read32(uintptr(hdr.addr))
+		sector := *(*uint64)(unsafe.Pointer(uintptr(hdr.addr + 8)))
+
+		result := uint8(blkStatusOK)
+		if sector*512+uint64(data.length) > uint64(len(fakeDisk)) {
+			result = 1 // VIRTIO_BLK_S_IOERR
+		} else {
+			buf := unsafe.Slice((*byte)(unsafe.Pointer(uintptr(data.addr))), data.length)
+			if typ == blkRequestOut {
+				copy(fakeDisk[sector*512:], buf)
+			} else {
+				copy(buf, fakeDisk[sector*512:])
+			}
+		}
+		write8(uintptr(status.addr), result)
+		fakePushUsed(&q, head, data.length+1)
+	}
+}
@@ -0,0 +1,242 @@
+package virtio
+
+import "github.com/dmarro89/go-dav-os/drivers/pci"
+
+// Legacy interface: a register block at the start of I/O BAR0
+const (
+	legacyDeviceFeatures = 0x00
+	legacyGuestFeatures  = 0x04
+	legacyQueueAddress   = 0x08
+	legacyQueueSize      = 0x0C
+	legacyQueueSelect    = 0x0E
+	legacyQueueNotify    = 0x10
+	legacyStatus         = 0x12
+	legacyISR            = 0x13
+	// legacyConfig is where device config starts while MSI-X is off
+	legacyConfig = 0x14
+
+	legacyPFNShift = 12
+)
+
+// Modern interface: vendor-specific PCI capabilities point at register
+// windows inside memory BARs
+const (
+	capConfigType       = 3
+	capBAR              = 4
+	capOffset           = 8
+	capLength           = 12
+	capNotifyMultiplier = 16
+
+	configCommon = 1
+	configNotify = 2
+	configISR    = 3
+	configDevice = 4
+
+	commonDeviceFeatureSelect = 0x00
+	commonDeviceFeature       = 0x04
+	commonDriverFeatureSelect = 0x08
+	commonDriverFeature       = 0x0C
+	commonStatus              = 0x14
+	commonQueueSelect         = 0x16
+	commonQueueSize           = 0x18
+	commonQueueEnable         = 0x1C
+	commonQueueNotifyOff      = 0x1E
+	commonQueueDesc           = 0x20
+	commonQueueDriver         = 0x28
+	commonQueueDevice         = 0x30
+)
+
+// maxQueues bounds the queues one device may set up: one for block
+// devices, receive and transmit for network devices
+const maxQueues = 2
+
+// transport hides which of the two PCI interfaces a device is driven
+// through
+type transport struct {
+	modern bool
+
+	// legacy
+	io uint16
+
+	// modern
+	common    uintptr
+	notify    uintptr
+	isr       uintptr
+	device    uintptr
+	notifyMul uint32
+	queueKick [maxQueues]uintptr
+}
+
+func (t *transport) setupLegacy(d *pci.Device) bool {
+	bar := &d.BARs[0]
+	if bar.Kind != pci.BARIO || bar.Base == 0 {
+		return false
+	}
+	t.modern = false
+	t.io = uint16(bar.Base)
+	return true
+}
+
+// setupModern locates the common, notify, ISR and device config windows
+// from the device's vendor-specific capabilities
+func (t *transport) setupModern(d *pci.Device) bool {
+	t.common, t.notify, t.isr, t.device = 0, 0, 0, 0
+	for i := 0; i < d.CapCount; i++ {
+		if d.Caps[i].ID != pci.CapVendorSpecific {
+			continue
+		}
+		off := uint16(d.Caps[i].Offset)
+		barIndex := d.Read8(off + capBAR)
+		if barIndex >= pci.MaxBARs {
+			continue
+		}
+		bar := &d.BARs[barIndex]
+		if bar.Kind != pci.BARMem32 && bar.Kind != pci.BARMem64 {
+			continue
+		}
+		start := bar.Base + uint64(d.Read32(off+capOffset))
+		if bar.Base == 0 || start+uint64(d.Read32(off+capLength)) > mmioLimit {
+			continue
+		}
+
+		switch d.Read8(off + capConfigType) {
+		case configCommon:
+			t.common = uintptr(start)
+		case configNotify:
+			t.notify = uintptr(start)
+			t.notifyMul = d.Read32(off + capNotifyMultiplier)
+		case configISR:
+			t.isr = uintptr(start)
+		case configDevice:
+			t.device = uintptr(start)
+		}
+	}
+	if t.common == 0 || t.notify == 0 || t.isr == 0 || t.device == 0 {
+		return false
+	}
+	t.modern = true
+	return true
+}
+
+func (t *transport) status() uint8 {
+	if t.modern {
+		return read8(t.common + commonStatus)
+	}
+	return inb(t.io + legacyStatus)
+}
+
+func (t *transport) setStatus(s uint8) {
+	if t.modern {
+		write8(t.common+commonStatus, s)
+		return
+	}
+	outb(t.io+legacyStatus, s)
+}
+
+// features returns the device feature bits; the legacy interface only
+// has the low 32
+func (t *transport) features() uint64 {
+	if !t.modern {
+		return uint64(inl(t.io + legacyDeviceFeatures))
+	}
+	write32(t.common+commonDeviceFeatureSelect, 0)
+	lo := read32(t.common + commonDeviceFeature)
+	write32(t.common+commonDeviceFeatureSelect, 1)
+	hi := read32(t.common + commonDeviceFeature)
+	return uint64(hi)<<32 | uint64(lo)
+}
+
+func (t *transport) setFeatures(f uint64) {
+	if !t.modern {
+		outl(t.io+legacyGuestFeatures, uint32(f))
+		return
+	}
+	write32(t.common+commonDriverFeatureSelect, 0)
+	write32(t.common+commonDriverFeature, uint32(f))
+	write32(t.common+commonDriverFeatureSelect, 1)
+	write32(t.common+commonDriverFeature, uint32(f>>32))
+}
+
+// queueSize returns the largest size the device allows for queue index,
+// 0 when the queue does not exist
+func (t *transport) queueSize(index uint16) uint16 {
+	if !t.modern {
+		outw(t.io+legacyQueueSelect, index)
+		return inw(t.io + legacyQueueSize)
+	}
+	write16(t.common+commonQueueSelect, index)
+	return read16(t.common + commonQueueSize)
+}
+
+// setQueue hands q to the device as queue index. A legacy device takes
+// only the page number of the area and must get the size it reported.
+func (t *transport) setQueue(index uint16, q *queue) bool {
+	if index >= maxQueues {
+		return false
+	}
+	if !t.modern {
+		outw(t.io+legacyQueueSelect, index)
+		if inw(t.io+legacyQueueSize) != q.size {
+			return false
+		}
+		outl(t.io+legacyQueueAddress, uint32(uint64(q.desc)>>legacyPFNShift))
+		return true
+	}
+	write16(t.common+commonQueueSelect, index)
+	write16(t.common+commonQueueSize, q.size)
+	write64(t.common+commonQueueDesc, uint64(q.desc))
+	write64(t.common+commonQueueDriver, uint64(q.avail))
+	write64(t.common+commonQueueDevice, uint64(q.used))
+	t.queueKick[index] = t.notify + uintptr(read16(t.common+commonQueueNotifyOff))*uintptr(t.notifyMul)
+	write16(t.common+commonQueueEnable, 1)
+	return true
+}
+
+// kick tells the device that queue index has new available entries
+func (t *transport) kick(index uint16) {
+	if t.modern {
+		write16(t.queueKick[index], index)
+		return
+	}
+	outw(t.io+legacyQueueNotify, index)
+}
+
+// ackInterrupt reads the ISR status, which also deasserts the device's
+// INTx line, and returns it
+func (t *transport) ackInterrupt() uint8 {
+	if t.modern {
+		return read8(t.isr)
+	}
+	return inb(t.io + legacyISR)
+}
+
+// config8 reads a byte of the device-specific configuration
+func (t *transport) config8(off uint16) uint8 {
+	if t.modern {
+		return read8(t.device + uintptr(off))
+	}
+	return inb(t.io + legacyConfig + off)
+}
+
+func (t *transport) config16(off uint16) uint16 {
+	return uint16(t.config8(off+1))<<8 | uint16(t.config8(off))
+}
+
+// config32 reads a 32-bit field of the device-specific configuration
+func (t *transport) config32(off uint16) uint32 {
+	if t.modern {
+		return read32(t.device + uintptr(off))
+	}
+	return inl(t.io + legacyConfig + off)
+}
+
+func (t *transport) config64(off uint16) uint64 {
+	return uint64(t.config32(off+4))<<32 | uint64(t.config32(off))
+}
+
+// write64 stores a 64-bit common config field low half first, as the
+// spec allows drivers to
+func write64(addr uintptr, v uint64) {
+	write32(addr, uint32(v))
+	write32(addr+4, uint32(v>>32))
+}
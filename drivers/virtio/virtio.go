@@ -0,0 +1,48 @@
+package virtio
+
+import "unsafe"
+
+const (
+	VendorID = 0x1AF4
+
+	// Transitional devices keep the legacy IDs 0x1000-0x103F; modern-only
+	// ones use 0x1040 plus the virtio device type (2 for block)
+	DeviceBlkLegacy = 0x1001
+	DeviceBlkModern = 0x1042
+
+	statusAcknowledge = 0x01
+	statusDriver      = 0x02
+	statusDriverOK    = 0x04
+	statusFeaturesOK  = 0x08
+	statusFailed      = 0x80
+
+	// featureVersion1 marks a modern (virtio 1.0) device; it must be
+	// accepted or the device refuses FEATURES_OK
+	featureVersion1 = 32
+)
+
+// mmioLimit is the end of the identity map built at boot: modern register
+// windows above it cannot be reached
+var mmioLimit uint64 = 1 << 32
+
+// physAddr returns the physical address of kernel memory at p, which is
+// identity mapped
+func physAddr(p unsafe.Pointer) uint64 {
+	return uint64(uintptr(p))
+}
+
+func alignUp(v, align uintptr) uintptr {
+	return (v + align - 1) &^ (align - 1)
+}
+
+func read8(addr uintptr) uint8 { return *(*uint8)(unsafe.Pointer(addr)) }
+
+func read16(addr uintptr) uint16 { return *(*uint16)(unsafe.Pointer(addr)) }
+
+func read32(addr uintptr) uint32 { return *(*uint32)(unsafe.Pointer(addr)) }
+
+func write8(addr uintptr, v uint8) { *(*uint8)(unsafe.Pointer(addr)) = v }
+
+func write16(addr uintptr, v uint16) { *(*uint16)(unsafe.Pointer(addr)) = v }
+
+func write32(addr uintptr, v uint32) { *(*uint32)(unsafe.Pointer(addr)) = v }
@@ -0,0 +1,15 @@
+//go:build gccgo
+
+package virtio
+
+// Implemented in boot/stubs_amd64.s
+func inb(port uint16) uint8
+func outb(port uint16, value uint8)
+func inw(port uint16) uint16
+func outw(port uint16, value uint16)
+func inl(port uint16) uint32
+func outl(port uint16, value uint32)
+
+// cpuRelax is a pause instruction; as an opaque call it also makes the
+// compiler reload ring indices on every poll
+func cpuRelax()
@@ -0,0 +1,284 @@
+package virtio
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/dmarro89/go-dav-os/drivers/block"
+	"github.com/dmarro89/go-dav-os/drivers/netdev"
+	"github.com/dmarro89/go-dav-os/drivers/pci"
+)
+
+func fakePCIDevice() *pci.Device {
+	d := &pci.Device{VendorID: VendorID, DeviceID: DeviceBlkLegacy}
+	d.BARs[0].Kind = pci.BARIO
+	d.BARs[0].Base = fakeIOBase
+	return d
+}
+
+func setup(t *testing.T) *block.Device {
+	t.Helper()
+	resetFakeDevice()
+	block.ResetForTesting()
+	diskCount = 0
+	t.Cleanup(func() {
+		block.ResetForTesting()
+		diskCount = 0
+	})
+	if !probeBlk(fakePCIDevice()) {
+		t.Fatalf("probe rejected the fake legacy device")
+	}
+	return block.Default()
+}
+
+func TestQueueLayoutAndFreeList(t *testing.T) {
+	if got := queueBytes(256); got != 3*queueAlign {
+		t.Fatalf("queueBytes(256) = %d, want %d", got, 3*queueAlign)
+	}
+	if queueBytes(MaxQueueSize)+queueAlign > queueAreaSize {
+		t.Fatalf("queueAreaSize cannot hold an aligned MaxQueueSize queue")
+	}
+
+	var area [queueAreaSize]byte
+	base := alignUp(uintptr(unsafe.Pointer(&area[0])), queueAlign)
+	var q queue
+	q.init(base, 8, 8)
+	if q.used%queueAlign != 0 {
+		t.Fatalf("used ring at 0x%x is not page aligned", q.used)
+	}
+
+	a, ok := q.alloc(3)
+	_, ok2 := q.alloc(3)
+	if !ok || !ok2 || q.numFree != 2 {
+		t.Fatalf("alloc: ok=%v,%v numFree=%d, want 2 left", ok, ok2, q.numFree)
+	}
+	if _, ok := q.alloc(3); ok {
+		t.Fatalf("alloc handed out more descriptors than are free")
+	}
+	q.setDesc(a, 0, 0, descFlagNext)
+	q.setDesc(q.descAt(a).next, 0, 0, descFlagNext)
+	q.setDesc(q.descAt(q.descAt(a).next).next, 0, 0, 0)
+	q.free(a)
+	if q.numFree != 5 || q.freeHead != a {
+		t.Fatalf("free: numFree=%d head=%d, want 5 and %d", q.numFree, q.freeHead, a)
+	}
+
+	q.submit(a)
+	if read16(q.avail+2) != 1 || read16(q.avail+ringHeader) != a {
+		t.Fatalf("submit did not publish the chain")
+	}
+	if _, _, ok := q.popUsed(); ok {
+		t.Fatalf("popUsed returned an entry the device never produced")
+	}
+	write32(q.used+ringHeader, uint32(a))
+	write16(q.used+2, 1)
+	if head, _, ok := q.popUsed(); !ok || head != a {
+		t.Fatalf("popUsed = %d, %v, want %d", head, ok, a)
+	}
+}
+
+func TestBlkProbeNegotiatesLegacyDevice(t *testing.T) {
+	dev := setup(t)
+	if dev == nil || dev.Name != "vda" || dev.Sectors != fakeDiskSectors || dev.ReadOnly {
+		t.Fatalf("registered block device = %+v", dev)
+	}
+	want := uint8(statusAcknowledge | statusDriver | statusDriverOK)
+	if fakeStatus != want {
+		t.Fatalf("device status = 0x%x, want 0x%x", fakeStatus, want)
+	}
+	if fakeQueuePFN == 0 {
+		t.Fatalf("queue address never programmed")
+	}
+
+	if probeBlk(&pci.Device{VendorID: VendorID, DeviceID: 0x1000}) {
+		t.Fatalf("probe claimed a virtio-net device")
+	}
+}
+
+func TestBlkReadWriteRoundTrip(t *testing.T) {
+	dev := setup(t)
+
+	var sec [block.SectorSize]byte
+	for i := range sec {
+		sec[i] = byte(i)
+	}
+	if !block.WriteSector(7, &sec) {
+		t.Fatalf("WriteSector failed")
+	}
+	if fakeDisk[7*512+5] != 5 {
+		t.Fatalf("write did not reach the device")
+	}
+
+	fakeDisk[9*512] = 0x5A
+	if !block.ReadSector(9, &sec) || sec[0] != 0x5A {
+		t.Fatalf("ReadSector(9)[0] = 0x%x, want 0x5A", sec[0])
+	}
+
+	// 150 sectors cross maxRequestSectors: two requests
+	var big [150 * block.SectorSize]byte
+	fakeDisk[149*512] = 0xC3
+	fakeRequests = 0
+	if !dev.Read(dev.Unit, 0, 150, &big[0]) {
+		t.Fatalf("150-sector read failed")
+	}
+	if fakeRequests != 2 || big[149*512] != 0xC3 {
+		t.Fatalf("requests=%d last byte=0x%x, want 2 and 0xC3", fakeRequests, big[149*512])
+	}
+
+	if dev.Read(dev.Unit, fakeDiskSectors-1, 2, &big[0]) {
+		t.Fatalf("read past the end succeeded")
+	}
+	if disks[0].q.numFree != fakeQueueSize {
+		t.Fatalf("%d descriptors leaked", fakeQueueSize-int(disks[0].q.numFree))
+	}
+}
+
+func TestBlkReadOnlyAndStalledDevice(t *testing.T) {
+	resetFakeDevice()
+	fakeFeatures = 1 << blkFeatureReadOnly
+	block.ResetForTesting()
+	diskCount = 0
+	defer func() { block.ResetForTesting(); diskCount = 0 }()
+	if !probeBlk(fakePCIDevice()) {
+		t.Fatalf("probe failed")
+	}
+	dev := block.Default()
+	if !dev.ReadOnly || fakeGuestFeat != 1<<blkFeatureReadOnly {
+		t.Fatalf("read-only feature not negotiated: ReadOnly=%v guest=0x%x", dev.ReadOnly, fakeGuestFeat)
+	}
+	var sec [block.SectorSize]byte
+	if block.WriteSector(0, &sec) {
+		t.Fatalf("write to a read-only disk succeeded")
+	}
+
+	fakeStall = true
+	if block.ReadSector(0, &sec) {
+		t.Fatalf("read from a stalled device succeeded")
+	}
+	fakeStall = false
+	if block.ReadSector(0, &sec) {
+		t.Fatalf("a disk that timed out must stay offline")
+	}
+}
+
+func TestBlkRejectsOversizedLegacyQueue(t *testing.T) {
+	resetFakeDevice()
+	fakeQueueMax = 1024
+	diskCount = 0
+	block.ResetForTesting()
+	defer block.ResetForTesting()
+	if probeBlk(fakePCIDevice()) {
+		t.Fatalf("probe accepted a legacy queue larger than MaxQueueSize")
+	}
+	if fakeStatus != statusFailed {
+		t.Fatalf("device status = 0x%x, want FAILED", fakeStatus)
+	}
+}
+
+func setupNIC(t *testing.T) *netdev.Device {
+	t.Helper()
+	resetFakeNIC()
+	netdev.ResetForTesting()
+	nicCount = 0
+	t.Cleanup(func() {
+		netdev.ResetForTesting()
+		nicCount = 0
+	})
+	d := &pci.Device{VendorID: VendorID, DeviceID: DeviceNetLegacy}
+	d.BARs[0].Kind = pci.BARIO
+	d.BARs[0].Base = fakeNetIOBase
+	if !probeNet(d) {
+		t.Fatalf("probe rejected the fake legacy NIC")
+	}
+	return netdev.At(0)
+}
+
+var netFrames [][]byte
+
+func keepFrame(d *netdev.Device, frame []byte) {
+	netFrames = append(netFrames, append([]byte(nil), frame...))
+}
+
+func TestNetProbeReadsMACAndPostsBuffers(t *testing.T) {
+	dev := setupNIC(t)
+	if dev == nil || dev.Name != "eth0" || dev.MAC != fakeNetMAC || !dev.LinkUp {
+		t.Fatalf("registered interface = %+v", dev)
+	}
+	if fakeNetGuestFeat != 1<<netFeatureMAC|1<<netFeatureStatus {
+		t.Fatalf("guest features = 0x%x", fakeNetGuestFeat)
+	}
+	if fakeNetStatus&statusDriverOK == 0 || fakeNetPFN[netQueueRX] == 0 || fakeNetPFN[netQueueTX] == 0 {
+		t.Fatalf("status=0x%x pfn=%v: device not brought up", fakeNetStatus, fakeNetPFN)
+	}
+	rx := fakeRing(fakeNetPFN[netQueueRX], fakeNetQueueSize)
+	if read16(rx.avail+2) != netRxBuffers || read16(rx.avail)&availNoInterrupt != 0 {
+		t.Fatalf("avail idx=%d flags=%d, want %d buffers with interrupts on",
+			read16(rx.avail+2), read16(rx.avail), netRxBuffers)
+	}
+
+	fakeNetLink = 0
+	dev.Poll(dev.Unit)
+	if dev.LinkUp {
+		t.Fatalf("link still up after the device reported it down")
+	}
+
+	if probeNet(&pci.Device{VendorID: VendorID, DeviceID: DeviceBlkLegacy}) {
+		t.Fatalf("probe claimed a virtio-blk device")
+	}
+}
+
+func TestNetSendPadsAndRecyclesBuffers(t *testing.T) {
+	dev := setupNIC(t)
+
+	frame := make([]byte, 42)
+	frame[0], frame[41] = 0xFF, 0xAB
+	// More frames than transmit buffers: completions must be reaped
+	for i := 0; i < 2*netTxBuffers; i++ {
+		if !netdev.Transmit(dev, frame) {
+			t.Fatalf("Transmit %d failed", i)
+		}
+	}
+	if len(fakeNetSent) != 2*netTxBuffers {
+		t.Fatalf("device saw %d frames, want %d", len(fakeNetSent), 2*netTxBuffers)
+	}
+	got := fakeNetSent[0]
+	if len(got) != netdev.MinFrame || got[0] != 0xFF || got[41] != 0xAB || got[42] != 0 {
+		t.Fatalf("sent frame len=%d, want padded to %d", len(got), netdev.MinFrame)
+	}
+	if dev.Stats.TxPackets != 2*netTxBuffers || dev.Stats.TxBytes != 2*netTxBuffers*42 {
+		t.Fatalf("tx stats = %+v", dev.Stats)
+	}
+}
+
+func TestNetPollDeliversReceivedFrames(t *testing.T) {
+	dev := setupNIC(t)
+	netFrames = nil
+	netdev.SetReceiver(keepFrame)
+
+	frame := make([]byte, 64)
+	frame[13] = 0x06
+	// Twice the ring's worth: each poll must hand the buffers back
+	for i := 0; i < 2*netRxBuffers; i++ {
+		frame[20] = byte(i)
+		if !fakeNetReceive(frame) {
+			t.Fatalf("no receive buffer posted for frame %d", i)
+		}
+		if i%4 == 3 {
+			netdev.PollAll()
+		}
+	}
+	if len(netFrames) != 2*netRxBuffers {
+		t.Fatalf("delivered %d frames, want %d", len(netFrames), 2*netRxBuffers)
+	}
+	last := netFrames[len(netFrames)-1]
+	if len(last) != 64 || last[13] != 0x06 || last[20] != byte(2*netRxBuffers-1) {
+		t.Fatalf("last frame = % x", last)
+	}
+	if dev.Stats.RxPackets != 2*netRxBuffers || dev.Stats.RxBytes != 2*netRxBuffers*64 {
+		t.Fatalf("rx stats = %+v", dev.Stats)
+	}
+	netIRQ()
+	if fakeNetISR != 0 {
+		t.Fatalf("interrupt handler did not acknowledge the ISR")
+	}
+}
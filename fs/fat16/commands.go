@@ -0,0 +1,48 @@
+package fat16
+
+import (
+	"github.com/dmarro89/go-dav-os/shell/command"
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+var commands = [4]command.Command{
+	{Name: "fatinit", Help: "Mount the FAT16 filesystem"},
+	{Name: "fatformat", Help: "Write an empty FAT16 filesystem to the disk"},
+	{Name: "fatinfo", Help: "Show the FAT16 layout"},
+	{Name: "fatls", Help: "List the FAT16 root directory"},
+}
+
+// RegisterCommands adds the FAT16 commands to the shell
+func RegisterCommands() {
+	commands[0].Run = cmdInit
+	commands[1].Run = cmdFormat
+	commands[2].Run = cmdInfo
+	commands[3].Run = cmdList
+	for i := 0; i < len(commands); i++ {
+		command.Register(&commands[i])
+	}
+}
+
+func cmdInit(a *command.Args) {
+	if Init() {
+		terminal.Print("FAT16 Initialized\n")
+	} else {
+		a.Fail("FAT16 Init Failed\n")
+	}
+}
+
+func cmdFormat(a *command.Args) {
+	if Format() {
+		terminal.Print("FAT16 Formatted\n")
+	} else {
+		a.Fail("FAT16 Format Failed\n")
+	}
+}
+
+func cmdInfo(a *command.Args) {
+	Info()
+}
+
+func cmdList(a *command.Args) {
+	ListDir()
+}
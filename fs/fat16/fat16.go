@@ -3,7 +3,8 @@
 package fat16
 
 import (
-	"github.com/dmarro89/go-dav-os/drivers/ata"
+	"github.com/dmarro89/go-dav-os/drivers/block"
+	"github.com/dmarro89/go-dav-os/drivers/rtc"
 	"github.com/dmarro89/go-dav-os/terminal"
 )
 
@@ -34,14 +35,28 @@ const (
 
 // Init reads the MBR/BPB from sector 0 and calculates offsets
 func Init() bool {
-	if !ata.ReadSector(0, &fatBuf) {
-		terminal.Print("FAT16: Read Error\n")
+	return mount(false)
+}
+
+// Mount is Init without the error messages, for probing a disk at boot
+// that may not hold a filesystem
+func Mount() bool {
+	return mount(true)
+}
+
+func mount(quiet bool) bool {
+	if !block.ReadSector(0, &fatBuf) {
+		if !quiet {
+			terminal.Print("FAT16: Read Error\n")
+		}
 		return false
 	}
 
 	// Check signature 0x55 0xAA at 510
 	if fatBuf[510] != 0x55 || fatBuf[511] != 0xAA {
-		terminal.Print("FAT16: Invalid Signature. Run 'fat_format' first.\n")
+		if !quiet {
+			terminal.Print("FAT16: Invalid Signature. Run 'fat_format' first.\n")
+		}
 		initialized = false
 		return false
 	}
@@ -55,7 +70,9 @@ func Init() bool {
 	FatSz16 = uint16(fatBuf[22]) | uint16(fatBuf[23])<<8
 
 	if BytesPerSec != 512 {
-		terminal.Print("FAT16 Error: BytesPerSec != 512\n")
+		if !quiet {
+			terminal.Print("FAT16 Error: BytesPerSec != 512\n")
+		}
 		return false
 	}
 
@@ -107,7 +124,7 @@ func Format() bool {
 	fatBuf[510] = 0x55
 	fatBuf[511] = 0xAA
 
-	if !ata.WriteSector(0, &fatBuf) {
+	if !block.WriteSector(0, &fatBuf) {
 		return false
 	}
 
@@ -124,14 +141,14 @@ func Format() bool {
 
 	// Zero out all sectors of FAT1 (sectors 1 to 160)
 	for sec := uint32(0); sec < fatSz16; sec++ {
-		if !ata.WriteSector(reservedSec+sec, &fatBuf) {
+		if !block.WriteSector(reservedSec+sec, &fatBuf) {
 			return false
 		}
 	}
 
 	// Zero out all sectors of FAT2 (sectors 161 to 320)
 	for sec := uint32(0); sec < fatSz16; sec++ {
-		if !ata.WriteSector(reservedSec+fatSz16+sec, &fatBuf) {
+		if !block.WriteSector(reservedSec+fatSz16+sec, &fatBuf) {
 			return false
 		}
 	}
@@ -143,7 +160,7 @@ func Format() bool {
 
 	// Zero out all root directory sectors
 	for sec := uint32(0); sec < rootSectors; sec++ {
-		if !ata.WriteSector(rootStart+sec, &fatBuf) {
+		if !block.WriteSector(rootStart+sec, &fatBuf) {
 			return false
 		}
 	}
@@ -168,6 +185,34 @@ func Info() {
 	terminal.Print("\n")
 }
 
+// printTimestamp prints t as YYYY-MM-DD HH:MM
+func printTimestamp(t rtc.Time) {
+	printDec(uint32(t.Year), 4)
+	terminal.PutRune('-')
+	printDec(uint32(t.Month), 2)
+	terminal.PutRune('-')
+	printDec(uint32(t.Day), 2)
+	terminal.PutRune(' ')
+	printDec(uint32(t.Hour), 2)
+	terminal.PutRune(':')
+	printDec(uint32(t.Minute), 2)
+}
+
+// printDec prints v in decimal, zero padded to width digits
+func printDec(v uint32, width int) {
+	var digits [10]byte
+	n := 0
+	for v > 0 || n < width {
+		digits[n] = byte('0' + v%10)
+		v /= 10
+		n++
+	}
+	for n > 0 {
+		n--
+		terminal.PutRune(rune(digits[n]))
+	}
+}
+
 func printU16(v uint16) {
 	printU32(uint32(v))
 }
@@ -191,7 +236,7 @@ func ListDir() {
 	entriesPerSector := 512 / DirEntrySize // 16
 
 	for sec := uint32(0); sec < rootSectors; sec++ {
-		if !ata.ReadSector(rootStart+sec, &fatBuf) {
+		if !block.ReadSector(rootStart+sec, &fatBuf) {
 			terminal.Print("FAT16: Read error\n")
 			return
 		}
@@ -233,11 +278,56 @@ func ListDir() {
 				uint32(fatBuf[off+30])<<16 | uint32(fatBuf[off+31])<<24
 			terminal.Print("  ")
 			printU32(size)
-			terminal.Print(" bytes\n")
+			terminal.Print(" bytes")
+
+			if t, ok := unpackTimestamp(getU16(&fatBuf, off+dirModifyDate), getU16(&fatBuf, off+dirModifyTime)); ok {
+				terminal.Print("  ")
+				printTimestamp(t)
+			}
+			terminal.PutRune('\n')
 		}
 	}
 }
 
+// DirName writes the name of root directory entry i as NAME.EXT and
+// returns its length, 0 for free, deleted and label entries. more turns
+// false past the last entry.
+func DirName(i int, out *[12]byte) (n int, more bool) {
+	entriesPerSector := 512 / DirEntrySize
+	sec := uint32(i / entriesPerSector)
+	if !initialized || i < 0 || sec >= rootSectors {
+		return 0, false
+	}
+	if !block.ReadSector(rootStart+sec, &fatBuf) {
+		return 0, false
+	}
+
+	off := (i % entriesPerSector) * DirEntrySize
+	switch {
+	case fatBuf[off] == 0x00:
+		return 0, false
+	case fatBuf[off] == 0xE5, fatBuf[off+11]&0x08 != 0:
+		return 0, true
+	}
+	for j := 0; j < 8; j++ {
+		if c := fatBuf[off+j]; c != ' ' {
+			out[n] = c
+			n++
+		}
+	}
+	if fatBuf[off+8] != ' ' {
+		out[n] = '.'
+		n++
+		for j := 8; j < 11; j++ {
+			if c := fatBuf[off+j]; c != ' ' {
+				out[n] = c
+				n++
+			}
+		}
+	}
+	return n, true
+}
+
 // CreateFile creates a file in the root directory
 func CreateFile(name *[8]byte, ext *[3]byte, data *[512]byte, dataLen uint32) bool {
 	if !initialized {
@@ -247,7 +337,7 @@ func CreateFile(name *[8]byte, ext *[3]byte, data *[512]byte, dataLen uint32) bo
 
 	// Check if file with same name already exists
 	for sec := uint32(0); sec < rootSectors; sec++ {
-		if !ata.ReadSector(rootStart+sec, &fatBuf) {
+		if !block.ReadSector(rootStart+sec, &fatBuf) {
 			return false
 		}
 
@@ -308,7 +398,7 @@ func CreateFile(name *[8]byte, ext *[3]byte, data *[512]byte, dataLen uint32) bo
 	var dirOff int
 
 	for sec := uint32(0); sec < rootSectors && !entryFound; sec++ {
-		if !ata.ReadSector(rootStart+sec, &fatBuf) {
+		if !block.ReadSector(rootStart+sec, &fatBuf) {
 			return false
 		}
 
@@ -330,7 +420,7 @@ func CreateFile(name *[8]byte, ext *[3]byte, data *[512]byte, dataLen uint32) bo
 	}
 
 	// Re-read sector for modification
-	if !ata.ReadSector(rootStart+dirSec, &fatBuf) {
+	if !block.ReadSector(rootStart+dirSec, &fatBuf) {
 		return false
 	}
 
@@ -349,6 +439,13 @@ func CreateFile(name *[8]byte, ext *[3]byte, data *[512]byte, dataLen uint32) bo
 	for i := 12; i < 26; i++ {
 		fatBuf[dirOff+i] = 0
 	}
+	// Created, modified and accessed now (zero when there is no clock)
+	date, clock := currentTimestamp()
+	putU16(&fatBuf, dirOff+dirCreateTime, clock)
+	putU16(&fatBuf, dirOff+dirCreateDate, date)
+	putU16(&fatBuf, dirOff+dirAccessDate, date)
+	putU16(&fatBuf, dirOff+dirModifyTime, clock)
+	putU16(&fatBuf, dirOff+dirModifyDate, date)
 	// First cluster (bytes 26-27, little endian)
 	fatBuf[dirOff+26] = byte(cluster & 0xFF)
 	fatBuf[dirOff+27] = byte((cluster >> 8) & 0xFF)
@@ -358,7 +455,7 @@ func CreateFile(name *[8]byte, ext *[3]byte, data *[512]byte, dataLen uint32) bo
 	fatBuf[dirOff+30] = byte((dataLen >> 16) & 0xFF)
 	fatBuf[dirOff+31] = byte((dataLen >> 24) & 0xFF)
 
-	if !ata.WriteSector(rootStart+dirSec, &fatBuf) {
+	if !block.WriteSector(rootStart+dirSec, &fatBuf) {
 		return false
 	}
 
@@ -372,13 +469,105 @@ func CreateFile(name *[8]byte, ext *[3]byte, data *[512]byte, dataLen uint32) bo
 			fatBuf[i] = 0
 		}
 	}
-	if !ata.WriteSector(dataSector, &fatBuf) {
+	if !block.WriteSector(dataSector, &fatBuf) {
 		return false
 	}
 
 	return true
 }
 
+// WriteFile replaces the contents of a file with the first sector of data,
+// creating the file when there is none
+func WriteFile(name *[8]byte, ext *[3]byte, data *[512]byte, dataLen uint32) bool {
+	if !initialized {
+		terminal.Print("FAT16: Not initialized\n")
+		return false
+	}
+	sec, off, found := findEntry(name, ext)
+	if !found {
+		return CreateFile(name, ext, data, dataLen)
+	}
+	if fatBuf[off+11]&0x10 != 0 {
+		terminal.Print("FAT16: Is a directory\n")
+		return false
+	}
+	if dataLen > 512 {
+		dataLen = 512
+	}
+
+	// findEntry left the directory sector in fatBuf
+	cluster := uint16(fatBuf[off+26]) | uint16(fatBuf[off+27])<<8
+	if cluster < 2 {
+		// An empty file may have no cluster yet: give it one as
+		// CreateFile does
+		cluster = findFreeCluster()
+		if cluster == 0 {
+			terminal.Print("FAT16: No free clusters\n")
+			return false
+		}
+		if !setFATEntry(cluster, 0xFFFF) {
+			terminal.Print("FAT16: FAT write error\n")
+			return false
+		}
+		// Both went through fatBuf, so read the entry back
+		if !block.ReadSector(rootStart+sec, &fatBuf) {
+			return false
+		}
+		fatBuf[off+26] = byte(cluster & 0xFF)
+		fatBuf[off+27] = byte((cluster >> 8) & 0xFF)
+	}
+	date, clock := currentTimestamp()
+	putU16(&fatBuf, off+dirAccessDate, date)
+	putU16(&fatBuf, off+dirModifyTime, clock)
+	putU16(&fatBuf, off+dirModifyDate, date)
+	fatBuf[off+28] = byte(dataLen & 0xFF)
+	fatBuf[off+29] = byte((dataLen >> 8) & 0xFF)
+	fatBuf[off+30] = byte((dataLen >> 16) & 0xFF)
+	fatBuf[off+31] = byte((dataLen >> 24) & 0xFF)
+	if !block.WriteSector(rootStart+sec, &fatBuf) {
+		return false
+	}
+
+	for i := 0; i < 512; i++ {
+		if uint32(i) < dataLen {
+			fatBuf[i] = data[i]
+		} else {
+			fatBuf[i] = 0
+		}
+	}
+	return block.WriteSector(clusterToSector(cluster), &fatBuf)
+}
+
+// findEntry looks a file up in the root directory, returning the sector
+// and offset of its entry with that sector in fatBuf
+func findEntry(name *[8]byte, ext *[3]byte) (sec uint32, off int, ok bool) {
+	for sec = 0; sec < rootSectors; sec++ {
+		if !block.ReadSector(rootStart+sec, &fatBuf) {
+			return 0, 0, false
+		}
+		for i := 0; i < 16; i++ {
+			off = i * DirEntrySize
+			if fatBuf[off] == 0x00 {
+				return 0, 0, false
+			}
+			if fatBuf[off] == 0xE5 || fatBuf[off+11]&0x08 != 0 {
+				continue
+			}
+			match := true
+			for j := 0; j < 8 && match; j++ {
+				match = fatBuf[off+j] == name[j]
+			}
+			for j := 0; j < 3 && match; j++ {
+				match = fatBuf[off+8+j] == ext[j]
+			}
+			if match {
+				return sec, off, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
 // ReadFile reads a file by name into the provided buffer
 func ReadFile(name *[8]byte, ext *[3]byte, outBuf *[512]byte) (uint32, bool) {
 	if !initialized {
@@ -387,7 +576,7 @@ func ReadFile(name *[8]byte, ext *[3]byte, outBuf *[512]byte) (uint32, bool) {
 
 	// Find file in root directory
 	for sec := uint32(0); sec < rootSectors; sec++ {
-		if !ata.ReadSector(rootStart+sec, &fatBuf) {
+		if !block.ReadSector(rootStart+sec, &fatBuf) {
 			return 0, false
 		}
 
@@ -427,7 +616,7 @@ func ReadFile(name *[8]byte, ext *[3]byte, outBuf *[512]byte) (uint32, bool) {
 
 				// Read data from cluster
 				dataSector := clusterToSector(cluster)
-				if !ata.ReadSector(dataSector, outBuf) {
+				if !block.ReadSector(dataSector, outBuf) {
 					return 0, false
 				}
 				return size, true
@@ -445,7 +634,7 @@ func findFreeCluster() uint16 {
 	entriesPerSector := 256 // 512 / 2
 
 	for sec := uint32(0); sec < uint32(FatSz16); sec++ {
-		if !ata.ReadSector(fatStart+sec, &fatBuf) {
+		if !block.ReadSector(fatStart+sec, &fatBuf) {
 			return 0
 		}
 
@@ -471,7 +660,7 @@ func setFATEntry(cluster uint16, value uint16) bool {
 	sec := fatOffset / 512
 	off := fatOffset % 512
 
-	if !ata.ReadSector(fatStart+sec, &fatBuf) {
+	if !block.ReadSector(fatStart+sec, &fatBuf) {
 		return false
 	}
 
@@ -479,11 +668,11 @@ func setFATEntry(cluster uint16, value uint16) bool {
 	fatBuf[off+1] = byte((value >> 8) & 0xFF)
 
 	// Write to both FATs
-	if !ata.WriteSector(fatStart+sec, &fatBuf) {
+	if !block.WriteSector(fatStart+sec, &fatBuf) {
 		return false
 	}
 	// FAT2
-	ata.WriteSector(fatStart+uint32(FatSz16)+sec, &fatBuf)
+	block.WriteSector(fatStart+uint32(FatSz16)+sec, &fatBuf)
 
 	return true
 }
@@ -2,6 +2,8 @@
 
 package fat16
 
+import "strings"
+
 var (
 	BytesPerSec uint16
 	SecPerClust uint8
@@ -20,6 +22,11 @@ func Init() bool {
 	return true
 }
 
+func Mount() bool {
+	initialized = true
+	return true
+}
+
 func Format() bool {
 	return true
 }
@@ -28,10 +35,49 @@ func Info() {}
 
 func ListDir() {}
 
+// NamesForTesting is the root directory DirName walks
+var NamesForTesting []string
+
+func DirName(i int, out *[12]byte) (n int, more bool) {
+	if i < 0 || i >= len(NamesForTesting) {
+		return 0, false
+	}
+	return copy(out[:], NamesForTesting[i]), true
+}
+
 func CreateFile(name *[8]byte, ext *[3]byte, data *[512]byte, dataLen uint32) bool {
 	return false
 }
 
+// FilesForTesting holds what ReadFile returns and WriteFile writes, keyed
+// by "NAME.EXT"
+var FilesForTesting map[string]string
+
+func fileKey(name *[8]byte, ext *[3]byte) string {
+	key := strings.TrimRight(string(name[:]), " ")
+	if e := strings.TrimRight(string(ext[:]), " "); e != "" {
+		key += "." + e
+	}
+	return key
+}
+
+func WriteFile(name *[8]byte, ext *[3]byte, data *[512]byte, dataLen uint32) bool {
+	if FilesForTesting == nil {
+		return false
+	}
+	if dataLen > 512 {
+		dataLen = 512
+	}
+	FilesForTesting[fileKey(name, ext)] = string(data[:dataLen])
+	return true
+}
+
 func ReadFile(name *[8]byte, ext *[3]byte, outBuf *[512]byte) (uint32, bool) {
-	return 0, false
+	data, ok := FilesForTesting[fileKey(name, ext)]
+	if !ok {
+		return 0, false
+	}
+	// Like the driver, it returns the size of the whole file
+	copy(outBuf[:], data)
+	return uint32(len(data)), true
 }
@@ -0,0 +1,61 @@
+package fat16
+
+import "github.com/dmarro89/go-dav-os/drivers/rtc"
+
+// FAT dates count years from 1980 in 7 bits and times keep 2-second steps
+const (
+	fatEpochYear = 1980
+	fatMaxYear   = fatEpochYear + 127
+
+	// Directory entry timestamp fields (little endian)
+	dirCreateTenths = 13
+	dirCreateTime   = 14
+	dirCreateDate   = 16
+	dirAccessDate   = 18
+	dirModifyTime   = 22
+	dirModifyDate   = 24
+)
+
+// packTimestamp encodes t as FAT date and time words. Years outside the FAT
+// range yield zero, which readers treat as "no timestamp".
+func packTimestamp(t rtc.Time) (date, clock uint16) {
+	if t.Year < fatEpochYear || t.Year > fatMaxYear {
+		return 0, 0
+	}
+	date = (t.Year-fatEpochYear)<<9 | uint16(t.Month)<<5 | uint16(t.Day)
+	clock = uint16(t.Hour)<<11 | uint16(t.Minute)<<5 | uint16(t.Second/2)
+	return date, clock
+}
+
+// unpackTimestamp is the inverse of packTimestamp; ok is false for a zero date
+func unpackTimestamp(date, clock uint16) (t rtc.Time, ok bool) {
+	if date == 0 {
+		return rtc.Time{}, false
+	}
+	t.Year = fatEpochYear + date>>9
+	t.Month = uint8(date >> 5 & 0x0F)
+	t.Day = uint8(date & 0x1F)
+	t.Hour = uint8(clock >> 11)
+	t.Minute = uint8(clock >> 5 & 0x3F)
+	t.Second = uint8(clock&0x1F) * 2
+	return t, true
+}
+
+// currentTimestamp packs the wall clock, or returns zeros when the RTC was
+// not readable at boot
+func currentTimestamp() (date, clock uint16) {
+	t, ok := rtc.Now()
+	if !ok {
+		return 0, 0
+	}
+	return packTimestamp(t)
+}
+
+func putU16(buf *[512]byte, off int, v uint16) {
+	buf[off] = byte(v)
+	buf[off+1] = byte(v >> 8)
+}
+
+func getU16(buf *[512]byte, off int) uint16 {
+	return uint16(buf[off]) | uint16(buf[off+1])<<8
+}
@@ -0,0 +1,33 @@
+package fat16
+
+import (
+	"testing"
+
+	"github.com/dmarro89/go-dav-os/drivers/rtc"
+)
+
+func TestPackTimestamp(t *testing.T) {
+	in := rtc.Time{Year: 2026, Month: 10, Day: 18, Hour: 23, Minute: 7, Second: 59}
+	date, clock := packTimestamp(in)
+	if date != 46<<9|10<<5|18 {
+		t.Fatalf("date = 0x%04x", date)
+	}
+	if clock != 23<<11|7<<5|29 {
+		t.Fatalf("clock = 0x%04x", clock)
+	}
+
+	out, ok := unpackTimestamp(date, clock)
+	in.Second = 58 // FAT keeps 2-second resolution
+	if !ok || out != in {
+		t.Fatalf("unpackTimestamp = %+v, %v; want %+v", out, ok, in)
+	}
+}
+
+func TestPackTimestampOutOfRange(t *testing.T) {
+	if d, c := packTimestamp(rtc.Time{Year: 1979, Month: 12, Day: 31}); d != 0 || c != 0 {
+		t.Fatalf("pre-1980 date should pack to zero, got 0x%04x 0x%04x", d, c)
+	}
+	if _, ok := unpackTimestamp(0, 0); ok {
+		t.Fatalf("a zero date means no timestamp")
+	}
+}
@@ -0,0 +1,146 @@
+package acpi
+
+import "unsafe"
+
+const (
+	rsdpSignature   = "RSD PTR "
+	rsdpV1Length    = 20
+	rsdpV2Length    = 36
+	sdtHeaderLength = 36
+
+	rsdpRevisionOffset = 15
+	rsdpRSDTOffset     = 16
+	rsdpLengthOffset   = 20
+	rsdpXSDTOffset     = 24
+
+	sdtLengthOffset = 4
+)
+
+var (
+	ready    bool
+	rootAddr uint64
+	// rootEntrySize is 8 for the XSDT and 4 for the legacy RSDT
+	rootEntrySize uintptr
+)
+
+// readU8 reads a byte from a physical address (identity mapped)
+func readU8(addr uintptr) byte {
+	return *(*byte)(unsafe.Pointer(addr))
+}
+
+func readU16(addr uintptr) uint16 {
+	return uint16(readU8(addr)) | uint16(readU8(addr+1))<<8
+}
+
+func readU32(addr uintptr) uint32 {
+	return uint32(readU16(addr)) | uint32(readU16(addr+2))<<16
+}
+
+func readU64(addr uintptr) uint64 {
+	return uint64(readU32(addr)) | uint64(readU32(addr+4))<<32
+}
+
+// checksumOK reports whether the bytes in [addr, addr+length) sum to zero,
+// which is how every ACPI structure validates itself
+func checksumOK(addr uintptr, length uint32) bool {
+	var sum byte
+	for i := uint32(0); i < length; i++ {
+		sum += readU8(addr + uintptr(i))
+	}
+	return sum == 0
+}
+
+func signatureMatches(addr uintptr, sig string) bool {
+	for i := 0; i < len(sig); i++ {
+		if readU8(addr+uintptr(i)) != sig[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Init validates the RSDP at rsdpAddr and records the root table (XSDT when
+// the firmware provides one, RSDT otherwise). Returns false if no usable
+// root table is found.
+func Init(rsdpAddr uint64) bool {
+	ready = false
+	rootAddr = 0
+	rootEntrySize = 0
+	if rsdpAddr == 0 {
+		return false
+	}
+
+	rsdp := uintptr(rsdpAddr)
+	if !signatureMatches(rsdp, rsdpSignature) || !checksumOK(rsdp, rsdpV1Length) {
+		return false
+	}
+
+	if readU8(rsdp+rsdpRevisionOffset) >= 2 {
+		length := readU32(rsdp + rsdpLengthOffset)
+		xsdt := readU64(rsdp + rsdpXSDTOffset)
+		if length >= rsdpV2Length && checksumOK(rsdp, length) && xsdt != 0 {
+			rootAddr = xsdt
+			rootEntrySize = 8
+		}
+	}
+
+	if rootAddr == 0 {
+		rootAddr = uint64(readU32(rsdp + rsdpRSDTOffset))
+		rootEntrySize = 4
+	}
+	if rootAddr == 0 || !validTable(uintptr(rootAddr)) {
+		rootAddr = 0
+		return false
+	}
+
+	ready = true
+	return true
+}
+
+// Ready reports whether Init found a valid root table
+func Ready() bool { return ready }
+
+func validTable(addr uintptr) bool {
+	length := readU32(addr + sdtLengthOffset)
+	if length < sdtHeaderLength {
+		return false
+	}
+	return checksumOK(addr, length)
+}
+
+// FindTable returns the physical address of the first table whose 4-byte
+// signature matches sig, or 0 if it is missing or fails its checksum
+func FindTable(sig string) uint64 {
+	if !ready || len(sig) != 4 {
+		return 0
+	}
+
+	root := uintptr(rootAddr)
+	length := readU32(root + sdtLengthOffset)
+	count := (uintptr(length) - sdtHeaderLength) / rootEntrySize
+
+	for i := uintptr(0); i < count; i++ {
+		entry := root + sdtHeaderLength + i*rootEntrySize
+		var table uint64
+		if rootEntrySize == 8 {
+			table = readU64(entry)
+		} else {
+			table = uint64(readU32(entry))
+		}
+		if table == 0 {
+			continue
+		}
+		if signatureMatches(uintptr(table), sig) && validTable(uintptr(table)) {
+			return table
+		}
+	}
+	return 0
+}
+
+// TableLength returns the length field of the table at addr
+func TableLength(addr uint64) uint32 {
+	if addr == 0 {
+		return 0
+	}
+	return readU32(uintptr(addr) + sdtLengthOffset)
+}
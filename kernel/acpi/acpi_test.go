@@ -0,0 +1,206 @@
+package acpi
+
+import (
+	"encoding/binary"
+	"testing"
+	"unsafe"
+)
+
+// fakeTable builds an SDT with a valid checksum. The returned slice must stay
+// referenced for as long as its address is used by the parser.
+func fakeTable(sig string, body []byte) []byte {
+	t := make([]byte, sdtHeaderLength+len(body))
+	copy(t, sig)
+	binary.LittleEndian.PutUint32(t[sdtLengthOffset:], uint32(len(t)))
+	copy(t[sdtHeaderLength:], body)
+	fixChecksum(t, 9)
+	return t
+}
+
+func fixChecksum(b []byte, at int) {
+	b[at] = 0
+	var sum byte
+	for _, v := range b {
+		sum += v
+	}
+	b[at] = -sum
+}
+
+func addr(b []byte) uint64 {
+	return uint64(uintptr(unsafe.Pointer(&b[0])))
+}
+
+func fakeRSDP(revision byte, rsdt uint32, xsdt uint64) []byte {
+	r := make([]byte, rsdpV2Length)
+	copy(r, rsdpSignature)
+	r[rsdpRevisionOffset] = revision
+	binary.LittleEndian.PutUint32(r[rsdpRSDTOffset:], rsdt)
+	fixChecksum(r[:rsdpV1Length], 8)
+	if revision >= 2 {
+		binary.LittleEndian.PutUint32(r[rsdpLengthOffset:], rsdpV2Length)
+		binary.LittleEndian.PutUint64(r[rsdpXSDTOffset:], xsdt)
+		fixChecksum(r, 32)
+	}
+	return r
+}
+
+func fakeHPET(base uint64) []byte {
+	body := make([]byte, hpetMinLength-sdtHeaderLength)
+	binary.LittleEndian.PutUint64(body[hpetAddressOffset-sdtHeaderLength:], base)
+	return fakeTable(hpetSignature, body)
+}
+
+func TestInitRejectsBadRSDP(t *testing.T) {
+	if Init(0) {
+		t.Fatalf("Init(0) should fail")
+	}
+
+	r := fakeRSDP(0, 0, 0)
+	r[0] = 'X'
+	if Init(addr(r)) {
+		t.Fatalf("Init should reject a bad signature")
+	}
+
+	r = fakeRSDP(0, 0, 0)
+	r[8]++
+	if Init(addr(r)) {
+		t.Fatalf("Init should reject a bad checksum")
+	}
+}
+
+func TestFindTableThroughXSDT(t *testing.T) {
+	hpet := fakeHPET(0xFED00000)
+	apic := fakeTable("APIC", make([]byte, 8))
+
+	entries := make([]byte, 16)
+	binary.LittleEndian.PutUint64(entries[0:], addr(apic))
+	binary.LittleEndian.PutUint64(entries[8:], addr(hpet))
+	xsdt := fakeTable("XSDT", entries)
+	rsdp := fakeRSDP(2, 0, addr(xsdt))
+
+	if !Init(addr(rsdp)) {
+		t.Fatalf("Init should accept a valid ACPI 2.0 RSDP")
+	}
+	if got := FindTable("APIC"); got != addr(apic) {
+		t.Fatalf("FindTable(APIC) = 0x%x, want 0x%x", got, addr(apic))
+	}
+	if got := FindTable("MCFG"); got != 0 {
+		t.Fatalf("FindTable(MCFG) = 0x%x, want 0", got)
+	}
+
+	base, ok := HPETBase()
+	if !ok || base != 0xFED00000 {
+		t.Fatalf("HPETBase() = (0x%x, %v), want (0xFED00000, true)", base, ok)
+	}
+}
+
+func TestFindTableSkipsCorruptTables(t *testing.T) {
+	hpet := fakeHPET(0xFED00000)
+	hpet[20]++ // break the checksum
+
+	// RSDT entries are 32-bit, so the tables must live below 4 GiB in the
+	// kernel; host addresses may not, which is why this case uses the XSDT.
+	entries := make([]byte, 8)
+	binary.LittleEndian.PutUint64(entries, addr(hpet))
+	xsdt := fakeTable("XSDT", entries)
+	rsdp := fakeRSDP(2, 0, addr(xsdt))
+
+	if !Init(addr(rsdp)) {
+		t.Fatalf("Init should accept a valid RSDP")
+	}
+	if _, ok := HPETBase(); ok {
+		t.Fatalf("HPETBase should ignore a table with a bad checksum")
+	}
+}
+
+func TestCenturyRegisterFromFADT(t *testing.T) {
+	body := make([]byte, fadtMinLength-sdtHeaderLength)
+	body[fadtCenturyOffset-sdtHeaderLength] = 0x32
+	fadt := fakeTable(fadtSignature, body)
+
+	entries := make([]byte, 8)
+	binary.LittleEndian.PutUint64(entries, addr(fadt))
+	xsdt := fakeTable("XSDT", entries)
+	rsdp := fakeRSDP(2, 0, addr(xsdt))
+
+	if !Init(addr(rsdp)) {
+		t.Fatalf("Init should accept a valid RSDP")
+	}
+	if got := CenturyRegister(); got != 0x32 {
+		t.Fatalf("CenturyRegister() = 0x%x, want 0x32", got)
+	}
+
+	Init(0)
+	if got := CenturyRegister(); got != 0 {
+		t.Fatalf("CenturyRegister() without ACPI = 0x%x, want 0", got)
+	}
+}
+
+func TestLocalAPICsFromMADT(t *testing.T) {
+	body := make([]byte, madtEntriesOffset-sdtHeaderLength)
+	binary.LittleEndian.PutUint32(body[madtLocalAPICOffset-sdtHeaderLength:], 0xFEE00000)
+	body = append(body,
+		madtTypeLocalAPIC, 8, 0, 0, 1, 0, 0, 0, // CPU 0, APIC 0, enabled
+		madtTypeLocalAPIC, 8, 1, 2, 0, 0, 0, 0, // CPU 1, APIC 2, disabled
+		1, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // I/O APIC, skipped
+		madtTypeLocalAPIC, 8, 2, 4, 2, 0, 0, 0, // CPU 2, APIC 4, online capable
+	)
+	madt := fakeTable(madtSignature, body)
+
+	entries := make([]byte, 8)
+	binary.LittleEndian.PutUint64(entries, addr(madt))
+	xsdt := fakeTable("XSDT", entries)
+	rsdp := fakeRSDP(2, 0, addr(xsdt))
+	if !Init(addr(rsdp)) {
+		t.Fatalf("Init should accept a valid RSDP")
+	}
+
+	var cpus [MaxProcessors]LocalAPIC
+	n, base := LocalAPICs(&cpus)
+	if base != 0xFEE00000 {
+		t.Fatalf("local APIC base = 0x%x, want 0xFEE00000", base)
+	}
+	want := []LocalAPIC{
+		{ProcessorID: 0, APICID: 0, Usable: true},
+		{ProcessorID: 1, APICID: 2, Usable: false},
+		{ProcessorID: 2, APICID: 4, Usable: true},
+	}
+	if n != len(want) {
+		t.Fatalf("LocalAPICs found %d processors, want %d", n, len(want))
+	}
+	for i := range want {
+		if cpus[i] != want[i] {
+			t.Fatalf("processor %d = %+v, want %+v", i, cpus[i], want[i])
+		}
+	}
+}
+
+func TestMCFGPicksSegmentZero(t *testing.T) {
+	body := make([]byte, mcfgEntriesOffset-sdtHeaderLength+2*mcfgEntrySize)
+	other := body[mcfgEntriesOffset-sdtHeaderLength:]
+	binary.LittleEndian.PutUint64(other, 0xC0000000)
+	binary.LittleEndian.PutUint16(other[mcfgSegmentOffset:], 1)
+	seg0 := other[mcfgEntrySize:]
+	binary.LittleEndian.PutUint64(seg0, 0xB0000000)
+	seg0[mcfgStartBusOffset] = 0
+	seg0[mcfgEndBusOffset] = 0xFF
+	mcfg := fakeTable(mcfgSignature, body)
+
+	entries := make([]byte, 8)
+	binary.LittleEndian.PutUint64(entries, addr(mcfg))
+	xsdt := fakeTable("XSDT", entries)
+	rsdp := fakeRSDP(2, 0, addr(xsdt))
+	if !Init(addr(rsdp)) {
+		t.Fatalf("Init should accept a valid RSDP")
+	}
+
+	base, start, end, ok := MCFG()
+	if !ok || base != 0xB0000000 || start != 0 || end != 0xFF {
+		t.Fatalf("MCFG() = (0x%x, %d, %d, %v), want (0xB0000000, 0, 255, true)", base, start, end, ok)
+	}
+
+	Init(0)
+	if _, _, _, ok := MCFG(); ok {
+		t.Fatalf("MCFG should report no window without ACPI")
+	}
+}
@@ -0,0 +1,19 @@
+package acpi
+
+const (
+	fadtSignature = "FACP"
+
+	// CMOS index of the RTC century register, 0 when the RTC has none
+	fadtCenturyOffset = 108
+	fadtMinLength     = 109
+)
+
+// CenturyRegister returns the CMOS register holding the RTC century as
+// advertised by the FADT, or 0 when there is no FADT or no such register
+func CenturyRegister() byte {
+	table := FindTable(fadtSignature)
+	if table == 0 || TableLength(table) < fadtMinLength {
+		return 0
+	}
+	return readU8(uintptr(table) + fadtCenturyOffset)
+}
@@ -0,0 +1,31 @@
+package acpi
+
+const (
+	hpetSignature = "HPET"
+
+	// Generic Address Structure of the event timer block, right after the header
+	hpetAddressSpaceOffset = 40
+	hpetAddressOffset      = 44
+	hpetMinLength          = 56
+
+	addressSpaceMemory = 0
+)
+
+// HPETBase returns the MMIO base address of the first HPET block described
+// by the firmware, or ok=false when there is no HPET table
+func HPETBase() (addr uint64, ok bool) {
+	table := FindTable(hpetSignature)
+	if table == 0 || TableLength(table) < hpetMinLength {
+		return 0, false
+	}
+
+	t := uintptr(table)
+	if readU8(t+hpetAddressSpaceOffset) != addressSpaceMemory {
+		return 0, false
+	}
+	addr = readU64(t + hpetAddressOffset)
+	if addr == 0 {
+		return 0, false
+	}
+	return addr, true
+}
@@ -0,0 +1,70 @@
+package acpi
+
+const (
+	madtSignature = "APIC"
+
+	madtLocalAPICOffset = 36 // 32-bit local APIC physical address
+	madtEntriesOffset   = 44
+
+	madtTypeLocalAPIC         = 0
+	madtTypeLocalAPICOverride = 5
+
+	madtLocalAPICEnabled       = 0x1
+	madtLocalAPICOnlineCapable = 0x2
+
+	// MaxProcessors caps how many local APIC entries LocalAPICs reports
+	MaxProcessors = 32
+)
+
+// LocalAPIC describes one processor entry of the MADT
+type LocalAPIC struct {
+	ProcessorID uint8
+	APICID      uint8
+	// Usable is set when the processor is enabled or can be brought online
+	Usable bool
+}
+
+// LocalAPICs fills out with the processors listed in the MADT and returns
+// how many were found along with the local APIC MMIO base. base is 0 when
+// there is no MADT.
+func LocalAPICs(out *[MaxProcessors]LocalAPIC) (n int, base uint64) {
+	table := FindTable(madtSignature)
+	if table == 0 {
+		return 0, 0
+	}
+	length := TableLength(table)
+	if length < madtEntriesOffset {
+		return 0, 0
+	}
+
+	t := uintptr(table)
+	base = uint64(readU32(t + madtLocalAPICOffset))
+
+	off := uintptr(madtEntriesOffset)
+	for off+2 <= uintptr(length) {
+		typ := readU8(t + off)
+		size := uintptr(readU8(t + off + 1))
+		if size < 2 || off+size > uintptr(length) {
+			break
+		}
+
+		switch typ {
+		case madtTypeLocalAPIC:
+			if size >= 8 && n < MaxProcessors {
+				flags := readU32(t + off + 4)
+				out[n] = LocalAPIC{
+					ProcessorID: readU8(t + off + 2),
+					APICID:      readU8(t + off + 3),
+					Usable:      flags&(madtLocalAPICEnabled|madtLocalAPICOnlineCapable) != 0,
+				}
+				n++
+			}
+		case madtTypeLocalAPICOverride:
+			if size >= 12 {
+				base = readU64(t + off + 4)
+			}
+		}
+		off += size
+	}
+	return n, base
+}
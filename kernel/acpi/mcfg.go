@@ -0,0 +1,41 @@
+package acpi
+
+const (
+	mcfgSignature = "MCFG"
+
+	// Configuration space allocations follow 8 reserved bytes after the
+	// header, 16 bytes each
+	mcfgEntriesOffset = 44
+	mcfgEntrySize     = 16
+
+	mcfgBaseOffset     = 0
+	mcfgSegmentOffset  = 8
+	mcfgStartBusOffset = 10
+	mcfgEndBusOffset   = 11
+)
+
+// MCFG returns the PCI Express memory-mapped configuration (ECAM) window of
+// segment group 0 and the bus range it decodes, or ok=false when the
+// firmware has no MCFG table (QEMU's default i440fx machine, for one)
+func MCFG() (base uint64, startBus, endBus uint8, ok bool) {
+	table := FindTable(mcfgSignature)
+	if table == 0 {
+		return 0, 0, 0, false
+	}
+	length := uintptr(TableLength(table))
+
+	t := uintptr(table)
+	for off := uintptr(mcfgEntriesOffset); off+mcfgEntrySize <= length; off += mcfgEntrySize {
+		if readU16(t+off+mcfgSegmentOffset) != 0 {
+			continue
+		}
+		base = readU64(t + off + mcfgBaseOffset)
+		startBus = readU8(t + off + mcfgStartBusOffset)
+		endBus = readU8(t + off + mcfgEndBusOffset)
+		if base == 0 || endBus < startBus {
+			continue
+		}
+		return base, startBus, endBus, true
+	}
+	return 0, 0, 0, false
+}
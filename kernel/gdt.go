@@ -6,6 +6,7 @@ import (
 	"unsafe"
 
 	gdtlib "github.com/dmarro89/go-dav-os/kernel/gdt"
+	"github.com/dmarro89/go-dav-os/kernel/percpu"
 	tsslib "github.com/dmarro89/go-dav-os/kernel/tss"
 )
 
@@ -16,28 +17,31 @@ const (
 	userDataDescriptor   uint64 = 0x00CFF2000000FFFF
 )
 
+// Every CPU gets its own GDT because the TSS descriptor differs per CPU
 var (
-	gdt         [7]uint64
-	gdtRegister [10]byte
+	gdt         [percpu.MaxCPUs][7]uint64
+	gdtRegister [percpu.MaxCPUs][10]byte
 )
 
 func LoadGDT(p *[10]byte)
 func LoadDataSegments(sel uint16)
 
-func initGDT() {
-	gdt[0] = 0
-	gdt[1] = kernelCodeDescriptor
-	gdt[2] = kernelDataDescriptor
-	gdt[3] = userCodeDescriptor
-	gdt[4] = userDataDescriptor
+func initGDT(cpu int) {
+	g := &gdt[cpu]
+	g[0] = 0
+	g[1] = kernelCodeDescriptor
+	g[2] = kernelDataDescriptor
+	g[3] = userCodeDescriptor
+	g[4] = userDataDescriptor
 }
 
-func setTSSDescriptor(base uintptr, limit uint32) {
-	gdt[5], gdt[6] = tsslib.EncodeTSSDescriptor(base, limit)
+func setTSSDescriptor(cpu int, base uintptr, limit uint32) {
+	gdt[cpu][5], gdt[cpu][6] = tsslib.EncodeTSSDescriptor(base, limit)
 }
 
-func loadGDT() {
-	gdtlib.PackGDTR(uint16(len(gdt)*8-1), uint64(uintptr(unsafe.Pointer(&gdt[0]))), &gdtRegister)
-	LoadGDT(&gdtRegister)
+func loadGDT(cpu int) {
+	g := &gdt[cpu]
+	gdtlib.PackGDTR(uint16(len(g)*8-1), uint64(uintptr(unsafe.Pointer(&g[0]))), &gdtRegister[cpu])
+	LoadGDT(&gdtRegister[cpu])
 	LoadDataSegments(kernelDataSelector)
 }
@@ -5,6 +5,8 @@ package kernel
 import (
 	"unsafe"
 
+	"github.com/dmarro89/go-dav-os/kernel/irq"
+	"github.com/dmarro89/go-dav-os/kernel/smp"
 	"github.com/dmarro89/go-dav-os/kernel/syscall"
 	"github.com/dmarro89/go-dav-os/terminal"
 )
@@ -41,14 +43,15 @@ func Int80Stub()
 func TriggerInt80()
 func GetCS() uint16
 func GetCR2() uint64
-func getIRQ0StubAddr() uint64
-func getIRQ1StubAddr() uint64
+func getIRQStubAddr(line uint8) uint64
+func getLAPICTimerStubAddr() uint64
+func getSpuriousStubAddr() uint64
 
 func GPFaultHandler(tf *syscall.TrapFrame) {
 	if tf.CS&3 == 3 {
 		terminal.Print("\n#GP in user mode\n")
 		printFaultDiagnostics("General Protection Fault", tf)
-		ReturnToKernel()
+		endProcess()
 	} else {
 		terminal.Print("\n#GP in kernel mode\n")
 		printFaultDiagnostics("General Protection Fault", tf)
@@ -65,7 +68,7 @@ func PFaultHandler(tf *syscall.TrapFrame) {
 		terminal.Print("CR2: ")
 		terminal.PrintHex(cr2)
 		terminal.Print("\n")
-		ReturnToKernel()
+		endProcess()
 	} else {
 		terminal.Print("\n#PF in kernel mode\n")
 		printFaultDiagnostics("Page Fault", tf)
@@ -120,9 +123,14 @@ func InitIDT() {
 	setIDTEntry(0x0D, getGPFaultStubAddr(), cs, intGateKernelFlags) // #GP
 	setIDTEntry(0x0E, getPFaultStubAddr(), cs, intGateKernelFlags)  // #PF
 
-	// Install IRQ handlers
-	setIDTEntry(0x20, getIRQ0StubAddr(), cs, intGateKernelFlags) // IRQ0
-	setIDTEntry(0x21, getIRQ1StubAddr(), cs, intGateKernelFlags) // IRQ1
+	// Install one stub per legacy IRQ line; irq.Dispatch finds the handlers
+	for line := uint8(0); line < irq.Lines; line++ {
+		setIDTEntry(irq.VectorBase+line, getIRQStubAddr(line), cs, intGateKernelFlags)
+	}
+
+	// Local APIC vectors used once the application processors are up
+	setIDTEntry(lapicTimerVector, getLAPICTimerStubAddr(), cs, intGateKernelFlags)
+	setIDTEntry(smp.SpuriousVector, getSpuriousStubAddr(), cs, intGateKernelFlags)
 
 	// Install 0x80 syscall handler
 	setIDTEntry(0x80, getInt80StubAddr(), cs, intGateUserFlags)
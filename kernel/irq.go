@@ -1,9 +1,13 @@
 package kernel
 
 import (
+	"github.com/dmarro89/go-dav-os/kernel/irq"
 	"github.com/dmarro89/go-dav-os/kernel/scheduler"
+	"github.com/dmarro89/go-dav-os/kernel/smp"
+	ktime "github.com/dmarro89/go-dav-os/kernel/time"
 	"github.com/dmarro89/go-dav-os/keyboard"
 	"github.com/dmarro89/go-dav-os/keyboard/layout"
+	"github.com/dmarro89/go-dav-os/serial"
 )
 
 var (
@@ -17,27 +21,28 @@ func InitKeyboard() {
 	currentLayoutName = initKeyboardLayout()
 }
 
-// SwitchLayout switches the active keyboard layout by name ("us" or "it").
+// SwitchLayout switches the active keyboard layout by name: a compiled-in
+// one or a keymap file NAME.KMP in the FAT16 root directory.
 // Interrupts are disabled during the swap to prevent IRQ1 from calling
 // translateScancode while currentLayout is half-written (type ptr != data ptr).
 // Must only be called with interrupts already enabled: it unconditionally calls
 // EnableInterrupts() after the swap and does not preserve the prior interrupt state.
-// Returns true if the layout was found and applied.
-func SwitchLayout(name string) bool {
-	switch name {
-	case "us":
-		switchKeyLayout, switchLayoutName = layout.GetUS()
-	case "it":
-		switchKeyLayout, switchLayoutName = layout.GetIT()
-	default:
-		return false
+// Returns the layout's canonical name and true if it was found and applied.
+func SwitchLayout(name string) (string, bool) {
+	var ok bool
+	switchKeyLayout, switchLayoutName, ok = layout.Find(name)
+	if !ok {
+		switchKeyLayout, switchLayoutName, ok = loadKeymap(name)
+	}
+	if !ok {
+		return "", false
 	}
 
 	DisableInterrupts()
 	keyboard.SetLayout(switchKeyLayout)
 	EnableInterrupts()
 	currentLayoutName = switchLayoutName
-	return true
+	return switchLayoutName, true
 }
 
 // GetCurrentLayoutName returns the name of the currently active layout.
@@ -45,20 +50,46 @@ func GetCurrentLayoutName() string {
 	return currentLayoutName
 }
 
+// timerIRQ is the PIT line; keyboardIRQ the PS/2 keyboard line
+const (
+	timerIRQ    = 0
+	keyboardIRQ = 1
+)
+
 var ticks uint64
 
-func IRQ0Handler() {
-	ticks++
-	PICEOI(0)
-	scheduler.Schedule()
+// initIRQs masks every PIC line, then claims the lines the kernel itself
+// services. Drivers register theirs with irq.RegisterIRQ.
+func initIRQs() {
+	irq.Init()
+	irq.RegisterIRQ(timerIRQ, timerTick)
+	// Read & buffer scancode -> rune (no terminal printing here!)
+	irq.RegisterIRQ(keyboardIRQ, keyboard.IRQHandler)
+	if serial.Present() {
+		irq.RegisterIRQ(serial.COM1IRQ, serial.IRQHandler)
+	}
 }
 
-func IRQ1Handler() {
-	// Read & buffer scancode -> rune (no terminal printing here!)
-	keyboard.IRQHandler()
+// IRQHandler is called by the stub of every legacy IRQ line. The PIC is
+// acknowledged inside irq.Dispatch; the timer then preempts the current
+// task.
+func IRQHandler(line uint8) {
+	irq.Dispatch(line)
+	if line == timerIRQ {
+		scheduler.Schedule()
+	}
+}
 
-	// Tell PIC we're done with IRQ1, otherwise it won't fire again
-	PICEOI(1)
+func timerTick() {
+	ticks++
+	ktime.Tick()
+}
+
+// LAPICTimerHandler preempts on the application processors, which never see
+// the PIT's IRQ0 because the PIC only routes it to the bootstrap CPU
+func LAPICTimerHandler() {
+	smp.EOI()
+	scheduler.Schedule()
 }
 
 func GetTicks() uint64 {
@@ -0,0 +1,132 @@
+// Package irq routes the 16 legacy PIC interrupt lines to registered
+// handlers, several of which may share a line, and keeps per-line counters.
+package irq
+
+import "github.com/dmarro89/go-dav-os/kernel/spinlock"
+
+const (
+	// Lines is the number of legacy IRQ lines behind the two 8259 PICs
+	Lines = 16
+	// MaxHandlers bounds how many handlers can share one line
+	MaxHandlers = 4
+	// VectorBase is the IDT vector of IRQ 0; IRQ n arrives on VectorBase+n
+	VectorBase = 0x20
+
+	cascadeLine = 2
+)
+
+// Handler services one interrupt on its line. Handlers run with interrupts
+// disabled and must not block; the PIC is acknowledged by Dispatch.
+type Handler func()
+
+var (
+	handlers [Lines][MaxHandlers]Handler
+	nHandler [Lines]int
+	counts   [Lines]uint64
+	spurious [Lines]uint64
+
+	// mask mirrors the PIC mask registers: bit n set means line n is masked
+	mask uint16 = 0xFFFF
+
+	lock spinlock.Lock
+)
+
+// Init remaps the PICs to VectorBase and masks every line until a handler
+// is registered for it. Previously registered handlers are forgotten.
+func Init() {
+	for i := 0; i < Lines; i++ {
+		for j := 0; j < MaxHandlers; j++ {
+			handlers[i][j] = nil
+		}
+		nHandler[i] = 0
+		counts[i] = 0
+		spurious[i] = 0
+	}
+	mask = 0xFFFF
+
+	remap(VectorBase, VectorBase+8)
+	writeMask(mask)
+}
+
+// RegisterIRQ adds h to the handlers of line and unmasks the line (and the
+// cascade, for a slave line). Handlers of a shared line run in registration
+// order. Returns false for an invalid line, a nil handler or a full line.
+func RegisterIRQ(line uint8, h Handler) bool {
+	if line >= Lines || line == cascadeLine || h == nil {
+		return false
+	}
+
+	flags := lock.AcquireIRQ()
+	if nHandler[line] >= MaxHandlers {
+		lock.ReleaseIRQ(flags)
+		return false
+	}
+	handlers[line][nHandler[line]] = h
+	nHandler[line]++
+
+	mask &^= 1 << line
+	if line >= 8 {
+		mask &^= 1 << cascadeLine
+	}
+	writeMask(mask)
+	lock.ReleaseIRQ(flags)
+	return true
+}
+
+// Dispatch is called by the interrupt stub of line. Spurious IRQ 7 and 15
+// are counted and dropped without an EOI to the PIC that raised them.
+// Otherwise the line is acknowledged first and its handlers run after, so
+// a handler that reschedules (the timer) cannot leave the PIC waiting.
+func Dispatch(line uint8) {
+	if line >= Lines {
+		return
+	}
+	if (line == 7 || line == 15) && !inService(line) {
+		spurious[line]++
+		if line == 15 {
+			// The master still saw a real interrupt on its cascade input
+			sendEOI(cascadeLine)
+		}
+		return
+	}
+
+	counts[line]++
+	sendEOI(line)
+
+	n := nHandler[line]
+	for i := 0; i < n; i++ {
+		handlers[line][i]()
+	}
+}
+
+// Count returns how many interrupts line has delivered, spurious excluded
+func Count(line uint8) uint64 {
+	if line >= Lines {
+		return 0
+	}
+	return counts[line]
+}
+
+// Spurious returns how many spurious interrupts were seen on line
+func Spurious(line uint8) uint64 {
+	if line >= Lines {
+		return 0
+	}
+	return spurious[line]
+}
+
+// HandlerCount returns the number of handlers registered on line
+func HandlerCount(line uint8) int {
+	if line >= Lines {
+		return 0
+	}
+	return nHandler[line]
+}
+
+// Masked reports whether line is currently masked at the PIC
+func Masked(line uint8) bool {
+	if line >= Lines {
+		return true
+	}
+	return mask&(1<<line) != 0
+}
@@ -0,0 +1,128 @@
+package irq
+
+import "testing"
+
+func resetFakePIC() {
+	for i := 0; i < 2; i++ {
+		fakeMask[i] = 0
+		fakeISR[i] = 0
+		fakeEOIs[i] = 0
+		fakeOCW3[i] = false
+	}
+}
+
+func TestInitMasksEveryLine(t *testing.T) {
+	resetFakePIC()
+	Init()
+
+	if fakeMask[0] != 0xFF || fakeMask[1] != 0xFF {
+		t.Fatalf("masks after Init = %#x %#x, want 0xff 0xff", fakeMask[0], fakeMask[1])
+	}
+	for line := uint8(0); line < Lines; line++ {
+		if !Masked(line) {
+			t.Fatalf("line %d unmasked after Init", line)
+		}
+	}
+}
+
+func TestRegisterIRQUnmasksLineAndCascade(t *testing.T) {
+	resetFakePIC()
+	Init()
+
+	if !RegisterIRQ(1, func() {}) {
+		t.Fatalf("RegisterIRQ(1) failed")
+	}
+	if fakeMask[0] != 0xFD || fakeMask[1] != 0xFF {
+		t.Fatalf("masks = %#x %#x, want 0xfd 0xff", fakeMask[0], fakeMask[1])
+	}
+
+	if !RegisterIRQ(14, func() {}) {
+		t.Fatalf("RegisterIRQ(14) failed")
+	}
+	// IRQ 14 sits on the slave, so the cascade (IRQ 2) must open too
+	if fakeMask[0] != 0xF9 || fakeMask[1] != 0xBF {
+		t.Fatalf("masks = %#x %#x, want 0xf9 0xbf", fakeMask[0], fakeMask[1])
+	}
+}
+
+func TestRegisterIRQRejectsInvalid(t *testing.T) {
+	resetFakePIC()
+	Init()
+
+	if RegisterIRQ(Lines, func() {}) {
+		t.Fatalf("RegisterIRQ should reject line %d", Lines)
+	}
+	if RegisterIRQ(cascadeLine, func() {}) {
+		t.Fatalf("RegisterIRQ should reject the cascade line")
+	}
+	if RegisterIRQ(3, nil) {
+		t.Fatalf("RegisterIRQ should reject a nil handler")
+	}
+	for i := 0; i < MaxHandlers; i++ {
+		if !RegisterIRQ(3, func() {}) {
+			t.Fatalf("handler %d on a shared line failed", i)
+		}
+	}
+	if RegisterIRQ(3, func() {}) {
+		t.Fatalf("RegisterIRQ should fail once the line is full")
+	}
+}
+
+func TestDispatchRunsSharedHandlersAndAcknowledges(t *testing.T) {
+	resetFakePIC()
+	Init()
+
+	var order [2]int
+	calls := 0
+	RegisterIRQ(11, func() { order[calls] = 1; calls++ })
+	RegisterIRQ(11, func() { order[calls] = 2; calls++ })
+
+	Dispatch(11)
+
+	if calls != 2 || order[0] != 1 || order[1] != 2 {
+		t.Fatalf("handlers ran %d times in order %v, want [1 2]", calls, order)
+	}
+	if Count(11) != 1 {
+		t.Fatalf("Count(11) = %d, want 1", Count(11))
+	}
+	if fakeEOIs[0] != 1 || fakeEOIs[1] != 1 {
+		t.Fatalf("EOIs = %v, want one per PIC", fakeEOIs)
+	}
+	if HandlerCount(11) != 2 {
+		t.Fatalf("HandlerCount(11) = %d, want 2", HandlerCount(11))
+	}
+}
+
+func TestDispatchDropsSpuriousIRQ7(t *testing.T) {
+	resetFakePIC()
+	Init()
+	calls := 0
+	RegisterIRQ(7, func() { calls++ })
+
+	Dispatch(7)
+	if calls != 0 || Count(7) != 0 || Spurious(7) != 1 {
+		t.Fatalf("spurious IRQ 7: calls=%d count=%d spurious=%d", calls, Count(7), Spurious(7))
+	}
+	if fakeEOIs[0] != 0 {
+		t.Fatalf("spurious IRQ 7 must not be acknowledged")
+	}
+
+	fakeISR[0] = 1 << 7
+	Dispatch(7)
+	if calls != 1 || Count(7) != 1 || fakeEOIs[0] != 1 {
+		t.Fatalf("real IRQ 7: calls=%d count=%d eois=%d", calls, Count(7), fakeEOIs[0])
+	}
+}
+
+func TestDispatchSpuriousIRQ15AcknowledgesMasterOnly(t *testing.T) {
+	resetFakePIC()
+	Init()
+
+	Dispatch(15)
+	if Spurious(15) != 1 {
+		t.Fatalf("Spurious(15) = %d, want 1", Spurious(15))
+	}
+	if fakeEOIs[0] != 1 || fakeEOIs[1] != 0 {
+		t.Fatalf("EOIs = %v, want the master only", fakeEOIs)
+	}
+}
@@ -0,0 +1,63 @@
+package irq
+
+// 8259A programmable interrupt controller pair: the master serves IRQ 0-7,
+// the slave IRQ 8-15 through the master's IRQ 2 cascade input.
+const (
+	pic1Cmd  = 0x20
+	pic1Data = 0x21
+	pic2Cmd  = 0xA0
+	pic2Data = 0xA1
+
+	icw1Init  = 0x11
+	icw4_8086 = 0x01
+
+	ocw3ReadISR = 0x0B
+	eoi         = 0x20
+)
+
+// remap moves the PIC vectors to offset1 (master) and offset2 (slave) so
+// they do not collide with CPU exceptions
+func remap(offset1, offset2 byte) {
+	// start init
+	outb(pic1Cmd, icw1Init)
+	outb(pic2Cmd, icw1Init)
+
+	// set vector offsets
+	outb(pic1Data, offset1)
+	outb(pic2Data, offset2)
+
+	// tell Master about Slave at IRQ2, tell Slave its cascade identity
+	outb(pic1Data, 1<<cascadeLine)
+	outb(pic2Data, cascadeLine)
+
+	// 8086 mode
+	outb(pic1Data, icw4_8086)
+	outb(pic2Data, icw4_8086)
+}
+
+// writeMask loads the 16-bit line mask (bit set = line masked) into both PICs
+func writeMask(m uint16) {
+	outb(pic1Data, byte(m))
+	outb(pic2Data, byte(m>>8))
+}
+
+// sendEOI acknowledges line; lines on the slave need both PICs acknowledged
+func sendEOI(line uint8) {
+	if line >= 8 {
+		outb(pic2Cmd, eoi)
+	}
+	outb(pic1Cmd, eoi)
+}
+
+// inService reports whether line is set in its PIC's in-service register.
+// A line 7 or 15 interrupt with its ISR bit clear is spurious.
+func inService(line uint8) bool {
+	cmd := uint16(pic1Cmd)
+	bit := line
+	if line >= 8 {
+		cmd = pic2Cmd
+		bit = line - 8
+	}
+	outb(cmd, ocw3ReadISR)
+	return inb(cmd)&(1<<bit) != 0
+}
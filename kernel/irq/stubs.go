@@ -0,0 +1,43 @@
+//go:build !gccgo
+
+package irq
+
+// Host builds get a fake PIC pair: fakeMask records the last mask written
+// to each data port, fakeISR is what an in-service read returns and
+// fakeEOIs counts end-of-interrupt commands per command port.
+var (
+	fakeMask [2]byte
+	fakeISR  [2]byte
+	fakeEOIs [2]int
+	fakeOCW3 [2]bool
+)
+
+func picIndex(port uint16) int {
+	if port == pic2Cmd || port == pic2Data {
+		return 1
+	}
+	return 0
+}
+
+func inb(port uint16) byte {
+	i := picIndex(port)
+	if (port == pic1Cmd || port == pic2Cmd) && fakeOCW3[i] {
+		return fakeISR[i]
+	}
+	return 0
+}
+
+func outb(port uint16, value byte) {
+	i := picIndex(port)
+	switch port {
+	case pic1Data, pic2Data:
+		fakeMask[i] = value
+	case pic1Cmd, pic2Cmd:
+		switch value {
+		case eoi:
+			fakeEOIs[i]++
+		case ocw3ReadISR:
+			fakeOCW3[i] = true
+		}
+	}
+}
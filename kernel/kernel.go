@@ -3,14 +3,45 @@
 package kernel
 
 import (
+	"github.com/dmarro89/go-dav-os/agent"
+	"github.com/dmarro89/go-dav-os/drivers/ata"
+	"github.com/dmarro89/go-dav-os/drivers/block"
+	"github.com/dmarro89/go-dav-os/drivers/mouse"
+	"github.com/dmarro89/go-dav-os/drivers/netdev"
+	"github.com/dmarro89/go-dav-os/drivers/pci"
+	"github.com/dmarro89/go-dav-os/drivers/rtc"
+	"github.com/dmarro89/go-dav-os/drivers/virtio"
 	"github.com/dmarro89/go-dav-os/fs"
+	"github.com/dmarro89/go-dav-os/fs/fat16"
+	"github.com/dmarro89/go-dav-os/kernel/acpi"
+	"github.com/dmarro89/go-dav-os/kernel/irq"
 	"github.com/dmarro89/go-dav-os/kernel/scheduler"
+	ksyscall "github.com/dmarro89/go-dav-os/kernel/syscall"
+	ktime "github.com/dmarro89/go-dav-os/kernel/time"
 	"github.com/dmarro89/go-dav-os/keyboard"
 	"github.com/dmarro89/go-dav-os/mem"
+	"github.com/dmarro89/go-dav-os/net"
+	"github.com/dmarro89/go-dav-os/serial"
 	"github.com/dmarro89/go-dav-os/shell"
 	"github.com/dmarro89/go-dav-os/terminal"
 )
 
+const (
+	// timerHz is the PIT channel 0 rate driving ticks and the timer wheel
+	timerHz = 100
+	// wheelLines is how far one notch of the mouse wheel scrolls
+	wheelLines = 3
+)
+
+var (
+	// bridgePort is COM2, reserved for the host LLM bridge
+	bridgePort  serial.Port
+	agentBridge agent.StreamBridge
+
+	// consoleFramebuffer is what initConsole hands the terminal
+	consoleFramebuffer terminal.Framebuffer
+)
+
 func DebugChar(c byte)
 func inb(port uint16) byte
 func outb(port uint16, val byte)
@@ -20,46 +51,274 @@ func Halt()
 
 func Main(multibootInfoAddr uint64) {
 	DisableInterrupts()
-	terminal.Init()
-	terminal.Clear()
+	haveMMap := mem.InitMultiboot(multibootInfoAddr)
+	initConsole()
+	initSerial()
 
 	InitGDTAndTSS()
 	InitSyscall()
 	InitIDT()
 
-	PICRemap(0x20, 0x28)
-	PICSetMask(0xFC, 0xFF)
-	PITInit(100)
+	initIRQs()
+	PITInit(timerHz)
 
 	shell.SetTickProvider(GetTicks)
 	shell.SetSyscallTickProvider(TriggerSysGetTicks)
-	shell.SetProgramRunner(RunProgram)
 
-	if mem.InitMultiboot(multibootInfoAddr) {
+	if haveMMap {
 		mem.InitPFA()
 	}
 
 	scheduler.Init()
+	initProcesses()
+	initClock()
+	initPCI()
+
+	// Learn the disk's size and LBA48 support before anything touches it,
+	// then sleep on IRQ 14 instead of spinning and use bus-master DMA when
+	// PCI found an IDE controller with a bus master. FAT16 uses the first
+	// block device registered, so a virtio disk probed by initPCI wins.
+	if ata.Identify() == ata.OK {
+		ata.RegisterBlock()
+	}
+	if ata.EnableIRQ() {
+		ata.InitDMA()
+	}
 
 	fs.Init()
+	shell.RegisterCommands()
+	fat16.RegisterCommands()
 	shell.ConfigureAgentRuntime()
+	initAgentBridge()
 
 	InitKeyboard()
+	initMouse()
 
 	shell.SetLayoutSwitcher(SwitchLayout)
 	shell.SetInitialLayout(GetCurrentLayoutName())
 
 	EnableInterrupts()
+	startAPs()
+	initNetwork()
+
+	// A formatted disk is mounted at boot so its AUTOEXEC.SH can run
+	// before the first prompt
+	if block.Default() != nil && fat16.Mount() {
+		shell.RunStartupScript()
+	}
 	shell.Init()
 
+	// A NIC, mouse or timer interrupt ends the Halt
 	for {
-		DisableInterrupts()
-		r, ok := keyboard.TryRead()
-		EnableInterrupts()
+		ev, ok := nextInput()
 		if !ok {
 			Halt()
 			continue
 		}
-		shell.FeedRune(r)
+		shell.FeedKey(ev)
+	}
+}
+
+// nextInput reaps received frames, runs TCP timers and moves the pointer,
+// then returns the next key event. The shell reads its keys this way, and
+// so does the wait for a program in the foreground.
+func nextInput() (keyboard.Event, bool) {
+	net.Poll()
+	pollMouse()
+	DisableInterrupts()
+	ev, ok := readInput()
+	EnableInterrupts()
+	return ev, ok
+}
+
+// initConsole draws on the framebuffer GRUB set up when it is direct colour
+// and falls back to VGA text mode otherwise. boot.s identity-maps only the
+// first 4 GiB, so a framebuffer above that stays unused.
+func initConsole() {
+	terminal.Init()
+	info, ok := mem.Framebuffer()
+	if !ok || info.Type != mem.FramebufferRGB {
+		return
+	}
+	if info.Addr+uint64(info.Pitch)*uint64(info.Height) > 1<<32 {
+		return
+	}
+	// Field by field, as in mem.parseFramebuffer
+	fb := &consoleFramebuffer
+	fb.Addr = uintptr(info.Addr)
+	fb.Pitch = info.Pitch
+	fb.Width = info.Width
+	fb.Height = info.Height
+	fb.BPP = info.BPP
+	fb.RedPos, fb.RedSize = info.RedPos, info.RedSize
+	fb.GreenPos, fb.GreenSize = info.GreenPos, info.GreenSize
+	fb.BluePos, fb.BlueSize = info.BluePos, info.BlueSize
+	terminal.InitFramebuffer(fb)
+}
+
+// initSerial mirrors the console to COM1 when a UART answers, so the OS
+// can be driven headlessly (QEMU -serial stdio). Its receive IRQ is
+// claimed in initIRQs.
+func initSerial() {
+	if serial.Init(serial.COM1) {
+		terminal.SetMirror(serial.PutByte)
+	}
+}
+
+// initAgentBridge offers "agent mode llm" when COM2 has a UART, which the
+// host bridge (scripts/fake_llm_bridge.py --listen) sits on the far end of.
+// Requests time out if nobody answers.
+func initAgentBridge() {
+	if !bridgePort.Init(serial.COM2) {
+		return
+	}
+	irq.RegisterIRQ(serial.COM2IRQ, bridgeIRQ)
+	agentBridge.Send = bridgeSend
+	agentBridge.Receive = bridgeReceive
+	agentBridge.Now = ktime.Nanotime
+	agentBridge.Idle = ktime.Idle
+	shell.SetAgentBridge(&agentBridge)
+}
+
+func bridgeIRQ()        { bridgePort.IRQHandler() }
+func bridgeSend(c byte) { bridgePort.Transmit(c) }
+func bridgeReceive() (byte, bool) {
+	DisableInterrupts()
+	c, ok := bridgePort.TryRead()
+	EnableInterrupts()
+	return c, ok
+}
+
+// initMouse shows the pointer when a PS/2 mouse answers. Its replies are
+// polled, so this runs before interrupts are enabled.
+func initMouse() {
+	if !mouse.Init() {
+		return
+	}
+	irq.RegisterIRQ(mouse.IRQ, mouse.IRQHandler)
+	terminal.ShowPointer()
+}
+
+// pollMouse hands queued mouse events to the console; a right or middle
+// click pastes the selected text into the shell line
+func pollMouse() {
+	for {
+		DisableInterrupts()
+		ev, ok := mouse.TryRead()
+		EnableInterrupts()
+		if !ok {
+			return
+		}
+		if terminal.PointerEvent(ev.DX, ev.DY, ev.Buttons) {
+			shell.Paste(terminal.Clipboard())
+		}
+		// Rolling the wheel away from the user looks back into the
+		// scrollback
+		if ev.DZ != 0 {
+			terminal.ScrollView(-ev.DZ * wheelLines)
+		}
+	}
+}
+
+// readInput returns the next pending key event, from the keyboard first
+// and then from the serial line, whose bytes become key presses. Call it
+// with interrupts disabled.
+func readInput() (keyboard.Event, bool) {
+	if ev, ok := keyboard.TryReadEvent(); ok {
+		return ev, true
+	}
+	c, ok := serial.TryRead()
+	if !ok {
+		return keyboard.Event{}, false
+	}
+	// Most terminals send DEL for the Backspace key
+	if c == 0x7F {
+		c = '\b'
+	}
+	r, done := serialRune(c)
+	if !done {
+		return keyboard.Event{}, false
+	}
+	return keyboard.Event{Rune: r, Pressed: true}, true
+}
+
+// A UTF-8 sequence from the serial line is gathered a byte at a time:
+// serialRuneBits holds the bits so far and serialRuneLeft the bytes to come
+var (
+	serialRuneBits rune
+	serialRuneLeft int
+)
+
+// serialRune adds a byte from the serial line to the rune being read,
+// reporting the rune once its sequence is complete
+func serialRune(c byte) (rune, bool) {
+	if serialRuneLeft > 0 {
+		if c&0xC0 == 0x80 {
+			serialRuneBits = serialRuneBits<<6 | rune(c&0x3F)
+			serialRuneLeft--
+			return serialRuneBits, serialRuneLeft == 0
+		}
+		// A broken sequence is dropped and c starts afresh
+		serialRuneLeft = 0
+	}
+	switch {
+	case c < 0x80:
+		return rune(c), true
+	case c&0xE0 == 0xC0:
+		serialRuneBits, serialRuneLeft = rune(c&0x1F), 1
+	case c&0xF0 == 0xE0:
+		serialRuneBits, serialRuneLeft = rune(c&0x0F), 2
+	case c&0xF8 == 0xF0:
+		serialRuneBits, serialRuneLeft = rune(c&0x07), 3
 	}
+	return 0, false
+}
+
+// initPCI enumerates the PCI bus, through ECAM when ACPI describes an MCFG
+// window, and hands each function to the first registered driver that
+// claims it
+func initPCI() {
+	base, startBus, endBus, _ := acpi.MCFG()
+	pci.Init(base, startBus, endBus)
+	pci.Register(&ata.PCIDriver)
+	pci.Register(&virtio.BlkDriver)
+	pci.Register(&virtio.NetDriver)
+	pci.Probe()
+}
+
+// initNetwork runs the IP stack on the first network interface and asks
+// DHCP for an address. Waiting for replies halts until the NIC or the
+// timer wakes the CPU.
+func initNetwork() {
+	d := netdev.At(0)
+	if d == nil {
+		return
+	}
+	net.Init(d)
+	terminal.Print("net: ")
+	terminal.Print(d.Name)
+	if err := net.DHCP(); err != net.OK {
+		terminal.Print(" DHCP failed: ")
+		terminal.Print(err.String())
+		terminal.Print("\n")
+		return
+	}
+	terminal.Print(" configured by DHCP\n")
+}
+
+// initClock selects the monotonic clock (HPET from ACPI, else the TSC),
+// anchors wall-clock time to the CMOS RTC and exposes sleeping to the shell
+// and to user programs
+func initClock() {
+	var hpetBase uint64
+	if acpi.Init(mem.ACPIRSDP()) {
+		hpetBase, _ = acpi.HPETBase()
+	}
+	ktime.Init(hpetBase, timerHz)
+	rtc.Init(acpi.CenturyRegister())
+	shell.SetClock(rtc.Now)
+	shell.SetNanotime(ktime.Nanotime)
+
+	ksyscall.SetSleepHandler(ktime.SleepNanos)
+	shell.SetSleeper(ktime.Sleep)
 }
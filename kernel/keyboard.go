@@ -1,12 +1,68 @@
 package kernel
 
 import (
+	"github.com/dmarro89/go-dav-os/fs/fat16"
 	"github.com/dmarro89/go-dav-os/keyboard"
 	"github.com/dmarro89/go-dav-os/keyboard/layout"
+	"github.com/dmarro89/go-dav-os/terminal"
 )
 
+// keymapBuf holds a keymap file while it is parsed; fat16.ReadFile returns
+// the first sector of a file, so keymaps on disk must fit in 512 bytes
+var keymapBuf [512]byte
+
 func initKeyboardLayout() string {
 	keyLayout, layoutName := layout.GetIT()
 	keyboard.SetLayout(keyLayout)
 	return layoutName
 }
+
+// loadKeymap reads the keymap file NAME.KMP from the FAT16 root directory
+func loadKeymap(name string) (keyboard.Layout, string, bool) {
+	if len(name) == 0 || len(name) > 8 {
+		return nil, "", false
+	}
+	var fname [8]byte
+	fext := [3]byte{'K', 'M', 'P'}
+	for i := 0; i < 8; i++ {
+		fname[i] = ' '
+		if i < len(name) {
+			c := name[i]
+			if c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			fname[i] = c
+		}
+	}
+
+	n, ok := fat16.ReadFile(&fname, &fext, &keymapBuf)
+	if !ok {
+		return nil, "", false
+	}
+	if n > uint32(len(keymapBuf)) {
+		// Only the first sector was read; a keymap cut short must not
+		// load as if it were whole
+		terminal.Print("layout: keymap too large, at most 512 bytes\n")
+		return nil, "", false
+	}
+	l, canonical, badLine, ok := layout.Load(name, keymapBuf[:n])
+	if !ok {
+		var digits [10]byte
+		i := len(digits)
+		for {
+			i--
+			digits[i] = byte('0' + badLine%10)
+			badLine /= 10
+			if badLine == 0 {
+				break
+			}
+		}
+		terminal.Print("layout: bad keymap line ")
+		for ; i < len(digits); i++ {
+			terminal.PutRune(rune(digits[i]))
+		}
+		terminal.PutRune('\n')
+		return nil, "", false
+	}
+	return l, canonical, true
+}
@@ -0,0 +1,25 @@
+package kernel
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dmarro89/go-dav-os/fs/fat16"
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+func TestLoadKeymapRejectsFilesPastASector(t *testing.T) {
+	terminal.Init()
+	fat16.FilesForTesting = map[string]string{
+		"BIG.KMP": "# " + strings.Repeat("x", 600) + "\n",
+	}
+	t.Cleanup(func() { fat16.FilesForTesting = nil })
+
+	terminal.ResetOutputForTesting()
+	if _, _, ok := loadKeymap("big"); ok {
+		t.Fatalf("loaded a keymap larger than a sector")
+	}
+	if got := terminal.OutputForTesting(); got != "layout: keymap too large, at most 512 bytes\n" {
+		t.Fatalf("output = %q", got)
+	}
+}
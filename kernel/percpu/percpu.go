@@ -0,0 +1,107 @@
+package percpu
+
+import "unsafe"
+
+// MaxCPUs bounds the number of processors the kernel will bring online
+const MaxCPUs = 16
+
+// CPU is the per-processor block. Each CPU's GS base points at its own
+// entry, so Current is a single GS-relative load.
+type CPU struct {
+	// self must stay the first field: Current reads it through %gs:0
+	self   uintptr
+	Index  int
+	APICID uint8
+	BSP    bool
+	Online bool
+}
+
+var (
+	cpus  [MaxCPUs]CPU
+	count int
+
+	// installed is set once the BSP has loaded its GS base; before that
+	// everything runs on the BSP and Current returns CPU 0 directly
+	installed bool
+)
+
+// Reset forgets every registered CPU. Used at boot before discovery.
+func Reset() {
+	for i := 0; i < MaxCPUs; i++ {
+		c := &cpus[i]
+		c.self = 0
+		c.Index = i
+		c.APICID = 0
+		c.BSP = false
+		c.Online = false
+	}
+	count = 0
+	installed = false
+}
+
+// Register claims the next slot for the processor with the given local
+// APIC ID. Returns nil when MaxCPUs processors are already registered.
+func Register(apicID uint8, bsp bool) *CPU {
+	if count >= MaxCPUs {
+		return nil
+	}
+	c := &cpus[count]
+	c.self = uintptr(unsafe.Pointer(c))
+	c.Index = count
+	c.APICID = apicID
+	c.BSP = bsp
+	c.Online = false
+	count++
+	return c
+}
+
+// Count returns the number of registered CPUs, online or not
+func Count() int { return count }
+
+// OnlineCount returns the number of CPUs that finished bring-up
+func OnlineCount() int {
+	n := 0
+	for i := 0; i < count; i++ {
+		if cpus[i].Online {
+			n++
+		}
+	}
+	return n
+}
+
+// Get returns the CPU in slot i, or nil if no such CPU was registered
+func Get(i int) *CPU {
+	if i < 0 || i >= count {
+		return nil
+	}
+	return &cpus[i]
+}
+
+// Install points this processor's GS base at c. Every CPU calls it once
+// during bring-up, after its GDT and segment registers are loaded
+// (reloading %gs clears the base).
+func Install(c *CPU) {
+	writeGSBase(uint64(uintptr(unsafe.Pointer(c))))
+	if c.BSP {
+		installed = true
+	}
+}
+
+// Current returns the CPU executing the caller
+func Current() *CPU {
+	if !installed {
+		return &cpus[0]
+	}
+	p := readGS0()
+	base := uintptr(unsafe.Pointer(&cpus[0]))
+	end := base + uintptr(MaxCPUs)*unsafe.Sizeof(cpus[0])
+	// A GS base clobbered by user code must not send the kernel into
+	// arbitrary memory; fall back to the BSP
+	if p < base || p >= end {
+		return &cpus[0]
+	}
+	return (*CPU)(unsafe.Pointer(p))
+}
+
+// Index returns the slot of the CPU executing the caller
+func Index() int { return Current().Index }
@@ -0,0 +1,7 @@
+//go:build gccgo
+
+package percpu
+
+// Implemented in boot/stubs_amd64.s
+func readGS0() uintptr
+func writeGSBase(base uint64)
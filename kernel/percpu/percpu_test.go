@@ -0,0 +1,58 @@
+package percpu
+
+import "testing"
+
+func TestRegisterAssignsSlots(t *testing.T) {
+	Reset()
+
+	bsp := Register(0, true)
+	ap := Register(3, false)
+	if bsp == nil || ap == nil {
+		t.Fatalf("Register failed")
+	}
+	if bsp.Index != 0 || ap.Index != 1 || ap.APICID != 3 {
+		t.Fatalf("unexpected slots: bsp=%+v ap=%+v", *bsp, *ap)
+	}
+	if Count() != 2 || Get(1) != ap || Get(2) != nil {
+		t.Fatalf("Count/Get disagree with Register")
+	}
+
+	for i := 2; i < MaxCPUs; i++ {
+		Register(uint8(i+1), false)
+	}
+	if Register(99, false) != nil {
+		t.Fatalf("Register should fail past MaxCPUs")
+	}
+}
+
+func TestCurrentFollowsGSBase(t *testing.T) {
+	Reset()
+	bsp := Register(0, true)
+	ap := Register(1, false)
+
+	if Current() != bsp {
+		t.Fatalf("before Install, Current should be the BSP")
+	}
+
+	Install(bsp)
+	Install(ap) // the host has a single GS base, so the AP takes it over
+	if Current() != ap || Index() != 1 {
+		t.Fatalf("Current should follow the GS base")
+	}
+
+	gsBase = 0
+	if Current() != bsp {
+		t.Fatalf("a bogus GS base should fall back to the BSP")
+	}
+}
+
+func TestOnlineCount(t *testing.T) {
+	Reset()
+	Register(0, true).Online = true
+	Register(1, false)
+	Register(2, false).Online = true
+
+	if got := OnlineCount(); got != 2 {
+		t.Fatalf("OnlineCount() = %d, want 2", got)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !gccgo
+
+package percpu
+
+import "unsafe"
+
+// gsBase emulates the IA32_GS_BASE MSR of the single host "CPU"
+var gsBase uintptr
+
+func readGS0() uintptr {
+	if gsBase == 0 {
+		return 0
+	}
+	return *(*uintptr)(unsafe.Pointer(gsBase))
+}
+
+func writeGSBase(base uint64) {
+	gsBase = uintptr(base)
+}
@@ -0,0 +1,186 @@
+//go:build !testing
+
+package kernel
+
+import (
+	"unsafe"
+
+	"github.com/dmarro89/go-dav-os/kernel/scheduler"
+	ksyscall "github.com/dmarro89/go-dav-os/kernel/syscall"
+	"github.com/dmarro89/go-dav-os/shell"
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+// A user program runs as a scheduler task of its own, so the timer
+// preempts it like any other and the shell keeps running. Each one has a
+// user stack page, a ring 0 stack for its traps and syscalls, a buffer
+// its syscalls copy through and an output on the console it was started
+// from, which the scheduler hook switches to along with the task. The
+// program page is shared: every program is linked into it.
+
+const (
+	// maxProcesses is USER_STACK_PAGES in boot/boot.s
+	maxProcesses  = 4
+	userStackSize = 4096
+
+	ctrlC = 0x03
+	ctrlZ = 0x1A
+)
+
+type process struct {
+	// pid is the task ID, 0 for a free slot
+	pid       int
+	rip       uint64
+	stackTop  uint64
+	output    terminal.Output
+	trapStack [kernelTrapStackSize]byte
+	// syscallBuf is what its syscalls copy user memory through
+	syscallBuf ksyscall.Buffer
+}
+
+var (
+	processes      [maxProcesses]process
+	shellProcesses shell.Processes
+)
+
+func setSyscallStack(top uint64)
+
+// initProcesses hands the shell its way to run programs
+func initProcesses() {
+	scheduler.SetSwitchHook(switchTask)
+	shellProcesses.Start = startProcess
+	shellProcesses.Wait = waitProcess
+	shellProcesses.Stop = stopProcess
+	shellProcesses.Continue = continueProcess
+	shellProcesses.Kill = killProcess
+	shellProcesses.Alive = processAlive
+	shell.SetProcesses(&shellProcesses)
+}
+
+// switchTask readies the CPU for the task the scheduler switches to:
+// traps and syscalls from user mode go to its stack, and output to its
+// console, and its syscalls copy through its own buffer. Tasks other than
+// programs share the kernel's output and buffer.
+func switchTask(t *scheduler.Task) {
+	if t.TrapStack != 0 {
+		SetKernelRSP0(t.TrapStack)
+		setSyscallStack(t.TrapStack)
+	}
+	if p := processOf(t.ID); p != nil {
+		terminal.SetOutput(&p.output)
+		ksyscall.SetBuffer(&p.syscallBuf)
+	} else {
+		terminal.SetOutput(terminal.MainOutput())
+		ksyscall.SetBuffer(nil)
+	}
+}
+
+// startProcess starts a program in the background and returns its pid
+func startProcess(name *[16]byte, nameLen int) (int, bool) {
+	rip, ok := programEntry(name, nameLen)
+	if !ok {
+		return -1, false
+	}
+	slot := -1
+	for i := range processes {
+		if processes[i].pid == 0 || !scheduler.Alive(processes[i].pid) {
+			slot = i
+			break
+		}
+	}
+	if slot < 0 {
+		return -1, false
+	}
+	p := &processes[slot]
+	p.rip = rip
+	p.stackTop = GetUserStackTopAddr() + uint64(slot)*userStackSize
+	p.output.Reset(terminal.OutputConsole())
+
+	// The task must not run before its process knows its pid
+	DisableInterrupts()
+	t := scheduler.NewTask(processEntry)
+	if t == nil {
+		EnableInterrupts()
+		return -1, false
+	}
+	top := uintptr(unsafe.Pointer(&p.trapStack[0])) + uintptr(len(p.trapStack))
+	t.TrapStack = uint64(top &^ uintptr(0xF))
+	p.pid = t.ID
+	EnableInterrupts()
+	return t.ID, true
+}
+
+// processEntry is where the task of a program starts; it leaves the
+// kernel for good
+func processEntry() {
+	p := processOf(scheduler.CurrentTaskID())
+	if p == nil {
+		return
+	}
+	ExecuteUserTask(p.rip, p.stackTop)
+}
+
+// endProcess ends the program on this CPU after SYS_EXIT or a fault in
+// user mode. Its task never runs again.
+func endProcess() {
+	pid := scheduler.CurrentTaskID()
+	if processOf(pid) == nil {
+		ReturnToKernel()
+		return
+	}
+	ksyscall.CloseSockets(pid)
+	scheduler.Exit()
+}
+
+// waitProcess runs the shell's input loop until the program ends,
+// returning 0, or Ctrl-C or Ctrl-Z is typed, returning the key. The keys
+// that switch and scroll consoles still work; others are dropped.
+func waitProcess(pid int) rune {
+	for processAlive(pid) {
+		ev, ok := nextInput()
+		if !ok {
+			Halt()
+			continue
+		}
+		if !ev.Pressed {
+			continue
+		}
+		if ev.Rune == ctrlC || ev.Rune == ctrlZ {
+			return ev.Rune
+		}
+		shell.ConsoleKey(ev)
+	}
+	return 0
+}
+
+func stopProcess(pid int) bool {
+	return processOf(pid) != nil && scheduler.Stop(pid)
+}
+
+func continueProcess(pid int) bool {
+	return processOf(pid) != nil && scheduler.Continue(pid)
+}
+
+func killProcess(pid int) bool {
+	if processOf(pid) == nil || !scheduler.Kill(pid) {
+		return false
+	}
+	ksyscall.CloseSockets(pid)
+	return true
+}
+
+func processAlive(pid int) bool {
+	return processOf(pid) != nil && scheduler.Alive(pid)
+}
+
+func processOf(pid int) *process {
+	if pid <= 0 {
+		return nil
+	}
+	for i := range processes {
+		if processes[i].pid == pid {
+			return &processes[i]
+		}
+	}
+	return nil
+}
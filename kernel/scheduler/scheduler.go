@@ -1,6 +1,11 @@
 package scheduler
 
-import "unsafe"
+import (
+	"unsafe"
+
+	"github.com/dmarro89/go-dav-os/kernel/percpu"
+	"github.com/dmarro89/go-dav-os/kernel/spinlock"
+)
 
 const StackSize = 4096
 const MaxTasks = 16
@@ -18,49 +23,122 @@ type Task struct {
 	ID    int
 	ESP   uint64
 	State TaskState
-	Stack [StackSize]byte
+	// Stopped keeps the task off the CPU, whatever its state, until
+	// Continue
+	Stopped bool
+	// TrapStack is the top of the ring 0 stack the task traps onto from
+	// user mode, 0 for a task that never leaves the kernel
+	TrapStack uint64
+	Stack     [StackSize]byte
+}
+
+// runQueue holds the tasks owned by one CPU. Slot 0 is the context the CPU
+// booted on; it doubles as the idle task and never exits.
+type runQueue struct {
+	tasks   [MaxTasks]*Task
+	count   int
+	current *Task
 }
 
 var (
-	tasks       [MaxTasks]*Task
-	taskCount   int
-	currentTask *Task
-	nextID      = 1
+	queues [percpu.MaxCPUs]runQueue
+	// lock guards the task pool and every run queue. Schedule runs from the
+	// timer interrupt, so it is always taken with interrupts disabled.
+	lock   spinlock.Lock
+	nextID = 1
 
 	// Static allocation for tasks to avoid 'newobject' heap allocation
 	taskPool [MaxTasks]Task
+	poolUsed int
+
+	// switchHook readies the CPU for a task about to run
+	switchHook func(t *Task)
 )
 
+// SetSwitchHook sets what Schedule calls with every task it switches to,
+// for the kernel to load its TrapStack into the TSS and point the console
+// at its output
+func SetSwitchHook(fn func(t *Task)) { switchHook = fn }
+
+// localQueue returns the run queue of the CPU executing the caller
+func localQueue() *runQueue {
+	return &queues[percpu.Index()]
+}
+
+// Init resets the scheduler and adopts the boot context as task 0 on the
+// bootstrap CPU
 func Init() {
-	taskCount = 0
+	lock.Release()
+	for i := 0; i < percpu.MaxCPUs; i++ {
+		q := &queues[i]
+		for j := 0; j < MaxTasks; j++ {
+			q.tasks[j] = nil
+		}
+		q.count = 0
+		q.current = nil
+	}
 	nextID = 1
-	// Init initial task (0)
+
 	t := &taskPool[0]
 	t.ID = 0
 	t.State = TaskRunning
+	poolUsed = 1
 
-	tasks[0] = t
-	taskCount = 1
-	currentTask = t
+	q := &queues[0]
+	q.tasks[0] = t
+	q.count = 1
+	q.current = t
+}
+
+// InitCPU adopts the calling context as the idle task of the current CPU.
+// Application processors call it once during bring-up.
+func InitCPU() bool {
+	flags := lock.AcquireIRQ()
+	q := localQueue()
+	if q.count != 0 || poolUsed >= MaxTasks {
+		lock.ReleaseIRQ(flags)
+		return false
+	}
+	t := &taskPool[poolUsed]
+	poolUsed++
+	t.ID = nextID
+	nextID++
+	t.State = TaskRunning
+
+	q.tasks[0] = t
+	q.count = 1
+	q.current = t
+	lock.ReleaseIRQ(flags)
+	return true
 }
 
 func NewTask(entry func()) *Task {
 	return NewTaskEntry(funcPC(entry))
 }
 
+// NewTaskEntry creates a task that starts at entry and queues it on the
+// current CPU
 func NewTaskEntry(entry uintptr) *Task {
-	if taskCount >= MaxTasks {
-		return nil
-	}
 	if entry == 0 {
 		return nil
 	}
 
-	idx := taskCount
-	t := &taskPool[idx]
+	flags := lock.AcquireIRQ()
+	q := localQueue()
+	t := reuseDeadTask(q)
+	if t == nil {
+		if poolUsed >= MaxTasks || q.count >= MaxTasks {
+			lock.ReleaseIRQ(flags)
+			return nil
+		}
+		t = &taskPool[poolUsed]
+		poolUsed++
+	}
 	t.ID = nextID
 	nextID++
 	t.State = TaskRunnable
+	t.Stopped = false
+	t.TrapStack = 0
 
 	// Stack grows down and must match CpuSwitch pop order.
 	sp := uintptr(unsafe.Pointer(&t.Stack[0])) + StackSize
@@ -74,20 +152,43 @@ func NewTaskEntry(entry uintptr) *Task {
 	sp -= 8
 	*(*uintptr)(unsafe.Pointer(sp)) = entry
 
-	// CpuSwitch restores RDI, RSI, RBX, RBP from these slots.
-	sp -= 32
-	*(*uint64)(unsafe.Pointer(sp + 0)) = 0
-	*(*uint64)(unsafe.Pointer(sp + 8)) = 0
-	*(*uint64)(unsafe.Pointer(sp + 16)) = 0
-	*(*uint64)(unsafe.Pointer(sp + 24)) = 0
+	// CpuSwitch restores R15, R14, R13, R12, RDI, RSI, RBX, RBP from these
+	// slots.
+	sp -= switchFrameSize
+	for off := uintptr(0); off < switchFrameSize; off += 8 {
+		*(*uint64)(unsafe.Pointer(sp + off)) = 0
+	}
 
 	t.ESP = uint64(sp)
 
-	tasks[idx] = t
-	taskCount++
+	q.tasks[q.count] = t
+	q.count++
+	lock.ReleaseIRQ(flags)
 	return t
 }
 
+// switchFrameSize is what CpuSwitch pushes below the return address
+const switchFrameSize = 64
+
+// reuseDeadTask takes a task that died on q out of the queue for reuse.
+// A dead task of this CPU has switched away for good, so its stack is
+// free. Call it with lock held.
+func reuseDeadTask(q *runQueue) *Task {
+	for i := 1; i < q.count; i++ {
+		t := q.tasks[i]
+		if t.State != TaskDead || t == q.current {
+			continue
+		}
+		for j := i; j < q.count-1; j++ {
+			q.tasks[j] = q.tasks[j+1]
+		}
+		q.count--
+		q.tasks[q.count] = nil
+		return t
+	}
+	return nil
+}
+
 func taskAutoExit() {
 	Exit()
 	for {
@@ -106,37 +207,46 @@ func funcPC(fn func()) uintptr {
 }
 
 func Exit() {
-	if currentTask == nil {
+	flags := lock.AcquireIRQ()
+	q := localQueue()
+	if q.current == nil {
+		lock.ReleaseIRQ(flags)
 		return
 	}
-	currentTask.State = TaskDead
+	q.current.State = TaskDead
+	lock.ReleaseIRQ(flags)
+
 	Schedule()
 	// Should not return if Schedule switched
 	for {
 	}
 }
 
+// Schedule switches the current CPU to its next runnable task, round robin
 func Schedule() {
-	if taskCount <= 1 {
+	flags := lock.AcquireIRQ()
+	q := localQueue()
+	if q.count <= 1 {
+		lock.ReleaseIRQ(flags)
 		return
 	}
 
-	oldTask := currentTask
+	oldTask := q.current
 
 	nextIndex := -1
 	currentIndex := -1
 
-	for i := 0; i < taskCount; i++ {
-		if tasks[i] == currentTask {
+	for i := 0; i < q.count; i++ {
+		if q.tasks[i] == oldTask {
 			currentIndex = i
 			break
 		}
 	}
 
 	// Round-robin
-	for i := 1; i < taskCount; i++ {
-		idx := (currentIndex + i) % taskCount
-		if tasks[idx].State == TaskRunnable {
+	for i := 1; i < q.count; i++ {
+		idx := (currentIndex + i) % q.count
+		if q.tasks[idx].State == TaskRunnable && !q.tasks[idx].Stopped {
 			nextIndex = idx
 			break
 		}
@@ -144,30 +254,170 @@ func Schedule() {
 
 	if nextIndex == -1 {
 		// No runnable task found.
-		// If current task is dead, we must find SOMETHING (maybe idle task 0?)
+		// If current task is dead, fall back to the CPU's permanent task 0
 		if oldTask.State == TaskDead {
-			// Fallback to task 0 usually, assuming it's permanent
 			nextIndex = 0
 		} else {
 			// Current task is still runnable, just return without switching
+			lock.ReleaseIRQ(flags)
 			return
 		}
 	}
 
-	newTask := tasks[nextIndex]
+	newTask := q.tasks[nextIndex]
 
-	if oldTask.State != TaskDead {
+	// A task that blocked itself stays TaskWaiting until Wake is called
+	if oldTask.State == TaskRunning {
 		oldTask.State = TaskRunnable
 	}
 	newTask.State = TaskRunning
-	currentTask = newTask
+	q.current = newTask
+	if switchHook != nil {
+		switchHook(newTask)
+	}
 
+	// Only this CPU switches tasks of its own queue, so the lock can go
+	// before the switch; interrupts stay off until this task resumes
+	lock.Release()
 	cpuSwitch(&oldTask.ESP, newTask.ESP)
+	spinlock.RestoreInterrupts(flags)
+}
+
+// Block marks the current task as waiting and switches to another runnable
+// task. If nothing else can run, Block returns with the task still waiting;
+// callers loop until their wake-up condition holds.
+func Block() {
+	flags := lock.AcquireIRQ()
+	q := localQueue()
+	if q.current == nil {
+		lock.ReleaseIRQ(flags)
+		return
+	}
+	q.current.State = TaskWaiting
+	lock.ReleaseIRQ(flags)
+
+	Schedule()
+}
+
+// Wake makes the waiting task with the given ID runnable again, whichever
+// CPU owns it. Safe to call from interrupt context.
+func Wake(id int) bool {
+	flags := lock.AcquireIRQ()
+	for c := 0; c < percpu.MaxCPUs; c++ {
+		q := &queues[c]
+		for i := 0; i < q.count; i++ {
+			t := q.tasks[i]
+			if t == nil || t.ID != id {
+				continue
+			}
+			if t.State != TaskWaiting {
+				lock.ReleaseIRQ(flags)
+				return false
+			}
+			// Block may have returned without switching away, so the waiting
+			// task can still be the one on the CPU
+			if t == q.current {
+				t.State = TaskRunning
+			} else {
+				t.State = TaskRunnable
+			}
+			lock.ReleaseIRQ(flags)
+			return true
+		}
+	}
+	lock.ReleaseIRQ(flags)
+	return false
+}
+
+// findTask returns the task with the given ID on any CPU, or nil. Call it
+// with lock held.
+func findTask(id int) *Task {
+	for c := 0; c < percpu.MaxCPUs; c++ {
+		q := &queues[c]
+		for i := 0; i < q.count; i++ {
+			if t := q.tasks[i]; t != nil && t.ID == id {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// Stop keeps a task off the CPU until Continue. A task that is waiting
+// still wakes up, but does not run.
+func Stop(id int) bool {
+	flags := lock.AcquireIRQ()
+	t := findTask(id)
+	ok := t != nil && t.State != TaskDead
+	if ok {
+		t.Stopped = true
+	}
+	lock.ReleaseIRQ(flags)
+	return ok
+}
+
+// Continue lets a stopped task run again
+func Continue(id int) bool {
+	flags := lock.AcquireIRQ()
+	t := findTask(id)
+	ok := t != nil && t.State != TaskDead
+	if ok {
+		t.Stopped = false
+	}
+	lock.ReleaseIRQ(flags)
+	return ok
+}
+
+// Kill ends another task; it never runs again and its slot is reused. A
+// task ends itself with Exit instead.
+func Kill(id int) bool {
+	flags := lock.AcquireIRQ()
+	t := findTask(id)
+	ok := t != nil && t.State != TaskDead && t != localQueue().current
+	if ok {
+		t.State = TaskDead
+	}
+	lock.ReleaseIRQ(flags)
+	return ok
+}
+
+// Alive reports whether the task with the given ID exists and has not
+// ended
+func Alive(id int) bool {
+	flags := lock.AcquireIRQ()
+	t := findTask(id)
+	alive := t != nil && t.State != TaskDead
+	lock.ReleaseIRQ(flags)
+	return alive
+}
+
+// Stopped reports whether the task with the given ID is stopped
+func Stopped(id int) bool {
+	flags := lock.AcquireIRQ()
+	t := findTask(id)
+	stopped := t != nil && t.Stopped
+	lock.ReleaseIRQ(flags)
+	return stopped
+}
+
+// CurrentWaiting reports whether the running task is still blocked
+func CurrentWaiting() bool {
+	q := localQueue()
+	return q.current != nil && q.current.State == TaskWaiting
 }
 
 func CurrentTaskID() int {
-	if currentTask == nil {
+	q := localQueue()
+	if q.current == nil {
 		return -1
 	}
-	return currentTask.ID
+	return q.current.ID
+}
+
+// TaskCount returns the number of tasks queued on CPU cpu, idle task included
+func TaskCount(cpu int) int {
+	if cpu < 0 || cpu >= percpu.MaxCPUs {
+		return 0
+	}
+	return queues[cpu].count
 }
@@ -4,17 +4,21 @@ import (
 	"reflect"
 	"testing"
 	"unsafe"
+
+	"github.com/dmarro89/go-dav-os/kernel/percpu"
 )
 
 func testTaskEntry() {}
 
 func MockInit() {
-	taskCount = 0
-	currentTask = nil
+	q := &queues[0]
+	q.count = 0
+	q.current = nil
+	poolUsed = 0
 	nextID = 1
-	// Reset tasks array if needed, though taskCount handles the logical reset
+	// Reset tasks array if needed, though count handles the logical reset
 	for i := 0; i < MaxTasks; i++ {
-		tasks[i] = nil
+		q.tasks[i] = nil
 	}
 }
 
@@ -23,8 +27,9 @@ func TestInit(t *testing.T) {
 
 	Init()
 
-	if taskCount != 1 {
-		t.Errorf("Expected taskCount to be 1, got %d", taskCount)
+	tasks := &queues[0].tasks
+	if queues[0].count != 1 {
+		t.Errorf("Expected taskCount to be 1, got %d", queues[0].count)
 	}
 
 	if tasks[0] == nil {
@@ -39,7 +44,7 @@ func TestInit(t *testing.T) {
 		t.Errorf("Expected tasks[0].State to be TaskRunning, got %v", tasks[0].State)
 	}
 
-	if currentTask != tasks[0] {
+	if queues[0].current != tasks[0] {
 		t.Errorf("Expected currentTask to be tasks[0]")
 	}
 }
@@ -59,12 +64,12 @@ func TestNewTaskEntryBuildsExpectedStack(t *testing.T) {
 		t.Fatalf("Expected initial RSP to be 16-byte aligned, got 0x%x", sp)
 	}
 
-	gotEntry := *(*uintptr)(unsafe.Pointer(sp + 32))
+	gotEntry := *(*uintptr)(unsafe.Pointer(sp + switchFrameSize))
 	if gotEntry != entry {
 		t.Fatalf("Expected entry 0x%x, got 0x%x", entry, gotEntry)
 	}
 
-	gotFallback := *(*uintptr)(unsafe.Pointer(sp + 40))
+	gotFallback := *(*uintptr)(unsafe.Pointer(sp + switchFrameSize + 8))
 	if gotFallback != funcPC(taskAutoExit) {
 		t.Fatalf("Expected fallback to taskAutoExit")
 	}
@@ -102,10 +107,178 @@ func TestNewTaskUsesFunctionEntryPointer(t *testing.T) {
 	}
 
 	sp := uintptr(task.ESP)
-	gotEntry := *(*uintptr)(unsafe.Pointer(sp + 32))
+	gotEntry := *(*uintptr)(unsafe.Pointer(sp + switchFrameSize))
 	wantEntry := reflect.ValueOf(testTaskEntry).Pointer()
 
 	if gotEntry != wantEntry {
 		t.Fatalf("Expected task entry 0x%x, got 0x%x", wantEntry, gotEntry)
 	}
 }
+
+func TestScheduleKeepsBlockedTaskWaiting(t *testing.T) {
+	MockInit()
+	Init()
+
+	other := NewTaskEntry(0x1000)
+	if other == nil {
+		t.Fatalf("Expected task to be created")
+	}
+
+	Block()
+
+	tasks := &queues[0].tasks
+	if tasks[0].State != TaskWaiting {
+		t.Fatalf("Expected blocked task to stay TaskWaiting, got %v", tasks[0].State)
+	}
+	if queues[0].current != other || other.State != TaskRunning {
+		t.Fatalf("Expected Block to switch to the other runnable task")
+	}
+
+	if !Wake(0) {
+		t.Fatalf("Expected Wake(0) to succeed")
+	}
+	if tasks[0].State != TaskRunnable {
+		t.Fatalf("Expected woken task to be TaskRunnable, got %v", tasks[0].State)
+	}
+	if Wake(0) {
+		t.Fatalf("Expected Wake on a non-waiting task to fail")
+	}
+}
+
+func TestBlockWithoutOtherTasksReturnsWaiting(t *testing.T) {
+	MockInit()
+	Init()
+
+	Block()
+
+	if !CurrentWaiting() {
+		t.Fatalf("Expected current task to be waiting after Block")
+	}
+	if !Wake(0) {
+		t.Fatalf("Expected Wake(0) to succeed")
+	}
+	if CurrentWaiting() || queues[0].tasks[0].State != TaskRunning {
+		t.Fatalf("Expected task woken on the CPU to be TaskRunning, got %v", queues[0].tasks[0].State)
+	}
+}
+
+func TestRunQueuesArePerCPU(t *testing.T) {
+	percpu.Reset()
+	bsp := percpu.Register(0, true)
+	ap := percpu.Register(1, false)
+	t.Cleanup(percpu.Reset)
+
+	Init()
+	percpu.Install(bsp)
+
+	percpu.Install(ap)
+	if !InitCPU() {
+		t.Fatalf("Expected InitCPU to adopt the AP context")
+	}
+	if InitCPU() {
+		t.Fatalf("Expected a second InitCPU on the same CPU to fail")
+	}
+	apTask := NewTaskEntry(0x1000)
+	if apTask == nil {
+		t.Fatalf("Expected task to be created on the AP")
+	}
+
+	if TaskCount(0) != 1 || TaskCount(1) != 2 {
+		t.Fatalf("Expected 1 task on CPU 0 and 2 on CPU 1, got %d and %d", TaskCount(0), TaskCount(1))
+	}
+
+	// Scheduling on the BSP must not pick up the AP's runnable task
+	percpu.Install(bsp)
+	Schedule()
+	if CurrentTaskID() != 0 {
+		t.Fatalf("Expected the BSP to keep running task 0, got %d", CurrentTaskID())
+	}
+
+	// Wake finds a task whichever CPU owns it
+	percpu.Install(ap)
+	Schedule()
+	if CurrentTaskID() != apTask.ID {
+		t.Fatalf("Expected the AP to switch to its own task")
+	}
+	Block()
+	percpu.Install(bsp)
+	if !Wake(apTask.ID) {
+		t.Fatalf("Expected Wake from the BSP to reach the AP's task")
+	}
+}
+
+func TestStoppedTaskIsSkipped(t *testing.T) {
+	MockInit()
+	Init()
+
+	other := NewTaskEntry(0x1000)
+	if !Stop(other.ID) || !Stopped(other.ID) {
+		t.Fatalf("Expected Stop to stop the task")
+	}
+	Schedule()
+	if CurrentTaskID() != 0 {
+		t.Fatalf("Expected a stopped task not to run, got %d", CurrentTaskID())
+	}
+
+	if !Continue(other.ID) || Stopped(other.ID) {
+		t.Fatalf("Expected Continue to resume the task")
+	}
+	Schedule()
+	if CurrentTaskID() != other.ID {
+		t.Fatalf("Expected the continued task to run, got %d", CurrentTaskID())
+	}
+}
+
+func TestKillEndsTaskAndFreesItsSlot(t *testing.T) {
+	MockInit()
+	Init()
+
+	var first *Task
+	for i := 1; i < MaxTasks; i++ {
+		task := NewTaskEntry(0x1000)
+		if task == nil {
+			t.Fatalf("Expected task %d to be created", i)
+		}
+		if first == nil {
+			first = task
+		}
+	}
+	if NewTaskEntry(0x1000) != nil {
+		t.Fatalf("Expected the pool to be full")
+	}
+
+	if Kill(0) {
+		t.Fatalf("Expected Kill of the running task to fail")
+	}
+	if !Kill(first.ID) || Alive(first.ID) || Kill(first.ID) {
+		t.Fatalf("Expected Kill to end the task once")
+	}
+	reused := NewTaskEntry(0x1000)
+	if reused != first || reused.ID == 1 || !Alive(reused.ID) {
+		t.Fatalf("Expected the killed task's slot to be reused under a new ID")
+	}
+	if TaskCount(0) != MaxTasks {
+		t.Fatalf("Expected the dead task to leave the queue, got %d tasks", TaskCount(0))
+	}
+}
+
+func TestScheduleCallsSwitchHook(t *testing.T) {
+	MockInit()
+	Init()
+
+	var loaded uint64
+	SetSwitchHook(func(t *Task) { loaded = t.TrapStack })
+	t.Cleanup(func() { SetSwitchHook(nil) })
+
+	other := NewTaskEntry(0x1000)
+	other.TrapStack = 0x8000
+	Schedule()
+	if loaded != 0x8000 {
+		t.Fatalf("Expected the trap stack of the task switched to, got 0x%x", loaded)
+	}
+	loaded = 0
+	Schedule()
+	if loaded != 0 {
+		t.Fatalf("Expected no trap stack for task 0, got 0x%x", loaded)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build !testing
+
+package kernel
+
+import (
+	"github.com/dmarro89/go-dav-os/kernel/percpu"
+	"github.com/dmarro89/go-dav-os/kernel/scheduler"
+	"github.com/dmarro89/go-dav-os/kernel/smp"
+)
+
+// lapicTimerVector is the IDT vector of the per-CPU local APIC timer
+const lapicTimerVector = 0x40
+
+// startAPs boots every application processor listed in the MADT. It must
+// run after EnableInterrupts: the AP start-up delays are measured with
+// ktime.Nanotime, which only advances on timer interrupts without a TSC
+// or HPET.
+func startAPs() {
+	smp.SetAPHandlers(apInit, apIdle)
+	smp.Start(timerHz)
+}
+
+// apInit runs on each application processor on its own stack, before it
+// is marked online: it loads the CPU's descriptor tables, joins the shared
+// IDT and syscall setup, and turns its boot context into its idle task.
+func apInit(c *percpu.CPU) {
+	initCPUTables(c.Index)
+	LoadIDT(&idtr)
+	InitSyscall()
+	percpu.Install(c)
+	scheduler.InitCPU()
+	smp.StartTimer(lapicTimerVector)
+}
+
+// apIdle is the body of an application processor's idle loop
+func apIdle() {
+	EnableInterrupts()
+	Halt()
+}
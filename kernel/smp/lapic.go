@@ -0,0 +1,143 @@
+package smp
+
+import (
+	"unsafe"
+
+	ktime "github.com/dmarro89/go-dav-os/kernel/time"
+)
+
+// Local APIC register offsets from the MMIO base
+const (
+	lapicRegID        = 0x20
+	lapicRegEOI       = 0xB0
+	lapicRegSpurious  = 0xF0
+	lapicRegICRLow    = 0x300
+	lapicRegICRHigh   = 0x310
+	lapicRegLVTTimer  = 0x320
+	lapicRegTimerInit = 0x380
+	lapicRegTimerCur  = 0x390
+	lapicRegTimerDiv  = 0x3E0
+
+	lapicDefaultBase = 0xFEE00000
+
+	lapicSoftwareEnable = 0x100
+	// SpuriousVector is where the local APIC sends spurious interrupts; the
+	// kernel installs an IDT gate that just returns
+	SpuriousVector = 0xFF
+
+	icrInit          = 0x00000500
+	icrStartup       = 0x00000600
+	icrLevelAssert   = 0x00004000
+	icrDeliveryBusy  = 0x00001000
+	icrDestShift     = 24
+	icrDeliveryLimit = 1 * ktime.NanosPerMillisecond
+
+	lvtMasked        = 0x10000
+	lvtTimerPeriodic = 0x20000
+	timerDivideBy16  = 0x3
+
+	// Calibrate the timer over 10 ms of monotonic time
+	timerCalibrationNs = 10 * ktime.NanosPerMillisecond
+)
+
+var (
+	lapicBase uint64
+
+	// timerInitCount is the LAPIC timer count for one scheduler period,
+	// measured on the BSP; 0 when calibration failed
+	timerInitCount uint32
+)
+
+func lapicRead(reg uintptr) uint32 {
+	return *(*uint32)(unsafe.Pointer(uintptr(lapicBase) + reg))
+}
+
+func lapicWrite(reg uintptr, v uint32) {
+	*(*uint32)(unsafe.Pointer(uintptr(lapicBase) + reg)) = v
+}
+
+// lapicID returns the APIC ID of the CPU executing the caller
+func lapicID() uint8 {
+	return uint8(lapicRead(lapicRegID) >> 24)
+}
+
+// enableLAPIC software-enables the local APIC of the calling CPU
+func enableLAPIC() {
+	lapicWrite(lapicRegSpurious, lapicSoftwareEnable|SpuriousVector)
+}
+
+// EOI acknowledges the interrupt being serviced by the local APIC
+func EOI() {
+	if lapicBase == 0 {
+		return
+	}
+	lapicWrite(lapicRegEOI, 0)
+}
+
+// sendIPI writes the interrupt command register and waits for delivery.
+// Returns false if the APIC never reports the IPI as sent.
+func sendIPI(apicID uint8, command uint32) bool {
+	lapicWrite(lapicRegICRHigh, uint32(apicID)<<icrDestShift)
+	lapicWrite(lapicRegICRLow, command)
+
+	start := ktime.Nanotime()
+	for lapicRead(lapicRegICRLow)&icrDeliveryBusy != 0 {
+		if ktime.Nanotime()-start > icrDeliveryLimit {
+			return false
+		}
+		pause()
+	}
+	return true
+}
+
+// startupCommand builds the SIPI command for a trampoline at page-aligned
+// physical address addr below 1 MiB
+func startupCommand(addr uint64) uint32 {
+	return icrStartup | icrLevelAssert | uint32(addr>>12)&0xFF
+}
+
+// calibrateTimer counts LAPIC timer ticks over a known stretch of monotonic
+// time and derives the count for one period at hz
+func calibrateTimer(hz uint32) {
+	timerInitCount = 0
+	if hz == 0 {
+		return
+	}
+	lapicWrite(lapicRegTimerDiv, timerDivideBy16)
+	lapicWrite(lapicRegLVTTimer, lvtMasked)
+	lapicWrite(lapicRegTimerInit, 0xFFFFFFFF)
+	delay(timerCalibrationNs)
+	elapsed := 0xFFFFFFFF - lapicRead(lapicRegTimerCur)
+	lapicWrite(lapicRegTimerInit, 0)
+
+	timerInitCount = timerCountForHz(uint64(elapsed), timerCalibrationNs, hz)
+}
+
+func timerCountForHz(counted, windowNs uint64, hz uint32) uint32 {
+	if windowNs == 0 || hz == 0 {
+		return 0
+	}
+	perSecond := counted * ktime.NanosPerSecond / windowNs
+	return uint32(perSecond / uint64(hz))
+}
+
+// StartTimer arms the calling CPU's LAPIC timer to raise vector
+// periodically at the rate passed to Start. Returns false if the timer
+// could not be calibrated.
+func StartTimer(vector uint8) bool {
+	if lapicBase == 0 || timerInitCount == 0 {
+		return false
+	}
+	lapicWrite(lapicRegTimerDiv, timerDivideBy16)
+	lapicWrite(lapicRegLVTTimer, uint32(vector)|lvtTimerPeriodic)
+	lapicWrite(lapicRegTimerInit, timerInitCount)
+	return true
+}
+
+// delay busy-waits for ns nanoseconds of monotonic time
+func delay(ns uint64) {
+	start := ktime.Nanotime()
+	for ktime.Nanotime()-start < ns {
+		pause()
+	}
+}
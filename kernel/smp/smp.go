@@ -0,0 +1,143 @@
+package smp
+
+import (
+	"unsafe"
+
+	"github.com/dmarro89/go-dav-os/kernel/acpi"
+	"github.com/dmarro89/go-dav-os/kernel/percpu"
+)
+
+const (
+	// TrampolineAddr is the page below 1 MiB the real-mode AP entry code is
+	// copied to. The page frame allocator never hands out low memory.
+	TrampolineAddr = 0x8000
+
+	apStackSize = 16384
+
+	// Offsets of the fields filled in for each AP, relative to the
+	// trampoline's parameter block (see asm/ap_trampoline.s)
+	paramCR3   = 0
+	paramStack = 8
+	paramCPU   = 16
+	paramEntry = 24
+
+	initDeassertWaitNs = 10 * 1000000 // 10 ms after INIT, per the MP spec
+	sipiWaitNs         = 200 * 1000   // 200 us between the two SIPIs
+	onlineWaitNs       = 100 * 1000000
+)
+
+var (
+	apStacks [percpu.MaxCPUs][apStackSize]byte
+
+	madt [acpi.MaxProcessors]acpi.LocalAPIC
+
+	apInit func(c *percpu.CPU)
+	apIdle func()
+)
+
+// SetAPHandlers registers the kernel code each application processor runs.
+// init loads the CPU's descriptor tables and per-CPU state and must call
+// percpu.Install; idle is then called in a loop and should halt until the
+// next interrupt.
+func SetAPHandlers(init func(c *percpu.CPU), idle func()) {
+	apInit = init
+	apIdle = idle
+}
+
+// Start registers the bootstrap processor, then wakes every usable
+// processor listed in the ACPI MADT with INIT-SIPI-SIPI. timerHz is the
+// rate StartTimer programs on each CPU. It must run with interrupts enabled
+// so the monotonic clock advances. Returns the number of CPUs online.
+func Start(timerHz uint32) int {
+	percpu.Reset()
+
+	n, base := acpi.LocalAPICs(&madt)
+	if base == 0 {
+		base = lapicDefaultBase
+	}
+	lapicBase = base
+	enableLAPIC()
+
+	bsp := percpu.Register(lapicID(), true)
+	percpu.Install(bsp)
+	bsp.Online = true
+
+	calibrateTimer(timerHz)
+
+	if n == 0 || apInit == nil {
+		return 1
+	}
+	installTrampoline()
+
+	for i := 0; i < n; i++ {
+		if !shouldStart(&madt[i], bsp.APICID) {
+			continue
+		}
+		c := percpu.Register(madt[i].APICID, false)
+		if c == nil {
+			break
+		}
+		startAP(c)
+	}
+	return percpu.OnlineCount()
+}
+
+// shouldStart reports whether a MADT entry names an AP worth waking
+func shouldStart(e *acpi.LocalAPIC, bspID uint8) bool {
+	return e.Usable && e.APICID != bspID
+}
+
+func installTrampoline() {
+	src := trampolineStart()
+	size := trampolineEnd() - src
+	for i := uintptr(0); i < size; i++ {
+		*(*byte)(unsafe.Pointer(TrampolineAddr + i)) = *(*byte)(unsafe.Pointer(src + i))
+	}
+}
+
+func setParam(off uintptr, v uint64) {
+	params := TrampolineAddr + (trampolineParams() - trampolineStart())
+	*(*uint64)(unsafe.Pointer(params + off)) = v
+}
+
+// startAP runs the INIT-SIPI-SIPI sequence for c and waits for it to report
+// online. APs are started one at a time because they share the trampoline
+// parameter block.
+func startAP(c *percpu.CPU) bool {
+	stackTop := uintptr(unsafe.Pointer(&apStacks[c.Index][0])) + apStackSize
+	setParam(paramCR3, readCR3())
+	setParam(paramStack, uint64(stackTop&^uintptr(15)))
+	setParam(paramCPU, uint64(uintptr(unsafe.Pointer(c))))
+	setParam(paramEntry, uint64(apEntryAddr()))
+
+	if !sendIPI(c.APICID, icrInit|icrLevelAssert) {
+		return false
+	}
+	delay(initDeassertWaitNs)
+
+	for attempt := 0; attempt < 2 && !c.Online; attempt++ {
+		sendIPI(c.APICID, startupCommand(TrampolineAddr))
+		delay(sipiWaitNs)
+	}
+
+	waited := uint64(0)
+	for !c.Online && waited < onlineWaitNs {
+		delay(sipiWaitNs)
+		waited += sipiWaitNs
+	}
+	return c.Online
+}
+
+// apEntry is where the trampoline lands in long mode, on the AP's own
+// stack, with the AP's percpu.CPU as argument
+func apEntry(c *percpu.CPU) {
+	enableLAPIC()
+	apInit(c)
+	c.Online = true
+
+	for {
+		if apIdle != nil {
+			apIdle()
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build gccgo
+
+package smp
+
+// Implemented in boot/stubs_amd64.s and asm/ap_trampoline.s
+func trampolineStart() uintptr
+func trampolineEnd() uintptr
+func trampolineParams() uintptr
+func apEntryAddr() uintptr
+func readCR3() uint64
+func pause()
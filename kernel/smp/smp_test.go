@@ -0,0 +1,43 @@
+package smp
+
+import (
+	"testing"
+
+	"github.com/dmarro89/go-dav-os/kernel/acpi"
+)
+
+func TestStartupCommandEncodesTrampolinePage(t *testing.T) {
+	// SIPI, level assert, vector = physical page number of the trampoline
+	if got := startupCommand(TrampolineAddr); got != 0x4608 {
+		t.Fatalf("startupCommand(0x%x) = 0x%x, want 0x4608", TrampolineAddr, got)
+	}
+}
+
+func TestShouldStartSkipsBSPAndDisabledCPUs(t *testing.T) {
+	tests := []struct {
+		entry acpi.LocalAPIC
+		want  bool
+	}{
+		{acpi.LocalAPIC{APICID: 0, Usable: true}, false},
+		{acpi.LocalAPIC{APICID: 1, Usable: true}, true},
+		{acpi.LocalAPIC{APICID: 2, Usable: false}, false},
+	}
+	for _, tt := range tests {
+		if got := shouldStart(&tt.entry, 0); got != tt.want {
+			t.Errorf("shouldStart(%+v) = %v, want %v", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestTimerCountForHz(t *testing.T) {
+	// 625000 ticks in 10 ms is 62.5 MHz; at 100 Hz that is 625000 per period
+	if got := timerCountForHz(625000, timerCalibrationNs, 100); got != 625000 {
+		t.Fatalf("timerCountForHz = %d, want 625000", got)
+	}
+	if got := timerCountForHz(625000, timerCalibrationNs, 1000); got != 62500 {
+		t.Fatalf("timerCountForHz at 1 kHz = %d, want 62500", got)
+	}
+	if timerCountForHz(625000, 0, 100) != 0 || timerCountForHz(625000, timerCalibrationNs, 0) != 0 {
+		t.Fatalf("timerCountForHz should reject a zero window or rate")
+	}
+}
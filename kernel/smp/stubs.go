@@ -0,0 +1,13 @@
+//go:build !gccgo
+
+package smp
+
+// Host builds have no trampoline or local APIC; Start is not exercised on
+// the host, only the helpers around it
+
+func trampolineStart() uintptr  { return 0 }
+func trampolineEnd() uintptr    { return 0 }
+func trampolineParams() uintptr { return 0 }
+func apEntryAddr() uintptr      { return 0 }
+func readCR3() uint64           { return 0 }
+func pause()                    {}
@@ -0,0 +1,56 @@
+package spinlock
+
+// Lock is a test-and-test-and-set spinlock. The zero value is unlocked.
+// Code that can also run in interrupt context must use AcquireIRQ so an
+// interrupt on the same CPU cannot spin forever on a lock it already holds.
+type Lock struct {
+	state uint32
+}
+
+// Acquire spins until the lock is taken
+func (l *Lock) Acquire() {
+	for xchg32(&l.state, 1) != 0 {
+		// Wait on a plain load so the cache line is not bounced by writes
+		for load32(&l.state) != 0 {
+			pause()
+		}
+	}
+}
+
+// TryAcquire takes the lock if it is free and reports whether it did
+func (l *Lock) TryAcquire() bool {
+	return xchg32(&l.state, 1) == 0
+}
+
+// Release frees the lock. x86 stores are not reordered with earlier loads
+// or stores, so a plain store is a release.
+func (l *Lock) Release() {
+	store32(&l.state, 0)
+}
+
+// Held reports whether someone holds the lock
+func (l *Lock) Held() bool {
+	return load32(&l.state) != 0
+}
+
+// AcquireIRQ disables interrupts on this CPU, then takes the lock.
+// It returns the previous RFLAGS for ReleaseIRQ.
+func (l *Lock) AcquireIRQ() uint64 {
+	flags := irqSave()
+	l.Acquire()
+	return flags
+}
+
+// ReleaseIRQ frees the lock and restores the interrupt flag saved by
+// AcquireIRQ
+func (l *Lock) ReleaseIRQ(flags uint64) {
+	l.Release()
+	irqRestore(flags)
+}
+
+// SaveInterrupts disables interrupts on this CPU and returns the previous
+// RFLAGS, for callers that drop a lock before restoring interrupts
+func SaveInterrupts() uint64 { return irqSave() }
+
+// RestoreInterrupts puts back the interrupt flag saved in flags
+func RestoreInterrupts(flags uint64) { irqRestore(flags) }
@@ -0,0 +1,13 @@
+//go:build gccgo
+
+package spinlock
+
+// Implemented in boot/stubs_amd64.s
+func xchg32(addr *uint32, v uint32) uint32
+func load32(addr *uint32) uint32
+func store32(addr *uint32, v uint32)
+func pause()
+
+// irqSave returns RFLAGS and disables interrupts; irqRestore puts IF back
+func irqSave() uint64
+func irqRestore(flags uint64)
@@ -0,0 +1,38 @@
+package spinlock
+
+import "testing"
+
+func TestAcquireRelease(t *testing.T) {
+	var l Lock
+	if l.Held() {
+		t.Fatalf("zero Lock should be unlocked")
+	}
+
+	l.Acquire()
+	if !l.Held() {
+		t.Fatalf("Acquire should take the lock")
+	}
+	if l.TryAcquire() {
+		t.Fatalf("TryAcquire should fail on a held lock")
+	}
+
+	l.Release()
+	if l.Held() {
+		t.Fatalf("Release should free the lock")
+	}
+	if !l.TryAcquire() {
+		t.Fatalf("TryAcquire should take a free lock")
+	}
+}
+
+func TestAcquireIRQRoundTrip(t *testing.T) {
+	var l Lock
+	flags := l.AcquireIRQ()
+	if !l.Held() {
+		t.Fatalf("AcquireIRQ should take the lock")
+	}
+	l.ReleaseIRQ(flags)
+	if l.Held() {
+		t.Fatalf("ReleaseIRQ should free the lock")
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !gccgo
+
+package spinlock
+
+// Host tests are single threaded, so plain memory operations stand in for
+// the locked instructions
+
+func xchg32(addr *uint32, v uint32) uint32 {
+	old := *addr
+	*addr = v
+	return old
+}
+
+func load32(addr *uint32) uint32 { return *addr }
+
+func store32(addr *uint32, v uint32) { *addr = v }
+
+func pause() {}
+
+func irqSave() uint64 { return 0 }
+
+func irqRestore(flags uint64) {}
@@ -52,11 +52,15 @@ func GetCR2() uint64 {
 	return 0
 }
 
-func getIRQ0StubAddr() uint64 {
+func getIRQStubAddr(line uint8) uint64 {
 	return 0
 }
 
-func getIRQ1StubAddr() uint64 {
+func getLAPICTimerStubAddr() uint64 {
+	return 0
+}
+
+func getSpuriousStubAddr() uint64 {
 	return 0
 }
 
@@ -1,9 +1,26 @@
 package syscall
 
 const (
-	SysWrite    = 1
-	SysExit     = 2
-	SysGetTicks = 3
+	SysWrite     = 1
+	SysExit      = 2
+	SysGetTicks  = 3
+	SysNanosleep = 4
+	SysSocket    = 5
+	SysBind      = 6
+	SysListen    = 7
+	SysAccept    = 8
+	SysConnect   = 9
+	SysSend      = 10
+	SysRecv      = 11
+	SysClose     = 12
+)
+
+// Socket arguments follow the BSD API: socket(AFInet, SockStream, 0) and a
+// 16-byte sockaddr_in {family uint16, port big-endian, addr big-endian, zero}
+const (
+	AFInet       = 2
+	SockStream   = 1
+	SockaddrSize = 16
 )
 
 type TrapFrame struct {
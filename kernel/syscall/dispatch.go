@@ -3,17 +3,43 @@ package syscall
 import (
 	"unsafe"
 
+	"github.com/dmarro89/go-dav-os/kernel/scheduler"
 	"github.com/dmarro89/go-dav-os/terminal"
 )
 
 const (
+	// The program page is followed by a stack page for each program that
+	// can run at once (USER_STACK_PAGES in boot/boot.s)
 	userVAStart      uintptr = 0x40000000
-	userVAEnd        uintptr = 0x40002000
+	userVAEnd        uintptr = 0x40005000
+	userStackStart   uintptr = 0x40001000
 	maxSysWriteBytes         = 4096
 	syscallError             = ^uint64(0)
 )
 
-var sysWriteBuffer [maxSysWriteBytes]byte
+// Buffer is what a syscall copies user memory through. Each program has
+// its own, so one blocked in send keeps its bytes while others run.
+type Buffer [maxSysWriteBytes]byte
+
+var (
+	// kernelBuffer serves every task that is not a program
+	kernelBuffer Buffer
+	buffer       = &kernelBuffer
+	sleepHook    func(ns uint64)
+)
+
+// SetBuffer makes b the copy buffer of the syscalls that follow, for the
+// scheduler to call with that of the program it switches to; nil is the
+// kernel's own
+func SetBuffer(b *Buffer) {
+	if b == nil {
+		b = &kernelBuffer
+	}
+	buffer = b
+}
+
+// SetSleepHandler wires SYS_NANOSLEEP to the kernel clock
+func SetSleepHandler(fn func(ns uint64)) { sleepHook = fn }
 
 func Dispatch(tf *TrapFrame, getTicks func() uint64, returnToKernel func()) {
 	switch uint32(tf.RAX) {
@@ -25,6 +51,7 @@ func Dispatch(tf *TrapFrame, getTicks func() uint64, returnToKernel func()) {
 	case SysExit:
 		status := int(tf.RDI)
 		if tf.CS&3 == 3 {
+			CloseSockets(scheduler.CurrentTaskID())
 			terminal.Print("Process exited with status ")
 			terminal.PrintInt(status)
 			terminal.Print("\n")
@@ -44,6 +71,29 @@ func Dispatch(tf *TrapFrame, getTicks func() uint64, returnToKernel func()) {
 			return
 		}
 		tf.RAX = getTicks()
+	case SysNanosleep:
+		if sleepHook == nil {
+			tf.RAX = syscallError
+			return
+		}
+		sleepHook(tf.RDI)
+		tf.RAX = 0
+	case SysSocket:
+		tf.RAX = sysSocket(tf.RDI, tf.RSI)
+	case SysBind:
+		tf.RAX = sysBind(tf.RDI, uintptr(tf.RSI), tf.RDX)
+	case SysListen:
+		tf.RAX = sysListen(tf.RDI, tf.RSI)
+	case SysAccept:
+		tf.RAX = sysAccept(tf.RDI, uintptr(tf.RSI))
+	case SysConnect:
+		tf.RAX = sysConnect(tf.RDI, uintptr(tf.RSI), tf.RDX)
+	case SysSend:
+		tf.RAX = sysSend(tf.RDI, uintptr(tf.RSI), tf.RDX)
+	case SysRecv:
+		tf.RAX = sysRecv(tf.RDI, uintptr(tf.RSI), tf.RDX)
+	case SysClose:
+		tf.RAX = sysClose(tf.RDI)
 	default:
 		terminal.Print("unknown syscall\n")
 		tf.RAX = ^uint64(0)
@@ -54,7 +104,7 @@ func sysWrite(fd uint64, buf uintptr, n uint64) uint64 {
 	return sysWriteWithCopier(fd, buf, n, copyFromUserBytes)
 }
 
-func sysWriteWithCopier(fd uint64, buf uintptr, n uint64, copier func(*[maxSysWriteBytes]byte, int, uintptr) bool) uint64 {
+func sysWriteWithCopier(fd uint64, buf uintptr, n uint64, copier func(*Buffer, int, uintptr) bool) uint64 {
 	if fd != 1 {
 		return syscallError
 	}
@@ -66,18 +116,18 @@ func sysWriteWithCopier(fd uint64, buf uintptr, n uint64, copier func(*[maxSysWr
 	}
 
 	count := int(n)
-	if !copier(&sysWriteBuffer, count, buf) {
+	b := buffer
+	if !copier(b, count, buf) {
 		return syscallError
 	}
 
 	for i := 0; i < count; i++ {
-		b := sysWriteBuffer[i]
-		terminal.PutRune(rune(b))
+		terminal.PutRune(rune(b[i]))
 	}
 	return n
 }
 
-func copyFromUserBytes(dst *[maxSysWriteBytes]byte, count int, userPtr uintptr) bool {
+func copyFromUserBytes(dst *Buffer, count int, userPtr uintptr) bool {
 	if count < 0 || count > maxSysWriteBytes {
 		return false
 	}
@@ -91,6 +141,22 @@ func copyFromUserBytes(dst *[maxSysWriteBytes]byte, count int, userPtr uintptr)
 	return true
 }
 
+// copyToUserBytes only writes into the user stack pages; the program page
+// is mapped read-only
+func copyToUserBytes(src *Buffer, count int, userPtr uintptr) bool {
+	if count < 0 || count > maxSysWriteBytes {
+		return false
+	}
+	if !validUserRange(userPtr, uintptr(count)) || (count > 0 && userPtr < userStackStart) {
+		return false
+	}
+
+	for i := 0; i < count; i++ {
+		*(*byte)(unsafe.Pointer(userPtr + uintptr(i))) = src[i]
+	}
+	return true
+}
+
 func validUserRange(start, length uintptr) bool {
 	if length == 0 {
 		return true
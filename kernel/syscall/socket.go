@@ -0,0 +1,238 @@
+package syscall
+
+import (
+	"github.com/dmarro89/go-dav-os/kernel/scheduler"
+	"github.com/dmarro89/go-dav-os/net"
+)
+
+const (
+	// MaxSockets bounds the descriptors a program can hold; socket
+	// descriptors start after stdin, stdout and stderr
+	MaxSockets    = 8
+	firstSocketFD = 3
+
+	connectTimeoutNs = 10000000000
+)
+
+type socket struct {
+	used bool
+	// owner is the task ID of the program that opened the socket; the
+	// others cannot see it
+	owner     int
+	port      uint16
+	conn      int
+	listening bool
+}
+
+var (
+	sockets [MaxSockets]socket
+
+	// Tests swap these for copiers that do not touch user memory
+	copyIn  = copyFromUserBytes
+	copyOut = copyToUserBytes
+)
+
+// socketAt maps a descriptor to its socket, or nil when it is not open
+func socketAt(fd uint64) *socket {
+	if fd < firstSocketFD || fd >= firstSocketFD+MaxSockets {
+		return nil
+	}
+	s := &sockets[fd-firstSocketFD]
+	if !s.used || s.owner != scheduler.CurrentTaskID() {
+		return nil
+	}
+	return s
+}
+
+// sysSocket only knows TCP over IPv4
+func sysSocket(domain, kind uint64) uint64 {
+	if domain != AFInet || kind != SockStream {
+		return syscallError
+	}
+	for i := range sockets {
+		s := &sockets[i]
+		if !s.used {
+			s.used = true
+			s.owner = scheduler.CurrentTaskID()
+			s.port = 0
+			s.conn = -1
+			s.listening = false
+			return uint64(firstSocketFD + i)
+		}
+	}
+	return syscallError
+}
+
+// readSockaddr copies a sockaddr_in from the user and returns its address
+// and port
+func readSockaddr(ptr uintptr, size uint64) (net.IP, uint16, bool) {
+	b := buffer
+	if size < SockaddrSize || !copyIn(b, SockaddrSize, ptr) {
+		return 0, 0, false
+	}
+	if uint16(b[0])|uint16(b[1])<<8 != AFInet {
+		return 0, 0, false
+	}
+	port := uint16(b[2])<<8 | uint16(b[3])
+	ip := net.IP(uint32(b[4])<<24 | uint32(b[5])<<16 | uint32(b[6])<<8 | uint32(b[7]))
+	return ip, port, true
+}
+
+func writeSockaddr(ptr uintptr, ip net.IP, port uint16) bool {
+	b := buffer
+	for i := 0; i < SockaddrSize; i++ {
+		b[i] = 0
+	}
+	b[0] = AFInet
+	b[2] = byte(port >> 8)
+	b[3] = byte(port)
+	b[4] = byte(ip >> 24)
+	b[5] = byte(ip >> 16)
+	b[6] = byte(ip >> 8)
+	b[7] = byte(ip)
+	return copyOut(b, SockaddrSize, ptr)
+}
+
+// sysBind records the local port; the address must be INADDR_ANY or ours
+func sysBind(fd uint64, addr uintptr, size uint64) uint64 {
+	s := socketAt(fd)
+	if s == nil || s.conn >= 0 || s.port != 0 {
+		return syscallError
+	}
+	ip, port, ok := readSockaddr(addr, size)
+	if !ok || port == 0 || (ip != 0 && ip != net.Addr()) {
+		return syscallError
+	}
+	s.port = port
+	return 0
+}
+
+func sysListen(fd, backlog uint64) uint64 {
+	s := socketAt(fd)
+	if s == nil || s.port == 0 || s.conn >= 0 {
+		return syscallError
+	}
+	if backlog > net.MaxTCPBacklog {
+		backlog = net.MaxTCPBacklog
+	}
+	c, err := net.TCPListen(s.port, int(backlog))
+	if err != net.OK {
+		return syscallError
+	}
+	s.conn = c
+	s.listening = true
+	return 0
+}
+
+// sysAccept blocks until a connection completes and returns its descriptor,
+// storing the peer's sockaddr_in at addr when it is not zero
+func sysAccept(fd uint64, addr uintptr) uint64 {
+	s := socketAt(fd)
+	if s == nil || !s.listening {
+		return syscallError
+	}
+	nfd := sysSocket(AFInet, SockStream)
+	if nfd == syscallError {
+		return syscallError
+	}
+	c, err := net.TCPAccept(s.conn, net.Forever)
+	if err != net.OK {
+		sockets[nfd-firstSocketFD].used = false
+		return syscallError
+	}
+	ns := &sockets[nfd-firstSocketFD]
+	ns.conn = c
+	ns.port = s.port
+	if addr != 0 {
+		ip, port := net.TCPPeer(c)
+		if !writeSockaddr(addr, ip, port) {
+			net.TCPClose(c)
+			ns.used = false
+			return syscallError
+		}
+	}
+	return nfd
+}
+
+func sysConnect(fd uint64, addr uintptr, size uint64) uint64 {
+	s := socketAt(fd)
+	if s == nil || s.conn >= 0 {
+		return syscallError
+	}
+	ip, port, ok := readSockaddr(addr, size)
+	if !ok || port == 0 {
+		return syscallError
+	}
+	c, err := net.TCPConnect(ip, port, connectTimeoutNs)
+	if err != net.OK {
+		return syscallError
+	}
+	s.conn = c
+	s.port = net.TCPLocalPort(c)
+	return 0
+}
+
+// sysSend blocks until every byte is queued, at most one page per call
+func sysSend(fd uint64, buf uintptr, n uint64) uint64 {
+	s := socketAt(fd)
+	if s == nil || s.conn < 0 || s.listening {
+		return syscallError
+	}
+	if n > maxSysWriteBytes {
+		n = maxSysWriteBytes
+	}
+	// The send can block while other programs run, so it holds on to
+	// this program's buffer rather than whichever is current later
+	b := buffer
+	if !copyIn(b, int(n), buf) {
+		return syscallError
+	}
+	sent, err := net.TCPSend(s.conn, b[:n], net.Forever)
+	if err != net.OK {
+		return syscallError
+	}
+	return uint64(sent)
+}
+
+// sysRecv blocks until data arrives and returns 0 once the peer has closed
+func sysRecv(fd uint64, buf uintptr, n uint64) uint64 {
+	s := socketAt(fd)
+	if s == nil || s.conn < 0 || s.listening {
+		return syscallError
+	}
+	if n > maxSysWriteBytes {
+		n = maxSysWriteBytes
+	}
+	b := buffer
+	got, err := net.TCPRecv(s.conn, b[:n], net.Forever)
+	if err != net.OK || !copyOut(b, got, buf) {
+		return syscallError
+	}
+	return uint64(got)
+}
+
+func sysClose(fd uint64) uint64 {
+	s := socketAt(fd)
+	if s == nil {
+		return syscallError
+	}
+	closeSocket(s)
+	return 0
+}
+
+func closeSocket(s *socket) {
+	if s.conn >= 0 {
+		net.TCPClose(s.conn)
+	}
+	s.used = false
+}
+
+// CloseSockets releases whatever the program running as task pid left
+// open, when it exits or is killed
+func CloseSockets(pid int) {
+	for i := range sockets {
+		if s := &sockets[i]; s.used && s.owner == pid {
+			closeSocket(s)
+		}
+	}
+}
@@ -1,6 +1,12 @@
 package syscall
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"github.com/dmarro89/go-dav-os/kernel/scheduler"
+	"github.com/dmarro89/go-dav-os/net"
+)
 
 func TestSTARValueUsesKernelAndSYSRETBaseSelectors(t *testing.T) {
 	const kernelCS = uint16(0x08)
@@ -29,7 +35,7 @@ func TestEnableSCESetsBitZero(t *testing.T) {
 func TestDispatchWriteUsesSyscallABIRegisters(t *testing.T) {
 	const userBuf = uintptr(userVAStart)
 
-	copier := func(dst *[maxSysWriteBytes]byte, count int, src uintptr) bool {
+	copier := func(dst *Buffer, count int, src uintptr) bool {
 		if src != userBuf {
 			t.Fatalf("copy_from_user source mismatch: got=0x%x want=0x%x", src, userBuf)
 		}
@@ -57,7 +63,7 @@ func TestSysWriteRejectsInvalidUserPointer(t *testing.T) {
 func TestSysWriteClampsLargeWrites(t *testing.T) {
 	const userBuf = uintptr(userVAStart)
 
-	copier := func(dst *[maxSysWriteBytes]byte, count int, src uintptr) bool {
+	copier := func(dst *Buffer, count int, src uintptr) bool {
 		if src != userBuf {
 			t.Fatalf("copy_from_user source mismatch: got=0x%x want=0x%x", src, userBuf)
 		}
@@ -117,3 +123,249 @@ func TestDispatchGetTicksUsesHook(t *testing.T) {
 		t.Fatalf("SYS_GETTICKS mismatch: got=%d want=1234", tf.RAX)
 	}
 }
+
+func TestDispatchNanosleepUsesHook(t *testing.T) {
+	var slept uint64
+	SetSleepHandler(func(ns uint64) { slept = ns })
+	t.Cleanup(func() { SetSleepHandler(nil) })
+
+	tf := TrapFrame{RAX: SysNanosleep, RDI: 5000000}
+	Dispatch(&tf, nil, nil)
+
+	if slept != 5000000 || tf.RAX != 0 {
+		t.Fatalf("SYS_NANOSLEEP mismatch: slept=%d rax=0x%x", slept, tf.RAX)
+	}
+}
+
+func TestDispatchNanosleepWithoutHookFails(t *testing.T) {
+	SetSleepHandler(nil)
+	tf := TrapFrame{RAX: SysNanosleep, RDI: 1}
+
+	Dispatch(&tf, nil, nil)
+
+	if tf.RAX != syscallError {
+		t.Fatalf("SYS_NANOSLEEP without hook should fail: got=0x%016x", tf.RAX)
+	}
+}
+
+// fakeUser stands in for user memory in the socket tests
+var fakeUser [maxSysWriteBytes]byte
+
+func setupSockets(t *testing.T) {
+	t.Helper()
+	net.ResetForTesting()
+	for i := range sockets {
+		sockets[i].used = false
+	}
+	copyIn = func(dst *Buffer, count int, src uintptr) bool {
+		copy(dst[:count], fakeUser[src-userStackStart:])
+		return true
+	}
+	copyOut = func(src *Buffer, count int, dst uintptr) bool {
+		copy(fakeUser[dst-userStackStart:], src[:count])
+		return true
+	}
+	t.Cleanup(func() {
+		copyIn, copyOut = copyFromUserBytes, copyToUserBytes
+		net.ResetForTesting()
+	})
+}
+
+func putSockaddr(off int, ip net.IP, port uint16) uintptr {
+	b := fakeUser[off : off+SockaddrSize]
+	b[0], b[1] = AFInet, 0
+	b[2], b[3] = byte(port>>8), byte(port)
+	b[4], b[5], b[6], b[7] = byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip)
+	return userStackStart + uintptr(off)
+}
+
+func invoke(nr, a, b, c uint64) uint64 {
+	tf := TrapFrame{RAX: nr, RDI: a, RSI: b, RDX: c}
+	Dispatch(&tf, nil, nil)
+	return tf.RAX
+}
+
+func TestSocketOnlyKnowsTCP(t *testing.T) {
+	setupSockets(t)
+	if fd := invoke(SysSocket, AFInet, 2, 0); fd != syscallError {
+		t.Fatalf("datagram socket = %d, want an error", fd)
+	}
+	if fd := invoke(SysSocket, 10, SockStream, 0); fd != syscallError {
+		t.Fatalf("AF_INET6 socket = %d, want an error", fd)
+	}
+	for i := 0; i < MaxSockets; i++ {
+		if fd := invoke(SysSocket, AFInet, SockStream, 0); fd != uint64(firstSocketFD+i) {
+			t.Fatalf("socket %d = %d", i, fd)
+		}
+	}
+	if fd := invoke(SysSocket, AFInet, SockStream, 0); fd != syscallError {
+		t.Fatalf("socket beyond the table = %d", fd)
+	}
+	if invoke(SysClose, 1, 0, 0) != syscallError || invoke(SysClose, firstSocketFD, 0, 0) != 0 {
+		t.Fatalf("close accepted stdout or refused a socket")
+	}
+	if invoke(SysClose, firstSocketFD, 0, 0) != syscallError {
+		t.Fatalf("closed the same descriptor twice")
+	}
+}
+
+func TestSocketBindAndListen(t *testing.T) {
+	setupSockets(t)
+	fd := invoke(SysSocket, AFInet, SockStream, 0)
+	if invoke(SysListen, fd, 1, 0) != syscallError {
+		t.Fatalf("listen before bind succeeded")
+	}
+	addr := putSockaddr(0, 0, 7777)
+	if invoke(SysBind, fd, uint64(addr), SockaddrSize-1) != syscallError {
+		t.Fatalf("bind accepted a short sockaddr")
+	}
+	if invoke(SysBind, fd, uint64(addr), SockaddrSize) != 0 || invoke(SysListen, fd, 16, 0) != 0 {
+		t.Fatalf("bind/listen on 7777 failed")
+	}
+	if s := socketAt(fd); !s.listening || net.TCPStateOf(s.conn) != net.StateListen || net.TCPLocalPort(s.conn) != 7777 {
+		t.Fatalf("listening socket = %+v", *s)
+	}
+	if invoke(SysRecv, fd, uint64(userStackStart), 4) != syscallError {
+		t.Fatalf("recv on a listening socket succeeded")
+	}
+
+	other := invoke(SysSocket, AFInet, SockStream, 0)
+	invoke(SysBind, other, uint64(addr), SockaddrSize)
+	if invoke(SysListen, other, 1, 0) != syscallError {
+		t.Fatalf("second listener on 7777 succeeded")
+	}
+
+	// Exiting closes every socket the program left open
+	tf := TrapFrame{RAX: SysExit, CS: 0x23}
+	Dispatch(&tf, nil, nil)
+	if socketAt(fd) != nil || socketAt(other) != nil || net.TCPStateOf(0) != net.StateClosed {
+		t.Fatalf("sockets survived SYS_EXIT")
+	}
+}
+
+func TestSocketsBelongToTheirProgram(t *testing.T) {
+	setupSockets(t)
+	scheduler.Init()
+	t.Cleanup(scheduler.Init)
+
+	mine := invoke(SysSocket, AFInet, SockStream, 0)
+	other := scheduler.NewTaskEntry(0x1000)
+	scheduler.Schedule()
+	if scheduler.CurrentTaskID() != other.ID {
+		t.Fatalf("did not switch to the other program")
+	}
+	if invoke(SysClose, mine, 0, 0) != syscallError {
+		t.Fatalf("another program closed the socket")
+	}
+	theirs := invoke(SysSocket, AFInet, SockStream, 0)
+	if theirs == mine || theirs == syscallError {
+		t.Fatalf("second program got descriptor %d", theirs)
+	}
+
+	CloseSockets(0)
+	if socketAt(theirs) == nil || sockets[mine-firstSocketFD].used {
+		t.Fatalf("CloseSockets(0) closed the wrong sockets")
+	}
+}
+
+func TestBlockedSendKeepsItsOwnBuffer(t *testing.T) {
+	setupSockets(t)
+	scheduler.Init()
+	var buffers [2]Buffer
+	scheduler.SetSwitchHook(func(task *scheduler.Task) { SetBuffer(&buffers[task.ID]) })
+	t.Cleanup(func() {
+		scheduler.SetSwitchHook(nil)
+		scheduler.Init()
+		SetBuffer(nil)
+	})
+	SetBuffer(&buffers[0])
+
+	fill := func(c byte, n int) {
+		for i := 0; i < n; i++ {
+			fakeUser[i] = c
+		}
+	}
+	connect := func() uint64 {
+		fd := invoke(SysSocket, AFInet, SockStream, 0)
+		socketAt(fd).conn = net.EstablishedForTesting()
+		return fd
+	}
+
+	mine := connect()
+	fill('a', maxSysWriteBytes)
+	if invoke(SysSend, mine, uint64(userStackStart), maxSysWriteBytes) != maxSysWriteBytes {
+		t.Fatalf("could not fill the send ring")
+	}
+
+	// The first program's next send finds the ring full and waits; the
+	// other one sends meanwhile, then the peer acknowledges
+	other := scheduler.NewTaskEntry(0x1000)
+	var theirs uint64
+	var acked [net.TCPBufferSize]byte
+	idled := 0
+	net.SetIdleForTesting(func() {
+		idled++
+		if idled > 1 {
+			t.Fatalf("send still blocked after the ring drained")
+		}
+		scheduler.Schedule()
+		if scheduler.CurrentTaskID() != other.ID {
+			t.Fatalf("did not switch to the other program")
+		}
+		theirs = connect()
+		fill('b', 100)
+		if invoke(SysSend, theirs, uint64(userStackStart), 100) != 100 {
+			t.Fatalf("the other program's send failed")
+		}
+		scheduler.Schedule()
+		net.AckForTesting(socketAt(mine).conn, acked[:])
+	})
+
+	fill('A', 100)
+	if invoke(SysSend, mine, uint64(userStackStart), 100) != 100 || idled != 1 {
+		t.Fatalf("blocked send did not complete (idled %d times)", idled)
+	}
+	n := net.AckForTesting(socketAt(mine).conn, acked[:])
+	if n != 100 || string(acked[:n]) != strings.Repeat("A", 100) {
+		t.Fatalf("blocked send queued %q", acked[:n])
+	}
+
+	scheduler.Schedule()
+	n = net.AckForTesting(socketAt(theirs).conn, acked[:])
+	if n != 100 || string(acked[:n]) != strings.Repeat("b", 100) {
+		t.Fatalf("other program queued %q", acked[:n])
+	}
+}
+
+func TestSockaddrEncoding(t *testing.T) {
+	setupSockets(t)
+	ip, port, ok := readSockaddr(putSockaddr(32, net.IPv4(10, 0, 2, 2), 80), SockaddrSize)
+	if !ok || ip != net.IPv4(10, 0, 2, 2) || port != 80 {
+		t.Fatalf("readSockaddr = 0x%08x:%d %v", ip, port, ok)
+	}
+	fakeUser[32] = 10
+	if _, _, ok := readSockaddr(userStackStart+32, SockaddrSize); ok {
+		t.Fatalf("readSockaddr accepted a non-AF_INET family")
+	}
+
+	if !writeSockaddr(userStackStart+64, net.IPv4(192, 168, 1, 9), 0x1234) {
+		t.Fatalf("writeSockaddr failed")
+	}
+	want := []byte{AFInet, 0, 0x12, 0x34, 192, 168, 1, 9, 0, 0, 0, 0, 0, 0, 0, 0}
+	if string(fakeUser[64:64+SockaddrSize]) != string(want) {
+		t.Fatalf("sockaddr = % x", fakeUser[64:64+SockaddrSize])
+	}
+}
+
+func TestCopyToUserOnlyWritesTheStack(t *testing.T) {
+	var buf Buffer
+	if copyToUserBytes(&buf, 4, userVAStart) {
+		t.Fatalf("copy_to_user wrote into the read-only program page")
+	}
+	if copyToUserBytes(&buf, 2, userVAEnd-1) {
+		t.Fatalf("copy_to_user ran past the stack page")
+	}
+	if !copyToUserBytes(&buf, 0, userVAStart) {
+		t.Fatalf("empty copy_to_user failed")
+	}
+}
@@ -17,9 +17,9 @@ func InitSyscall() {
 }
 
 func Int80Handler(tf *ksyscall.TrapFrame) {
-	ksyscall.Dispatch(tf, GetTicks, ReturnToKernel)
+	ksyscall.Dispatch(tf, GetTicks, endProcess)
 }
 
 func SyscallHandler(tf *ksyscall.TrapFrame) {
-	ksyscall.Dispatch(tf, GetTicks, ReturnToKernel)
+	ksyscall.Dispatch(tf, GetTicks, endProcess)
 }
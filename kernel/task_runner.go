@@ -6,16 +6,18 @@ var helloProgramName = [...]byte{'h', 'e', 'l', 'l', 'o'}
 var kernelReadProbeProgramName = [...]byte{'k', 'r', 'e', 'a', 'd'}
 var kernelWriteProbeProgramName = [...]byte{'k', 'w', 'r', 'i', 't', 'e'}
 var privilegedProbeProgramName = [...]byte{'k', 'p', 'r', 'i', 'v'}
+var echoProgramName = [...]byte{'e', 'c', 'h', 'o'}
 
 func ExecuteUserTask(rip, rsp uint64)
 func GetUserProgramHelloAddr() uint64
 func GetUserProgramKernelReadProbeAddr() uint64
 func GetUserProgramKernelWriteProbeAddr() uint64
 func GetUserProgramPrivilegedProbeAddr() uint64
+func GetUserProgramEchoAddr() uint64
 func GetUserStackTopAddr() uint64
 
-func RunProgram(name *[16]byte, nameLen int) (pid int, ok bool) {
-	var rip uint64
+// programEntry returns where the program called name starts
+func programEntry(name *[16]byte, nameLen int) (rip uint64, ok bool) {
 	switch {
 	case matchProgramName(name, nameLen, helloProgramName[:]):
 		rip = GetUserProgramHelloAddr()
@@ -25,13 +27,12 @@ func RunProgram(name *[16]byte, nameLen int) (pid int, ok bool) {
 		rip = GetUserProgramKernelWriteProbeAddr()
 	case matchProgramName(name, nameLen, privilegedProbeProgramName[:]):
 		rip = GetUserProgramPrivilegedProbeAddr()
+	case matchProgramName(name, nameLen, echoProgramName[:]):
+		rip = GetUserProgramEchoAddr()
 	default:
-		return -1, false
+		return 0, false
 	}
-
-	ExecuteUserTask(rip, GetUserStackTopAddr())
-
-	return 1, true
+	return rip, true
 }
 
 func matchProgramName(name *[16]byte, nameLen int, expected []byte) bool {
@@ -0,0 +1,46 @@
+package time
+
+const (
+	pitChannel2Port uint16 = 0x42
+	pitCommandPort  uint16 = 0x43
+	pitGatePort     uint16 = 0x61
+
+	pitGateBit    = 0x01
+	pitSpeakerBit = 0x02
+	pitOut2Bit    = 0x20
+
+	// Channel 2, lobyte/hibyte access, mode 0 (interrupt on terminal count)
+	pitChannel2OneShot = 0xB0
+
+	calibrationWindowHz  = 100 // 10 ms sample
+	calibrationSpinLimit = 50000000
+)
+
+// calibrateTSC counts TSC cycles while PIT channel 2 runs a 10 ms one-shot.
+// Channel 2 is polled through port 0x61, so no interrupt is needed and the
+// PIT channel 0 tick rate is left untouched. Returns 0 on timeout.
+func calibrateTSC() uint64 {
+	count := uint64(pitInputHz / calibrationWindowHz)
+
+	gate := inb(pitGatePort)
+	outb(pitGatePort, (gate&^pitSpeakerBit)|pitGateBit)
+	outb(pitCommandPort, pitChannel2OneShot)
+	outb(pitChannel2Port, byte(count))
+	outb(pitChannel2Port, byte(count>>8))
+
+	start := rdtsc()
+	done := false
+	for i := 0; i < calibrationSpinLimit; i++ {
+		if inb(pitGatePort)&pitOut2Bit != 0 {
+			done = true
+			break
+		}
+	}
+	end := rdtsc()
+
+	outb(pitGatePort, gate)
+	if !done {
+		return 0
+	}
+	return tscHzFromSample(end-start, count)
+}
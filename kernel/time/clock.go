@@ -0,0 +1,122 @@
+package time
+
+const (
+	NanosPerSecond      = 1000000000
+	NanosPerMillisecond = 1000000
+
+	pitInputHz = 1193182
+)
+
+// Source identifies the counter backing Nanotime
+type Source uint8
+
+const (
+	SourcePIT Source = iota
+	SourceTSC
+	SourceHPET
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceTSC:
+		return "tsc"
+	case SourceHPET:
+		return "hpet"
+	default:
+		return "pit"
+	}
+}
+
+var (
+	source Source
+	tickHz uint64
+
+	tscHz   uint64
+	tscBase uint64
+)
+
+// Init picks the monotonic clock source and resets the timer wheel.
+// The HPET main counter is used when hpetAddr points at a sane HPET block;
+// otherwise the TSC is calibrated against PIT channel 2. If calibration
+// fails, time falls back to counting PIT ticks. hz is the rate at which
+// the kernel calls Tick.
+func Init(hpetAddr uint64, hz uint32) {
+	if hz == 0 {
+		hz = 100
+	}
+	tickHz = uint64(hz)
+	resetWheel()
+
+	if hpetAddr != 0 && initHPET(hpetAddr) {
+		source = SourceHPET
+		return
+	}
+
+	tscHz = calibrateTSC()
+	if tscHz != 0 {
+		tscBase = rdtsc()
+		source = SourceTSC
+		return
+	}
+
+	source = SourcePIT
+}
+
+// CurrentSource reports which counter Nanotime reads
+func CurrentSource() Source { return source }
+
+// TSCHz returns the calibrated TSC frequency, or 0 if it was not calibrated
+func TSCHz() uint64 { return tscHz }
+
+// TickHz returns the rate at which the timer wheel advances
+func TickHz() uint64 { return tickHz }
+
+// Nanotime returns nanoseconds since Init from the best available counter.
+// It never goes backwards.
+func Nanotime() uint64 {
+	switch source {
+	case SourceHPET:
+		return hpetNanos()
+	case SourceTSC:
+		return cyclesToNanos(rdtsc()-tscBase, tscHz)
+	default:
+		return ticksToNanos(wheelNow)
+	}
+}
+
+// cyclesToNanos converts a counter delta at hz into nanoseconds without
+// overflowing the intermediate product for realistic uptimes
+func cyclesToNanos(cycles, hz uint64) uint64 {
+	if hz == 0 {
+		return 0
+	}
+	secs := cycles / hz
+	rem := cycles % hz
+	return secs*NanosPerSecond + rem*NanosPerSecond/hz
+}
+
+func ticksToNanos(ticks uint64) uint64 {
+	return cyclesToNanos(ticks, tickHz)
+}
+
+// nanosToTicks rounds up, and adds one tick because the next tick may be
+// only moments away: a sleep must last at least the requested time
+func nanosToTicks(ns uint64) uint64 {
+	if ns == 0 || tickHz == 0 {
+		return 0
+	}
+	secs := ns / NanosPerSecond
+	rem := ns % NanosPerSecond
+	ticks := secs * tickHz
+	ticks += (rem*tickHz + NanosPerSecond - 1) / NanosPerSecond
+	return ticks + 1
+}
+
+// tscHzFromSample derives the TSC frequency from the cycles counted while
+// PIT channel 2 counted down pitCount input clocks
+func tscHzFromSample(cycles, pitCount uint64) uint64 {
+	if pitCount == 0 {
+		return 0
+	}
+	return cycles * pitInputHz / pitCount
+}
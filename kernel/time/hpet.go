@@ -0,0 +1,56 @@
+package time
+
+import "unsafe"
+
+const (
+	hpetCapabilitiesReg = 0x000
+	hpetConfigReg       = 0x010
+	hpetCounterReg      = 0x0F0
+
+	hpetEnableBit = 1
+
+	// The spec caps the counter period at 100 ns (in femtoseconds)
+	hpetMaxPeriodFs = 100000000
+	femtosPerNano   = 1000000
+)
+
+var (
+	hpetBase     uintptr
+	hpetPeriodFs uint64
+	hpetStart    uint64
+)
+
+func hpetRead(reg uintptr) uint64 {
+	return *(*uint64)(unsafe.Pointer(hpetBase + reg))
+}
+
+func hpetWrite(reg uintptr, v uint64) {
+	*(*uint64)(unsafe.Pointer(hpetBase + reg)) = v
+}
+
+// initHPET validates the counter period and starts the main counter
+func initHPET(addr uint64) bool {
+	hpetBase = uintptr(addr)
+	period := hpetRead(hpetCapabilitiesReg) >> 32
+	if period == 0 || period > hpetMaxPeriodFs {
+		hpetBase = 0
+		return false
+	}
+	hpetPeriodFs = period
+
+	hpetWrite(hpetConfigReg, hpetRead(hpetConfigReg)|hpetEnableBit)
+	hpetStart = hpetRead(hpetCounterReg)
+	return true
+}
+
+func hpetNanos() uint64 {
+	return counterToNanos(hpetRead(hpetCounterReg)-hpetStart, hpetPeriodFs)
+}
+
+// counterToNanos scales HPET counter ticks by a femtosecond period, split so
+// the product stays within 64 bits
+func counterToNanos(counter, periodFs uint64) uint64 {
+	whole := counter / femtosPerNano
+	rem := counter % femtosPerNano
+	return whole*periodFs + rem*periodFs/femtosPerNano
+}
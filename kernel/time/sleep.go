@@ -0,0 +1,96 @@
+package time
+
+import "github.com/dmarro89/go-dav-os/kernel/scheduler"
+
+// Sleep blocks the current task for at least ms milliseconds
+func Sleep(ms uint64) {
+	SleepNanos(ms * NanosPerMillisecond)
+}
+
+// SleepNanos blocks the current scheduler task for at least ns nanoseconds.
+// The task is parked as TaskWaiting and a one-shot timer wakes it, so other
+// tasks run meanwhile; when nothing else is runnable the CPU halts until
+// the next interrupt instead of spinning.
+func SleepNanos(ns uint64) {
+	ticks := nanosToTicks(ns)
+	if ticks == 0 {
+		return
+	}
+
+	// Arm and block with interrupts off so the wake-up cannot fire between
+	// the two steps and be lost
+	flags := irqSave()
+	id := scheduler.CurrentTaskID()
+	if id < 0 {
+		waitTicks(ticks)
+		irqRestore(flags)
+		return
+	}
+	if _, ok := AddTimer(ticks, wakeSleeper, uintptr(id)); !ok {
+		waitTicks(ticks)
+		irqRestore(flags)
+		return
+	}
+
+	scheduler.Block()
+	for scheduler.CurrentWaiting() {
+		waitForInterrupt()
+	}
+	irqRestore(flags)
+}
+
+func wakeSleeper(arg uintptr) {
+	scheduler.Wake(int(arg))
+}
+
+// WaitEvent blocks the current task until done reports true or timeoutNs
+// have passed, and returns the final done(). Whoever makes done true (an
+// interrupt handler, typically) must scheduler.Wake the task that was
+// current when it started waiting; a wake-up that lands before the task
+// blocks is not lost because done is checked with interrupts off.
+func WaitEvent(done func() bool, timeoutNs uint64) bool {
+	flags := irqSave()
+	deadline := Nanotime() + timeoutNs
+	id := scheduler.CurrentTaskID()
+	for !done() {
+		now := Nanotime()
+		if now >= deadline {
+			irqRestore(flags)
+			return false
+		}
+		if id < 0 {
+			waitForInterrupt()
+			continue
+		}
+		timer, ok := AddTimer(nanosToTicks(deadline-now), wakeSleeper, uintptr(id))
+		if !ok {
+			waitForInterrupt()
+			continue
+		}
+		scheduler.Block()
+		for scheduler.CurrentWaiting() {
+			waitForInterrupt()
+		}
+		CancelTimer(timer)
+	}
+	irqRestore(flags)
+	return true
+}
+
+// waitTicks is the fallback when no task or timer slot is available: halt
+// between interrupts until enough ticks have gone by
+func waitTicks(ticks uint64) {
+	deadline := wheelNow + ticks
+	for wheelNow < deadline {
+		waitForInterrupt()
+	}
+}
+
+// Idle halts until the next interrupt whatever the interrupt flag, for
+// callers that poll in a loop (the network stack, from a syscall that
+// runs with interrupts masked)
+func Idle() {
+	flags := irqSave()
+	waitForInterrupt()
+	irqRestore(flags)
+}
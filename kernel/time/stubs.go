@@ -0,0 +1,33 @@
+//go:build !gccgo
+
+package time
+
+// Host builds have no TSC or PIT: the fake TSC advances by fakeTSCStep on
+// every read and the fake PIT reports its one-shot as finished immediately.
+var (
+	fakeTSC     uint64
+	fakeTSCStep uint64
+)
+
+func rdtsc() uint64 {
+	fakeTSC += fakeTSCStep
+	return fakeTSC
+}
+
+func inb(port uint16) byte {
+	if port == pitGatePort {
+		return pitOut2Bit
+	}
+	return 0
+}
+
+func outb(port uint16, value byte) {}
+
+func irqSave() uint64 { return 0 }
+
+func irqRestore(flags uint64) {}
+
+// waitForInterrupt stands in for the timer interrupt so sleeps finish
+func waitForInterrupt() {
+	Tick()
+}
@@ -0,0 +1,16 @@
+//go:build gccgo
+
+package time
+
+// Implemented in boot/stubs_amd64.s
+func rdtsc() uint64
+func inb(port uint16) byte
+func outb(port uint16, value byte)
+
+// irqSave returns RFLAGS and disables interrupts; irqRestore puts IF back
+func irqSave() uint64
+func irqRestore(flags uint64)
+
+// waitForInterrupt enables interrupts, halts until one arrives and disables
+// them again (sti; hlt; cli)
+func waitForInterrupt()
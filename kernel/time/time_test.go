@@ -0,0 +1,263 @@
+package time
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/dmarro89/go-dav-os/kernel/scheduler"
+)
+
+func TestCyclesToNanos(t *testing.T) {
+	tests := []struct {
+		name   string
+		cycles uint64
+		hz     uint64
+		want   uint64
+	}{
+		{"zero hz", 100, 0, 0},
+		{"one second at 1GHz", 1000000000, 1000000000, 1000000000},
+		{"half second at 2GHz", 1000000000, 2000000000, 500000000},
+		{"one tick at 100Hz", 1, 100, 10000000},
+		// A day of 3 GHz cycles would overflow a naive cycles*1e9
+		{"one day at 3GHz", 3000000000 * 86400, 3000000000, 86400 * NanosPerSecond},
+	}
+	for _, tt := range tests {
+		if got := cyclesToNanos(tt.cycles, tt.hz); got != tt.want {
+			t.Errorf("%s: cyclesToNanos(%d, %d) = %d, want %d", tt.name, tt.cycles, tt.hz, got, tt.want)
+		}
+	}
+}
+
+func TestNanosToTicksRoundsUpAndAddsOne(t *testing.T) {
+	tickHz = 100
+	tests := []struct {
+		ns   uint64
+		want uint64
+	}{
+		{0, 0},
+		{1, 2},
+		{10 * NanosPerMillisecond, 2},
+		{15 * NanosPerMillisecond, 3},
+		{NanosPerSecond, 101},
+	}
+	for _, tt := range tests {
+		if got := nanosToTicks(tt.ns); got != tt.want {
+			t.Errorf("nanosToTicks(%d) = %d, want %d", tt.ns, got, tt.want)
+		}
+	}
+}
+
+func TestCounterToNanos(t *testing.T) {
+	// QEMU's HPET runs at 100 MHz: a 10 ns (10^7 fs) period
+	if got := counterToNanos(100000000, 10000000); got != NanosPerSecond {
+		t.Fatalf("counterToNanos = %d, want %d", got, NanosPerSecond)
+	}
+	if got := counterToNanos(3, 69841279); got != 209 {
+		t.Fatalf("counterToNanos = %d, want 209", got)
+	}
+}
+
+func TestInitCalibratesTSCWithoutHPET(t *testing.T) {
+	fakeTSC = 0
+	fakeTSCStep = 10000000 // 10^7 cycles across the 10 ms window ~ 1 GHz
+
+	Init(0, 100)
+
+	if CurrentSource() != SourceTSC {
+		t.Fatalf("source = %v, want tsc", CurrentSource())
+	}
+	want := tscHzFromSample(fakeTSCStep, pitInputHz/calibrationWindowHz)
+	if TSCHz() != want {
+		t.Fatalf("TSCHz() = %d, want %d", TSCHz(), want)
+	}
+
+	first := Nanotime()
+	second := Nanotime()
+	if second <= first {
+		t.Fatalf("Nanotime went backwards or stalled: %d then %d", first, second)
+	}
+	fakeTSCStep = 0
+}
+
+func TestInitUsesHPETWhenPresent(t *testing.T) {
+	var regs [0x100 / 8]uint64
+	regs[hpetCapabilitiesReg/8] = uint64(10000000) << 32 // 10 ns period
+	base := uint64(uintptr(unsafe.Pointer(&regs[0])))
+
+	Init(base, 100)
+	defer func() { hpetBase = 0 }()
+
+	if CurrentSource() != SourceHPET {
+		t.Fatalf("source = %v, want hpet", CurrentSource())
+	}
+	if regs[hpetConfigReg/8]&hpetEnableBit == 0 {
+		t.Fatalf("Init should enable the HPET main counter")
+	}
+
+	regs[hpetCounterReg/8] = 250
+	if got := Nanotime(); got != 2500 {
+		t.Fatalf("Nanotime() = %d, want 2500", got)
+	}
+}
+
+func TestInitRejectsBogusHPETPeriod(t *testing.T) {
+	var regs [0x100 / 8]uint64
+	regs[hpetCapabilitiesReg/8] = uint64(hpetMaxPeriodFs+1) << 32
+	fakeTSCStep = 0
+
+	Init(uint64(uintptr(unsafe.Pointer(&regs[0]))), 100)
+
+	if CurrentSource() == SourceHPET {
+		t.Fatalf("Init should reject an out-of-spec HPET period")
+	}
+}
+
+func TestOneShotTimerFiresOnce(t *testing.T) {
+	resetWheel()
+	fired := 0
+	var gotArg uintptr
+
+	if _, ok := AddTimer(3, func(arg uintptr) { fired++; gotArg = arg }, 42); !ok {
+		t.Fatalf("AddTimer failed")
+	}
+
+	Tick()
+	Tick()
+	if fired != 0 {
+		t.Fatalf("timer fired early after 2 ticks")
+	}
+	Tick()
+	if fired != 1 || gotArg != 42 {
+		t.Fatalf("fired=%d arg=%d, want 1 and 42", fired, gotArg)
+	}
+	for i := 0; i < 2*wheelSlots; i++ {
+		Tick()
+	}
+	if fired != 1 {
+		t.Fatalf("one-shot timer fired %d times", fired)
+	}
+}
+
+func TestTimerBeyondOneWheelRevolution(t *testing.T) {
+	resetWheel()
+	fired := 0
+	AddTimer(wheelSlots+5, func(uintptr) { fired++ }, 0)
+
+	for i := 0; i < wheelSlots+4; i++ {
+		Tick()
+	}
+	if fired != 0 {
+		t.Fatalf("timer fired a revolution early")
+	}
+	Tick()
+	if fired != 1 {
+		t.Fatalf("timer did not fire after %d ticks", wheelSlots+5)
+	}
+}
+
+func TestPeriodicTimerAndCancel(t *testing.T) {
+	resetWheel()
+	fired := 0
+	id, ok := AddPeriodic(2, func(uintptr) { fired++ }, 0)
+	if !ok {
+		t.Fatalf("AddPeriodic failed")
+	}
+
+	for i := 0; i < 6; i++ {
+		Tick()
+	}
+	if fired != 3 {
+		t.Fatalf("periodic timer fired %d times in 6 ticks, want 3", fired)
+	}
+
+	if !CancelTimer(id) {
+		t.Fatalf("CancelTimer failed")
+	}
+	for i := 0; i < 6; i++ {
+		Tick()
+	}
+	if fired != 3 {
+		t.Fatalf("cancelled timer kept firing")
+	}
+	if CancelTimer(id) {
+		t.Fatalf("CancelTimer should fail on an inactive timer")
+	}
+}
+
+func TestAddTimerFailsWhenPoolExhausted(t *testing.T) {
+	resetWheel()
+	for i := 0; i < MaxTimers; i++ {
+		if _, ok := AddTimer(10, func(uintptr) {}, 0); !ok {
+			t.Fatalf("AddTimer %d failed early", i)
+		}
+	}
+	if _, ok := AddTimer(10, func(uintptr) {}, 0); ok {
+		t.Fatalf("AddTimer should fail once every slot is used")
+	}
+	if _, ok := AddTimer(10, nil, 0); ok {
+		t.Fatalf("AddTimer should reject a nil callback")
+	}
+}
+
+func TestSleepBlocksCurrentTaskUntilTimer(t *testing.T) {
+	scheduler.Init()
+	Init(0, 100)
+
+	start := Ticks()
+	Sleep(30)
+
+	if elapsed := Ticks() - start; elapsed < 3 {
+		t.Fatalf("Sleep(30) returned after %d ticks, want at least 3", elapsed)
+	}
+	if scheduler.CurrentWaiting() {
+		t.Fatalf("task should be running again after Sleep")
+	}
+}
+
+func TestStaleTimerIDCannotCancelReusedSlot(t *testing.T) {
+	resetWheel()
+	first, _ := AddTimer(1, func(uintptr) {}, 0)
+	Tick()
+
+	fired := 0
+	second, _ := AddTimer(2, func(uintptr) { fired++ }, 0)
+	if first == second {
+		t.Fatalf("reused slot handed out the same ID %d", first)
+	}
+	if CancelTimer(first) {
+		t.Fatalf("CancelTimer accepted the ID of a timer that already fired")
+	}
+	Tick()
+	Tick()
+	if fired != 1 {
+		t.Fatalf("second timer fired %d times, want 1", fired)
+	}
+}
+
+var eventDone bool
+
+func eventHappened() bool { return eventDone }
+
+func TestWaitEventReturnsOnEventOrTimeout(t *testing.T) {
+	scheduler.Init()
+	Init(0, 100)
+	resetWheel()
+
+	// Nothing ever sets the flag: WaitEvent gives up once the deadline passes
+	eventDone = false
+	start := Ticks()
+	if WaitEvent(eventHappened, 30*NanosPerMillisecond) {
+		t.Fatalf("WaitEvent reported an event that never happened")
+	}
+	if Ticks()-start < 3 {
+		t.Fatalf("WaitEvent timed out after %d ticks, want at least 3", Ticks()-start)
+	}
+	if scheduler.CurrentWaiting() {
+		t.Fatalf("task still waiting after WaitEvent timed out")
+	}
+
+	eventDone = true
+	if !WaitEvent(eventHappened, NanosPerSecond) {
+		t.Fatalf("WaitEvent missed an event that already happened")
+	}
+}
@@ -0,0 +1,208 @@
+package time
+
+import "github.com/dmarro89/go-dav-os/kernel/spinlock"
+
+const (
+	wheelSlots = 64
+	MaxTimers  = 32
+
+	// A TimerID is the slot index+1 in the low bits and the slot's arming
+	// generation above, so a stale ID cannot cancel the slot's next timer
+	timerIDShift     = 8
+	timerIDIndexMask = 1<<timerIDShift - 1
+)
+
+// TimerFunc runs in interrupt context when a timer expires: it must not
+// block or print at length
+type TimerFunc func(arg uintptr)
+
+// TimerID identifies an armed timer; 0 is never a valid ID
+type TimerID int
+
+type timer struct {
+	gen     int
+	active  bool
+	expires uint64
+	period  uint64
+	fn      TimerFunc
+	arg     uintptr
+	// next links timers hashed into the same slot (index+1, 0 ends the list)
+	next int
+}
+
+// firedTimer is a callback collected under the wheel lock and run after it
+// is released, so callbacks may arm or cancel timers themselves
+type firedTimer struct {
+	fn  TimerFunc
+	arg uintptr
+}
+
+var (
+	// wheelLock guards timers and wheel; any CPU may arm a timer while the
+	// BSP advances the wheel from IRQ0
+	wheelLock spinlock.Lock
+
+	timers [MaxTimers]timer
+	// wheel holds the first timer (index+1) of each slot
+	wheel    [wheelSlots]int
+	wheelNow uint64
+)
+
+func resetWheel() {
+	for i := 0; i < MaxTimers; i++ {
+		t := &timers[i]
+		t.gen = 0
+		t.active = false
+		t.expires = 0
+		t.period = 0
+		t.fn = nil
+		t.arg = 0
+		t.next = 0
+	}
+	for i := 0; i < wheelSlots; i++ {
+		wheel[i] = 0
+	}
+	wheelNow = 0
+}
+
+// AddTimer arms a one-shot timer that calls fn(arg) after delay ticks
+func AddTimer(delay uint64, fn TimerFunc, arg uintptr) (TimerID, bool) {
+	return startTimer(delay, 0, fn, arg)
+}
+
+// AddPeriodic arms a timer that calls fn(arg) every period ticks until it is
+// cancelled
+func AddPeriodic(period uint64, fn TimerFunc, arg uintptr) (TimerID, bool) {
+	if period == 0 {
+		return 0, false
+	}
+	return startTimer(period, period, fn, arg)
+}
+
+// CancelTimer disarms a timer. Returns false if it already fired or never
+// existed.
+func CancelTimer(id TimerID) bool {
+	idx := int(id)&timerIDIndexMask - 1
+	if idx < 0 || idx >= MaxTimers {
+		return false
+	}
+	flags := wheelLock.AcquireIRQ()
+	if !timers[idx].active || timers[idx].gen != int(id)>>timerIDShift {
+		wheelLock.ReleaseIRQ(flags)
+		return false
+	}
+	unlink(idx)
+	timers[idx].active = false
+	wheelLock.ReleaseIRQ(flags)
+	return true
+}
+
+// Ticks returns the number of Tick calls since Init
+func Ticks() uint64 { return wheelNow }
+
+// Tick advances the wheel by one tick and fires every timer that expired.
+// Called from the timer interrupt.
+func Tick() {
+	var fired [MaxTimers]firedTimer
+	nfired := 0
+
+	flags := wheelLock.AcquireIRQ()
+	wheelNow++
+	slot := int(wheelNow % wheelSlots)
+
+	// Detach due timers first so callbacks can safely re-arm into this slot
+	due := 0
+	prev := 0
+	cur := wheel[slot]
+	for cur != 0 {
+		idx := cur - 1
+		next := timers[idx].next
+		if timers[idx].expires <= wheelNow {
+			if prev == 0 {
+				wheel[slot] = next
+			} else {
+				timers[prev-1].next = next
+			}
+			timers[idx].next = due
+			due = cur
+		} else {
+			prev = cur
+		}
+		cur = next
+	}
+
+	for due != 0 {
+		idx := due - 1
+		due = timers[idx].next
+		t := &timers[idx]
+		t.next = 0
+
+		fired[nfired].fn = t.fn
+		fired[nfired].arg = t.arg
+		nfired++
+		if t.period != 0 {
+			t.expires = wheelNow + t.period
+			link(idx)
+		} else {
+			t.active = false
+		}
+	}
+	wheelLock.ReleaseIRQ(flags)
+
+	for i := 0; i < nfired; i++ {
+		fired[i].fn(fired[i].arg)
+	}
+}
+
+func startTimer(delay, period uint64, fn TimerFunc, arg uintptr) (TimerID, bool) {
+	if fn == nil {
+		return 0, false
+	}
+	if delay == 0 {
+		delay = 1
+	}
+	flags := wheelLock.AcquireIRQ()
+	for i := 0; i < MaxTimers; i++ {
+		if timers[i].active {
+			continue
+		}
+		t := &timers[i]
+		t.gen++
+		t.active = true
+		t.expires = wheelNow + delay
+		t.period = period
+		t.fn = fn
+		t.arg = arg
+		t.next = 0
+		link(i)
+		wheelLock.ReleaseIRQ(flags)
+		return TimerID(t.gen<<timerIDShift | (i + 1)), true
+	}
+	wheelLock.ReleaseIRQ(flags)
+	return 0, false
+}
+
+func link(idx int) {
+	slot := int(timers[idx].expires % wheelSlots)
+	timers[idx].next = wheel[slot]
+	wheel[slot] = idx + 1
+}
+
+func unlink(idx int) {
+	slot := int(timers[idx].expires % wheelSlots)
+	prev := 0
+	cur := wheel[slot]
+	for cur != 0 {
+		if cur == idx+1 {
+			if prev == 0 {
+				wheel[slot] = timers[idx].next
+			} else {
+				timers[prev-1].next = timers[idx].next
+			}
+			timers[idx].next = 0
+			return
+		}
+		prev = cur
+		cur = timers[cur-1].next
+	}
+}
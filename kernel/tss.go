@@ -5,34 +5,44 @@ package kernel
 import (
 	"unsafe"
 
+	"github.com/dmarro89/go-dav-os/kernel/percpu"
 	tsslib "github.com/dmarro89/go-dav-os/kernel/tss"
 )
 
 const kernelTrapStackSize = 4096
 
 var (
-	cpuTSS    [tsslib.TSSSize]byte
-	trapStack [kernelTrapStackSize]byte
+	cpuTSS    [percpu.MaxCPUs][tsslib.TSSSize]byte
+	trapStack [percpu.MaxCPUs][kernelTrapStackSize]byte
 )
 
 func LoadTR(sel uint16)
 
+// InitGDTAndTSS loads the descriptor tables of the bootstrap CPU
 func InitGDTAndTSS() {
-	initGDT()
+	initCPUTables(0)
+}
+
+// initCPUTables builds and loads the GDT and TSS of CPU slot cpu on the
+// calling processor
+func initCPUTables(cpu int) {
+	initGDT(cpu)
 
-	tsslib.SetIomapBase(&cpuTSS, tsslib.TSSSize)
-	SetKernelRSP0(defaultKernelTrapStackTop())
-	setTSSDescriptor(uintptr(unsafe.Pointer(&cpuTSS[0])), tsslib.TSSSize-1)
+	tss := &cpuTSS[cpu]
+	tsslib.SetIomapBase(tss, tsslib.TSSSize)
+	tsslib.SetRSP0(tss, defaultKernelTrapStackTop(cpu))
+	setTSSDescriptor(cpu, uintptr(unsafe.Pointer(&tss[0])), tsslib.TSSSize-1)
 
-	loadGDT()
+	loadGDT(cpu)
 	LoadTR(tssSelector)
 }
 
+// SetKernelRSP0 sets the ring 0 stack of the calling CPU's TSS
 func SetKernelRSP0(rsp0 uint64) {
-	tsslib.SetRSP0(&cpuTSS, rsp0)
+	tsslib.SetRSP0(&cpuTSS[percpu.Index()], rsp0)
 }
 
-func defaultKernelTrapStackTop() uint64 {
-	top := uintptr(unsafe.Pointer(&trapStack[0])) + uintptr(len(trapStack))
+func defaultKernelTrapStackTop(cpu int) uint64 {
+	top := uintptr(unsafe.Pointer(&trapStack[cpu][0])) + uintptr(len(trapStack[cpu]))
 	return uint64(top &^ uintptr(0xF))
 }
@@ -0,0 +1,342 @@
+package keyboard
+
+// Keycode names a physical key independently of the layout: the set 1 make
+// code, with the top bit set for keys sent behind an 0xE0 prefix
+type Keycode uint8
+
+const (
+	KeyEscape     Keycode = 0x01
+	KeyBackspace  Keycode = 0x0E
+	KeyTab        Keycode = 0x0F
+	KeyEnter      Keycode = 0x1C
+	KeyLeftCtrl   Keycode = 0x1D
+	KeyLeftShift  Keycode = 0x2A
+	KeyRightShift Keycode = 0x36
+	KeyKPStar     Keycode = 0x37
+	KeyLeftAlt    Keycode = 0x38
+	KeySpace      Keycode = 0x39
+	KeyCapsLock   Keycode = 0x3A
+	KeyF1         Keycode = 0x3B
+	KeyF2         Keycode = 0x3C
+	KeyF3         Keycode = 0x3D
+	KeyF4         Keycode = 0x3E
+	KeyF5         Keycode = 0x3F
+	KeyF6         Keycode = 0x40
+	KeyF7         Keycode = 0x41
+	KeyF8         Keycode = 0x42
+	KeyF9         Keycode = 0x43
+	KeyF10        Keycode = 0x44
+	KeyNumLock    Keycode = 0x45
+	KeyScrollLock Keycode = 0x46
+	KeyKP7        Keycode = 0x47
+	KeyKP8        Keycode = 0x48
+	KeyKP9        Keycode = 0x49
+	KeyKPMinus    Keycode = 0x4A
+	KeyKP4        Keycode = 0x4B
+	KeyKP5        Keycode = 0x4C
+	KeyKP6        Keycode = 0x4D
+	KeyKPPlus     Keycode = 0x4E
+	KeyKP1        Keycode = 0x4F
+	KeyKP2        Keycode = 0x50
+	KeyKP3        Keycode = 0x51
+	KeyKP0        Keycode = 0x52
+	KeyKPDot      Keycode = 0x53
+	KeyF11        Keycode = 0x57
+	KeyF12        Keycode = 0x58
+
+	KeyKPEnter   Keycode = 0x9C
+	KeyRightCtrl Keycode = 0x9D
+	KeyKPSlash   Keycode = 0xB5
+	KeyRightAlt  Keycode = 0xB8
+	KeyHome      Keycode = 0xC7
+	KeyUp        Keycode = 0xC8
+	KeyPageUp    Keycode = 0xC9
+	KeyLeft      Keycode = 0xCB
+	KeyRight     Keycode = 0xCD
+	KeyEnd       Keycode = 0xCF
+	KeyDown      Keycode = 0xD0
+	KeyPageDown  Keycode = 0xD1
+	KeyInsert    Keycode = 0xD2
+	KeyDelete    Keycode = 0xD3
+)
+
+// Modifier bits of Event.Mods: the held modifiers and the lock states
+const (
+	ModShift = 1 << iota
+	ModCtrl
+	ModAlt
+	ModAltGr
+	ModCapsLock
+	ModNumLock
+	ModScrollLock
+)
+
+// Event is one key press or release. Rune is the character the press
+// types, 0 for keys without one and on release; with Ctrl held a letter
+// types its control character, so Ctrl-C gives 0x03.
+type Event struct {
+	Key     Keycode
+	Rune    rune
+	Mods    uint8
+	Pressed bool
+}
+
+const (
+	scExtended = 0xE0
+	scPause    = 0xE1 // E1 1D 45 E1 9D C5, with no release
+	scAck      = 0xFA
+	scResend   = 0xFE
+	scRelease  = 0x80
+
+	pauseLength = 5
+
+	cmdSetLEDs = 0xED
+
+	ledScrollLock = 0x01
+	ledNumLock    = 0x02
+	ledCapsLock   = 0x04
+
+	statusInputBuffer = 2 // bit 1 => the controller has not taken the last write
+	writeSpinLimit    = 100000
+)
+
+var (
+	leftCtrlDown  bool
+	rightCtrlDown bool
+	leftAltDown   bool
+	rightAltDown  bool
+	numLockOn     bool
+	scrollLockOn  bool
+
+	extended  bool // the last byte was the 0xE0 prefix
+	pauseSkip int  // bytes of the Pause sequence still to swallow
+
+	// ledsPending holds the LED mask while the keyboard has yet to
+	// acknowledge the 0xED command that precedes it
+	ledsPending bool
+	ledMask     byte
+)
+
+// decodeScancode consumes one byte of the set 1 stream and reports the key
+// event it completes. Acknowledgements of LED commands are handled here.
+func decodeScancode(sc byte) (Event, bool) {
+	if pauseSkip > 0 {
+		pauseSkip--
+		return Event{}, false
+	}
+	switch sc {
+	case scExtended:
+		extended = true
+		return Event{}, false
+	case scPause:
+		pauseSkip = pauseLength
+		return Event{}, false
+	case scAck:
+		if ledsPending {
+			ledsPending = false
+			writeData(ledMask)
+		}
+		return Event{}, false
+	case scResend:
+		return Event{}, false
+	}
+
+	ext := extended
+	extended = false
+	code := sc &^ scRelease
+	pressed := sc&scRelease == 0
+	// Extended shift codes are fake presses that wrap PrintScreen and
+	// the navigation keys while Shift or NumLock is active
+	if ext && (code == byte(KeyLeftShift) || code == byte(KeyRightShift)) {
+		return Event{}, false
+	}
+
+	key := Keycode(code)
+	if ext {
+		key |= scRelease
+	} else if code >= byte(KeyKP7) && code <= byte(KeyKPDot) && !numLockOn && isKeypadNavigation(key) {
+		// Without NumLock the keypad doubles as the navigation block
+		key |= scRelease
+	}
+
+	ev := Event{Key: key, Pressed: pressed}
+	if !updateModifiers(key, pressed) && pressed {
+		ev.Rune = keyRune(key)
+	}
+	ev.Mods = modifiers()
+	return ev, true
+}
+
+func isKeypadNavigation(key Keycode) bool {
+	return key != KeyKPMinus && key != KeyKP5 && key != KeyKPPlus
+}
+
+// updateModifiers tracks the modifier and lock keys, reporting whether key
+// is one of them. The locks toggle on press and update the LEDs.
+func updateModifiers(key Keycode, pressed bool) bool {
+	switch key {
+	case KeyLeftShift:
+		leftShiftDown = pressed
+	case KeyRightShift:
+		rightShiftDown = pressed
+	case KeyLeftCtrl:
+		leftCtrlDown = pressed
+	case KeyRightCtrl:
+		rightCtrlDown = pressed
+	case KeyLeftAlt:
+		leftAltDown = pressed
+	case KeyRightAlt:
+		rightAltDown = pressed
+	case KeyCapsLock:
+		if pressed {
+			capsLockOn = !capsLockOn
+			SetLEDs()
+		}
+	case KeyNumLock:
+		if pressed {
+			numLockOn = !numLockOn
+			SetLEDs()
+		}
+	case KeyScrollLock:
+		if pressed {
+			scrollLockOn = !scrollLockOn
+			SetLEDs()
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func modifiers() uint8 {
+	var m uint8
+	if shiftActive() {
+		m |= ModShift
+	}
+	if leftCtrlDown || rightCtrlDown {
+		m |= ModCtrl
+	}
+	if leftAltDown {
+		m |= ModAlt
+	}
+	if rightAltDown {
+		m |= ModAltGr
+	}
+	if capsLockOn {
+		m |= ModCapsLock
+	}
+	if numLockOn {
+		m |= ModNumLock
+	}
+	if scrollLockOn {
+		m |= ModScrollLock
+	}
+	return m
+}
+
+// keyRune is the character a pressed key types under the current layout
+// and modifiers, 0 when it types none
+func keyRune(key Keycode) rune {
+	switch key {
+	case KeyEscape:
+		return 0x1B
+	case KeyTab:
+		return '\t'
+	case KeyKPEnter:
+		return '\n'
+	case KeyKPSlash:
+		return '/'
+	case KeyKPStar:
+		return '*'
+	case KeyKPMinus:
+		return '-'
+	case KeyKPPlus:
+		return '+'
+	case KeyKPDot:
+		return '.'
+	case KeyKP0:
+		return '0'
+	}
+	if key >= KeyKP7 && key <= KeyKP3 {
+		if !numLockOn {
+			return 0
+		}
+		return keypadDigits[key-KeyKP7]
+	}
+	if key&scRelease != 0 || currentLayout == nil {
+		return 0
+	}
+
+	r, ok := layoutRune(byte(key))
+	if !ok {
+		return 0
+	}
+	if leftCtrlDown || rightCtrlDown {
+		deadAccent = 0
+		if isASCIILetter(r) {
+			return toLowerASCII(r) - 'a' + 1
+		}
+		return 0
+	}
+	return composeRune(r)
+}
+
+// composeRune applies a pending dead key to r. A dead key types nothing
+// and waits; the next key gives the composed letter, or the accent itself
+// for Space or a second dead key. A letter the accent does not combine
+// with is typed plain.
+func composeRune(r rune) rune {
+	accent := deadAccent
+	if r&DeadKey != 0 {
+		if accent == 0 {
+			deadAccent = r &^ DeadKey
+			return 0
+		}
+		deadAccent = 0
+		return accent
+	}
+	if accent == 0 {
+		return r
+	}
+	deadAccent = 0
+	if r == ' ' {
+		return accent
+	}
+	if c, ok := currentLayout.Compose(accent, r); ok {
+		return c
+	}
+	return r
+}
+
+// keypadDigits maps KeyKP7..KeyKP3; the operators in between never reach it
+var keypadDigits = [...]rune{'7', '8', '9', 0, '4', '5', '6', 0, '1', '2', '3'}
+
+// SetLEDs sends the lock states to the keyboard LEDs. The mask follows
+// once the keyboard acknowledges the 0xED command, which arrives on IRQ 1.
+func SetLEDs() {
+	var mask byte
+	if scrollLockOn {
+		mask |= ledScrollLock
+	}
+	if numLockOn {
+		mask |= ledNumLock
+	}
+	if capsLockOn {
+		mask |= ledCapsLock
+	}
+	ledMask = mask
+	if writeData(cmdSetLEDs) {
+		ledsPending = true
+	}
+}
+
+// writeData sends a byte to the keyboard once the controller can take it
+func writeData(b byte) bool {
+	for i := 0; i < writeSpinLimit; i++ {
+		if inb(portStatus)&statusInputBuffer == 0 {
+			outb(portData, b)
+			return true
+		}
+	}
+	return false
+}
@@ -4,17 +4,17 @@ package keyboard
 
 const bufSize = 256
 
-var buf [bufSize]rune
+var buf [bufSize]Event
 var head uint32
 var tail uint32
 
-func push(r rune) {
+func push(ev Event) {
 	next := (head + 1) & (bufSize - 1)
 	if next == tail {
-		// buffer full -> drop char
+		// buffer full -> drop event
 		return
 	}
-	buf[head] = r
+	buf[head] = ev
 	head = next
 }
 
@@ -22,17 +22,31 @@ func push(r rune) {
 func IRQHandler() {
 	sc := inb(0x60)
 
-	if r, ok := translateScancode(sc); ok {
-		push(r)
+	if ev, ok := decodeScancode(sc); ok {
+		push(ev)
 	}
 }
 
-// Non-blocking read used by the shell loop.
-func TryRead() (rune, bool) {
+// TryReadEvent pops the next key press or release, non-blocking
+func TryReadEvent() (Event, bool) {
 	if tail == head {
-		return 0, false
+		return Event{}, false
 	}
-	r := buf[tail]
+	ev := buf[tail]
 	tail = (tail + 1) & (bufSize - 1)
-	return r, true
+	return ev, true
+}
+
+// Non-blocking read used by the shell loop. Events that type no character
+// are skipped.
+func TryRead() (rune, bool) {
+	for {
+		ev, ok := TryReadEvent()
+		if !ok {
+			return 0, false
+		}
+		if ev.Pressed && ev.Rune != 0 {
+			return ev.Rune, true
+		}
+	}
 }
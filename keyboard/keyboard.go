@@ -21,10 +21,19 @@ var (
 	capsLockOn     bool
 )
 
+// DeadKey marks a layout rune as a dead key: the accent in the low bits
+// combines with the next letter instead of being typed
+const DeadKey rune = 1 << 30
+
 var currentLayout Layout
 
+// deadAccent is the accent of a dead key waiting for the next key, 0 when
+// none is
+var deadAccent rune
+
 func SetLayout(l Layout) {
 	currentLayout = l
+	deadAccent = 0
 }
 
 func readScancode() byte {
@@ -36,21 +45,41 @@ func readScancode() byte {
 	}
 }
 
+// translateScancode feeds one scancode byte to the decoder and returns the
+// character typed by a completed key press, if any
 func translateScancode(sc byte) (rune, bool) {
-	if handleModifier(sc) {
-		return 0, false
-	}
-
-	// Ignore key releases for non-modifier keys
-	if sc&0x80 != 0 {
+	ev, ok := decodeScancode(sc)
+	if !ok || !ev.Pressed || ev.Rune == 0 {
 		return 0, false
 	}
+	return ev.Rune, true
+}
 
+// layoutRune looks a make code up in the current layout and applies
+// AltGr, Shift and CapsLock to the result
+func layoutRune(sc byte) (rune, bool) {
 	r, valid := currentLayout.GetKey(sc)
 	if !valid {
 		return 0, false
 	}
 
+	if rightAltDown {
+		if alt, ok := currentLayout.GetAltGrKey(sc); ok {
+			return alt, true
+		}
+	}
+	if shifted, ok := currentLayout.GetShiftKey(sc); ok {
+		// CapsLock only flips keys whose Shift rune is their capital
+		shift := shiftActive()
+		if capsLockOn && isCasePair(r, shifted) {
+			shift = !shift
+		}
+		if shift {
+			return shifted, true
+		}
+		return r, true
+	}
+
 	if isASCIILetter(r) {
 		if shiftActive() != capsLockOn { // XOR between shift and caps
 			r = toUpperASCII(r)
@@ -69,28 +98,6 @@ func translateScancode(sc byte) (rune, bool) {
 	return r, true
 }
 
-func handleModifier(sc byte) bool {
-	switch sc {
-	case scLeftShiftDown:
-		leftShiftDown = true
-		return true
-	case scLeftShiftUp:
-		leftShiftDown = false
-		return true
-	case scRightShiftDown:
-		rightShiftDown = true
-		return true
-	case scRightShiftUp:
-		rightShiftDown = false
-		return true
-	case scCapsLockDown:
-		capsLockOn = !capsLockOn
-		return true
-	default:
-		return false
-	}
-}
-
 func shiftActive() bool {
 	return leftShiftDown || rightShiftDown
 }
@@ -108,6 +115,15 @@ func isASCIILetter(r rune) bool {
 	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
 }
 
+// isCasePair reports whether upper is the capital of lower, for ASCII and
+// the Latin-1 letters
+func isCasePair(lower, upper rune) bool {
+	if lower >= 'a' && lower <= 'z' {
+		return upper == lower-'a'+'A'
+	}
+	return lower >= 0xE0 && lower <= 0xFE && lower != 0xF7 && upper == lower-0x20
+}
+
 func isASCIIDigit(r rune) bool {
 	return r >= '0' && r <= '9'
 }
@@ -24,13 +24,34 @@ func (testLayout) GetShiftDigitSymbol(r rune) (rune, bool) {
 	return 0, false
 }
 
+func (testLayout) GetShiftKey(sc byte) (rune, bool)    { return 0, false }
+func (testLayout) GetAltGrKey(sc byte) (rune, bool)    { return 0, false }
+func (testLayout) Compose(accent, r rune) (rune, bool) { return 0, false }
+
 func resetKeyboardState() {
 	leftShiftDown = false
 	rightShiftDown = false
 	capsLockOn = false
+	leftCtrlDown, rightCtrlDown = false, false
+	leftAltDown, rightAltDown = false, false
+	numLockOn, scrollLockOn = false, false
+	extended, pauseSkip = false, 0
+	ledsPending, ledMask = false, 0
+	sentForTesting = nil
 	SetLayout(testLayout{})
 }
 
+// decodeAll feeds a scancode sequence and returns the events it produced
+func decodeAll(scs ...byte) []Event {
+	var evs []Event
+	for _, sc := range scs {
+		if ev, ok := decodeScancode(sc); ok {
+			evs = append(evs, ev)
+		}
+	}
+	return evs
+}
+
 func TestTranslateScancodeLetterModifiers(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -96,6 +117,67 @@ func TestTranslateScancodeShiftedDigitUsesLayoutSymbol(t *testing.T) {
 	}
 }
 
+// layerLayout has whole Shift and AltGr layers, as keymaps do: 0x1A is a
+// letter with its capital, 0x03 a digit whose AltGr rune is a symbol
+type layerLayout struct{ testLayout }
+
+func (layerLayout) GetKey(sc byte) (rune, bool) {
+	switch sc {
+	case 0x1A:
+		return 'ü', true
+	case 0x03:
+		return 'é', true
+	}
+	return testLayout{}.GetKey(sc)
+}
+
+func (layerLayout) GetShiftKey(sc byte) (rune, bool) {
+	switch sc {
+	case 0x1A:
+		return 'Ü', true
+	case 0x03:
+		return '2', true
+	}
+	return 0, false
+}
+
+func (layerLayout) GetAltGrKey(sc byte) (rune, bool) {
+	if sc == 0x03 {
+		return '~', true
+	}
+	return 0, false
+}
+
+func TestLayoutRuneUsesShiftAndAltGrLayers(t *testing.T) {
+	resetKeyboardState()
+	SetLayout(layerLayout{})
+
+	type step struct {
+		scs  []byte
+		want rune
+	}
+	for i, s := range []step{
+		{[]byte{0x1A}, 'ü'},
+		{[]byte{scLeftShiftDown, 0x1A, scLeftShiftUp}, 'Ü'},
+		{[]byte{scLeftShiftDown, 0x03, scLeftShiftUp}, '2'},
+		{[]byte{0xE0, 0x38, 0x03, 0xE0, 0xB8}, '~'},
+		{[]byte{0xE0, 0x38, 0x1E, 0xE0, 0xB8}, 'a'},
+		{[]byte{scCapsLockDown, 0x1A}, 'Ü'},
+		{[]byte{0x03}, 'é'},
+		{[]byte{scLeftShiftDown, 0x1A, scLeftShiftUp}, 'ü'},
+	} {
+		var got rune
+		for _, ev := range decodeAll(s.scs...) {
+			if ev.Rune != 0 {
+				got = ev.Rune
+			}
+		}
+		if got != s.want {
+			t.Fatalf("step %d: typed %q, want %q", i, got, s.want)
+		}
+	}
+}
+
 func TestTranslateScancodeShiftReleaseClearsModifier(t *testing.T) {
 	resetKeyboardState()
 
@@ -141,3 +223,119 @@ func TestTranslateScancodeIgnoresKeyReleasesAndUnmappedKeys(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeExtendedNavigationKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		scs  []byte
+		want Keycode
+	}{
+		{"up", []byte{0xE0, 0x48}, KeyUp},
+		{"down", []byte{0xE0, 0x50}, KeyDown},
+		{"left", []byte{0xE0, 0x4B}, KeyLeft},
+		{"right", []byte{0xE0, 0x4D}, KeyRight},
+		{"home", []byte{0xE0, 0x47}, KeyHome},
+		{"end", []byte{0xE0, 0x4F}, KeyEnd},
+		{"delete", []byte{0xE0, 0x53}, KeyDelete},
+		{"f1", []byte{0x3B}, KeyF1},
+		{"f12", []byte{0x58}, KeyF12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetKeyboardState()
+			evs := decodeAll(tt.scs...)
+			if len(evs) != 1 || evs[0].Key != tt.want || !evs[0].Pressed || evs[0].Rune != 0 {
+				t.Fatalf("events = %+v, want a press of %#x", evs, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeReportsReleases(t *testing.T) {
+	resetKeyboardState()
+
+	evs := decodeAll(0x1E, 0x9E, 0xE0, 0x48, 0xE0, 0xC8)
+	if len(evs) != 4 {
+		t.Fatalf("got %d events, want 4", len(evs))
+	}
+	if evs[0].Rune != 'a' || !evs[0].Pressed || evs[1].Pressed || evs[1].Rune != 0 {
+		t.Fatalf("letter press/release = %+v, %+v", evs[0], evs[1])
+	}
+	if evs[3].Key != KeyUp || evs[3].Pressed {
+		t.Fatalf("arrow release = %+v", evs[3])
+	}
+}
+
+func TestDecodeCtrlLetterGivesControlCharacter(t *testing.T) {
+	resetKeyboardState()
+
+	evs := decodeAll(0x1D, 0x1E)
+	if len(evs) != 2 || evs[1].Rune != 0x01 || evs[1].Mods&ModCtrl == 0 {
+		t.Fatalf("ctrl-a events = %+v", evs)
+	}
+
+	// The right Ctrl comes behind the 0xE0 prefix
+	evs = decodeAll(0x9D, 0xE0, 0x1D, 0x1E, 0xE0, 0x9D, 0x1E)
+	if len(evs) != 5 || evs[1].Key != KeyRightCtrl || evs[2].Rune != 0x01 || evs[4].Rune != 'a' {
+		t.Fatalf("right ctrl events = %+v", evs)
+	}
+}
+
+func TestDecodeAltModifiers(t *testing.T) {
+	resetKeyboardState()
+
+	evs := decodeAll(0x38, 0x3B, 0xE0, 0x38)
+	if evs[1].Key != KeyF1 || evs[1].Mods&ModAlt == 0 || evs[1].Mods&ModAltGr != 0 {
+		t.Fatalf("alt-f1 = %+v", evs[1])
+	}
+	if evs[2].Key != KeyRightAlt || evs[2].Mods&ModAltGr == 0 {
+		t.Fatalf("altgr = %+v", evs[2])
+	}
+}
+
+func TestDecodeKeypadFollowsNumLock(t *testing.T) {
+	resetKeyboardState()
+
+	evs := decodeAll(0x48)
+	if evs[0].Key != KeyUp || evs[0].Rune != 0 {
+		t.Fatalf("keypad 8 without NumLock = %+v, want up", evs[0])
+	}
+
+	evs = decodeAll(0x45, 0xC5, 0x48, 0x4E)
+	if evs[2].Key != KeyKP8 || evs[2].Rune != '8' || evs[3].Rune != '+' || evs[2].Mods&ModNumLock == 0 {
+		t.Fatalf("keypad with NumLock = %+v", evs)
+	}
+}
+
+func TestDecodeSkipsFakeShiftsAndPause(t *testing.T) {
+	resetKeyboardState()
+
+	// PrintScreen, then Pause, then a plain key
+	evs := decodeAll(0xE0, 0x2A, 0xE0, 0x37, 0xE0, 0xB7, 0xE0, 0xAA, 0xE1, 0x1D, 0x45, 0xE1, 0x9D, 0xC5, 0x1E)
+	last := evs[len(evs)-1]
+	if last.Rune != 'a' || shiftActive() {
+		t.Fatalf("events = %+v", evs)
+	}
+	for _, ev := range evs {
+		if ev.Key == KeyLeftShift {
+			t.Fatalf("a fake shift leaked: %+v", evs)
+		}
+	}
+}
+
+func TestLockKeysUpdateLEDs(t *testing.T) {
+	resetKeyboardState()
+
+	decodeAll(0x3A)
+	if string(sentForTesting) != "\xED" {
+		t.Fatalf("sent % x, want the LED command", sentForTesting)
+	}
+	// The mask goes out once the keyboard acknowledges
+	decodeAll(0xFA)
+	decodeAll(0x45, 0xFA, 0xFA)
+	want := []byte{0xED, ledCapsLock, 0xED, ledCapsLock | ledNumLock}
+	if string(sentForTesting) != string(want) {
+		t.Fatalf("sent % x, want % x", sentForTesting, want)
+	}
+}
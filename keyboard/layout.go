@@ -5,4 +5,10 @@ package keyboard
 type Layout interface {
 	GetKey(byte) (rune, bool)
 	GetShiftDigitSymbol(r rune) (rune, bool)
+	// GetShiftKey and GetAltGrKey give the rune of a whole Shift or AltGr
+	// layer; a layout without one reports false and the base rune is used
+	GetShiftKey(byte) (rune, bool)
+	GetAltGrKey(byte) (rune, bool)
+	// Compose is what the accent of a dead key makes of the next rune
+	Compose(accent, r rune) (rune, bool)
 }
@@ -3,7 +3,9 @@ package layout
 import "github.com/dmarro89/go-dav-os/keyboard"
 
 type ITLayout struct {
-	keys [128]rune
+	keys  [128]rune
+	shift [128]rune
+	altGr [128]rune
 }
 
 const ITLayoutName string = "it"
@@ -50,10 +52,46 @@ var itLayoutData = ITLayout{
 		0x31: 'n',
 		0x32: 'm',
 
+		0x0C: '\'',
+		0x0D: 'ì',
+		0x1A: 'è',
+		0x1B: '+',
+		0x27: 'ò',
+		0x28: 'à',
+		0x29: '\\',
+		0x2B: 'ù',
+		0x33: ',',
+		0x34: '.',
+		0x35: '-',
+		0x56: '<',
+
 		0x39: ' ',
 		0x1C: '\n',
 		0x0E: '\b',
 	},
+	// The punctuation and accented keys; letters and digits keep the
+	// capitals and GetShiftDigitSymbol
+	shift: [128]rune{
+		0x0C: '?',
+		0x0D: '^',
+		0x1A: 'é',
+		0x1B: '*',
+		0x27: 'ç',
+		0x28: '°',
+		0x29: '|',
+		0x2B: '§',
+		0x33: ';',
+		0x34: ':',
+		0x35: '_',
+		0x56: '>',
+	},
+	altGr: [128]rune{
+		0x12: '€',
+		0x1A: '[',
+		0x1B: ']',
+		0x27: '@',
+		0x28: '#',
+	},
 }
 
 func GetIT() (keyboard.Layout, string) {
@@ -90,3 +128,15 @@ func (l *ITLayout) GetShiftDigitSymbol(r rune) (rune, bool) {
 		return 0, false
 	}
 }
+
+func (l *ITLayout) GetShiftKey(sc byte) (rune, bool) {
+	return lookupKey(&l.shift, sc)
+}
+
+func (l *ITLayout) GetAltGrKey(sc byte) (rune, bool) {
+	return lookupKey(&l.altGr, sc)
+}
+
+func (*ITLayout) Compose(accent, r rune) (rune, bool) {
+	return 0, false
+}
@@ -0,0 +1,317 @@
+package layout
+
+import (
+	"unsafe"
+
+	"github.com/dmarro89/go-dav-os/keyboard"
+)
+
+// A keymap describes a layout as text, so layouts can ship as data and be
+// loaded from disk. Every keymap starts from the US QWERTY table in
+// qwertyKeymap and lists the keys it changes, one per line:
+//
+//	# comment
+//	<scancode> <base> [<shift> [<altgr>]]
+//	compose <accent> <base><result>...
+//
+// The scancode is the set 1 make code in hex. A rune is written as the
+// character itself in UTF-8, as U+XXXX, as "space", as "none" for a layer
+// the key has nothing on, or as "dead" followed by an accent for a dead
+// key. A key line replaces every layer of the key. A compose line lists
+// what the accent of a dead key turns each following letter into.
+
+const (
+	maxKeymapName = 8
+	maxCompose    = 64
+
+	scBackspace = 0x0E
+	scEnter     = 0x1C
+	scSpace     = 0x39
+)
+
+// Keymap is a layout built from a keymap text
+type Keymap struct {
+	name    [maxKeymapName]byte
+	nameLen int
+
+	base  [128]rune
+	shift [128]rune
+	altGr [128]rune
+
+	// compose holds accent, letter and result triples
+	compose    [maxCompose][3]rune
+	composeLen int
+}
+
+// Name is the name the keymap was loaded under
+func (k *Keymap) Name() string {
+	var s string
+	if k.nameLen == 0 {
+		return s
+	}
+	h := (*[2]uintptr)(unsafe.Pointer(&s))
+	h[0] = uintptr(unsafe.Pointer(&k.name[0]))
+	h[1] = uintptr(k.nameLen)
+	return s
+}
+
+func (k *Keymap) GetKey(sc byte) (rune, bool) {
+	return lookupKey(&k.base, sc)
+}
+
+func (k *Keymap) GetShiftKey(sc byte) (rune, bool) {
+	return lookupKey(&k.shift, sc)
+}
+
+func (k *Keymap) GetAltGrKey(sc byte) (rune, bool) {
+	return lookupKey(&k.altGr, sc)
+}
+
+// GetShiftDigitSymbol finds the digit key on the base layer and returns
+// its Shift rune
+func (k *Keymap) GetShiftDigitSymbol(r rune) (rune, bool) {
+	for sc := 0; sc < len(k.base); sc++ {
+		if k.base[sc] == r {
+			return lookupKey(&k.shift, byte(sc))
+		}
+	}
+	return 0, false
+}
+
+// Compose returns what the accent of a dead key makes of r
+func (k *Keymap) Compose(accent, r rune) (rune, bool) {
+	for i := 0; i < k.composeLen; i++ {
+		if k.compose[i][0] == accent && k.compose[i][1] == r {
+			return k.compose[i][2], true
+		}
+	}
+	return 0, false
+}
+
+// parse builds the keymap from the QWERTY table and src, reporting the
+// 1-based number of the first bad line
+func (k *Keymap) parse(name string, src []byte) (badLine int, ok bool) {
+	k.nameLen = 0
+	for i := 0; i < len(name) && i < maxKeymapName; i++ {
+		k.name[i] = toLowerByte(name[i])
+		k.nameLen++
+	}
+	for sc := 0; sc < len(k.base); sc++ {
+		k.base[sc], k.shift[sc], k.altGr[sc] = 0, 0, 0
+	}
+	k.composeLen = 0
+	k.base[scBackspace] = '\b'
+	k.base[scEnter] = '\n'
+	k.base[scSpace] = ' '
+
+	if line, ok := k.parseLines(stringBytes(qwertyKeymap)); !ok {
+		return line, false
+	}
+	return k.parseLines(src)
+}
+
+func (k *Keymap) parseLines(src []byte) (badLine int, ok bool) {
+	line := 0
+	for start := 0; start < len(src); {
+		end := start
+		for end < len(src) && src[end] != '\n' {
+			end++
+		}
+		line++
+		if !k.parseLine(src[start:end]) {
+			return line, false
+		}
+		start = end + 1
+	}
+	return 0, true
+}
+
+func (k *Keymap) parseLine(text []byte) bool {
+	var tokens [16][]byte
+	n := 0
+	for i := 0; i < len(text); {
+		for i < len(text) && isBlank(text[i]) {
+			i++
+		}
+		start := i
+		for i < len(text) && !isBlank(text[i]) {
+			i++
+		}
+		if start == i {
+			break
+		}
+		if n == len(tokens) {
+			return false
+		}
+		tokens[n] = text[start:i]
+		n++
+	}
+	if n == 0 || tokens[0][0] == '#' {
+		return true
+	}
+
+	if equalToken(tokens[0], "compose") {
+		return k.parseCompose(&tokens, n)
+	}
+
+	sc, ok := parseScancode(tokens[0])
+	if !ok || n > 4 {
+		return false
+	}
+	var layers [3]rune
+	for i := 1; i < n; i++ {
+		if layers[i-1], ok = parseRune(tokens[i]); !ok {
+			return false
+		}
+	}
+	k.base[sc], k.shift[sc], k.altGr[sc] = layers[0], layers[1], layers[2]
+	return true
+}
+
+func (k *Keymap) parseCompose(tokens *[16][]byte, n int) bool {
+	if n < 3 {
+		return false
+	}
+	accent, size := decodeRune(tokens[1])
+	if size == 0 || size != len(tokens[1]) {
+		return false
+	}
+	for i := 2; i < n; i++ {
+		tok := tokens[i]
+		letter, a := decodeRune(tok)
+		if a == 0 {
+			return false
+		}
+		result, b := decodeRune(tok[a:])
+		if b == 0 || a+b != len(tok) || k.composeLen == maxCompose {
+			return false
+		}
+		k.compose[k.composeLen] = [3]rune{accent, letter, result}
+		k.composeLen++
+	}
+	return true
+}
+
+func parseScancode(tok []byte) (byte, bool) {
+	if len(tok) != 2 {
+		return 0, false
+	}
+	hi, ok1 := hexDigit(tok[0])
+	lo, ok2 := hexDigit(tok[1])
+	if !ok1 || !ok2 || hi > 7 {
+		return 0, false
+	}
+	return hi<<4 | lo, true
+}
+
+// parseRune reads one rune token of a key line
+func parseRune(tok []byte) (rune, bool) {
+	if r, size := decodeRune(tok); size != 0 && size == len(tok) {
+		return r, true
+	}
+	switch {
+	case equalToken(tok, "none"):
+		return 0, true
+	case equalToken(tok, "space"):
+		return ' ', true
+	case len(tok) > 4 && equalToken(tok[:4], "dead"):
+		r, size := decodeRune(tok[4:])
+		if size == 0 || 4+size != len(tok) {
+			return 0, false
+		}
+		return r | keyboard.DeadKey, true
+	case len(tok) > 2 && len(tok) <= 8 && tok[0] == 'U' && tok[1] == '+':
+		var r rune
+		for i := 2; i < len(tok); i++ {
+			d, ok := hexDigit(tok[i])
+			if !ok {
+				return 0, false
+			}
+			r = r<<4 | rune(d)
+		}
+		return r, true
+	}
+	return 0, false
+}
+
+// decodeRune decodes the UTF-8 sequence at the start of b, returning a
+// size of 0 when it is malformed
+func decodeRune(b []byte) (rune, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	c := b[0]
+	var r rune
+	var size int
+	switch {
+	case c < 0x80:
+		return rune(c), 1
+	case c&0xE0 == 0xC0:
+		r, size = rune(c&0x1F), 2
+	case c&0xF0 == 0xE0:
+		r, size = rune(c&0x0F), 3
+	case c&0xF8 == 0xF0:
+		r, size = rune(c&0x07), 4
+	default:
+		return 0, 0
+	}
+	if len(b) < size {
+		return 0, 0
+	}
+	for i := 1; i < size; i++ {
+		if b[i]&0xC0 != 0x80 {
+			return 0, 0
+		}
+		r = r<<6 | rune(b[i]&0x3F)
+	}
+	return r, size
+}
+
+func hexDigit(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	}
+	return 0, false
+}
+
+func equalToken(tok []byte, s string) bool {
+	if len(tok) != len(s) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if tok[i] != s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+func toLowerByte(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c - 'A' + 'a'
+	}
+	return c
+}
+
+// stringBytes views a string as a byte slice without copying, since the
+// freestanding build cannot allocate one. The bytes must not be written.
+func stringBytes(s string) []byte {
+	var b []byte
+	if len(s) == 0 {
+		return b
+	}
+	h := (*[3]uintptr)(unsafe.Pointer(&b))
+	h[0] = (*[2]uintptr)(unsafe.Pointer(&s))[0]
+	h[1] = uintptr(len(s))
+	h[2] = uintptr(len(s))
+	return b
+}
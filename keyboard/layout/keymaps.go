@@ -0,0 +1,259 @@
+package layout
+
+import "github.com/dmarro89/go-dav-os/keyboard"
+
+// qwertyKeymap is the US table every keymap is written against. Letters
+// without a Shift rune are capitalised by the keyboard driver.
+const qwertyKeymap = `
+02 1 !
+03 2 @
+04 3 #
+05 4 $
+06 5 %
+07 6 ^
+08 7 &
+09 8 *
+0A 9 (
+0B 0 )
+0C - _
+0D = +
+10 q
+11 w
+12 e
+13 r
+14 t
+15 y
+16 u
+17 i
+18 o
+19 p
+1A [ {
+1B ] }
+1E a
+1F s
+20 d
+21 f
+22 g
+23 h
+24 j
+25 k
+26 l
+27 ; :
+28 ' "
+29 ` + "`" + ` ~
+2B \ |
+2C z
+2D x
+2E c
+2F v
+30 b
+31 n
+32 m
+33 , <
+34 . >
+35 / ?
+`
+
+const ukKeymap = `
+# British
+03 2 "
+04 3 £
+05 4 $ €
+28 ' @
+29 ` + "`" + ` ¬ ¦
+2B # ~
+56 \ |
+`
+
+const deKeymap = `
+# German QWERTZ
+29 dead^ °
+03 2 " ²
+04 3 § ³
+07 6 &
+08 7 / {
+09 8 ( [
+0A 9 ) ]
+0B 0 = }
+0C ß ? \
+0D dead´ dead` + "`" + `
+10 q Q @
+12 e E €
+15 z Z
+1A ü Ü
+1B + * ~
+27 ö Ö
+28 ä Ä
+2B # '
+2C y Y
+32 m M µ
+33 , ;
+34 . :
+35 - _
+56 < > |
+compose ^ aâ eê iî oô uû AÂ EÊ IÎ OÔ UÛ
+compose ´ aá eé ií oó uú AÁ EÉ IÍ OÓ UÚ
+compose ` + "`" + ` aà eè iì oò uù AÀ EÈ IÌ OÒ UÙ
+`
+
+const frKeymap = `
+# French AZERTY
+29 ² none
+02 & 1
+03 é 2 ~
+04 " 3 #
+05 ' 4 {
+06 ( 5 [
+07 - 6 |
+08 è 7 ` + "`" + `
+09 _ 8 \
+0A ç 9 ^
+0B à 0 @
+0C ) ° ]
+0D = + }
+10 a A
+11 z Z
+12 e E €
+1A dead^ dead¨
+1B $ £ ¤
+1E q Q
+27 m M
+28 ù %
+2B * µ
+2C w W
+32 , ?
+33 ; .
+34 : /
+35 ! §
+56 < >
+compose ^ aâ eê iî oô uû AÂ EÊ IÎ OÔ UÛ
+compose ¨ aä eë iï oö uü yÿ AÄ EË IÏ OÖ UÜ
+`
+
+const esKeymap = `
+# Spanish
+29 º ª \
+02 1 ! |
+03 2 " @
+04 3 · #
+05 4 $ ~
+07 6 & ¬
+08 7 /
+09 8 (
+0A 9 )
+0B 0 =
+0C ' ?
+0D ¡ ¿
+12 e E €
+1A dead` + "`" + ` dead^ [
+1B + * ]
+27 ñ Ñ
+28 dead´ dead¨ {
+2B ç Ç }
+33 , ;
+34 . :
+35 - _
+56 < >
+compose ` + "`" + ` aà eè iì oò uù AÀ EÈ IÌ OÒ UÙ
+compose ^ aâ eê iî oô uû AÂ EÊ IÎ OÔ UÛ
+compose ´ aá eé ií oó uú AÁ EÉ IÍ OÓ UÚ
+compose ¨ aä eë iï oö uü AÄ EË IÏ OÖ UÜ
+`
+
+const dvorakKeymap = `
+# US Dvorak
+0C [ {
+0D ] }
+10 ' "
+11 , <
+12 . >
+13 p
+14 y
+15 f
+16 g
+17 c
+18 r
+19 l
+1A / ?
+1B = +
+1F o
+20 e
+21 u
+22 i
+23 d
+24 h
+25 t
+26 n
+27 s
+28 - _
+2C ; :
+2D q
+2E j
+2F k
+30 x
+31 b
+32 m
+33 w
+34 v
+35 z
+`
+
+// builtinNames lists every compiled-in layout, the Go tables first and
+// then the keymaps in builtinKeymaps order
+var builtinNames = [...]string{USLayoutName, ITLayoutName, "de", "fr", "es", "uk", "dvorak"}
+
+var builtinKeymaps = [...]string{deKeymap, frKeymap, esKeymap, ukKeymap, dvorakKeymap}
+
+var (
+	// The compiled-in keymaps, parsed the first time they are used
+	builtins      [len(builtinKeymaps)]Keymap
+	builtinParsed [len(builtinKeymaps)]bool
+
+	// Keymaps loaded from disk alternate between two slots so the one
+	// being parsed is never the one the keyboard IRQ may be reading
+	loaded     [2]Keymap
+	loadedNext int
+)
+
+// BuiltinCount is the number of compiled-in layouts
+func BuiltinCount() int { return len(builtinNames) }
+
+// BuiltinName returns the name of compiled-in layout i
+func BuiltinName(i int) string { return builtinNames[i] }
+
+// Find returns the compiled-in layout called name
+func Find(name string) (keyboard.Layout, string, bool) {
+	switch name {
+	case USLayoutName:
+		l, n := GetUS()
+		return l, n, true
+	case ITLayoutName:
+		l, n := GetIT()
+		return l, n, true
+	}
+	for i := 0; i < len(builtinKeymaps); i++ {
+		if builtinNames[i+2] != name {
+			continue
+		}
+		k := &builtins[i]
+		if !builtinParsed[i] {
+			if _, ok := k.parse(name, stringBytes(builtinKeymaps[i])); !ok {
+				return nil, "", false
+			}
+			builtinParsed[i] = true
+		}
+		return k, k.Name(), true
+	}
+	return nil, "", false
+}
+
+// Load parses a keymap text read from disk as the layout called name. On
+// failure badLine is the 1-based number of the line that was rejected.
+func Load(name string, src []byte) (l keyboard.Layout, canonical string, badLine int, ok bool) {
+	k := &loaded[loadedNext]
+	if badLine, ok = k.parse(name, src); !ok {
+		return nil, "", badLine, false
+	}
+	loadedNext = 1 - loadedNext
+	return k, k.Name(), 0, true
+}
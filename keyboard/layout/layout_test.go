@@ -1,6 +1,10 @@
 package layout
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/dmarro89/go-dav-os/keyboard"
+)
 
 // TestUSLayout_LetterScancodes verifies the canonical letter scancodes
 // produce the expected lowercase letters.
@@ -226,3 +230,106 @@ func TestLayouts_UnknownScancodesReturnFalse(t *testing.T) {
 		}
 	}
 }
+
+// TestBuiltinKeymapsParse checks every compiled-in keymap loads and that
+// each one changes the key it is best known for.
+func TestBuiltinKeymapsParse(t *testing.T) {
+	cases := []struct {
+		name   string
+		sc     byte
+		base   rune
+		shift  rune
+		altGr  rune
+		hasAlt bool
+	}{
+		{"de", 0x15, 'z', 'Z', 0, false},
+		{"de", 0x10, 'q', 'Q', '@', true},
+		{"fr", 0x10, 'a', 'A', 0, false},
+		{"fr", 0x03, 'é', '2', '~', true},
+		{"es", 0x27, 'ñ', 'Ñ', 0, false},
+		{"uk", 0x04, '3', '£', 0, false},
+		{"dvorak", 0x2D, 'q', 0, 0, false},
+	}
+	for _, tc := range cases {
+		l, name, ok := Find(tc.name)
+		if !ok || name != tc.name {
+			t.Fatalf("Find(%q) = %q, %v", tc.name, name, ok)
+		}
+		if got, _ := l.GetKey(tc.sc); got != tc.base {
+			t.Errorf("%s.GetKey(0x%02X) = %q, want %q", tc.name, tc.sc, got, tc.base)
+		}
+		if got, _ := l.GetShiftKey(tc.sc); got != tc.shift {
+			t.Errorf("%s.GetShiftKey(0x%02X) = %q, want %q", tc.name, tc.sc, got, tc.shift)
+		}
+		if got, ok := l.GetAltGrKey(tc.sc); ok != tc.hasAlt || got != tc.altGr {
+			t.Errorf("%s.GetAltGrKey(0x%02X) = %q, %v", tc.name, tc.sc, got, ok)
+		}
+	}
+}
+
+// TestKeymapKeepsQwertyKeysItDoesNotList checks a keymap starts from the
+// US table.
+func TestKeymapKeepsQwertyKeysItDoesNotList(t *testing.T) {
+	l, _, _ := Find("uk")
+	if r, _ := l.GetKey(0x10); r != 'q' {
+		t.Fatalf("uk q key = %q", r)
+	}
+	if r, _ := l.GetShiftKey(0x35); r != '?' {
+		t.Fatalf("uk Shift+/ = %q", r)
+	}
+	if r, ok := l.GetShiftDigitSymbol('2'); !ok || r != '"' {
+		t.Fatalf("uk GetShiftDigitSymbol('2') = %q, %v", r, ok)
+	}
+}
+
+func TestLoadKeymapDeadKeysAndCompose(t *testing.T) {
+	src := []byte("# test\n1A dead^ dead¨ U+005B\n39 space\ncompose ^ aâ eê\n")
+	l, name, badLine, ok := Load("TEST", src)
+	if !ok || name != "test" {
+		t.Fatalf("Load = %q, line %d, %v", name, badLine, ok)
+	}
+	k := l.(*Keymap)
+	if r, _ := k.GetKey(0x1A); r != '^'|keyboard.DeadKey {
+		t.Fatalf("dead key rune = %#x", r)
+	}
+	if r, _ := k.GetAltGrKey(0x1A); r != '[' {
+		t.Fatalf("U+005B = %q", r)
+	}
+	if r, ok := k.Compose('^', 'e'); !ok || r != 'ê' {
+		t.Fatalf("Compose('^', 'e') = %q, %v", r, ok)
+	}
+	if _, ok := k.Compose('^', 'x'); ok {
+		t.Fatalf("Compose('^', 'x') should not compose")
+	}
+}
+
+func TestLoadKeymapRejectsBadLines(t *testing.T) {
+	for src, want := range map[string]int{
+		"10 q\nZZ q\n":        2,
+		"10 q Q @ extra\n":    1,
+		"\n\n10 qq\n":         3,
+		"compose ^ a\n":       1,
+		"10 dead\n":           1,
+		"80 q\n":              1,
+		"10 U+00G8\n":         1,
+		"10 \xff\n":           1,
+		"# fine\ncompose ^\n": 2,
+	} {
+		if _, _, line, ok := Load("bad", []byte(src)); ok || line != want {
+			t.Errorf("Load(%q) = line %d, %v; want line %d", src, line, ok, want)
+		}
+	}
+}
+
+// TestLoadAlternatesSlots checks a load never rewrites the keymap the
+// previous load returned, which may be the active layout.
+func TestLoadAlternatesSlots(t *testing.T) {
+	a, _, _, _ := Load("a", []byte("10 a\n"))
+	b, _, _, _ := Load("b", []byte("10 b\n"))
+	if r, _ := a.GetKey(0x10); r != 'a' {
+		t.Fatalf("first keymap was overwritten: %q", r)
+	}
+	if r, _ := b.GetKey(0x10); r != 'b' {
+		t.Fatalf("second keymap = %q", r)
+	}
+}
@@ -90,3 +90,15 @@ func (u *USLayout) GetShiftDigitSymbol(r rune) (rune, bool) {
 		return 0, false
 	}
 }
+
+func (*USLayout) GetShiftKey(sc byte) (rune, bool) {
+	return 0, false
+}
+
+func (*USLayout) GetAltGrKey(sc byte) (rune, bool) {
+	return 0, false
+}
+
+func (*USLayout) Compose(accent, r rune) (rune, bool) {
+	return 0, false
+}
@@ -0,0 +1,115 @@
+//go:build testing
+
+package keyboard
+
+import "testing"
+
+// deadLayout puts a dead grave on 0x1A and a dead circumflex on Shift+0x1A,
+// with 'e' on 0x12, 'x' on 0x2D and AltGr+0x12 giving '€'
+type deadLayout struct{ testLayout }
+
+func (deadLayout) GetKey(sc byte) (rune, bool) {
+	switch sc {
+	case 0x1A:
+		return '`' | DeadKey, true
+	case 0x12:
+		return 'e', true
+	case 0x2D:
+		return 'x', true
+	case 0x39:
+		return ' ', true
+	}
+	return testLayout{}.GetKey(sc)
+}
+
+func (deadLayout) GetShiftKey(sc byte) (rune, bool) {
+	if sc == 0x1A {
+		return '^' | DeadKey, true
+	}
+	return 0, false
+}
+
+func (deadLayout) GetAltGrKey(sc byte) (rune, bool) {
+	if sc == 0x12 {
+		return '€', true
+	}
+	return 0, false
+}
+
+func (deadLayout) Compose(accent, r rune) (rune, bool) {
+	switch {
+	case accent == '`' && r == 'e':
+		return 'è', true
+	case accent == '`' && r == 'E':
+		return 'È', true
+	case accent == '^' && r == 'e':
+		return 'ê', true
+	}
+	return 0, false
+}
+
+// typed feeds scancodes and returns the runes the key presses typed
+func typed(scs ...byte) []rune {
+	var rs []rune
+	for _, ev := range decodeAll(scs...) {
+		if ev.Rune != 0 {
+			rs = append(rs, ev.Rune)
+		}
+	}
+	return rs
+}
+
+func TestDeadKeyComposesWithNextLetter(t *testing.T) {
+	tests := []struct {
+		name string
+		scs  []byte
+		want string
+	}{
+		{"grave e", []byte{0x1A, 0x9A, 0x12, 0x92}, "è"},
+		{"shifted dead key", []byte{scLeftShiftDown, 0x1A, 0x9A, scLeftShiftUp, 0x12}, "ê"},
+		{"capital", []byte{0x1A, scLeftShiftDown, 0x12, scLeftShiftUp}, "È"},
+		{"space types the accent", []byte{0x1A, 0x39}, "`"},
+		{"dead key twice types the accent", []byte{0x1A, 0x1A}, "`"},
+		{"no composition types the letter", []byte{0x1A, 0x2D, 0x12}, "xe"},
+		{"a dead key alone types nothing", []byte{0x1A, 0x9A}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetKeyboardState()
+			SetLayout(deadLayout{})
+			if got := string(typed(tt.scs...)); got != tt.want {
+				t.Fatalf("typed %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCtrlDropsPendingDeadKey(t *testing.T) {
+	resetKeyboardState()
+	SetLayout(deadLayout{})
+
+	got := typed(0x1A, 0x1D, 0x1E, 0x9D, 0x12)
+	if string(got) != "\x01e" {
+		t.Fatalf("typed %q, want Ctrl-A then a plain e", string(got))
+	}
+}
+
+func TestAltGrLayerTypesSymbol(t *testing.T) {
+	resetKeyboardState()
+	SetLayout(deadLayout{})
+
+	if got := string(typed(0xE0, 0x38, 0x12, 0xE0, 0xB8, 0x12)); got != "€e" {
+		t.Fatalf("typed %q, want %q", got, "€e")
+	}
+}
+
+func TestSetLayoutDropsPendingDeadKey(t *testing.T) {
+	resetKeyboardState()
+	SetLayout(deadLayout{})
+	typed(0x1A)
+	SetLayout(deadLayout{})
+
+	if got := string(typed(0x12)); got != "e" {
+		t.Fatalf("typed %q after a layout switch, want %q", got, "e")
+	}
+}
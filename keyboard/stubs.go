@@ -5,16 +5,33 @@ package keyboard
 type Layout interface {
 	GetKey(byte) (rune, bool)
 	GetShiftDigitSymbol(r rune) (rune, bool)
+	// GetShiftKey and GetAltGrKey give the rune of a whole Shift or AltGr
+	// layer; a layout without one reports false and the base rune is used
+	GetShiftKey(byte) (rune, bool)
+	GetAltGrKey(byte) (rune, bool)
+	// Compose is what the accent of a dead key makes of the next rune
+	Compose(accent, r rune) (rune, bool)
 }
 
 func inb(port uint16) byte {
 	return 0
 }
 
-func outb(port uint16, value byte) {}
+// sentForTesting records the bytes written to the keyboard
+var sentForTesting []byte
+
+func outb(port uint16, value byte) {
+	if port == portData {
+		sentForTesting = append(sentForTesting, value)
+	}
+}
 
 func IRQHandler() {}
 
+func TryReadEvent() (Event, bool) {
+	return Event{}, false
+}
+
 func TryRead() (rune, bool) {
 	return 0, false
 }
@@ -1,6 +1,10 @@
 package mem
 
-import "unsafe"
+import (
+	"unsafe"
+
+	"github.com/dmarro89/go-dav-os/kernel/spinlock"
+)
 
 const pageSize = 4096
 
@@ -11,6 +15,9 @@ var (
 	bitmapPhys  uint64 // Physical address of the bitmap
 	bitmapBytes uint64
 	scanStart   uint64 // First page index to start scanning from
+
+	// pfaLock serialises AllocPage/FreePage across CPUs
+	pfaLock spinlock.Lock
 )
 
 func kernelEndPhys() uint64 {
@@ -211,15 +218,18 @@ func AllocPage() uint64 {
 		return 0
 	}
 
+	flags := pfaLock.AcquireIRQ()
 	for page := scanStart; page < totalPages; page++ {
 		if !bitmapGet(page) {
 			bitmapSet(page, true)
 			if freePages > 0 {
 				freePages--
 			}
+			pfaLock.ReleaseIRQ(flags)
 			return page * pageSize
 		}
 	}
+	pfaLock.ReleaseIRQ(flags)
 
 	return 0
 }
@@ -237,11 +247,15 @@ func FreePage(addr uint64) bool {
 	if page >= totalPages {
 		return false
 	}
+
+	flags := pfaLock.AcquireIRQ()
 	if !bitmapGet(page) {
+		pfaLock.ReleaseIRQ(flags)
 		return false
 	}
 
 	bitmapSet(page, false)
 	freePages++
+	pfaLock.ReleaseIRQ(flags)
 	return true
 }
@@ -17,11 +17,44 @@ var (
 	// mmapEntries stores a compact snapshot of the memory map provided by GRUB
 	mmapEntries [maxMMapEntries]mmapEntry
 	mmapCount   int
+
+	// acpiRSDP is the address of the RSDP copy GRUB places inside the boot info
+	acpiRSDP uint64
+
+	// framebuffer is the video mode GRUB set up, when it reported one
+	framebuffer      FramebufferInfo
+	foundFramebuffer bool
+)
+
+// FramebufferInfo is the framebuffer tag of the boot info. Type tells an
+// indexed, a direct RGB or an EGA text framebuffer apart; the channel
+// fields are only meaningful for RGB.
+type FramebufferInfo struct {
+	Addr   uint64
+	Pitch  uint32
+	Width  uint32
+	Height uint32
+	BPP    uint8
+	Type   uint8
+
+	RedPos, RedSize     uint8
+	GreenPos, GreenSize uint8
+	BluePos, BlueSize   uint8
+}
+
+// Framebuffer types of the multiboot2 framebuffer tag
+const (
+	FramebufferIndexed = 0
+	FramebufferRGB     = 1
+	FramebufferEGAText = 2
 )
 
 const (
-	multiboot2TagTypeEnd  = 0
-	multiboot2TagTypeMmap = 6
+	multiboot2TagTypeEnd     = 0
+	multiboot2TagTypeMmap    = 6
+	multiboot2TagTypeFB      = 8
+	multiboot2TagTypeACPIOld = 14
+	multiboot2TagTypeACPINew = 15
 )
 
 // readU32 reads a 32-bit value from memory at the given address
@@ -29,6 +62,11 @@ func readU32(addr uintptr) uint32 {
 	return *(*uint32)(unsafe.Pointer(addr))
 }
 
+// readU8 reads a byte from memory at the given address
+func readU8(addr uintptr) uint8 {
+	return *(*uint8)(unsafe.Pointer(addr))
+}
+
 // readU64 reads a 64-bit value from memory at the given address
 func readU64(addr uintptr) uint64 {
 	return *(*uint64)(unsafe.Pointer(addr))
@@ -43,6 +81,8 @@ func alignUp8(p uintptr) uintptr {
 func InitMultiboot(mbInfoAddr uint64) bool {
 	// reset the memory map counter
 	mmapCount = 0
+	acpiRSDP = 0
+	foundFramebuffer = false
 	if mbInfoAddr == 0 {
 		return false
 	}
@@ -92,12 +132,50 @@ func InitMultiboot(mbInfoAddr uint64) bool {
 			}
 		}
 
+		// Prefer the ACPI 2.0+ RSDP (it carries the XSDT) over the 1.0 copy
+		if tagType == multiboot2TagTypeACPINew && tagSize > 8 {
+			acpiRSDP = uint64(p + 8)
+		}
+		if tagType == multiboot2TagTypeACPIOld && tagSize > 8 && acpiRSDP == 0 {
+			acpiRSDP = uint64(p + 8)
+		}
+
+		if tagType == multiboot2TagTypeFB && tagSize >= 32 {
+			parseFramebuffer(p, tagSize)
+		}
+
 		p = alignUp8(p + uintptr(tagSize))
 	}
 
 	return foundMmap
 }
 
+// parseFramebuffer reads the common part of the framebuffer tag and, for an
+// RGB framebuffer, the position and size of each colour channel
+func parseFramebuffer(p uintptr, tagSize uint32) {
+	// Field by field: a whole struct assignment may become a memcpy, which
+	// the freestanding build does not have
+	fb := &framebuffer
+	fb.Addr = readU64(p + 8)
+	fb.Pitch = readU32(p + 16)
+	fb.Width = readU32(p + 20)
+	fb.Height = readU32(p + 24)
+	fb.BPP = readU8(p + 28)
+	fb.Type = readU8(p + 29)
+	fb.RedPos, fb.RedSize = 0, 0
+	fb.GreenPos, fb.GreenSize = 0, 0
+	fb.BluePos, fb.BlueSize = 0, 0
+	if fb.Type == FramebufferRGB && tagSize >= 38 {
+		fb.RedPos = readU8(p + 32)
+		fb.RedSize = readU8(p + 33)
+		fb.GreenPos = readU8(p + 34)
+		fb.GreenSize = readU8(p + 35)
+		fb.BluePos = readU8(p + 36)
+		fb.BlueSize = readU8(p + 37)
+	}
+	foundFramebuffer = true
+}
+
 // MMapCount returns the number of memory map entries
 func MMapCount() int { return mmapCount }
 
@@ -109,3 +187,12 @@ func MMapEntry(i int) (baseLo, baseHi, lenLo, lenHi, typ uint32) {
 	e := mmapEntries[i]
 	return e.baseLo, e.baseHi, e.lenLo, e.lenHi, e.typ
 }
+
+// ACPIRSDP returns the address of the ACPI RSDP found in the boot info, or 0
+func ACPIRSDP() uint64 { return acpiRSDP }
+
+// Framebuffer returns the framebuffer described by the boot info, if any.
+// It points at the parsed tag rather than copying it.
+func Framebuffer() (*FramebufferInfo, bool) {
+	return &framebuffer, foundFramebuffer
+}
@@ -0,0 +1,90 @@
+package mem
+
+import (
+	"encoding/binary"
+	"testing"
+	"unsafe"
+)
+
+// bootInfo lays out a multiboot2 info structure with the given tags, each
+// padded to 8 bytes, and an end tag
+func bootInfo(tags ...[]byte) []byte {
+	info := make([]byte, 8)
+	for _, tag := range tags {
+		info = append(info, tag...)
+		for len(info)%8 != 0 {
+			info = append(info, 0)
+		}
+	}
+	info = append(info, 0, 0, 0, 0, 8, 0, 0, 0)
+	binary.LittleEndian.PutUint32(info, uint32(len(info)))
+	return info
+}
+
+func mmapTag(base, length uint64, typ uint32) []byte {
+	tag := make([]byte, 16+24)
+	binary.LittleEndian.PutUint32(tag[0:], multiboot2TagTypeMmap)
+	binary.LittleEndian.PutUint32(tag[4:], uint32(len(tag)))
+	binary.LittleEndian.PutUint32(tag[8:], 24)
+	binary.LittleEndian.PutUint64(tag[16:], base)
+	binary.LittleEndian.PutUint64(tag[24:], length)
+	binary.LittleEndian.PutUint32(tag[32:], typ)
+	return tag
+}
+
+func framebufferTag(addr uint64, pitch, width, height uint32, bpp, typ byte, channels ...byte) []byte {
+	tag := make([]byte, 32+len(channels))
+	binary.LittleEndian.PutUint32(tag[0:], multiboot2TagTypeFB)
+	binary.LittleEndian.PutUint32(tag[4:], uint32(len(tag)))
+	binary.LittleEndian.PutUint64(tag[8:], addr)
+	binary.LittleEndian.PutUint32(tag[16:], pitch)
+	binary.LittleEndian.PutUint32(tag[20:], width)
+	binary.LittleEndian.PutUint32(tag[24:], height)
+	tag[28] = bpp
+	tag[29] = typ
+	copy(tag[32:], channels)
+	return tag
+}
+
+func parse(t *testing.T, info []byte) bool {
+	t.Helper()
+	return InitMultiboot(uint64(uintptr(unsafe.Pointer(&info[0]))))
+}
+
+func TestInitMultibootReadsRGBFramebuffer(t *testing.T) {
+	info := bootInfo(
+		mmapTag(0x100000, 0x7F00000, 1),
+		framebufferTag(0xFD000000, 4096, 1024, 768, 32, FramebufferRGB, 16, 8, 8, 8, 0, 8),
+	)
+	if !parse(t, info) || MMapCount() != 1 {
+		t.Fatalf("memory map not found")
+	}
+
+	fb, ok := Framebuffer()
+	want := FramebufferInfo{
+		Addr: 0xFD000000, Pitch: 4096, Width: 1024, Height: 768, BPP: 32, Type: FramebufferRGB,
+		RedPos: 16, RedSize: 8, GreenPos: 8, GreenSize: 8, BluePos: 0, BlueSize: 8,
+	}
+	if !ok || *fb != want {
+		t.Fatalf("Framebuffer() = %+v, %v; want %+v", fb, ok, want)
+	}
+}
+
+func TestInitMultibootTextModeFramebuffer(t *testing.T) {
+	info := bootInfo(framebufferTag(0xB8000, 160, 80, 25, 16, FramebufferEGAText))
+	parse(t, info)
+
+	fb, ok := Framebuffer()
+	if !ok || fb.Type != FramebufferEGAText || fb.Width != 80 || fb.RedSize != 0 {
+		t.Fatalf("Framebuffer() = %+v, %v", fb, ok)
+	}
+}
+
+func TestInitMultibootWithoutFramebuffer(t *testing.T) {
+	parse(t, bootInfo(framebufferTag(0xFD000000, 4096, 1024, 768, 32, FramebufferRGB, 16, 8, 8, 8, 0, 8)))
+	parse(t, bootInfo(mmapTag(0, 0x9FC00, 1)))
+
+	if _, ok := Framebuffer(); ok {
+		t.Fatalf("a framebuffer from an earlier boot info must not survive")
+	}
+}
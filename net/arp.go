@@ -0,0 +1,151 @@
+package net
+
+import (
+	"github.com/dmarro89/go-dav-os/drivers/netdev"
+	ktime "github.com/dmarro89/go-dav-os/kernel/time"
+)
+
+const (
+	ARPCacheSize = 16
+
+	arpPacketLen  = 28
+	arpHTypeEther = 1
+	arpOpRequest  = 1
+	arpOpReply    = 2
+	arpRetries    = 3
+	arpRetryNs    = 300 * ktime.NanosPerMillisecond
+	arpLifetimeNs = 300 * ktime.NanosPerSecond
+	arpSenderMAC  = 8
+	arpSenderIP   = 14
+	arpTargetMAC  = 18
+	arpTargetIP   = 24
+)
+
+// ARPEntry is one cached IPv4 to Ethernet mapping
+type ARPEntry struct {
+	IP      IP
+	MAC     [netdev.AddrLen]byte
+	Valid   bool
+	updated uint64
+}
+
+var (
+	arpCache [ARPCacheSize]ARPEntry
+)
+
+// ARPAt returns the i-th cache slot, which may be invalid
+func ARPAt(i int) *ARPEntry {
+	if i < 0 || i >= ARPCacheSize {
+		return nil
+	}
+	return &arpCache[i]
+}
+
+func arpFind(ip IP) *ARPEntry {
+	now := nanotime()
+	for i := 0; i < ARPCacheSize; i++ {
+		e := &arpCache[i]
+		if !e.Valid || e.IP != ip {
+			continue
+		}
+		if now-e.updated > arpLifetimeNs {
+			e.Valid = false
+			return nil
+		}
+		return e
+	}
+	return nil
+}
+
+// arpStore records ip at mac, replacing an existing entry, a free slot
+// or the stalest entry, in that order
+func arpStore(ip IP, mac []byte) {
+	e := arpFind(ip)
+	if e == nil {
+		e = &arpCache[0]
+		for i := 0; i < ARPCacheSize; i++ {
+			c := &arpCache[i]
+			if !c.Valid {
+				e = c
+				break
+			}
+			if c.updated < e.updated {
+				e = c
+			}
+		}
+	}
+	e.IP = ip
+	for i := 0; i < netdev.AddrLen; i++ {
+		e.MAC[i] = mac[i]
+	}
+	e.Valid = true
+	e.updated = nanotime()
+}
+
+// arpInput learns the sender of every ARP packet aimed at us and answers
+// requests for our address
+func arpInput(p []byte) {
+	if len(p) < arpPacketLen || be16(p[0:]) != arpHTypeEther || be16(p[2:]) != EtherTypeIPv4 ||
+		p[4] != netdev.AddrLen || p[5] != 4 {
+		return
+	}
+	sender := IP(be32(p[arpSenderIP:]))
+	target := IP(be32(p[arpTargetIP:]))
+	// RFC 826: refresh a known sender from any packet, add it only when
+	// the packet is for us
+	if arpFind(sender) != nil || (configured && target == addr) {
+		arpStore(sender, p[arpSenderMAC:])
+	}
+	if be16(p[6:]) != arpOpRequest || !configured || target != addr {
+		return
+	}
+
+	var dst [netdev.AddrLen]byte
+	for i := 0; i < netdev.AddrLen; i++ {
+		dst[i] = p[arpSenderMAC+i]
+	}
+	arpOutput(arpOpReply, &dst, &dst, sender)
+}
+
+// arpOutput sends an ARP packet for target; a request goes to the
+// broadcast address with an all-zero target hardware address
+func arpOutput(op uint16, to, targetMAC *[netdev.AddrLen]byte, target IP) Error {
+	p := txBuf[netdev.HeaderLen:]
+	putBE16(p[0:], arpHTypeEther)
+	putBE16(p[2:], EtherTypeIPv4)
+	p[4] = netdev.AddrLen
+	p[5] = 4
+	putBE16(p[6:], op)
+	copyMAC(p[arpSenderMAC:], &iface.MAC)
+	putBE32(p[arpSenderIP:], uint32(addr))
+	copyMAC(p[arpTargetMAC:], targetMAC)
+	putBE32(p[arpTargetIP:], uint32(target))
+	return sendEthernet(to, EtherTypeARP, arpPacketLen)
+}
+
+func arpResolved(ip int) bool { return arpFind(IP(ip)) != nil }
+
+// resolve finds the Ethernet address of the on-link host ip, asking with
+// ARP requests when it is not cached. It blocks, so it must not be called
+// from the receive path.
+func resolve(ip IP, mac *[netdev.AddrLen]byte) Error {
+	e := arpFind(ip)
+	if e == nil {
+		var zero [netdev.AddrLen]byte
+		for try := 0; try < arpRetries && e == nil; try++ {
+			if err := arpOutput(arpOpRequest, &broadcastMAC, &zero, ip); err != OK {
+				return err
+			}
+			if waitUntil(arpResolved, int(ip), nanotime()+arpRetryNs) {
+				e = arpFind(ip)
+			}
+		}
+		if e == nil {
+			return ErrNoRoute
+		}
+	}
+	for i := 0; i < netdev.AddrLen; i++ {
+		mac[i] = e.MAC[i]
+	}
+	return OK
+}
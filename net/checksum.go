@@ -0,0 +1,51 @@
+package net
+
+// sum16 adds b to sum as big-endian 16-bit words, padding an odd tail
+// with a zero byte
+func sum16(b []byte, sum uint32) uint32 {
+	n := len(b)
+	for i := 0; i+1 < n; i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if n&1 != 0 {
+		sum += uint32(b[n-1]) << 8
+	}
+	return sum
+}
+
+// checksum folds sum16(b, sum) into the one's complement Internet
+// checksum (RFC 1071). Over data that already holds its checksum the
+// result is zero.
+func checksum(b []byte, sum uint32) uint16 {
+	sum = sum16(b, sum)
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// pseudoSum is the partial sum of the pseudo-header UDP and TCP
+// checksums cover
+func pseudoSum(src, dst IP, proto byte, length int) uint32 {
+	return uint32(src>>16) + uint32(src&0xFFFF) +
+		uint32(dst>>16) + uint32(dst&0xFFFF) +
+		uint32(proto) + uint32(length)
+}
+
+func be16(b []byte) uint16 { return uint16(b[0])<<8 | uint16(b[1]) }
+
+func be32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func putBE16(b []byte, v uint16) {
+	b[0] = byte(v >> 8)
+	b[1] = byte(v)
+}
+
+func putBE32(b []byte, v uint32) {
+	b[0] = byte(v >> 24)
+	b[1] = byte(v >> 16)
+	b[2] = byte(v >> 8)
+	b[3] = byte(v)
+}
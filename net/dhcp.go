@@ -0,0 +1,228 @@
+package net
+
+import (
+	"github.com/dmarro89/go-dav-os/drivers/netdev"
+	ktime "github.com/dmarro89/go-dav-os/kernel/time"
+)
+
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+
+	dhcpRetries   = 3
+	dhcpReplyWait = 2 * ktime.NanosPerSecond
+
+	// BOOTP message layout (RFC 2131); options follow the magic cookie
+	bootRequest     = 1
+	bootReply       = 2
+	bootFlagBcast   = 0x8000
+	bootXID         = 4
+	bootFlags       = 10
+	bootYIAddr      = 16
+	bootCHAddr      = 28
+	bootMagic       = 236
+	bootOptions     = 240
+	bootMinLen      = 300
+	dhcpMagicCookie = 0x63825363
+
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpAck      = 5
+	dhcpNak      = 6
+
+	optPad         = 0
+	optSubnetMask  = 1
+	optRouter      = 3
+	optDNS         = 6
+	optRequestedIP = 50
+	optLeaseTime   = 51
+	optMessageType = 53
+	optServerID    = 54
+	optParamList   = 55
+	optEnd         = 255
+)
+
+// dhcpLease is what one OFFER or ACK carries
+type dhcpLease struct {
+	kind    byte
+	addr    IP
+	mask    IP
+	router  IP
+	dns     IP
+	server  IP
+	seconds uint32
+}
+
+var (
+	dhcpXID    uint32
+	dhcpSocket = -1
+	dhcpBuf    [bootMinLen]byte
+	dhcpRx     [MaxUDPPayload]byte
+	leaseTime  uint32
+)
+
+// LeaseSeconds returns the lease length granted by the last DHCP ACK
+func LeaseSeconds() uint32 { return leaseTime }
+
+// DHCP configures the interface from a DHCP server: DISCOVER, wait for an
+// OFFER, REQUEST the offered address and apply it once ACKed. Each step
+// is retried a few times before giving up.
+func DHCP() Error {
+	flags := lock.AcquireIRQ()
+	err := dhcp()
+	lock.ReleaseIRQ(flags)
+	return err
+}
+
+func dhcp() Error {
+	if iface == nil {
+		return ErrNoInterface
+	}
+	sock, err := udpOpen(dhcpClientPort)
+	if err != OK {
+		return err
+	}
+	dhcpSocket = sock
+	err = dhcpExchange()
+	udpClose(sock)
+	dhcpSocket = -1
+	return err
+}
+
+func dhcpExchange() Error {
+	dhcpXID = uint32(nanotime()) ^ uint32(iface.MAC[2])<<24 ^ uint32(iface.MAC[3])<<16 ^
+		uint32(iface.MAC[4])<<8 ^ uint32(iface.MAC[5])
+
+	var offer, ack dhcpLease
+	err := ErrTimeout
+	for try := 0; try < dhcpRetries; try++ {
+		if err = dhcpSend(dhcpDiscover, 0, 0); err != OK {
+			return err
+		}
+		if dhcpAwait(&offer, dhcpOffer) {
+			break
+		}
+		err = ErrTimeout
+	}
+	if err != OK {
+		return err
+	}
+
+	err = ErrTimeout
+	for try := 0; try < dhcpRetries; try++ {
+		if err = dhcpSend(dhcpRequest, offer.addr, offer.server); err != OK {
+			return err
+		}
+		if dhcpAwait(&ack, dhcpAck) {
+			break
+		}
+		err = ErrTimeout
+	}
+	if err != OK {
+		return err
+	}
+	if ack.kind == dhcpNak {
+		return ErrRejected
+	}
+
+	configure(ack.addr, ack.mask, ack.router)
+	dns = ack.dns
+	leaseTime = ack.seconds
+	return OK
+}
+
+// dhcpSend broadcasts a DISCOVER, or a REQUEST for requested from server
+func dhcpSend(kind byte, requested, server IP) Error {
+	b := dhcpBuf[:]
+	for i := range b {
+		b[i] = 0
+	}
+	b[0] = bootRequest
+	b[1] = 1 // Ethernet
+	b[2] = netdev.AddrLen
+	putBE32(b[bootXID:], dhcpXID)
+	// Ask for broadcast replies: without an address we cannot take unicast
+	putBE16(b[bootFlags:], bootFlagBcast)
+	copyMAC(b[bootCHAddr:], &iface.MAC)
+	putBE32(b[bootMagic:], dhcpMagicCookie)
+
+	o := bootOptions
+	b[o], b[o+1], b[o+2] = optMessageType, 1, kind
+	o += 3
+	if kind == dhcpRequest {
+		b[o], b[o+1] = optRequestedIP, 4
+		putBE32(b[o+2:], uint32(requested))
+		o += 6
+		b[o], b[o+1] = optServerID, 4
+		putBE32(b[o+2:], uint32(server))
+		o += 6
+	}
+	b[o], b[o+1], b[o+2], b[o+3], b[o+4] = optParamList, 3, optSubnetMask, optRouter, optDNS
+	o += 5
+	b[o] = optEnd
+
+	// Until the ACK is applied the source address is 0.0.0.0
+	return udpSendTo(dhcpSocket, Broadcast, dhcpServerPort, b)
+}
+
+func dhcpPending(sock int) bool { return udpPending(sock) > 0 }
+
+// dhcpAwait waits for a reply to the current transaction of type want (a
+// NAK also ends a REQUEST) and parses it into l
+func dhcpAwait(l *dhcpLease, want byte) bool {
+	deadline := nanotime() + dhcpReplyWait
+	for waitUntil(dhcpPending, dhcpSocket, deadline) {
+		n, _, port, _ := udpRecvFrom(dhcpSocket, dhcpRx[:])
+		if port != dhcpServerPort || !dhcpParse(dhcpRx[:n], l) {
+			continue
+		}
+		if l.kind == want || (want == dhcpAck && l.kind == dhcpNak) {
+			return true
+		}
+	}
+	return false
+}
+
+// dhcpParse decodes a BOOTREPLY for our transaction
+func dhcpParse(b []byte, l *dhcpLease) bool {
+	if len(b) < bootOptions || b[0] != bootReply || be32(b[bootXID:]) != dhcpXID ||
+		be32(b[bootMagic:]) != dhcpMagicCookie {
+		return false
+	}
+	l.kind = 0
+	l.addr = IP(be32(b[bootYIAddr:]))
+	l.mask, l.router, l.dns, l.server, l.seconds = 0, 0, 0, 0, 0
+
+	for o := bootOptions; o < len(b); {
+		code := b[o]
+		if code == optEnd {
+			break
+		}
+		if code == optPad {
+			o++
+			continue
+		}
+		if o+2 > len(b) || o+2+int(b[o+1]) > len(b) {
+			return false
+		}
+		n := int(b[o+1])
+		v := b[o+2 : o+2+n]
+		switch {
+		case code == optMessageType && n == 1:
+			l.kind = v[0]
+		case code == optSubnetMask && n == 4:
+			l.mask = IP(be32(v))
+		case code == optRouter && n >= 4:
+			l.router = IP(be32(v))
+		case code == optDNS && n >= 4:
+			l.dns = IP(be32(v))
+		case code == optServerID && n == 4:
+			l.server = IP(be32(v))
+		case code == optLeaseTime && n == 4:
+			l.seconds = be32(v)
+		}
+		o += 2 + n
+	}
+	return l.kind != 0
+}
@@ -0,0 +1,69 @@
+package net
+
+// Error reports why a network operation failed
+type Error uint8
+
+const (
+	OK Error = iota
+	// ErrNoInterface means Init has not been given a device
+	ErrNoInterface
+	// ErrNotConfigured means the interface has no address yet
+	ErrNotConfigured
+	// ErrNoRoute means the next hop did not answer ARP, or there is no
+	// gateway for an off-link destination
+	ErrNoRoute
+	// ErrSendFailed means the driver refused the frame
+	ErrSendFailed
+	// ErrTimeout means no answer arrived in time
+	ErrTimeout
+	// ErrTooLarge means the payload does not fit in one frame
+	ErrTooLarge
+	// ErrNoSocket means the socket table is full or the handle is invalid
+	ErrNoSocket
+	// ErrPortInUse means another socket is bound to the port
+	ErrPortInUse
+	// ErrRejected means the peer declined (a DHCP NAK)
+	ErrRejected
+	// ErrRefused means the peer answered a connection attempt with RST
+	ErrRefused
+	// ErrReset means the peer aborted an open connection
+	ErrReset
+	// ErrClosed means the connection can no longer carry data
+	ErrClosed
+	// ErrInvalid means the operation does not apply in the socket's state
+	ErrInvalid
+)
+
+func (e Error) String() string {
+	switch e {
+	case OK:
+		return "ok"
+	case ErrNoInterface:
+		return "no network interface"
+	case ErrNotConfigured:
+		return "interface not configured"
+	case ErrNoRoute:
+		return "no route to host"
+	case ErrSendFailed:
+		return "send failed"
+	case ErrTimeout:
+		return "timed out"
+	case ErrTooLarge:
+		return "message too large"
+	case ErrNoSocket:
+		return "no such socket"
+	case ErrPortInUse:
+		return "port in use"
+	case ErrRejected:
+		return "rejected by server"
+	case ErrRefused:
+		return "connection refused"
+	case ErrReset:
+		return "connection reset"
+	case ErrClosed:
+		return "connection closed"
+	case ErrInvalid:
+		return "invalid argument"
+	}
+	return "unknown error"
+}
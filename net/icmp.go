@@ -0,0 +1,91 @@
+package net
+
+import "github.com/dmarro89/go-dav-os/drivers/netdev"
+
+const (
+	icmpEchoReply   = 0
+	icmpEchoRequest = 8
+	icmpHeaderLen   = 8
+
+	// MaxPingData bounds the echo payload Ping sends
+	MaxPingData = netdev.MTU - ipHeaderLen - icmpHeaderLen
+	pingID      = 0xDA05
+)
+
+var (
+	pingDst      IP
+	pingSeq      uint16
+	pingReplied  bool
+	pingReplyTTL uint8
+)
+
+// Ping sends one echo request with size bytes of data to dst and waits up
+// to timeoutNs for the matching reply. It returns the round trip time and
+// the reply's TTL.
+func Ping(dst IP, seq uint16, size int, timeoutNs uint64) (rttNs uint64, ttl uint8, err Error) {
+	flags := lock.AcquireIRQ()
+	rttNs, ttl, err = ping(dst, seq, size, timeoutNs)
+	lock.ReleaseIRQ(flags)
+	return rttNs, ttl, err
+}
+
+func ping(dst IP, seq uint16, size int, timeoutNs uint64) (uint64, uint8, Error) {
+	if size < 0 || size > MaxPingData {
+		return 0, 0, ErrTooLarge
+	}
+	var mac [netdev.AddrLen]byte
+	if err := route(dst, &mac); err != OK {
+		return 0, 0, err
+	}
+
+	p := txBuf[ipPayload:]
+	p[0] = icmpEchoRequest
+	p[1] = 0
+	putBE16(p[2:], 0)
+	putBE16(p[4:], pingID)
+	putBE16(p[6:], seq)
+	for i := 0; i < size; i++ {
+		p[icmpHeaderLen+i] = byte(i)
+	}
+	putBE16(p[2:], checksum(p[:icmpHeaderLen+size], 0))
+
+	pingDst, pingSeq, pingReplied = dst, seq, false
+	start := nanotime()
+	if err := ipOutput(&mac, dst, ProtoICMP, icmpHeaderLen+size); err != OK {
+		return 0, 0, err
+	}
+	if !waitUntil(pingDone, 0, start+timeoutNs) {
+		return 0, 0, ErrTimeout
+	}
+	return nanotime() - start, pingReplyTTL, OK
+}
+
+func pingDone(int) bool { return pingReplied }
+
+// icmpInput answers echo requests and completes a pending Ping. The reply
+// goes straight back to the sender's Ethernet address, since resolving it
+// would block in the receive path.
+func icmpInput(src, dst IP, ttl uint8, p []byte, srcMAC *[netdev.AddrLen]byte) {
+	if len(p) < icmpHeaderLen || checksum(p, 0) != 0 {
+		return
+	}
+	switch p[0] {
+	case icmpEchoRequest:
+		if !configured || dst != addr || len(p) > netdev.MTU-ipHeaderLen {
+			return
+		}
+		r := txBuf[ipPayload:]
+		for i := 0; i < len(p); i++ {
+			r[i] = p[i]
+		}
+		r[0] = icmpEchoReply
+		putBE16(r[2:], 0)
+		putBE16(r[2:], checksum(r[:len(p)], 0))
+		ipOutput(srcMAC, src, ProtoICMP, len(p))
+	case icmpEchoReply:
+		if src == pingDst && be16(p[4:]) == pingID && be16(p[6:]) == pingSeq {
+			pingReplied = true
+			pingReplyTTL = ttl
+		}
+	}
+}
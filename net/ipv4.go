@@ -0,0 +1,113 @@
+package net
+
+import "github.com/dmarro89/go-dav-os/drivers/netdev"
+
+const (
+	ProtoICMP = 1
+	ProtoTCP  = 6
+	ProtoUDP  = 17
+
+	ipHeaderLen  = 20
+	ipVersionIHL = 0x45
+	ipDefaultTTL = 64
+	// ipFragmentMask covers the more-fragments flag and the offset;
+	// fragmented datagrams are dropped
+	ipFragmentMask = 0x3FFF
+
+	// ipPayload is where transport headers start in txBuf
+	ipPayload = netdev.HeaderLen + ipHeaderLen
+)
+
+var ipID uint16
+
+// onLink reports whether ip is reached directly rather than through the
+// gateway
+func onLink(ip IP) bool {
+	return ip&netmask == addr&netmask
+}
+
+// isBroadcast covers the limited and the subnet-directed broadcast
+func isBroadcast(ip IP) bool {
+	return ip == Broadcast || (configured && netmask != 0 && ip == addr|^netmask)
+}
+
+// route finds the Ethernet address dst is sent to: broadcast, the host
+// itself when it is on-link, otherwise the gateway
+func route(dst IP, mac *[netdev.AddrLen]byte) Error {
+	if iface == nil {
+		return ErrNoInterface
+	}
+	if isBroadcast(dst) {
+		for i := 0; i < netdev.AddrLen; i++ {
+			mac[i] = 0xFF
+		}
+		return OK
+	}
+	if !configured {
+		return ErrNotConfigured
+	}
+	hop := dst
+	if !onLink(dst) {
+		if gateway == 0 {
+			return ErrNoRoute
+		}
+		hop = gateway
+	}
+	return resolve(hop, mac)
+}
+
+// ipOutput wraps the payloadLen bytes at txBuf[ipPayload:] in an IPv4
+// header from our address to dst and sends them to mac
+func ipOutput(mac *[netdev.AddrLen]byte, dst IP, proto byte, payloadLen int) Error {
+	if ipHeaderLen+payloadLen > netdev.MTU {
+		return ErrTooLarge
+	}
+	h := txBuf[netdev.HeaderLen:ipPayload]
+	h[0] = ipVersionIHL
+	h[1] = 0
+	putBE16(h[2:], uint16(ipHeaderLen+payloadLen))
+	ipID++
+	putBE16(h[4:], ipID)
+	putBE16(h[6:], 0)
+	h[8] = ipDefaultTTL
+	h[9] = proto
+	putBE16(h[10:], 0)
+	putBE32(h[12:], uint32(addr))
+	putBE32(h[16:], uint32(dst))
+	putBE16(h[10:], checksum(h, 0))
+	return sendEthernet(mac, EtherTypeIPv4, ipHeaderLen+payloadLen)
+}
+
+// ipInput validates an IPv4 datagram addressed to us and passes its
+// payload up. Until an address is configured every destination is taken,
+// so DHCP replies sent to the offered address still arrive.
+func ipInput(p []byte, srcMAC *[netdev.AddrLen]byte) {
+	if len(p) < ipHeaderLen || p[0]>>4 != 4 {
+		return
+	}
+	hlen := int(p[0]&0xF) * 4
+	total := int(be16(p[2:]))
+	if hlen < ipHeaderLen || total < hlen || total > len(p) || checksum(p[:hlen], 0) != 0 {
+		return
+	}
+	if be16(p[6:])&ipFragmentMask != 0 {
+		return
+	}
+	src := IP(be32(p[12:]))
+	dst := IP(be32(p[16:]))
+	if configured && dst != addr && !isBroadcast(dst) {
+		return
+	}
+
+	payload := p[hlen:total]
+	switch p[9] {
+	case ProtoICMP:
+		icmpInput(src, dst, p[8], payload, srcMAC)
+	case ProtoUDP:
+		udpInput(src, dst, payload)
+	case ProtoTCP:
+		if configured && dst == addr {
+			tcpInput(src, dst, payload, srcMAC)
+		}
+	}
+}
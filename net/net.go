@@ -0,0 +1,243 @@
+// Package net is a minimal IPv4 stack over one netdev interface: Ethernet
+// framing, an ARP cache, IPv4, ICMP echo, UDP sockets, TCP connections and
+// a DHCP client.
+//
+// Everything runs on the caller's thread. Received frames are processed
+// and TCP timers run when Poll is called, either from the kernel's main
+// loop or from waitUntil while an operation blocks for an answer. Frames
+// are built in place in a single transmit buffer, so handlers that reply
+// from the receive path must not block.
+//
+// The shell and user programs call in from tasks of their own, so every
+// exported call that changes the stack takes lock with interrupts off and
+// does its work in an unexported twin. waitUntil drops the lock while it
+// waits for an interrupt, which is where another task gets its turn.
+package net
+
+import (
+	"github.com/dmarro89/go-dav-os/drivers/netdev"
+	"github.com/dmarro89/go-dav-os/kernel/spinlock"
+	ktime "github.com/dmarro89/go-dav-os/kernel/time"
+)
+
+// IP is an IPv4 address in host byte order, so 10.0.2.15 is 0x0A00020F
+type IP uint32
+
+const (
+	// Broadcast is the limited broadcast address 255.255.255.255
+	Broadcast IP = 0xFFFFFFFF
+
+	EtherTypeIPv4 = 0x0800
+	EtherTypeARP  = 0x0806
+
+	// MaxIPStringLen is the longest dotted-quad address
+	MaxIPStringLen = 15
+
+	// Forever is a timeout that never expires
+	Forever = ^uint64(0)
+)
+
+// IPv4 builds the address a.b.c.d
+func IPv4(a, b, c, d byte) IP {
+	return IP(a)<<24 | IP(b)<<16 | IP(c)<<8 | IP(d)
+}
+
+var broadcastMAC = [netdev.AddrLen]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+var (
+	iface *netdev.Device
+
+	addr       IP
+	netmask    IP
+	gateway    IP
+	dns        IP
+	configured bool
+
+	// nanotime and idle are replaced by tests. Blocking operations halt
+	// until the next interrupt between polls.
+	nanotime = ktime.Nanotime
+	idle     = ktime.Idle
+
+	// txBuf is where every outgoing frame is assembled
+	txBuf [netdev.MaxFrame]byte
+
+	lock spinlock.Lock
+)
+
+// Init attaches the stack to d and starts taking its received frames
+func Init(d *netdev.Device) {
+	iface = d
+	netdev.SetReceiver(receive)
+}
+
+// Interface returns the device the stack runs on, or nil
+func Interface() *netdev.Device { return iface }
+
+// Configure sets a static address, replacing any DHCP lease
+func Configure(a, mask, gw IP) {
+	flags := lock.AcquireIRQ()
+	configure(a, mask, gw)
+	lock.ReleaseIRQ(flags)
+}
+
+func configure(a, mask, gw IP) {
+	addr, netmask, gateway = a, mask, gw
+	configured = a != 0
+}
+
+// Configured reports whether the interface has an address
+func Configured() bool { return configured }
+
+func Addr() IP    { return addr }
+func Netmask() IP { return netmask }
+func Gateway() IP { return gateway }
+
+// DNS returns the name server the DHCP server advertised, or 0
+func DNS() IP { return dns }
+
+// ParseIP parses a dotted-quad address such as 10.0.2.2
+func ParseIP(s []byte) (IP, bool) {
+	var ip IP
+	part, digits, dots := uint32(0), 0, 0
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) || s[i] == '.' {
+			if digits == 0 || part > 255 {
+				return 0, false
+			}
+			ip = ip<<8 | IP(part)
+			if i < len(s) {
+				dots++
+			}
+			part, digits = 0, 0
+			continue
+		}
+		c := s[i]
+		if c < '0' || c > '9' || digits == 3 {
+			return 0, false
+		}
+		part = part*10 + uint32(c-'0')
+		digits++
+	}
+	if dots != 3 {
+		return 0, false
+	}
+	return ip, true
+}
+
+// FormatIP writes ip in dotted-quad form into buf and returns the length
+func FormatIP(ip IP, buf *[MaxIPStringLen]byte) int {
+	n := 0
+	for shift := 24; shift >= 0; shift -= 8 {
+		b := byte(ip >> uint(shift))
+		if b >= 100 {
+			buf[n] = '0' + b/100
+			n++
+		}
+		if b >= 10 {
+			buf[n] = '0' + b/10%10
+			n++
+		}
+		buf[n] = '0' + b%10
+		n++
+		if shift > 0 {
+			buf[n] = '.'
+			n++
+		}
+	}
+	return n
+}
+
+func sameMAC(a, b *[netdev.AddrLen]byte) bool {
+	for i := 0; i < netdev.AddrLen; i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func copyMAC(dst []byte, src *[netdev.AddrLen]byte) {
+	for i := 0; i < netdev.AddrLen; i++ {
+		dst[i] = src[i]
+	}
+}
+
+// receive is the netdev receiver: it checks the Ethernet destination and
+// hands the payload to ARP or IPv4
+func receive(d *netdev.Device, frame []byte) {
+	if d != iface || len(frame) < netdev.HeaderLen {
+		return
+	}
+	var dst, src [netdev.AddrLen]byte
+	for i := 0; i < netdev.AddrLen; i++ {
+		dst[i] = frame[i]
+		src[i] = frame[netdev.AddrLen+i]
+	}
+	if !sameMAC(&dst, &iface.MAC) && !sameMAC(&dst, &broadcastMAC) {
+		return
+	}
+	payload := frame[netdev.HeaderLen:]
+	switch be16(frame[2*netdev.AddrLen:]) {
+	case EtherTypeARP:
+		arpInput(payload)
+	case EtherTypeIPv4:
+		ipInput(payload, &src)
+	}
+}
+
+// sendEthernet frames the payloadLen bytes already at
+// txBuf[netdev.HeaderLen:] for dst and transmits them
+func sendEthernet(dst *[netdev.AddrLen]byte, etherType uint16, payloadLen int) Error {
+	if iface == nil {
+		return ErrNoInterface
+	}
+	copyMAC(txBuf[0:], dst)
+	copyMAC(txBuf[netdev.AddrLen:], &iface.MAC)
+	putBE16(txBuf[2*netdev.AddrLen:], etherType)
+	if !netdev.Transmit(iface, txBuf[:netdev.HeaderLen+payloadLen]) {
+		return ErrSendFailed
+	}
+	return OK
+}
+
+// Poll reaps the interfaces' rings, processing every received frame, and
+// runs the TCP timers
+func Poll() {
+	flags := lock.AcquireIRQ()
+	poll()
+	lock.ReleaseIRQ(flags)
+}
+
+func poll() {
+	netdev.PollAll()
+	tcpTimers()
+}
+
+// deadlineAfter turns a timeout into a deadline; Forever never expires
+func deadlineAfter(timeoutNs uint64) uint64 {
+	now := nanotime()
+	if timeoutNs > Forever-now {
+		return Forever
+	}
+	return now + timeoutNs
+}
+
+// waitUntil polls until done(arg) reports true or the clock reaches
+// deadline. Other tasks may use the stack while it waits, so what done
+// looks at is passed in arg rather than left in a variable.
+func waitUntil(done func(arg int) bool, arg int, deadline uint64) bool {
+	for {
+		poll()
+		if done(arg) {
+			return true
+		}
+		if nanotime() >= deadline {
+			return false
+		}
+		if idle != nil {
+			lock.Release()
+			idle()
+			lock.Acquire()
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package net
+
+import (
+	"testing"
+
+	"github.com/dmarro89/go-dav-os/drivers/netdev"
+)
+
+// The fake peer plays QEMU's slirp: gateway 10.0.2.2 answers ARP, ping
+// for any address and DHCP, handing out 10.0.2.15
+var (
+	gatewayIP  = IPv4(10, 0, 2, 2)
+	offeredIP  = IPv4(10, 0, 2, 15)
+	gatewayMAC = [netdev.AddrLen]byte{0x52, 0x55, 0x0A, 0x00, 0x02, 0x02}
+	ourMAC     = [netdev.AddrLen]byte{0x52, 0x54, 0x00, 0x12, 0x34, 0x56}
+
+	sentFrames [][]byte
+	pending    [][]byte
+	peerSilent bool
+	peerNak    bool
+	fakeNow    uint64
+)
+
+func fakeClock() uint64 {
+	fakeNow += 1000000
+	return fakeNow
+}
+
+func peerSend(unit int, frame []byte) bool {
+	f := append([]byte(nil), frame...)
+	sentFrames = append(sentFrames, f)
+	if !peerSilent {
+		peerAnswer(f)
+	}
+	return true
+}
+
+func peerPoll(unit int) {
+	queued := pending
+	pending = nil
+	for _, f := range queued {
+		netdev.Deliver(netdev.At(0), f)
+	}
+}
+
+func setup(t *testing.T) *netdev.Device {
+	t.Helper()
+	netdev.ResetForTesting()
+	ResetForTesting()
+	sentFrames, pending, peerSilent, peerNak = nil, nil, false, false
+	tcpResponder = nil
+	nanotime = fakeClock
+	t.Cleanup(func() {
+		ResetForTesting()
+		netdev.ResetForTesting()
+	})
+	d := &netdev.Device{Name: "eth0", MAC: ourMAC, MTU: netdev.MTU, LinkUp: true, Send: peerSend, Poll: peerPoll}
+	netdev.Register(d)
+	Init(d)
+	return d
+}
+
+func ethernet(dst, src [netdev.AddrLen]byte, etherType uint16, payload []byte) []byte {
+	f := make([]byte, netdev.HeaderLen, netdev.HeaderLen+len(payload))
+	copy(f, dst[:])
+	copy(f[6:], src[:])
+	putBE16(f[12:], etherType)
+	return append(f, payload...)
+}
+
+func ipPacket(src, dst IP, proto, ttl byte, payload []byte) []byte {
+	h := make([]byte, ipHeaderLen, ipHeaderLen+len(payload))
+	h[0] = ipVersionIHL
+	putBE16(h[2:], uint16(ipHeaderLen+len(payload)))
+	h[8] = ttl
+	h[9] = proto
+	putBE32(h[12:], uint32(src))
+	putBE32(h[16:], uint32(dst))
+	putBE16(h[10:], checksum(h, 0))
+	return append(h, payload...)
+}
+
+func udpPacket(src, dst IP, srcPort, dstPort uint16, data []byte) []byte {
+	u := make([]byte, udpHeaderLen, udpHeaderLen+len(data))
+	putBE16(u[0:], srcPort)
+	putBE16(u[2:], dstPort)
+	putBE16(u[4:], uint16(udpHeaderLen+len(data)))
+	u = append(u, data...)
+	putBE16(u[6:], checksum(u, pseudoSum(src, dst, ProtoUDP, len(u))))
+	return ipPacket(src, dst, ProtoUDP, 64, u)
+}
+
+// peerAnswer queues what slirp would send back for frame
+func peerAnswer(f []byte) {
+	p := f[netdev.HeaderLen:]
+	switch be16(f[12:]) {
+	case EtherTypeARP:
+		if be16(p[6:]) != arpOpRequest || IP(be32(p[arpTargetIP:])) != gatewayIP {
+			return
+		}
+		r := make([]byte, arpPacketLen)
+		copy(r, p[:8])
+		putBE16(r[6:], arpOpReply)
+		copy(r[arpSenderMAC:], gatewayMAC[:])
+		putBE32(r[arpSenderIP:], uint32(gatewayIP))
+		copy(r[arpTargetMAC:], p[arpSenderMAC:arpSenderMAC+6])
+		copy(r[arpTargetIP:], p[arpSenderIP:arpSenderIP+4])
+		pending = append(pending, ethernet(ourMAC, gatewayMAC, EtherTypeARP, r))
+	case EtherTypeIPv4:
+		src, dst := IP(be32(p[12:])), IP(be32(p[16:]))
+		body := p[ipHeaderLen:]
+		switch p[9] {
+		case ProtoICMP:
+			if body[0] != icmpEchoRequest {
+				return
+			}
+			r := append([]byte(nil), body...)
+			r[0] = icmpEchoReply
+			putBE16(r[2:], 0)
+			putBE16(r[2:], checksum(r, 0))
+			pending = append(pending, ethernet(ourMAC, gatewayMAC, EtherTypeIPv4, ipPacket(dst, src, ProtoICMP, 255, r)))
+		case ProtoUDP:
+			if be16(body[2:]) == dhcpServerPort {
+				peerDHCP(body[udpHeaderLen:])
+			}
+		case ProtoTCP:
+			if tcpResponder != nil {
+				tcpResponder(parseSegment(f))
+			}
+		}
+	}
+}
+
+func peerDHCP(req []byte) {
+	kind := byte(0)
+	for o := bootOptions; req[o] != optEnd; o += 2 + int(req[o+1]) {
+		if req[o] == optMessageType {
+			kind = req[o+2]
+		}
+	}
+	reply := dhcpOffer
+	if kind == dhcpRequest {
+		reply = dhcpAck
+		if peerNak {
+			reply = dhcpNak
+		}
+	}
+	r := make([]byte, bootMinLen)
+	r[0] = bootReply
+	copy(r[bootXID:], req[bootXID:bootXID+4])
+	putBE32(r[bootYIAddr:], uint32(offeredIP))
+	copy(r[bootCHAddr:], req[bootCHAddr:bootCHAddr+6])
+	putBE32(r[bootMagic:], dhcpMagicCookie)
+	opts := []byte{
+		optMessageType, 1, byte(reply),
+		optServerID, 4, 10, 0, 2, 2,
+		optSubnetMask, 4, 255, 255, 255, 0,
+		optRouter, 4, 10, 0, 2, 2,
+		optDNS, 4, 10, 0, 2, 3,
+		optLeaseTime, 4, 0, 0, 0x0E, 0x10,
+		optEnd,
+	}
+	copy(r[bootOptions:], opts)
+	pending = append(pending, ethernet(broadcastMAC, gatewayMAC, EtherTypeIPv4,
+		udpPacket(gatewayIP, Broadcast, dhcpServerPort, dhcpClientPort, r)))
+}
+
+func TestChecksumRFC1071Example(t *testing.T) {
+	b := []byte{0x00, 0x01, 0xF2, 0x03, 0xF4, 0xF5, 0xF6, 0xF7}
+	if got := checksum(b, 0); got != 0x220D {
+		t.Fatalf("checksum = 0x%04x, want 0x220d", got)
+	}
+	odd := []byte{0x01, 0x02, 0x03}
+	if got := checksum(odd, 0); got != ^uint16(0x0102+0x0300) {
+		t.Fatalf("odd-length checksum = 0x%04x", got)
+	}
+}
+
+func TestParseAndFormatIP(t *testing.T) {
+	tests := []struct {
+		in   string
+		want IP
+		ok   bool
+	}{
+		{"10.0.2.15", IPv4(10, 0, 2, 15), true},
+		{"255.255.255.255", Broadcast, true},
+		{"0.0.0.0", 0, true},
+		{"10.0.2", 0, false},
+		{"10.0.2.256", 0, false},
+		{"10..2.1", 0, false},
+		{"10.0.2.1.", 0, false},
+		{"1.2.3.4x", 0, false},
+		{"1000.2.3.4", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseIP([]byte(tt.in))
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ParseIP(%q) = 0x%08x, %v, want 0x%08x, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+		if !tt.ok {
+			continue
+		}
+		var buf [MaxIPStringLen]byte
+		if s := string(buf[:FormatIP(got, &buf)]); s != tt.in {
+			t.Errorf("FormatIP(0x%08x) = %q, want %q", got, s, tt.in)
+		}
+	}
+}
+
+func TestARPResolvesAndAnswers(t *testing.T) {
+	setup(t)
+	Configure(offeredIP, IPv4(255, 255, 255, 0), gatewayIP)
+
+	var mac [netdev.AddrLen]byte
+	if err := resolve(gatewayIP, &mac); err != OK || mac != gatewayMAC {
+		t.Fatalf("resolve = %v, %x; want gateway MAC", err, mac)
+	}
+	n := len(sentFrames)
+	if err := resolve(gatewayIP, &mac); err != OK || len(sentFrames) != n {
+		t.Fatalf("second resolve sent %d frames, want a cache hit", len(sentFrames)-n)
+	}
+
+	// A host asking for our address gets a reply, and is learnt
+	other := IPv4(10, 0, 2, 7)
+	otherMAC := [netdev.AddrLen]byte{2, 0, 0, 0, 0, 7}
+	req := make([]byte, arpPacketLen)
+	putBE16(req[0:], arpHTypeEther)
+	putBE16(req[2:], EtherTypeIPv4)
+	req[4], req[5] = 6, 4
+	putBE16(req[6:], arpOpRequest)
+	copy(req[arpSenderMAC:], otherMAC[:])
+	putBE32(req[arpSenderIP:], uint32(other))
+	putBE32(req[arpTargetIP:], uint32(offeredIP))
+	receive(netdev.At(0), ethernet(broadcastMAC, otherMAC, EtherTypeARP, req))
+
+	reply := sentFrames[len(sentFrames)-1]
+	p := reply[netdev.HeaderLen:]
+	if be16(reply[12:]) != EtherTypeARP || be16(p[6:]) != arpOpReply ||
+		IP(be32(p[arpSenderIP:])) != offeredIP || IP(be32(p[arpTargetIP:])) != other {
+		t.Fatalf("ARP reply = % x", reply)
+	}
+	if e := arpFind(other); e == nil || e.MAC != otherMAC {
+		t.Fatalf("requester not added to the ARP cache")
+	}
+
+	peerSilent = true
+	if err := resolve(IPv4(10, 0, 2, 99), &mac); err != ErrNoRoute {
+		t.Fatalf("resolve of a silent host = %v, want ErrNoRoute", err)
+	}
+}
+
+func TestPingRoutesThroughGateway(t *testing.T) {
+	setup(t)
+	Configure(offeredIP, IPv4(255, 255, 255, 0), gatewayIP)
+
+	rtt, ttl, err := Ping(IPv4(8, 8, 8, 8), 1, 56, 1000000000)
+	if err != OK || ttl != 255 || rtt == 0 {
+		t.Fatalf("Ping = %d ns, ttl %d, %v", rtt, ttl, err)
+	}
+	echo := sentFrames[len(sentFrames)-1]
+	var dst [netdev.AddrLen]byte
+	copy(dst[:], echo)
+	if dst != gatewayMAC || IP(be32(echo[netdev.HeaderLen+16:])) != IPv4(8, 8, 8, 8) {
+		t.Fatalf("off-link echo not sent to the gateway: % x", echo[:34])
+	}
+	if checksum(echo[netdev.HeaderLen:ipPayload], 0) != 0 || checksum(echo[ipPayload:], 0) != 0 {
+		t.Fatalf("echo request has a bad IP or ICMP checksum")
+	}
+
+	peerSilent = true
+	if _, _, err := Ping(gatewayIP, 2, 56, 50000000); err != ErrTimeout {
+		t.Fatalf("Ping with no reply = %v, want ErrTimeout", err)
+	}
+	if _, _, err := Ping(gatewayIP, 3, MaxPingData+1, 50000000); err != ErrTooLarge {
+		t.Fatalf("oversized Ping = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestEchoRequestIsAnswered(t *testing.T) {
+	setup(t)
+	Configure(offeredIP, IPv4(255, 255, 255, 0), gatewayIP)
+
+	echo := []byte{icmpEchoRequest, 0, 0, 0, 0x12, 0x34, 0, 9, 'h', 'i'}
+	putBE16(echo[2:], checksum(echo, 0))
+	receive(netdev.At(0), ethernet(ourMAC, gatewayMAC, EtherTypeIPv4, ipPacket(gatewayIP, offeredIP, ProtoICMP, 64, echo)))
+
+	if len(sentFrames) != 1 {
+		t.Fatalf("sent %d frames, want one echo reply", len(sentFrames))
+	}
+	r := sentFrames[0]
+	icmp := r[ipPayload:]
+	if IP(be32(r[netdev.HeaderLen+16:])) != gatewayIP || icmp[0] != icmpEchoReply ||
+		be16(icmp[4:]) != 0x1234 || string(icmp[8:10]) != "hi" || checksum(icmp[:10], 0) != 0 {
+		t.Fatalf("echo reply = % x", r)
+	}
+
+	// Not for us: ignored
+	receive(netdev.At(0), ethernet(ourMAC, gatewayMAC, EtherTypeIPv4, ipPacket(gatewayIP, IPv4(10, 0, 2, 16), ProtoICMP, 64, echo)))
+	if len(sentFrames) != 1 {
+		t.Fatalf("answered an echo request for another address")
+	}
+}
+
+func TestUDPSendAndReceive(t *testing.T) {
+	setup(t)
+	Configure(offeredIP, IPv4(255, 255, 255, 0), gatewayIP)
+
+	sock, err := UDPOpen(0)
+	if err != OK || UDPPort(sock) != ephemeralFirst {
+		t.Fatalf("UDPOpen(0) = %d, %v, port %d", sock, err, UDPPort(sock))
+	}
+	if _, err := UDPOpen(ephemeralFirst); err != ErrPortInUse {
+		t.Fatalf("binding a used port = %v, want ErrPortInUse", err)
+	}
+
+	if err := UDPSendTo(sock, gatewayIP, 7, []byte("hello")); err != OK {
+		t.Fatalf("UDPSendTo = %v", err)
+	}
+	f := sentFrames[len(sentFrames)-1]
+	u := f[ipPayload:]
+	if be16(u[2:]) != 7 || string(u[8:13]) != "hello" ||
+		checksum(u[:13], pseudoSum(offeredIP, gatewayIP, ProtoUDP, 13)) != 0 {
+		t.Fatalf("UDP datagram = % x", u[:13])
+	}
+
+	in := udpPacket(gatewayIP, offeredIP, 7, ephemeralFirst, []byte("world"))
+	receive(netdev.At(0), ethernet(ourMAC, gatewayMAC, EtherTypeIPv4, in))
+	bad := udpPacket(gatewayIP, offeredIP, 7, ephemeralFirst, []byte("xx"))
+	bad[len(bad)-1] ^= 0xFF
+	receive(netdev.At(0), ethernet(ourMAC, gatewayMAC, EtherTypeIPv4, bad))
+
+	var buf [16]byte
+	n, src, port, ok := UDPRecvFrom(sock, buf[:])
+	if !ok || string(buf[:n]) != "world" || src != gatewayIP || port != 7 {
+		t.Fatalf("UDPRecvFrom = %q from 0x%08x:%d, %v", buf[:n], src, port, ok)
+	}
+	if _, _, _, ok := UDPRecvFrom(sock, buf[:]); ok {
+		t.Fatalf("a datagram with a bad checksum was queued")
+	}
+
+	for i := 0; i < udpQueueLen+2; i++ {
+		receive(netdev.At(0), ethernet(ourMAC, gatewayMAC, EtherTypeIPv4, in))
+	}
+	if UDPPending(sock) != udpQueueLen {
+		t.Fatalf("%d datagrams queued, want the queue capped at %d", UDPPending(sock), udpQueueLen)
+	}
+	UDPClose(sock)
+	if err := UDPSendTo(sock, gatewayIP, 7, nil); err != ErrNoSocket {
+		t.Fatalf("send on a closed socket = %v", err)
+	}
+}
+
+func TestDHCPConfiguresInterface(t *testing.T) {
+	setup(t)
+	if err := DHCP(); err != OK {
+		t.Fatalf("DHCP = %v", err)
+	}
+	if !Configured() || Addr() != offeredIP || Netmask() != IPv4(255, 255, 255, 0) ||
+		Gateway() != gatewayIP || DNS() != IPv4(10, 0, 2, 3) || LeaseSeconds() != 3600 {
+		t.Fatalf("config = 0x%08x/0x%08x gw 0x%08x dns 0x%08x lease %d",
+			Addr(), Netmask(), Gateway(), DNS(), LeaseSeconds())
+	}
+	if len(sentFrames) != 2 {
+		t.Fatalf("sent %d frames, want DISCOVER and REQUEST", len(sentFrames))
+	}
+	req := sentFrames[1]
+	if IP(be32(req[netdev.HeaderLen+12:])) != 0 || IP(be32(req[netdev.HeaderLen+16:])) != Broadcast {
+		t.Fatalf("REQUEST must go from 0.0.0.0 to broadcast")
+	}
+	if UDPPort(0) != 0 {
+		t.Fatalf("DHCP left its socket open")
+	}
+}
+
+func TestDHCPFailures(t *testing.T) {
+	setup(t)
+	peerNak = true
+	if err := DHCP(); err != ErrRejected || Configured() {
+		t.Fatalf("DHCP with a NAK = %v, configured %v", err, Configured())
+	}
+
+	peerSilent = true
+	sentFrames = nil
+	if err := DHCP(); err != ErrTimeout {
+		t.Fatalf("DHCP with no server = %v, want ErrTimeout", err)
+	}
+	if len(sentFrames) != dhcpRetries {
+		t.Fatalf("sent %d DISCOVERs, want %d", len(sentFrames), dhcpRetries)
+	}
+}
@@ -0,0 +1,974 @@
+package net
+
+import (
+	"github.com/dmarro89/go-dav-os/drivers/netdev"
+	ktime "github.com/dmarro89/go-dav-os/kernel/time"
+)
+
+// TCPState is a connection's RFC 793 state
+type TCPState uint8
+
+const (
+	StateClosed TCPState = iota
+	StateListen
+	StateSynSent
+	StateSynReceived
+	StateEstablished
+	StateFinWait1
+	StateFinWait2
+	StateCloseWait
+	StateClosing
+	StateLastAck
+	StateTimeWait
+)
+
+func (s TCPState) String() string {
+	switch s {
+	case StateClosed:
+		return "CLOSED"
+	case StateListen:
+		return "LISTEN"
+	case StateSynSent:
+		return "SYN-SENT"
+	case StateSynReceived:
+		return "SYN-RECEIVED"
+	case StateEstablished:
+		return "ESTABLISHED"
+	case StateFinWait1:
+		return "FIN-WAIT-1"
+	case StateFinWait2:
+		return "FIN-WAIT-2"
+	case StateCloseWait:
+		return "CLOSE-WAIT"
+	case StateClosing:
+		return "CLOSING"
+	case StateLastAck:
+		return "LAST-ACK"
+	case StateTimeWait:
+		return "TIME-WAIT"
+	}
+	return "?"
+}
+
+const (
+	MaxTCPConns = 8
+	// TCPBufferSize is each connection's send and receive buffer
+	TCPBufferSize = 4096
+	// MaxTCPBacklog bounds the connections waiting on one listener
+	MaxTCPBacklog = 4
+
+	tcpHeaderLen  = 20
+	tcpMSS        = netdev.MTU - ipHeaderLen - tcpHeaderLen
+	tcpDefaultMSS = 536
+
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpPSH = 0x08
+	tcpACK = 0x10
+
+	tcpOptEnd    = 0
+	tcpOptNOP    = 1
+	tcpOptMSS    = 2
+	tcpOptMSSLen = 4
+
+	// Retransmission timeout bounds (RFC 6298), doubled on every expiry
+	tcpInitialRTO = ktime.NanosPerSecond
+	tcpMinRTO     = 200 * ktime.NanosPerMillisecond
+	tcpMaxRTO     = 60 * ktime.NanosPerSecond
+	tcpMaxRetries = 8
+	// tcpTimeWaitNs stands in for 2*MSL; a short wait is enough on a
+	// LAN and frees the slot sooner
+	tcpTimeWaitNs = 2 * ktime.NanosPerSecond
+)
+
+// tcpConn is one slot of the connection table. Its index is the handle
+// the TCP* functions take. Sequence space starts at iss with the SYN; the
+// send ring holds the bytes from txSeq on, sent or not, until acked.
+type tcpConn struct {
+	state TCPState
+	// open means the application still holds the handle; a slot is free
+	// once it is closed and no longer open
+	open bool
+	err  Error
+
+	localPort  uint16
+	remotePort uint16
+	remoteIP   IP
+	remoteMAC  [netdev.AddrLen]byte
+
+	// parent is the listener a passively opened connection came from,
+	// -1 otherwise; pending means it has not been accepted yet
+	parent  int
+	pending bool
+	born    uint64
+	backlog int
+
+	iss    uint32
+	sndUna uint32
+	sndNxt uint32
+	sndWnd uint32
+	mss    int
+	txSeq  uint32
+	tx     [TCPBufferSize]byte
+	txHead int
+	txLen  int
+	// finQueued is set by TCPClose: a FIN follows the buffered data
+	finQueued bool
+	finSent   bool
+	// probe lets one byte past a zero window out to learn when it opens
+	probe bool
+
+	rcvNxt      uint32
+	rx          [TCPBufferSize]byte
+	rxHead      int
+	rxLen       int
+	finReceived bool
+
+	rto         uint64
+	rtoDeadline uint64
+	retries     int
+	rttTiming   bool
+	rttSeq      uint32
+	rttStart    uint64
+	srtt        uint64
+	rttvar      uint64
+	timeWaitEnd uint64
+}
+
+var (
+	tcpConns [MaxTCPConns]tcpConn
+)
+
+func seqLT(a, b uint32) bool { return int32(a-b) < 0 }
+func seqLE(a, b uint32) bool { return int32(a-b) <= 0 }
+
+// TCPStateOf returns the state of the connection behind handle c
+func TCPStateOf(c int) TCPState {
+	if k := tcpHandle(c); k != nil {
+		return k.state
+	}
+	return StateClosed
+}
+
+// TCPPeer returns the remote address and port of connection c
+func TCPPeer(c int) (IP, uint16) {
+	if k := tcpHandle(c); k != nil {
+		return k.remoteIP, k.remotePort
+	}
+	return 0, 0
+}
+
+// TCPLocalPort returns the local port of connection c
+func TCPLocalPort(c int) uint16 {
+	if k := tcpHandle(c); k != nil {
+		return k.localPort
+	}
+	return 0
+}
+
+func tcpHandle(c int) *tcpConn {
+	if c < 0 || c >= MaxTCPConns || !tcpConns[c].open {
+		return nil
+	}
+	return &tcpConns[c]
+}
+
+// tcpAlloc claims a free slot and resets it
+func tcpAlloc() int {
+	for i := 0; i < MaxTCPConns; i++ {
+		k := &tcpConns[i]
+		if k.open || k.state != StateClosed {
+			continue
+		}
+		k.err = OK
+		k.localPort, k.remotePort, k.remoteIP = 0, 0, 0
+		k.parent, k.pending, k.backlog = -1, false, 0
+		k.born = nanotime()
+		k.sndWnd, k.mss = 0, tcpDefaultMSS
+		k.txHead, k.txLen = 0, 0
+		k.finQueued, k.finSent, k.probe = false, false, false
+		k.rcvNxt, k.rxHead, k.rxLen, k.finReceived = 0, 0, 0, false
+		k.rto, k.rtoDeadline, k.retries = tcpInitialRTO, 0, 0
+		k.rttTiming, k.srtt, k.rttvar = false, 0, 0
+		k.iss = uint32(nanotime()/4000) + uint32(i)<<24
+		k.sndUna, k.sndNxt, k.txSeq = k.iss, k.iss, k.iss+1
+		return i
+	}
+	return -1
+}
+
+// tcpPortInUse reports whether a listener or a connection owns port
+func tcpPortInUse(port uint16) bool {
+	for i := 0; i < MaxTCPConns; i++ {
+		k := &tcpConns[i]
+		if k.state != StateClosed && k.localPort == port {
+			return true
+		}
+	}
+	return false
+}
+
+// TCPListen opens a listener on port that queues up to backlog incoming
+// connections for TCPAccept
+func TCPListen(port uint16, backlog int) (int, Error) {
+	flags := lock.AcquireIRQ()
+	c, err := tcpListen(port, backlog)
+	lock.ReleaseIRQ(flags)
+	return c, err
+}
+
+func tcpListen(port uint16, backlog int) (int, Error) {
+	if port == 0 {
+		return -1, ErrInvalid
+	}
+	if tcpPortInUse(port) {
+		return -1, ErrPortInUse
+	}
+	c := tcpAlloc()
+	if c < 0 {
+		return -1, ErrNoSocket
+	}
+	if backlog < 1 || backlog > MaxTCPBacklog {
+		backlog = MaxTCPBacklog
+	}
+	k := &tcpConns[c]
+	k.open = true
+	k.state = StateListen
+	k.localPort = port
+	k.backlog = backlog
+	return c, OK
+}
+
+// TCPAccept waits up to timeoutNs for a connection on listener l to be
+// established and returns its handle
+func TCPAccept(l int, timeoutNs uint64) (int, Error) {
+	flags := lock.AcquireIRQ()
+	c, err := tcpAccept(l, timeoutNs)
+	lock.ReleaseIRQ(flags)
+	return c, err
+}
+
+func tcpAccept(l int, timeoutNs uint64) (int, Error) {
+	k := tcpHandle(l)
+	if k == nil || k.state != StateListen {
+		return -1, ErrInvalid
+	}
+	if !waitUntil(tcpAcceptReady, l, deadlineAfter(timeoutNs)) {
+		return -1, ErrTimeout
+	}
+	c := tcpReadyChild(l)
+	child := &tcpConns[c]
+	child.pending = false
+	child.open = true
+	return c, OK
+}
+
+func tcpAcceptReady(l int) bool { return tcpReadyChild(l) >= 0 }
+
+// tcpReadyChild returns the oldest established, unaccepted connection of
+// listener l
+func tcpReadyChild(l int) int {
+	best := -1
+	for i := 0; i < MaxTCPConns; i++ {
+		k := &tcpConns[i]
+		if k.parent != l || !k.pending || k.state == StateClosed || k.state == StateSynReceived {
+			continue
+		}
+		if best < 0 || k.born < tcpConns[best].born {
+			best = i
+		}
+	}
+	return best
+}
+
+// TCPConnect opens a connection to dst:port from an ephemeral port and
+// waits up to timeoutNs for the handshake
+func TCPConnect(dst IP, port uint16, timeoutNs uint64) (int, Error) {
+	flags := lock.AcquireIRQ()
+	c, err := tcpConnect(dst, port, timeoutNs)
+	lock.ReleaseIRQ(flags)
+	return c, err
+}
+
+func tcpConnect(dst IP, port uint16, timeoutNs uint64) (int, Error) {
+	var mac [netdev.AddrLen]byte
+	if err := route(dst, &mac); err != OK {
+		return -1, err
+	}
+	local := tcpEphemeral()
+	if local == 0 {
+		return -1, ErrPortInUse
+	}
+	c := tcpAlloc()
+	if c < 0 {
+		return -1, ErrNoSocket
+	}
+	k := &tcpConns[c]
+	k.open = true
+	k.state = StateSynSent
+	k.localPort = local
+	k.remoteIP = dst
+	k.remotePort = port
+	k.remoteMAC = mac
+	k.output()
+
+	if !waitUntil(tcpConnectDone, c, deadlineAfter(timeoutNs)) {
+		k.abort(ErrTimeout)
+		k.open = false
+		return -1, ErrTimeout
+	}
+	if k.state == StateClosed {
+		err := k.err
+		k.open = false
+		return -1, err
+	}
+	return c, OK
+}
+
+func tcpConnectDone(c int) bool { return tcpConns[c].state != StateSynSent }
+
+func tcpEphemeral() uint16 {
+	for tries := 0; tries <= ephemeralLast-ephemeralFirst; tries++ {
+		port := nextEphemeral
+		if nextEphemeral == ephemeralLast {
+			nextEphemeral = ephemeralFirst
+		} else {
+			nextEphemeral++
+		}
+		if !tcpPortInUse(port) {
+			return port
+		}
+	}
+	return 0
+}
+
+// canSend reports whether the application may still queue data
+func (k *tcpConn) canSend() bool {
+	return !k.finQueued && (k.state == StateEstablished || k.state == StateCloseWait)
+}
+
+// TCPSend queues data on connection c, waiting up to timeoutNs for room in
+// the send buffer, and returns how much was queued
+func TCPSend(c int, data []byte, timeoutNs uint64) (int, Error) {
+	flags := lock.AcquireIRQ()
+	sent, err := tcpSend(c, data, timeoutNs)
+	lock.ReleaseIRQ(flags)
+	return sent, err
+}
+
+func tcpSend(c int, data []byte, timeoutNs uint64) (int, Error) {
+	k := tcpHandle(c)
+	if k == nil {
+		return 0, ErrNoSocket
+	}
+	deadline := deadlineAfter(timeoutNs)
+	sent := 0
+	for sent < len(data) {
+		if !k.canSend() {
+			if k.err != OK {
+				return sent, k.err
+			}
+			return sent, ErrClosed
+		}
+		n := TCPBufferSize - k.txLen
+		if n == 0 {
+			if !waitUntil(tcpSendRoom, c, deadline) {
+				return sent, ErrTimeout
+			}
+			continue
+		}
+		if n > len(data)-sent {
+			n = len(data) - sent
+		}
+		for i := 0; i < n; i++ {
+			k.tx[(k.txHead+k.txLen+i)%TCPBufferSize] = data[sent+i]
+		}
+		k.txLen += n
+		sent += n
+		k.output()
+	}
+	return sent, OK
+}
+
+func tcpSendRoom(c int) bool {
+	k := &tcpConns[c]
+	return k.txLen < TCPBufferSize || !k.canSend()
+}
+
+// TCPRecv waits up to timeoutNs for data on connection c and copies what
+// is buffered into buf. It returns 0 and OK once the peer has closed its
+// side and everything was read.
+func TCPRecv(c int, buf []byte, timeoutNs uint64) (int, Error) {
+	flags := lock.AcquireIRQ()
+	n, err := tcpRecv(c, buf, timeoutNs)
+	lock.ReleaseIRQ(flags)
+	return n, err
+}
+
+func tcpRecv(c int, buf []byte, timeoutNs uint64) (int, Error) {
+	k := tcpHandle(c)
+	if k == nil {
+		return 0, ErrNoSocket
+	}
+	if k.state == StateListen || k.state == StateSynSent {
+		return 0, ErrInvalid
+	}
+	if !waitUntil(tcpRecvReady, c, deadlineAfter(timeoutNs)) {
+		return 0, ErrTimeout
+	}
+	if k.rxLen == 0 {
+		if k.finReceived {
+			return 0, OK
+		}
+		if k.err != OK {
+			return 0, k.err
+		}
+		return 0, ErrClosed
+	}
+
+	n := len(buf)
+	if n > k.rxLen {
+		n = k.rxLen
+	}
+	wasClosed := k.rxLen == TCPBufferSize
+	for i := 0; i < n; i++ {
+		buf[i] = k.rx[(k.rxHead+i)%TCPBufferSize]
+	}
+	k.rxHead = (k.rxHead + n) % TCPBufferSize
+	k.rxLen -= n
+	// Tell a peer that was stopped by a full window that it opened
+	if wasClosed && n > 0 && k.state != StateClosed {
+		k.sendSegment(k.sndNxt, tcpACK, 0, 0, false)
+	}
+	return n, OK
+}
+
+func tcpRecvReady(c int) bool {
+	k := &tcpConns[c]
+	return k.rxLen > 0 || k.finReceived || k.state == StateClosed
+}
+
+// TCPClose gives up handle c. Buffered data is still delivered, followed
+// by a FIN; the slot is reused once the close handshake completes. A
+// listener drops the connections nobody accepted.
+func TCPClose(c int) {
+	flags := lock.AcquireIRQ()
+	tcpClose(c)
+	lock.ReleaseIRQ(flags)
+}
+
+func tcpClose(c int) {
+	k := tcpHandle(c)
+	if k == nil {
+		return
+	}
+	k.open = false
+	switch k.state {
+	case StateListen:
+		k.state = StateClosed
+		for i := 0; i < MaxTCPConns; i++ {
+			child := &tcpConns[i]
+			if child.parent == c && child.pending && child.state != StateClosed {
+				child.sendSegment(child.sndNxt, tcpRST|tcpACK, 0, 0, false)
+				child.abort(ErrReset)
+			}
+		}
+	case StateSynSent:
+		k.abort(ErrClosed)
+	case StateEstablished, StateCloseWait:
+		k.finQueued = true
+		k.output()
+	}
+}
+
+func (k *tcpConn) abort(err Error) {
+	k.state = StateClosed
+	k.err = err
+	k.rtoDeadline = 0
+	k.pending = false
+}
+
+// armTimer starts the retransmission timer unless it is running
+func (k *tcpConn) armTimer() {
+	if k.rtoDeadline == 0 {
+		k.rtoDeadline = nanotime() + k.rto
+	}
+}
+
+// output sends whatever the state, the send window and the buffer allow:
+// the SYN, data segments of at most one MSS, then the FIN
+func (k *tcpConn) output() {
+	switch {
+	case k.state == StateSynSent && k.sndNxt == k.iss:
+		k.sendSegment(k.iss, tcpSYN, 0, 0, true)
+		k.sndNxt = k.iss + 1
+		k.armTimer()
+		return
+	case k.state == StateSynReceived && k.sndNxt == k.iss:
+		k.sendSegment(k.iss, tcpSYN|tcpACK, 0, 0, true)
+		k.sndNxt = k.iss + 1
+		k.armTimer()
+		return
+	case k.state == StateSynSent || k.state == StateSynReceived || k.state == StateClosed || k.state == StateListen:
+		return
+	}
+
+	for {
+		inFlight := k.sndNxt - k.sndUna
+		off := int(k.sndNxt - k.txSeq)
+		wnd := k.sndWnd
+		if k.probe && wnd == 0 && inFlight == 0 {
+			wnd = 1
+		}
+		n := k.txLen - off
+		if room := int(wnd) - int(inFlight); n > room {
+			n = room
+		}
+		if n > k.mss {
+			n = k.mss
+		}
+		if n > 0 {
+			if !k.rttTiming {
+				k.rttTiming = true
+				k.rttSeq = k.sndNxt + uint32(n)
+				k.rttStart = nanotime()
+			}
+			k.sendSegment(k.sndNxt, tcpACK|tcpPSH, off, n, false)
+			k.sndNxt += uint32(n)
+			k.probe = false
+			k.armTimer()
+			continue
+		}
+		if off < k.txLen && k.sndWnd == 0 && inFlight == 0 {
+			// Data is waiting on a zero window: the timer becomes the
+			// persist timer and lets a probe out when it fires
+			k.armTimer()
+		}
+		if k.finQueued && !k.finSent && off == k.txLen {
+			k.sendSegment(k.sndNxt, tcpFIN|tcpACK, 0, 0, false)
+			k.sndNxt++
+			k.finSent = true
+			switch k.state {
+			case StateEstablished:
+				k.state = StateFinWait1
+			case StateCloseWait:
+				k.state = StateLastAck
+			}
+			k.armTimer()
+		}
+		return
+	}
+}
+
+// rcvWindow is the free space in the receive buffer
+func (k *tcpConn) rcvWindow() int { return TCPBufferSize - k.rxLen }
+
+// sendSegment builds one segment from k with n bytes of the send ring
+// starting off bytes past txSeq, and sends it to the peer
+func (k *tcpConn) sendSegment(seq uint32, flags byte, off, n int, withMSS bool) {
+	p := txBuf[ipPayload:]
+	hlen := tcpHeaderLen
+	if withMSS {
+		hlen += tcpOptMSSLen
+	}
+	for i := 0; i < n; i++ {
+		p[hlen+i] = k.tx[(k.txHead+off+i)%TCPBufferSize]
+	}
+	ack := k.rcvNxt
+	if flags&tcpACK == 0 {
+		ack = 0
+	}
+	tcpHeader(p, k.localPort, k.remotePort, seq, ack, flags, k.rcvWindow(), withMSS)
+	tcpFinish(p, k.remoteIP, hlen+n)
+	ipOutput(&k.remoteMAC, k.remoteIP, ProtoTCP, hlen+n)
+}
+
+func tcpHeader(p []byte, sport, dport uint16, seq, ack uint32, flags byte, window int, withMSS bool) {
+	hlen := tcpHeaderLen
+	if withMSS {
+		hlen += tcpOptMSSLen
+		p[20], p[21] = tcpOptMSS, tcpOptMSSLen
+		putBE16(p[22:], tcpMSS)
+	}
+	putBE16(p[0:], sport)
+	putBE16(p[2:], dport)
+	putBE32(p[4:], seq)
+	putBE32(p[8:], ack)
+	p[12] = byte(hlen/4) << 4
+	p[13] = flags
+	if window > 0xFFFF {
+		window = 0xFFFF
+	}
+	putBE16(p[14:], uint16(window))
+	putBE16(p[16:], 0)
+	putBE16(p[18:], 0)
+}
+
+// tcpFinish fills in the checksum of the length-byte segment at p
+func tcpFinish(p []byte, dst IP, length int) {
+	putBE16(p[16:], checksum(p[:length], pseudoSum(addr, dst, ProtoTCP, length)))
+}
+
+// tcpReset answers a segment that belongs to no connection (RFC 793,
+// "Reset Generation")
+func tcpReset(src IP, srcMAC *[netdev.AddrLen]byte, sport, dport uint16, seq, ack uint32, flags byte, dataLen int) {
+	if flags&tcpRST != 0 {
+		return
+	}
+	p := txBuf[ipPayload:]
+	if flags&tcpACK != 0 {
+		tcpHeader(p, dport, sport, ack, 0, tcpRST, 0, false)
+	} else {
+		end := seq + uint32(dataLen)
+		if flags&tcpSYN != 0 {
+			end++
+		}
+		if flags&tcpFIN != 0 {
+			end++
+		}
+		tcpHeader(p, dport, sport, 0, end, tcpRST|tcpACK, 0, false)
+	}
+	tcpFinish(p, src, tcpHeaderLen)
+	ipOutput(srcMAC, src, ProtoTCP, tcpHeaderLen)
+}
+
+// tcpFind returns the connection a segment belongs to, falling back to a
+// listener on the port
+func tcpFind(src IP, sport, dport uint16) int {
+	listener := -1
+	for i := 0; i < MaxTCPConns; i++ {
+		k := &tcpConns[i]
+		if k.state == StateClosed || k.localPort != dport {
+			continue
+		}
+		if k.state == StateListen {
+			listener = i
+			continue
+		}
+		if k.remoteIP == src && k.remotePort == sport {
+			return i
+		}
+	}
+	return listener
+}
+
+// tcpMSSOption returns the peer's MSS option, or the RFC 1122 default
+func tcpMSSOption(opts []byte) int {
+	for i := 0; i < len(opts); {
+		switch opts[i] {
+		case tcpOptEnd:
+			return tcpDefaultMSS
+		case tcpOptNOP:
+			i++
+			continue
+		}
+		if i+1 >= len(opts) || opts[i+1] < 2 || i+int(opts[i+1]) > len(opts) {
+			break
+		}
+		if opts[i] == tcpOptMSS && opts[i+1] == tcpOptMSSLen {
+			mss := int(be16(opts[i+2:]))
+			if mss > tcpMSS {
+				mss = tcpMSS
+			}
+			if mss > 0 {
+				return mss
+			}
+		}
+		i += int(opts[i+1])
+	}
+	return tcpDefaultMSS
+}
+
+// tcpInput runs one segment addressed to us through the state machine
+func tcpInput(src, dst IP, p []byte, srcMAC *[netdev.AddrLen]byte) {
+	if len(p) < tcpHeaderLen || checksum(p, pseudoSum(src, dst, ProtoTCP, len(p))) != 0 {
+		return
+	}
+	hlen := int(p[12]>>4) * 4
+	if hlen < tcpHeaderLen || hlen > len(p) {
+		return
+	}
+	sport, dport := be16(p[0:]), be16(p[2:])
+	seq, ack := be32(p[4:]), be32(p[8:])
+	flags := p[13]
+	window := uint32(be16(p[14:]))
+	data := p[hlen:]
+
+	c := tcpFind(src, sport, dport)
+	if c < 0 {
+		tcpReset(src, srcMAC, sport, dport, seq, ack, flags, len(data))
+		return
+	}
+	k := &tcpConns[c]
+
+	switch k.state {
+	case StateListen:
+		if flags&tcpRST != 0 {
+			return
+		}
+		if flags&tcpACK != 0 || flags&tcpSYN == 0 {
+			tcpReset(src, srcMAC, sport, dport, seq, ack, flags, len(data))
+			return
+		}
+		tcpAcceptSYN(c, src, srcMAC, sport, seq, window, p[tcpHeaderLen:hlen])
+		return
+	case StateSynSent:
+		k.synSentInput(seq, ack, flags, window, p[tcpHeaderLen:hlen])
+		return
+	}
+
+	// Synchronized states. RST: accepted when it is exactly in sequence.
+	if flags&tcpRST != 0 {
+		if seq == k.rcvNxt {
+			k.abort(ErrReset)
+		}
+		return
+	}
+	if flags&tcpSYN != 0 || flags&tcpACK == 0 {
+		// A retransmitted SYN, or junk: re-acknowledge what we have
+		k.sendSegment(k.sndNxt, tcpACK, 0, 0, false)
+		return
+	}
+
+	if k.state == StateSynReceived {
+		if ack != k.iss+1 {
+			tcpReset(src, srcMAC, sport, dport, seq, ack, flags, len(data))
+			return
+		}
+		k.state = StateEstablished
+	}
+	if seqLT(k.sndNxt, ack) {
+		k.sendSegment(k.sndNxt, tcpACK, 0, 0, false)
+		return
+	}
+	if seqLE(k.sndUna, ack) {
+		k.acked(ack, window)
+	}
+	if k.state == StateClosed {
+		return
+	}
+
+	needAck := k.receiveData(seq, data)
+	if flags&tcpFIN != 0 {
+		// A FIN seen before is retransmitted because our ACK was lost
+		needAck = true
+	}
+	if flags&tcpFIN != 0 && !k.finReceived && seq+uint32(len(data)) == k.rcvNxt {
+		k.rcvNxt++
+		k.finReceived = true
+		switch k.state {
+		case StateEstablished:
+			k.state = StateCloseWait
+		case StateFinWait1:
+			k.state = StateClosing
+		case StateFinWait2:
+			k.enterTimeWait()
+		}
+	}
+	if needAck {
+		k.sendSegment(k.sndNxt, tcpACK, 0, 0, false)
+	}
+	k.output()
+}
+
+// tcpAcceptSYN creates a half-open connection on listener l and answers
+// with SYN-ACK; the peer's Ethernet address is taken from the SYN since
+// the receive path must not block on ARP
+func tcpAcceptSYN(l int, src IP, srcMAC *[netdev.AddrLen]byte, sport uint16, seq, window uint32, opts []byte) {
+	lk := &tcpConns[l]
+	queued := 0
+	for i := 0; i < MaxTCPConns; i++ {
+		if tcpConns[i].parent == l && tcpConns[i].pending && tcpConns[i].state != StateClosed {
+			queued++
+		}
+	}
+	if queued >= lk.backlog {
+		return
+	}
+	c := tcpAlloc()
+	if c < 0 {
+		return
+	}
+	k := &tcpConns[c]
+	k.state = StateSynReceived
+	k.parent = l
+	k.pending = true
+	k.localPort = lk.localPort
+	k.remoteIP = src
+	k.remotePort = sport
+	k.remoteMAC = *srcMAC
+	k.rcvNxt = seq + 1
+	k.sndWnd = window
+	k.mss = tcpMSSOption(opts)
+	k.output()
+}
+
+func (k *tcpConn) synSentInput(seq, ack uint32, flags byte, window uint32, opts []byte) {
+	if flags&tcpACK != 0 && ack != k.iss+1 {
+		if flags&tcpRST == 0 {
+			p := txBuf[ipPayload:]
+			tcpHeader(p, k.localPort, k.remotePort, ack, 0, tcpRST, 0, false)
+			tcpFinish(p, k.remoteIP, tcpHeaderLen)
+			ipOutput(&k.remoteMAC, k.remoteIP, ProtoTCP, tcpHeaderLen)
+		}
+		return
+	}
+	if flags&tcpRST != 0 {
+		if flags&tcpACK != 0 {
+			k.abort(ErrRefused)
+		}
+		return
+	}
+	if flags&tcpSYN == 0 || flags&tcpACK == 0 {
+		return
+	}
+	k.rcvNxt = seq + 1
+	k.mss = tcpMSSOption(opts)
+	k.state = StateEstablished
+	k.acked(ack, window)
+	k.sendSegment(k.sndNxt, tcpACK, 0, 0, false)
+	k.output()
+}
+
+// acked processes an acceptable ACK: it frees acknowledged data, takes an
+// RTT sample, restarts or stops the retransmission timer and completes
+// the transitions that wait for our FIN to be acknowledged
+func (k *tcpConn) acked(ack, window uint32) {
+	k.sndWnd = window
+	if window == 0 {
+		// The peer is alive, only out of buffer: probing goes on
+		k.retries = 0
+	}
+	if ack == k.sndUna {
+		return
+	}
+	if seqLT(k.txSeq, ack) {
+		n := int(ack - k.txSeq)
+		if n > k.txLen {
+			n = k.txLen
+		}
+		k.txHead = (k.txHead + n) % TCPBufferSize
+		k.txLen -= n
+		k.txSeq += uint32(n)
+	}
+	k.sndUna = ack
+	now := nanotime()
+	if k.rttTiming && seqLE(k.rttSeq, ack) {
+		k.rttTiming = false
+		k.sampleRTT(now - k.rttStart)
+	}
+	k.retries = 0
+	k.rtoDeadline = 0
+	if k.sndUna != k.sndNxt {
+		k.rtoDeadline = now + k.rto
+	}
+
+	if !k.finSent || k.sndUna != k.sndNxt {
+		return
+	}
+	switch k.state {
+	case StateFinWait1:
+		k.state = StateFinWait2
+	case StateClosing:
+		k.enterTimeWait()
+	case StateLastAck:
+		k.abort(OK)
+	}
+}
+
+// sampleRTT folds one measurement into the smoothed RTT and recomputes
+// the timeout (RFC 6298 section 2)
+func (k *tcpConn) sampleRTT(r uint64) {
+	if k.srtt == 0 {
+		k.srtt = r
+		k.rttvar = r / 2
+	} else {
+		diff := k.srtt - r
+		if r > k.srtt {
+			diff = r - k.srtt
+		}
+		k.rttvar = (3*k.rttvar + diff) / 4
+		k.srtt = (7*k.srtt + r) / 8
+	}
+	k.rto = k.srtt + 4*k.rttvar
+	if k.rto < tcpMinRTO {
+		k.rto = tcpMinRTO
+	}
+	if k.rto > tcpMaxRTO {
+		k.rto = tcpMaxRTO
+	}
+}
+
+// receiveData appends the in-sequence part of data to the receive buffer
+// and reports whether an ACK is due. Out-of-order segments are dropped
+// and answered with a duplicate ACK so the peer retransmits.
+func (k *tcpConn) receiveData(seq uint32, data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	if k.finReceived || (k.state != StateEstablished && k.state != StateFinWait1 && k.state != StateFinWait2) {
+		return true
+	}
+	if seqLT(k.rcvNxt, seq) {
+		return true
+	}
+	skip := int(k.rcvNxt - seq)
+	if skip >= len(data) {
+		return true
+	}
+	data = data[skip:]
+	n := len(data)
+	if room := k.rcvWindow(); n > room {
+		n = room
+	}
+	for i := 0; i < n; i++ {
+		k.rx[(k.rxHead+k.rxLen+i)%TCPBufferSize] = data[i]
+	}
+	k.rxLen += n
+	k.rcvNxt += uint32(n)
+	return true
+}
+
+func (k *tcpConn) enterTimeWait() {
+	k.state = StateTimeWait
+	k.rtoDeadline = 0
+	k.timeWaitEnd = nanotime() + tcpTimeWaitNs
+}
+
+// tcpTimers retransmits from the oldest unacknowledged byte when a
+// connection's timer expires, backing the timeout off each time, and
+// retires connections leaving TIME-WAIT. With nothing outstanding the
+// timer is the zero-window persist timer instead.
+func tcpTimers() {
+	now := nanotime()
+	for i := 0; i < MaxTCPConns; i++ {
+		k := &tcpConns[i]
+		if k.state == StateTimeWait && now >= k.timeWaitEnd {
+			k.abort(OK)
+			continue
+		}
+		if k.rtoDeadline == 0 || now < k.rtoDeadline {
+			continue
+		}
+		if k.sndUna == k.sndNxt {
+			// Persist timer: nothing is outstanding, probe the window
+			k.probe = true
+		} else {
+			k.retries++
+			if k.retries > tcpMaxRetries {
+				k.abort(ErrTimeout)
+				continue
+			}
+			k.sndNxt = k.sndUna
+			k.finSent = false
+		}
+		k.rto *= 2
+		if k.rto > tcpMaxRTO {
+			k.rto = tcpMaxRTO
+		}
+		k.rttTiming = false
+		k.rtoDeadline = 0
+		k.output()
+	}
+}
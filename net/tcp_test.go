@@ -0,0 +1,368 @@
+package net
+
+import (
+	"testing"
+
+	"github.com/dmarro89/go-dav-os/drivers/netdev"
+)
+
+type segment struct {
+	sport, dport uint16
+	seq, ack     uint32
+	flags        byte
+	window       uint16
+	mss          int
+	data         []byte
+}
+
+// tcpResponder sees every segment the stack sends; it queues answers in
+// pending so they arrive on the next poll
+var tcpResponder func(s segment)
+
+const peerPort = 40000
+
+func parseSegment(f []byte) segment {
+	p := f[ipPayload:]
+	hlen := int(p[12]>>4) * 4
+	s := segment{
+		sport:  be16(p[0:]),
+		dport:  be16(p[2:]),
+		seq:    be32(p[4:]),
+		ack:    be32(p[8:]),
+		flags:  p[13],
+		window: be16(p[14:]),
+		data:   p[hlen:],
+	}
+	if hlen > tcpHeaderLen {
+		s.mss = tcpMSSOption(p[tcpHeaderLen:hlen])
+	}
+	return s
+}
+
+func segmentFrame(s segment) []byte {
+	hlen := tcpHeaderLen
+	if s.mss != 0 {
+		hlen += tcpOptMSSLen
+	}
+	p := make([]byte, hlen+len(s.data))
+	tcpHeader(p, s.sport, s.dport, s.seq, s.ack, s.flags, int(s.window), s.mss != 0)
+	if s.mss != 0 {
+		putBE16(p[22:], uint16(s.mss))
+	}
+	copy(p[hlen:], s.data)
+	putBE16(p[16:], checksum(p, pseudoSum(gatewayIP, offeredIP, ProtoTCP, len(p))))
+	return ethernet(ourMAC, gatewayMAC, EtherTypeIPv4, ipPacket(gatewayIP, offeredIP, ProtoTCP, 64, p))
+}
+
+func inject(s segment) { receive(netdev.At(0), segmentFrame(s)) }
+
+func lastSegment(t *testing.T) segment {
+	t.Helper()
+	if len(sentFrames) == 0 {
+		t.Fatalf("nothing was sent")
+	}
+	f := sentFrames[len(sentFrames)-1]
+	if be16(f[12:]) != EtherTypeIPv4 || f[netdev.HeaderLen+9] != ProtoTCP {
+		t.Fatalf("last frame is not TCP: % x", f[:34])
+	}
+	if checksum(f[ipPayload:], pseudoSum(offeredIP, gatewayIP, ProtoTCP, len(f)-ipPayload)) != 0 {
+		t.Fatalf("segment has a bad checksum")
+	}
+	return parseSegment(f)
+}
+
+func setupTCP(t *testing.T) {
+	t.Helper()
+	setup(t)
+	idle = nil
+	Configure(offeredIP, IPv4(255, 255, 255, 0), gatewayIP)
+}
+
+// acceptConnection runs the three-way handshake from the peer's side and
+// returns the accepted handle with the next sequence number of each side
+func acceptConnection(t *testing.T, l int, window uint16) (c int, peerSeq, ourSeq uint32) {
+	t.Helper()
+	peerSeq = 1000
+	inject(segment{sport: peerPort, dport: 7, seq: peerSeq, flags: tcpSYN, window: window, mss: 1000})
+	synAck := lastSegment(t)
+	if synAck.flags != tcpSYN|tcpACK || synAck.ack != peerSeq+1 || synAck.mss != tcpMSS {
+		t.Fatalf("SYN-ACK = %+v", synAck)
+	}
+	peerSeq++
+	ourSeq = synAck.seq + 1
+	inject(segment{sport: peerPort, dport: 7, seq: peerSeq, ack: ourSeq, flags: tcpACK, window: window})
+
+	c, err := TCPAccept(l, 0)
+	if err != OK || TCPStateOf(c) != StateEstablished {
+		t.Fatalf("TCPAccept = %d, %v, state %v", c, err, TCPStateOf(c))
+	}
+	if ip, port := TCPPeer(c); ip != gatewayIP || port != peerPort {
+		t.Fatalf("TCPPeer = 0x%08x:%d", ip, port)
+	}
+	return c, peerSeq, ourSeq
+}
+
+func TestTCPPassiveOpenEchoAndClose(t *testing.T) {
+	setupTCP(t)
+	l, err := TCPListen(7, 2)
+	if err != OK {
+		t.Fatalf("TCPListen = %v", err)
+	}
+	if _, err := TCPListen(7, 2); err != ErrPortInUse {
+		t.Fatalf("second listener = %v, want ErrPortInUse", err)
+	}
+	if _, err := TCPAccept(l, 5000000); err != ErrTimeout {
+		t.Fatalf("TCPAccept with nobody connecting = %v", err)
+	}
+	c, peerSeq, ourSeq := acceptConnection(t, l, 8192)
+
+	inject(segment{sport: peerPort, dport: 7, seq: peerSeq, ack: ourSeq, flags: tcpACK | tcpPSH, window: 8192, data: []byte("hello")})
+	if a := lastSegment(t); a.flags != tcpACK || a.ack != peerSeq+5 || a.window != TCPBufferSize-5 {
+		t.Fatalf("ACK for data = %+v", a)
+	}
+	peerSeq += 5
+	// A duplicate is acknowledged again but not delivered twice
+	inject(segment{sport: peerPort, dport: 7, seq: peerSeq - 5, ack: ourSeq, flags: tcpACK, window: 8192, data: []byte("hello")})
+
+	var buf [16]byte
+	n, err := TCPRecv(c, buf[:], 0)
+	if err != OK || string(buf[:n]) != "hello" {
+		t.Fatalf("TCPRecv = %q, %v", buf[:n], err)
+	}
+	if n, err := TCPSend(c, buf[:n], 0); n != 5 || err != OK {
+		t.Fatalf("TCPSend = %d, %v", n, err)
+	}
+	d := lastSegment(t)
+	if string(d.data) != "hello" || d.seq != ourSeq || d.flags&tcpACK == 0 {
+		t.Fatalf("data segment = %+v", d)
+	}
+	ourSeq += 5
+	inject(segment{sport: peerPort, dport: 7, seq: peerSeq, ack: ourSeq, flags: tcpACK, window: 8192})
+
+	// Peer closes first: CLOSE-WAIT, EOF, then our FIN and LAST-ACK
+	inject(segment{sport: peerPort, dport: 7, seq: peerSeq, ack: ourSeq, flags: tcpFIN | tcpACK, window: 8192})
+	peerSeq++
+	if a := lastSegment(t); a.ack != peerSeq || TCPStateOf(c) != StateCloseWait {
+		t.Fatalf("after FIN: ack %d state %v", a.ack, TCPStateOf(c))
+	}
+	if n, err := TCPRecv(c, buf[:], 0); n != 0 || err != OK {
+		t.Fatalf("TCPRecv at EOF = %d, %v", n, err)
+	}
+	TCPClose(c)
+	fin := lastSegment(t)
+	if fin.flags != tcpFIN|tcpACK || fin.seq != ourSeq || tcpConns[c].state != StateLastAck {
+		t.Fatalf("FIN = %+v, state %v", fin, tcpConns[c].state)
+	}
+	inject(segment{sport: peerPort, dport: 7, seq: peerSeq, ack: ourSeq + 1, flags: tcpACK, window: 8192})
+	if tcpConns[c].state != StateClosed {
+		t.Fatalf("state after the last ACK = %v, want CLOSED", tcpConns[c].state)
+	}
+	TCPClose(l)
+	if tcpPortInUse(7) {
+		t.Fatalf("port 7 still in use after closing everything")
+	}
+}
+
+func TestTCPActiveCloseReachesTimeWait(t *testing.T) {
+	setupTCP(t)
+	l, _ := TCPListen(7, 1)
+	c, peerSeq, ourSeq := acceptConnection(t, l, 8192)
+
+	TCPClose(c)
+	if fin := lastSegment(t); fin.flags != tcpFIN|tcpACK || tcpConns[c].state != StateFinWait1 {
+		t.Fatalf("FIN = %+v, state %v", fin, tcpConns[c].state)
+	}
+	inject(segment{sport: peerPort, dport: 7, seq: peerSeq, ack: ourSeq + 1, flags: tcpACK, window: 8192})
+	if tcpConns[c].state != StateFinWait2 {
+		t.Fatalf("state = %v, want FIN-WAIT-2", tcpConns[c].state)
+	}
+	inject(segment{sport: peerPort, dport: 7, seq: peerSeq, ack: ourSeq + 1, flags: tcpFIN | tcpACK, window: 8192})
+	if a := lastSegment(t); a.ack != peerSeq+1 || tcpConns[c].state != StateTimeWait {
+		t.Fatalf("ack %d state %v, want TIME-WAIT", a.ack, tcpConns[c].state)
+	}
+	fakeNow += tcpTimeWaitNs
+	Poll()
+	if tcpConns[c].state != StateClosed {
+		t.Fatalf("TIME-WAIT never expired")
+	}
+}
+
+func TestTCPConnectRetransmitsUnackedData(t *testing.T) {
+	setupTCP(t)
+	acking := false
+	var peerNext uint32 = 5000
+	tcpResponder = func(s segment) {
+		switch {
+		case s.flags&tcpSYN != 0:
+			pending = append(pending, segmentFrame(segment{sport: s.dport, dport: s.sport,
+				seq: peerNext, ack: s.seq + 1, flags: tcpSYN | tcpACK, window: 8192, mss: 1460}))
+		case acking && len(s.data) > 0:
+			pending = append(pending, segmentFrame(segment{sport: s.dport, dport: s.sport,
+				seq: peerNext + 1, ack: s.seq + uint32(len(s.data)), flags: tcpACK, window: 8192}))
+		}
+	}
+
+	c, err := TCPConnect(gatewayIP, 80, 100000000)
+	if err != OK || TCPStateOf(c) != StateEstablished || TCPLocalPort(c) != ephemeralFirst {
+		t.Fatalf("TCPConnect = %d, %v, state %v", c, err, TCPStateOf(c))
+	}
+	if a := lastSegment(t); a.flags != tcpACK || a.ack != peerNext+1 {
+		t.Fatalf("handshake ACK = %+v", a)
+	}
+
+	TCPSend(c, []byte("GET /"), 0)
+	first := lastSegment(t)
+	sent := len(sentFrames)
+	Poll()
+	if len(sentFrames) != sent {
+		t.Fatalf("retransmitted before the timeout")
+	}
+	fakeNow += tcpInitialRTO
+	acking = true
+	Poll()
+	again := lastSegment(t)
+	if len(sentFrames) != sent+1 || again.seq != first.seq || string(again.data) != "GET /" {
+		t.Fatalf("retransmission = %+v", again)
+	}
+	if tcpConns[c].rto != 2*tcpInitialRTO {
+		t.Fatalf("RTO = %d, want it doubled", tcpConns[c].rto)
+	}
+	Poll()
+	if tcpConns[c].txLen != 0 || tcpConns[c].rtoDeadline != 0 {
+		t.Fatalf("ACK did not free the data and stop the timer")
+	}
+}
+
+func TestTCPConnectRefusedAndTimeout(t *testing.T) {
+	setupTCP(t)
+	tcpResponder = func(s segment) {
+		pending = append(pending, segmentFrame(segment{sport: s.dport, dport: s.sport,
+			ack: s.seq + 1, flags: tcpRST | tcpACK}))
+	}
+	if _, err := TCPConnect(gatewayIP, 81, 100000000); err != ErrRefused {
+		t.Fatalf("TCPConnect to a closed port = %v, want ErrRefused", err)
+	}
+
+	tcpResponder = nil
+	if _, err := TCPConnect(gatewayIP, 82, 3*tcpInitialRTO); err != ErrTimeout {
+		t.Fatalf("TCPConnect to a silent host = %v, want ErrTimeout", err)
+	}
+	syns := 0
+	for _, f := range sentFrames {
+		if f[netdev.HeaderLen+9] == ProtoTCP && parseSegment(f).flags == tcpSYN && parseSegment(f).dport == 82 {
+			syns++
+		}
+	}
+	if syns < 2 {
+		t.Fatalf("SYN sent %d times, want it retransmitted", syns)
+	}
+}
+
+func TestTCPResetForClosedPort(t *testing.T) {
+	setupTCP(t)
+	inject(segment{sport: peerPort, dport: 9999, seq: 77, flags: tcpSYN, window: 8192})
+	r := lastSegment(t)
+	if r.flags != tcpRST|tcpACK || r.ack != 78 || r.sport != 9999 || r.dport != peerPort {
+		t.Fatalf("reset = %+v", r)
+	}
+	n := len(sentFrames)
+	inject(segment{sport: peerPort, dport: 9999, seq: 77, flags: tcpRST, window: 8192})
+	if len(sentFrames) != n {
+		t.Fatalf("answered a RST with a RST")
+	}
+}
+
+func TestTCPRespectsPeerWindow(t *testing.T) {
+	setupTCP(t)
+	l, _ := TCPListen(7, 1)
+	c, peerSeq, ourSeq := acceptConnection(t, l, 10)
+
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if n, err := TCPSend(c, data, 0); n != 100 || err != OK {
+		t.Fatalf("TCPSend = %d, %v", n, err)
+	}
+	if d := lastSegment(t); len(d.data) != 10 || d.seq != ourSeq {
+		t.Fatalf("sent %d bytes into a 10-byte window", len(d.data))
+	}
+	ourSeq += 10
+
+	// Window closes: nothing more until the persist timer probes
+	sent := len(sentFrames)
+	inject(segment{sport: peerPort, dport: 7, seq: peerSeq, ack: ourSeq, flags: tcpACK, window: 0})
+	if len(sentFrames) != sent {
+		t.Fatalf("sent into a zero window")
+	}
+	fakeNow += tcpInitialRTO
+	Poll()
+	if p := lastSegment(t); len(p.data) != 1 || p.seq != ourSeq || p.data[0] != 10 {
+		t.Fatalf("window probe = %+v", p)
+	}
+
+	inject(segment{sport: peerPort, dport: 7, seq: peerSeq, ack: ourSeq + 1, flags: tcpACK, window: 1000})
+	if d := lastSegment(t); len(d.data) != 89 || d.seq != ourSeq+1 || d.data[0] != 11 {
+		t.Fatalf("after the window opened: %d bytes at %d", len(d.data), d.seq)
+	}
+	if tcpConns[c].mss != 1000 {
+		t.Fatalf("peer MSS option not honoured: %d", tcpConns[c].mss)
+	}
+}
+
+func TestTCPBacklogAndReset(t *testing.T) {
+	setupTCP(t)
+	l, _ := TCPListen(7, 1)
+	inject(segment{sport: peerPort, dport: 7, seq: 1, flags: tcpSYN, window: 8192})
+	n := len(sentFrames)
+	inject(segment{sport: peerPort + 1, dport: 7, seq: 1, flags: tcpSYN, window: 8192})
+	if len(sentFrames) != n {
+		t.Fatalf("a SYN beyond the backlog was answered")
+	}
+
+	synAck := lastSegment(t)
+	inject(segment{sport: peerPort, dport: 7, seq: 2, ack: synAck.seq + 1, flags: tcpACK, window: 8192})
+	c, err := TCPAccept(l, 0)
+	if err != OK {
+		t.Fatalf("TCPAccept = %v", err)
+	}
+	inject(segment{sport: peerPort, dport: 7, seq: 2, flags: tcpRST, window: 8192})
+	var buf [4]byte
+	if _, err := TCPRecv(c, buf[:], 0); err != ErrReset {
+		t.Fatalf("TCPRecv after RST = %v, want ErrReset", err)
+	}
+	if _, err := TCPSend(c, buf[:], 0); err != ErrReset {
+		t.Fatalf("TCPSend after RST = %v, want ErrReset", err)
+	}
+}
+
+func TestTCPWaitersKeepTheirOwnConnection(t *testing.T) {
+	setupTCP(t)
+	l7, _ := TCPListen(7, 1)
+	l8, _ := TCPListen(8, 1)
+
+	// While the accept on port 7 waits, another task takes a connection
+	// on port 8; only then does the peer connect to 7
+	waits := 0
+	idle = func() {
+		waits++
+		if waits > 1 {
+			return
+		}
+		for _, port := range []uint16{8, 7} {
+			inject(segment{sport: peerPort, dport: port, seq: 1000, flags: tcpSYN, window: 8192})
+			synAck := lastSegment(t)
+			inject(segment{sport: peerPort, dport: port, seq: 1001, ack: synAck.seq + 1, flags: tcpACK, window: 8192})
+			if port != 8 {
+				continue
+			}
+			if c, err := TCPAccept(l8, 0); err != OK || TCPLocalPort(c) != 8 {
+				t.Fatalf("accept on 8 = %d, %v", c, err)
+			}
+		}
+	}
+	c, err := TCPAccept(l7, 100000000)
+	if err != OK || TCPLocalPort(c) != 7 {
+		t.Fatalf("accept on 7 = %d, %v", c, err)
+	}
+}
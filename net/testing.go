@@ -0,0 +1,61 @@
+//go:build testing
+
+package net
+
+import "github.com/dmarro89/go-dav-os/drivers/netdev"
+
+// ResetForTesting detaches the interface and forgets the address, the
+// ARP cache and every socket and connection
+func ResetForTesting() {
+	iface = nil
+	addr, netmask, gateway, dns = 0, 0, 0, 0
+	configured = false
+	leaseTime = 0
+	idle = nil
+	for i := 0; i < ARPCacheSize; i++ {
+		arpCache[i].Valid = false
+	}
+	for i := 0; i < MaxUDPSockets; i++ {
+		udpSockets[i].used = false
+		udpSockets[i].count = 0
+	}
+	for i := 0; i < MaxTCPConns; i++ {
+		tcpConns[i].state = StateClosed
+		tcpConns[i].open = false
+	}
+	nextEphemeral = ephemeralFirst
+	dhcpSocket = -1
+	netdev.SetReceiver(nil)
+}
+
+// SetIdleForTesting makes blocking calls run fn where they would halt
+func SetIdleForTesting(fn func()) { idle = fn }
+
+// EstablishedForTesting opens a connection established with a peer whose
+// window is shut, so what is sent on it stays in the send ring
+func EstablishedForTesting() int {
+	c := tcpAlloc()
+	k := &tcpConns[c]
+	k.open = true
+	k.state = StateEstablished
+	k.sndUna, k.sndNxt = k.txSeq, k.txSeq
+	return c
+}
+
+// AckForTesting takes up to len(buf) bytes off the front of c's send ring
+// into buf, as if the peer had acknowledged them
+func AckForTesting(c int, buf []byte) int {
+	k := &tcpConns[c]
+	n := k.txLen
+	if n > len(buf) {
+		n = len(buf)
+	}
+	for i := 0; i < n; i++ {
+		buf[i] = k.tx[(k.txHead+i)%TCPBufferSize]
+	}
+	k.txHead = (k.txHead + n) % TCPBufferSize
+	k.txLen -= n
+	k.txSeq += uint32(n)
+	k.sndUna, k.sndNxt = k.txSeq, k.txSeq
+	return n
+}
@@ -0,0 +1,234 @@
+package net
+
+import "github.com/dmarro89/go-dav-os/drivers/netdev"
+
+const (
+	MaxUDPSockets = 8
+	// MaxUDPPayload is the largest datagram that fits one frame
+	MaxUDPPayload = netdev.MTU - ipHeaderLen - udpHeaderLen
+
+	udpHeaderLen   = 8
+	udpQueueLen    = 4
+	ephemeralFirst = 49152
+	ephemeralLast  = 65535
+)
+
+type datagram struct {
+	src  IP
+	port uint16
+	n    int
+	data [MaxUDPPayload]byte
+}
+
+// udpSocket keeps a small ring of received datagrams; more arriving while
+// it is full are dropped
+type udpSocket struct {
+	used  bool
+	port  uint16
+	queue [udpQueueLen]datagram
+	head  int
+	count int
+}
+
+var (
+	udpSockets    [MaxUDPSockets]udpSocket
+	nextEphemeral uint16 = ephemeralFirst
+)
+
+// UDPOpen binds a socket to port, or to a free ephemeral port when port
+// is 0, and returns its handle
+func UDPOpen(port uint16) (int, Error) {
+	flags := lock.AcquireIRQ()
+	sock, err := udpOpen(port)
+	lock.ReleaseIRQ(flags)
+	return sock, err
+}
+
+func udpOpen(port uint16) (int, Error) {
+	if port == 0 {
+		port = pickEphemeral()
+		if port == 0 {
+			return -1, ErrPortInUse
+		}
+	} else if udpFind(port) >= 0 {
+		return -1, ErrPortInUse
+	}
+	for i := 0; i < MaxUDPSockets; i++ {
+		s := &udpSockets[i]
+		if s.used {
+			continue
+		}
+		s.used = true
+		s.port = port
+		s.head, s.count = 0, 0
+		return i, OK
+	}
+	return -1, ErrNoSocket
+}
+
+func pickEphemeral() uint16 {
+	for tries := 0; tries <= ephemeralLast-ephemeralFirst; tries++ {
+		port := nextEphemeral
+		if nextEphemeral == ephemeralLast {
+			nextEphemeral = ephemeralFirst
+		} else {
+			nextEphemeral++
+		}
+		if udpFind(port) < 0 {
+			return port
+		}
+	}
+	return 0
+}
+
+func udpFind(port uint16) int {
+	for i := 0; i < MaxUDPSockets; i++ {
+		if udpSockets[i].used && udpSockets[i].port == port {
+			return i
+		}
+	}
+	return -1
+}
+
+func udpSocketAt(sock int) *udpSocket {
+	if sock < 0 || sock >= MaxUDPSockets || !udpSockets[sock].used {
+		return nil
+	}
+	return &udpSockets[sock]
+}
+
+// UDPClose releases the socket and drops anything still queued on it
+func UDPClose(sock int) {
+	flags := lock.AcquireIRQ()
+	udpClose(sock)
+	lock.ReleaseIRQ(flags)
+}
+
+func udpClose(sock int) {
+	if s := udpSocketAt(sock); s != nil {
+		s.used = false
+		s.count = 0
+	}
+}
+
+// UDPPort returns the local port the socket is bound to, or 0
+func UDPPort(sock int) uint16 {
+	if s := udpSocketAt(sock); s != nil {
+		return s.port
+	}
+	return 0
+}
+
+// UDPPending returns how many datagrams are waiting on the socket
+func UDPPending(sock int) int {
+	flags := lock.AcquireIRQ()
+	n := udpPending(sock)
+	lock.ReleaseIRQ(flags)
+	return n
+}
+
+func udpPending(sock int) int {
+	if s := udpSocketAt(sock); s != nil {
+		return s.count
+	}
+	return 0
+}
+
+// UDPSendTo sends data from the socket's port to dst:port. It may block
+// while the next hop is resolved.
+func UDPSendTo(sock int, dst IP, port uint16, data []byte) Error {
+	flags := lock.AcquireIRQ()
+	err := udpSendTo(sock, dst, port, data)
+	lock.ReleaseIRQ(flags)
+	return err
+}
+
+func udpSendTo(sock int, dst IP, port uint16, data []byte) Error {
+	s := udpSocketAt(sock)
+	if s == nil {
+		return ErrNoSocket
+	}
+	if len(data) > MaxUDPPayload {
+		return ErrTooLarge
+	}
+	var mac [netdev.AddrLen]byte
+	if err := route(dst, &mac); err != OK {
+		return err
+	}
+
+	length := udpHeaderLen + len(data)
+	p := txBuf[ipPayload:]
+	putBE16(p[0:], s.port)
+	putBE16(p[2:], port)
+	putBE16(p[4:], uint16(length))
+	putBE16(p[6:], 0)
+	for i := 0; i < len(data); i++ {
+		p[udpHeaderLen+i] = data[i]
+	}
+	sum := checksum(p[:length], pseudoSum(addr, dst, ProtoUDP, length))
+	if sum == 0 {
+		// Zero means "no checksum"; its one's complement twin is sent
+		sum = 0xFFFF
+	}
+	putBE16(p[6:], sum)
+	return ipOutput(&mac, dst, ProtoUDP, length)
+}
+
+// UDPRecvFrom takes the oldest queued datagram off the socket without
+// blocking. Data beyond len(buf) is discarded.
+func UDPRecvFrom(sock int, buf []byte) (n int, src IP, port uint16, ok bool) {
+	flags := lock.AcquireIRQ()
+	n, src, port, ok = udpRecvFrom(sock, buf)
+	lock.ReleaseIRQ(flags)
+	return n, src, port, ok
+}
+
+func udpRecvFrom(sock int, buf []byte) (n int, src IP, port uint16, ok bool) {
+	s := udpSocketAt(sock)
+	if s == nil || s.count == 0 {
+		return 0, 0, 0, false
+	}
+	d := &s.queue[s.head]
+	n = d.n
+	if n > len(buf) {
+		n = len(buf)
+	}
+	for i := 0; i < n; i++ {
+		buf[i] = d.data[i]
+	}
+	src, port = d.src, d.port
+	s.head = (s.head + 1) % udpQueueLen
+	s.count--
+	return n, src, port, true
+}
+
+// udpInput queues a datagram on the socket bound to its destination port
+func udpInput(src, dst IP, p []byte) {
+	if len(p) < udpHeaderLen {
+		return
+	}
+	length := int(be16(p[4:]))
+	if length < udpHeaderLen || length > len(p) {
+		return
+	}
+	p = p[:length]
+	if be16(p[6:]) != 0 && checksum(p, pseudoSum(src, dst, ProtoUDP, length)) != 0 {
+		return
+	}
+	i := udpFind(be16(p[2:]))
+	if i < 0 {
+		return
+	}
+	s := &udpSockets[i]
+	if s.count == udpQueueLen || length-udpHeaderLen > MaxUDPPayload {
+		return
+	}
+	d := &s.queue[(s.head+s.count)%udpQueueLen]
+	d.src = src
+	d.port = be16(p[0:])
+	d.n = length - udpHeaderLen
+	for j := 0; j < d.n; j++ {
+		d.data[j] = p[udpHeaderLen+j]
+	}
+	s.count++
+}
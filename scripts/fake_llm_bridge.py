@@ -1,12 +1,17 @@
 #!/usr/bin/env python3
 """Deterministic host-side Agent bridge for local testing.
 
-The fake bridge reads one JSON request from stdin and writes one JSON response to
-stdout. It follows docs/v0.5.0/llm_bridge_protocol.md and does not call any
-external provider.
+By default the fake bridge reads one JSON request from stdin and writes one JSON
+response to stdout. With --listen it becomes the long-running serial peer of the
+kernel: QEMU connects the guest's COM2 to it (-serial tcp:HOST:PORT) and it
+answers every request line with one response line until QEMU disconnects. It
+follows docs/v0.5.0/llm_bridge_protocol.md and does not call any external
+provider.
 """
 
+import argparse
 import json
+import socket
 import sys
 
 
@@ -14,12 +19,44 @@ MAX_ARG_LEN = 16
 RAW_EXECUTION_FIELDS = {"command", "shell", "argv", "script", "exec"}
 
 
-def main():
+def main(argv=None):
+    parser = argparse.ArgumentParser(description=__doc__.splitlines()[0])
+    parser.add_argument(
+        "--listen",
+        metavar="HOST:PORT",
+        help="serve newline-delimited requests to every connection on HOST:PORT",
+    )
+    args = parser.parse_args(argv)
+    if args.listen:
+        host, _, port = args.listen.rpartition(":")
+        listen(host or "127.0.0.1", int(port))
+        return
+
     response = plan_from_stdin(sys.stdin.read())
     json.dump(response, sys.stdout, indent=2, sort_keys=True)
     sys.stdout.write("\n")
 
 
+def listen(host, port):
+    with socket.create_server((host, port)) as server:
+        print(f"fake_llm_bridge: listening on {host}:{port}", file=sys.stderr, flush=True)
+        while True:
+            conn, _ = server.accept()
+            with conn, conn.makefile("rb") as reader, conn.makefile("wb") as writer:
+                serve_stream(reader, writer)
+
+
+def serve_stream(reader, writer):
+    """Answer each request line with one compact response line until EOF."""
+    for raw in reader:
+        line = raw.decode("utf-8", errors="replace").strip()
+        if not line:
+            continue
+        response = json.dumps(plan_from_stdin(line), sort_keys=True, separators=(",", ":"))
+        writer.write(response.encode("ascii") + b"\n")
+        writer.flush()
+
+
 def plan_from_stdin(text):
     try:
         request = json.loads(text)
@@ -1,10 +1,18 @@
 import argparse
 import os
+import socket
 import subprocess
 import sys
 import time
 
 
+RTC_BASE = "2024-01-02T03:04:05"
+# Host port forwarded by QEMU's user network to the guest's `run echo` server
+ECHO_HOST_PORT = 5555
+# Port the fake LLM bridge listens on; QEMU connects the guest's COM2 to it
+BRIDGE_PORT = 4555
+
+
 def check_log_for(target, log_file, timeout=5):
     start = time.time()
     while time.time() - start < timeout:
@@ -22,7 +30,14 @@ def create_disk_image(disk_img):
         f.write(b"\0" * (20 * 1024 * 1024))
 
 
-def start_qemu(iso_path, disk_img, log_file):
+def start_qemu(iso_path, disk_img, log_file, serial_log=None, extra_args=()):
+    # By default stdin drives the QEMU monitor (sendkey); with serial_log the
+    # guest's COM1 is wired to stdin/stdout instead and stdout goes to the file
+    console = ["-serial", "none", "-monitor", "stdio"]
+    stdout = subprocess.DEVNULL
+    if serial_log is not None:
+        console = ["-serial", "stdio", "-monitor", "none"]
+        stdout = open(serial_log, "w")
     cmd = [
         "qemu-system-x86_64",
         "-cdrom",
@@ -31,19 +46,23 @@ def start_qemu(iso_path, disk_img, log_file):
         f"file={disk_img},format=raw",
         "-debugcon",
         f"file:{log_file}",
-        "-serial",
-        "none",
-        "-monitor",
-        "stdio",
+        *console,
         "-display",
         "none",
         "-no-reboot",
         "-no-shutdown",
+        # Boot with application processors so SMP bring-up is exercised
+        "-smp",
+        "4",
+        # Pin the CMOS clock so date and FAT timestamps are predictable
+        "-rtc",
+        f"base={RTC_BASE},clock=vm",
+        *extra_args,
     ]
     return subprocess.Popen(
         cmd,
         stdin=subprocess.PIPE,
-        stdout=subprocess.DEVNULL,
+        stdout=stdout,
         stderr=subprocess.PIPE,
         text=True,
     )
@@ -60,6 +79,8 @@ def stop_qemu(process):
         process.stdin.close()
     if process.stderr is not None:
         process.stderr.close()
+    if process.stdout is not None:
+        process.stdout.close()
 
 
 def fail_with_log(message, process, log_file):
@@ -117,6 +138,14 @@ def run_functional_suite(iso_path, disk_img, log_file):
 
         test_cases = [
             ("help", ["Commands:", "agent"]),
+            ("help sleep", ["Usage: sleep <ms>"]),
+            ("echo a b | grep a | wc", ["1 2 4"]),
+            # sleep prints nothing; the next command only answers once it returns
+            ("sleep 50", []),
+            ("date", ["2024-01-02 03:04"]),
+            ("irqstat", ["IRQ 0:", "IRQ 1:"]),
+            ("cpus", ["CPU 0  APIC 0  online (BSP)", "4 of 4 CPUs online"]),
+            ("lspci", ["00:00.0 Host bridge: 8086:1237", "00:01.1 IDE interface: 8086:7010 [ata]"]),
             ("version", ["DavOS 0.0.5 (64bit)"]),
             ("write notes hi", ["ok"]),
             ("agent show files", ["notes  size=2", "agent: files listed"]),
@@ -131,15 +160,18 @@ def run_functional_suite(iso_path, disk_img, log_file):
             ("agent delete notes confirm", ["ok"]),
             ("agent show files", ["agent: no files"]),
             ("agent mode", ["agent: deterministic mode"]),
+            ("diskinfo", ["QEMU HARDDISK", "Sectors: 40960 (20 MiB)", "LBA48:   yes"]),
+            ("diskbench 64", ["poll: 64 sectors", "irq: 64 sectors", "dma: 64 sectors"]),
             ("fatformat", ["FAT16 Formatted"]),
             ("fatinit", ["FAT16 Initialized"]),
             ("fatcreate test hi", ["File created"]),
-            ("fatls", ["TEST"]),
+            ("fatls", ["TEST", "2024-01-02 03:04"]),
             ("fatread test", ["hi"]),
             ("layout", ["current layout:"]),
             ("layout us", ["layout: switched to us"]),
             ("layout it", ["layout: switched to it"]),
             ("run hello", ["hello from userland", "Process exited with status 0"]),
+            ("run hello &", ["[1] ", "hello from userland"]),
         ]
 
         for cmd_text, expected_outputs in test_cases:
@@ -157,6 +189,147 @@ def run_functional_suite(iso_path, disk_img, log_file):
         stop_qemu(process)
 
 
+def run_serial_suite(iso_path, disk_img, log_file, serial_log):
+    for path in (log_file, serial_log):
+        if os.path.exists(path):
+            os.remove(path)
+
+    process = start_qemu(iso_path, disk_img, log_file, serial_log=serial_log)
+    try:
+        print("Waiting for boot prompt on COM1...")
+        if not check_log_for("Welcome to DavOS", serial_log, timeout=12):
+            fail_with_log("Console output was not mirrored to COM1.", process, serial_log)
+
+        print("Sending 'version' over COM1...")
+        process.stdin.write("version\n")
+        process.stdin.flush()
+        if not check_log_for("DavOS 0.0.5 (64bit)", serial_log, timeout=6):
+            fail_with_log("Shell did not answer input typed on COM1.", process, serial_log)
+        print("Test Passed: shell driven over the serial port.")
+    finally:
+        stop_qemu(process)
+
+
+def run_virtio_suite(iso_path, disk_img, virtio_img, log_file):
+    if os.path.exists(log_file):
+        os.remove(log_file)
+
+    # The virtio disk is probed during PCI enumeration, before the IDE disk
+    # registers, so FAT16 runs on it
+    virtio_devices = [
+        "-drive",
+        f"file={virtio_img},if=virtio,format=raw",
+        "-nic",
+        f"user,model=virtio-net-pci,mac=52:54:00:12:34:56,hostfwd=tcp:127.0.0.1:{ECHO_HOST_PORT}-:7777",
+    ]
+    process = start_qemu(iso_path, disk_img, log_file, extra_args=virtio_devices)
+    try:
+        wait_for_boot(process, log_file)
+
+        test_cases = [
+            ("lspci", ["1AF4:1001 [virtio-blk]", "1AF4:1000 [virtio-net]"]),
+            ("ifconfig", [
+                "eth0  HWaddr 52:54:00:12:34:56  MTU 1500  UP",
+                "inet 10.0.2.15  netmask 255.255.255.0  gateway 10.0.2.2",
+            ]),
+            ("ping 10.0.2.2 2", ["bytes from 10.0.2.2: seq=1", "2 packets sent, 2 received"]),
+            ("lsblk", ["* vda  40960 sectors (20 MiB)", "  hda  40960 sectors (20 MiB)"]),
+            ("fatformat", ["FAT16 Formatted"]),
+            ("fatinit", ["FAT16 Initialized"]),
+            ("fatcreate virt hi", ["File created"]),
+            ("fatread virt", ["hi"]),
+        ]
+        for cmd_text, expected_outputs in test_cases:
+            send_shell_command(process, cmd_text)
+            for expected in expected_outputs:
+                print(f"Waiting for '{expected}' output...")
+                if not check_log_for(expected, log_file, timeout=6):
+                    fail_with_log(
+                        f"Timeout waiting for '{expected}' from command '{cmd_text}'.",
+                        process,
+                        log_file,
+                    )
+            print(f"Test Passed: '{cmd_text}' command executed successfully.")
+
+        run_echo_test(process, log_file)
+    finally:
+        stop_qemu(process)
+
+    with open(virtio_img, "rb") as f:
+        if f.read(512)[510:512] != b"\x55\xaa":
+            print("ERROR: fatformat did not write the boot sector to the virtio disk.")
+            sys.exit(1)
+    print("Test Passed: FAT16 formatted the virtio disk.")
+
+
+def run_echo_test(process, log_file):
+    """Drive the user-space TCP echo server through the forwarded port."""
+    send_shell_command(process, "run echo")
+    if not check_log_for("echo: listening on port 7777", log_file, timeout=6):
+        fail_with_log("Timeout waiting for the echo server to listen.", process, log_file)
+
+    message = b"hello over tcp\n" * 200
+    received = b""
+    try:
+        with socket.create_connection(("127.0.0.1", ECHO_HOST_PORT), timeout=10) as conn:
+            conn.sendall(message)
+            while len(received) < len(message):
+                chunk = conn.recv(4096)
+                if not chunk:
+                    break
+                received += chunk
+    except OSError as e:
+        fail_with_log(f"TCP echo failed: {e}", process, log_file)
+    if received != message:
+        fail_with_log(
+            f"Echo returned {len(received)} of {len(message)} bytes.", process, log_file
+        )
+    if not check_log_for("Process exited with status 0", log_file, timeout=6):
+        fail_with_log("Echo server did not exit after the peer closed.", process, log_file)
+    print("Test Passed: user-space TCP echo server.")
+
+
+def run_agent_bridge_suite(iso_path, disk_img, log_file):
+    """Plan agent requests through the fake LLM bridge on COM2."""
+    if os.path.exists(log_file):
+        os.remove(log_file)
+
+    bridge = subprocess.Popen(
+        [sys.executable, "scripts/fake_llm_bridge.py", "--listen", f"127.0.0.1:{BRIDGE_PORT}"],
+        stderr=subprocess.PIPE,
+        text=True,
+    )
+    # Wait for the listening socket so QEMU's connect succeeds
+    bridge.stderr.readline()
+    # COM1 stays unconnected, so this -serial becomes COM2
+    process = start_qemu(
+        iso_path, disk_img, log_file, extra_args=["-serial", f"tcp:127.0.0.1:{BRIDGE_PORT}"]
+    )
+    try:
+        wait_for_boot(process, log_file)
+        test_cases = [
+            ("agent mode llm", ["agent: llm mode"]),
+            ("agent ask show version", ["agent: version shown"]),
+            ("agent ask delete notes", ["agent: confirmation required"]),
+            ("agent ask make coffee", ["agent: no allowed action matched"]),
+        ]
+        for cmd_text, expected_outputs in test_cases:
+            send_shell_command(process, cmd_text)
+            for expected in expected_outputs:
+                print(f"Waiting for '{expected}' output...")
+                if not check_log_for(expected, log_file, timeout=10):
+                    fail_with_log(
+                        f"Timeout waiting for '{expected}' from command '{cmd_text}'.",
+                        process,
+                        log_file,
+                    )
+            print(f"Test Passed: '{cmd_text}' command executed successfully.")
+    finally:
+        stop_qemu(process)
+        bridge.terminate()
+        bridge.wait()
+
+
 def run_fault_probe(iso_path, disk_img, cmd_text, log_file, fault_marker="PF"):
     last_process = None
     for attempt in range(1, 4):
@@ -229,6 +402,11 @@ def main():
         disk_img = "disk.img"
         create_disk_image(disk_img)
         run_functional_suite(iso_path, disk_img, "qemu.log")
+        run_serial_suite(iso_path, disk_img, "qemu_serial_debug.log", "qemu_serial.log")
+        virtio_img = "disk_virtio.img"
+        create_disk_image(virtio_img)
+        run_virtio_suite(iso_path, disk_img, virtio_img, "qemu_virtio.log")
+        run_agent_bridge_suite(iso_path, disk_img, "qemu_agent.log")
 
     if run_faults:
         # Each probe must run in its own VM instance because a #PF is terminal here.
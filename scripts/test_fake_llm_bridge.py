@@ -1,4 +1,5 @@
 import importlib.util
+import io
 import json
 import subprocess
 import sys
@@ -64,6 +65,22 @@ class FakeLLMBridgeTest(unittest.TestCase):
         self.assertEqual(response["args"], [])
         self.assertEqual(response["risk"], "safe")
 
+    def test_stream_answers_each_request_line(self):
+        requests = [
+            {"input": "show me the files", "context": {"requestCount": 1}, "allowedActions": DEFAULT_ALLOWED},
+            {"input": "read notes", "context": {"requestCount": 2}, "allowedActions": DEFAULT_ALLOWED},
+        ]
+        lines = b"".join(json.dumps(r).encode() + b"\r\n\n" for r in requests)
+        writer = io.BytesIO()
+
+        fake_llm_bridge.serve_stream(io.BytesIO(b"garbage\n" + lines), writer)
+
+        responses = writer.getvalue().split(b"\n")
+        self.assertEqual(responses[-1], b"")
+        responses = [json.loads(line) for line in responses[:-1]]
+        self.assertEqual([r["action"] for r in responses], ["unknown", "list_files", "read_file"])
+        self.assertEqual(responses[2]["args"], ["notes"])
+
     def test_maps_read_request_with_target_arg(self):
         response = self.plan("read notes")
 
@@ -0,0 +1,194 @@
+// Package serial drives 16550 UARTs. Output is polled; input arrives on
+// the UART's IRQ and is buffered until TryRead collects it. The package
+// functions drive the console port; other ports get their own Port.
+package serial
+
+const (
+	// COM1 is the I/O base of the first serial port
+	COM1 uint16 = 0x3F8
+	// COM1IRQ is the legacy IRQ line COM1 raises
+	COM1IRQ = 4
+	// COM2 is the I/O base of the second serial port
+	COM2 uint16 = 0x2F8
+	// COM2IRQ is the legacy IRQ line COM2 raises
+	COM2IRQ = 3
+
+	regData    = 0 // RBR (read) / THR (write); divisor low byte with DLAB set
+	regIER     = 1 // interrupt enable; divisor high byte with DLAB set
+	regFCR     = 2 // FIFO control (write)
+	regLCR     = 3
+	regMCR     = 4
+	regLSR     = 5
+	regScratch = 7
+
+	lcrDLAB = 0x80
+	lcr8N1  = 0x03
+
+	ierRxAvailable = 0x01
+
+	// Enable and clear both FIFOs, interrupt at 14 bytes
+	fcrEnable = 0xC7
+
+	mcrDTR  = 0x01
+	mcrRTS  = 0x02
+	mcrOUT1 = 0x04
+	// OUT2 gates the UART's interrupt line onto the PIC
+	mcrOUT2     = 0x08
+	mcrLoopback = 0x10
+
+	lsrDataReady = 0x01
+	lsrTHREmpty  = 0x20
+
+	uartClock   = 115200
+	defaultBaud = 115200
+
+	loopbackProbe = 0xAE
+
+	// txSpinLimit bounds the wait for the transmitter so a wedged or
+	// missing UART cannot hang terminal output
+	txSpinLimit = 100000
+
+	rxBufSize = 256
+)
+
+// Port is one UART and the bytes received on it
+type Port struct {
+	base    uint16
+	present bool
+
+	rxBuf  [rxBufSize]byte
+	rxHead int
+	rxTail int
+	// rxDropped counts bytes lost because the buffer was full
+	rxDropped uint64
+}
+
+// console is the port the package-level functions drive
+var console Port
+
+// Init programs the console UART at port; see Port.Init
+func Init(port uint16) bool { return console.Init(port) }
+
+// Present reports whether Init found a console UART
+func Present() bool { return console.present }
+
+// PutByte transmits c on the console; see Port.PutByte
+func PutByte(c byte) { console.PutByte(c) }
+
+// Write transmits every byte of s through PutByte
+func Write(s string) {
+	for i := 0; i < len(s); i++ {
+		PutByte(s[i])
+	}
+}
+
+// IRQHandler drains the console's receive FIFO
+func IRQHandler() { console.IRQHandler() }
+
+// TryRead pops the oldest byte received on the console. Call it with
+// interrupts disabled so IRQHandler cannot run halfway through.
+func TryRead() (byte, bool) { return console.TryRead() }
+
+// Dropped returns how many console bytes were lost to a full buffer
+func Dropped() uint64 { return console.rxDropped }
+
+// Init programs the UART at port for 115200 baud 8N1 and checks that it
+// exists by echoing a byte through loopback mode. On success the receive
+// interrupt is enabled; the caller still has to route the IRQ (COM1IRQ for
+// COM1) to IRQHandler. Returns false when no UART answers.
+func (p *Port) Init(port uint16) bool {
+	p.base = port
+	p.present = false
+	p.rxHead = 0
+	p.rxTail = 0
+	p.rxDropped = 0
+	base := p.base
+
+	outb(base+regIER, 0)
+	outb(base+regLCR, lcrDLAB)
+	div := uint16(uartClock / defaultBaud)
+	outb(base+regData, byte(div))
+	outb(base+regIER, byte(div>>8))
+	outb(base+regLCR, lcr8N1)
+	outb(base+regFCR, fcrEnable)
+
+	outb(base+regMCR, mcrRTS|mcrOUT1|mcrOUT2|mcrLoopback)
+	outb(base+regData, loopbackProbe)
+	if inb(base+regData) != loopbackProbe {
+		outb(base+regMCR, 0)
+		return false
+	}
+
+	outb(base+regMCR, mcrDTR|mcrRTS|mcrOUT1|mcrOUT2)
+	outb(base+regIER, ierRxAvailable)
+	p.present = true
+	return true
+}
+
+// Present reports whether Init found a UART
+func (p *Port) Present() bool { return p.present }
+
+// PutByte transmits c, expanding '\n' to CR LF and '\b' to an erasing
+// backspace so a plain serial terminal shows what the screen shows
+func (p *Port) PutByte(c byte) {
+	if !p.present {
+		return
+	}
+	switch c {
+	case '\n':
+		p.transmit('\r')
+	case '\b':
+		p.transmit('\b')
+		p.transmit(' ')
+	}
+	p.transmit(c)
+}
+
+// Transmit sends c unchanged, for ports that carry data rather than a
+// terminal
+func (p *Port) Transmit(c byte) {
+	if p.present {
+		p.transmit(c)
+	}
+}
+
+func (p *Port) transmit(c byte) {
+	for i := 0; i < txSpinLimit; i++ {
+		if inb(p.base+regLSR)&lsrTHREmpty != 0 {
+			break
+		}
+	}
+	outb(p.base+regData, c)
+}
+
+// IRQHandler drains the receive FIFO into the input buffer. When the
+// buffer is full new bytes are dropped and counted.
+func (p *Port) IRQHandler() {
+	if !p.present {
+		return
+	}
+	for inb(p.base+regLSR)&lsrDataReady != 0 {
+		c := inb(p.base + regData)
+		next := (p.rxHead + 1) % rxBufSize
+		if next == p.rxTail {
+			p.rxDropped++
+			continue
+		}
+		p.rxBuf[p.rxHead] = c
+		p.rxHead = next
+	}
+}
+
+// TryRead pops the oldest received byte. Call it with interrupts disabled
+// so IRQHandler cannot run halfway through.
+func (p *Port) TryRead() (byte, bool) {
+	if p.rxTail == p.rxHead {
+		return 0, false
+	}
+	c := p.rxBuf[p.rxTail]
+	p.rxTail = (p.rxTail + 1) % rxBufSize
+	return c, true
+}
+
+// Dropped returns how many received bytes were lost to a full buffer
+func (p *Port) Dropped() uint64 { return p.rxDropped }
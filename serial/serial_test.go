@@ -0,0 +1,120 @@
+package serial
+
+import "testing"
+
+func resetFakeUART() {
+	for i := range fakeRegs {
+		fakeRegs[i] = 0
+	}
+	fakeTx = nil
+	fakeRx = nil
+	fakeAbsent = false
+}
+
+func TestInitDetectsUARTThroughLoopback(t *testing.T) {
+	resetFakeUART()
+	if !Init(COM1) || !Present() {
+		t.Fatalf("Init should find the fake UART")
+	}
+	if fakeRegs[regLCR] != lcr8N1 {
+		t.Fatalf("LCR = %#x, want 8N1 with DLAB clear", fakeRegs[regLCR])
+	}
+	if fakeRegs[regMCR]&mcrLoopback != 0 || fakeRegs[regMCR]&mcrOUT2 == 0 {
+		t.Fatalf("MCR = %#x, want loopback off and OUT2 on", fakeRegs[regMCR])
+	}
+	if fakeRegs[regIER] != ierRxAvailable {
+		t.Fatalf("IER = %#x, want receive interrupt only", fakeRegs[regIER])
+	}
+	if len(fakeTx) != 0 {
+		t.Fatalf("loopback probe leaked onto the line: %q", fakeTx)
+	}
+
+	resetFakeUART()
+	fakeAbsent = true
+	if Init(COM1) || Present() {
+		t.Fatalf("Init should fail when loopback does not echo")
+	}
+	PutByte('x')
+	if len(fakeTx) != 0 {
+		t.Fatalf("PutByte without a UART sent %q", fakeTx)
+	}
+}
+
+func TestWriteTranslatesNewlineAndBackspace(t *testing.T) {
+	resetFakeUART()
+	Init(COM1)
+
+	Write("ok\n")
+	PutByte('\b')
+
+	if got, want := string(fakeTx), "ok\r\n\b \b"; got != want {
+		t.Fatalf("line = %q, want %q", got, want)
+	}
+}
+
+func TestIRQHandlerBuffersInput(t *testing.T) {
+	resetFakeUART()
+	Init(COM1)
+	fakeRx = []byte("hi\r")
+
+	if _, ok := TryRead(); ok {
+		t.Fatalf("TryRead before the IRQ should be empty")
+	}
+	IRQHandler()
+
+	for _, want := range []byte("hi\r") {
+		c, ok := TryRead()
+		if !ok || c != want {
+			t.Fatalf("TryRead = %q, %v, want %q", c, ok, want)
+		}
+	}
+	if _, ok := TryRead(); ok {
+		t.Fatalf("buffer should be drained")
+	}
+}
+
+func TestIRQHandlerDropsWhenFull(t *testing.T) {
+	resetFakeUART()
+	Init(COM1)
+	fakeRx = make([]byte, rxBufSize+10)
+
+	IRQHandler()
+
+	if Dropped() != 11 {
+		t.Fatalf("Dropped() = %d, want 11", Dropped())
+	}
+	n := 0
+	for {
+		if _, ok := TryRead(); !ok {
+			break
+		}
+		n++
+	}
+	if n != rxBufSize-1 {
+		t.Fatalf("buffered %d bytes, want %d", n, rxBufSize-1)
+	}
+}
+
+func TestPortIsIndependentOfTheConsole(t *testing.T) {
+	resetFakeUART()
+	Init(COM1)
+	var p Port
+	if !p.Init(COM2) || !p.Present() {
+		t.Fatalf("Port.Init should find the fake UART at COM2")
+	}
+
+	p.Transmit('\n')
+	p.PutByte('\n')
+	if got, want := string(fakeTx), "\n\r\n"; got != want {
+		t.Fatalf("line = %q, want %q", got, want)
+	}
+
+	fakeRx = []byte("{}")
+	p.IRQHandler()
+	if _, ok := TryRead(); ok {
+		t.Fatalf("bytes received on COM2 reached the console")
+	}
+	if c, ok := p.TryRead(); !ok || c != '{' {
+		t.Fatalf("Port.TryRead = %q, %v", c, ok)
+	}
+}
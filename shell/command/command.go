@@ -0,0 +1,212 @@
+// Package command is the table of shell commands. It depends on nothing
+// but the terminal, so drivers and filesystems can register commands from
+// their own code without importing the shell, which imports them.
+package command
+
+import "github.com/dmarro89/go-dav-os/terminal"
+
+const (
+	// MaxCommands is the size of the table
+	MaxCommands = 64
+
+	// MaxWords is how many words of a line Args splits out, the command
+	// name included; Rest still reaches past them
+	MaxWords = 16
+
+	// Variadic as MaxArgs takes any number of arguments
+	Variadic = -1
+
+	// UsageStatus is the exit status of a command given the wrong number
+	// of arguments
+	UsageStatus = 2
+)
+
+// Command is a shell command. Register keeps the pointer, so a Command
+// must live as long as the kernel, in a package variable.
+type Command struct {
+	Name string
+	// Usage is the argument synopsis, "<name> [count]", printed after the
+	// name by help and when the arguments do not fit
+	Usage string
+	// Help is one line saying what the command does
+	Help string
+
+	// MinArgs and MaxArgs bound the arguments after the name; the shell
+	// prints the usage instead of running a command given too few or too
+	// many
+	MinArgs int
+	MaxArgs int
+
+	Run func(args *Args)
+}
+
+// Accepts reports whether n arguments fit the command
+func (c *Command) Accepts(n int) bool {
+	return n >= c.MinArgs && (c.MaxArgs == Variadic || n <= c.MaxArgs)
+}
+
+// PrintUsage prints the "Usage:" line of the command
+func (c *Command) PrintUsage() {
+	terminal.Print("Usage: ")
+	terminal.Print(c.Name)
+	if len(c.Usage) > 0 {
+		terminal.PutRune(' ')
+		terminal.Print(c.Usage)
+	}
+	terminal.PutRune('\n')
+}
+
+var (
+	table [MaxCommands]*Command
+	count int
+)
+
+// Register adds c to the table. It fails when the table is full, c has no
+// name or handler, or its name is taken.
+func Register(c *Command) bool {
+	if count == MaxCommands || len(c.Name) == 0 || c.Run == nil || Find(c.Name) != nil {
+		return false
+	}
+	table[count] = c
+	count++
+	return true
+}
+
+// Find returns the command called name, or nil
+func Find(name string) *Command {
+	for i := 0; i < count; i++ {
+		if table[i].Name == name {
+			return table[i]
+		}
+	}
+	return nil
+}
+
+// Execute runs the command named by word 0 of a. A command given too few
+// or too many arguments prints its usage instead. Execute reports false
+// when there is no such command.
+func Execute(a *Args) bool {
+	c := Find(a.Name())
+	if c == nil {
+		return false
+	}
+	a.cmd = c
+	if !c.Accepts(a.Len()) {
+		a.Usage()
+		return true
+	}
+	c.Run(a)
+	return true
+}
+
+// Count is the number of registered commands
+func Count() int { return count }
+
+// At returns command i in registration order
+func At(i int) *Command { return table[i] }
+
+// Args is a command line split into words at blanks. Word 0 is the
+// command name. The strings are views of the line, only valid while the
+// command runs.
+type Args struct {
+	cmd   *Command
+	line  string
+	words [MaxWords][2]int
+	count int
+
+	input string
+	piped bool
+
+	// Status is the exit status the command leaves in $?
+	Status int
+}
+
+// Split breaks line into words and clears Status and the input
+func (a *Args) Split(line string) {
+	a.cmd = nil
+	a.line = line
+	a.count = 0
+	a.input = ""
+	a.piped = false
+	a.Status = 0
+	for i := 0; i < len(line) && a.count < MaxWords; {
+		for i < len(line) && isBlank(line[i]) {
+			i++
+		}
+		start := i
+		for i < len(line) && !isBlank(line[i]) {
+			i++
+		}
+		if start == i {
+			break
+		}
+		a.words[a.count] = [2]int{start, i}
+		a.count++
+	}
+}
+
+// Len is the number of arguments after the name
+func (a *Args) Len() int {
+	if a.count == 0 {
+		return 0
+	}
+	return a.count - 1
+}
+
+// Name is word 0
+func (a *Args) Name() string { return a.Arg(0) }
+
+// Arg returns word i, or "" past the last one
+func (a *Args) Arg(i int) string {
+	start, end := a.Span(i)
+	return a.line[start:end]
+}
+
+// Rest returns the line from word i to its end, for commands taking free
+// text
+func (a *Args) Rest(i int) string {
+	start, _ := a.Span(i)
+	end := len(a.line)
+	for end > start && isBlank(a.line[end-1]) {
+		end--
+	}
+	return a.line[start:end]
+}
+
+// Span returns the byte offsets of word i in the line; past the last word
+// both are the length of the line
+func (a *Args) Span(i int) (start, end int) {
+	if i < 0 || i >= a.count {
+		return len(a.line), len(a.line)
+	}
+	return a.words[i][0], a.words[i][1]
+}
+
+// SetInput gives the command the output of the stage before it in a pipe
+func (a *Args) SetInput(s string) {
+	a.input = s
+	a.piped = true
+}
+
+// Input returns what was piped into the command, and false when the
+// command is not reading a pipe
+func (a *Args) Input() (string, bool) { return a.input, a.piped }
+
+// Usage prints the usage of the running command and sets Status to
+// UsageStatus, for arguments the handler rejects
+func (a *Args) Usage() {
+	if a.cmd != nil {
+		a.cmd.PrintUsage()
+	}
+	a.Status = UsageStatus
+}
+
+// Fail prints msg and sets Status to 1
+func (a *Args) Fail(msg string) {
+	terminal.Print(msg)
+	a.Status = 1
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t'
+}
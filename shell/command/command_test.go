@@ -0,0 +1,94 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+func resetTable() {
+	for i := range table {
+		table[i] = nil
+	}
+	count = 0
+}
+
+func TestSplitAndArgs(t *testing.T) {
+	var a Args
+	a.Split("  write\tnotes  hello   world ")
+	if a.Len() != 3 || a.Name() != "write" || a.Arg(1) != "notes" || a.Arg(3) != "world" {
+		t.Fatalf("split into %d args: %q %q %q", a.Len(), a.Name(), a.Arg(1), a.Arg(3))
+	}
+	if got := a.Rest(2); got != "hello   world" {
+		t.Fatalf("Rest(2) = %q", got)
+	}
+	if s, e := a.Span(1); s != 8 || e != 13 {
+		t.Fatalf("Span(1) = %d,%d", s, e)
+	}
+	if a.Arg(4) != "" || a.Rest(4) != "" {
+		t.Fatalf("past the last word: %q %q", a.Arg(4), a.Rest(4))
+	}
+
+	a.Split("")
+	if a.Len() != 0 || a.Name() != "" {
+		t.Fatalf("empty line split into %d args", a.Len())
+	}
+}
+
+var ran *Args
+
+func record(a *Args) { ran = a }
+
+func TestRegisterRejectsDuplicates(t *testing.T) {
+	resetTable()
+	t.Cleanup(resetTable)
+	first := Command{Name: "probe", Run: record}
+	second := Command{Name: "probe", Run: record}
+	if !Register(&first) || Register(&second) || Register(&Command{Name: "norun"}) {
+		t.Fatalf("registered a duplicate or a command without a handler")
+	}
+	if Count() != 1 || At(0) != &first || Find("probe") != &first || Find("nope") != nil {
+		t.Fatalf("table holds %d commands", Count())
+	}
+}
+
+func TestExecuteChecksArgumentCount(t *testing.T) {
+	resetTable()
+	t.Cleanup(resetTable)
+	c := Command{Name: "probe", Usage: "<a> [b]", MinArgs: 1, MaxArgs: 2, Run: record}
+	Register(&c)
+
+	cases := []struct {
+		line  string
+		found bool
+		runs  bool
+	}{
+		{"probe x", true, true},
+		{"probe x y", true, true},
+		{"probe", true, false},
+		{"probe x y z", true, false},
+		{"other", false, false},
+	}
+	for _, tc := range cases {
+		terminal.Init()
+		terminal.ResetOutputForTesting()
+		ran = nil
+		var a Args
+		a.Split(tc.line)
+		if found := Execute(&a); found != tc.found || (ran != nil) != tc.runs {
+			t.Fatalf("%q: found %v ran %v", tc.line, found, ran != nil)
+		}
+		if tc.found && !tc.runs {
+			if got := terminal.OutputForTesting(); got != "Usage: probe <a> [b]\n" || a.Status != UsageStatus {
+				t.Fatalf("%q: output %q status %d", tc.line, got, a.Status)
+			}
+		}
+	}
+
+	c.MaxArgs = Variadic
+	var a Args
+	a.Split("probe 1 2 3 4 5")
+	if Execute(&a); ran != &a {
+		t.Fatalf("a variadic command did not run")
+	}
+}
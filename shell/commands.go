@@ -0,0 +1,789 @@
+package shell
+
+import (
+	"unsafe"
+
+	"github.com/dmarro89/go-dav-os/agent"
+	"github.com/dmarro89/go-dav-os/drivers/ata"
+	"github.com/dmarro89/go-dav-os/fs"
+	"github.com/dmarro89/go-dav-os/fs/fat16"
+	"github.com/dmarro89/go-dav-os/mem"
+	"github.com/dmarro89/go-dav-os/net"
+	"github.com/dmarro89/go-dav-os/shell/command"
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+// The shell's own commands live in the command table with any other
+// package's, in builtins. Handlers read their arguments from lineBuf,
+// which the line of their Args views, so the parsing helpers of this
+// package work on the offsets Span returns.
+
+var (
+	builtins          [56]command.Command
+	builtinCount      int
+	builtinsInstalled bool
+)
+
+// RegisterCommands puts the shell's commands in the command table. The
+// kernel calls it before other packages register theirs, so help lists
+// the shell's first; the shell calls it too before it looks a command up.
+func RegisterCommands() {
+	if builtinsInstalled {
+		return
+	}
+	builtinsInstalled = true
+
+	builtin("help", "[<command>]", "List the commands, or show how to use one", 0, 1, cmdHelp)
+	builtin("history", "", "List the lines typed before", 0, 0, cmdHistory)
+	builtin("clear", "", "Clear the screen", 0, 0, cmdClear)
+	builtin("echo", "[<text...>]", "Print the text", 0, command.Variadic, cmdEcho)
+	builtin("ticks", "", "Show the PIT tick counter", 0, 0, cmdTicks)
+	builtin("uptime", "", "Show the time since boot", 0, 0, cmdUptime)
+	builtin("sleep", "<ms>", "Block the shell on a one-shot timer", 1, 1, cmdSleep)
+	builtin("date", "", "Show the date and time from the RTC", 0, 0, cmdDate)
+	builtin("cpus", "", "List the processors found at boot", 0, 0, cmdCPUs)
+	builtin("irqstat", "", "Show the interrupt counts per IRQ line", 0, 0, cmdIRQStat)
+	builtin("lspci", "[-v]", "List the PCI functions, -v with BARs and capabilities", 0, 1, cmdLspci)
+	builtin("mem", "<hex_addr> [len]", "Dump memory", 1, 2, cmdMem)
+	builtin("mmap", "", "Show the Multiboot memory map", 0, 0, cmdMmap)
+	builtin("mmapmax", "", "Show the highest usable address", 0, 0, cmdMmapMax)
+	builtin("pfa", "", "Show the page allocator counts", 0, 0, cmdPFA)
+	builtin("alloc", "", "Allocate a 4 KiB page", 0, 0, cmdAlloc)
+	builtin("free", "<hex_addr>", "Free a page from alloc", 1, 1, cmdFree)
+	builtin("ls", "", "List the RAM fs files", 0, 0, cmdLs)
+	builtin("write", "<name> <text...>", "Write a RAM fs file", 1, command.Variadic, cmdWrite)
+	builtin("cat", "<name>", "Print a RAM fs file", 1, 1, cmdCat)
+	builtin("rm", "<name>", "Remove a RAM fs file", 1, 1, cmdRm)
+	builtin("stat", "<name>", "Show where a RAM fs file is and its size", 1, 1, cmdStat)
+	builtin("lsblk", "", "List the block devices", 0, 0, cmdLsblk)
+	builtin("disk", "<read|write> <lba> [text]", "Read or write a raw sector", 2, command.Variadic, cmdDisk)
+	builtin("diskinfo", "", "Show the model and size of the primary disk", 0, 0, cmdDiskInfo)
+	builtin("diskbench", "[sectors 1-256]", "Measure disk read throughput", 0, 1, cmdDiskBench)
+	builtin("fatcreate", "<filename> <content>", "Create a FAT16 file", 1, command.Variadic, cmdFatCreate)
+	builtin("fatread", "<filename>", "Print a FAT16 file", 1, 1, cmdFatRead)
+	builtin("ifconfig", "", "List the network interfaces", 0, 0, cmdIfconfig)
+	builtin("ping", "<ip> [count]", "Send ICMP echo requests", 1, 2, cmdPing)
+	builtin("layout", "[list|<name>]", "Show, list or switch the keyboard layout", 0, 1, cmdLayout)
+	builtin("version", "", "Show the OS version", 0, 0, cmdVersion)
+	builtin("run", "<program> [&]", "Start a program, in the background with &", 1, 2, cmdRun)
+	builtin("jobs", "", "List the programs started from the shell", 0, 0, cmdJobs)
+	builtin("fg", "[%job]", "Bring a job to the foreground", 0, 1, cmdFg)
+	builtin("bg", "[%job]", "Let a stopped job run in the background", 0, 1, cmdBg)
+	builtin("kill", "<%job|pid>", "End a job", 1, 1, cmdKill)
+	builtin("agent", "<show|read|stat|delete|mode|ask|help> [arg]", "Ask the agent", 1, command.Variadic, cmdAgent)
+	builtin("source", "<file>", "Run a script", 1, 1, cmdSource)
+	builtin("set", "", "List the variables", 0, 0, cmdSet)
+	builtin("unset", "<name...>", "Remove variables", 1, command.Variadic, cmdUnset)
+	builtin("true", "", "Exit with status 0", 0, command.Variadic, cmdTrue)
+	builtin("false", "", "Exit with status 1", 0, command.Variadic, cmdFalse)
+	builtin("test", "[-n|-z|-f] <arg> | <a> =|!=|-eq|-ne|-lt|-le|-gt|-ge <b>", "Exit with 0 when the test holds", 1, 3, cmdTest)
+	builtin("let", "<name>=<n> [+|-|*|/|% <n>]", "Set a variable to a sum", 1, 3, cmdLet)
+	builtin("exit", "[n]", "Stop the running scripts", 0, 1, cmdExit)
+	builtin("grep", "[-v] <text> [file]", "Print the lines holding the text, or with -v the others", 1, 3, cmdGrep)
+	builtin("head", "[-n <lines>] [file]", "Print the first lines", 0, 3, cmdHead)
+	builtin("tail", "[-n <lines>] [file]", "Print the last lines", 0, 3, cmdTail)
+	builtin("wc", "[file]", "Count lines, words and bytes", 0, 1, cmdWc)
+}
+
+func builtin(name, usage, help string, minArgs, maxArgs int, run func(*command.Args)) {
+	c := &builtins[builtinCount]
+	builtinCount++
+	c.Name = name
+	c.Usage = usage
+	c.Help = help
+	c.MinArgs = minArgs
+	c.MaxArgs = maxArgs
+	c.Run = run
+	command.Register(c)
+}
+
+// restRange returns where word i of a up to the end of the line is in
+// lineBuf
+func restRange(a *command.Args, i int) (int, int) {
+	start, _ := a.Span(i)
+	return start, start + len(a.Rest(i))
+}
+
+func cmdHelp(a *command.Args) {
+	if a.Len() == 1 {
+		c := command.Find(a.Arg(1))
+		if c == nil {
+			fail("help: no command ")
+			terminal.Print(a.Arg(1))
+			terminal.PutRune('\n')
+			return
+		}
+		c.PrintUsage()
+		terminal.Print(c.Help)
+		terminal.PutRune('\n')
+		return
+	}
+
+	terminal.Print("Commands: ")
+	for i := 0; i < command.Count(); i++ {
+		if i > 0 {
+			terminal.Print(", ")
+		}
+		terminal.Print(command.At(i).Name)
+	}
+	terminal.PutRune('\n')
+}
+
+func cmdHistory(a *command.Args) {
+	printHistory()
+}
+
+func cmdClear(a *command.Args) {
+	terminal.Clear()
+}
+
+func cmdEcho(a *command.Args) {
+	terminal.Print(a.Rest(1))
+	terminal.PutRune('\n')
+}
+
+func cmdTicks(a *command.Args) {
+	if !printTicks() {
+		fail("ticks: not wired yet\n")
+	}
+}
+
+func cmdUptime(a *command.Args) {
+	if getSyscallTicks == nil {
+		fail("uptime: not wired yet\n")
+		return
+	}
+	t := getSyscallTicks()
+	secs := t / 100
+	mins := secs / 60
+	secs = secs % 60
+	terminal.Print("up ")
+	printUint(mins)
+	terminal.Print("m ")
+	printUint(secs)
+	terminal.Print("s (")
+	printUint(t)
+	terminal.Print(" ticks via SYS_GETTICKS)\n")
+}
+
+func cmdSleep(a *command.Args) {
+	ms, ok := parseDec(a.Span(1))
+	if !ok {
+		fail("sleep: invalid duration\n")
+		return
+	}
+
+	if sleepFn == nil {
+		fail("sleep: not wired yet\n")
+		return
+	}
+
+	sleepFn(uint64(ms))
+}
+
+func cmdDate(a *command.Args) {
+	if clockFn == nil {
+		fail("date: not wired yet\n")
+		return
+	}
+	t, ok := clockFn()
+	if !ok {
+		fail("date: clock not available\n")
+		return
+	}
+	printDate(t)
+	terminal.PutRune('\n')
+}
+
+func cmdCPUs(a *command.Args) {
+	printCPUs()
+}
+
+func cmdIRQStat(a *command.Args) {
+	printIRQStats()
+}
+
+func cmdLspci(a *command.Args) {
+	verbose := false
+	if a.Len() == 1 {
+		if a.Arg(1) != "-v" {
+			a.Usage()
+			return
+		}
+		verbose = true
+	}
+	printPCI(verbose)
+}
+
+// VGA mem 0xB8000 160
+// kernel mem 0x00100000 256, mem 0x00101000 256 ...
+// .rodata & .data mem 0x00104000 256, mem 0x00108000 256, mem 0x0010C000 256
+func cmdMem(a *command.Args) {
+	addr, ok := parseHex64(a.Span(1))
+	if !ok {
+		fail("mem: invalid hex address\n")
+		return
+	}
+
+	length := 64
+	if a.Len() == 2 {
+		v, ok := parseDec(a.Span(2))
+		if !ok {
+			fail("mem: invalid length\n")
+			return
+		}
+		length = v
+	}
+
+	if length < 1 {
+		length = 1
+	}
+	if length > 512 {
+		length = 512
+	}
+
+	dumpMemory(addr, length)
+}
+
+func cmdMmap(a *command.Args) {
+	printMemoryMap()
+}
+
+func cmdMmapMax(a *command.Args) {
+	var maxEnd uint64
+	n := mem.MMapCount()
+	for i := 0; i < n; i++ {
+		bLo, bHi, lLo, lHi, typ := mem.MMapEntry(i)
+		if typ != 1 {
+			continue
+		}
+		base := (uint64(bHi) << 32) | uint64(bLo)
+		l := (uint64(lHi) << 32) | uint64(lLo)
+		end := base + l
+		if end > maxEnd {
+			maxEnd = end
+		}
+	}
+
+	terminal.Print("mmap max end=0x")
+	printHexU64(maxEnd)
+	terminal.PutRune('\n')
+}
+
+func cmdPFA(a *command.Args) {
+	if !mem.PFAReady() {
+		fail("pfa: not ready\n")
+		return
+	}
+
+	terminal.Print("pages total=")
+	printUint(mem.TotalPages())
+	terminal.Print(" used=")
+	printUint(mem.UsedPages())
+	terminal.Print(" free=")
+	printUint(mem.FreePages())
+	terminal.PutRune('\n')
+}
+
+// cmdAlloc allocates one 4KB page and prints its physical address
+func cmdAlloc(a *command.Args) {
+	if !mem.PFAReady() {
+		fail("alloc: pfa not ready\n")
+		return
+	}
+
+	addr := mem.AllocPage()
+	if addr == 0 {
+		fail("alloc: failed\n")
+		return
+	}
+
+	terminal.Print("0x")
+	printHexU64(addr)
+	terminal.PutRune('\n')
+}
+
+// cmdFree frees a previously allocated 4KB page
+func cmdFree(a *command.Args) {
+	if !mem.PFAReady() {
+		fail("free: pfa not ready\n")
+		return
+	}
+
+	addr, ok := parseHex64(a.Span(1))
+	if !ok {
+		fail("free: invalid hex address\n")
+		return
+	}
+
+	if mem.FreePage(addr) {
+		terminal.Print("ok\n")
+	} else {
+		fail("free: failed\n")
+	}
+}
+
+func cmdLs(a *command.Args) {
+	for i := 0; i < fs.MaxFiles(); i++ {
+		used, name, nameLen, size, page := fs.Entry(i)
+		if !used {
+			continue
+		}
+
+		printName(name, nameLen)
+		terminal.Print("  size=")
+		printUint(size)
+		terminal.Print("  page=0x")
+		printHexU64(page)
+		terminal.PutRune('\n')
+	}
+}
+
+func cmdWrite(a *command.Args) {
+	nameLen, ok := copyNameFromRange(a.Span(1))
+	if !ok {
+		fail("write: invalid name\n")
+		return
+	}
+
+	dataLen := copyDataFromRange(restRange(a, 2))
+
+	if !fs.Write(&tmpName, nameLen, (*byte)(unsafe.Pointer(&tmpData[0])), dataLen) {
+		fail("write: failed\n")
+		return
+	}
+
+	terminal.Print("ok\n")
+}
+
+func cmdCat(a *command.Args) {
+	nameLen, ok := copyNameFromRange(a.Span(1))
+	if !ok {
+		fail("cat: invalid name\n")
+		return
+	}
+
+	page, size, ok := fs.Lookup(&tmpName, nameLen)
+	if !ok {
+		fail("cat: not found\n")
+		return
+	}
+
+	terminal.Print(pageString(page, size))
+	terminal.PutRune('\n')
+}
+
+func cmdRm(a *command.Args) {
+	nameLen, ok := copyNameFromRange(a.Span(1))
+	if !ok {
+		fail("rm: invalid name\n")
+		return
+	}
+
+	if fs.Remove(&tmpName, nameLen) {
+		terminal.Print("ok\n")
+	} else {
+		fail("rm: not found\n")
+	}
+}
+
+func cmdStat(a *command.Args) {
+	nameLen, ok := copyNameFromRange(a.Span(1))
+	if !ok {
+		fail("stat: invalid name\n")
+		return
+	}
+
+	page, size, ok := fs.Lookup(&tmpName, nameLen)
+	if !ok {
+		fail("stat: not found\n")
+		return
+	}
+
+	terminal.Print("page=0x")
+	printHexU64(page)
+	terminal.Print(" size=")
+	printUint(size)
+	terminal.PutRune('\n')
+}
+
+func cmdLsblk(a *command.Args) {
+	printBlockDevices()
+}
+
+func cmdDisk(a *command.Args) {
+	switch a.Arg(1) {
+	case "read":
+		lba := 0
+		// Try hex then dec
+		vHex, okHex := parseHex64(a.Span(2))
+		if okHex {
+			lba = int(vHex)
+		} else {
+			vDec, okDec := parseDec(a.Span(2))
+			if !okDec {
+				fail("disk read: invalid lba\n")
+				return
+			}
+			lba = vDec
+		}
+
+		if err := ata.ReadSectors(uint64(lba), 1, &diskBuf[0]); err == ata.OK {
+			terminal.Print("Read Sector ")
+			printUint(uint64(lba))
+			terminal.Print(" OK\n")
+			dumpMemory(uint64(uintptr(unsafe.Pointer(&diskBuf[0]))), 512)
+		} else {
+			fail("Read Failed: ")
+			terminal.Print(err.String())
+			terminal.PutRune('\n')
+		}
+
+	case "write":
+		lba, ok := parseDec(a.Span(2))
+		if !ok {
+			// try hex if needed, but dec is fine
+			vHex, okHex := parseHex64(a.Span(2))
+			if okHex {
+				lba = int(vHex)
+			} else {
+				fail("disk write: invalid lba\n")
+				return
+			}
+		}
+
+		msgStart, end := restRange(a, 3)
+		// Clear diskBuf before writing to avoid stale data
+		for i := 0; i < 512; i++ {
+			diskBuf[i] = 0
+		}
+		idx := 0
+		for i := msgStart; i < end && idx < 512; i++ {
+			diskBuf[idx] = lineBuf[i]
+			idx++
+		}
+
+		if err := ata.WriteSectors(uint64(lba), 1, &diskBuf[0]); err == ata.OK {
+			terminal.Print("Write Sector ")
+			printUint(uint64(lba))
+			terminal.Print(" OK\n")
+		} else {
+			fail("Write Failed: ")
+			terminal.Print(err.String())
+			terminal.PutRune('\n')
+		}
+
+	default:
+		a.Usage()
+	}
+}
+
+func cmdIfconfig(a *command.Args) {
+	printInterfaces()
+}
+
+func cmdPing(a *command.Args) {
+	s, e := a.Span(1)
+	dst, ok := net.ParseIP(lineBuf[s:e])
+	if !ok {
+		fail("ping: invalid address\n")
+		return
+	}
+	count := pingDefaultCount
+	if a.Len() == 2 {
+		count, ok = parseDec(a.Span(2))
+		if !ok || count == 0 {
+			fail("ping: invalid count\n")
+			return
+		}
+	}
+	runPing(dst, count)
+}
+
+func cmdDiskInfo(a *command.Args) {
+	if err := ata.Identify(); err != ata.OK {
+		fail("diskinfo: ")
+		terminal.Print(err.String())
+		terminal.PutRune('\n')
+		return
+	}
+	printDiskInfo(ata.Device())
+}
+
+func cmdDiskBench(a *command.Args) {
+	if nanotimeFn == nil {
+		fail("diskbench: no clock\n")
+		return
+	}
+	sectors := benchDefaultSectors
+	if a.Len() == 1 {
+		v, ok := parseDec(a.Span(1))
+		if !ok || v < 1 || v > benchMaxSectors {
+			a.Usage()
+			return
+		}
+		sectors = v
+	}
+	runDiskBench(sectors)
+}
+
+func cmdFatCreate(a *command.Args) {
+	a1s, a1e := a.Span(1)
+
+	// Parse filename (max 8 chars, no extension for simplicity)
+	var fname [8]byte
+	var fext [3]byte
+	for i := 0; i < 8; i++ {
+		fname[i] = ' '
+	}
+	for i := 0; i < 3; i++ {
+		fext[i] = ' '
+	}
+
+	nameLen := a1e - a1s
+	if nameLen > 8 {
+		nameLen = 8
+	}
+	for i := 0; i < nameLen; i++ {
+		c := lineBuf[a1s+i]
+		if c >= 'a' && c <= 'z' {
+			c = c - 'a' + 'A' // Uppercase
+		}
+		fname[i] = c
+	}
+
+	// Get content
+	msgStart, end := restRange(a, 2)
+	var dataBuf [512]byte
+	// Clear dataBuf before writing to avoid stale data
+	for i := 0; i < 512; i++ {
+		dataBuf[i] = 0
+	}
+	idx := 0
+	for i := msgStart; i < end && idx < 512; i++ {
+		dataBuf[idx] = lineBuf[i]
+		idx++
+	}
+
+	if fat16.CreateFile(&fname, &fext, &dataBuf, uint32(idx)) {
+		terminal.Print("File created\n")
+	} else {
+		fail("Failed to create file\n")
+	}
+}
+
+func cmdFatRead(a *command.Args) {
+	a1s, a1e := a.Span(1)
+
+	var fname [8]byte
+	var fext [3]byte
+	for i := 0; i < 8; i++ {
+		fname[i] = ' '
+	}
+	for i := 0; i < 3; i++ {
+		fext[i] = ' '
+	}
+
+	nameLen := a1e - a1s
+	if nameLen > 8 {
+		nameLen = 8
+	}
+	for i := 0; i < nameLen; i++ {
+		c := lineBuf[a1s+i]
+		if c >= 'a' && c <= 'z' {
+			c = c - 'a' + 'A'
+		}
+		fname[i] = c
+	}
+
+	size, ok := fat16.ReadFile(&fname, &fext, &diskBuf)
+	if !ok {
+		fail("File not found\n")
+		return
+	}
+
+	if size > 512 {
+		size = 512
+	}
+	terminal.Print(bytesString(diskBuf[:size]))
+	terminal.PutRune('\n')
+}
+
+func cmdLayout(a *command.Args) {
+	if a.Len() == 0 {
+		terminal.Print("current layout: ")
+		terminal.Print(currentLayout)
+		terminal.PutRune('\n')
+		return
+	}
+
+	if a.Arg(1) == "list" {
+		printLayouts()
+		return
+	}
+
+	if switchLayoutFn == nil {
+		fail("layout: switcher not wired\n")
+		return
+	}
+
+	name, ok := switchLayoutFn(a.Arg(1))
+	if !ok {
+		fail("layout: no layout ")
+		terminal.Print(a.Arg(1))
+		terminal.Print(", see layout list\n")
+		return
+	}
+
+	currentLayout = name
+	terminal.Print("layout: switched to ")
+	terminal.Print(name)
+	terminal.PutRune('\n')
+}
+
+func cmdVersion(a *command.Args) {
+	printVersion()
+}
+
+func cmdAgent(a *command.Args) {
+	a1s, a1e := a.Span(1)
+	_, end := restRange(a, 1)
+
+	if matchLiteral(a1s, a1e, "show") {
+		a2s, a2e, ok := nextArg(a1e, end)
+		if !ok {
+			fail("Usage: agent show <files|history|version|ticks|memorymap>\n")
+			return
+		}
+		if matchLiteral(a2s, a2e, "files") {
+			runAgentNoTarget(agent.ActionListFiles, agent.IntentListFiles, agent.RiskSafe)
+			return
+		} else if matchLiteral(a2s, a2e, "history") {
+			runAgentNoTarget(agent.ActionShowHistory, agent.IntentShowHistory, agent.RiskSafe)
+			return
+		} else if matchLiteral(a2s, a2e, "version") {
+			runAgentNoTarget(agent.ActionShowVersion, agent.IntentShowVersion, agent.RiskSafe)
+			return
+		} else if matchLiteral(a2s, a2e, "ticks") {
+			runAgentNoTarget(agent.ActionShowTicks, agent.IntentShowTicks, agent.RiskSafe)
+			return
+		} else if matchLiteral(a2s, a2e, "memorymap") || matchLiteral(a2s, a2e, "memory_map") {
+			runAgentNoTarget(agent.ActionShowMemoryMap, agent.IntentShowMemoryMap, agent.RiskSafe)
+			return
+		}
+		fail("Usage: agent show <files|history|version|ticks|memorymap>\n")
+		return
+	} else if matchLiteral(a1s, a1e, "read") {
+		a2s, a2e, ok := nextArg(a1e, end)
+		if !ok {
+			fail("Usage: agent read <name>\n")
+			return
+		}
+		runAgentAction(agent.ActionReadFile, agent.IntentReadFile, agent.RiskSafe, a2s, a2e)
+		return
+	} else if matchLiteral(a1s, a1e, "delete") {
+		a2s, a2e, ok := nextArg(a1e, end)
+		if !ok {
+			fail("Usage: agent delete <name> [confirm]\n")
+			return
+		}
+		a3s, a3e, confirmed := nextArg(a2e, end)
+		if confirmed && matchLiteral(a3s, a3e, "confirm") {
+			runAgentAction(agent.ActionDeleteFile, agent.IntentDeleteFile, agent.RiskSafe, a2s, a2e)
+			return
+		}
+		runAgentAction(agent.ActionDeleteFile, agent.IntentDeleteFile, agent.RiskRisky, a2s, a2e)
+		return
+	} else if matchLiteral(a1s, a1e, "stat") {
+		a2s, a2e, ok := nextArg(a1e, end)
+		if !ok {
+			fail("Usage: agent stat <name>\n")
+			return
+		}
+		runAgentAction(agent.ActionStatFile, agent.IntentStatFile, agent.RiskSafe, a2s, a2e)
+		return
+	} else if matchLiteral(a1s, a1e, "mode") {
+		a2s, a2e, ok := nextArg(a1e, end)
+		if ok {
+			runAgentAction(agent.ActionSetMode, agent.IntentSetMode, agent.RiskSafe, a2s, a2e)
+			return
+		}
+		runAgentNoTarget(agent.ActionSetMode, agent.IntentSetMode, agent.RiskSafe)
+		return
+	} else if matchLiteral(a1s, a1e, "ask") {
+		a2s, _, ok := nextArg(a1e, end)
+		if !ok {
+			fail("Usage: agent ask <request>\n")
+			return
+		}
+		if !agentLLM {
+			fail("agent: ask needs llm mode (agent mode llm)\n")
+			return
+		}
+		response := runtimeAgent.RunLLM(agentPlanner, lineString(a2s, end), &agentContext)
+		printAgentMessage(response.Result.Message)
+		terminal.PutRune('\n')
+		return
+	} else if matchLiteral(a1s, a1e, "help") {
+		runAgentNoTarget(agent.ActionShowHelp, agent.IntentShowHelp, agent.RiskSafe)
+		return
+	}
+
+	fail("Try with agent help to see available commands\n")
+}
+
+func cmdSource(a *command.Args) {
+	runScript(a.Arg(1))
+}
+
+func cmdSet(a *command.Args) {
+	printVars()
+}
+
+func cmdUnset(a *command.Args) {
+	for i := 1; i <= a.Len(); i++ {
+		s, e := a.Span(i)
+		unsetVar(lineBuf[s:e])
+	}
+}
+
+func cmdTrue(a *command.Args) {}
+
+func cmdFalse(a *command.Args) {
+	exitStatus = 1
+}
+
+func cmdTest(a *command.Args) {
+	result, ok := testExpr(a)
+	if !ok {
+		a.Usage()
+		return
+	}
+	if !result {
+		exitStatus = 1
+	}
+}
+
+func cmdLet(a *command.Args) {
+	if !letExpr(a) {
+		a.Usage()
+	}
+}
+
+func cmdExit(a *command.Args) {
+	if scriptDepth == 0 {
+		fail("exit: not in a script\n")
+		return
+	}
+	status := exitStatus
+	if a.Len() == 1 {
+		v, ok := parseInt(a.Span(1))
+		if !ok {
+			fail("exit: invalid status\n")
+			return
+		}
+		status = v
+	}
+	exitStatus = status
+	scriptExit = true
+}
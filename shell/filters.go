@@ -0,0 +1,165 @@
+package shell
+
+import (
+	"github.com/dmarro89/go-dav-os/shell/command"
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+// Filters read a file, or when given none what was piped into them, and
+// print some of its lines. A last line without a newline is still a line.
+
+const defaultFilterLines = 10
+
+// filterInput returns the file named by word i of a, or what was piped in
+// when a has no word i
+func filterInput(a *command.Args, i int) (string, bool) {
+	if a.Len() < i {
+		if in, ok := a.Input(); ok {
+			return in, true
+		}
+		terminal.Print(a.Name())
+		fail(": no file or pipe to read\n")
+		return "", false
+	}
+	n, ok := readFile(a.Arg(i), &fileBuf)
+	if !ok {
+		terminal.Print(a.Name())
+		fail(": cannot read ")
+		terminal.Print(a.Arg(i))
+		terminal.PutRune('\n')
+		return "", false
+	}
+	return bytesString(fileBuf[:n]), true
+}
+
+// nextLine returns the line of text starting at pos, without its newline,
+// and where the line after it starts
+func nextLine(text string, pos int) (string, int) {
+	end := pos
+	for end < len(text) && text[end] != '\n' {
+		end++
+	}
+	if end < len(text) {
+		return text[pos:end], end + 1
+	}
+	return text[pos:end], end
+}
+
+// lineCountArg reads [-n N] from word 1 of a, returning the count and the
+// word the file name would be
+func lineCountArg(a *command.Args) (int, int, bool) {
+	if a.Arg(1) != "-n" {
+		return defaultFilterLines, 1, a.Len() <= 1
+	}
+	n, ok := parseDec(a.Span(2))
+	return n, 3, ok
+}
+
+func containsString(s, sub string) bool {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if s[i:i+len(sub)] == sub {
+			return true
+		}
+	}
+	return false
+}
+
+func cmdGrep(a *command.Args) {
+	invert := a.Arg(1) == "-v" && a.Len() >= 2
+	i := 1
+	if invert {
+		i = 2
+	}
+	if a.Len() > i+1 {
+		a.Usage()
+		return
+	}
+	pattern := a.Arg(i)
+	text, ok := filterInput(a, i+1)
+	if !ok {
+		return
+	}
+
+	matched := false
+	for pos := 0; pos < len(text); {
+		line, next := nextLine(text, pos)
+		pos = next
+		if containsString(line, pattern) != invert {
+			terminal.Print(line)
+			terminal.PutRune('\n')
+			matched = true
+		}
+	}
+	if !matched {
+		exitStatus = 1
+	}
+}
+
+func cmdHead(a *command.Args) {
+	count, i, ok := lineCountArg(a)
+	if !ok {
+		a.Usage()
+		return
+	}
+	text, ok := filterInput(a, i)
+	if !ok {
+		return
+	}
+	for pos := 0; pos < len(text) && count > 0; count-- {
+		line, next := nextLine(text, pos)
+		pos = next
+		terminal.Print(line)
+		terminal.PutRune('\n')
+	}
+}
+
+func cmdTail(a *command.Args) {
+	count, i, ok := lineCountArg(a)
+	if !ok {
+		a.Usage()
+		return
+	}
+	text, ok := filterInput(a, i)
+	if !ok {
+		return
+	}
+
+	lines := 0
+	for pos := 0; pos < len(text); lines++ {
+		_, pos = nextLine(text, pos)
+	}
+	for pos, n := 0, 0; pos < len(text); n++ {
+		line, next := nextLine(text, pos)
+		pos = next
+		if n >= lines-count {
+			terminal.Print(line)
+			terminal.PutRune('\n')
+		}
+	}
+}
+
+func cmdWc(a *command.Args) {
+	text, ok := filterInput(a, 1)
+	if !ok {
+		return
+	}
+	lines, words := 0, 0
+	inWord := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '\n' {
+			lines++
+		}
+		blank := c == ' ' || c == '\t' || c == '\n' || c == '\r'
+		if !blank && !inWord {
+			words++
+		}
+		inWord = !blank
+	}
+	printUint(uint64(lines))
+	terminal.PutRune(' ')
+	printUint(uint64(words))
+	terminal.PutRune(' ')
+	printUint(uint64(len(text)))
+	terminal.PutRune('\n')
+}
@@ -0,0 +1,253 @@
+package shell
+
+import (
+	"github.com/dmarro89/go-dav-os/shell/command"
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+// Programs started with run are jobs. One in the foreground holds the
+// shell until it ends, Ctrl-C kills it and Ctrl-Z stops it; run prog &
+// starts one in the background. jobs lists them, fg and bg resume a
+// stopped one and kill ends one. A job is %N in those commands, N being
+// the number run printed; a plain number is a pid.
+
+// Processes is how the shell runs user programs; the kernel fills it in.
+// A program is known by its pid.
+type Processes struct {
+	// Start starts a program in the background
+	Start func(name *[16]byte, nameLen int) (pid int, ok bool)
+	// Wait returns 0 once the program has ended, or Ctrl-C or Ctrl-Z
+	// when one is typed first
+	Wait     func(pid int) rune
+	Stop     func(pid int) bool
+	Continue func(pid int) bool
+	Kill     func(pid int) bool
+	Alive    func(pid int) bool
+}
+
+const maxJobs = 8
+
+type job struct {
+	// pid is 0 for a free slot
+	pid     int
+	name    [16]byte
+	nameLen int
+	stopped bool
+}
+
+var (
+	processes *Processes
+	jobTable  [maxJobs]job
+	// lastJob is the job fg and bg take by default, the one started or
+	// stopped last, 0 for none
+	lastJob int
+)
+
+// SetProcesses wires run and the job commands to the kernel
+func SetProcesses(p *Processes) { processes = p }
+
+func cmdRun(a *command.Args) {
+	background := a.Len() == 2
+	if background && a.Arg(2) != "&" {
+		a.Usage()
+		return
+	}
+	if processes == nil {
+		fail("run: runner not wired\n")
+		return
+	}
+	nameLen, ok := copyNameFromRange(a.Span(1))
+	if !ok {
+		fail("run: invalid name\n")
+		return
+	}
+	n := 0
+	for i := range jobTable {
+		if jobTable[i].pid == 0 {
+			n = i + 1
+			break
+		}
+	}
+	if n == 0 {
+		fail("run: too many jobs\n")
+		return
+	}
+	pid, ok := processes.Start(&tmpName, nameLen)
+	if !ok {
+		fail("run: not found or no slot\n")
+		return
+	}
+
+	j := &jobTable[n-1]
+	j.pid = pid
+	j.name = tmpName
+	j.nameLen = nameLen
+	j.stopped = false
+	if !background {
+		foreground(n)
+		return
+	}
+	lastJob = n
+	terminal.PutRune('[')
+	printUint(uint64(n))
+	terminal.Print("] ")
+	printUint(uint64(pid))
+	terminal.PutRune('\n')
+}
+
+// foreground waits for job n, killing it on Ctrl-C and stopping it on
+// Ctrl-Z
+func foreground(n int) {
+	j := &jobTable[n-1]
+	switch processes.Wait(j.pid) {
+	case ctrlC:
+		processes.Kill(j.pid)
+		terminal.Print("^C\n")
+		j.pid = 0
+		exitStatus = 130
+	case ctrlZ:
+		processes.Stop(j.pid)
+		j.stopped = true
+		lastJob = n
+		terminal.Print("^Z\n")
+		printJob(n, "Stopped")
+		exitStatus = 148
+	default:
+		j.pid = 0
+	}
+}
+
+func cmdJobs(a *command.Args) {
+	if processes == nil {
+		return
+	}
+	for i := range jobTable {
+		j := &jobTable[i]
+		switch {
+		case j.pid == 0:
+		case !processes.Alive(j.pid):
+			printJob(i+1, "Done")
+			j.pid = 0
+		case j.stopped:
+			printJob(i+1, "Stopped")
+		default:
+			printJob(i+1, "Running")
+		}
+	}
+}
+
+func cmdFg(a *command.Args) {
+	n := jobArg(a, "fg")
+	if n == 0 {
+		return
+	}
+	j := &jobTable[n-1]
+	terminal.Print(bytesString(j.name[:j.nameLen]))
+	terminal.PutRune('\n')
+	processes.Continue(j.pid)
+	j.stopped = false
+	foreground(n)
+}
+
+func cmdBg(a *command.Args) {
+	n := jobArg(a, "bg")
+	if n == 0 {
+		return
+	}
+	j := &jobTable[n-1]
+	processes.Continue(j.pid)
+	j.stopped = false
+	printJob(n, "Running")
+}
+
+func cmdKill(a *command.Args) {
+	n := 0
+	if arg := a.Arg(1); len(arg) > 1 && arg[0] == '%' {
+		n = jobArg(a, "kill")
+		if n == 0 {
+			return
+		}
+	} else if pid, ok := parseDec(a.Span(1)); ok {
+		n = jobOf(pid)
+		if n == 0 {
+			fail("kill: no such job\n")
+			return
+		}
+	} else {
+		a.Usage()
+		return
+	}
+	j := &jobTable[n-1]
+	if processes.Kill(j.pid) {
+		printJob(n, "Killed")
+	} else {
+		printJob(n, "Done")
+	}
+	j.pid = 0
+}
+
+// jobArg returns the job named by word 1 of a, %N or N, or lastJob when
+// there is no word 1. It prints why and returns 0 when there is no such
+// job or it has ended.
+func jobArg(a *command.Args, cmd string) int {
+	n := lastJob
+	if a.Len() == 1 {
+		start, end := a.Span(1)
+		if lineBuf[start] == '%' {
+			start++
+		}
+		v, ok := parseDec(start, end)
+		if !ok {
+			a.Usage()
+			return 0
+		}
+		n = v
+	}
+	if processes == nil || n < 1 || n > maxJobs || jobTable[n-1].pid == 0 {
+		terminal.Print(cmd)
+		fail(": no such job\n")
+		return 0
+	}
+	if !processes.Alive(jobTable[n-1].pid) {
+		printJob(n, "Done")
+		jobTable[n-1].pid = 0
+		exitStatus = 1
+		return 0
+	}
+	return n
+}
+
+// jobOf returns the number of the job running pid, 0 for none
+func jobOf(pid int) int {
+	for i := range jobTable {
+		if pid > 0 && jobTable[i].pid == pid {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func printJob(n int, state string) {
+	j := &jobTable[n-1]
+	terminal.PutRune('[')
+	printUint(uint64(n))
+	terminal.Print("] ")
+	terminal.Print(state)
+	terminal.PutRune(' ')
+	terminal.Print(bytesString(j.name[:j.nameLen]))
+	terminal.PutRune('\n')
+}
+
+// reportDoneJobs tells of background jobs that ended since the last
+// prompt
+func reportDoneJobs() {
+	if processes == nil {
+		return
+	}
+	for i := range jobTable {
+		if j := &jobTable[i]; j.pid != 0 && !processes.Alive(j.pid) {
+			printJob(i+1, "Done")
+			j.pid = 0
+		}
+	}
+}
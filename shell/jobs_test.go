@@ -0,0 +1,156 @@
+package shell
+
+import (
+	"testing"
+
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+// fakeKernel stands in for the kernel's processes: programs run until
+// they are killed or end is called, and Wait returns the next key queued
+type fakeKernel struct {
+	next    int
+	alive   map[int]bool
+	stopped map[int]bool
+	keys    []rune
+}
+
+func (k *fakeKernel) end(pid int) { delete(k.alive, pid) }
+
+func setupJobs(t *testing.T) *fakeKernel {
+	t.Helper()
+	terminal.Init()
+	resetScripting()
+	jobTable = [maxJobs]job{}
+	lastJob = 0
+
+	k := &fakeKernel{next: 10, alive: map[int]bool{}, stopped: map[int]bool{}}
+	SetProcesses(&Processes{
+		Start: func(name *[16]byte, nameLen int) (int, bool) {
+			if string(name[:nameLen]) == "missing" {
+				return -1, false
+			}
+			k.next++
+			k.alive[k.next] = true
+			return k.next, true
+		},
+		Wait: func(pid int) rune {
+			if len(k.keys) == 0 {
+				k.end(pid)
+				return 0
+			}
+			key := k.keys[0]
+			k.keys = k.keys[1:]
+			return key
+		},
+		Stop:     func(pid int) bool { k.stopped[pid] = true; return k.alive[pid] },
+		Continue: func(pid int) bool { delete(k.stopped, pid); return k.alive[pid] },
+		Kill: func(pid int) bool {
+			ok := k.alive[pid]
+			k.end(pid)
+			return ok
+		},
+		Alive: func(pid int) bool { return k.alive[pid] },
+	})
+	t.Cleanup(func() { SetProcesses(nil) })
+	return k
+}
+
+func TestRunInForegroundAndBackground(t *testing.T) {
+	k := setupJobs(t)
+
+	if got := runTyped("run hello"); got != "" || jobTable[0].pid != 0 {
+		t.Fatalf("foreground run: %q, job left %d", got, jobTable[0].pid)
+	}
+	if got := runTyped("run hello &", "run echo &", "jobs"); got != "[1] 12\n[2] 13\n[1] Running hello\n[2] Running echo\n" {
+		t.Fatalf("output = %q", got)
+	}
+	if got := runTyped("run missing"); got != "run: not found or no slot\n" || exitStatus != 1 {
+		t.Fatalf("output = %q, status %d", got, exitStatus)
+	}
+	if got := runTyped("run hello now"); got != "Usage: run <program> [&]\n" || exitStatus != 2 {
+		t.Fatalf("output = %q, status %d", got, exitStatus)
+	}
+
+	k.end(12)
+	terminal.ResetOutputForTesting()
+	setLineBuf("")
+	FeedRune('\n')
+	if got := terminal.OutputForTesting(); got != "\n[1] Done hello\n"+prompt {
+		t.Fatalf("done report = %q", got)
+	}
+	if got := runTyped("jobs"); got != "[2] Running echo\n" {
+		t.Fatalf("jobs after done = %q", got)
+	}
+}
+
+func TestCtrlCAndCtrlZInForeground(t *testing.T) {
+	k := setupJobs(t)
+
+	k.keys = []rune{ctrlC}
+	if got := runTyped("run hello"); got != "^C\n" || exitStatus != 130 || k.alive[11] {
+		t.Fatalf("Ctrl-C: %q, status %d", got, exitStatus)
+	}
+
+	k.keys = []rune{ctrlZ}
+	if got := runTyped("run echo"); got != "^Z\n[1] Stopped echo\n" || exitStatus != 148 || !k.stopped[12] {
+		t.Fatalf("Ctrl-Z: %q, status %d", got, exitStatus)
+	}
+	if got := runTyped("jobs", "bg"); got != "[1] Stopped echo\n[1] Running echo\n" || k.stopped[12] {
+		t.Fatalf("bg: %q", got)
+	}
+
+	k.keys = []rune{ctrlZ}
+	if got := runTyped("fg %1"); got != "echo\n^Z\n[1] Stopped echo\n" {
+		t.Fatalf("fg then Ctrl-Z: %q", got)
+	}
+	if got := runTyped("fg"); got != "echo\n" || k.alive[12] || jobTable[0].pid != 0 {
+		t.Fatalf("fg to the end: %q", got)
+	}
+	if got := runTyped("fg"); got != "fg: no such job\n" || exitStatus != 1 {
+		t.Fatalf("fg without jobs: %q, status %d", got, exitStatus)
+	}
+}
+
+func TestKillJobOrPid(t *testing.T) {
+	k := setupJobs(t)
+
+	got := runTyped("run hello &", "run echo &", "kill %2", "kill 11", "kill 11", "kill x", "jobs")
+	want := "[1] 11\n[2] 12\n[2] Killed echo\n[1] Killed hello\nkill: no such job\nUsage: kill <%job|pid>\n"
+	if got != want || k.alive[11] || k.alive[12] {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestConsoleKeysDuringForegroundJob(t *testing.T) {
+	k := setupJobs(t)
+	started[0] = true
+	setLineBuf("")
+	t.Cleanup(func() {
+		SwitchConsole(0)
+		sessions[1], started[1] = session{}, false
+		setLineBuf("")
+	})
+
+	// Alt+F2 while the job runs shows console 2, but the job's messages
+	// and the prompt after it stay on the first console
+	stoppedOn := -1
+	k.keys = []rune{ctrlZ}
+	stop := processes.Stop
+	processes.Stop = func(pid int) bool {
+		stoppedOn = terminal.OutputConsole()
+		return stop(pid)
+	}
+	wait := processes.Wait
+	processes.Wait = func(pid int) rune {
+		ConsoleKey(altF(1))
+		return wait(pid)
+	}
+	typeLine("run hello\n")
+	if terminal.ActiveConsole() != 1 || stoppedOn != 0 {
+		t.Fatalf("console %d, job stopped with output on %d", terminal.ActiveConsole(), stoppedOn)
+	}
+	if activeSession != 1 || terminal.OutputConsole() != 1 {
+		t.Fatalf("session %d, output %d once the line is done", activeSession, terminal.OutputConsole())
+	}
+}
@@ -0,0 +1,530 @@
+package shell
+
+import (
+	"github.com/dmarro89/go-dav-os/fs"
+	"github.com/dmarro89/go-dav-os/fs/fat16"
+	"github.com/dmarro89/go-dav-os/keyboard"
+	"github.com/dmarro89/go-dav-os/shell/command"
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+// Control characters the line editor acts on, as Ctrl plus a letter types
+// them on the keyboard or a terminal on the serial line
+const (
+	ctrlA  = 0x01 // start of line
+	ctrlC  = 0x03 // cancel the line, or kill the program in the foreground
+	ctrlE  = 0x05 // end of line
+	ctrlK  = 0x0B // kill to the end
+	ctrlU  = 0x15 // kill to the start
+	ctrlW  = 0x17 // kill the word before the cursor
+	ctrlZ  = 0x1A // stop the program in the foreground
+	escape = 0x1B
+)
+
+const (
+	maxCandidates = 64
+	maxCandidate  = 16
+)
+
+var (
+	// lineCursor is the index in lineBuf the next character goes in
+	lineCursor int
+
+	// historyPos counts how far back Up has walked the history, 0 being
+	// the line typed before browsing started, which draftBuf keeps
+	historyPos int
+	draftBuf   [maxLine]byte
+	draftLen   int
+
+	// escState follows an ANSI cursor key sequence from the serial line:
+	// 1 after ESC, 2 inside "ESC [" while escParam collects the number
+	escState int
+	escParam int
+
+	// Completions of the word at the cursor, plus a slot to stage the
+	// next name in
+	candidates     [maxCandidates + 1][maxCandidate]byte
+	candidateLen   [maxCandidates + 1]int
+	candidateCount int
+)
+
+// FeedKey handles a key event from the keyboard: the console keys switch
+// consoles or scroll, the editing keys move through the line and
+// everything that types a character goes to FeedRune
+func FeedKey(ev keyboard.Event) {
+	if !ev.Pressed {
+		return
+	}
+	if ConsoleKey(ev) {
+		return
+	}
+	if !editKey(ev.Key) && ev.Rune != 0 {
+		FeedRune(ev.Rune)
+	}
+}
+
+// editKey applies a cursor or history key, reporting whether key is one
+func editKey(key keyboard.Keycode) bool {
+	switch key {
+	case keyboard.KeyLeft:
+		moveCursor(prevRune(lineCursor))
+	case keyboard.KeyRight:
+		moveCursor(nextRune(lineCursor))
+	case keyboard.KeyHome:
+		moveCursor(0)
+	case keyboard.KeyEnd:
+		moveCursor(lineLen)
+	case keyboard.KeyDelete:
+		deleteAtCursor()
+	case keyboard.KeyUp:
+		recallHistory(historyPos + 1)
+	case keyboard.KeyDown:
+		recallHistory(historyPos - 1)
+	default:
+		return false
+	}
+	return true
+}
+
+// editControl applies a control character, reporting whether r is one
+func editControl(r rune) bool {
+	switch r {
+	case ctrlA:
+		moveCursor(0)
+	case ctrlE:
+		moveCursor(lineLen)
+	case ctrlK:
+		deleteRange(lineCursor, lineLen)
+	case ctrlU:
+		deleteRange(0, lineCursor)
+	case ctrlW:
+		deleteRange(wordStart(), lineCursor)
+	case '\t':
+		complete()
+	default:
+		return false
+	}
+	return true
+}
+
+// escapeSequence feeds r to the ANSI cursor key decoder, reporting whether
+// it was taken as part of a sequence. The arrows, Home, End and Delete of
+// a terminal on the serial line arrive this way.
+func escapeSequence(r rune) bool {
+	switch {
+	case r == escape:
+		escState, escParam = 1, 0
+		return true
+	case escState == 1:
+		escState = 0
+		if r == '[' || r == 'O' {
+			escState = 2
+			return true
+		}
+		return false
+	case escState != 2:
+		return false
+	case r >= '0' && r <= '9':
+		escParam = escParam*10 + int(r-'0')
+		return true
+	}
+
+	escState = 0
+	switch r {
+	case 'A':
+		editKey(keyboard.KeyUp)
+	case 'B':
+		editKey(keyboard.KeyDown)
+	case 'C':
+		editKey(keyboard.KeyRight)
+	case 'D':
+		editKey(keyboard.KeyLeft)
+	case 'H':
+		editKey(keyboard.KeyHome)
+	case 'F':
+		editKey(keyboard.KeyEnd)
+	case '~':
+		switch escParam {
+		case 1, 7:
+			editKey(keyboard.KeyHome)
+		case 4, 8:
+			editKey(keyboard.KeyEnd)
+		case 3:
+			editKey(keyboard.KeyDelete)
+		}
+	}
+	return true
+}
+
+// moveCursor puts the cursor on index i of the line, clamped to its ends
+func moveCursor(i int) {
+	if i < 0 {
+		i = 0
+	}
+	if i > lineLen {
+		i = lineLen
+	}
+	if i < lineCursor {
+		terminal.CursorBack(runeCount(i, lineCursor))
+	} else if i > lineCursor {
+		terminal.CursorForward(runeCount(lineCursor, i))
+	}
+	lineCursor = i
+}
+
+// lineBuf holds UTF-8 and every rune takes one cell on screen. The indices
+// into it stay on rune boundaries.
+
+// runeCount is the number of runes, and cells, in lineBuf[from:to]
+func runeCount(from, to int) int {
+	n := 0
+	for i := from; i < to; i++ {
+		if lineBuf[i]&0xC0 != 0x80 {
+			n++
+		}
+	}
+	return n
+}
+
+// prevRune is the start of the rune before index i
+func prevRune(i int) int {
+	if i <= 0 {
+		return 0
+	}
+	i--
+	for i > 0 && lineBuf[i]&0xC0 == 0x80 {
+		i--
+	}
+	return i
+}
+
+// nextRune is the start of the rune after the one at index i
+func nextRune(i int) int {
+	if i >= lineLen {
+		return lineLen
+	}
+	i++
+	for i < lineLen && lineBuf[i]&0xC0 == 0x80 {
+		i++
+	}
+	return i
+}
+
+// insertRune puts r at the cursor, shifting the rest of the line right
+func insertRune(r rune) {
+	var buf [4]byte
+	n := terminal.EncodeRune(r, &buf)
+	if lineLen+n > maxLine {
+		return
+	}
+	for i := lineLen - 1; i >= lineCursor; i-- {
+		lineBuf[i+n] = lineBuf[i]
+	}
+	for i := 0; i < n; i++ {
+		lineBuf[lineCursor+i] = buf[i]
+	}
+	lineLen += n
+	lineCursor += n
+	terminal.PutRune(r)
+	redrawTail(0)
+}
+
+// insertText types the UTF-8 text s at the cursor
+func insertText(s string) {
+	for i := 0; i < len(s); {
+		r, n := terminal.DecodeRune(s[i:])
+		insertRune(r)
+		i += n
+	}
+}
+
+// deleteBeforeCursor removes the character left of the cursor, as
+// Backspace does
+func deleteBeforeCursor() {
+	if lineCursor == 0 {
+		return
+	}
+	if lineCursor == lineLen {
+		lineLen = prevRune(lineCursor)
+		lineCursor = lineLen
+		terminal.Backspace()
+		return
+	}
+	deleteRange(prevRune(lineCursor), lineCursor)
+}
+
+// deleteAtCursor removes the character under the cursor, as Delete does
+func deleteAtCursor() {
+	if lineCursor < lineLen {
+		deleteRange(lineCursor, nextRune(lineCursor))
+	}
+}
+
+// deleteRange removes lineBuf[from:to] and leaves the cursor at from
+func deleteRange(from, to int) {
+	if from >= to {
+		return
+	}
+	moveCursor(from)
+	erased := runeCount(from, to)
+	n := to - from
+	for i := from; i+n < lineLen; i++ {
+		lineBuf[i] = lineBuf[i+n]
+	}
+	lineLen -= n
+	redrawTail(erased)
+}
+
+// wordStart is where the word before the cursor begins, skipping the
+// blanks between it and the cursor as Ctrl-W does
+func wordStart() int {
+	i := lineCursor
+	for i > 0 && isSpace(lineBuf[i-1]) {
+		i--
+	}
+	for i > 0 && !isSpace(lineBuf[i-1]) {
+		i--
+	}
+	return i
+}
+
+// redrawTail prints the line from the cursor on, blanks the erased cells
+// the line no longer covers and returns the cursor where it was
+func redrawTail(erased int) {
+	terminal.Print(lineString(lineCursor, lineLen))
+	for i := 0; i < erased; i++ {
+		terminal.PutRune(' ')
+	}
+	if n := runeCount(lineCursor, lineLen) + erased; n > 0 {
+		terminal.CursorBack(n)
+	}
+}
+
+// cancelLine drops the line being typed and shows a fresh prompt
+func cancelLine() {
+	moveCursor(lineLen)
+	terminal.Print("^C\n")
+	lineLen = 0
+	lineCursor = 0
+	historyPos = 0
+	terminal.Print(prompt)
+}
+
+// redrawLine prints the prompt and the whole line on a fresh row, after
+// output that ran over the line being edited
+func redrawLine() {
+	terminal.Print(prompt)
+	terminal.Print(lineString(0, lineLen))
+	if lineLen > lineCursor {
+		terminal.CursorBack(runeCount(lineCursor, lineLen))
+	}
+}
+
+// recallHistory replaces the line with the entry pos commands back, 0
+// bringing back the line typed before browsing started
+func recallHistory(pos int) {
+	if pos < 0 || pos > historyCount || pos == historyPos {
+		return
+	}
+	if historyPos == 0 {
+		for i := 0; i < lineLen; i++ {
+			draftBuf[i] = lineBuf[i]
+		}
+		draftLen = lineLen
+	}
+	historyPos = pos
+	if pos == 0 {
+		replaceLine(&draftBuf, draftLen)
+		return
+	}
+	idx := (historyHead - pos + maxHistory) % maxHistory
+	replaceLine(&historyBuf[idx], historyLen[idx])
+}
+
+// replaceLine shows src[:n] in place of the line, cursor at the end
+func replaceLine(src *[maxLine]byte, n int) {
+	moveCursor(0)
+	erased := runeCount(0, lineLen)
+	for i := 0; i < n; i++ {
+		lineBuf[i] = src[i]
+	}
+	lineLen = n
+	erased -= runeCount(0, lineLen)
+	if erased < 0 {
+		erased = 0
+	}
+	redrawTail(erased)
+	moveCursor(lineLen)
+}
+
+// complete extends the word before the cursor with Tab: the first word of
+// the line against the command names, later ones against the files on the
+// RAM fs and the FAT16 root. Several matches extend to their common prefix
+// and, when that adds nothing, get listed; a command with no match lists
+// the names calculateDistance finds close to it.
+func complete() {
+	start := lineCursor
+	for start > 0 && !isSpace(lineBuf[start-1]) {
+		start--
+	}
+	firstWord := trimLeft(0, start) == start
+
+	candidateCount = 0
+	if firstWord {
+		RegisterCommands()
+		for i := 0; i < command.Count(); i++ {
+			name := command.At(i).Name
+			n := len(name)
+			for j := 0; j < n && j < maxCandidate; j++ {
+				candidates[candidateCount][j] = name[j]
+			}
+			offerCandidate(start, n)
+		}
+	} else {
+		for i := 0; i < fs.MaxFiles(); i++ {
+			used, name, nameLen, _, _ := fs.Entry(i)
+			if !used {
+				continue
+			}
+			for j := 0; j < nameLen; j++ {
+				candidates[candidateCount][j] = name[j]
+			}
+			offerCandidate(start, nameLen)
+		}
+		var fatName [12]byte
+		for i := 0; ; i++ {
+			n, more := fat16.DirName(i, &fatName)
+			if !more {
+				break
+			}
+			for j := 0; j < n; j++ {
+				candidates[candidateCount][j] = fatName[j]
+			}
+			offerCandidate(start, n)
+		}
+	}
+
+	typed := lineCursor - start
+	if candidateCount == 0 {
+		if firstWord && typed > 0 {
+			suggestCommands(start)
+		}
+		return
+	}
+	// Matched regardless of case, the word takes the case of the name
+	for j := 0; j < typed; j++ {
+		if lineBuf[start+j] != candidates[0][j] {
+			deleteRange(start, lineCursor)
+			typed = 0
+			break
+		}
+	}
+
+	switch {
+	case candidateCount == 1:
+		insertText(bytesString(candidates[0][typed:candidateLen[0]]))
+		if lineCursor == lineLen || !isSpace(lineBuf[lineCursor]) {
+			insertRune(' ')
+		}
+		return
+	}
+
+	common := candidateLen[0]
+	for i := 1; i < candidateCount; i++ {
+		j := typed
+		for j < common && j < candidateLen[i] && foldCase(candidates[i][j]) == foldCase(candidates[0][j]) {
+			j++
+		}
+		common = j
+	}
+	// Names can share the first bytes of different runes
+	for common > typed && common < candidateLen[0] && candidates[0][common]&0xC0 == 0x80 {
+		common--
+	}
+	if common > typed {
+		insertText(bytesString(candidates[0][typed:common]))
+		return
+	}
+
+	moveCursorAside()
+	for i := 0; i < candidateCount; i++ {
+		if i > 0 {
+			terminal.Print("  ")
+		}
+		terminal.Print(bytesString(candidates[i][:candidateLen[i]]))
+	}
+	terminal.PutRune('\n')
+	redrawLine()
+}
+
+// offerCandidate keeps the n-byte name staged in the next candidates slot
+// when it starts with lineBuf[start:lineCursor], ignoring case as FAT16
+// names are upper case, and is not already kept
+func offerCandidate(start, n int) {
+	typed := lineCursor - start
+	if candidateCount >= maxCandidates || n > maxCandidate || n < typed {
+		return
+	}
+	name := &candidates[candidateCount]
+	for j := 0; j < typed; j++ {
+		if foldCase(name[j]) != foldCase(lineBuf[start+j]) {
+			return
+		}
+	}
+	for i := 0; i < candidateCount; i++ {
+		if candidateLen[i] != n {
+			continue
+		}
+		same := true
+		for j := 0; j < n; j++ {
+			if candidates[i][j] != name[j] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return
+		}
+	}
+	candidateLen[candidateCount] = n
+	candidateCount++
+}
+
+// suggestCommands lists the command names close to the unknown word
+// lineBuf[start:lineCursor]
+func suggestCommands(start int) {
+	found := false
+	for i := 0; i < command.Count(); i++ {
+		name := command.At(i).Name
+		if calculateDistance(start, lineCursor, name) >= maxDistanceThreshold {
+			continue
+		}
+		if !found {
+			moveCursorAside()
+			terminal.Print("Did you mean '")
+			found = true
+		} else {
+			terminal.Print(" ")
+		}
+		terminal.Print(name)
+	}
+	if found {
+		terminal.Print("'?\n")
+		redrawLine()
+	}
+}
+
+// moveCursorAside ends the row holding the line so a listing can follow
+func moveCursorAside() {
+	if lineLen > lineCursor {
+		terminal.CursorForward(runeCount(lineCursor, lineLen))
+	}
+	terminal.PutRune('\n')
+}
+
+func foldCase(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
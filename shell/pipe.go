@@ -0,0 +1,216 @@
+package shell
+
+import (
+	"unsafe"
+
+	"github.com/dmarro89/go-dav-os/fs"
+	"github.com/dmarro89/go-dav-os/fs/fat16"
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+// A line may join commands with |, each reading what the one before it
+// printed, and may end in > file to write the output of the last one to a
+// file or >> file to add it to the end. Output is captured in memory, so a
+// stage passes on at most a page, and a /disk/ file takes one sector; what
+// is cut off is reported and leaves $? at 1.
+//
+//	cat notes | grep todo | wc
+//	lspci > /disk/PCI.TXT
+//	date >> log
+
+var (
+	// Stages alternate between the two, reading one and printing into the
+	// other
+	pipeBufs [2]terminal.Capture
+	piping   bool
+
+	// pipeInput is handed to the next command run, when piped is set
+	pipeInput string
+	piped     bool
+
+	// fileBuf holds a file read by a filter or grown by >>
+	fileBuf [maxFile]byte
+)
+
+// runPipeline expands and runs the commands of text, joined by | and
+// with an optional > or >> at the end, leaving $? at the status of the
+// last one
+func runPipeline(text []byte) {
+	cmds, target, appending, ok := splitRedirect(text)
+	if !ok {
+		syntaxError(">")
+		return
+	}
+	stages := 1
+	for i := 0; i < len(cmds); i++ {
+		if cmds[i] == '|' {
+			stages++
+		}
+	}
+	if stages == 1 && target == nil {
+		lineLen = expand(cmds, &lineBuf)
+		runCommand()
+		return
+	}
+	if piping {
+		fail("pipes and redirections do not nest\n")
+		return
+	}
+
+	// The target is expanded before the commands reuse lineBuf
+	var path [maxLine]byte
+	pathLen := 0
+	if target != nil {
+		pathLen = expand(target, &path)
+	}
+
+	piping = true
+	var out *terminal.Capture
+	for i := 0; i < stages; i++ {
+		stage := trimBytes(nextStage(&cmds))
+		if len(stage) == 0 {
+			piping = false
+			if stages == 1 {
+				syntaxError(">")
+			} else {
+				syntaxError("|")
+			}
+			return
+		}
+		last := i == stages-1
+		if i > 0 {
+			pipeInput = bytesString(out.Bytes())
+			piped = true
+		}
+		out = nil
+		if !last || target != nil {
+			out = &pipeBufs[i%2]
+		}
+
+		var prev *terminal.Capture
+		if out != nil {
+			prev = terminal.StartCapture(out)
+		}
+		lineLen = expand(stage, &lineBuf)
+		runCommand()
+		piped = false
+		if out != nil {
+			terminal.StopCapture(prev)
+			if out.Truncated() {
+				terminal.Print("shell: output cut at 4096 bytes\n")
+			}
+		}
+	}
+	piping = false
+
+	if target == nil {
+		return
+	}
+	name := bytesString(path[:pathLen])
+	size, ok := writeOutput(name, out.Bytes(), appending)
+	if !ok {
+		fail("shell: cannot write ")
+		terminal.Print(name)
+		terminal.PutRune('\n')
+		return
+	}
+	if size != 0 {
+		fail("shell: ")
+		terminal.Print(name)
+		terminal.Print(" cut at ")
+		printUint(uint64(size))
+		terminal.Print(" bytes\n")
+	}
+}
+
+// splitRedirect cuts a trailing > file or >> file off text. It fails when
+// the target is missing or is not a single word.
+func splitRedirect(text []byte) (cmds, target []byte, appending, ok bool) {
+	r := 0
+	for r < len(text) && text[r] != '>' {
+		r++
+	}
+	if r == len(text) {
+		return text, nil, false, true
+	}
+	rest := text[r+1:]
+	if len(rest) > 0 && rest[0] == '>' {
+		appending = true
+		rest = rest[1:]
+	}
+	rest = trimBytes(rest)
+	if len(rest) == 0 {
+		return nil, nil, false, false
+	}
+	for i := 0; i < len(rest); i++ {
+		if rest[i] == ' ' || rest[i] == '\t' || rest[i] == '>' || rest[i] == '|' {
+			return nil, nil, false, false
+		}
+	}
+	return text[:r], rest, appending, true
+}
+
+// nextStage returns the text up to the next | and moves cmds past it
+func nextStage(cmds *[]byte) []byte {
+	text := *cmds
+	for i := 0; i < len(text); i++ {
+		if text[i] == '|' {
+			*cmds = text[i+1:]
+			return text[:i]
+		}
+	}
+	*cmds = text[len(text):]
+	return text
+}
+
+func syntaxError(near string) {
+	terminal.Print("shell: syntax error near ")
+	terminal.Print(near)
+	terminal.PutRune('\n')
+	exitStatus = 2
+}
+
+// writeOutput writes data to a RAM fs file, or with a /disk/ path to a
+// FAT16 file, adding it to the end with appending. What does not fit in
+// the file is dropped, and cutAt is then the size of the file, 0 when
+// all of data went in.
+func writeOutput(path string, data []byte, appending bool) (cutAt int, ok bool) {
+	n := 0
+	if appending {
+		// A file that exists but cannot be read whole is left alone
+		// rather than replaced by data
+		n, ok = readFile(path, &fileBuf)
+		if !ok && fileExists(path) {
+			return 0, false
+		}
+	}
+	i := 0
+	for ; i < len(data) && n < len(fileBuf); i++ {
+		fileBuf[n] = data[i]
+		n++
+	}
+	if i < len(data) {
+		cutAt = n
+	}
+
+	if len(path) > len(diskPrefix) && path[:len(diskPrefix)] == diskPrefix {
+		var fname [8]byte
+		var fext [3]byte
+		if !fatName(path[len(diskPrefix):], &fname, &fext) {
+			return 0, false
+		}
+		if n > len(diskBuf) {
+			n = len(diskBuf)
+			cutAt = n
+		}
+		for i := 0; i < n; i++ {
+			diskBuf[i] = fileBuf[i]
+		}
+		return cutAt, fat16.WriteFile(&fname, &fext, &diskBuf, uint32(n))
+	}
+
+	if !ramName(path) {
+		return 0, false
+	}
+	return cutAt, fs.Write(&tmpName, len(path), (*byte)(unsafe.Pointer(&fileBuf[0])), uint32(n))
+}
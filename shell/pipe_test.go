@@ -0,0 +1,119 @@
+package shell
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dmarro89/go-dav-os/fs"
+	"github.com/dmarro89/go-dav-os/fs/fat16"
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+// runTyped runs each line as if typed and returns the output
+func runTyped(lines ...string) string {
+	terminal.ResetOutputForTesting()
+	for _, line := range lines {
+		setLineBuf(line)
+		execute()
+	}
+	return terminal.OutputForTesting()
+}
+
+func resetPipes(t *testing.T) {
+	t.Helper()
+	fs.Init()
+	fs.SetupMockPFA()
+	terminal.Init()
+	resetScripting()
+}
+
+func TestPipeIntoFilters(t *testing.T) {
+	resetPipes(t)
+	writeScript(t, "f", "alpha\nbeta\ngamma\ndelta\n")
+
+	got := runTyped("cat f | grep a | grep -v ph")
+	if got != "beta\ngamma\ndelta\n" || exitStatus != 0 {
+		t.Fatalf("grep: %q, status %d", got, exitStatus)
+	}
+	if got := runTyped("grep zeta f"); got != "" || exitStatus != 1 {
+		t.Fatalf("grep without a match: %q, status %d", got, exitStatus)
+	}
+	if got := runTyped("head -n 2 f", "cat f | tail -n 3 | head -n 1"); got != "alpha\nbeta\ngamma\n" {
+		t.Fatalf("head and tail: %q", got)
+	}
+	// cat adds a newline of its own
+	if got := runTyped("cat f | wc", "echo one two | wc"); got != "5 4 24\n1 2 8\n" {
+		t.Fatalf("wc: %q", got)
+	}
+}
+
+func TestPipeStatusAndErrors(t *testing.T) {
+	resetPipes(t)
+	// There is no separate error output, so errors go down the pipe too
+	if got := runTyped("nosuch | wc"); got != "1 3 24\n" || exitStatus != 0 {
+		t.Fatalf("output = %q, status %d", got, exitStatus)
+	}
+	if got := runTyped("echo a | false"); got != "" || exitStatus != 1 {
+		t.Fatalf("output = %q, status %d", got, exitStatus)
+	}
+	if got := runTyped("echo a |"); got != "shell: syntax error near |\n" || exitStatus != 2 {
+		t.Fatalf("output = %q, status %d", got, exitStatus)
+	}
+	if got := runTyped("echo a > b c"); got != "shell: syntax error near >\n" || exitStatus != 2 {
+		t.Fatalf("output = %q, status %d", got, exitStatus)
+	}
+	if got := runTyped("wc"); got != "wc: no file or pipe to read\n" || exitStatus != 1 {
+		t.Fatalf("output = %q, status %d", got, exitStatus)
+	}
+}
+
+func TestRedirectToRAMFile(t *testing.T) {
+	resetPipes(t)
+	got := runTyped("F=out", "echo one > $F", "echo two >> out", "echo three | grep th >> out", "cat out")
+	if got != "one\ntwo\nthree\n\n" {
+		t.Fatalf("output = %q", got)
+	}
+	if got := runTyped("echo new > out", "cat out"); got != "new\n\n" {
+		t.Fatalf("overwrite: %q", got)
+	}
+
+	writeScript(t, "big", strings.Repeat("x", 4000))
+	if got := runTyped("cat big >> big"); got != "shell: big cut at 4096 bytes\n" || exitStatus != 1 {
+		t.Fatalf("append past a page: %q, status %d", got, exitStatus)
+	}
+}
+
+func TestRedirectToDisk(t *testing.T) {
+	resetPipes(t)
+	fat16.FilesForTesting = map[string]string{"LOG.TXT": "old\n"}
+	t.Cleanup(func() { fat16.FilesForTesting = nil })
+
+	runTyped("echo new >> /disk/log.txt", "echo hi > /disk/hi.txt")
+	if got := fat16.FilesForTesting["LOG.TXT"]; got != "old\nnew\n" {
+		t.Fatalf("LOG.TXT = %q", got)
+	}
+	if got := fat16.FilesForTesting["HI.TXT"]; got != "hi\n" {
+		t.Fatalf("HI.TXT = %q", got)
+	}
+	writeScript(t, "big", strings.Repeat("x", 600))
+	got := runTyped("cat big > /disk/big.txt")
+	if got != "shell: /disk/big.txt cut at 512 bytes\n" || exitStatus != 1 || len(fat16.FilesForTesting["BIG.TXT"]) != 512 {
+		t.Fatalf("write past a sector: %q, status %d", got, exitStatus)
+	}
+	fat16.FilesForTesting["BIG.TXT"] = strings.Repeat("x", 600)
+	got = runTyped("echo y >> /disk/big.txt")
+	if got != "shell: /disk/big.txt cut at 512 bytes\nshell: cannot write /disk/big.txt\n" || exitStatus != 1 || len(fat16.FilesForTesting["BIG.TXT"]) != 600 {
+		t.Fatalf("append to a file past a sector: %q, status %d", got, exitStatus)
+	}
+	if got := runTyped("echo x > /disk/toolongname.txt"); got != "shell: cannot write /disk/toolongname.txt\n" || exitStatus != 1 {
+		t.Fatalf("output = %q, status %d", got, exitStatus)
+	}
+}
+
+func TestPipesDoNotNest(t *testing.T) {
+	resetPipes(t)
+	writeScript(t, "p.sh", "echo in | wc\necho $?\n")
+	if got := runTyped("source p.sh | grep -v zzz"); got != "pipes and redirections do not nest\n1\n" {
+		t.Fatalf("output = %q", got)
+	}
+}
@@ -0,0 +1,716 @@
+package shell
+
+import (
+	"github.com/dmarro89/go-dav-os/fs"
+	"github.com/dmarro89/go-dav-os/fs/fat16"
+	"github.com/dmarro89/go-dav-os/shell/command"
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+// Scripts are text files of shell lines, run by source from the RAM fs or,
+// under /disk/, from the FAT16 root directory. Besides commands a line may
+// hold:
+//
+//	# comment
+//	NAME=value            set a variable, read back as $NAME or ${NAME};
+//	                      the value may be in double quotes
+//	if <command>          run the block when the command exits with 0
+//	else
+//	fi
+//	while <command>       repeat the block while the command exits with 0
+//	done
+//	for NAME in <words>   run the block once per word
+//	done
+//
+// An if, while or for line may end in "; then" or "; do", and lines that
+// are just "then" or "do" are skipped, so scripts read like sh. $? is the
+// exit status of the last command and \$ a plain dollar sign. Variables
+// and $? work on the command line too.
+
+const (
+	maxVars        = 32
+	maxVarName     = 16
+	maxFile        = 4096
+	maxScriptDepth = 4
+
+	diskPrefix = "/disk/"
+
+	// StartupScript runs at boot when the disk has it
+	StartupScript = "/disk/AUTOEXEC.SH"
+)
+
+// How a block of script lines ended
+const (
+	blockEOF = iota
+	blockElse
+	blockFi
+	blockDone
+)
+
+type variable struct {
+	name     [maxVarName]byte
+	nameLen  int
+	value    [maxLine]byte
+	valueLen int
+}
+
+type script struct {
+	text [maxFile]byte
+	len  int
+}
+
+var (
+	vars [maxVars]variable
+
+	// exitStatus is $?, the status of the last command
+	exitStatus int
+
+	// rawLine holds a line while it is expanded into lineBuf
+	rawLine [maxLine]byte
+
+	// A script sourcing another runs it from the next slot
+	scripts     [maxScriptDepth]script
+	scriptDepth int
+	// scriptExit stops the running scripts, after exit or an error
+	scriptExit bool
+)
+
+// fail prints a command's error and sets its exit status to 1
+func fail(msg string) {
+	terminal.Print(msg)
+	exitStatus = 1
+}
+
+// runLine runs one line of input: blank lines and comments do nothing,
+// NAME=value sets a variable and anything else runs as a pipeline of
+// commands. text must not be in lineBuf.
+func runLine(text []byte) {
+	text = trimBytes(text)
+	if len(text) == 0 || text[0] == '#' {
+		return
+	}
+	if eq := assignment(text); eq > 0 {
+		src := text[eq+1:]
+		if len(src) >= 2 && src[0] == '"' && src[len(src)-1] == '"' {
+			src = src[1 : len(src)-1]
+		}
+		var value [maxLine]byte
+		n := expand(src, &value)
+		exitStatus = 0
+		if !setVar(text[:eq], value[:n]) {
+			fail("set: too many variables\n")
+		}
+		return
+	}
+	runPipeline(text)
+}
+
+// expand copies src to dst with $NAME, ${NAME} and $? replaced by their
+// values, cutting the result at the size of dst. An unset variable
+// expands to nothing.
+func expand(src []byte, dst *[maxLine]byte) int {
+	n := 0
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		if c == '\\' && i+1 < len(src) && src[i+1] == '$' {
+			n = appendBytes(dst, n, src[i+1:i+2])
+			i++
+			continue
+		}
+		if c != '$' || i+1 == len(src) {
+			n = appendBytes(dst, n, src[i:i+1])
+			continue
+		}
+		if src[i+1] == '?' {
+			buf, start := terminal.FormatInt(exitStatus)
+			n = appendBytes(dst, n, buf[start:])
+			i++
+			continue
+		}
+
+		nameStart, nameEnd, next := i+1, i+1, i+1
+		if src[i+1] == '{' {
+			nameStart, nameEnd = i+2, i+2
+			for nameEnd < len(src) && src[nameEnd] != '}' {
+				nameEnd++
+			}
+			next = nameEnd + 1
+		} else {
+			for nameEnd < len(src) && isNameByte(src[nameEnd], nameEnd == nameStart) {
+				nameEnd++
+			}
+			next = nameEnd
+		}
+		if nameEnd == nameStart || nameEnd == len(src) && next > nameEnd {
+			// Not a variable after all: a lone or unclosed $
+			n = appendBytes(dst, n, src[i:i+1])
+			continue
+		}
+		if v := findVar(src[nameStart:nameEnd]); v >= 0 {
+			n = appendBytes(dst, n, vars[v].value[:vars[v].valueLen])
+		}
+		i = next - 1
+	}
+	return n
+}
+
+func appendBytes(dst *[maxLine]byte, n int, b []byte) int {
+	for i := 0; i < len(b) && n < maxLine; i++ {
+		dst[n] = b[i]
+		n++
+	}
+	return n
+}
+
+// assignment returns the index of the = of a NAME=value line, or 0
+func assignment(text []byte) int {
+	for i := 0; i < len(text) && !isSpace(text[i]); i++ {
+		if text[i] == '=' {
+			if validName(text[:i]) {
+				return i
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || !first && c >= '0' && c <= '9'
+}
+
+func validName(name []byte) bool {
+	if len(name) == 0 || len(name) > maxVarName {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameByte(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func findVar(name []byte) int {
+	for i := 0; i < maxVars; i++ {
+		if vars[i].nameLen > 0 && equalBytes(vars[i].name[:vars[i].nameLen], name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// setVar sets a variable, reporting false when the name is bad or every
+// slot is taken
+func setVar(name, value []byte) bool {
+	if !validName(name) {
+		return false
+	}
+	v := findVar(name)
+	for i := 0; v < 0 && i < maxVars; i++ {
+		if vars[i].nameLen == 0 {
+			v = i
+		}
+	}
+	if v < 0 {
+		return false
+	}
+	vr := &vars[v]
+	vr.nameLen = len(name)
+	for i := 0; i < len(name); i++ {
+		vr.name[i] = name[i]
+	}
+	vr.valueLen = 0
+	for i := 0; i < len(value) && i < maxLine; i++ {
+		vr.value[i] = value[i]
+		vr.valueLen++
+	}
+	return true
+}
+
+func unsetVar(name []byte) bool {
+	v := findVar(name)
+	if v < 0 {
+		return false
+	}
+	vars[v].nameLen = 0
+	return true
+}
+
+func printVars() {
+	for i := 0; i < maxVars; i++ {
+		if vars[i].nameLen == 0 {
+			continue
+		}
+		terminal.Print(bytesString(vars[i].name[:vars[i].nameLen]))
+		terminal.PutRune('=')
+		terminal.Print(bytesString(vars[i].value[:vars[i].valueLen]))
+		terminal.PutRune('\n')
+	}
+}
+
+// testExpr evaluates the arguments of test
+func testExpr(a *command.Args) (result, ok bool) {
+	switch a.Len() {
+	case 1:
+		return true, true
+	case 2:
+		s, e := a.Span(2)
+		switch a.Arg(1) {
+		case "-n":
+			return s < e, true
+		case "-z":
+			return s == e, true
+		case "-f":
+			return fileExists(a.Arg(2)), true
+		}
+	case 3:
+		x, y := a.Arg(1), a.Arg(3)
+		switch a.Arg(2) {
+		case "=":
+			return x == y, true
+		case "!=":
+			return x != y, true
+		}
+		m, okM := parseInt(a.Span(1))
+		n, okN := parseInt(a.Span(3))
+		if !okM || !okN {
+			return false, false
+		}
+		switch a.Arg(2) {
+		case "-eq":
+			return m == n, true
+		case "-ne":
+			return m != n, true
+		case "-lt":
+			return m < n, true
+		case "-le":
+			return m <= n, true
+		case "-gt":
+			return m > n, true
+		case "-ge":
+			return m >= n, true
+		}
+	}
+	return false, false
+}
+
+// fileExists reports whether path names a RAM fs file or, under /disk/,
+// a FAT16 file
+func fileExists(path string) bool {
+	if len(path) > len(diskPrefix) && path[:len(diskPrefix)] == diskPrefix {
+		var fname [8]byte
+		var fext [3]byte
+		if !fatName(path[len(diskPrefix):], &fname, &fext) {
+			return false
+		}
+		_, ok := fat16.ReadFile(&fname, &fext, &diskBuf)
+		return ok
+	}
+	if !ramName(path) {
+		return false
+	}
+	_, _, ok := fs.Lookup(&tmpName, len(path))
+	return ok
+}
+
+// letExpr runs let NAME=A [op B]
+func letExpr(a *command.Args) bool {
+	if a.Len() == 2 {
+		return false
+	}
+	s, e := a.Span(1)
+	eq := assignment(lineBuf[s:e])
+	if eq == 0 {
+		return false
+	}
+	v, ok := parseInt(s+eq+1, e)
+	if !ok {
+		return false
+	}
+	if a.Len() == 3 {
+		b, ok := parseInt(a.Span(3))
+		if !ok {
+			return false
+		}
+		switch a.Arg(2) {
+		case "+":
+			v += b
+		case "-":
+			v -= b
+		case "*":
+			v *= b
+		case "/", "%":
+			if b == 0 {
+				return false
+			}
+			if a.Arg(2) == "/" {
+				v /= b
+			} else {
+				v %= b
+			}
+		default:
+			return false
+		}
+	}
+	buf, bufStart := terminal.FormatInt(v)
+	return setVar(lineBuf[s:s+eq], buf[bufStart:])
+}
+
+// parseInt parses a decimal number in lineBuf[start:end] with an optional
+// minus sign
+func parseInt(start, end int) (int, bool) {
+	neg := start < end && lineBuf[start] == '-'
+	if neg {
+		start++
+	}
+	v, ok := parseDec(start, end)
+	if neg {
+		v = -v
+	}
+	return v, ok
+}
+
+// RunStartupScript runs StartupScript when the disk holds one. The kernel
+// calls it once the disk is mounted and before the first prompt.
+func RunStartupScript() {
+	if loadScript(StartupScript, &scripts[0]) {
+		runLoaded(&scripts[0])
+	}
+}
+
+// runScript runs the script at path, leaving $? at the status of the
+// last command it ran
+func runScript(path string) {
+	if scriptDepth == maxScriptDepth {
+		fail("source: scripts nested too deep\n")
+		return
+	}
+	sc := &scripts[scriptDepth]
+	if !loadScript(path, sc) {
+		fail("source: cannot read ")
+		terminal.Print(path)
+		terminal.PutRune('\n')
+		return
+	}
+	runLoaded(sc)
+}
+
+func runLoaded(sc *script) {
+	scriptDepth++
+	exitStatus = 0
+	pos, end := runLines(sc, 0, true)
+	if end != blockEOF {
+		scriptError(sc, pos, "unexpected else, fi or done")
+	}
+	scriptDepth--
+	if scriptDepth == 0 {
+		scriptExit = false
+	}
+}
+
+// loadScript reads a script with readFile
+func loadScript(path string, sc *script) bool {
+	n, ok := readFile(path, &sc.text)
+	sc.len = n
+	return ok
+}
+
+// readFile reads a RAM fs file, or with a /disk/ path a FAT16 file, into
+// buf. Only the first sector of a FAT16 file is read, so one larger than
+// that fails rather than pass for the whole file.
+func readFile(path string, buf *[maxFile]byte) (int, bool) {
+	if len(path) > len(diskPrefix) && path[:len(diskPrefix)] == diskPrefix {
+		var fname [8]byte
+		var fext [3]byte
+		if !fatName(path[len(diskPrefix):], &fname, &fext) {
+			return 0, false
+		}
+		size, ok := fat16.ReadFile(&fname, &fext, &diskBuf)
+		if !ok {
+			return 0, false
+		}
+		if size > uint32(len(diskBuf)) {
+			fail("shell: ")
+			terminal.Print(path)
+			terminal.Print(" cut at 512 bytes\n")
+			return 0, false
+		}
+		for i := 0; i < int(size); i++ {
+			buf[i] = diskBuf[i]
+		}
+		return int(size), true
+	}
+
+	if !ramName(path) {
+		return 0, false
+	}
+	page, size, ok := fs.Lookup(&tmpName, len(path))
+	if !ok {
+		return 0, false
+	}
+	text := pageString(page, size)
+	for i := 0; i < len(text); i++ {
+		buf[i] = text[i]
+	}
+	return len(text), true
+}
+
+// ramName copies a RAM fs file name into tmpName
+func ramName(path string) bool {
+	if len(path) == 0 || len(path) > len(tmpName) {
+		return false
+	}
+	for i := 0; i < len(tmpName); i++ {
+		tmpName[i] = 0
+		if i < len(path) {
+			tmpName[i] = path[i]
+		}
+	}
+	return true
+}
+
+// fatName splits NAME.EXT into the blank padded upper case fields of a
+// FAT16 directory entry
+func fatName(name string, fname *[8]byte, fext *[3]byte) bool {
+	dot := len(name)
+	for i := 0; i < len(name); i++ {
+		if name[i] == '.' {
+			dot = i
+			break
+		}
+	}
+	ext := ""
+	if dot < len(name) {
+		ext = name[dot+1:]
+	}
+	if dot == 0 || dot > len(fname) || len(ext) > len(fext) {
+		return false
+	}
+	for i := 0; i < len(fname); i++ {
+		fname[i] = ' '
+		if i < dot {
+			fname[i] = upperByte(name[i])
+		}
+	}
+	for i := 0; i < len(fext); i++ {
+		fext[i] = ' '
+		if i < len(ext) {
+			fext[i] = upperByte(ext[i])
+		}
+	}
+	return true
+}
+
+func upperByte(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+// runLines runs the lines of sc from pos up to the else, fi or done that
+// ends the block, or to the end of the script, and returns the position
+// after that line and how the block ended. With run false the lines are
+// only walked to find the end of the block.
+func runLines(sc *script, pos int, run bool) (int, int) {
+	for pos < sc.len {
+		line, next := scriptLine(sc, pos)
+		pos = next
+		if scriptExit {
+			run = false
+		}
+		word, rest := splitWord(line)
+		switch {
+		case equalWord(word, "else"):
+			return pos, blockElse
+		case equalWord(word, "fi"):
+			return pos, blockFi
+		case equalWord(word, "done"):
+			return pos, blockDone
+		case equalWord(word, "then"), equalWord(word, "do"):
+		case equalWord(word, "if"):
+			pos = runIf(sc, pos, trimKeyword(rest, "then"), run)
+		case equalWord(word, "while"):
+			pos = runWhile(sc, pos, trimKeyword(rest, "do"), run)
+		case equalWord(word, "for"):
+			pos = runFor(sc, pos, trimKeyword(rest, "do"), run)
+		default:
+			if run {
+				runLine(line)
+			}
+		}
+	}
+	return pos, blockEOF
+}
+
+func runIf(sc *script, pos int, cond []byte, run bool) int {
+	if len(cond) == 0 {
+		scriptError(sc, pos, "if without a command")
+		run = false
+	}
+	taken := false
+	if run {
+		runLine(cond)
+		taken = exitStatus == 0
+	}
+	pos, end := runLines(sc, pos, run && taken)
+	if end == blockElse {
+		pos, end = runLines(sc, pos, run && !taken)
+	} else if run && !taken {
+		exitStatus = 0
+	}
+	if end != blockFi {
+		scriptError(sc, pos, "missing fi")
+	}
+	return pos
+}
+
+func runWhile(sc *script, pos int, cond []byte, run bool) int {
+	if len(cond) == 0 {
+		scriptError(sc, pos, "while without a command")
+		run = false
+	}
+	body := pos
+	for run && !scriptExit {
+		runLine(cond)
+		if exitStatus != 0 {
+			exitStatus = 0
+			break
+		}
+		if next, end := runLines(sc, body, true); end != blockDone {
+			scriptError(sc, next, "missing done")
+			return next
+		}
+	}
+	next, end := runLines(sc, body, false)
+	if end != blockDone {
+		scriptError(sc, next, "missing done")
+	}
+	return next
+}
+
+func runFor(sc *script, pos int, header []byte, run bool) int {
+	name, rest := splitWord(header)
+	in, words := splitWord(rest)
+	if !validName(name) || !equalWord(in, "in") {
+		scriptError(sc, pos, "for needs NAME in <words>")
+		run = false
+	}
+	body := pos
+	if run {
+		// The words are expanded once, before the block first runs
+		var list [maxLine]byte
+		n := expand(words, &list)
+		for i := 0; i < n && !scriptExit; {
+			for i < n && isSpace(list[i]) {
+				i++
+			}
+			start := i
+			for i < n && !isSpace(list[i]) {
+				i++
+			}
+			if start == i {
+				break
+			}
+			setVar(name, list[start:i])
+			if next, end := runLines(sc, body, true); end != blockDone {
+				scriptError(sc, next, "missing done")
+				return next
+			}
+		}
+	}
+	next, end := runLines(sc, body, false)
+	if end != blockDone {
+		scriptError(sc, next, "missing done")
+	}
+	return next
+}
+
+// scriptError reports a malformed script at the line before pos and stops
+// it with status 2
+func scriptError(sc *script, pos int, msg string) {
+	if scriptExit {
+		return
+	}
+	line := 0
+	for i := 0; i < pos && i < sc.len; i++ {
+		if sc.text[i] == '\n' {
+			line++
+		}
+	}
+	if pos >= sc.len && (sc.len == 0 || sc.text[sc.len-1] != '\n') {
+		line++
+	}
+	terminal.Print("source: line ")
+	printUint(uint64(line))
+	terminal.Print(": ")
+	terminal.Print(msg)
+	terminal.PutRune('\n')
+	exitStatus = 2
+	scriptExit = true
+}
+
+// scriptLine returns the trimmed line starting at pos and where the next
+// one starts
+func scriptLine(sc *script, pos int) ([]byte, int) {
+	end := pos
+	for end < sc.len && sc.text[end] != '\n' {
+		end++
+	}
+	next := end + 1
+	for end > pos && sc.text[end-1] == '\r' {
+		end--
+	}
+	return trimBytes(sc.text[pos:end]), next
+}
+
+// splitWord returns the first word of text and the rest, trimmed
+func splitWord(text []byte) ([]byte, []byte) {
+	text = trimBytes(text)
+	i := 0
+	for i < len(text) && !isSpace(text[i]) {
+		i++
+	}
+	return text[:i], trimBytes(text[i:])
+}
+
+// trimKeyword drops a trailing "; then" or "; do"
+func trimKeyword(text []byte, kw string) []byte {
+	text = trimBytes(text)
+	if len(text) < len(kw) || !equalWord(text[len(text)-len(kw):], kw) {
+		return text
+	}
+	rest := trimBytes(text[:len(text)-len(kw)])
+	if len(rest) == 0 || rest[len(rest)-1] != ';' {
+		return text
+	}
+	return trimBytes(rest[:len(rest)-1])
+}
+
+func trimBytes(b []byte) []byte {
+	start, end := 0, len(b)
+	for start < end && isSpace(b[start]) {
+		start++
+	}
+	for end > start && isSpace(b[end-1]) {
+		end--
+	}
+	return b[start:end]
+}
+
+func equalWord(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if b[i] != s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalBytes(a, b []byte) bool {
+	return equalWord(a, bytesString(b))
+}
@@ -0,0 +1,166 @@
+package shell
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dmarro89/go-dav-os/fs"
+	"github.com/dmarro89/go-dav-os/fs/fat16"
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+func writeScript(t *testing.T, name, text string) {
+	t.Helper()
+	var n [16]byte
+	copy(n[:], name)
+	data := []byte(text)
+	if !fs.Write(&n, len(name), &data[0], uint32(len(data))) {
+		t.Fatalf("failed to write %s", name)
+	}
+}
+
+func resetScripting() {
+	for i := range vars {
+		vars[i].nameLen = 0
+	}
+	exitStatus = 0
+	scriptDepth = 0
+	scriptExit = false
+}
+
+// runScriptText runs text as the script s.sh, next to the other scripts
+// given as name and text pairs, and returns its output
+func runScriptText(t *testing.T, text string, others ...[2]string) string {
+	t.Helper()
+	fs.Init()
+	fs.SetupMockPFA()
+	terminal.Init()
+	resetScripting()
+	writeScript(t, "s.sh", text)
+	for _, o := range others {
+		writeScript(t, o[0], o[1])
+	}
+	terminal.ResetOutputForTesting()
+	setLineBuf("source s.sh")
+	execute()
+	return terminal.OutputForTesting()
+}
+
+func TestVariablesExpand(t *testing.T) {
+	got := runScriptText(t, "# greeting\nNAME=world\nGREETING=\"hi $NAME\"\necho $GREETING ${NAME}s \\$NAME $UNSET.\n")
+	if want := "hi world worlds $NAME .\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	got := runScriptText(t, "false\necho $?\ntrue\necho $?\nnosuch\necho $?\ntest 1 -lt\necho $?\n")
+	if want := "1\n0\nUnknown command: nosuch\n127\n"; got[:len(want)] != want || got[len(got)-3:] != "\n2\n" {
+		t.Fatalf("output = %q", got)
+	}
+}
+
+func TestIfElse(t *testing.T) {
+	got := runScriptText(t, "X=b\nif test $X = a; then\necho a\nelse\necho not a\nfi\nif test -n $X\nthen\necho set\nfi\nif false\necho no\nfi\necho $?\n")
+	if want := "not a\nset\n0\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestWhileAndLet(t *testing.T) {
+	got := runScriptText(t, "i=0\nwhile test $i -lt 3; do\necho $i\nlet i=$i + 1\ndone\nlet j=7 % 4\necho $j\n")
+	if want := "0\n1\n2\n3\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestForAndNestedBlocks(t *testing.T) {
+	got := runScriptText(t, "WORDS=\"a b\"\nfor w in x $WORDS\ndo\nif test $w != a\nthen\necho $w\nfi\ndone\n")
+	if want := "x\nb\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestExitStopsScripts(t *testing.T) {
+	got := runScriptText(t, "source inner.sh\necho outer\n",
+		[2]string{"inner.sh", "echo inner\nexit 3\necho after exit\n"})
+	if got != "inner\n" || exitStatus != 3 || scriptExit {
+		t.Fatalf("output = %q, status %d, still exiting %v", got, exitStatus, scriptExit)
+	}
+
+	terminal.ResetOutputForTesting()
+	setLineBuf("exit")
+	execute()
+	if got := terminal.OutputForTesting(); got != "exit: not in a script\n" || exitStatus != 1 {
+		t.Fatalf("interactive exit: %q, status %d", got, exitStatus)
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	got := runScriptText(t, "echo one\nif true\necho two\n")
+	if got != "one\ntwo\nsource: line 3: missing fi\n" || exitStatus != 2 {
+		t.Fatalf("output = %q, status %d", got, exitStatus)
+	}
+
+	got = runScriptText(t, "done\necho never\n")
+	if got != "source: line 1: unexpected else, fi or done\n" {
+		t.Fatalf("output = %q", got)
+	}
+
+	terminal.ResetOutputForTesting()
+	setLineBuf("source missing.sh")
+	execute()
+	if got := terminal.OutputForTesting(); got != "source: cannot read missing.sh\n" || exitStatus != 1 {
+		t.Fatalf("output = %q, status %d", got, exitStatus)
+	}
+}
+
+func TestInteractiveVariables(t *testing.T) {
+	terminal.Init()
+	resetScripting()
+	terminal.ResetOutputForTesting()
+	for _, line := range []string{"A=1", "B=two", "unset A", "set", "echo $B$?"} {
+		setLineBuf(line)
+		execute()
+	}
+	if got := terminal.OutputForTesting(); got != "B=two\ntwo0\n" {
+		t.Fatalf("output = %q", got)
+	}
+}
+
+func TestStartupScriptReadsDisk(t *testing.T) {
+	terminal.Init()
+	resetScripting()
+	fat16.FilesForTesting = map[string]string{"AUTOEXEC.SH": "echo booted\r\nX=1\r\n"}
+	t.Cleanup(func() { fat16.FilesForTesting = nil })
+
+	terminal.ResetOutputForTesting()
+	RunStartupScript()
+	if got := terminal.OutputForTesting(); got != "booted\n" {
+		t.Fatalf("output = %q", got)
+	}
+	if v := findVar([]byte("X")); v < 0 || string(vars[v].value[:vars[v].valueLen]) != "1" {
+		t.Fatalf("X was not set")
+	}
+}
+
+func TestOversizedDiskScriptDoesNotRun(t *testing.T) {
+	terminal.Init()
+	resetScripting()
+	big := "echo ran\n" + strings.Repeat("#", 600) + "\necho end\n"
+	fat16.FilesForTesting = map[string]string{"BIG.SH": big, "AUTOEXEC.SH": big}
+	t.Cleanup(func() { fat16.FilesForTesting = nil })
+
+	got := runTyped("source /disk/big.sh")
+	if got != "shell: /disk/big.sh cut at 512 bytes\nsource: cannot read /disk/big.sh\n" || exitStatus != 1 {
+		t.Fatalf("source output = %q, status %d", got, exitStatus)
+	}
+	terminal.ResetOutputForTesting()
+	RunStartupScript()
+	if got := terminal.OutputForTesting(); got != "shell: "+StartupScript+" cut at 512 bytes\n" {
+		t.Fatalf("startup output = %q", got)
+	}
+	if got := runTyped("head /disk/big.sh"); got != "shell: /disk/big.sh cut at 512 bytes\nhead: cannot read /disk/big.sh\n" || exitStatus != 1 {
+		t.Fatalf("head output = %q, status %d", got, exitStatus)
+	}
+}
@@ -0,0 +1,111 @@
+package shell
+
+import (
+	"github.com/dmarro89/go-dav-os/keyboard"
+	"github.com/dmarro89/go-dav-os/terminal"
+)
+
+// Every virtual console runs a shell session of its own: the line being
+// typed and the command history. The session on screen lives in the
+// package variables the editor works on; switching consoles saves them in
+// the slot of the console left and loads those of the one shown. The
+// copies go byte by byte, as the freestanding build has no memcpy for
+// large assignments.
+
+type session struct {
+	line       [maxLine]byte
+	lineLen    int
+	lineCursor int
+
+	history      [maxHistory][maxLine]byte
+	historyLen   [maxHistory]int
+	historyHead  int
+	historyCount int
+	historyPos   int
+
+	draft    [maxLine]byte
+	draftLen int
+}
+
+var (
+	sessions      [terminal.Consoles]session
+	activeSession int
+	// started marks the sessions that have printed their first prompt
+	started [terminal.Consoles]bool
+
+	// running is set while a command line runs. A console switched to then
+	// only changes the screen: the session follows once the line is done,
+	// so the rest of its output stays on its own console.
+	running bool
+)
+
+// ConsoleKey handles the keys that work the console rather than the line:
+// Alt+F1 onwards switch virtual consoles and Shift+PgUp/PgDn page through
+// the scrollback by half a screen. The kernel also hands them here while
+// a program holds the keyboard.
+func ConsoleKey(ev keyboard.Event) bool {
+	_, lines := terminal.Size()
+	switch {
+	case ev.Mods&keyboard.ModAlt != 0 && ev.Key >= keyboard.KeyF1 && ev.Key < keyboard.KeyF1+terminal.Consoles:
+		if running {
+			terminal.SwitchConsole(int(ev.Key - keyboard.KeyF1))
+		} else {
+			SwitchConsole(int(ev.Key - keyboard.KeyF1))
+		}
+	case ev.Mods&keyboard.ModShift != 0 && ev.Key == keyboard.KeyPageUp:
+		terminal.ScrollView(lines / 2)
+	case ev.Mods&keyboard.ModShift != 0 && ev.Key == keyboard.KeyPageDown:
+		terminal.ScrollView(-lines / 2)
+	default:
+		return false
+	}
+	return true
+}
+
+// SwitchConsole shows virtual console i with its shell session, which the
+// shell's output follows. A console shown for the first time greets and
+// prompts like the first one.
+func SwitchConsole(i int) {
+	if i < 0 || i >= len(sessions) || i == activeSession {
+		return
+	}
+	saveSession(&sessions[activeSession])
+	terminal.SwitchConsole(i)
+	terminal.OutputTo(i)
+	loadSession(&sessions[i])
+	activeSession = i
+	if !started[i] {
+		Init()
+	}
+}
+
+func saveSession(s *session) {
+	copyLine(&s.line, &lineBuf)
+	s.lineLen, s.lineCursor = lineLen, lineCursor
+	for i := 0; i < maxHistory; i++ {
+		copyLine(&s.history[i], &historyBuf[i])
+		s.historyLen[i] = historyLen[i]
+	}
+	s.historyHead, s.historyCount, s.historyPos = historyHead, historyCount, historyPos
+	copyLine(&s.draft, &draftBuf)
+	s.draftLen = draftLen
+}
+
+func loadSession(s *session) {
+	copyLine(&lineBuf, &s.line)
+	lineLen, lineCursor = s.lineLen, s.lineCursor
+	for i := 0; i < maxHistory; i++ {
+		copyLine(&historyBuf[i], &s.history[i])
+		historyLen[i] = s.historyLen[i]
+	}
+	historyHead, historyCount, historyPos = s.historyHead, s.historyCount, s.historyPos
+	copyLine(&draftBuf, &s.draft)
+	draftLen = s.draftLen
+	escState = 0
+}
+
+func copyLine(dst, src *[maxLine]byte) {
+	for i := 0; i < maxLine; i++ {
+		dst[i] = src[i]
+	}
+}
@@ -5,9 +5,18 @@ import (
 
 	"github.com/dmarro89/go-dav-os/agent"
 	"github.com/dmarro89/go-dav-os/drivers/ata"
+	"github.com/dmarro89/go-dav-os/drivers/block"
+	"github.com/dmarro89/go-dav-os/drivers/netdev"
+	"github.com/dmarro89/go-dav-os/drivers/pci"
+	"github.com/dmarro89/go-dav-os/drivers/rtc"
 	"github.com/dmarro89/go-dav-os/fs"
 	"github.com/dmarro89/go-dav-os/fs/fat16"
+	"github.com/dmarro89/go-dav-os/kernel/irq"
+	"github.com/dmarro89/go-dav-os/kernel/percpu"
+	"github.com/dmarro89/go-dav-os/keyboard/layout"
 	"github.com/dmarro89/go-dav-os/mem"
+	"github.com/dmarro89/go-dav-os/net"
+	"github.com/dmarro89/go-dav-os/shell/command"
 	"github.com/dmarro89/go-dav-os/terminal"
 )
 
@@ -17,8 +26,12 @@ const (
 	osName               = "DavOS"
 	maxDistanceThreshold = 3
 	osVersion            = "0.0.5"
-	commandHelp          = "help"
-	commandHistory       = "history"
+	benchMaxSectors      = 256
+	benchDefaultSectors  = 128
+	pingDefaultCount     = 4
+	pingDataSize         = 56
+	pingTimeoutNs        = 1000000000
+	pingIntervalMs       = 1000
 )
 
 var (
@@ -26,12 +39,15 @@ var (
 	lineLen         int
 	getTicks        func() uint64
 	getSyscallTicks func() uint64
-	runProgram      func(name *[16]byte, nameLen int) (pid int, ok bool)
-	switchLayoutFn  func(string) bool
+	sleepFn         func(ms uint64)
+	clockFn         func() (rtc.Time, bool)
+	nanotimeFn      func() uint64
+	switchLayoutFn  func(string) (string, bool)
 	currentLayout   = "it"
 	tmpName         [16]byte
 	tmpData         [4096]byte
 	diskBuf         [512]byte
+	benchBuf        [benchMaxSectors * 512]byte
 
 	// History ring buffer
 	// historyBuf stores the content of the commands
@@ -44,26 +60,33 @@ var (
 	historyCount int
 
 	runtimeAgent agent.Runtime
+	// agentPlanner reaches the host LLM bridge; agentLLM is set by
+	// "agent mode llm" and enables "agent ask"
+	agentPlanner agent.LLMPlanner
+	agentLLM     bool
+	agentContext agent.Context
 )
 
 // maxHistory defines the maximum size of the history ring buffer
 const maxHistory = 32
 
-var commandBuf = [...]string{
-	commandHelp, commandHistory, "clear", "echo", "ticks", "uptime",
-	"mem", "mmap", "mmapmax", "pfa", "alloc", "free",
-	"ls", "write", "cat", "rm", "stat",
-	"disk", "fatinit", "fatformat", "fatinfo", "fatls", "fatcreate", "fatread",
-	"layout", "version", "run", "agent",
+func SetTickProvider(fn func() uint64)                 { getTicks = fn }
+func SetSyscallTickProvider(fn func() uint64)          { getSyscallTicks = fn }
+func SetSleeper(fn func(ms uint64))                    { sleepFn = fn }
+func SetClock(fn func() (rtc.Time, bool))              { clockFn = fn }
+func SetNanotime(fn func() uint64)                     { nanotimeFn = fn }
+func SetLayoutSwitcher(fn func(string) (string, bool)) { switchLayoutFn = fn }
+func SetInitialLayout(name string)                     { currentLayout = name }
+
+// SetAgentBridge wires the host LLM bridge behind "agent mode llm"; nil
+// removes it and falls back to deterministic mode
+func SetAgentBridge(bridge agent.BridgeClient) {
+	agentPlanner.Bridge = bridge
+	if bridge == nil {
+		agentLLM = false
+	}
 }
 
-func SetTickProvider(fn func() uint64)        { getTicks = fn }
-func SetSyscallTickProvider(fn func() uint64) { getSyscallTicks = fn }
-func SetProgramRunner(fn func(name *[16]byte, nameLen int) (pid int, ok bool)) {
-	runProgram = fn
-}
-func SetLayoutSwitcher(fn func(string) bool) { switchLayoutFn = fn }
-func SetInitialLayout(name string)           { currentLayout = name }
 func SetAgentRuntime(runtime *agent.Runtime) {
 	if runtime == nil {
 		runtimeAgent.Executor.ListFiles = nil
@@ -126,7 +149,11 @@ func NewAgentExecutor() agent.AllowedActionExecutor {
 }
 
 func Init() {
+	started[activeSession] = true
 	lineLen = 0
+	lineCursor = 0
+	historyPos = 0
+	escState = 0
 	terminal.Print("Welcome to " + osName + " " + osVersion + "\n")
 	terminal.Print(prompt)
 }
@@ -135,37 +162,54 @@ func FeedRune(r rune) {
 	if r == '\r' {
 		r = '\n'
 	}
+	if escapeSequence(r) || editControl(r) {
+		return
+	}
 
 	switch r {
 	case '\b':
-		if lineLen == 0 {
-			return
-		}
-		lineLen--
-		terminal.Backspace()
+		deleteBeforeCursor()
+		return
+
+	case ctrlC:
+		cancelLine()
 		return
 
 	case '\n':
+		moveCursor(lineLen)
 		terminal.PutRune('\n')
+		running = true
 		execute()
+		running = false
 		lineLen = 0
+		lineCursor = 0
+		historyPos = 0
+		reportDoneJobs()
 		terminal.Print(prompt)
+		SwitchConsole(terminal.ActiveConsole())
 		return
 	}
 
-	if r < 32 || r > 126 {
-		return
-	}
-	if lineLen >= maxLine {
+	// Control characters, C1 controls included, are not typed
+	if r < 32 || r == 127 || (r >= 0x80 && r < 0xA0) || r > 0x10FFFF {
 		return
 	}
-
-	lineBuf[lineLen] = byte(r)
-	lineLen++
-	terminal.PutRune(r)
+	insertRune(r)
 }
 
-var targetLayout string
+// Paste types UTF-8 text into the command line as if it were keyed in.
+// Line breaks become spaces so a pasted selection never runs on its own.
+func Paste(text []byte) {
+	s := bytesString(text)
+	for i := 0; i < len(s); {
+		r, n := terminal.DecodeRune(s[i:])
+		if r == '\n' {
+			r = ' '
+		}
+		FeedRune(r)
+		i += n
+	}
+}
 
 func execute() {
 	start := trimLeft(0, lineLen)
@@ -213,668 +257,65 @@ func execute() {
 		}
 	}
 
-	cmdStart, cmdEnd := firstToken(start, end)
-
-	if matchLiteral(cmdStart, cmdEnd, commandHistory) {
-		printHistory()
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, commandHelp) {
-		terminal.Print("Commands: help, history, clear, echo, ticks, uptime, mem, mmap, mmapmax, pfa, alloc, free, ls, write, cat, rm, stat, disk, fatinit, fatformat, fatinfo, fatls, fatcreate, fatread, layout, version, run, agent\n")
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "clear") {
-		terminal.Clear()
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "echo") {
-		msgStart := trimLeft(cmdEnd, end)
-		if msgStart < end {
-			printRange(msgStart, end)
-		}
-		terminal.PutRune('\n')
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "ticks") {
-		if !printTicks() {
-			terminal.Print("ticks: not wired yet\n")
-		}
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "uptime") {
-		if getSyscallTicks == nil {
-			terminal.Print("uptime: not wired yet\n")
-			return
-		}
-		t := getSyscallTicks()
-		secs := t / 100
-		mins := secs / 60
-		secs = secs % 60
-		terminal.Print("up ")
-		printUint(mins)
-		terminal.Print("m ")
-		printUint(secs)
-		terminal.Print("s (")
-		printUint(t)
-		terminal.Print(" ticks via SYS_GETTICKS)\n")
-		return
-	}
-
-	// VGA mem 0xB8000 160
-	// kernel mem 0x00100000 256, mem 0x00101000 256 ...
-	// .rodata & .data mem 0x00104000 256, mem 0x00108000 256, mem 0x0010C000 256
-	if matchLiteral(cmdStart, cmdEnd, "mem") {
-		a1s, a1e, ok := nextArg(cmdEnd, end)
-		if !ok {
-			terminal.Print("Usage: mem <hex_addr> [len]\n")
-			return
-		}
-
-		addr, ok := parseHex64(a1s, a1e)
-		if !ok {
-			terminal.Print("mem: invalid hex address\n")
-			return
-		}
-
-		length := 64
-		a2s, a2e, ok := nextArg(a1e, end)
-		if ok {
-			v, ok2 := parseDec(a2s, a2e)
-			if !ok2 {
-				terminal.Print("mem: invalid length\n")
-				return
-			}
-			length = v
-		}
-
-		if length < 1 {
-			length = 1
-		}
-		if length > 512 {
-			length = 512
-		}
-
-		dumpMemory(addr, length)
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "mmap") {
-		printMemoryMap()
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "mmapmax") {
-		var maxEnd uint64
-		n := mem.MMapCount()
-		for i := 0; i < n; i++ {
-			bLo, bHi, lLo, lHi, typ := mem.MMapEntry(i)
-			if typ != 1 {
-				continue
-			}
-			base := (uint64(bHi) << 32) | uint64(bLo)
-			l := (uint64(lHi) << 32) | uint64(lLo)
-			end := base + l
-			if end > maxEnd {
-				maxEnd = end
-			}
-		}
-
-		terminal.Print("mmap max end=0x")
-		printHexU64(maxEnd)
-		terminal.PutRune('\n')
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "pfa") {
-		if !mem.PFAReady() {
-			terminal.Print("pfa: not ready\n")
-			return
-		}
-
-		terminal.Print("pages total=")
-		printUint(mem.TotalPages())
-		terminal.Print(" used=")
-		printUint(mem.UsedPages())
-		terminal.Print(" free=")
-		printUint(mem.FreePages())
-		terminal.PutRune('\n')
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "alloc") {
-		// allocate one 4KB page and print its physical address
-		if !mem.PFAReady() {
-			terminal.Print("alloc: pfa not ready\n")
-			return
-		}
-
-		addr := mem.AllocPage()
-		if addr == 0 {
-			terminal.Print("alloc: failed\n")
-			return
-		}
-
-		terminal.Print("0x")
-		printHexU64(addr)
-		terminal.PutRune('\n')
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "free") {
-		// free a previously allocated 4KB page
-		if !mem.PFAReady() {
-			terminal.Print("free: pfa not ready\n")
-			return
-		}
-
-		a1s, a1e, ok := nextArg(cmdEnd, end)
-		if !ok {
-			terminal.Print("Usage: free <hex_addr>\n")
-			return
-		}
-
-		addr, ok := parseHex64(a1s, a1e)
-		if !ok {
-			terminal.Print("free: invalid hex address\n")
-			return
-		}
-
-		if mem.FreePage(addr) {
-			terminal.Print("ok\n")
-		} else {
-			terminal.Print("free: failed\n")
-		}
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "ls") {
-		for i := 0; i < fs.MaxFiles(); i++ {
-			used, name, nameLen, size, page := fs.Entry(i)
-			if !used {
-				continue
-			}
-
-			printName(name, nameLen)
-			terminal.Print("  size=")
-			printUint(size)
-			terminal.Print("  page=0x")
-			printHexU64(page)
-			terminal.PutRune('\n')
-		}
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "write") {
-		a1s, a1e, ok := nextArg(cmdEnd, end)
-		if !ok {
-			terminal.Print("Usage: write <name> <text...>\n")
-			return
-		}
-
-		nameLen, ok := copyNameFromRange(a1s, a1e)
-		if !ok {
-			terminal.Print("write: invalid name\n")
-			return
-		}
-
-		msgStart := trimLeft(a1e, end)
-		dataLen := copyDataFromRange(msgStart, end)
-
-		if !fs.Write(&tmpName, nameLen, (*byte)(unsafe.Pointer(&tmpData[0])), dataLen) {
-			terminal.Print("write: failed\n")
-			return
-		}
-
-		terminal.Print("ok\n")
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "cat") {
-		a1s, a1e, ok := nextArg(cmdEnd, end)
-		if !ok {
-			terminal.Print("Usage: cat <name>\n")
-			return
-		}
-
-		nameLen, ok := copyNameFromRange(a1s, a1e)
-		if !ok {
-			terminal.Print("cat: invalid name\n")
-			return
-		}
-
-		page, size, ok := fs.Lookup(&tmpName, nameLen)
-		if !ok {
-			terminal.Print("cat: not found\n")
-			return
-		}
-
-		p := uintptr(page)
-		for i := uint64(0); i < size; i++ {
-			b := *(*byte)(unsafe.Pointer(p + uintptr(i)))
-			terminal.PutRune(rune(b))
-		}
-		terminal.PutRune('\n')
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "rm") {
-		a1s, a1e, ok := nextArg(cmdEnd, end)
-		if !ok {
-			terminal.Print("Usage: rm <name>\n")
-			return
-		}
-
-		nameLen, ok := copyNameFromRange(a1s, a1e)
-		if !ok {
-			terminal.Print("rm: invalid name\n")
-			return
-		}
-
-		if fs.Remove(&tmpName, nameLen) {
-			terminal.Print("ok\n")
-		} else {
-			terminal.Print("rm: not found\n")
-		}
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "stat") {
-		a1s, a1e, ok := nextArg(cmdEnd, end)
-		if !ok {
-			terminal.Print("Usage: stat <name>\n")
-			return
-		}
-
-		nameLen, ok := copyNameFromRange(a1s, a1e)
-		if !ok {
-			terminal.Print("stat: invalid name\n")
-			return
-		}
-
-		page, size, ok := fs.Lookup(&tmpName, nameLen)
-		if !ok {
-			terminal.Print("stat: not found\n")
-			return
-		}
-
-		terminal.Print("page=0x")
-		printHexU64(page)
-		terminal.Print(" size=")
-		printUint(size)
-		terminal.PutRune('\n')
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "disk") {
-		a1s, a1e, ok := nextArg(cmdEnd, end)
-		if !ok {
-			terminal.Print("Usage: disk <read|write> <lba> [text]\n")
-			return
-		}
-
-		if matchLiteral(a1s, a1e, "read") {
-			a2s, a2e, ok := nextArg(a1e, end)
-			if !ok {
-				terminal.Print("disk read: missing lba\n")
-				return
-			}
-
-			lba := 0
-			// Try hex then dec
-			vHex, okHex := parseHex64(a2s, a2e)
-			if okHex {
-				lba = int(vHex)
-			} else {
-				vDec, okDec := parseDec(a2s, a2e)
-				if !okDec {
-					terminal.Print("disk read: invalid lba\n")
-					return
-				}
-				lba = vDec
-			}
-
-			if ata.ReadSector(uint32(lba), &diskBuf) {
-				terminal.Print("Read Sector ")
-				printUint(uint64(lba))
-				terminal.Print(" OK\n")
-				dumpMemory(uint64(uintptr(unsafe.Pointer(&diskBuf[0]))), 512)
-			} else {
-				terminal.Print("Read Failed\n")
-			}
-			return
-		}
-
-		if matchLiteral(a1s, a1e, "write") {
-			a2s, a2e, ok := nextArg(a1e, end)
-			if !ok {
-				terminal.Print("disk write: missing lba\n")
-				return
-			}
-			lba, ok := parseDec(a2s, a2e)
-			if !ok {
-				// try hex if needed, but dec is fine
-				vHex, okHex := parseHex64(a2s, a2e)
-				if okHex {
-					lba = int(vHex)
-				} else {
-					terminal.Print("disk write: invalid lba\n")
-					return
-				}
-			}
-
-			msgStart := trimLeft(a2e, end)
-			// Clear diskBuf before writing to avoid stale data
-			for i := 0; i < 512; i++ {
-				diskBuf[i] = 0
-			}
-			idx := 0
-			for i := msgStart; i < end && idx < 512; i++ {
-				diskBuf[idx] = lineBuf[i]
-				idx++
-			}
-
-			if ata.WriteSector(uint32(lba), &diskBuf) {
-				terminal.Print("Write Sector ")
-				printUint(uint64(lba))
-				terminal.Print(" OK\n")
-			} else {
-				terminal.Print("Write Failed\n")
-			}
-			return
-		}
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "fatinit") {
-		if fat16.Init() {
-			terminal.Print("FAT16 Initialized\n")
-		} else {
-			terminal.Print("FAT16 Init Failed\n")
-		}
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "fatformat") {
-		if fat16.Format() {
-			terminal.Print("FAT16 Formatted\n")
-		} else {
-			terminal.Print("FAT16 Format Failed\n")
-		}
-		return
+	// The line is expanded back into lineBuf, so it runs from a copy
+	n := 0
+	for i := start; i < end; i++ {
+		rawLine[n] = lineBuf[i]
+		n++
 	}
+	runLine(rawLine[:n])
+}
 
-	if matchLiteral(cmdStart, cmdEnd, "fatinfo") {
-		fat16.Info()
+// runCommand runs the command in lineBuf, setting exitStatus: 0 when it
+// succeeds, 1 when it fails, 2 when its arguments are wrong and 127 when
+// there is no such command
+func runCommand() {
+	exitStatus = 0
+	start := trimLeft(0, lineLen)
+	end := trimRight(start, lineLen)
+	if start >= end {
 		return
 	}
+	cmdStart, cmdEnd := firstToken(start, end)
 
-	if matchLiteral(cmdStart, cmdEnd, "fatls") {
-		fat16.ListDir()
+	if matchLiteral(cmdStart, cmdEnd, "if") || matchLiteral(cmdStart, cmdEnd, "while") || matchLiteral(cmdStart, cmdEnd, "for") {
+		printRange(cmdStart, cmdEnd)
+		fail(": only allowed in scripts\n")
 		return
 	}
 
-	if matchLiteral(cmdStart, cmdEnd, "fatcreate") {
-		// Usage: fatcreate <filename> <content>
-		a1s, a1e, ok := nextArg(cmdEnd, end)
-		if !ok {
-			terminal.Print("Usage: fatcreate <filename> <content>\n")
-			return
-		}
-
-		// Parse filename (max 8 chars, no extension for simplicity)
-		var fname [8]byte
-		var fext [3]byte
-		for i := 0; i < 8; i++ {
-			fname[i] = ' '
-		}
-		for i := 0; i < 3; i++ {
-			fext[i] = ' '
-		}
-
-		nameLen := a1e - a1s
-		if nameLen > 8 {
-			nameLen = 8
-		}
-		for i := 0; i < nameLen; i++ {
-			c := lineBuf[a1s+i]
-			if c >= 'a' && c <= 'z' {
-				c = c - 'a' + 'A' // Uppercase
-			}
-			fname[i] = c
-		}
-
-		// Get content
-		msgStart := trimLeft(a1e, end)
-		var dataBuf [512]byte
-		// Clear dataBuf before writing to avoid stale data
-		for i := 0; i < 512; i++ {
-			dataBuf[i] = 0
-		}
-		idx := 0
-		for i := msgStart; i < end && idx < 512; i++ {
-			dataBuf[idx] = lineBuf[i]
-			idx++
-		}
-
-		if fat16.CreateFile(&fname, &fext, &dataBuf, uint32(idx)) {
-			terminal.Print("File created\n")
-		} else {
-			terminal.Print("Failed to create file\n")
-		}
-		return
+	// A command run by a script runs commands of its own, so each line
+	// gets its own Args
+	RegisterCommands()
+	var args command.Args
+	args.Split(lineString(0, end))
+	if piped {
+		args.SetInput(pipeInput)
+		piped = false
 	}
-
-	if matchLiteral(cmdStart, cmdEnd, "fatread") {
-		// Usage: fatread <filename>
-		a1s, a1e, ok := nextArg(cmdEnd, end)
-		if !ok {
-			terminal.Print("Usage: fatread <filename>\n")
-			return
-		}
-
-		var fname [8]byte
-		var fext [3]byte
-		for i := 0; i < 8; i++ {
-			fname[i] = ' '
+	if command.Execute(&args) {
+		if args.Status != 0 {
+			exitStatus = args.Status
 		}
-		for i := 0; i < 3; i++ {
-			fext[i] = ' '
-		}
-
-		nameLen := a1e - a1s
-		if nameLen > 8 {
-			nameLen = 8
-		}
-		for i := 0; i < nameLen; i++ {
-			c := lineBuf[a1s+i]
-			if c >= 'a' && c <= 'z' {
-				c = c - 'a' + 'A'
-			}
-			fname[i] = c
-		}
-
-		size, ok := fat16.ReadFile(&fname, &fext, &diskBuf)
-		if !ok {
-			terminal.Print("File not found\n")
-			return
-		}
-
-		for i := uint32(0); i < size && i < 512; i++ {
-			terminal.PutRune(rune(diskBuf[i]))
-		}
-		terminal.PutRune('\n')
 		return
 	}
 
-	if matchLiteral(cmdStart, cmdEnd, "layout") {
-		a1s, a1e, ok := nextArg(cmdEnd, end)
-		if !ok {
-			terminal.Print("current layout: ")
-			terminal.Print(currentLayout)
-			terminal.PutRune('\n')
-			return
+	exitStatus = 127
+	found := false
+	for i := 0; i < command.Count(); i++ {
+		name := command.At(i).Name
+		if calculateDistance(cmdStart, cmdEnd, name) >= maxDistanceThreshold {
+			continue
 		}
-
-		if matchLiteral(a1s, a1e, "us") {
-			targetLayout = "us"
-		} else if matchLiteral(a1s, a1e, "it") {
-			targetLayout = "it"
+		if !found {
+			terminal.Print("Did you mean '")
+			found = true
 		} else {
-			terminal.Print("Usage: layout [us|it]\n")
-			return
-		}
-
-		if switchLayoutFn == nil {
-			terminal.Print("layout: switcher not wired\n")
-			return
-		}
-
-		if !switchLayoutFn(targetLayout) {
-			terminal.Print("layout: failed to switch\n")
-			return
-		}
-
-		currentLayout = targetLayout
-		terminal.Print("layout: switched to ")
-		terminal.Print(targetLayout)
-		terminal.PutRune('\n')
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "version") {
-		printVersion()
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "run") {
-		a1s, a1e, ok := nextArg(cmdEnd, end)
-		if !ok {
-			terminal.Print("Usage: run <program>\n")
-			return
-		}
-
-		if runProgram == nil {
-			terminal.Print("run: runner not wired\n")
-			return
-		}
-
-		nameLen, ok := copyNameFromRange(a1s, a1e)
-		if !ok {
-			terminal.Print("run: invalid name\n")
-			return
-		}
-
-		pid, ok := runProgram(&tmpName, nameLen)
-		if !ok {
-			terminal.Print("run: not found or no slot\n")
-			return
-		}
-
-		terminal.Print("started pid=")
-		printUint(uint64(pid))
-		terminal.PutRune('\n')
-		return
-	}
-
-	if matchLiteral(cmdStart, cmdEnd, "agent") {
-		a1s, a1e, ok := nextArg(cmdEnd, end)
-		if !ok {
-			terminal.Print("Usage: agent <show|read|stat|delete|mode|help> [arg]\n")
-			return
-		}
-
-		if matchLiteral(a1s, a1e, "show") {
-			a2s, a2e, ok := nextArg(a1e, end)
-			if !ok {
-				terminal.Print("Usage: agent show <files|history|version|ticks|memorymap>\n")
-				return
-			}
-			if matchLiteral(a2s, a2e, "files") {
-				runAgentNoTarget(agent.ActionListFiles, agent.IntentListFiles, agent.RiskSafe)
-				return
-			} else if matchLiteral(a2s, a2e, "history") {
-				runAgentNoTarget(agent.ActionShowHistory, agent.IntentShowHistory, agent.RiskSafe)
-				return
-			} else if matchLiteral(a2s, a2e, "version") {
-				runAgentNoTarget(agent.ActionShowVersion, agent.IntentShowVersion, agent.RiskSafe)
-				return
-			} else if matchLiteral(a2s, a2e, "ticks") {
-				runAgentNoTarget(agent.ActionShowTicks, agent.IntentShowTicks, agent.RiskSafe)
-				return
-			} else if matchLiteral(a2s, a2e, "memorymap") || matchLiteral(a2s, a2e, "memory_map") {
-				runAgentNoTarget(agent.ActionShowMemoryMap, agent.IntentShowMemoryMap, agent.RiskSafe)
-				return
-			}
-			terminal.Print("Usage: agent show <files|history|version|ticks|memorymap>\n")
-			return
-		} else if matchLiteral(a1s, a1e, "read") {
-			a2s, a2e, ok := nextArg(a1e, end)
-			if !ok {
-				terminal.Print("Usage: agent read <name>\n")
-				return
-			}
-			runAgentAction(agent.ActionReadFile, agent.IntentReadFile, agent.RiskSafe, a2s, a2e)
-			return
-		} else if matchLiteral(a1s, a1e, "delete") {
-			a2s, a2e, ok := nextArg(a1e, end)
-			if !ok {
-				terminal.Print("Usage: agent delete <name> [confirm]\n")
-				return
-			}
-			a3s, a3e, confirmed := nextArg(a2e, end)
-			if confirmed && matchLiteral(a3s, a3e, "confirm") {
-				runAgentAction(agent.ActionDeleteFile, agent.IntentDeleteFile, agent.RiskSafe, a2s, a2e)
-				return
-			}
-			runAgentAction(agent.ActionDeleteFile, agent.IntentDeleteFile, agent.RiskRisky, a2s, a2e)
-			return
-		} else if matchLiteral(a1s, a1e, "stat") {
-			a2s, a2e, ok := nextArg(a1e, end)
-			if !ok {
-				terminal.Print("Usage: agent stat <name>\n")
-				return
-			}
-			runAgentAction(agent.ActionStatFile, agent.IntentStatFile, agent.RiskSafe, a2s, a2e)
-			return
-		} else if matchLiteral(a1s, a1e, "mode") {
-			a2s, a2e, ok := nextArg(a1e, end)
-			if ok {
-				runAgentAction(agent.ActionSetMode, agent.IntentSetMode, agent.RiskSafe, a2s, a2e)
-				return
-			}
-			runAgentNoTarget(agent.ActionSetMode, agent.IntentSetMode, agent.RiskSafe)
-			return
-		} else if matchLiteral(a1s, a1e, "help") {
-			runAgentNoTarget(agent.ActionShowHelp, agent.IntentShowHelp, agent.RiskSafe)
-			return
-		}
-
-		terminal.Print("Try with agent help to see available commands\n")
-		return
-	}
-
-	var suggestionBuf [len(commandBuf)]string
-	suggestionCount := 0
-
-	for i := 0; i < len(commandBuf); i++ {
-		if calculateDistance(cmdStart, cmdEnd, commandBuf[i]) < maxDistanceThreshold {
-			suggestionBuf[suggestionCount] = commandBuf[i]
-			suggestionCount++
+			terminal.Print(" ")
 		}
+		terminal.Print(name)
 	}
-
-	if suggestionCount > 0 {
-		terminal.Print("Did you mean '")
-		for i := 0; i < suggestionCount; i++ {
-			if i > 0 { // To ensure formatting; the first time we print we do not add a space in-front of the suggestion
-				terminal.Print(" ")
-			}
-			terminal.Print(suggestionBuf[i])
-		}
+	if found {
 		terminal.Print("'?")
 		terminal.PutRune('\n')
 		return
@@ -957,6 +398,7 @@ func printAgentMessage(message agent.MessageKind) {
 		terminal.Print("  agent stat <name>   - Show file metadata through the agent\n")
 		terminal.Print("  agent delete <name> confirm - Delete a file through the agent\n")
 		terminal.Print("  agent mode [mode]   - Show or switch agent mode\n")
+		terminal.Print("  agent ask <request> - Plan a request through the LLM bridge\n")
 		terminal.Print("  agent help          - Show agent commands")
 	case agent.MessageHistoryListed:
 		terminal.Print("agent: history listed")
@@ -972,11 +414,61 @@ func printAgentMessage(message agent.MessageKind) {
 		terminal.Print("agent: llm mode not configured")
 	case agent.MessageUnsupportedMode:
 		terminal.Print("agent: unsupported mode")
+	case agent.MessageLLMMode:
+		terminal.Print("agent: llm mode")
+	case agent.MessageLLMBridgeNotConfigured:
+		terminal.Print("agent: llm bridge not configured")
+	case agent.MessageLLMBridgeFailed:
+		terminal.Print("agent: llm bridge failed")
+	case agent.MessageBridgeTimeout:
+		terminal.Print("bridge timeout")
+	case agent.MessageBridgeResponseInvalid:
+		terminal.Print("agent: bridge response rejected")
+	case agent.MessageBridgeNoAction:
+		terminal.Print("agent: no allowed action matched")
 	default:
 		return
 	}
 }
 
+// printLayouts lists the compiled-in layouts and the keymap files found in
+// the FAT16 root directory
+func printLayouts() {
+	terminal.Print("built-in:")
+	for i := 0; i < layout.BuiltinCount(); i++ {
+		terminal.PutRune(' ')
+		terminal.Print(layout.BuiltinName(i))
+	}
+	terminal.PutRune('\n')
+
+	var name [12]byte
+	found := false
+	for i := 0; ; i++ {
+		n, more := fat16.DirName(i, &name)
+		if !more {
+			break
+		}
+		if n < 5 || name[n-4] != '.' || name[n-3] != 'K' || name[n-2] != 'M' || name[n-1] != 'P' {
+			continue
+		}
+		if !found {
+			terminal.Print("on disk:")
+			found = true
+		}
+		terminal.PutRune(' ')
+		for j := 0; j < n-4; j++ {
+			c := name[j]
+			if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			terminal.PutRune(rune(c))
+		}
+	}
+	if found {
+		terminal.PutRune('\n')
+	}
+}
+
 func printHistory() {
 	startIdx := (historyHead - historyCount + maxHistory) % maxHistory
 	for i := 0; i < historyCount; i++ {
@@ -984,10 +476,7 @@ func printHistory() {
 		printUint(uint64(i + 1))
 		terminal.Print(" ")
 
-		l := historyLen[idx]
-		for k := 0; k < l; k++ {
-			terminal.PutRune(rune(historyBuf[idx][k]))
-		}
+		terminal.Print(bytesString(historyBuf[idx][:historyLen[idx]]))
 		terminal.PutRune('\n')
 	}
 }
@@ -1054,10 +543,7 @@ func agentReadFile(action agent.Action, _ *agent.Context) agent.ActionResult {
 	if !ok {
 		return agent.ActionResult{OK: false, Message: agent.MessageFileNotFound}
 	}
-	for i := uint64(0); i < size; i++ {
-		b := *(*byte)(unsafe.Pointer(uintptr(page) + uintptr(i)))
-		terminal.PutRune(rune(b))
-	}
+	terminal.Print(pageString(page, size))
 	terminal.PutRune('\n')
 	return agent.ActionResult{OK: true, Message: agent.MessageFileRead}
 }
@@ -1130,13 +616,21 @@ func agentShowMemoryMap(_ agent.Action, _ *agent.Context) agent.ActionResult {
 
 func agentSetMode(action agent.Action, _ *agent.Context) agent.ActionResult {
 	if action.TargetLen == 0 {
+		if agentLLM {
+			return agent.ActionResult{OK: true, Message: agent.MessageLLMMode}
+		}
 		return agent.ActionResult{OK: true, Message: agent.MessageDeterministicMode}
 	}
 	if actionTargetMatches(action, "deterministic") {
+		agentLLM = false
 		return agent.ActionResult{OK: true, Message: agent.MessageDeterministicMode}
 	}
 	if actionTargetMatches(action, "llm") {
-		return agent.ActionResult{OK: false, Message: agent.MessageLLMModeNotConfigured}
+		if agentPlanner.Bridge == nil {
+			return agent.ActionResult{OK: false, Message: agent.MessageLLMModeNotConfigured}
+		}
+		agentLLM = true
+		return agent.ActionResult{OK: true, Message: agent.MessageLLMMode}
 	}
 	return agent.ActionResult{OK: false, Message: agent.MessageUnsupportedMode}
 }
@@ -1197,12 +691,439 @@ func matchLiteral(start, end int, lit string) bool {
 	return true
 }
 
+// lineString views lineBuf[start:end] as a string without copying, since
+// the freestanding build cannot allocate one. It is only valid until the
+// next line is read.
+func lineString(start, end int) string {
+	if start >= end {
+		return ""
+	}
+	return bytesString(lineBuf[start:end])
+}
+
+// pageString views the contents of a RAM fs file, at most a page
+func pageString(page, size uint64) string {
+	if size > 4096 {
+		size = 4096
+	}
+	return bytesString((*[4096]byte)(unsafe.Pointer(uintptr(page)))[:size])
+}
+
+// bytesString views b as a string without copying, like lineString
+func bytesString(b []byte) string {
+	var s string
+	if len(b) == 0 {
+		return s
+	}
+	h := (*[2]uintptr)(unsafe.Pointer(&s))
+	h[0] = uintptr(unsafe.Pointer(&b[0]))
+	h[1] = uintptr(len(b))
+	return s
+}
+
 func printRange(start, end int) {
-	i := start
-	for i < end && i < maxLine {
-		terminal.PutRune(rune(lineBuf[i]))
-		i++
+	if end > maxLine {
+		end = maxLine
+	}
+	terminal.Print(lineString(start, end))
+}
+
+// printDate prints t as YYYY-MM-DD HH:MM:SS
+func printDate(t rtc.Time) {
+	printPadded(uint64(t.Year), 4)
+	terminal.PutRune('-')
+	printPadded(uint64(t.Month), 2)
+	terminal.PutRune('-')
+	printPadded(uint64(t.Day), 2)
+	terminal.PutRune(' ')
+	printPadded(uint64(t.Hour), 2)
+	terminal.PutRune(':')
+	printPadded(uint64(t.Minute), 2)
+	terminal.PutRune(':')
+	printPadded(uint64(t.Second), 2)
+}
+
+// printCPUs lists every processor found at boot and whether it came online
+func printCPUs() {
+	n := percpu.Count()
+	if n == 0 {
+		terminal.Print("cpus: SMP not started\n")
+		return
+	}
+	for i := 0; i < n; i++ {
+		c := percpu.Get(i)
+		terminal.Print("CPU ")
+		printUint(uint64(c.Index))
+		terminal.Print("  APIC ")
+		printUint(uint64(c.APICID))
+		if c.Online {
+			terminal.Print("  online")
+		} else {
+			terminal.Print("  offline")
+		}
+		if c.BSP {
+			terminal.Print(" (BSP)")
+		}
+		terminal.PutRune('\n')
+	}
+	printUint(uint64(percpu.OnlineCount()))
+	terminal.Print(" of ")
+	printUint(uint64(n))
+	terminal.Print(" CPUs online\n")
+}
+
+// printBlockDevices lists the registered disks; '*' marks the one FAT16
+// uses
+func printBlockDevices() {
+	n := block.Count()
+	if n == 0 {
+		terminal.Print("lsblk: no block devices\n")
+		return
+	}
+	for i := 0; i < n; i++ {
+		d := block.At(i)
+		if d == block.Default() {
+			terminal.Print("* ")
+		} else {
+			terminal.Print("  ")
+		}
+		terminal.Print(d.Name)
+		terminal.Print("  ")
+		printUint(d.Sectors)
+		terminal.Print(" sectors (")
+		printSize(d.Sectors * block.SectorSize)
+		terminal.PutRune(')')
+		if d.ReadOnly {
+			terminal.Print(" read-only")
+		}
+		terminal.PutRune('\n')
+	}
+}
+
+// printInterfaces lists the network interfaces with their address, link
+// state and traffic counters
+func printInterfaces() {
+	n := netdev.Count()
+	if n == 0 {
+		terminal.Print("ifconfig: no network interfaces\n")
+		return
+	}
+	for i := 0; i < n; i++ {
+		d := netdev.At(i)
+		terminal.Print(d.Name)
+		terminal.Print("  HWaddr ")
+		for j := 0; j < netdev.AddrLen; j++ {
+			if j > 0 {
+				terminal.PutRune(':')
+			}
+			printHex8(d.MAC[j])
+		}
+		terminal.Print("  MTU ")
+		printUint(uint64(d.MTU))
+		if d.LinkUp {
+			terminal.Print("  UP\n")
+		} else {
+			terminal.Print("  DOWN\n")
+		}
+		if d == net.Interface() && net.Configured() {
+			terminal.Print("      inet ")
+			printIP(net.Addr())
+			terminal.Print("  netmask ")
+			printIP(net.Netmask())
+			if net.Gateway() != 0 {
+				terminal.Print("  gateway ")
+				printIP(net.Gateway())
+			}
+			terminal.PutRune('\n')
+		}
+		terminal.Print("      RX packets ")
+		printUint(d.Stats.RxPackets)
+		terminal.Print("  bytes ")
+		printUint(d.Stats.RxBytes)
+		terminal.Print("  dropped ")
+		printUint(d.Stats.RxDropped)
+		terminal.Print("\n      TX packets ")
+		printUint(d.Stats.TxPackets)
+		terminal.Print("  bytes ")
+		printUint(d.Stats.TxBytes)
+		terminal.Print("  dropped ")
+		printUint(d.Stats.TxDropped)
+		terminal.PutRune('\n')
+	}
+}
+
+// runPing sends count echo requests to dst, one per second, and reports
+// each reply and a summary. An error other than a timeout ends the run.
+func runPing(dst net.IP, count int) {
+	terminal.Print("PING ")
+	printIP(dst)
+	terminal.Print(": ")
+	printUint(pingDataSize)
+	terminal.Print(" data bytes\n")
+
+	received := 0
+	sent := 0
+	for seq := 1; seq <= count; seq++ {
+		if seq > 1 && sleepFn != nil {
+			sleepFn(pingIntervalMs)
+		}
+		rtt, ttl, err := net.Ping(dst, uint16(seq), pingDataSize, pingTimeoutNs)
+		if err != net.OK && err != net.ErrTimeout {
+			terminal.Print("ping: ")
+			terminal.Print(err.String())
+			terminal.PutRune('\n')
+			return
+		}
+		sent++
+		if err == net.ErrTimeout {
+			terminal.Print("Request timeout for seq=")
+			printUint(uint64(seq))
+			terminal.PutRune('\n')
+			continue
+		}
+		received++
+		printUint(pingDataSize + 8)
+		terminal.Print(" bytes from ")
+		printIP(dst)
+		terminal.Print(": seq=")
+		printUint(uint64(seq))
+		terminal.Print(" ttl=")
+		printUint(uint64(ttl))
+		terminal.Print(" time=")
+		printMillis(rtt)
+		terminal.Print(" ms\n")
+	}
+	printUint(uint64(sent))
+	terminal.Print(" packets sent, ")
+	printUint(uint64(received))
+	terminal.Print(" received\n")
+}
+
+// printMillis prints ns as milliseconds with three decimals
+func printMillis(ns uint64) {
+	us := ns / 1000
+	printUint(us / 1000)
+	terminal.PutRune('.')
+	frac := us % 1000
+	if frac < 100 {
+		terminal.PutRune('0')
+	}
+	if frac < 10 {
+		terminal.PutRune('0')
+	}
+	printUint(frac)
+}
+
+func printIP(ip net.IP) {
+	var buf [net.MaxIPStringLen]byte
+	printBytes(buf[:net.FormatIP(ip, &buf)])
+}
+
+// printDiskInfo shows the IDENTIFY DEVICE summary of the primary master
+func printDiskInfo(d *ata.DeviceInfo) {
+	terminal.Print("Model:   ")
+	printBytes(d.Model[:d.ModelLen])
+	terminal.Print("\nSerial:  ")
+	printBytes(d.Serial[:d.SerialLen])
+	terminal.Print("\nSectors: ")
+	printUint(d.Sectors)
+	terminal.Print(" (")
+	printUint(d.Sectors / 2048)
+	terminal.Print(" MiB)\nLBA48:   ")
+	if d.LBA48 {
+		terminal.Print("yes\n")
+	} else {
+		terminal.Print("no\n")
+	}
+}
+
+func printBytes(b []byte) {
+	terminal.Print(bytesString(b))
+}
+
+// runDiskBench reads the first sectors of the disk once per ATA transfer
+// mode and prints the time taken and the throughput
+func runDiskBench(sectors int) {
+	saved := ata.CurrentMode()
+	for m := ata.ModePolling; m <= ata.ModeDMA; m++ {
+		terminal.Print(m.String())
+		terminal.Print(": ")
+		if !ata.SetMode(m) {
+			terminal.Print("not available\n")
+			continue
+		}
+		start := nanotimeFn()
+		err := ata.ReadSectors(0, sectors, &benchBuf[0])
+		elapsed := nanotimeFn() - start
+		if err != ata.OK {
+			terminal.Print("failed: ")
+			terminal.Print(err.String())
+			terminal.PutRune('\n')
+			continue
+		}
+		if elapsed == 0 {
+			elapsed = 1
+		}
+		printUint(uint64(sectors))
+		terminal.Print(" sectors in ")
+		printUint(elapsed / 1000)
+		terminal.Print(" us, ")
+		// KiB per second: sectors/2 KiB over elapsed ns
+		printUint(uint64(sectors) * 1000000000 / 2 / elapsed)
+		terminal.Print(" KiB/s\n")
+	}
+	ata.SetMode(saved)
+}
+
+// printIRQStats lists every legacy IRQ line that has handlers or has
+// fired, with its interrupt and spurious counts
+func printIRQStats() {
+	shown := 0
+	for line := uint8(0); line < irq.Lines; line++ {
+		handlers := irq.HandlerCount(line)
+		count := irq.Count(line)
+		spurious := irq.Spurious(line)
+		if handlers == 0 && count == 0 && spurious == 0 {
+			continue
+		}
+		terminal.Print("IRQ ")
+		printUint(uint64(line))
+		terminal.Print(": ")
+		printUint(count)
+		terminal.Print(" interrupts, handlers=")
+		printUint(uint64(handlers))
+		if spurious > 0 {
+			terminal.Print(", spurious=")
+			printUint(spurious)
+		}
+		if irq.Masked(line) {
+			terminal.Print(", masked")
+		}
+		terminal.PutRune('\n')
+		shown++
+	}
+	if shown == 0 {
+		terminal.Print("irqstat: no IRQ lines in use\n")
+	}
+}
+
+// printPCI lists the functions found on the PCI bus, with their BARs, IRQ
+// and capabilities when verbose
+func printPCI(verbose bool) {
+	n := pci.Count()
+	if n == 0 {
+		terminal.Print("lspci: no PCI devices found\n")
+		return
+	}
+	for i := 0; i < n; i++ {
+		d := pci.At(i)
+		printHex8(d.Bus)
+		terminal.PutRune(':')
+		printHex8(d.Slot)
+		terminal.PutRune('.')
+		printUint(uint64(d.Function))
+		terminal.PutRune(' ')
+		terminal.Print(pci.ClassName(d.Class, d.Subclass))
+		terminal.Print(": ")
+		printHex16(d.VendorID)
+		terminal.PutRune(':')
+		printHex16(d.DeviceID)
+		if name := d.DriverName(); len(name) > 0 {
+			terminal.Print(" [")
+			terminal.Print(name)
+			terminal.PutRune(']')
+		}
+		terminal.PutRune('\n')
+		if verbose {
+			printPCIDetails(d)
+		}
 	}
+	printUint(uint64(n))
+	terminal.Print(" devices, config via ")
+	terminal.Print(pci.Mechanism())
+	terminal.PutRune('\n')
+}
+
+func printPCIDetails(d *pci.Device) {
+	for i := 0; i < pci.MaxBARs; i++ {
+		bar := &d.BARs[i]
+		if bar.Kind == pci.BARNone {
+			continue
+		}
+		terminal.Print("  BAR")
+		printUint(uint64(i))
+		terminal.Print(": ")
+		terminal.Print(bar.Kind.String())
+		terminal.Print(" 0x")
+		if bar.Kind == pci.BARIO {
+			printHex16(uint16(bar.Base))
+		} else if bar.Base>>32 != 0 {
+			printHexU64(bar.Base)
+		} else {
+			printHex32(uint32(bar.Base))
+		}
+		terminal.Print(" size ")
+		printSize(bar.Size)
+		if bar.Prefetchable {
+			terminal.Print(" prefetchable")
+		}
+		terminal.PutRune('\n')
+	}
+	if d.IRQPin != 0 {
+		terminal.Print("  IRQ ")
+		printUint(uint64(d.IRQLine))
+		terminal.Print(", pin ")
+		terminal.PutRune(rune('A' + d.IRQPin - 1))
+		terminal.PutRune('\n')
+	}
+	if d.CapCount == 0 {
+		return
+	}
+	terminal.Print("  Capabilities:")
+	for i := 0; i < d.CapCount; i++ {
+		terminal.PutRune(' ')
+		terminal.Print(pci.CapabilityName(d.Caps[i].ID))
+		if d.Caps[i].ID != pci.CapMSI {
+			continue
+		}
+		terminal.PutRune('(')
+		if d.MSI.Is64Bit {
+			terminal.Print("64-bit, ")
+		}
+		printUint(uint64(d.MSI.Vectors))
+		terminal.Print(" vectors")
+		if d.MSI.Enabled {
+			terminal.Print(", enabled")
+		}
+		terminal.PutRune(')')
+	}
+	terminal.PutRune('\n')
+}
+
+// printSize prints a byte count in the largest of B, KiB and MiB that
+// divides it exactly
+func printSize(n uint64) {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		printUint(n >> 20)
+		terminal.Print(" MiB")
+	case n >= 1<<10 && n%(1<<10) == 0:
+		printUint(n >> 10)
+		terminal.Print(" KiB")
+	default:
+		printUint(n)
+	}
+}
+
+// printPadded prints v in decimal with leading zeros up to width digits
+func printPadded(v uint64, width int) {
+	for limit := uint64(10); width > 1; width-- {
+		if v < limit {
+			terminal.PutRune('0')
+		}
+		limit *= 10
+	}
+	printUint(v)
 }
 
 func printUint(v uint64) {
@@ -1344,6 +1265,11 @@ func printHexU64(v uint64) {
 	}
 }
 
+func printHex16(v uint16) {
+	printHex8(byte(v >> 8))
+	printHex8(byte(v))
+}
+
 func printHex8(b byte) {
 	hexDigits := "0123456789ABCDEF"
 	terminal.PutRune(rune(hexDigits[(b>>4)&0xF]))
@@ -1351,9 +1277,7 @@ func printHex8(b byte) {
 }
 
 func printName(name *[16]byte, nameLen int) {
-	for i := 0; i < nameLen; i++ {
-		terminal.PutRune(rune(name[i]))
-	}
+	terminal.Print(bytesString(name[:nameLen]))
 }
 
 func copyNameFromRange(start, end int) (int, bool) {
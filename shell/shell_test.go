@@ -5,13 +5,24 @@ import (
 	"testing"
 
 	"github.com/dmarro89/go-dav-os/agent"
+	"github.com/dmarro89/go-dav-os/drivers/ata"
+	"github.com/dmarro89/go-dav-os/drivers/block"
+	"github.com/dmarro89/go-dav-os/drivers/netdev"
+	"github.com/dmarro89/go-dav-os/drivers/pci"
+	"github.com/dmarro89/go-dav-os/drivers/rtc"
 	"github.com/dmarro89/go-dav-os/fs"
+	"github.com/dmarro89/go-dav-os/fs/fat16"
+	"github.com/dmarro89/go-dav-os/kernel/irq"
+	"github.com/dmarro89/go-dav-os/kernel/percpu"
+	"github.com/dmarro89/go-dav-os/keyboard"
+	"github.com/dmarro89/go-dav-os/net"
 	"github.com/dmarro89/go-dav-os/terminal"
 )
 
 // Test helper to set lineBuf for testing
 func setLineBuf(content string) {
 	lineLen = len(content)
+	lineCursor = lineLen
 	for i := 0; i < maxLine; i++ {
 		lineBuf[i] = 0
 	}
@@ -465,7 +476,7 @@ func TestExecuteAgentCommand(t *testing.T) {
 		{
 			name:  "missing command",
 			input: "agent",
-			want:  "Usage: agent <show|read|stat|delete|mode|help> [arg]\n",
+			want:  "Usage: agent <show|read|stat|delete|mode|ask|help> [arg]\n",
 		},
 		{
 			name:  "missing show argument",
@@ -525,7 +536,17 @@ func TestExecuteAgentCommand(t *testing.T) {
 		{
 			name:  "help",
 			input: "agent help",
-			want:  "Agent commands:\n  agent show files    - Show files managed by the agent\n  agent show history  - Show command history stored by the agent\n  agent show version  - Show OS version through the agent\n  agent show ticks    - Show PIT ticks through the agent\n  agent show memorymap - Show memory map through the agent\n  agent read <name>   - Read a file through the agent\n  agent stat <name>   - Show file metadata through the agent\n  agent delete <name> confirm - Delete a file through the agent\n  agent mode [mode]   - Show or switch agent mode\n  agent help          - Show agent commands\n",
+			want:  "Agent commands:\n  agent show files    - Show files managed by the agent\n  agent show history  - Show command history stored by the agent\n  agent show version  - Show OS version through the agent\n  agent show ticks    - Show PIT ticks through the agent\n  agent show memorymap - Show memory map through the agent\n  agent read <name>   - Read a file through the agent\n  agent stat <name>   - Show file metadata through the agent\n  agent delete <name> confirm - Delete a file through the agent\n  agent mode [mode]   - Show or switch agent mode\n  agent ask <request> - Plan a request through the LLM bridge\n  agent help          - Show agent commands\n",
+		},
+		{
+			name:  "llm mode without bridge",
+			input: "agent mode llm",
+			want:  "agent: llm mode not configured\n",
+		},
+		{
+			name:  "ask in deterministic mode",
+			input: "agent ask show me the files",
+			want:  "agent: ask needs llm mode (agent mode llm)\n",
 		},
 	}
 
@@ -544,20 +565,116 @@ func TestExecuteAgentCommand(t *testing.T) {
 	}
 }
 
+// scriptedBridge answers every request with one plan and records the input
+type scriptedBridge struct {
+	input  string
+	result agent.PlanningResult
+}
+
+func (b *scriptedBridge) Plan(input string, _ *agent.Context) agent.PlanningResult {
+	b.input = input
+	return b.result
+}
+
+func TestExecuteAgentAskUsesLLMBridge(t *testing.T) {
+	fs.Init()
+	runtime := agent.NewDeterministicAgent(NewAgentExecutor())
+	SetAgentRuntime(&runtime)
+	bridge := &scriptedBridge{}
+	bridge.result = agent.ParseBridgeResponse([]byte(`{"intent":"show_version","action":"show_version","args":[],"risk":"safe"}`))
+	SetAgentBridge(bridge)
+	t.Cleanup(func() {
+		SetAgentBridge(nil)
+		SetAgentRuntime(nil)
+	})
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "agent mode llm", want: "agent: llm mode\n"},
+		{input: "agent mode", want: "agent: llm mode\n"},
+		{input: "agent ask", want: "Usage: agent ask <request>\n"},
+		{input: "agent ask what   version is this?", want: "DavOS 0.0.5 (64bit)\nagent: version shown\n"},
+	}
+	terminal.Init()
+	for _, tt := range tests {
+		terminal.ResetOutputForTesting()
+		setLineBuf(tt.input)
+		execute()
+		if got := terminal.OutputForTesting(); got != tt.want {
+			t.Fatalf("execute(%q) output = %q, expected %q", tt.input, got, tt.want)
+		}
+	}
+	if bridge.input != "what   version is this?" {
+		t.Fatalf("bridge saw %q", bridge.input)
+	}
+
+	bridge.result = agent.ParseBridgeResponse([]byte(`{"intent":"delete_file","action":"delete_file","args":["notes"],"risk":"risky"}`))
+	terminal.ResetOutputForTesting()
+	setLineBuf("agent ask delete notes")
+	execute()
+	if got := terminal.OutputForTesting(); got != "agent: confirmation required\n" {
+		t.Fatalf("risky bridge plan output = %q", got)
+	}
+
+	bridge.result = agent.PlanningResult{OK: false, Reason: agent.MessageBridgeTimeout}
+	terminal.ResetOutputForTesting()
+	setLineBuf("agent ask show files")
+	execute()
+	if got := terminal.OutputForTesting(); got != "bridge timeout\n" {
+		t.Fatalf("bridge timeout output = %q", got)
+	}
+
+	terminal.ResetOutputForTesting()
+	setLineBuf("agent mode deterministic")
+	execute()
+	setLineBuf("agent ask show files")
+	execute()
+	if got := terminal.OutputForTesting(); got != "agent: deterministic mode\nagent: ask needs llm mode (agent mode llm)\n" {
+		t.Fatalf("after switching back: %q", got)
+	}
+}
+
 func TestExecuteHelpListsImplementedCommands(t *testing.T) {
 	terminal.Init()
 	terminal.ResetOutputForTesting()
+	RegisterCommands()
+	fat16.RegisterCommands()
 	setLineBuf("help")
 
 	execute()
 
 	got := terminal.OutputForTesting()
-	want := "Commands: help, history, clear, echo, ticks, uptime, mem, mmap, mmapmax, pfa, alloc, free, ls, write, cat, rm, stat, disk, fatinit, fatformat, fatinfo, fatls, fatcreate, fatread, layout, version, run, agent\n"
+	want := "Commands: help, history, clear, echo, ticks, uptime, sleep, date, cpus, irqstat, lspci, mem, mmap, mmapmax, pfa, alloc, free, ls, write, cat, rm, stat, lsblk, disk, diskinfo, diskbench, fatcreate, fatread, ifconfig, ping, layout, version, run, jobs, fg, bg, kill, agent, source, set, unset, true, false, test, let, exit, grep, head, tail, wc, fatinit, fatformat, fatinfo, fatls\n"
 	if got != want {
 		t.Fatalf("help output = %q, expected %q", got, want)
 	}
 }
 
+func TestExecuteHelpShowsCommandUsage(t *testing.T) {
+	cases := []struct {
+		line, want string
+		status     int
+	}{
+		{"help sleep", "Usage: sleep <ms>\nBlock the shell on a one-shot timer\n", 0},
+		{"help clear", "Usage: clear\nClear the screen\n", 0},
+		{"help nosuch", "help: no command nosuch\n", 1},
+		{"help sleep clear", "Usage: help [<command>]\n", 2},
+		{"cat a b", "Usage: cat <name>\n", 2},
+		{"disk seek 1", "Usage: disk <read|write> <lba> [text]\n", 2},
+	}
+	for _, c := range cases {
+		terminal.Init()
+		terminal.ResetOutputForTesting()
+		setLineBuf(c.line)
+		execute()
+		if got := terminal.OutputForTesting(); got != c.want || exitStatus != c.status {
+			t.Fatalf("%q: output %q status %d, want %q status %d", c.line, got, exitStatus, c.want, c.status)
+		}
+	}
+}
+
 func TestExecuteAgentShowFilesUsesDefaultRuntime(t *testing.T) {
 	fs.Init()
 	runtime := agent.NewDeterministicAgent(NewAgentExecutor())
@@ -777,3 +894,716 @@ func TestExecuteAgentCommandSuccessPaths(t *testing.T) {
 		}
 	})
 }
+
+func TestExecuteSleep(t *testing.T) {
+	var slept []uint64
+	SetSleeper(func(ms uint64) { slept = append(slept, ms) })
+	t.Cleanup(func() { SetSleeper(nil) })
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"missing duration", "sleep", "Usage: sleep <ms>\n"},
+		{"invalid duration", "sleep soon", "sleep: invalid duration\n"},
+		{"sleeps quietly", "sleep 250", ""},
+	}
+
+	terminal.Init()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			terminal.ResetOutputForTesting()
+			setLineBuf(tt.input)
+
+			execute()
+
+			if got := terminal.OutputForTesting(); got != tt.want {
+				t.Fatalf("execute(%q) output = %q, expected %q", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	if len(slept) != 1 || slept[0] != 250 {
+		t.Fatalf("expected one 250ms sleep, got %v", slept)
+	}
+}
+
+func TestExecuteDate(t *testing.T) {
+	terminal.Init()
+	setLineBuf("date")
+
+	terminal.ResetOutputForTesting()
+	execute()
+	if got := terminal.OutputForTesting(); got != "date: not wired yet\n" {
+		t.Fatalf("unwired date output = %q", got)
+	}
+
+	SetClock(func() (rtc.Time, bool) { return rtc.Time{}, false })
+	t.Cleanup(func() { SetClock(nil) })
+	terminal.ResetOutputForTesting()
+	execute()
+	if got := terminal.OutputForTesting(); got != "date: clock not available\n" {
+		t.Fatalf("date without RTC output = %q", got)
+	}
+
+	SetClock(func() (rtc.Time, bool) {
+		return rtc.Time{Year: 2026, Month: 3, Day: 7, Hour: 9, Minute: 5, Second: 0}, true
+	})
+	terminal.ResetOutputForTesting()
+	execute()
+	if got := terminal.OutputForTesting(); got != "2026-03-07 09:05:00\n" {
+		t.Fatalf("date output = %q", got)
+	}
+}
+
+func TestExecuteCPUs(t *testing.T) {
+	terminal.Init()
+	percpu.Reset()
+	setLineBuf("cpus")
+
+	terminal.ResetOutputForTesting()
+	execute()
+	if got := terminal.OutputForTesting(); got != "cpus: SMP not started\n" {
+		t.Fatalf("cpus before SMP output = %q", got)
+	}
+
+	percpu.Register(0, true).Online = true
+	percpu.Register(2, false).Online = true
+	percpu.Register(5, false)
+	t.Cleanup(percpu.Reset)
+
+	terminal.ResetOutputForTesting()
+	execute()
+	want := "CPU 0  APIC 0  online (BSP)\n" +
+		"CPU 1  APIC 2  online\n" +
+		"CPU 2  APIC 5  offline\n" +
+		"2 of 3 CPUs online\n"
+	if got := terminal.OutputForTesting(); got != want {
+		t.Fatalf("cpus output = %q, want %q", got, want)
+	}
+}
+
+func TestExecuteIRQStat(t *testing.T) {
+	terminal.Init()
+	irq.Init()
+	setLineBuf("irqstat")
+
+	terminal.ResetOutputForTesting()
+	execute()
+	if got := terminal.OutputForTesting(); got != "irqstat: no IRQ lines in use\n" {
+		t.Fatalf("irqstat with no lines output = %q", got)
+	}
+
+	irq.RegisterIRQ(0, func() {})
+	irq.RegisterIRQ(11, func() {})
+	irq.RegisterIRQ(11, func() {})
+	t.Cleanup(irq.Init)
+	irq.Dispatch(0)
+	irq.Dispatch(0)
+	irq.Dispatch(11)
+	irq.Dispatch(7) // nothing in service on the fake PIC: spurious
+
+	terminal.ResetOutputForTesting()
+	execute()
+	want := "IRQ 0: 2 interrupts, handlers=1\n" +
+		"IRQ 7: 0 interrupts, handlers=0, spurious=1, masked\n" +
+		"IRQ 11: 1 interrupts, handlers=2\n"
+	if got := terminal.OutputForTesting(); got != want {
+		t.Fatalf("irqstat output = %q, want %q", got, want)
+	}
+}
+
+func TestExecuteLspci(t *testing.T) {
+	terminal.Init()
+	setLineBuf("lspci")
+	terminal.ResetOutputForTesting()
+	execute()
+	if got := terminal.OutputForTesting(); got != "lspci: no PCI devices found\n" {
+		t.Fatalf("lspci before enumeration output = %q", got)
+	}
+
+	// The fake bus is QEMU's default machine
+	pci.Init(0, 0, 0)
+	pci.Register(&ata.PCIDriver)
+	pci.Probe()
+
+	terminal.ResetOutputForTesting()
+	execute()
+	want := "00:00.0 Host bridge: 8086:1237\n" +
+		"00:01.0 ISA bridge: 8086:7000\n" +
+		"00:01.1 IDE interface: 8086:7010 [ata]\n" +
+		"00:01.3 Bridge: 8086:7113\n" +
+		"00:02.0 VGA compatible controller: 1234:1111\n" +
+		"00:03.0 Ethernet controller: 8086:100E\n" +
+		"6 devices, config via ports\n"
+	if got := terminal.OutputForTesting(); got != want {
+		t.Fatalf("lspci output = %q, want %q", got, want)
+	}
+
+	setLineBuf("lspci -v")
+	terminal.ResetOutputForTesting()
+	execute()
+	nic := "00:03.0 Ethernet controller: 8086:100E\n" +
+		"  BAR0: mem32 0xFEBC0000 size 128 KiB\n" +
+		"  BAR1: io 0xC000 size 64\n" +
+		"  IRQ 11, pin A\n"
+	vga := "  BAR0: mem32 0xFD000000 size 16 MiB prefetchable\n"
+	if got := terminal.OutputForTesting(); !strings.Contains(got, nic) || !strings.Contains(got, vga) {
+		t.Fatalf("lspci -v output = %q, want it to contain %q and %q", got, nic, vga)
+	}
+
+	setLineBuf("lspci -x")
+	terminal.ResetOutputForTesting()
+	execute()
+	if got := terminal.OutputForTesting(); got != "Usage: lspci [-v]\n" {
+		t.Fatalf("lspci -x output = %q", got)
+	}
+}
+
+func readNothing(unit int, lba uint64, count int, buf *byte) bool { return false }
+
+func TestExecuteLsblk(t *testing.T) {
+	terminal.Init()
+	block.ResetForTesting()
+	t.Cleanup(block.ResetForTesting)
+	setLineBuf("lsblk")
+
+	terminal.ResetOutputForTesting()
+	execute()
+	if got := terminal.OutputForTesting(); got != "lsblk: no block devices\n" {
+		t.Fatalf("lsblk with no devices output = %q", got)
+	}
+
+	vda := block.Device{Name: "vda", Sectors: 131072, Read: readNothing}
+	hda := block.Device{Name: "hda", Sectors: 40960, ReadOnly: true, Read: readNothing}
+	block.Register(&vda)
+	block.Register(&hda)
+
+	terminal.ResetOutputForTesting()
+	execute()
+	want := "* vda  131072 sectors (64 MiB)\n" +
+		"  hda  40960 sectors (20 MiB) read-only\n"
+	if got := terminal.OutputForTesting(); got != want {
+		t.Fatalf("lsblk output = %q, want %q", got, want)
+	}
+}
+
+func sendNothing(unit int, frame []byte) bool { return true }
+
+func TestExecuteIfconfig(t *testing.T) {
+	terminal.Init()
+	netdev.ResetForTesting()
+	t.Cleanup(netdev.ResetForTesting)
+	setLineBuf("ifconfig")
+
+	terminal.ResetOutputForTesting()
+	execute()
+	if got := terminal.OutputForTesting(); got != "ifconfig: no network interfaces\n" {
+		t.Fatalf("ifconfig with no interfaces output = %q", got)
+	}
+
+	eth0 := netdev.Device{
+		Name:   "eth0",
+		MAC:    [netdev.AddrLen]byte{0x52, 0x54, 0x00, 0x12, 0x34, 0xAB},
+		MTU:    netdev.MTU,
+		LinkUp: true,
+		Send:   sendNothing,
+	}
+	eth0.Stats.RxPackets, eth0.Stats.RxBytes, eth0.Stats.RxDropped = 3, 180, 1
+	eth0.Stats.TxPackets, eth0.Stats.TxBytes = 1, 42
+	eth1 := netdev.Device{Name: "eth1", MTU: netdev.MTU, Send: sendNothing}
+	netdev.Register(&eth0)
+	netdev.Register(&eth1)
+
+	terminal.ResetOutputForTesting()
+	execute()
+	want := "eth0  HWaddr 52:54:00:12:34:AB  MTU 1500  UP\n" +
+		"      RX packets 3  bytes 180  dropped 1\n" +
+		"      TX packets 1  bytes 42  dropped 0\n" +
+		"eth1  HWaddr 00:00:00:00:00:00  MTU 1500  DOWN\n" +
+		"      RX packets 0  bytes 0  dropped 0\n" +
+		"      TX packets 0  bytes 0  dropped 0\n"
+	if got := terminal.OutputForTesting(); got != want {
+		t.Fatalf("ifconfig output = %q, want %q", got, want)
+	}
+}
+
+func TestExecuteIfconfigShowsAddress(t *testing.T) {
+	terminal.Init()
+	netdev.ResetForTesting()
+	net.ResetForTesting()
+	t.Cleanup(func() {
+		net.ResetForTesting()
+		netdev.ResetForTesting()
+	})
+	eth0 := netdev.Device{Name: "eth0", MTU: netdev.MTU, LinkUp: true, Send: sendNothing}
+	netdev.Register(&eth0)
+	net.Init(&eth0)
+	net.Configure(net.IPv4(10, 0, 2, 15), net.IPv4(255, 255, 255, 0), net.IPv4(10, 0, 2, 2))
+	setLineBuf("ifconfig")
+
+	terminal.ResetOutputForTesting()
+	execute()
+	want := "      inet 10.0.2.15  netmask 255.255.255.0  gateway 10.0.2.2\n"
+	if got := terminal.OutputForTesting(); !strings.Contains(got, want) {
+		t.Fatalf("ifconfig output = %q, want it to contain %q", got, want)
+	}
+}
+
+func TestExecutePingReportsErrors(t *testing.T) {
+	terminal.Init()
+	net.ResetForTesting()
+	t.Cleanup(net.ResetForTesting)
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"ping", "Usage: ping <ip> [count]\n"},
+		{"ping 10.0.2", "ping: invalid address\n"},
+		{"ping 10.0.2.2 x", "ping: invalid count\n"},
+		{"ping 10.0.2.2 1", "PING 10.0.2.2: 56 data bytes\nping: no network interface\n"},
+	}
+	for _, tt := range tests {
+		setLineBuf(tt.line)
+		terminal.ResetOutputForTesting()
+		execute()
+		if got := terminal.OutputForTesting(); got != tt.want {
+			t.Errorf("%q output = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteDiskInfo(t *testing.T) {
+	terminal.Init()
+	setLineBuf("diskinfo")
+
+	terminal.ResetOutputForTesting()
+	execute()
+	want := "Model:   QEMU HARDDISK\n" +
+		"Serial:  QM00001\n" +
+		"Sectors: 40960 (20 MiB)\n" +
+		"LBA48:   yes\n"
+	if got := terminal.OutputForTesting(); got != want {
+		t.Fatalf("diskinfo output = %q, want %q", got, want)
+	}
+}
+
+func TestExecuteDiskBench(t *testing.T) {
+	terminal.Init()
+	setLineBuf("diskbench")
+
+	terminal.ResetOutputForTesting()
+	execute()
+	if got := terminal.OutputForTesting(); got != "diskbench: no clock\n" {
+		t.Fatalf("diskbench without clock output = %q", got)
+	}
+
+	// Every clock read advances 0.5 ms, so each benchmark read measures 500 us
+	now := uint64(0)
+	SetNanotime(func() uint64 { now += 500000; return now })
+	t.Cleanup(func() { SetNanotime(nil) })
+
+	terminal.ResetOutputForTesting()
+	execute()
+	want := "poll: 128 sectors in 500 us, 128000 KiB/s\n" +
+		"irq: 128 sectors in 500 us, 128000 KiB/s\n" +
+		"dma: not available\n"
+	if got := terminal.OutputForTesting(); got != want {
+		t.Fatalf("diskbench output = %q, want %q", got, want)
+	}
+	if ata.CurrentMode() != ata.ModePolling {
+		t.Fatalf("diskbench left the mode at %v", ata.CurrentMode())
+	}
+
+	setLineBuf("diskbench 999")
+	terminal.ResetOutputForTesting()
+	execute()
+	if got := terminal.OutputForTesting(); got != "Usage: diskbench [sectors 1-256]\n" {
+		t.Fatalf("diskbench 999 output = %q", got)
+	}
+}
+
+func TestPasteTypesIntoLineWithoutRunning(t *testing.T) {
+	setLineBuf("echo ")
+	terminal.ResetOutputForTesting()
+
+	Paste([]byte("one\ntwo\x01"))
+	if got := string(lineBuf[:lineLen]); got != "echo one two" {
+		t.Fatalf("line = %q, want %q", got, "echo one two")
+	}
+	if got := terminal.OutputForTesting(); got != "one two" {
+		t.Fatalf("output = %q, want %q", got, "one two")
+	}
+	setLineBuf("")
+}
+
+func press(key keyboard.Keycode) {
+	FeedKey(keyboard.Event{Key: key, Pressed: true})
+}
+
+func typeLine(s string) {
+	for _, r := range s {
+		FeedKey(keyboard.Event{Key: keyboard.KeySpace, Rune: r, Pressed: true})
+	}
+}
+
+func TestFeedKeyInsertsAndDeletesMidLine(t *testing.T) {
+	setLineBuf("")
+	terminal.ResetOutputForTesting()
+
+	typeLine("ecoh")
+	press(keyboard.KeyLeft)
+	press(keyboard.KeyLeft)
+	typeLine("h")
+	press(keyboard.KeyEnd)
+	FeedRune('\b')
+	if got := string(lineBuf[:lineLen]); got != "echo" || lineCursor != 4 {
+		t.Fatalf("line = %q cursor %d, want %q at 4", got, lineCursor, "echo")
+	}
+	if got := terminal.OutputForTesting(); got != "echo" {
+		t.Fatalf("output = %q, want the redrawn line", got)
+	}
+
+	press(keyboard.KeyHome)
+	press(keyboard.KeyDelete)
+	press(keyboard.KeyRight)
+	FeedRune('\b')
+	if got := string(lineBuf[:lineLen]); got != "ho" || lineCursor != 0 {
+		t.Fatalf("line = %q cursor %d, want %q at 0", got, lineCursor, "ho")
+	}
+	if got := terminal.OutputForTesting(); got != "ho  " {
+		t.Fatalf("output = %q", got)
+	}
+	setLineBuf("")
+}
+
+func TestLineEditsMultibyteRunes(t *testing.T) {
+	setLineBuf("")
+	terminal.ResetOutputForTesting()
+
+	typeLine("cafè")
+	press(keyboard.KeyLeft)
+	typeLine("é")
+	press(keyboard.KeyRight)
+	FeedRune('\b')
+	if got := string(lineBuf[:lineLen]); got != "café" || lineCursor != len("café") {
+		t.Fatalf("line = %q cursor %d", got, lineCursor)
+	}
+	if got := terminal.OutputForTesting(); got != "café" {
+		t.Fatalf("output = %q, want one cell per rune", got)
+	}
+
+	press(keyboard.KeyHome)
+	press(keyboard.KeyRight)
+	press(keyboard.KeyRight)
+	press(keyboard.KeyRight)
+	press(keyboard.KeyDelete)
+	if got := string(lineBuf[:lineLen]); got != "caf" || lineCursor != 3 {
+		t.Fatalf("line = %q cursor %d", got, lineCursor)
+	}
+	setLineBuf("")
+}
+
+func TestFeedRuneDropsC1Controls(t *testing.T) {
+	setLineBuf("")
+	FeedRune(0x85)
+	FeedRune(0x110000)
+	FeedRune('ß')
+	if got := string(lineBuf[:lineLen]); got != "ß" {
+		t.Fatalf("line = %q", got)
+	}
+	setLineBuf("")
+}
+
+func TestPasteDecodesUTF8(t *testing.T) {
+	setLineBuf("")
+	terminal.ResetOutputForTesting()
+
+	// A stray byte is kept as U+FFFD rather than split into runes
+	Paste([]byte("grüße\xFF"))
+	if got := string(lineBuf[:lineLen]); got != "grüße\uFFFD" {
+		t.Fatalf("line = %q", got)
+	}
+	setLineBuf("")
+}
+
+func TestFeedKeyIgnoresReleasesAndClampsCursor(t *testing.T) {
+	setLineBuf("ab")
+	terminal.ResetOutputForTesting()
+
+	FeedKey(keyboard.Event{Key: keyboard.KeySpace, Rune: 'x'})
+	press(keyboard.KeyRight)
+	press(keyboard.KeyHome)
+	press(keyboard.KeyLeft)
+	if lineLen != 2 || lineCursor != 0 {
+		t.Fatalf("len %d cursor %d, want 2 and 0", lineLen, lineCursor)
+	}
+	setLineBuf("")
+}
+
+func TestCtrlCCancelsLine(t *testing.T) {
+	setLineBuf("")
+	terminal.ResetOutputForTesting()
+
+	typeLine("rm notes")
+	press(keyboard.KeyHome)
+	FeedRune(ctrlC)
+	if lineLen != 0 || lineCursor != 0 {
+		t.Fatalf("line not dropped: len %d cursor %d", lineLen, lineCursor)
+	}
+	if got := terminal.OutputForTesting(); got != "rm notes^C\n"+prompt {
+		t.Fatalf("output = %q", got)
+	}
+}
+
+func resetHistory() {
+	historyBuf = [32][maxLine]byte{}
+	historyLen = [32]int{}
+	historyHead = 0
+	historyCount = 0
+	historyPos = 0
+}
+
+func TestUpDownRecallHistoryAndDraft(t *testing.T) {
+	resetHistory()
+	setLineBuf("")
+	typeLine("echo one\n")
+	typeLine("echo two\n")
+	typeLine("ec")
+	terminal.ResetOutputForTesting()
+
+	press(keyboard.KeyUp)
+	if got := string(lineBuf[:lineLen]); got != "echo two" || lineCursor != lineLen {
+		t.Fatalf("Up: line = %q cursor %d", got, lineCursor)
+	}
+	press(keyboard.KeyUp)
+	press(keyboard.KeyUp)
+	if got := string(lineBuf[:lineLen]); got != "echo one" {
+		t.Fatalf("Up past the oldest: line = %q", got)
+	}
+	press(keyboard.KeyDown)
+	press(keyboard.KeyDown)
+	if got := string(lineBuf[:lineLen]); got != "ec" {
+		t.Fatalf("Down back to the draft: line = %q", got)
+	}
+	if got := terminal.OutputForTesting(); got != "ec      " {
+		t.Fatalf("output = %q, want the draft over blanked cells", got)
+	}
+	setLineBuf("")
+}
+
+func TestCtrlKeysEditLine(t *testing.T) {
+	setLineBuf("")
+	terminal.ResetOutputForTesting()
+
+	typeLine("cat old notes")
+	FeedRune(ctrlW)
+	if got := string(lineBuf[:lineLen]); got != "cat old " {
+		t.Fatalf("Ctrl-W: line = %q", got)
+	}
+	FeedRune(ctrlA)
+	FeedRune(ctrlK)
+	if lineLen != 0 || terminal.OutputForTesting() != "             " {
+		t.Fatalf("Ctrl-A Ctrl-K: len %d output %q", lineLen, terminal.OutputForTesting())
+	}
+
+	typeLine("rm a.txt")
+	press(keyboard.KeyLeft)
+	FeedRune(ctrlU)
+	FeedRune(ctrlE)
+	if got := string(lineBuf[:lineLen]); got != "t" || lineCursor != 1 {
+		t.Fatalf("Ctrl-U Ctrl-E: line = %q cursor %d", got, lineCursor)
+	}
+	setLineBuf("")
+}
+
+func TestSerialEscapeSequencesMoveCursor(t *testing.T) {
+	resetHistory()
+	setLineBuf("")
+	typeLine("ls\n")
+	typeLine("ab")
+
+	for _, r := range "\x1b[D\x1b[Dx\x1b[3~" {
+		FeedRune(r)
+	}
+	if got := string(lineBuf[:lineLen]); got != "xb" || lineCursor != 1 {
+		t.Fatalf("line = %q cursor %d, want %q at 1", got, lineCursor, "xb")
+	}
+	for _, r := range "\x1b[A" {
+		FeedRune(r)
+	}
+	if got := string(lineBuf[:lineLen]); got != "ls" {
+		t.Fatalf("ESC [ A: line = %q", got)
+	}
+	setLineBuf("")
+}
+
+func TestTabCompletesCommand(t *testing.T) {
+	setLineBuf("")
+	terminal.ResetOutputForTesting()
+
+	typeLine("hist\t")
+	if got := string(lineBuf[:lineLen]); got != "history " {
+		t.Fatalf("line = %q, want %q", got, "history ")
+	}
+
+	setLineBuf("")
+	typeLine("fatc\t")
+	if got := string(lineBuf[:lineLen]); got != "fatcreate " {
+		t.Fatalf("line = %q", got)
+	}
+	setLineBuf("")
+}
+
+func TestTabExtendsAndListsCommonPrefix(t *testing.T) {
+	setLineBuf("")
+	typeLine("di\t")
+	if got := string(lineBuf[:lineLen]); got != "disk" {
+		t.Fatalf("line = %q, want the common prefix %q", got, "disk")
+	}
+
+	terminal.ResetOutputForTesting()
+	FeedRune('\t')
+	want := "\ndisk  diskinfo  diskbench\n" + prompt + "disk"
+	if got := terminal.OutputForTesting(); got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+	setLineBuf("")
+}
+
+func TestTabCompletesFileNames(t *testing.T) {
+	fs.Init()
+	fs.SetupMockPFA()
+	name := [16]byte{}
+	copy(name[:], "notes.txt")
+	data := []byte("x")
+	if !fs.Write(&name, len("notes.txt"), &data[0], 1) {
+		t.Fatalf("failed to write test file")
+	}
+	fat16.NamesForTesting = []string{"README.TXT", "NOTES.BAK"}
+	t.Cleanup(func() { fat16.NamesForTesting = nil })
+
+	setLineBuf("")
+	typeLine("fatread rea\t")
+	if got := string(lineBuf[:lineLen]); got != "fatread README.TXT " {
+		t.Fatalf("line = %q", got)
+	}
+
+	setLineBuf("")
+	typeLine("cat no\t")
+	if got := string(lineBuf[:lineLen]); got != "cat notes." {
+		t.Fatalf("line = %q, want the shared %q", got, "notes.")
+	}
+	setLineBuf("")
+}
+
+func TestTabSuggestsCloseCommands(t *testing.T) {
+	setLineBuf("")
+	typeLine("lsbk")
+	terminal.ResetOutputForTesting()
+
+	FeedRune('\t')
+	if got := string(lineBuf[:lineLen]); got != "lsbk" {
+		t.Fatalf("line = %q, want it untouched", got)
+	}
+	if got := terminal.OutputForTesting(); !strings.Contains(got, "Did you mean 'ls lsblk'?\n"+prompt+"lsbk") {
+		t.Fatalf("output = %q", got)
+	}
+	setLineBuf("")
+}
+
+func altF(n int) keyboard.Event {
+	return keyboard.Event{Key: keyboard.KeyF1 + keyboard.Keycode(n), Mods: keyboard.ModAlt, Pressed: true}
+}
+
+func TestAltFKeysSwitchSessions(t *testing.T) {
+	// The kernel greets on the first console at boot
+	started[0] = true
+	resetHistory()
+	setLineBuf("")
+	typeLine("ls\n")
+	terminal.ResetOutputForTesting()
+	typeLine("cat no")
+
+	FeedKey(altF(1))
+	if terminal.ActiveConsole() != 1 || activeSession != 1 || terminal.OutputConsole() != 1 {
+		t.Fatalf("console %d, session %d, output %d after Alt+F2", terminal.ActiveConsole(), activeSession, terminal.OutputConsole())
+	}
+	if !strings.Contains(terminal.OutputForTesting(), "Welcome") || lineLen != 0 || historyCount != 0 {
+		t.Fatalf("a new session should start empty with a greeting: line %d history %d", lineLen, historyCount)
+	}
+	typeLine("date")
+
+	// Back on the first console the line and history are as they were left
+	FeedKey(altF(0))
+	if got := string(lineBuf[:lineLen]); got != "cat no" || lineCursor != lineLen {
+		t.Fatalf("line = %q cursor %d", got, lineCursor)
+	}
+	if historyCount != 1 || string(historyBuf[0][:historyLen[0]]) != "ls" {
+		t.Fatalf("history lost: %d entries", historyCount)
+	}
+
+	terminal.ResetOutputForTesting()
+	FeedKey(altF(1))
+	if got := string(lineBuf[:lineLen]); got != "date" || strings.Contains(terminal.OutputForTesting(), "Welcome") {
+		t.Fatalf("the second session should resume: line %q", got)
+	}
+
+	// F-keys beyond the consoles and without Alt are left alone
+	FeedKey(altF(terminal.Consoles))
+	FeedKey(keyboard.Event{Key: keyboard.KeyF1, Pressed: true})
+	if activeSession != 1 {
+		t.Fatalf("session %d", activeSession)
+	}
+	FeedKey(altF(0))
+	sessions[1], started[1] = session{}, false
+	setLineBuf("")
+	resetHistory()
+}
+
+func TestLayoutListShowsBuiltinsAndDiskKeymaps(t *testing.T) {
+	fat16.NamesForTesting = []string{"README.TXT", "SV.KMP", "NOTES.KMP"}
+	t.Cleanup(func() { fat16.NamesForTesting = nil })
+	terminal.ResetOutputForTesting()
+
+	setLineBuf("layout list")
+	execute()
+	want := "built-in: us it de fr es uk dvorak\non disk: sv notes\n"
+	if got := terminal.OutputForTesting(); got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestLayoutSwitchUsesCanonicalName(t *testing.T) {
+	var asked string
+	SetLayoutSwitcher(func(name string) (string, bool) {
+		asked = name
+		if name == "DE" {
+			return "de", true
+		}
+		return "", false
+	})
+	t.Cleanup(func() {
+		SetLayoutSwitcher(nil)
+		SetInitialLayout("it")
+	})
+
+	terminal.ResetOutputForTesting()
+	setLineBuf("layout DE")
+	execute()
+	if asked != "DE" || currentLayout != "de" {
+		t.Fatalf("asked %q, current layout %q", asked, currentLayout)
+	}
+	if got := terminal.OutputForTesting(); got != "layout: switched to de\n" {
+		t.Fatalf("output = %q", got)
+	}
+
+	terminal.ResetOutputForTesting()
+	setLineBuf("layout xx")
+	execute()
+	if got := terminal.OutputForTesting(); got != "layout: no layout xx, see layout list\n" || currentLayout != "de" {
+		t.Fatalf("output = %q, layout %q", got, currentLayout)
+	}
+}
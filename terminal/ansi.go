@@ -0,0 +1,270 @@
+package terminal
+
+// The console understands the VT100/ANSI escape sequences programs use for
+// colour and cursor placement, so the same output looks right here and on a
+// host terminal following the serial mirror:
+//
+//	ESC [ <n;...> m    SGR: 0 reset, 1 bold, 22 normal, 7 reverse,
+//	                   27 not reversed, 30-37/90-97 foreground,
+//	                   39 default, 40-47/100-107 background, 49 default
+//	ESC [ <row;col> H  CUP, also f; 1-based, missing means 1
+//	ESC [ <n> A/B/C/D  cursor up, down, forward, back
+//	ESC [ <n> J        erase in display: 0 to the end, 1 from the start, 2 all
+//	ESC [ <n> K        erase in line, with the same arguments
+//	ESC [ s, ESC 7     save the cursor and attributes
+//	ESC [ u, ESC 8     restore them
+//
+// Anything else that parses as a sequence is swallowed.
+
+const (
+	ansiGround = iota
+	ansiEscape // after ESC
+	ansiCSI    // after ESC [
+
+	escapeRune    = 0x1B
+	maxANSIParams = 8
+
+	// Light grey on black
+	defaultFg   = 0x07
+	defaultBg   = 0x00
+	defaultAttr = defaultFg | defaultBg<<4
+)
+
+// ansiColors maps the ANSI colour order to the VGA palette
+var ansiColors = [8]byte{0, 4, 2, 6, 1, 5, 3, 7}
+
+// graphics is the SGR state the text attribute is built from
+type graphics struct {
+	fg, bg  byte
+	bold    bool
+	reverse bool
+}
+
+var defaultGraphics = graphics{fg: defaultFg, bg: defaultBg}
+
+// ansiParser is the escape sequence state of a console
+type ansiParser struct {
+	state   int
+	params  [maxANSIParams]int
+	count   int  // parameters seen, the one being read included
+	private bool // the sequence started with '?' or another marker
+
+	sgr graphics
+
+	// What ESC 7 or CSI s saved
+	savedRow int
+	savedCol int
+	savedSGR graphics
+}
+
+var (
+	firstParser ansiParser
+
+	// ansi is the parser of the console being written to, firstParser
+	// until the consoles are set up
+	ansi = &firstParser
+)
+
+// ansiReset drops any sequence in progress and goes back to the default
+// colours
+func ansiReset() {
+	ansi.state = ansiGround
+	ansi.sgr, ansi.savedSGR = defaultGraphics, defaultGraphics
+	ansi.savedRow, ansi.savedCol = 0, 0
+	setTextAttr(defaultAttr)
+}
+
+// ansiFeed passes r through the escape sequence parser. It reports whether
+// r was taken as part of a sequence; if not, it is text to write.
+func ansiFeed(r rune) bool {
+	switch ansi.state {
+	case ansiEscape:
+		ansi.state = ansiGround
+		switch r {
+		case '[':
+			ansi.state = ansiCSI
+			ansi.count, ansi.private = 0, false
+			ansi.params[0] = 0
+		case '7':
+			saveCursor()
+		case '8':
+			restoreCursor()
+		case escapeRune:
+			ansi.state = ansiEscape
+		}
+		return true
+	case ansiCSI:
+		return csiFeed(r)
+	}
+	if r == escapeRune {
+		ansi.state = ansiEscape
+		return true
+	}
+	return false
+}
+
+func csiFeed(r rune) bool {
+	switch {
+	case r >= '0' && r <= '9':
+		if ansi.count == 0 {
+			ansi.count = 1
+		}
+		p := &ansi.params[ansi.count-1]
+		if *p < 10000 {
+			*p = *p*10 + int(r-'0')
+		}
+	case r == ';':
+		if ansi.count == 0 {
+			ansi.count = 1
+		}
+		if ansi.count < maxANSIParams {
+			ansi.params[ansi.count] = 0
+			ansi.count++
+		}
+	case r >= '<' && r <= '?':
+		ansi.private = true
+	case r >= 0x20 && r <= 0x2F:
+		// Intermediate bytes do not change the sequences handled here
+	case r >= 0x40 && r <= 0x7E:
+		ansi.state = ansiGround
+		if !ansi.private {
+			csiDispatch(r)
+		}
+	case r == escapeRune:
+		ansi.state = ansiEscape
+	default:
+		// A control character cuts the sequence short and is written
+		ansi.state = ansiGround
+		return false
+	}
+	return true
+}
+
+// ansiParam returns parameter i, or def when it is missing or zero
+func ansiParam(i, def int) int {
+	if i >= ansi.count || ansi.params[i] == 0 {
+		return def
+	}
+	return ansi.params[i]
+}
+
+func csiDispatch(final rune) {
+	r, c := cursorAt()
+	switch final {
+	case 'm':
+		selectGraphics()
+	case 'H', 'f':
+		moveCursorTo(clampCell(ansiParam(0, 1)-1, rows), clampCell(ansiParam(1, 1)-1, cols))
+	case 'A':
+		moveCursorTo(clampCell(r-ansiParam(0, 1), rows), c)
+	case 'B':
+		moveCursorTo(clampCell(r+ansiParam(0, 1), rows), c)
+	case 'C':
+		moveCursorTo(r, clampCell(c+ansiParam(0, 1), cols))
+	case 'D':
+		moveCursorTo(r, clampCell(c-ansiParam(0, 1), cols))
+	case 'J':
+		switch ansiParam(0, 0) {
+		case 0:
+			eraseCells(r*cols+c, rows*cols)
+		case 1:
+			eraseCells(0, r*cols+c+1)
+		case 2, 3:
+			eraseCells(0, rows*cols)
+		}
+	case 'K':
+		switch ansiParam(0, 0) {
+		case 0:
+			eraseCells(r*cols+c, (r+1)*cols)
+		case 1:
+			eraseCells(r*cols, r*cols+c+1)
+		case 2:
+			eraseCells(r*cols, (r+1)*cols)
+		}
+	case 's':
+		saveCursor()
+	case 'u':
+		restoreCursor()
+	}
+}
+
+// selectGraphics applies an SGR sequence; no parameters means reset
+func selectGraphics() {
+	if ansi.count == 0 {
+		ansi.count, ansi.params[0] = 1, 0
+	}
+	for i := 0; i < ansi.count; i++ {
+		p := ansi.params[i]
+		switch {
+		case p == 0:
+			ansi.sgr = defaultGraphics
+		case p == 1:
+			ansi.sgr.bold = true
+		case p == 22:
+			ansi.sgr.bold = false
+		case p == 7:
+			ansi.sgr.reverse = true
+		case p == 27:
+			ansi.sgr.reverse = false
+		case p >= 30 && p <= 37:
+			ansi.sgr.fg = ansiColors[p-30]
+		case p == 39:
+			ansi.sgr.fg = defaultFg
+		case p >= 90 && p <= 97:
+			ansi.sgr.fg = ansiColors[p-90] | 0x08
+		case p >= 40 && p <= 47:
+			ansi.sgr.bg = ansiColors[p-40]
+		case p >= 100 && p <= 107:
+			// The top attribute bit blinks in VGA text mode, so bright
+			// backgrounds stay plain
+			ansi.sgr.bg = ansiColors[p-100]
+		case p == 49:
+			ansi.sgr.bg = defaultBg
+		}
+	}
+	setTextAttr(ansi.sgr.attr())
+}
+
+// attr builds the VGA attribute byte
+func (g graphics) attr() byte {
+	fg, bg := g.fg, g.bg
+	if g.bold {
+		fg |= 0x08
+	}
+	if g.reverse {
+		fg, bg = bg, fg&0x07
+	}
+	return makeColor(fg, bg)
+}
+
+func makeColor(fg, bg byte) byte {
+	return fg | (bg << 4)
+}
+
+func saveCursor() {
+	ansi.savedRow, ansi.savedCol = cursorAt()
+	ansi.savedSGR = ansi.sgr
+}
+
+func restoreCursor() {
+	moveCursorTo(clampCell(ansi.savedRow, rows), clampCell(ansi.savedCol, cols))
+	ansi.sgr = ansi.savedSGR
+	setTextAttr(ansi.sgr.attr())
+}
+
+// eraseCells blanks the cells from linear index from up to to
+func eraseCells(from, to int) {
+	for i := from; i < to; i++ {
+		eraseCell(i/cols, i%cols)
+	}
+}
+
+func clampCell(v, limit int) int {
+	if v < 0 {
+		return 0
+	}
+	if v >= limit {
+		return limit - 1
+	}
+	return v
+}
@@ -0,0 +1,151 @@
+//go:build testing
+
+package terminal
+
+import "testing"
+
+func feed(s string) {
+	for _, r := range s {
+		PutRune(r)
+	}
+}
+
+func TestEscapeSequencesAreNotWritten(t *testing.T) {
+	Init()
+	feed("a\x1b[1;31mb\x1b[0mc\x1b[?25ld\x1b7e")
+	if got := OutputForTesting(); got != "abcde" {
+		t.Fatalf("output = %q", got)
+	}
+}
+
+func TestSelectGraphicsSetsAttribute(t *testing.T) {
+	cases := []struct {
+		seq  string
+		attr byte
+	}{
+		{"\x1b[31m", 0x04},
+		{"\x1b[1;32m", 0x0A},
+		{"\x1b[94;43m", 0x69},
+		{"\x1b[34;47;7m", 0x17},
+		{"\x1b[103m", 0x67},
+		{"\x1b[31;44m\x1b[39m", 0x17},
+		{"\x1b[31;44m\x1b[49;22m", 0x04},
+		{"\x1b[1;31m\x1b[m", defaultAttr},
+		{"\x1b[7m\x1b[27m", defaultAttr},
+	}
+	for _, c := range cases {
+		Init()
+		feed(c.seq)
+		if textColor != c.attr {
+			t.Fatalf("%q: attribute %#x, want %#x", c.seq, textColor, c.attr)
+		}
+	}
+}
+
+func TestCursorSequencesMoveAndClamp(t *testing.T) {
+	Init()
+	moves := []struct {
+		seq      string
+		row, col int
+	}{
+		{"\x1b[5;10H", 4, 9},
+		{"\x1b[2A", 2, 9},
+		{"\x1b[B", 3, 9},
+		{"\x1b[3C", 3, 12},
+		{"\x1b[20D", 3, 0},
+		{"\x1b[99;99f", VGAHeight - 1, VGAWidth - 1},
+		{"\x1b[H", 0, 0},
+		{"\x1b[0A", 0, 0},
+		{"\x1b[;7H", 0, 6},
+	}
+	for _, m := range moves {
+		feed(m.seq)
+		if cursorRow != m.row || cursorCol != m.col {
+			t.Fatalf("%q: cursor %d,%d, want %d,%d", m.seq, cursorRow, cursorCol, m.row, m.col)
+		}
+	}
+}
+
+func TestSaveAndRestoreCursor(t *testing.T) {
+	Init()
+	feed("\x1b[3;4H\x1b[32m\x1b7\x1b[10;10H\x1b[0m\x1b8")
+	if cursorRow != 2 || cursorCol != 3 || textColor != 0x02 {
+		t.Fatalf("ESC 8: cursor %d,%d attr %#x", cursorRow, cursorCol, textColor)
+	}
+	feed("\x1b[5;6H\x1b[s\x1b[H\x1b[u")
+	if cursorRow != 4 || cursorCol != 5 {
+		t.Fatalf("CSI u: cursor %d,%d", cursorRow, cursorCol)
+	}
+}
+
+func filled() {
+	for r := 0; r < VGAHeight; r++ {
+		for c := 0; c < VGAWidth; c++ {
+			vgaBuffer[r][c] = 'x' | defaultAttr<<8
+		}
+	}
+}
+
+func TestEraseInLine(t *testing.T) {
+	cases := []struct {
+		seq      string
+		from, to int // erased columns of row 1
+	}{
+		{"\x1b[K", 5, VGAWidth},
+		{"\x1b[1K", 0, 6},
+		{"\x1b[2K", 0, VGAWidth},
+	}
+	for _, c := range cases {
+		Init()
+		filled()
+		feed("\x1b[44m\x1b[2;6H" + c.seq)
+		for col := 0; col < VGAWidth; col++ {
+			want := uint16('x') | defaultAttr<<8
+			if col >= c.from && col < c.to {
+				want = ' ' | 0x17<<8
+			}
+			if vgaBuffer[1][col] != want {
+				t.Fatalf("%q: column %d = %#x, want %#x", c.seq, col, vgaBuffer[1][col], want)
+			}
+		}
+		if vgaBuffer[0][VGAWidth-1] != 'x'|defaultAttr<<8 || vgaBuffer[2][0] != 'x'|defaultAttr<<8 {
+			t.Fatalf("%q: erased another row", c.seq)
+		}
+	}
+}
+
+func TestEraseInDisplay(t *testing.T) {
+	cases := []struct {
+		seq      string
+		from, to int // erased linear cells
+	}{
+		{"\x1b[J", VGAWidth + 5, VGAHeight * VGAWidth},
+		{"\x1b[1J", 0, VGAWidth + 6},
+		{"\x1b[2J", 0, VGAHeight * VGAWidth},
+	}
+	for _, c := range cases {
+		Init()
+		filled()
+		feed("\x1b[2;6H" + c.seq)
+		for i := 0; i < VGAHeight*VGAWidth; i++ {
+			blank := vgaBuffer[i/VGAWidth][i%VGAWidth]&0xFF == ' '
+			if blank != (i >= c.from && i < c.to) {
+				t.Fatalf("%q: cell %d blank = %v", c.seq, i, blank)
+			}
+		}
+		if cursorRow != 1 || cursorCol != 5 {
+			t.Fatalf("%q: erasing moved the cursor", c.seq)
+		}
+	}
+}
+
+func TestControlCharacterCutsSequenceShort(t *testing.T) {
+	Init()
+	feed("\x1b[31\nok\x1b[2J")
+	if got := OutputForTesting(); got != "\nok" {
+		t.Fatalf("output = %q", got)
+	}
+	if textColor != defaultAttr {
+		t.Fatalf("the cut sequence still set %#x", textColor)
+	}
+}
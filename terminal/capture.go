@@ -0,0 +1,58 @@
+package terminal
+
+// CaptureSize is how much output a Capture holds, a RAM fs page
+const CaptureSize = 4096
+
+// Capture collects output in place of the screen, for the shell to send it
+// to a file or into the next command of a pipe
+type Capture struct {
+	buf       [CaptureSize]byte
+	n         int
+	truncated bool
+}
+
+// StartCapture empties c and sends the running task's output into it
+// until StopCapture. It returns the capture it replaces, for StopCapture
+// to go back to.
+func StartCapture(c *Capture) *Capture {
+	c.n = 0
+	c.truncated = false
+	prev := out.capture
+	out.capture = c
+	return prev
+}
+
+// StopCapture sends output back to prev, the console when it is nil
+func StopCapture(prev *Capture) {
+	out.capture = prev
+}
+
+// Bytes is the output collected, valid until the capture starts again
+func (c *Capture) Bytes() []byte {
+	return c.buf[:c.n]
+}
+
+// Truncated reports whether output was dropped for lack of room
+func (c *Capture) Truncated() bool {
+	return c.truncated
+}
+
+// captureRune stores r when output is being captured, reporting whether
+// it took r. Once a rune does not fit, the rest is dropped too.
+func captureRune(r rune) bool {
+	c := out.capture
+	if c == nil {
+		return false
+	}
+	var enc [4]byte
+	n := EncodeRune(r, &enc)
+	if c.truncated || c.n+n > CaptureSize {
+		c.truncated = true
+		return true
+	}
+	for i := 0; i < n; i++ {
+		c.buf[c.n] = enc[i]
+		c.n++
+	}
+	return true
+}
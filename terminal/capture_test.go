@@ -0,0 +1,68 @@
+//go:build testing
+
+package terminal
+
+import "testing"
+
+func TestCaptureTakesOutputFromTheScreen(t *testing.T) {
+	Init()
+	var outer, inner Capture
+	screen := StartCapture(&outer)
+	Print("a€")
+	prev := StartCapture(&inner)
+	Print("b")
+	StopCapture(prev)
+	PutRune('\n')
+	StopCapture(screen)
+	Print("c")
+
+	if got := string(outer.Bytes()); got != "a€\n" {
+		t.Fatalf("outer capture = %q", got)
+	}
+	if got := string(inner.Bytes()); got != "b" {
+		t.Fatalf("inner capture = %q", got)
+	}
+	if got := OutputForTesting(); got != "c" {
+		t.Fatalf("screen = %q", got)
+	}
+}
+
+func TestCaptureStopsWhenFull(t *testing.T) {
+	Init()
+	var c Capture
+	screen := StartCapture(&c)
+	for i := 0; i < CaptureSize-1; i++ {
+		PutRune('x')
+	}
+	PutRune('é')
+	PutRune('y')
+	StopCapture(screen)
+	if len(c.Bytes()) != CaptureSize-1 || !c.Truncated() {
+		t.Fatalf("captured %d bytes, truncated %v", len(c.Bytes()), c.Truncated())
+	}
+}
+
+func TestCaptureBelongsToItsOutput(t *testing.T) {
+	Init()
+	var task Output
+	task.Reset(2)
+	var c Capture
+	StartCapture(&c)
+	OutputTo(1)
+
+	SetOutput(&task)
+	Print("task")
+	if OutputConsole() != 2 {
+		t.Fatalf("task output on console %d", OutputConsole())
+	}
+	SetOutput(MainOutput())
+	Print("main")
+	StopCapture(nil)
+
+	if got := string(c.Bytes()); got != "main" {
+		t.Fatalf("capture = %q", got)
+	}
+	if got := OutputForTesting(); got != "task" || OutputConsole() != 1 {
+		t.Fatalf("screen = %q, main output on console %d", got, OutputConsole())
+	}
+}
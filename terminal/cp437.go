@@ -0,0 +1,72 @@
+package terminal
+
+// The console stores one code page 437 byte per cell, the character set of
+// the VGA text mode font, and the framebuffer console draws the same bytes.
+// Runes are translated on the way in and back to Unicode for the clipboard
+// and the serial mirror.
+
+// cp437High is the Unicode character of each byte from 0x80 up
+var cp437High = [128]uint16{
+	0x00C7, 0x00FC, 0x00E9, 0x00E2, 0x00E4, 0x00E0, 0x00E5, 0x00E7,
+	0x00EA, 0x00EB, 0x00E8, 0x00EF, 0x00EE, 0x00EC, 0x00C4, 0x00C5,
+	0x00C9, 0x00E6, 0x00C6, 0x00F4, 0x00F6, 0x00F2, 0x00FB, 0x00F9,
+	0x00FF, 0x00D6, 0x00DC, 0x00A2, 0x00A3, 0x00A5, 0x20A7, 0x0192,
+	0x00E1, 0x00ED, 0x00F3, 0x00FA, 0x00F1, 0x00D1, 0x00AA, 0x00BA,
+	0x00BF, 0x2310, 0x00AC, 0x00BD, 0x00BC, 0x00A1, 0x00AB, 0x00BB,
+	0x2591, 0x2592, 0x2593, 0x2502, 0x2524, 0x2561, 0x2562, 0x2556,
+	0x2555, 0x2563, 0x2551, 0x2557, 0x255D, 0x255C, 0x255B, 0x2510,
+	0x2514, 0x2534, 0x252C, 0x251C, 0x2500, 0x253C, 0x255E, 0x255F,
+	0x255A, 0x2554, 0x2569, 0x2566, 0x2560, 0x2550, 0x256C, 0x2567,
+	0x2568, 0x2564, 0x2565, 0x2559, 0x2558, 0x2552, 0x2553, 0x256B,
+	0x256A, 0x2518, 0x250C, 0x2588, 0x2584, 0x258C, 0x2590, 0x2580,
+	0x03B1, 0x00DF, 0x0393, 0x03C0, 0x03A3, 0x03C3, 0x00B5, 0x03C4,
+	0x03A6, 0x0398, 0x03A9, 0x03B4, 0x221E, 0x03C6, 0x03B5, 0x2229,
+	0x2261, 0x00B1, 0x2265, 0x2264, 0x2320, 0x2321, 0x00F7, 0x2248,
+	0x00B0, 0x2219, 0x00B7, 0x221A, 0x207F, 0x00B2, 0x25A0, 0x00A0,
+}
+
+// Pilcrow and section sign sit among the control codes
+const (
+	cp437Pilcrow = 0x14
+	cp437Section = 0x15
+)
+
+// latin1Base is the unaccented letter for U+00C0..U+00FF, for the accented
+// letters code page 437 lacks; '?' marks the non-letters
+const latin1Base = "AAAAAA?CEEEEIIII?NOOOOO??UUUUY??aaaaaa?ceeeeiiii?nooooo??uuuuy?y"
+
+// toCP437 is the code page 437 byte that shows r, '?' when there is none.
+// ASCII, control characters included, maps to itself.
+func toCP437(r rune) byte {
+	if r >= 0 && r < 0x80 {
+		return byte(r)
+	}
+	switch r {
+	case 0xB6:
+		return cp437Pilcrow
+	case 0xA7:
+		return cp437Section
+	}
+	for i := 0; i < len(cp437High); i++ {
+		if rune(cp437High[i]) == r {
+			return byte(0x80 + i)
+		}
+	}
+	if r >= 0xC0 && r <= 0xFF {
+		return latin1Base[r-0xC0]
+	}
+	return '?'
+}
+
+// cp437Rune is the Unicode character a code page 437 byte shows
+func cp437Rune(b byte) rune {
+	switch {
+	case b >= 0x80:
+		return rune(cp437High[b-0x80])
+	case b == cp437Pilcrow:
+		return 0xB6
+	case b == cp437Section:
+		return 0xA7
+	}
+	return rune(b)
+}
@@ -0,0 +1,67 @@
+package terminal
+
+import "testing"
+
+func TestToCP437(t *testing.T) {
+	cases := []struct {
+		r    rune
+		want byte
+	}{
+		{'A', 'A'},
+		{'é', 0x82},
+		{'Ä', 0x8E},
+		{'ñ', 0xA4},
+		{'£', 0x9C},
+		{'§', cp437Section},
+		{'░', 0xB0},
+		{'€', '?'},
+		{'Ã', 'A'},
+		{'ő', '?'},
+	}
+	for _, c := range cases {
+		if got := toCP437(c.r); got != c.want {
+			t.Fatalf("toCP437(%q) = %#x, want %#x", c.r, got, c.want)
+		}
+	}
+}
+
+func TestCP437RoundTrip(t *testing.T) {
+	for b := 0x80; b < 0x100; b++ {
+		r := cp437Rune(byte(b))
+		if got := toCP437(r); got != byte(b) {
+			t.Fatalf("byte %#x -> %q -> %#x", b, r, got)
+		}
+	}
+}
+
+func TestDecodeRune(t *testing.T) {
+	cases := []struct {
+		s    string
+		r    rune
+		size int
+	}{
+		{"a", 'a', 1},
+		{"é!", 'é', 2},
+		{"€", '€', 3},
+		{"😀", 0x1F600, 4},
+		{"\xC3", 0xFFFD, 1},
+		{"\xC3(", 0xFFFD, 1},
+		{"\xFF", 0xFFFD, 1},
+	}
+	for _, c := range cases {
+		r, size := DecodeRune(c.s)
+		if r != c.r || size != c.size {
+			t.Fatalf("DecodeRune(%q) = %q, %d", c.s, r, size)
+		}
+	}
+}
+
+func TestEncodeRuneMatchesGo(t *testing.T) {
+	var buf [4]byte
+	for _, r := range []rune{'a', 'é', '€', 0x1F600} {
+		n := EncodeRune(r, &buf)
+		if got := string(buf[:n]); got != string(r) {
+			t.Fatalf("EncodeRune(%q) = %q", r, got)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package terminal
+
+// The framebuffer font covers the upper half of code page 437 in part: the
+// accented letters are drawn as their ASCII letter with an accent mark on
+// top, or a cedilla below, and a few symbols have glyphs of their own.
+// Anything else is drawn as a box.
+
+// Accent marks, indexed by the accent of cp437Letters
+const (
+	accentNone = iota
+	accentGrave
+	accentAcute
+	accentCircumflex
+	accentDiaeresis
+	accentTilde
+	accentRing
+	accentCedilla
+)
+
+// accentMarks holds the two pixel rows of each mark, drawn above the
+// letter, and for the cedilla the one row drawn below it
+var accentMarks = [...][2]byte{
+	accentGrave:      {0x20, 0x10},
+	accentAcute:      {0x08, 0x10},
+	accentCircumflex: {0x10, 0x28},
+	accentDiaeresis:  {0x00, 0x28},
+	accentTilde:      {0x34, 0x48},
+	accentRing:       {0x38, 0x28},
+	accentCedilla:    {0x18, 0x00},
+}
+
+// cp437Letters gives the ASCII letter and accent of bytes 0x80..0xA5, a
+// zero letter for the symbols among them
+var cp437Letters = [...][2]byte{
+	{'C', accentCedilla},    // Ç
+	{'u', accentDiaeresis},  // ü
+	{'e', accentAcute},      // é
+	{'a', accentCircumflex}, // â
+	{'a', accentDiaeresis},  // ä
+	{'a', accentGrave},      // à
+	{'a', accentRing},       // å
+	{'c', accentCedilla},    // ç
+	{'e', accentCircumflex}, // ê
+	{'e', accentDiaeresis},  // ë
+	{'e', accentGrave},      // è
+	{'i', accentDiaeresis},  // ï
+	{'i', accentCircumflex}, // î
+	{'i', accentGrave},      // ì
+	{'A', accentDiaeresis},  // Ä
+	{'A', accentRing},       // Å
+	{'E', accentAcute},      // É
+	{},                      // æ
+	{},                      // Æ
+	{'o', accentCircumflex}, // ô
+	{'o', accentDiaeresis},  // ö
+	{'o', accentGrave},      // ò
+	{'u', accentCircumflex}, // û
+	{'u', accentGrave},      // ù
+	{'y', accentDiaeresis},  // ÿ
+	{'O', accentDiaeresis},  // Ö
+	{'U', accentDiaeresis},  // Ü
+	{},                      // ¢
+	{},                      // £
+	{},                      // ¥
+	{},                      // ₧
+	{},                      // ƒ
+	{'a', accentAcute},      // á
+	{'i', accentAcute},      // í
+	{'o', accentAcute},      // ó
+	{'u', accentAcute},      // ú
+	{'n', accentTilde},      // ñ
+	{'N', accentTilde},      // Ñ
+}
+
+// cp437Glyphs are the symbols with glyphs of their own, in the layout of
+// font
+var cp437Glyphs = [...]struct {
+	ch   byte
+	rows [8]byte
+}{
+	{0x14, [8]byte{0x3C, 0x68, 0x68, 0x34, 0x08, 0x08, 0x08, 0x00}}, // ¶
+	{0x15, [8]byte{0x38, 0x40, 0x38, 0x44, 0x38, 0x04, 0x38, 0x00}}, // §
+	{0x91, [8]byte{0x00, 0x00, 0x68, 0x14, 0x3C, 0x50, 0x2C, 0x00}}, // æ
+	{0x92, [8]byte{0x3C, 0x50, 0x50, 0x7C, 0x50, 0x50, 0x5C, 0x00}}, // Æ
+	{0x9B, [8]byte{0x10, 0x38, 0x50, 0x50, 0x50, 0x38, 0x10, 0x00}}, // ¢
+	{0x9C, [8]byte{0x18, 0x24, 0x20, 0x70, 0x20, 0x24, 0x58, 0x00}}, // £
+	{0x9D, [8]byte{0x44, 0x28, 0x7C, 0x10, 0x7C, 0x10, 0x10, 0x00}}, // ¥
+	{0xA6, [8]byte{0x38, 0x04, 0x3C, 0x44, 0x3C, 0x00, 0x7C, 0x00}}, // ª
+	{0xA7, [8]byte{0x38, 0x44, 0x44, 0x44, 0x38, 0x00, 0x7C, 0x00}}, // º
+	{0xA8, [8]byte{0x10, 0x00, 0x10, 0x20, 0x40, 0x44, 0x38, 0x00}}, // ¿
+	{0xAA, [8]byte{0x00, 0x00, 0x7C, 0x04, 0x04, 0x00, 0x00, 0x00}}, // ¬
+	{0xAD, [8]byte{0x10, 0x00, 0x10, 0x10, 0x10, 0x10, 0x10, 0x00}}, // ¡
+	{0xAE, [8]byte{0x00, 0x14, 0x28, 0x50, 0x28, 0x14, 0x00, 0x00}}, // «
+	{0xAF, [8]byte{0x00, 0x50, 0x28, 0x14, 0x28, 0x50, 0x00, 0x00}}, // »
+	{0xE1, [8]byte{0x30, 0x48, 0x48, 0x70, 0x48, 0x48, 0x70, 0x40}}, // ß
+	{0xE6, [8]byte{0x00, 0x00, 0x44, 0x44, 0x44, 0x6C, 0x54, 0x40}}, // µ
+	{0xF1, [8]byte{0x10, 0x10, 0x7C, 0x10, 0x10, 0x00, 0x7C, 0x00}}, // ±
+	{0xF6, [8]byte{0x00, 0x10, 0x00, 0x7C, 0x00, 0x10, 0x00, 0x00}}, // ÷
+	{0xF8, [8]byte{0x30, 0x48, 0x48, 0x30, 0x00, 0x00, 0x00, 0x00}}, // °
+	{0xF9, [8]byte{0x00, 0x00, 0x00, 0x30, 0x30, 0x00, 0x00, 0x00}}, // ∙
+	{0xFA, [8]byte{0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00}}, // ·
+	{0xFD, [8]byte{0x30, 0x48, 0x10, 0x20, 0x78, 0x00, 0x00, 0x00}}, // ²
+	{0xFE, [8]byte{0x00, 0x38, 0x38, 0x38, 0x38, 0x38, 0x00, 0x00}}, // ■
+}
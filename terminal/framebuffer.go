@@ -0,0 +1,177 @@
+package terminal
+
+import "unsafe"
+
+// Framebuffer describes a linear RGB framebuffer as handed over by the boot
+// loader. The channel positions and sizes are in bits within a pixel.
+type Framebuffer struct {
+	Addr   uintptr
+	Pitch  uint32 // bytes per scanline
+	Width  uint32 // pixels
+	Height uint32
+	BPP    uint8
+
+	RedPos, RedSize     uint8
+	GreenPos, GreenSize uint8
+	BluePos, BlueSize   uint8
+}
+
+const (
+	glyphWidth  = 8
+	glyphHeight = 16
+
+	fontFirst  = 0x20
+	fontGlyphs = 0x7F - fontFirst
+
+	// The cursor is an underline over the last two pixel rows of its cell
+	cursorHeight = 2
+)
+
+// vgaPalette is the RGB value of each of the 16 text-mode colours
+var vgaPalette = [16]uint32{
+	0x000000, 0x0000AA, 0x00AA00, 0x00AAAA, 0xAA0000, 0xAA00AA, 0xAA5500, 0xAAAAAA,
+	0x555555, 0x5555FF, 0x55FF55, 0x55FFFF, 0xFF5555, 0xFF55FF, 0xFFFF55, 0xFFFFFF,
+}
+
+var (
+	fb        Framebuffer
+	fbPalette [16]uint32 // vgaPalette packed in the framebuffer's pixel format
+)
+
+// fbUsable reports whether the console can draw on f: a direct-colour
+// layout of 16, 24 or 32 bits deep with room for the 80x25 text screen
+func fbUsable(f *Framebuffer) bool {
+	if f.Addr == 0 || f.BPP != 16 && f.BPP != 24 && f.BPP != 32 {
+		return false
+	}
+	if f.RedSize == 0 || f.GreenSize == 0 || f.BlueSize == 0 {
+		return false
+	}
+	if f.Pitch < f.Width*uint32(f.BPP/8) {
+		return false
+	}
+	return f.Width >= VGAWidth*glyphWidth && f.Height >= VGAHeight*glyphHeight
+}
+
+// fbSetup adopts f and returns its size in cells, capped by the back buffer
+func fbSetup(f *Framebuffer) (columns, lines int) {
+	fb = *f
+	for i := 0; i < 16; i++ {
+		c := vgaPalette[i]
+		fbPalette[i] = packColor(byte(c>>16), byte(c>>8), byte(c))
+	}
+	columns = int(fb.Width / glyphWidth)
+	if columns > MaxColumns {
+		columns = MaxColumns
+	}
+	lines = int(fb.Height / glyphHeight)
+	if lines > MaxRows {
+		lines = MaxRows
+	}
+	return columns, lines
+}
+
+// packColor scales 8-bit channels down to the framebuffer's channel sizes
+func packColor(r, g, b byte) uint32 {
+	return uint32(r)>>(8-fb.RedSize)<<fb.RedPos |
+		uint32(g)>>(8-fb.GreenSize)<<fb.GreenPos |
+		uint32(b)>>(8-fb.BlueSize)<<fb.BluePos
+}
+
+func putPixel(addr uintptr, v uint32) {
+	switch fb.BPP {
+	case 32:
+		*(*uint32)(unsafe.Pointer(addr)) = v
+	case 24:
+		*(*byte)(unsafe.Pointer(addr)) = byte(v)
+		*(*byte)(unsafe.Pointer(addr + 1)) = byte(v >> 8)
+		*(*byte)(unsafe.Pointer(addr + 2)) = byte(v >> 16)
+	case 16:
+		*(*uint16)(unsafe.Pointer(addr)) = uint16(v)
+	}
+}
+
+// fbDrawCell renders ch at a cell with the foreground and background of a
+// text-mode attribute. Bytes outside the font draw as a hollow box.
+func fbDrawCell(row, col int, ch, attr byte) {
+	fg := fbPalette[attr&0x0F]
+	bg := fbPalette[attr>>4&0x0F]
+	var bits [glyphHeight]byte
+	glyphRows(ch, &bits)
+
+	bpp := uintptr(fb.BPP / 8)
+	line := fb.Addr + uintptr(row*glyphHeight)*uintptr(fb.Pitch) + uintptr(col*glyphWidth)*bpp
+	for y := 0; y < glyphHeight; y++ {
+		p := line
+		for x := 0; x < glyphWidth; x++ {
+			if bits[y]&(0x80>>uint(x)) != 0 {
+				putPixel(p, fg)
+			} else {
+				putPixel(p, bg)
+			}
+			p += bpp
+		}
+		line += uintptr(fb.Pitch)
+	}
+}
+
+// glyphRows renders a code page 437 byte as one bit row per pixel row of
+// the cell, each font row drawn twice
+func glyphRows(ch byte, bits *[glyphHeight]byte) {
+	var glyph [8]byte
+	var accent byte
+	shift := 0
+	switch {
+	case ch == 0:
+		return
+	case ch >= fontFirst && int(ch-fontFirst) < fontGlyphs:
+		glyph = font[ch-fontFirst]
+	case ch >= 0x80 && int(ch-0x80) < len(cp437Letters) && cp437Letters[ch-0x80][0] != 0:
+		base := cp437Letters[ch-0x80][0]
+		accent = cp437Letters[ch-0x80][1]
+		glyph = font[base-fontFirst]
+		// A capital moves down to make room for its mark
+		if base <= 'Z' && accent != accentCedilla {
+			shift = 2
+		}
+	default:
+		glyph = [8]byte{0x7E, 0x42, 0x42, 0x42, 0x42, 0x42, 0x7E, 0x00}
+		for i := 0; i < len(cp437Glyphs); i++ {
+			if cp437Glyphs[i].ch == ch {
+				glyph = cp437Glyphs[i].rows
+				break
+			}
+		}
+	}
+
+	for y := shift; y < glyphHeight; y++ {
+		bits[y] = glyph[(y-shift)/2]
+	}
+	mark := &accentMarks[accent]
+	switch {
+	case accent == accentNone:
+	case accent == accentCedilla:
+		bits[glyphHeight-2], bits[glyphHeight-1] = mark[0], mark[0]
+	case shift != 0:
+		bits[0], bits[1] = mark[0], mark[1]
+	default:
+		// Over a lowercase letter, replacing the dot of an i
+		bits[0], bits[1] = mark[0], mark[0]
+		bits[2], bits[3] = mark[1], mark[1]
+	}
+}
+
+// fbDrawCursor underlines a cell in its foreground colour
+func fbDrawCursor(row, col int, attr byte) {
+	fg := fbPalette[attr&0x0F]
+	bpp := uintptr(fb.BPP / 8)
+	line := fb.Addr + uintptr((row+1)*glyphHeight-cursorHeight)*uintptr(fb.Pitch) + uintptr(col*glyphWidth)*bpp
+	for y := 0; y < cursorHeight; y++ {
+		p := line
+		for x := 0; x < glyphWidth; x++ {
+			putPixel(p, fg)
+			p += bpp
+		}
+		line += uintptr(fb.Pitch)
+	}
+}
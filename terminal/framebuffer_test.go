@@ -0,0 +1,144 @@
+package terminal
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// testFramebuffer backs a small 32-bit XRGB framebuffer with host memory
+func testFramebuffer(pixels []uint32, width, height uint32) Framebuffer {
+	return Framebuffer{
+		Addr:  uintptr(unsafe.Pointer(&pixels[0])),
+		Pitch: width * 4, Width: width, Height: height, BPP: 32,
+		RedPos: 16, RedSize: 8, GreenPos: 8, GreenSize: 8, BluePos: 0, BlueSize: 8,
+	}
+}
+
+func TestFbUsable(t *testing.T) {
+	pixels := make([]uint32, 1)
+	f := testFramebuffer(pixels, 1024, 768)
+	if !fbUsable(&f) {
+		t.Fatalf("1024x768x32 should be usable")
+	}
+
+	small := f
+	small.Width = 320
+	indexed := f
+	indexed.RedSize = 0
+	deep := f
+	deep.BPP = 8
+	narrow := f
+	narrow.Pitch = 1024
+	for _, bad := range []Framebuffer{small, indexed, deep, narrow} {
+		if fbUsable(&bad) {
+			t.Fatalf("fbUsable(%+v) = true", bad)
+		}
+	}
+}
+
+func TestFbSetupSizesConsole(t *testing.T) {
+	pixels := make([]uint32, 1)
+	f := testFramebuffer(pixels, 1024, 768)
+	c, l := fbSetup(&f)
+	if c != 128 || l != 48 {
+		t.Fatalf("fbSetup(1024x768) = %dx%d, want 128x48", c, l)
+	}
+
+	f.Width, f.Height = 1920, 1200
+	c, l = fbSetup(&f)
+	if c != MaxColumns || l != MaxRows {
+		t.Fatalf("fbSetup(1920x1200) = %dx%d, want the back buffer limit", c, l)
+	}
+}
+
+func TestPackColorScalesChannels(t *testing.T) {
+	pixels := make([]uint32, 1)
+	f := testFramebuffer(pixels, 1024, 768)
+	fbSetup(&f)
+	if got := packColor(0xAA, 0x55, 0xFF); got != 0xAA55FF {
+		t.Fatalf("XRGB packColor = %#x", got)
+	}
+
+	// RGB565
+	f.BPP, f.RedPos, f.RedSize, f.GreenPos, f.GreenSize, f.BlueSize = 16, 11, 5, 5, 6, 5
+	fbSetup(&f)
+	if got := packColor(0xFF, 0xFF, 0xFF); got != 0xFFFF {
+		t.Fatalf("RGB565 white = %#x", got)
+	}
+	if fbPalette[1] != 0x0015 {
+		t.Fatalf("RGB565 blue = %#x, want 0x15", fbPalette[1])
+	}
+}
+
+func TestFbDrawCellRendersGlyph(t *testing.T) {
+	const width, height = 640, 400
+	pixels := make([]uint32, width*height)
+	f := testFramebuffer(pixels, width, height)
+	fbSetup(&f)
+
+	// 'I' on blue at cell (1, 2): the stem is the fourth pixel column
+	fbDrawCell(1, 2, 'I', 0x1F)
+	x0, y0 := 2*glyphWidth, 1*glyphHeight
+	at := func(x, y int) uint32 { return pixels[(y0+y)*width+x0+x] }
+	for y := 2; y < 12; y++ {
+		if at(3, y) != 0xFFFFFF || at(0, y) != 0x0000AA {
+			t.Fatalf("row %d: stem %#x, margin %#x", y, at(3, y), at(0, y))
+		}
+	}
+	if at(3, 15) != 0x0000AA {
+		t.Fatalf("the bottom row should be background")
+	}
+	if pixels[y0*width+x0-1] != 0 {
+		t.Fatalf("drawing spilled into the previous cell")
+	}
+
+	fbDrawCursor(1, 2, 0x1F)
+	if at(0, 15) != 0xFFFFFF || at(0, 13) != 0x0000AA {
+		t.Fatalf("cursor should underline the last two rows")
+	}
+}
+
+func TestFontCoversPrintableASCII(t *testing.T) {
+	if len(font) != '~'-' '+1 {
+		t.Fatalf("font has %d glyphs", len(font))
+	}
+	for i, g := range font[1:] {
+		blank := true
+		for _, b := range g {
+			if b != 0 {
+				blank = false
+			}
+		}
+		if blank {
+			t.Fatalf("glyph %q is blank", rune(' '+1+i))
+		}
+	}
+}
+
+func TestGlyphRowsDrawAccents(t *testing.T) {
+	var plain, accented [glyphHeight]byte
+	glyphRows('E', &plain)
+	glyphRows(toCP437('É'), &accented)
+	if accented[0] == 0 || accented[1] == 0 {
+		t.Fatalf("É has no mark on top")
+	}
+	// The capital is the same glyph moved down by two pixel rows
+	for y := 2; y < glyphHeight; y++ {
+		if accented[y] != plain[y-2] {
+			t.Fatalf("É row %d = %#x, want %#x", y, accented[y], plain[y-2])
+		}
+	}
+
+	var c, cedilla [glyphHeight]byte
+	glyphRows('c', &c)
+	glyphRows(toCP437('ç'), &cedilla)
+	if cedilla[glyphHeight-1] == 0 || cedilla[4] != c[4] {
+		t.Fatalf("ç should be c with a mark below")
+	}
+
+	var box [glyphHeight]byte
+	glyphRows(0xB0, &box)
+	if box[0] != 0x7E {
+		t.Fatalf("a byte without a glyph should draw a box")
+	}
+}
@@ -0,0 +1,28 @@
+package terminal
+
+// mirror receives a copy of every byte written through PutRune/Print, UTF-8
+// encoded, so the console can be followed on a second device such as a
+// serial port
+var mirror func(c byte)
+
+// SetMirror registers fn as the output mirror; nil turns mirroring off
+func SetMirror(fn func(c byte)) {
+	mirror = fn
+}
+
+func mirrorByte(c byte) {
+	if mirror != nil {
+		mirror(c)
+	}
+}
+
+func mirrorRune(r rune) {
+	if mirror == nil {
+		return
+	}
+	var buf [4]byte
+	n := EncodeRune(r, &buf)
+	for i := 0; i < n; i++ {
+		mirror(buf[i])
+	}
+}
@@ -0,0 +1,56 @@
+package terminal
+
+// Output is where the text of one writer goes: the virtual console it
+// belongs to, and while the shell pipes it, a capture in place of the
+// console. Every task has its own, so a program keeps printing to the
+// console it was started on whichever one is on screen.
+type Output struct {
+	console int
+	capture *Capture
+}
+
+var (
+	// mainOutput belongs to the kernel's own task, the one the shell runs in
+	mainOutput Output
+	// out is the output of the running task, which Print and PutRune use
+	out = &mainOutput
+)
+
+// MainOutput is the output of the kernel's own task
+func MainOutput() *Output {
+	return &mainOutput
+}
+
+// Reset sends o to console i, with nothing capturing it
+func (o *Output) Reset(i int) {
+	o.console = i
+	o.capture = nil
+}
+
+// SetOutput makes o the output written to, for the scheduler to call with
+// that of the task it switches to
+func SetOutput(o *Output) {
+	out = o
+	useConsole(o.console)
+}
+
+// OutputTo sends the output of the running task to console i
+func OutputTo(i int) {
+	if i < 0 || i >= Consoles {
+		return
+	}
+	out.console = i
+	useConsole(i)
+}
+
+// OutputConsole is the console the running task's output goes to
+func OutputConsole() int {
+	return out.console
+}
+
+// resetOutput goes back to the main output on the first console
+func resetOutput() {
+	out = &mainOutput
+	mainOutput.console = 0
+	useConsole(0)
+}
@@ -0,0 +1,219 @@
+package terminal
+
+// The mouse pointer is a cell drawn with its colours swapped. Dragging with
+// the left button highlights text the same way and copies it to the
+// clipboard on release; the kernel pastes the clipboard into the shell on a
+// right or middle click.
+
+// Button bits taken by PointerEvent, in the PS/2 packet layout
+const (
+	ButtonLeft   = 0x01
+	ButtonRight  = 0x02
+	ButtonMiddle = 0x04
+)
+
+const (
+	// Mouse counts per cell, a text cell being about 8x16 pixels
+	pointerScaleX = glyphWidth
+	pointerScaleY = glyphHeight
+
+	// Every row plus the line breaks between them, each cell taking up to
+	// three bytes of UTF-8
+	clipboardSize = MaxRows * (MaxColumns*3 + 1)
+)
+
+var (
+	pointerShown   bool
+	pointerX       int // position in mouse counts
+	pointerY       int
+	pointerButtons uint8
+
+	// The selection runs from anchor to head, both linear cell indices, in
+	// whichever order they fall on screen
+	selected  bool
+	selecting bool // the left button is still held
+	anchor    int
+	head      int
+
+	// inverted records the cells currently drawn with swapped colours
+	inverted [MaxRows][MaxColumns]bool
+
+	clipboard [clipboardSize]byte
+	clipLen   int
+)
+
+// ShowPointer draws the pointer cell in the middle of the screen
+func ShowPointer() {
+	flags := lock.AcquireIRQ()
+	pointerX = cols * pointerScaleX / 2
+	pointerY = rows * pointerScaleY / 2
+	pointerShown = true
+	refreshHighlight()
+	lock.ReleaseIRQ(flags)
+}
+
+// PointerPosition returns the cell under the pointer
+func PointerPosition() (col, row int) {
+	return pointerX / pointerScaleX, pointerY / pointerScaleY
+}
+
+// PointerEvent moves the pointer by a mouse report, dy growing downwards,
+// and applies the buttons now held: pressing left starts a selection,
+// dragging extends it and releasing copies it to the clipboard. It reports
+// whether right or middle was just pressed, which asks for a paste.
+func PointerEvent(dx, dy int, buttons uint8) bool {
+	flags := lock.AcquireIRQ()
+	pointerX = clampCount(pointerX+dx, cols*pointerScaleX)
+	pointerY = clampCount(pointerY+dy, rows*pointerScaleY)
+	col, row := PointerPosition()
+	pos := row*cols + col
+
+	pressed := buttons &^ pointerButtons
+	released := pointerButtons &^ buttons
+	pointerButtons = buttons
+
+	if pressed&ButtonLeft != 0 {
+		selected, selecting = true, true
+		anchor, head = pos, pos
+	} else if selecting {
+		head = pos
+	}
+	if released&ButtonLeft != 0 && selecting {
+		selecting = false
+		if anchor == head {
+			// A click without a drag just drops the selection
+			selected = false
+		} else {
+			copySelection()
+		}
+	}
+	refreshHighlight()
+	lock.ReleaseIRQ(flags)
+	return pressed&(ButtonRight|ButtonMiddle) != 0
+}
+
+// Clipboard returns the text of the last selection as UTF-8. Rows are
+// separated by '\n' and lose their trailing blanks.
+func Clipboard() []byte {
+	return clipboard[:clipLen]
+}
+
+func clampCount(v, limit int) int {
+	if v < 0 {
+		return 0
+	}
+	if v >= limit {
+		return limit - 1
+	}
+	return v
+}
+
+func selectionRange() (start, end int) {
+	if anchor <= head {
+		return anchor, head
+	}
+	return head, anchor
+}
+
+func copySelection() {
+	start, end := selectionRange()
+	firstRow, lastRow := start/cols, end/cols
+	clipLen = 0
+	for row := firstRow; row <= lastRow; row++ {
+		first, last := 0, cols-1
+		if row == firstRow {
+			first = start % cols
+		}
+		if row == lastRow {
+			last = end % cols
+		}
+		for last >= first && screenChar(row, last) == ' ' {
+			last--
+		}
+		if row != firstRow {
+			clipboard[clipLen] = '\n'
+			clipLen++
+		}
+		for col := first; col <= last; col++ {
+			var buf [4]byte
+			n := EncodeRune(cp437Rune(screenChar(row, col)), &buf)
+			for i := 0; i < n; i++ {
+				clipboard[clipLen] = buf[i]
+				clipLen++
+			}
+		}
+	}
+}
+
+// screenChar reads a cell, an untouched one counting as blank
+func screenChar(row, col int) byte {
+	c := cellChar(row, col)
+	if c == 0 {
+		return ' '
+	}
+	return c
+}
+
+// refreshHighlight swaps the colours of every cell whose highlight changed.
+// The pointer over a selected cell cancels out so it stays visible.
+func refreshHighlight() {
+	pointer := -1
+	if pointerShown {
+		col, row := PointerPosition()
+		pointer = row*cols + col
+	}
+	start, end := selectionRange()
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			pos := row*cols + col
+			want := (pos == pointer) != (selected && pos >= start && pos <= end)
+			if want != inverted[row][col] {
+				setCellAttr(row, col, swapColors(cellAttr(row, col)))
+				inverted[row][col] = want
+			}
+		}
+	}
+}
+
+// hideHighlight restores every swapped cell, before the screen contents
+// move under it
+func hideHighlight() {
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			if inverted[row][col] {
+				setCellAttr(row, col, swapColors(cellAttr(row, col)))
+				inverted[row][col] = false
+			}
+		}
+	}
+}
+
+// scrollHighlight moves the selection up a row with its text, dropping it
+// once it leaves the screen, and redraws the highlight
+func scrollHighlight() {
+	anchor -= cols
+	head -= cols
+	if anchor < 0 || head < 0 {
+		selected, selecting = false, false
+	}
+	refreshHighlight()
+}
+
+// clearHighlight drops the selection after the screen was wiped
+func clearHighlight() {
+	selected, selecting = false, false
+	refreshHighlight()
+}
+
+// highlightAttr is the attribute to store for a cell written with attr, so
+// the pointer or selection drawn over it survives the write
+func highlightAttr(row, col int, attr byte) byte {
+	if inverted[row][col] {
+		return swapColors(attr)
+	}
+	return attr
+}
+
+func swapColors(attr byte) byte {
+	return attr<<4 | attr>>4
+}
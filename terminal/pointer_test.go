@@ -0,0 +1,152 @@
+//go:build testing
+
+package terminal
+
+import "testing"
+
+const testAttr = 0x07
+
+func setupPointer(rows ...string) {
+	Init()
+	pointerShown, pointerButtons = false, 0
+	selected, selecting = false, false
+	anchor, head, clipLen = 0, 0, 0
+	inverted = [MaxRows][MaxColumns]bool{}
+	for r := 0; r < VGAHeight; r++ {
+		for c := 0; c < VGAWidth; c++ {
+			ch := uint16(' ')
+			if r < len(rows) && c < len(rows[r]) {
+				ch = uint16(rows[r][c])
+			}
+			vgaBuffer[r][c] = ch | testAttr<<8
+		}
+	}
+}
+
+// moveTo places the pointer on a cell through relative motion
+func moveTo(col, row int, buttons uint8) bool {
+	x, y := PointerPosition()
+	return PointerEvent((col-x)*pointerScaleX, (row-y)*pointerScaleY, buttons)
+}
+
+func TestShowPointerInvertsOneCell(t *testing.T) {
+	setupPointer()
+	ShowPointer()
+
+	col, row := PointerPosition()
+	if col != VGAWidth/2 || row != VGAHeight/2 {
+		t.Fatalf("PointerPosition() = %d,%d", col, row)
+	}
+	if cellAttr(row, col) != 0x70 {
+		t.Fatalf("pointer cell attr = %#x, want 0x70", cellAttr(row, col))
+	}
+
+	moveTo(3, 1, 0)
+	if cellAttr(row, col) != testAttr || cellAttr(1, 3) != 0x70 {
+		t.Fatalf("pointer did not follow the motion")
+	}
+}
+
+func TestPointerClampsToScreen(t *testing.T) {
+	setupPointer()
+	ShowPointer()
+
+	PointerEvent(-10000, 10000, 0)
+	col, row := PointerPosition()
+	if col != 0 || row != VGAHeight-1 {
+		t.Fatalf("PointerPosition() = %d,%d, want 0,%d", col, row, VGAHeight-1)
+	}
+}
+
+func TestDragSelectsAndCopies(t *testing.T) {
+	setupPointer("dav$ ls", "readme.txt   notes.txt")
+	ShowPointer()
+
+	moveTo(5, 0, ButtonLeft)
+	moveTo(5, 1, ButtonLeft)
+	if cellAttr(0, 6) != 0x70 || cellAttr(1, 0) != 0x70 || cellAttr(1, 6) != testAttr {
+		t.Fatalf("selection is not highlighted")
+	}
+	moveTo(5, 1, 0)
+
+	if got := string(Clipboard()); got != "ls\nreadme" {
+		t.Fatalf("Clipboard() = %q, want %q", got, "ls\nreadme")
+	}
+	if !selected || cellAttr(0, 5) != 0x70 {
+		t.Fatalf("selection should stay highlighted after the copy")
+	}
+}
+
+func TestDragUpwardsSelectsInScreenOrder(t *testing.T) {
+	setupPointer("abcdef")
+	moveTo(4, 0, ButtonLeft)
+	moveTo(1, 0, ButtonLeft)
+	moveTo(1, 0, 0)
+
+	if got := string(Clipboard()); got != "bcde" {
+		t.Fatalf("Clipboard() = %q, want %q", got, "bcde")
+	}
+}
+
+func TestClickDropsSelectionAndKeepsClipboard(t *testing.T) {
+	setupPointer("abcdef")
+	moveTo(0, 0, ButtonLeft)
+	moveTo(2, 0, ButtonLeft)
+	moveTo(2, 0, 0)
+
+	moveTo(4, 0, ButtonLeft)
+	moveTo(4, 0, 0)
+	if selected || cellAttr(0, 1) != testAttr {
+		t.Fatalf("a click should drop the selection")
+	}
+	if got := string(Clipboard()); got != "abc" {
+		t.Fatalf("Clipboard() = %q, want %q", got, "abc")
+	}
+}
+
+func TestPasteButtons(t *testing.T) {
+	setupPointer()
+	if PointerEvent(0, 0, 0) {
+		t.Fatalf("no button, no paste")
+	}
+	if !PointerEvent(0, 0, ButtonRight) {
+		t.Fatalf("right press should paste")
+	}
+	if PointerEvent(0, 0, ButtonRight) {
+		t.Fatalf("holding right must not paste again")
+	}
+	if !PointerEvent(0, 0, ButtonRight|ButtonMiddle) {
+		t.Fatalf("middle press should paste")
+	}
+}
+
+func TestScrollMovesSelectionWithText(t *testing.T) {
+	setupPointer("one", "two")
+	moveTo(0, 1, ButtonLeft)
+	moveTo(2, 1, ButtonLeft)
+
+	hideHighlight()
+	if cellAttr(1, 0) != testAttr {
+		t.Fatalf("hideHighlight left a cell swapped")
+	}
+	scrollHighlight()
+	if anchor != 0 || head != 2 || cellAttr(0, 0) != 0x70 {
+		t.Fatalf("selection = %d..%d, want it one row up", anchor, head)
+	}
+
+	hideHighlight()
+	scrollHighlight()
+	if selected {
+		t.Fatalf("a selection scrolled off the screen should be dropped")
+	}
+}
+
+func TestHighlightAttrKeepsHighlightOnWrite(t *testing.T) {
+	setupPointer()
+	ShowPointer()
+	col, row := PointerPosition()
+
+	if highlightAttr(row, col, 0x1F) != 0xF1 || highlightAttr(0, 0, 0x1F) != 0x1F {
+		t.Fatalf("highlightAttr should swap only highlighted cells")
+	}
+}
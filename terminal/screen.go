@@ -0,0 +1,37 @@
+package terminal
+
+import "github.com/dmarro89/go-dav-os/kernel/spinlock"
+
+// Size of the VGA text screen, in character cells
+const (
+	VGAWidth  = 80
+	VGAHeight = 25
+)
+
+// Limits of the cell back buffer, which bound the framebuffer console
+const (
+	MaxColumns = 160
+	MaxRows    = 64
+)
+
+// Consoles is the number of virtual consoles, switched with Alt+F1 on
+const Consoles = 4
+
+// cols and rows are the size of the active console: the VGA text screen
+// until a framebuffer takes over
+var (
+	cols = VGAWidth
+	rows = VGAHeight
+)
+
+// lock keeps writers apart: the shell and the programs it started run in
+// tasks of their own. The exported calls that change the screen take it
+// with interrupts off, so the timer cannot switch tasks halfway through a
+// rune; Print takes it for each rune so a long write does not hold the
+// timer off.
+var lock spinlock.Lock
+
+// Size returns the console size in cells
+func Size() (columns, lines int) {
+	return cols, rows
+}
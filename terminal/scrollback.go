@@ -0,0 +1,46 @@
+package terminal
+
+// Rows scrolled off the top of a console are kept in a ring, so output
+// that went by too fast can be paged back through with Shift+PgUp.
+
+const scrollbackLines = 512
+
+// screenLine is one row of cells, character then attribute
+type screenLine [MaxColumns][2]byte
+
+type scrollback struct {
+	lines [scrollbackLines]screenLine
+	next  int // the slot the next line goes in
+	count int
+}
+
+// push saves the first width cells of a row leaving the screen, dropping
+// the oldest line once the ring is full
+func (s *scrollback) push(row *screenLine, width int) {
+	line := &s.lines[s.next]
+	for c := 0; c < width; c++ {
+		line[c] = row[c]
+	}
+	s.next = (s.next + 1) % scrollbackLines
+	if s.count < scrollbackLines {
+		s.count++
+	}
+}
+
+// line returns the n-th newest saved line, 1 being the last one pushed
+func (s *scrollback) line(n int) *screenLine {
+	return &s.lines[(s.next-n+scrollbackLines)%scrollbackLines]
+}
+
+func (s *scrollback) reset() {
+	s.next, s.count = 0, 0
+}
+
+// viewRow is what screen row r shows with the display scrolled view lines
+// back: saved lines at the top, then the screen from its first row
+func (s *scrollback) viewRow(screen *[MaxRows]screenLine, view, r int) *screenLine {
+	if r < view {
+		return s.line(view - r)
+	}
+	return &screen[r-view]
+}
@@ -0,0 +1,68 @@
+package terminal
+
+import "testing"
+
+func numberedLine(n int) *screenLine {
+	var l screenLine
+	l[0] = [2]byte{byte(n), defaultAttr}
+	return &l
+}
+
+func TestScrollbackKeepsNewestLines(t *testing.T) {
+	var s scrollback
+	for i := 1; i <= 3; i++ {
+		s.push(numberedLine(i), VGAWidth)
+	}
+	if s.count != 3 || s.line(1)[0][0] != 3 || s.line(3)[0][0] != 1 {
+		t.Fatalf("count %d, newest %d, oldest %d", s.count, s.line(1)[0][0], s.line(3)[0][0])
+	}
+
+	for i := 4; i <= scrollbackLines+10; i++ {
+		s.push(numberedLine(i), VGAWidth)
+	}
+	if s.count != scrollbackLines {
+		t.Fatalf("count %d, want the ring size", s.count)
+	}
+	oldest := byte(scrollbackLines + 10 - scrollbackLines + 1)
+	if s.line(scrollbackLines)[0][0] != oldest {
+		t.Fatalf("oldest line %d, want %d", s.line(scrollbackLines)[0][0], oldest)
+	}
+
+	s.reset()
+	if s.count != 0 {
+		t.Fatalf("reset kept %d lines", s.count)
+	}
+}
+
+func TestScrollbackPushCopiesWidth(t *testing.T) {
+	var s scrollback
+	row := numberedLine(7)
+	row[VGAWidth] = [2]byte{'x', defaultAttr}
+	s.push(row, VGAWidth)
+	row[0][0] = 8
+	if got := s.line(1); got[0][0] != 7 || got[VGAWidth][0] != 0 {
+		t.Fatalf("saved line %v...", got[:2])
+	}
+}
+
+func TestViewRowShowsScrollbackAboveScreen(t *testing.T) {
+	var s scrollback
+	var screen [MaxRows]screenLine
+	for i := 0; i < MaxRows; i++ {
+		screen[i][0][0] = byte(100 + i)
+	}
+	for i := 1; i <= 5; i++ {
+		s.push(numberedLine(i), VGAWidth)
+	}
+
+	if s.viewRow(&screen, 0, 3)[0][0] != 103 {
+		t.Fatalf("the live view should show the screen")
+	}
+	// Two lines back: the two newest saved lines, then the screen
+	want := []byte{4, 5, 100, 101}
+	for r, w := range want {
+		if got := s.viewRow(&screen, 2, r)[0][0]; got != w {
+			t.Fatalf("row %d = %d, want %d", r, got, w)
+		}
+	}
+}
@@ -5,7 +5,15 @@ package terminal
 var vgaBuffer *[25][80]uint16
 var cursorRow int
 var cursorCol int
-var output string
+
+// output holds one rune per cell written; outputCursor is where the next
+// rune lands, and the cursor moves let writes overwrite what is already
+// there, like on screen
+var output []rune
+var outputCursor int
+
+// textColor is the attribute escape sequences last selected
+var textColor byte
 
 func outb(port uint16, value byte) {}
 
@@ -15,7 +23,10 @@ func Init() {
 	vgaBuffer = new([25][80]uint16)
 	cursorRow = 0
 	cursorCol = 0
-	output = ""
+	output = nil
+	outputCursor = 0
+	ansiReset()
+	resetOutput()
 }
 
 func Clear() {
@@ -31,27 +42,121 @@ func Clear() {
 }
 
 func PutRune(ch rune) {
-	output += string(ch)
+	if captureRune(ch) {
+		return
+	}
+	if ansiFeed(ch) {
+		return
+	}
+	if outputCursor < len(output) {
+		output[outputCursor] = ch
+		outputCursor++
+		return
+	}
+	output = append(output, ch)
+	outputCursor = len(output)
 }
 
 func Print(s string) {
-	output += s
+	for _, r := range s {
+		PutRune(r)
+	}
+}
+
+func CursorBack(n int) {
+	outputCursor -= n
+	if outputCursor < 0 {
+		outputCursor = 0
+	}
+}
+
+func CursorForward(n int) {
+	outputCursor += n
+	if outputCursor > len(output) {
+		outputCursor = len(output)
+	}
 }
 
 func PrintAt(col, row int, s string) {}
 
 func Backspace() {
+	if outputCursor < len(output) {
+		if outputCursor > 0 {
+			outputCursor--
+			output[outputCursor] = ' '
+		}
+		return
+	}
 	if len(output) > 0 {
 		output = output[:len(output)-1]
+		outputCursor = len(output)
 	}
 }
 
 func PrintInt(v int) {}
 
+// The stub has a single screen: switching only records the console asked
+// for, output to every console lands in the same place and the scrollback
+// view is always the live screen
+var activeConsole int
+
+func SwitchConsole(i int) {
+	if i >= 0 && i < Consoles {
+		activeConsole = i
+	}
+}
+
+func ActiveConsole() int { return activeConsole }
+
+func useConsole(i int) {}
+
+func ScrollView(n int) {}
+
+// The cells hold the character in the low byte and the attribute in the
+// high byte, like VGA memory
+func cellChar(row, col int) byte {
+	if vgaBuffer == nil {
+		return 0
+	}
+	return byte(vgaBuffer[row][col])
+}
+
+func cellAttr(row, col int) byte {
+	if vgaBuffer == nil {
+		return 0
+	}
+	return byte(vgaBuffer[row][col] >> 8)
+}
+
+func setCellAttr(row, col int, attr byte) {
+	if vgaBuffer != nil {
+		vgaBuffer[row][col] = vgaBuffer[row][col]&0xFF | uint16(attr)<<8
+	}
+}
+
+func cursorAt() (row, col int) {
+	return cursorRow, cursorCol
+}
+
+func moveCursorTo(row, col int) {
+	cursorRow, cursorCol = row, col
+}
+
+func eraseCell(row, col int) {
+	if vgaBuffer != nil {
+		vgaBuffer[row][col] = ' ' | uint16(textColor)<<8
+	}
+}
+
+func setTextAttr(attr byte) {
+	textColor = attr
+}
+
 func ResetOutputForTesting() {
-	output = ""
+	output = nil
+	outputCursor = 0
 }
 
 func OutputForTesting() string {
-	return output
+	return string(output)
 }
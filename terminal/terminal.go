@@ -5,9 +5,6 @@ package terminal
 import "unsafe"
 
 const (
-	VGAWidth  = 80
-	VGAHeight = 25
-
 	vgaCursorIndexPort uint16 = 0x3D4
 	vgaCursorDataPort  uint16 = 0x3D5
 )
@@ -23,131 +20,319 @@ const (
 	ColorLightGrey = 7
 )
 
-var (
-	column int
+// console is one virtual console: its screen, cursor and colour, the
+// escape sequence state and the lines scrolled off its top
+type console struct {
+	// cells is the back buffer, character then attribute for every cell.
+	// The screen is drawn from it, so scrolling never reads video memory.
+	cells  [MaxRows]screenLine
 	row    int
+	column int
 	color  byte
+	parser ansiParser
+	back   scrollback
+}
+
+var (
 	vidMem *[VGAHeight][VGAWidth][2]byte
+
+	consoles [Consoles]console
+	// con is the console written to, that of the running task's output;
+	// shown is the one on screen, consoles[active]
+	con    = &consoles[0]
+	shown  = &consoles[0]
+	active int
+
+	// view is how many lines the display is scrolled back from the live
+	// screen
+	view int
+
+	// fbActive selects the framebuffer console over VGA text mode; it draws
+	// its own cursor, currently at cursorRow/cursorCol
+	fbActive  bool
+	cursorRow int
+	cursorCol int
 )
 
+// Init starts the console on the VGA text screen
 func Init() {
 	vidMem = getVidMem()
-	color = makeColor(ColorLightGrey, ColorBlack)
-	column = 0
-	row = 0
-	Clear()
+	fbActive = false
+	cols, rows = VGAWidth, VGAHeight
+	start()
+}
+
+// InitFramebuffer starts the console on a linear framebuffer instead, one
+// 8x16 cell per character. It reports false and leaves the console as it
+// was when it cannot draw in the framebuffer's format.
+func InitFramebuffer(f *Framebuffer) bool {
+	if !fbUsable(f) {
+		return false
+	}
+	cols, rows = fbSetup(f)
+	fbActive = true
+	cursorRow, cursorCol = 0, 0
+	start()
+	return true
+}
+
+// Framebuffered reports whether the framebuffer console is active
+func Framebuffered() bool {
+	return fbActive
+}
+
+// start resets every console, leaving the first one on screen and written
+// to by the main output
+func start() {
+	showConsole(0)
+	for i := Consoles - 1; i >= 0; i-- {
+		useConsole(i)
+		ansiReset()
+		con.back.reset()
+		clearScreen()
+	}
+	resetOutput()
+}
+
+// useConsole makes console i the one written to
+func useConsole(i int) {
+	con = &consoles[i]
+	ansi = &con.parser
+}
+
+func showConsole(i int) {
+	active = i
+	shown = &consoles[i]
+	view = 0
+}
+
+// onScreen reports whether the console written to is on screen. Writing
+// to any other only changes its back buffer.
+func onScreen() bool {
+	return con == shown
+}
+
+// SwitchConsole puts virtual console i on screen. Each task's output stays
+// on the console it goes to. The selection is dropped as it belongs to the
+// screen left; a display scrolled back has already hidden it.
+func SwitchConsole(i int) {
+	if i < 0 || i >= Consoles || i == active {
+		return
+	}
+	flags := lock.AcquireIRQ()
+	if view == 0 {
+		hideHighlight()
+	}
+	selected, selecting = false, false
+	showConsole(i)
+	redraw()
+	refreshHighlight()
+	placeCursor()
+	lock.ReleaseIRQ(flags)
+}
+
+// ActiveConsole is the index of the console on screen
+func ActiveConsole() int {
+	return active
+}
+
+// ScrollView moves the display n lines back into the scrollback, or
+// forward towards the live screen when n is negative. Any output brings
+// the live screen back.
+func ScrollView(n int) {
+	flags := lock.AcquireIRQ()
+	scrollView(n)
+	lock.ReleaseIRQ(flags)
+}
+
+func scrollView(n int) {
+	v := view + n
+	if v > shown.back.count {
+		v = shown.back.count
+	}
+	if v < 0 {
+		v = 0
+	}
+	if v == view {
+		return
+	}
+	// The highlight lives on the live cells, so it comes off while they are
+	// out of sight
+	if view == 0 {
+		hideHighlight()
+	}
+	view = v
+	redraw()
+	if view == 0 {
+		refreshHighlight()
+	}
 }
 
-func makeColor(fg, bg byte) byte {
-	return fg | (bg << 4)
+// showLive returns the display to the live screen before the console on
+// it is written
+func showLive() {
+	if view != 0 && onScreen() {
+		scrollView(-view)
+	}
+}
+
+// redraw paints the whole display from the back buffer and scrollback
+func redraw() {
+	for r := 0; r < rows; r++ {
+		line := shown.back.viewRow(&shown.cells, view, r)
+		for c := 0; c < cols; c++ {
+			paint(r, c, &line[c])
+		}
+	}
 }
 
 func Clear() {
-	for r := 0; r < VGAHeight; r++ {
-		for c := 0; c < VGAWidth; c++ {
-			vidMem[r][c][0] = ' '
-			vidMem[r][c][1] = color
+	flags := lock.AcquireIRQ()
+	clearScreen()
+	lock.ReleaseIRQ(flags)
+}
+
+func clearScreen() {
+	showLive()
+	if onScreen() {
+		hideHighlight()
+	}
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			setCell(r, c, ' ')
 		}
 	}
-	column = 0
-	row = 0
+	if onScreen() {
+		clearHighlight()
+	}
+	con.column = 0
+	con.row = 0
 	updateCursor()
 }
 
 func PutRune(ch rune) {
+	flags := lock.AcquireIRQ()
 	putRune(ch)
+	lock.ReleaseIRQ(flags)
 }
 
 func putRune(ch rune) {
+	if captureRune(ch) {
+		return
+	}
+	showLive()
 	if ch == '\b' {
-		Backspace()
+		backspace()
+		return
+	}
+
+	// Escape sequences go to the mirror as they are, for a host terminal
+	// to act on as well
+	mirrorRune(ch)
+	if ansiFeed(ch) {
 		return
 	}
 
 	if ch == '\n' {
-		column = 0
-		row++
-		if row >= VGAHeight {
+		con.column = 0
+		con.row++
+		if con.row >= rows {
 			scroll()
-			row = VGAHeight - 1
+			con.row = rows - 1
 		}
 		updateCursor()
 		return
 	}
 
-	vidMem[row][column][0] = byte(ch)
-	vidMem[row][column][1] = color
+	c := toCP437(ch)
+	setCell(con.row, con.column, c)
 
-	debugChar(byte(ch))
+	debugChar(c)
 
-	column++
-	if column >= VGAWidth {
-		column = 0
-		row++
-		if row >= VGAHeight {
+	con.column++
+	if con.column >= cols {
+		con.column = 0
+		con.row++
+		if con.row >= rows {
 			scroll()
-			row = VGAHeight - 1
+			con.row = rows - 1
 		}
 	}
 	updateCursor()
 }
 
 func scroll() {
-	for r := 1; r < VGAHeight; r++ {
-		for c := 0; c < VGAWidth; c++ {
-			vidMem[r-1][c] = vidMem[r][c]
+	if onScreen() {
+		hideHighlight()
+	}
+	con.back.push(&con.cells[0], cols)
+	for r := 1; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			// Only the cells whose content changes are drawn again
+			if con.cells[r-1][c][0] != con.cells[r][c][0] || con.cells[r-1][c][1] != con.cells[r][c][1] {
+				con.cells[r-1][c] = con.cells[r][c]
+				drawCell(r-1, c)
+			}
 		}
 	}
 
-	last := VGAHeight - 1
-	for c := 0; c < VGAWidth; c++ {
-		vidMem[last][c][0] = ' '
-		vidMem[last][c][1] = color
+	last := rows - 1
+	for c := 0; c < cols; c++ {
+		setCell(last, c, ' ')
+	}
+	if onScreen() {
+		scrollHighlight()
 	}
 }
 
 func Print(s string) {
-	for i := 0; i < len(s); i++ {
-		putRune(rune(s[i]))
+	for i := 0; i < len(s); {
+		r, n := DecodeRune(s[i:])
+		PutRune(r)
+		i += n
 	}
 }
 
 func PrintAt(col, row int, s string) {
-	for i := 0; i < len(s); i++ {
-		putRuneAt(col+i, row, rune(s[i]))
+	flags := lock.AcquireIRQ()
+	showLive()
+	for i, cell := 0, col; i < len(s); cell++ {
+		r, n := DecodeRune(s[i:])
+		putRuneAt(cell, row, r)
+		i += n
 	}
+	lock.ReleaseIRQ(flags)
 }
 
 func putRuneAt(col, currRow int, ch rune) {
-	if col < 0 || col >= VGAWidth {
+	if col < 0 || col >= cols {
 		return
 	}
-	if currRow < 0 || currRow >= VGAHeight {
+	if currRow < 0 || currRow >= rows {
 		return
 	}
 
 	if ch == '\n' {
-		column = 0
-		row = currRow + 1
-		if row >= VGAHeight {
+		con.column = 0
+		con.row = currRow + 1
+		if con.row >= rows {
 			scroll()
-			row = VGAHeight - 1
+			con.row = rows - 1
 		}
 		updateCursor()
 		return
 	}
 
-	vidMem[currRow][col][0] = byte(ch)
-	vidMem[currRow][col][1] = color
+	setCell(currRow, col, toCP437(ch))
 
-	column = col + 1
-	row = currRow
-	if column >= VGAWidth {
-		column = 0
-		row = currRow + 1
-		if row >= VGAHeight {
+	con.column = col + 1
+	con.row = currRow
+	if con.column >= cols {
+		con.column = 0
+		con.row = currRow + 1
+		if con.row >= rows {
 			scroll()
-			row = VGAHeight - 1
+			con.row = rows - 1
 		}
 	}
 
@@ -155,23 +340,165 @@ func putRuneAt(col, currRow int, ch rune) {
 }
 
 func Backspace() {
-	if column > 0 {
-		column--
-		vidMem[row][column][0] = ' '
-		vidMem[row][column][1] = color
+	flags := lock.AcquireIRQ()
+	backspace()
+	lock.ReleaseIRQ(flags)
+}
+
+func backspace() {
+	showLive()
+	mirrorByte('\b')
+
+	if con.column > 0 {
+		con.column--
+		setCell(con.row, con.column, ' ')
 	} else {
-		if row > 0 {
-			row--
-			column = VGAWidth - 1
-			vidMem[row][column][0] = ' '
-			vidMem[row][column][1] = color
+		if con.row > 0 {
+			con.row--
+			con.column = cols - 1
+			setCell(con.row, con.column, ' ')
+		}
+	}
+	updateCursor()
+}
+
+// CursorBack moves the cursor n cells towards the start of the screen
+// without erasing them, wrapping to the end of the previous row
+func CursorBack(n int) {
+	flags := lock.AcquireIRQ()
+	showLive()
+	for ; n > 0; n-- {
+		if con.column > 0 {
+			con.column--
+		} else if con.row > 0 {
+			con.row--
+			con.column = cols - 1
+		} else {
+			break
+		}
+		mirrorByte('\b')
+	}
+	updateCursor()
+	lock.ReleaseIRQ(flags)
+}
+
+// CursorForward moves the cursor n cells on over what is already written.
+// The mirror gets the characters passed over again.
+func CursorForward(n int) {
+	flags := lock.AcquireIRQ()
+	showLive()
+	for ; n > 0; n-- {
+		c := con.cells[con.row][con.column][0]
+		if c == 0 {
+			c = ' '
+		}
+		mirrorRune(cp437Rune(c))
+		con.column++
+		if con.column >= cols {
+			con.column = 0
+			con.row++
+			if con.row >= rows {
+				scroll()
+				con.row = rows - 1
+			}
 		}
 	}
 	updateCursor()
+	lock.ReleaseIRQ(flags)
+}
+
+// setCell writes a character in the current colour, keeping any highlight
+// drawn over the cell when it is on screen
+func setCell(r, c int, ch byte) {
+	attr := con.color
+	if onScreen() {
+		attr = highlightAttr(r, c, attr)
+	}
+	con.cells[r][c][0] = ch
+	con.cells[r][c][1] = attr
+	drawCell(r, c)
+}
+
+// The escape sequence parser moves the cursor, erases cells and sets the
+// colour through these
+func cursorAt() (r, c int) {
+	return con.row, con.column
+}
+
+func moveCursorTo(r, c int) {
+	con.row, con.column = r, c
+	updateCursor()
+}
+
+func eraseCell(r, c int) {
+	setCell(r, c, ' ')
+}
+
+func setTextAttr(attr byte) {
+	con.color = attr
+}
+
+// The pointer and selection work on the console on screen through these
+func cellChar(r, c int) byte {
+	return shown.cells[r][c][0]
+}
+
+func cellAttr(r, c int) byte {
+	return shown.cells[r][c][1]
+}
+
+func setCellAttr(r, c int, attr byte) {
+	shown.cells[r][c][1] = attr
+	drawShown(r, c)
+}
+
+// drawCell copies a cell of the console written to to the screen, when it
+// is on screen
+func drawCell(r, c int) {
+	if onScreen() {
+		drawShown(r, c)
+	}
+}
+
+// drawShown copies a back buffer cell of the console on screen to the
+// screen, unless the display is scrolled back over it
+func drawShown(r, c int) {
+	if view == 0 {
+		paint(r, c, &shown.cells[r][c])
+	}
+}
+
+// paint draws a cell at screen position r, c
+func paint(r, c int, cell *[2]byte) {
+	if !fbActive {
+		vidMem[r][c] = *cell
+		return
+	}
+	fbDrawCell(r, c, cell[0], cell[1])
+	if view == 0 && r == cursorRow && c == cursorCol {
+		fbDrawCursor(r, c, cell[1])
+	}
 }
 
+// updateCursor shows the cursor where the console written to has it, when
+// that console is on screen
 func updateCursor() {
-	pos := uint16(row*VGAWidth + column)
+	if onScreen() {
+		placeCursor()
+	}
+}
+
+// placeCursor draws the cursor of the console on screen
+func placeCursor() {
+	if fbActive {
+		oldRow, oldCol := cursorRow, cursorCol
+		cursorRow, cursorCol = shown.row, shown.column
+		drawShown(oldRow, oldCol)
+		drawShown(shown.row, shown.column)
+		return
+	}
+
+	pos := uint16(shown.row*VGAWidth + shown.column)
 
 	outb(vgaCursorIndexPort, 0x0F)
 	outb(vgaCursorDataPort, byte(pos&0xFF))
@@ -13,11 +13,16 @@ func Clear() {
 }
 
 func PutRune(ch rune) {
+	if captureRune(ch) {
+		return
+	}
 	output += string(ch)
 }
 
 func Print(s string) {
-	output += s
+	for _, r := range s {
+		PutRune(r)
+	}
 }
 
 func PrintAt(col, row int, s string) {
@@ -30,6 +35,15 @@ func Backspace() {
 	}
 }
 
+// The host console is a plain string: cursor moves have nowhere to go,
+// and there is one console with nothing to scroll back to
+func CursorBack(n int)    {}
+func CursorForward(n int) {}
+func SwitchConsole(i int) {}
+func ActiveConsole() int  { return 0 }
+func useConsole(i int)    {}
+func ScrollView(n int)    {}
+
 func PrintInt(v int) {
 	if v < 0 {
 		PutRune('-')
@@ -73,6 +87,17 @@ func PrintHex(v uint64) {
 	}
 }
 
+// The host console has no cells to highlight,
+func cellChar(row, col int) byte          { return 0 }
+func cellAttr(row, col int) byte          { return 0 }
+func setCellAttr(row, col int, attr byte) {}
+
+// nor a cursor or colours for escape sequences to change
+func cursorAt() (row, col int)  { return 0, 0 }
+func moveCursorTo(row, col int) {}
+func eraseCell(row, col int)    {}
+func setTextAttr(attr byte)     {}
+
 func ResetOutputForTesting() {
 	output = ""
 }
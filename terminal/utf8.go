@@ -0,0 +1,61 @@
+package terminal
+
+// DecodeRune decodes the UTF-8 sequence at the start of s and returns the
+// rune and its length in bytes. A malformed sequence decodes as U+FFFD one
+// byte long, so a caller walking a string always moves on.
+func DecodeRune(s string) (rune, int) {
+	if len(s) == 0 {
+		return 0, 0
+	}
+	c := s[0]
+	var r rune
+	var size int
+	switch {
+	case c < 0x80:
+		return rune(c), 1
+	case c&0xE0 == 0xC0:
+		r, size = rune(c&0x1F), 2
+	case c&0xF0 == 0xE0:
+		r, size = rune(c&0x0F), 3
+	case c&0xF8 == 0xF0:
+		r, size = rune(c&0x07), 4
+	default:
+		return 0xFFFD, 1
+	}
+	if len(s) < size {
+		return 0xFFFD, 1
+	}
+	for i := 1; i < size; i++ {
+		if s[i]&0xC0 != 0x80 {
+			return 0xFFFD, 1
+		}
+		r = r<<6 | rune(s[i]&0x3F)
+	}
+	return r, size
+}
+
+// EncodeRune writes the UTF-8 encoding of r to buf and returns its length
+func EncodeRune(r rune, buf *[4]byte) int {
+	switch {
+	case r < 0 || r > 0x10FFFF:
+		r = 0xFFFD
+	case r < 0x80:
+		buf[0] = byte(r)
+		return 1
+	case r < 0x800:
+		buf[0] = 0xC0 | byte(r>>6)
+		buf[1] = 0x80 | byte(r)&0x3F
+		return 2
+	}
+	if r < 0x10000 {
+		buf[0] = 0xE0 | byte(r>>12)
+		buf[1] = 0x80 | byte(r>>6)&0x3F
+		buf[2] = 0x80 | byte(r)&0x3F
+		return 3
+	}
+	buf[0] = 0xF0 | byte(r>>18)
+	buf[1] = 0x80 | byte(r>>12)&0x3F
+	buf[2] = 0x80 | byte(r>>6)&0x3F
+	buf[3] = 0x80 | byte(r)&0x3F
+	return 4
+}
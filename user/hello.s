@@ -44,6 +44,91 @@ hello_msg:
 hello_msg_end:
 	.set hello_msg_len, hello_msg_end - hello_msg
 
+# echo: a TCP echo server on port 7777 serving one connection. The
+# sockaddr_in and the receive buffer live on the user stack page, the only
+# page the kernel may write.
+.global go_0kernel.userEchoStart
+go_0kernel.userEchoStart:
+	sub  $2048, %rsp
+	mov  $5, %rax            # SYS_SOCKET
+	mov  $2, %rdi            # AF_INET
+	mov  $1, %rsi            # SOCK_STREAM
+	xor  %rdx, %rdx
+	syscall
+	cmp  $-1, %rax
+	je   echo_fail
+	mov  %rax, %r12          # listening fd
+
+	movq $0x611E0002, (%rsp) # AF_INET, port 7777 big-endian, INADDR_ANY
+	movq $0, 8(%rsp)
+	mov  $6, %rax            # SYS_BIND
+	mov  %r12, %rdi
+	mov  %rsp, %rsi
+	mov  $16, %rdx
+	syscall
+	cmp  $-1, %rax
+	je   echo_fail
+
+	mov  $7, %rax            # SYS_LISTEN
+	mov  %r12, %rdi
+	mov  $1, %rsi
+	syscall
+	cmp  $-1, %rax
+	je   echo_fail
+
+	mov  $1, %rax            # SYS_WRITE
+	mov  $1, %rdi
+	lea  echo_msg(%rip), %rsi
+	mov  $echo_msg_len, %rdx
+	syscall
+
+	mov  $8, %rax            # SYS_ACCEPT
+	mov  %r12, %rdi
+	xor  %rsi, %rsi
+	syscall
+	cmp  $-1, %rax
+	je   echo_fail
+	mov  %rax, %r13          # connection fd
+
+echo_loop:
+	mov  $11, %rax           # SYS_RECV
+	mov  %r13, %rdi
+	lea  16(%rsp), %rsi
+	mov  $1024, %rdx
+	syscall
+	cmp  $0, %rax            # 0 at EOF, -1 on error
+	jle  echo_done
+	mov  %rax, %rdx
+	mov  $10, %rax           # SYS_SEND
+	mov  %r13, %rdi
+	lea  16(%rsp), %rsi
+	syscall
+	cmp  $-1, %rax
+	jne  echo_loop
+
+echo_done:
+	mov  $12, %rax           # SYS_CLOSE
+	mov  %r13, %rdi
+	syscall
+	mov  $12, %rax
+	mov  %r12, %rdi
+	syscall
+	mov  $2, %rax            # SYS_EXIT
+	xor  %rdi, %rdi
+	syscall
+	hlt
+
+echo_fail:
+	mov  $2, %rax            # SYS_EXIT
+	mov  $1, %rdi
+	syscall
+	hlt
+
+echo_msg:
+	.ascii "echo: listening on port 7777\n"
+echo_msg_end:
+	.set echo_msg_len, echo_msg_end - echo_msg
+
 .global go_0kernel.userProbePrivilegedStart
 go_0kernel.userProbePrivilegedStart:
 	cli              # Privileged instruction, must #GP in ring 3